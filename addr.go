@@ -0,0 +1,79 @@
+package k2rule
+
+import (
+	"net"
+	"strings"
+)
+
+// MatchAddr matches the host of a network address (e.g. a connection's remote address).
+//
+// Handles *net.TCPAddr, *net.UDPAddr and *net.IPAddr directly; any other address is
+// parsed from its String() form ("host:port", "[v6]:port", bare host). IPv6 zones and
+// trailing dots are stripped before matching. Unix sockets and nil addresses are
+// local and return TargetDirect.
+//
+// Example:
+//
+//	target := k2rule.MatchAddr(conn.RemoteAddr())
+func MatchAddr(a net.Addr) Target {
+	host, ok := addrHost(a)
+	if !ok {
+		return TargetDirect
+	}
+	return Match(host)
+}
+
+// MatchConnRemote matches the remote address of a connection.
+// Returns TargetDirect for a nil connection.
+func MatchConnRemote(c net.Conn) Target {
+	if c == nil {
+		return TargetDirect
+	}
+	return MatchAddr(c.RemoteAddr())
+}
+
+// addrHost extracts the normalized host (IP string or domain) of a network address.
+// Returns false for nil and local (Unix socket) addresses.
+func addrHost(a net.Addr) (string, bool) {
+	switch addr := a.(type) {
+	case nil:
+		return "", false
+	case *net.TCPAddr:
+		if addr == nil || addr.IP == nil {
+			return "", false
+		}
+		return addr.IP.String(), true
+	case *net.UDPAddr:
+		if addr == nil || addr.IP == nil {
+			return "", false
+		}
+		return addr.IP.String(), true
+	case *net.IPAddr:
+		if addr == nil || addr.IP == nil {
+			return "", false
+		}
+		return addr.IP.String(), true
+	case *net.UnixAddr:
+		return "", false
+	}
+
+	return normalizeHost(a.String()), true
+}
+
+// normalizeHost strips the port, IPv6 brackets, IPv6 zone and trailing dot from a host string
+func normalizeHost(s string) string {
+	host := s
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		host = h
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	if i := strings.IndexByte(host, '%'); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i] // IPv6 zone, e.g. fe80::1%eth0
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String() // canonical form (also unmaps ::ffff:1.2.3.4)
+	}
+	return strings.TrimSuffix(host, ".")
+}
@@ -0,0 +1,78 @@
+package k2rule
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// stringAddr is a net.Addr with an arbitrary String() form
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestAddrHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   net.Addr
+		want   string
+		wantOK bool
+	}{
+		{"tcp v4", &net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 443}, "8.8.8.8", true},
+		{"tcp v4-mapped", &net.TCPAddr{IP: net.ParseIP("::ffff:8.8.8.8"), Port: 443}, "8.8.8.8", true},
+		{"udp v6", &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53, Zone: "eth0"}, "2001:db8::1", true},
+		{"ip addr", &net.IPAddr{IP: net.ParseIP("1.1.1.1")}, "1.1.1.1", true},
+		{"host:port", stringAddr("Example.com.:443"), "Example.com", true},
+		{"bracketed v6", stringAddr("[2001:db8::2]:80"), "2001:db8::2", true},
+		{"v6 with zone", stringAddr("[fe80::1%eth0]:80"), "fe80::1", true},
+		{"bare domain", stringAddr("example.com"), "example.com", true},
+		{"nil", nil, "", false},
+		{"nil tcp", (*net.TCPAddr)(nil), "", false},
+		{"unix", &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := addrHost(tt.addr)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("addrHost() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMatchAddr(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if got := MatchAddr(stringAddr("blocked.com:443")); got != TargetReject {
+		t.Errorf("MatchAddr(blocked.com:443) = %v, want REJECT", got)
+	}
+	if got := MatchAddr(&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 22}); got != TargetDirect {
+		t.Errorf("MatchAddr(192.168.1.1:22) = %v, want DIRECT", got)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if got := MatchConnRemote(client); got != TargetDirect {
+		t.Errorf("MatchConnRemote(pipe) = %v, want DIRECT", got)
+	}
+	if got := MatchConnRemote(nil); got != TargetDirect {
+		t.Errorf("MatchConnRemote(nil) = %v, want DIRECT", got)
+	}
+}
@@ -0,0 +1,297 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// AdminAPIVersion is the version of the admin API schemas, served under /v1/.
+// Fields of a version are only ever added, never renamed, removed or retyped;
+// incompatible changes get a new version path.
+const AdminAPIVersion = 1
+
+// AdminHealthV1 is the /v1/health response
+type AdminHealthV1 struct {
+	APIVersion    int                `json:"api_version"`
+	Degraded      bool               `json:"degraded"`
+	RulesLoaded   bool               `json:"rules_loaded"`
+	FailClosed    bool               `json:"fail_closed"`
+	UpdatesPaused bool               `json:"updates_paused"`
+	Problems      []string           `json:"problems"`
+	Components    []AdminComponentV1 `json:"components"`
+}
+
+// AdminComponentV1 is a component of AdminHealthV1
+type AdminComponentV1 struct {
+	Name       string    `json:"name"`
+	Source     string    `json:"source"` // URL (credentials redacted) or local file path
+	Loaded     bool      `json:"loaded"`
+	Generation uint64    `json:"generation"`
+	LastUpdate time.Time `json:"last_update"`
+	BuildTime  time.Time `json:"build_time"`
+	LastError  string    `json:"last_error"`
+	SizeBytes  int64     `json:"size_bytes"`
+	Entries    int       `json:"entries"`
+}
+
+// AdminStatsV1 is the /v1/stats response
+type AdminStatsV1 struct {
+	APIVersion  int                 `json:"api_version"`
+	Generation  uint64              `json:"generation"` // GetGeneration
+	Caches      []AdminCacheV1      `json:"caches"`
+	GeoIP       AdminGeoIPV1        `json:"geoip"`
+	Experiments []AdminExperimentV1 `json:"experiments"`
+	Hooks       []AdminHookV1       `json:"hooks"`
+}
+
+// AdminCacheV1 is a decision cache of AdminStatsV1
+type AdminCacheV1 struct {
+	Name        string `json:"name"`
+	Policy      string `json:"policy"`
+	MaxEntries  int    `json:"max_entries"` // 0 = unbounded
+	Entries     int    `json:"entries"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+// AdminGeoIPV1 are the GeoIP lookup counters of AdminStatsV1
+type AdminGeoIPV1 struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	None         uint64 `json:"none"`
+	NegativeHits uint64 `json:"negative_hits"`
+}
+
+// AdminExperimentV1 is an experiment of AdminStatsV1
+type AdminExperimentV1 struct {
+	Name     string  `json:"name"`
+	Fraction float64 `json:"fraction"`
+	Target   string  `json:"target"`
+	Eligible uint64  `json:"eligible"`
+	Diverted uint64  `json:"diverted"`
+}
+
+// AdminHookV1 is a hook limiter of AdminStatsV1 (see HookStats)
+type AdminHookV1 struct {
+	Name           string `json:"name"`
+	State          string `json:"state"`
+	Allowed        uint64 `json:"allowed"`
+	Limited        uint64 `json:"limited"`
+	ShortCircuited uint64 `json:"short_circuited"`
+	Failures       uint64 `json:"failures"`
+	Trips          uint64 `json:"trips"`
+}
+
+// AdminConfigV1 is the /v1/config response. Credentials are never included and
+// passwords in source URLs are redacted (as in EffectivePolicy).
+type AdminConfigV1 struct {
+	APIVersion   int               `json:"api_version"`
+	Initialized  bool              `json:"initialized"`
+	Global       bool              `json:"global"`
+	GlobalTarget string            `json:"global_target"`
+	Fallback     string            `json:"fallback"` // Rule fallback target ("" = no rules loaded)
+	Sources      map[string]string `json:"sources"`  // Component name → URL or local file path
+	Antiporn     bool              `json:"antiporn"`
+	TmpRules     map[string]string `json:"tmp_rules"` // Input → target
+}
+
+// AdminMatchV1 is the /v1/match response
+type AdminMatchV1 struct {
+	APIVersion int      `json:"api_version"`
+	Input      string   `json:"input"`
+	Target     string   `json:"target"`
+	Reason     string   `json:"reason"`
+	RejectMode string   `json:"reject_mode"` // "" unless Target is REJECT
+	Fallback   bool     `json:"fallback"`
+	Unknown    bool     `json:"unknown"`
+	Country    string   `json:"country"`
+	Category   string   `json:"category"`
+	Keyword    string   `json:"keyword"`
+	Domain     string   `json:"domain"`
+	Profile    string   `json:"profile"`
+	Experiment string   `json:"experiment"`
+	Generation uint64   `json:"generation"`
+	DNS        []string `json:"dns"` // DNS hint names (see DNSHint)
+}
+
+// NewAdminHandler returns a read-only http.Handler for debug/admin servers, serving the
+// engine state as JSON with stable, versioned schemas (see AdminAPIVersion):
+//
+//	GET /v1/health          AdminHealthV1
+//	GET /v1/stats           AdminStatsV1
+//	GET /v1/config          AdminConfigV1
+//	GET /v1/match?input=…   AdminMatchV1 (not counted by hit stats, telemetry or events)
+//
+// The handler has no authentication; serve it on a loopback address or behind the
+// embedding application's own access control.
+//
+// Example:
+//
+//	go http.ListenAndServe("127.0.0.1:9090", k2rule.NewAdminHandler())
+func NewAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", adminEndpoint(func(*http.Request) (any, int) { return adminHealth(), http.StatusOK }))
+	mux.HandleFunc("/v1/stats", adminEndpoint(func(*http.Request) (any, int) { return adminStats(), http.StatusOK }))
+	mux.HandleFunc("/v1/config", adminEndpoint(func(*http.Request) (any, int) { return adminConfig(), http.StatusOK }))
+	mux.HandleFunc("/v1/match", adminEndpoint(adminMatch))
+	return mux
+}
+
+// adminError is the body of admin API errors
+type adminError struct {
+	APIVersion int    `json:"api_version"`
+	Error      string `json:"error"`
+}
+
+// adminEndpoint serves the JSON response of a read-only endpoint
+func adminEndpoint(handle func(r *http.Request) (any, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, status := any(adminError{APIVersion: AdminAPIVersion, Error: "method not allowed"}), http.StatusMethodNotAllowed
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			body, status = handle(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(body)
+		}
+	}
+}
+
+// adminHealth builds the /v1/health response
+func adminHealth() AdminHealthV1 {
+	health := Health()
+	resp := AdminHealthV1{
+		APIVersion:    AdminAPIVersion,
+		Degraded:      health.Degraded,
+		RulesLoaded:   health.RulesLoaded,
+		FailClosed:    health.FailClosed,
+		UpdatesPaused: health.UpdatesPaused,
+		Problems:      append([]string{}, health.Problems...),
+		Components:    []AdminComponentV1{},
+	}
+	for _, c := range ComponentStatus() {
+		resp.Components = append(resp.Components, AdminComponentV1{
+			Name:       c.Name,
+			Source:     redactURL(c.Source),
+			Loaded:     c.Loaded,
+			Generation: c.Generation,
+			LastUpdate: c.LastUpdate,
+			BuildTime:  c.BuildTime,
+			LastError:  c.LastError,
+			SizeBytes:  c.Size,
+			Entries:    c.Entries,
+		})
+	}
+	return resp
+}
+
+// adminStats builds the /v1/stats response
+func adminStats() AdminStatsV1 {
+	geoIP := GeoIPStats()
+	resp := AdminStatsV1{
+		APIVersion:  AdminAPIVersion,
+		Generation:  GetGeneration(),
+		Caches:      []AdminCacheV1{},
+		GeoIP:       AdminGeoIPV1{Hits: geoIP.Hits, Misses: geoIP.Misses, None: geoIP.None, NegativeHits: geoIP.NegativeHits},
+		Experiments: []AdminExperimentV1{},
+		Hooks:       []AdminHookV1{},
+	}
+	for _, c := range CacheStats() {
+		resp.Caches = append(resp.Caches, AdminCacheV1{
+			Name:        c.Name,
+			Policy:      string(c.Policy),
+			MaxEntries:  c.MaxEntries,
+			Entries:     c.Entries,
+			Hits:        c.Hits,
+			Misses:      c.Misses,
+			Evictions:   c.Evictions,
+			Expirations: c.Expirations,
+		})
+	}
+	for _, e := range GetExperimentStats() {
+		resp.Experiments = append(resp.Experiments, AdminExperimentV1{
+			Name:     e.Name,
+			Fraction: e.Fraction,
+			Target:   e.Target.String(),
+			Eligible: e.Eligible,
+			Diverted: e.Diverted,
+		})
+	}
+	for _, h := range HookStats() {
+		resp.Hooks = append(resp.Hooks, AdminHookV1{
+			Name:           h.Name,
+			State:          h.State,
+			Allowed:        h.Allowed,
+			Limited:        h.Limited,
+			ShortCircuited: h.ShortCircuited,
+			Failures:       h.Failures,
+			Trips:          h.Trips,
+		})
+	}
+	return resp
+}
+
+// adminConfig builds the /v1/config response
+func adminConfig() AdminConfigV1 {
+	defaultEngine.mu.RLock()
+	initialized := defaultEngine.config != nil
+	defaultEngine.mu.RUnlock()
+
+	policy := currentPolicy()
+	resp := AdminConfigV1{
+		APIVersion:   AdminAPIVersion,
+		Initialized:  initialized,
+		Global:       policy.Config.IsGlobal,
+		GlobalTarget: policy.Config.GlobalTarget.String(),
+		Sources:      map[string]string{},
+		Antiporn:     policy.Config.Antiporn,
+		TmpRules:     map[string]string{},
+	}
+	if !initialized {
+		resp.GlobalTarget = ""
+	}
+	if policy.Fallback != nil {
+		resp.Fallback = policy.Fallback.String()
+	}
+	for _, c := range policy.Components {
+		resp.Sources[c.Name] = c.Source
+	}
+	for input, target := range policy.TmpRules {
+		resp.TmpRules[input] = target.String()
+	}
+	return resp
+}
+
+// adminMatch builds the /v1/match response
+func adminMatch(r *http.Request) (any, int) {
+	input := r.URL.Query().Get("input")
+	if input == "" {
+		return adminError{APIVersion: AdminAPIVersion, Error: "missing input parameter"}, http.StatusBadRequest
+	}
+	result := defaultEngine.query(input)
+	resp := AdminMatchV1{
+		APIVersion: AdminAPIVersion,
+		Input:      input,
+		Target:     result.Target.String(),
+		Reason:     string(result.Reason),
+		Fallback:   result.Fallback,
+		Unknown:    result.Unknown,
+		Country:    result.Country,
+		Category:   result.Category,
+		Keyword:    result.Keyword,
+		Domain:     result.Domain,
+		Profile:    result.Profile,
+		Experiment: result.Experiment,
+		Generation: result.Generation,
+		DNS:        append([]string{}, result.DNS.Names()...),
+	}
+	if result.Target == TargetReject {
+		resp.RejectMode = result.RejectMode.String()
+	}
+	return resp, http.StatusOK
+}
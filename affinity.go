@@ -0,0 +1,98 @@
+package k2rule
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// affinitySweepInterval is the number of new bindings between sweeps of expired bindings
+const affinitySweepInterval = 1024
+
+// globalAffinity maps resolved IPs to the domain they were resolved for (BindDomainIPs),
+// so IP-level decisions inherit the domain's target.
+var globalAffinity ipAffinity
+
+// ipAffinity maps IP string → *affinityEntry
+type ipAffinity struct {
+	entries sync.Map
+	puts    atomic.Uint64
+}
+
+// affinityEntry is a domain binding that expires with the DNS answer
+type affinityEntry struct {
+	domain  string
+	expires int64 // UnixNano
+}
+
+// BindDomainIPs records that domain resolved to ips for ttl (usually the DNS answer TTL).
+// Until the binding expires, Match on one of the IPs returns the domain's decision
+// (MatchResult.Domain is set), so CDN domains with rotating IPs get the same target at
+// packet level as by name. TmpRules for the IP and LAN bypass still take precedence.
+// An IP shared by several domains follows the most recent binding. ttl <= 0, and a
+// domain that is an IP address or not a valid domain name, are ignored.
+//
+// Example:
+//
+//	addrs, _ := net.LookupIP("video.example.com")
+//	k2rule.BindDomainIPs("video.example.com", addrs, 5*time.Minute)
+func BindDomainIPs(domain string, ips []net.IP, ttl time.Duration) {
+	domain = normalizeOverlayDomain(domain)
+	if ttl <= 0 || net.ParseIP(domain) != nil || !isValidDomain(domain) {
+		return
+	}
+	expires := time.Now().Add(ttl).UnixNano()
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		globalAffinity.entries.Store(ip.String(), &affinityEntry{domain: domain, expires: expires})
+		if globalAffinity.puts.Add(1)%affinitySweepInterval == 0 {
+			globalAffinity.sweep()
+		}
+	}
+}
+
+// UnbindDomainIPs removes the bindings of ips.
+func UnbindDomainIPs(ips []net.IP) {
+	for _, ip := range ips {
+		if ip != nil {
+			globalAffinity.entries.Delete(ip.String())
+		}
+	}
+}
+
+// ClearDomainIPs removes all IP bindings.
+func ClearDomainIPs() {
+	globalAffinity.entries.Range(func(key, _ any) bool {
+		globalAffinity.entries.Delete(key)
+		return true
+	})
+}
+
+// lookup returns the domain bound to ip, if not expired
+func (a *ipAffinity) lookup(ip net.IP) (string, bool) {
+	key := ip.String()
+	v, ok := a.entries.Load(key)
+	if !ok {
+		return "", false
+	}
+	e := v.(*affinityEntry)
+	if e.expires <= time.Now().UnixNano() {
+		a.entries.CompareAndDelete(key, e)
+		return "", false
+	}
+	return e.domain, true
+}
+
+// sweep deletes expired bindings
+func (a *ipAffinity) sweep() {
+	now := time.Now().UnixNano()
+	a.entries.Range(func(key, value any) bool {
+		if e := value.(*affinityEntry); e.expires <= now {
+			a.entries.CompareAndDelete(key, e)
+		}
+		return true
+	})
+}
@@ -0,0 +1,105 @@
+package k2rule
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBindDomainIPs(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, path, buildTestPornK2R(t, []string{"video.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if got := Match("203.0.113.7"); got != TargetDirect {
+		t.Fatalf("Match(unbound IP) = %v, want DIRECT", got)
+	}
+
+	BindDomainIPs("Cdn.Video.com", []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::7")}, time.Hour)
+	for _, ip := range []string{"203.0.113.7", "2001:db8::7"} {
+		result := MatchVerbose(ip)
+		if result.Target != TargetReject || result.Domain != "cdn.video.com" {
+			t.Errorf("MatchVerbose(%s) = %+v, want REJECT via cdn.video.com", ip, result)
+		}
+	}
+	if got := Match("203.0.113.8"); got != TargetDirect {
+		t.Errorf("Match(other IP) = %v, want DIRECT", got)
+	}
+
+	// TmpRule for the IP takes precedence over the binding
+	SetTmpRule("203.0.113.7", TargetProxy)
+	if got := Match("203.0.113.7"); got != TargetProxy {
+		t.Errorf("Match with TmpRule = %v, want PROXY", got)
+	}
+	ClearTmpRules()
+
+	// LAN bypass takes precedence over the binding
+	BindDomainIPs("video.com", []net.IP{net.ParseIP("192.168.1.10")}, time.Hour)
+	if got := Match("192.168.1.10"); got != TargetDirect {
+		t.Errorf("Match(LAN IP) = %v, want DIRECT", got)
+	}
+
+	UnbindDomainIPs([]net.IP{net.ParseIP("203.0.113.7")})
+	if got := Match("203.0.113.7"); got != TargetDirect {
+		t.Errorf("Match after UnbindDomainIPs = %v, want DIRECT", got)
+	}
+}
+
+func TestBindDomainIPs_Expiry(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	SetTmpRule("blocked.com", TargetReject)
+	ip := net.ParseIP("198.51.100.1")
+
+	BindDomainIPs("blocked.com", []net.IP{ip}, 0)
+	if _, ok := globalAffinity.lookup(ip); ok {
+		t.Error("binding stored with ttl 0")
+	}
+
+	BindDomainIPs("blocked.com", []net.IP{ip}, 20*time.Millisecond)
+	if got := Match("198.51.100.1"); got != TargetReject {
+		t.Errorf("Match(bound IP) = %v, want REJECT", got)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if got := Match("198.51.100.1"); got != TargetDirect {
+		t.Errorf("Match(expired binding) = %v, want DIRECT", got)
+	}
+	if _, ok := globalAffinity.entries.Load(ip.String()); ok {
+		t.Error("expired binding not deleted on lookup")
+	}
+}
+
+func TestBindDomainIPs_IPLiteral(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	ip := net.ParseIP("8.8.8.8")
+	BindDomainIPs("8.8.8.8", []net.IP{ip}, time.Hour)
+	BindDomainIPs("not a domain", []net.IP{ip}, time.Hour)
+	if _, ok := globalAffinity.lookup(ip); ok {
+		t.Error("binding stored for an invalid domain")
+	}
+	if result := MatchVerbose("8.8.8.8"); result.Domain != "" {
+		t.Errorf("MatchVerbose(8.8.8.8) = %+v, want no bound domain", result)
+	}
+
+	// A binding to an IP literal is matched as a domain, never recursing into IP steps
+	globalAffinity.entries.Store(ip.String(), &affinityEntry{domain: "8.8.8.8", expires: time.Now().Add(time.Hour).UnixNano()})
+	if result := MatchVerbose("8.8.8.8"); result.Domain != "8.8.8.8" {
+		t.Errorf("MatchVerbose(8.8.8.8) = %+v, want the bound domain", result)
+	}
+}
@@ -0,0 +1,214 @@
+package k2rule
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultBlockPageTemplate is the html/template of the block page (see BlockPageData)
+const DefaultBlockPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Blocked</title>
+<style>body{font-family:system-ui,sans-serif;max-width:36em;margin:4em auto;padding:0 1em;color:#222}code{background:#eee;padding:.1em .3em}</style>
+</head>
+<body>
+<h1>This site is blocked</h1>
+{{if .Domain}}<p><code>{{.Domain}}</code></p>{{end}}
+<p>{{.Message}}</p>
+{{if .AppealURL}}<p><a href="{{.AppealURL}}">Request access</a></p>{{end}}
+</body>
+</html>
+`
+
+// defaultBlockMessages are the block page messages of BlockPageConfig.Messages
+var defaultBlockMessages = map[ReasonCode]string{
+	ReasonPorn:       "This site was identified as adult content.",
+	ReasonCategory:   "This site belongs to a blocked category.",
+	ReasonUserRule:   "This site is blocked by a rule of your administrator.",
+	ReasonNetwork:    "This site is blocked on the current network.",
+	ReasonGeo:        "Sites hosted in this region are blocked.",
+	ReasonRule:       "This site is blocked by the routing rules.",
+	ReasonHosts:      "This site is blocked by the hosts file.",
+	ReasonGlobal:     "All traffic is blocked.",
+	ReasonFailClosed: "Traffic is blocked until the routing rules are loaded.",
+	ReasonRisk:       "This site looks like a phishing site.",
+}
+
+// defaultBlockMessage is shown for reasons without a message
+const defaultBlockMessage = "This site is blocked."
+
+// BlockPageConfig customizes a BlockPage
+type BlockPageConfig struct {
+	// Template is an html/template executed with BlockPageData ("" = DefaultBlockPageTemplate)
+	Template string `json:"template,omitempty"`
+
+	// Messages overrides the message shown per reason code (missing reasons keep the
+	// built-in English messages), e.g. for localization
+	Messages map[ReasonCode]string `json:"messages,omitempty"`
+
+	// AppealURL is the link to request access ("" = none). The blocked domain, reason
+	// and category are appended as the query parameters domain, reason and category.
+	AppealURL string `json:"appeal_url,omitempty"`
+}
+
+// BlockPageData is the data the block page template is executed with
+type BlockPageData struct {
+	Domain    string     // Blocked domain or IP ("" when unknown)
+	Reason    ReasonCode // Reason code of the decision (MatchResult.Reason)
+	Category  string     // Category of a category policy decision (MatchResult.Category)
+	Message   string     // Message of the reason (BlockPageConfig.Messages)
+	AppealURL string     // AppealURL with the query parameters ("" = none)
+}
+
+// BlockPage is an http.Handler serving the block page, for proxy and DNS layers that
+// send REJECTed HTTP traffic to it: either redirected to a BlockPageURL, or with the
+// blocked domain resolved to the block page server (the domain is then taken from the
+// Host header). Without a reason parameter the domain is matched with MatchVerbose.
+// Pages are served with status 403 Forbidden.
+type BlockPage struct {
+	tmpl     *template.Template
+	messages map[ReasonCode]string
+	appeal   *url.URL
+}
+
+// NewBlockPage creates a block page. Returns an error for an invalid template or
+// AppealURL.
+func NewBlockPage(config BlockPageConfig) (*BlockPage, error) {
+	text := config.Template
+	if text == "" {
+		text = DefaultBlockPageTemplate
+	}
+	tmpl, err := template.New("blockpage").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block page template: %w", err)
+	}
+	p := &BlockPage{tmpl: tmpl, messages: make(map[ReasonCode]string, len(defaultBlockMessages)+len(config.Messages))}
+	for reason, message := range defaultBlockMessages {
+		p.messages[reason] = message
+	}
+	for reason, message := range config.Messages {
+		p.messages[reason] = message
+	}
+	if config.AppealURL != "" {
+		if p.appeal, err = url.Parse(config.AppealURL); err != nil || p.appeal.Host == "" {
+			return nil, fmt.Errorf("invalid block page AppealURL: %s", config.AppealURL)
+		}
+	}
+	return p, nil
+}
+
+// ServeHTTP renders the block page for the request
+func (p *BlockPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	domain := query.Get("domain")
+	if domain == "" {
+		domain = r.Host
+		if host, _, err := net.SplitHostPort(domain); err == nil {
+			domain = host
+		}
+	}
+	reason, category := ReasonCode(query.Get("reason")), query.Get("category")
+	if reason == "" && domain != "" {
+		result := MatchVerbose(domain)
+		reason, category = result.Reason, result.Category
+	}
+
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, p.data(domain, reason, category)); err != nil {
+		http.Error(w, "block page template failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	if r.Method != http.MethodHead {
+		buf.WriteTo(w)
+	}
+}
+
+// data returns the template data of a blocked domain
+func (p *BlockPage) data(domain string, reason ReasonCode, category string) BlockPageData {
+	data := BlockPageData{Domain: domain, Reason: reason, Category: category, Message: defaultBlockMessage}
+	if message, ok := p.messages[reason]; ok {
+		data.Message = message
+	}
+	if p.appeal != nil {
+		appeal := *p.appeal
+		query := appeal.Query()
+		query.Set("domain", domain)
+		query.Set("reason", string(reason))
+		if category != "" {
+			query.Set("category", category)
+		}
+		appeal.RawQuery = query.Encode()
+		data.AppealURL = appeal.String()
+	}
+	return data
+}
+
+// BlockPageURL returns the URL of the block page at base (e.g. "http://127.0.0.1:8099/")
+// for a blocked input and its decision, for proxies redirecting REJECTed HTTP requests.
+//
+// Example:
+//
+//	if r := k2rule.MatchVerbose(host); r.Target == k2rule.TargetReject {
+//	    http.Redirect(w, req, k2rule.BlockPageURL(blockPageBase, host, r), http.StatusFound)
+//	}
+func BlockPageURL(base, input string, result MatchResult) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	query := u.Query()
+	query.Set("domain", input)
+	if result.Reason != "" {
+		query.Set("reason", string(result.Reason))
+	}
+	if result.Category != "" {
+		query.Set("category", result.Category)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// BlockPageServer is a running block page server (see StartBlockPageServer)
+type BlockPageServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// StartBlockPageServer serves page on addr (e.g. "127.0.0.1:8099"; port 0 picks a
+// free port) in the background until Close
+func StartBlockPageServer(addr string, page *BlockPage) (*BlockPageServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the block page: %w", err)
+	}
+	s := &BlockPageServer{
+		server:   &http.Server{Handler: page, ReadHeaderTimeout: 10 * time.Second},
+		listener: listener,
+	}
+	safeGo("blockpage", func() { s.server.Serve(listener) })
+	return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *BlockPageServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server, waiting up to 5 seconds for requests in progress
+func (s *BlockPageServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,84 @@
+package k2rule
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBlockPage_ServeHTTP(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	page, err := NewBlockPage(BlockPageConfig{
+		Messages:  map[ReasonCode]string{ReasonCategory: "Blocked by category <policy>"},
+		AppealURL: "https://appeal.example/request?lang=en",
+	})
+	if err != nil {
+		t.Fatalf("NewBlockPage failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	page.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?domain=ads.example&reason=category&category=ads", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusForbidden || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("status %d, Content-Type %q; want 403 HTML", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"ads.example",
+		"Blocked by category &lt;policy&gt;", // escaped by html/template
+		`href="https://appeal.example/request?category=ads&amp;domain=ads.example&amp;lang=en&amp;reason=category"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("block page lacks %q:\n%s", want, body)
+		}
+	}
+
+	// Domain from the Host header, reason from MatchVerbose
+	SetTmpRule("blocked.example", TargetReject)
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "blocked.example:80"
+	page.ServeHTTP(rec, req)
+	if body := rec.Body.String(); !strings.Contains(body, "blocked.example") || !strings.Contains(body, defaultBlockMessages[ReasonUserRule]) {
+		t.Errorf("block page for Host blocked.example:\n%s", body)
+	}
+
+	if _, err := NewBlockPage(BlockPageConfig{Template: "{{.Missing"}); err == nil {
+		t.Error("NewBlockPage accepted an invalid template")
+	}
+	if _, err := NewBlockPage(BlockPageConfig{AppealURL: "not a url"}); err == nil {
+		t.Error("NewBlockPage accepted an invalid AppealURL")
+	}
+}
+
+func TestBlockPageURL(t *testing.T) {
+	got := BlockPageURL("http://127.0.0.1:8099/block", "porn.example", MatchResult{Target: TargetReject, Reason: ReasonPorn, Category: CategoryPorn})
+	if want := "http://127.0.0.1:8099/block?category=porn&domain=porn.example&reason=porn"; got != want {
+		t.Errorf("BlockPageURL = %s, want %s", got, want)
+	}
+}
+
+func TestStartBlockPageServer(t *testing.T) {
+	page, err := NewBlockPage(BlockPageConfig{Template: "{{.Reason}}: {{.Message}}"})
+	if err != nil {
+		t.Fatalf("NewBlockPage failed: %v", err)
+	}
+	server, err := StartBlockPageServer("127.0.0.1:0", page)
+	if err != nil {
+		t.Fatalf("StartBlockPageServer failed: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr() + "/?domain=x.example&reason=geo")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || string(body) != "geo: "+defaultBlockMessages[ReasonGeo] {
+		t.Errorf("GET = %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,489 @@
+package k2rule
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/filelock"
+)
+
+// BundleManifestName is the name of the manifest member of a bundle archive
+const BundleManifestName = "manifest.json"
+
+// BundleManifest lists the member files of a bundle archive per component.
+// Empty entries mean the bundle does not contain that component.
+//
+// A bundle is a tar.gz archive containing manifest.json and the listed files:
+//
+//	{"rules": "cn_whitelist.k2r.gz", "geoip": "GeoLite2-Country.mmdb.gz", "porn": "porn_domains.k2r.gz"}
+//
+// Rule and porn files are K2RULEV3 (.k2r.gz); the GeoIP file is a MaxMind .mmdb,
+// optionally gzip-compressed (.gz suffix).
+type BundleManifest struct {
+	Rules string `json:"rules,omitempty"`
+	GeoIP string `json:"geoip,omitempty"`
+	Porn  string `json:"porn,omitempty"`
+}
+
+// bundleMemberFiles are the cache file names of extracted components
+var bundleMemberFiles = map[string]string{
+	ComponentRules: "rules.k2r.gz",
+	ComponentGeoIP: "geoip.mmdb",
+	ComponentPorn:  "porn.k2r.gz",
+}
+
+// member returns the member file of a component ("" if not in the bundle)
+func (b BundleManifest) member(component string) string {
+	switch component {
+	case ComponentRules:
+		return b.Rules
+	case ComponentGeoIP:
+		return b.GeoIP
+	case ComponentPorn:
+		return b.Porn
+	}
+	return ""
+}
+
+// setMember sets the member file of a component
+func (b *BundleManifest) setMember(component, name string) {
+	switch component {
+	case ComponentRules:
+		b.Rules = name
+	case ComponentGeoIP:
+		b.GeoIP = name
+	case ComponentPorn:
+		b.Porn = name
+	}
+}
+
+// BundleManager downloads a bundle archive (Config.BundleURL) and hot-loads each
+// component into the rule, GeoIP and porn managers. The whole bundle shares one URL
+// and one ETag; it is checked for updates every 6 hours.
+type BundleManager struct {
+	url      string
+	cacheDir string
+	dl       *downloader // shared HTTP downloader (auth, timeouts)
+
+	componentSet // Components loaded from the bundle
+
+	// Update metadata
+	mu         sync.RWMutex
+	etag       string
+	lastUpdate time.Time
+	lastErr    error
+	size       int64  // Size of the bundle archive
+	generation uint64 // Number of successful loads
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	flight     flightGroup // deduplicates concurrent downloads
+
+	managerBase // ManagerOption settings (interval, logger, validator)
+}
+
+// NewBundleManager creates a bundle manager. Components are attached with the
+// rules/geoIP/porn fields before Init.
+func NewBundleManager(url, cacheDir string, opts ...ManagerOption) *BundleManager {
+	m := &BundleManager{
+		url:    url,
+		dl:     newDownloader(180 * time.Second),
+		stopCh: make(chan struct{}),
+	}
+	m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dl, opts)
+	return m
+}
+
+// Init initializes the manager: loads the extracted bundle from cache → downloads if needed → starts auto-update
+func (m *BundleManager) Init() error {
+	// Read-only cache: load the members extracted there, never download
+	if m.readOnly {
+		if err := m.loadMembers(m.getCachePath()); err != nil {
+			m.proxyUntilLoaded()
+			m.logger().Warn("bundle cache not loaded, download skipped, cache is read-only", "error", err)
+			return nil
+		}
+		m.logger().Info("bundle loaded from cache")
+		return nil
+	}
+
+	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// 1. Check cache (the archive is kept next to its extracted members)
+	if _, err := os.Stat(m.getCachePath()); err == nil {
+		if err := m.extractAndLoad(m.getCachePath()); err == nil {
+			m.logger().Info("bundle loaded from cache")
+			safeGo("bundle", m.startAutoUpdate)
+			return nil
+		}
+		m.logger().Warn("bundle cache corrupted, will re-download")
+	}
+
+	// 2. Download in background (non-blocking); rules proxy everything until loaded
+	m.proxyUntilLoaded()
+	m.logger().Info("bundle cache not found, downloading in background")
+	safeGo("bundle", func() {
+		retryForever("bundle", m.stopCh, func() error { return m.downloadAndLoad(false) })
+		m.startAutoUpdate()
+	})
+
+	return nil
+}
+
+// Stop stops the auto-update background task
+func (m *BundleManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// Update manually triggers a bundle update check.
+// Concurrent calls share one download and its result.
+func (m *BundleManager) Update() error {
+	if m.skipUpdate("bundle") {
+		return nil
+	}
+	return m.downloadAndLoad(true)
+}
+
+// downloadAndLoad downloads the bundle and loads its components
+func (m *BundleManager) downloadAndLoad(useETag bool) error {
+	return m.flight.do("download", func() (err error) {
+		defer func() { m.setLastError(err) }()
+
+		var currentETag string
+		if useETag {
+			currentETag = m.GetETag()
+		}
+
+		m.logger().Debug("downloading bundle", "url", redactURL(m.url))
+
+		etag, modified, err := m.dl.fetchValidated(m.url, currentETag, m.getCachePath(), false, m.validator)
+		if err != nil {
+			return err
+		}
+		if !modified {
+			m.logger().Debug("bundle not modified")
+			return nil
+		}
+
+		if err := m.extractAndLoad(m.getCachePath()); err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		m.etag = etag
+		m.lastUpdate = time.Now()
+		m.mu.Unlock()
+
+		m.logger().Info("bundle downloaded and loaded")
+		return nil
+	})
+}
+
+// extractAndLoad extracts the components of the bundle at path and hot-loads them
+func (m *BundleManager) extractAndLoad(path string) error {
+	if _, err := m.extract(path); err != nil {
+		return err
+	}
+	return m.loadMembers(path)
+}
+
+// loadMembers hot-loads the extracted members of the bundle at path
+func (m *BundleManager) loadMembers(path string) error {
+	for _, c := range []string{ComponentRules, ComponentGeoIP, ComponentPorn} {
+		if !m.has(c) {
+			continue
+		}
+		if err := m.load(c, m.getMemberPath(bundleMemberFiles[c])); err != nil {
+			return fmt.Errorf("failed to load bundle %s: %w", c, err)
+		}
+	}
+
+	var size int64
+	if stat, err := os.Stat(path); err == nil {
+		size = stat.Size()
+	}
+	m.mu.Lock()
+	m.size = size
+	m.generation++
+	m.mu.Unlock()
+	return nil
+}
+
+// extract writes the members required by the attached components to the cache
+// directory and returns their paths (manifest member names are never used as paths)
+func (m *BundleManager) extract(path string) (BundleManifest, error) {
+	manifest, err := readBundleManifest(path)
+	if err != nil {
+		return BundleManifest{}, err
+	}
+
+	// member name → destination path (and whether to gunzip)
+	type member struct {
+		dest   string
+		gunzip bool
+	}
+	members := make(map[string]member)
+	add := func(name string, mb member) error {
+		if _, ok := members[name]; ok {
+			return fmt.Errorf("bundle member %s listed twice", name)
+		}
+		members[name] = mb
+		return nil
+	}
+	var paths BundleManifest
+	for _, c := range []string{ComponentRules, ComponentGeoIP, ComponentPorn} {
+		if !m.has(c) {
+			continue
+		}
+		name := manifest.member(c)
+		if name == "" {
+			return BundleManifest{}, fmt.Errorf("bundle has no %s database", c)
+		}
+		dest := m.getMemberPath(bundleMemberFiles[c])
+		paths.setMember(c, dest)
+		// GeoIP is opened as a plain .mmdb; K2RULEV3 files are loaded gzip-compressed
+		gunzip := c == ComponentGeoIP && strings.HasSuffix(name, ".gz")
+		if err := add(name, member{dest: dest, gunzip: gunzip}); err != nil {
+			return BundleManifest{}, err
+		}
+	}
+
+	err = readTarGz(path, func(name string, r io.Reader) error {
+		mb, ok := members[name]
+		if !ok {
+			return nil
+		}
+		delete(members, name)
+		if mb.gunzip {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return fmt.Errorf("bundle member %s: %w", name, err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		return writeFileAtomic(mb.dest, r)
+	})
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	for name := range members {
+		return BundleManifest{}, fmt.Errorf("bundle member %s not found", name)
+	}
+	return paths, nil
+}
+
+// readBundleManifest reads manifest.json from a bundle archive
+func readBundleManifest(path string) (BundleManifest, error) {
+	var manifest BundleManifest
+	found := false
+	err := readTarGz(path, func(name string, r io.Reader) error {
+		if name != BundleManifestName {
+			return nil
+		}
+		found = true
+		if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+			return fmt.Errorf("invalid bundle manifest: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	if !found {
+		return BundleManifest{}, fmt.Errorf("bundle has no %s", BundleManifestName)
+	}
+	return manifest, nil
+}
+
+// readTarGz calls fn for every regular file in a tar.gz archive (bundles, MaxMind
+// database archives)
+func readTarGz(path string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid tar.gz archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar.gz archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(strings.TrimPrefix(hdr.Name, "./"), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFileAtomic writes r to path via a temporary file and rename, holding the
+// cross-process lock on path+".lock"
+func writeFileAtomic(path string, r io.Reader) error {
+	lock, err := filelock.Acquire(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = io.Copy(tmpFile, r)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// startAutoUpdate runs background auto-update (every 6 hours)
+func (m *BundleManager) startAutoUpdate() {
+	m.autoUpdate(ComponentBundle, 6*time.Hour, m.stopCh, m.Update)
+}
+
+// getCachePath returns the bundle archive cache path (based on URL hash)
+func (m *BundleManager) getCachePath() string {
+	hash := sha256.Sum256([]byte(m.url))
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x.bundle.tar.gz", hash[:8]))
+}
+
+// getMemberPath returns the path of an extracted component (based on URL hash)
+func (m *BundleManager) getMemberPath(name string) string {
+	hash := sha256.Sum256([]byte(m.url))
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x.bundle.%s", hash[:8], name))
+}
+
+// GetETag returns the current ETag
+func (m *BundleManager) GetETag() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.etag
+}
+
+// GetLastUpdate returns the last update time
+func (m *BundleManager) GetLastUpdate() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastUpdate
+}
+
+// GetLastError returns the error of the most recent download attempt (nil after success)
+func (m *BundleManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Status returns a snapshot of the bundle state
+func (m *BundleManager) Status() ComponentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return ComponentInfo{
+		Name:       ComponentBundle,
+		Source:     m.url,
+		Loaded:     m.generation > 0,
+		Generation: m.generation,
+		LastUpdate: m.lastUpdate,
+		LastError:  errorString(m.lastErr),
+		Size:       m.size,
+		ReadOnly:   m.readOnly,
+	}
+}
+
+// setLastError records the outcome of a download attempt
+func (m *BundleManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
+
+// componentSet is the set of component managers fed by a combined source
+// (BundleManager, ManifestManager) instead of their own URLs
+type componentSet struct {
+	rules *RemoteRuleManager // nil = not taken from this source
+	geoIP *GeoIPManager
+	porn  *PornRemoteManager
+}
+
+// empty reports whether no component is attached
+func (s *componentSet) empty() bool {
+	return s.rules == nil && s.geoIP == nil && s.porn == nil
+}
+
+// has reports whether the named component is attached
+func (s *componentSet) has(component string) bool {
+	switch component {
+	case ComponentRules:
+		return s.rules != nil
+	case ComponentGeoIP:
+		return s.geoIP != nil
+	case ComponentPorn:
+		return s.porn != nil
+	}
+	return false
+}
+
+// load hot-loads the named component from path (.k2r.gz for rules/porn, .mmdb for GeoIP)
+func (s *componentSet) load(component, path string) error {
+	var loaded Component
+	switch component {
+	case ComponentRules:
+		if err := s.rules.reader.Load(path); err != nil {
+			return err
+		}
+		s.rules.fallback.Store(uint32(s.rules.reader.Fallback()))
+		loaded = s.rules
+	case ComponentGeoIP:
+		if err := s.geoIP.loadDatabase(path); err != nil {
+			return err
+		}
+		loaded = s.geoIP
+	case ComponentPorn:
+		if err := s.porn.loadDatabase(path); err != nil {
+			return err
+		}
+		loaded = s.porn
+	default:
+		return fmt.Errorf("unknown component %q", component)
+	}
+	publishReload(loaded)
+	return nil
+}
+
+// proxyUntilLoaded makes the rules proxy all traffic until the first load (see RemoteRuleManager.Init)
+func (s *componentSet) proxyUntilLoaded() {
+	if s.rules != nil {
+		s.rules.fallback.Store(uint32(TargetProxy))
+	}
+}
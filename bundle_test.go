@@ -0,0 +1,151 @@
+package k2rule
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// buildTestBundle returns a tar.gz archive with the given members
+func buildTestBundle(t *testing.T, members map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range members {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader failed: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close failed: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleManager_DownloadAndLoad(t *testing.T) {
+	bundle := buildTestBundle(t, map[string][]byte{
+		"manifest.json":    []byte(`{"rules": "rules.k2r.gz", "porn": "porn.k2r.gz"}`),
+		"rules.k2r.gz":     gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})),
+		"porn.k2r.gz":      gzipBytes(t, buildTestPornK2R(t, []string{"adult-example.com"})),
+		"./unrelated.bin":  []byte("ignored"),
+		"../../etc/passwd": []byte("never written"),
+	})
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"b1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"b1"`)
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	url := server.URL + "/bundle.tar.gz"
+	m := NewBundleManager(url, cacheDir)
+	m.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	m.porn = NewPornRemoteManager(url, cacheDir)
+	defer m.rules.Close()
+	defer m.porn.Stop()
+
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if got := m.rules.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("rules matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if !m.porn.IsPorn("adult-example.com") {
+		t.Error("porn IsPorn(adult-example.com) = false, want true")
+	}
+	status := m.Status()
+	if status.Name != ComponentBundle || !status.Loaded || status.Generation != 1 || m.GetETag() != `"b1"` {
+		t.Errorf("Status() = %+v, etag %q", status, m.GetETag())
+	}
+
+	// Single ETag for the whole bundle
+	if err := m.Update(); err != nil {
+		t.Fatalf("second Update() failed: %v", err)
+	}
+	if m.Status().Generation != 1 {
+		t.Error("not-modified bundle was reloaded")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("server received %d requests, want 2", n)
+	}
+
+	// A new manager loads the cached bundle without downloading
+	cached := NewBundleManager(url, cacheDir)
+	cached.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer cached.rules.Close()
+	if err := cached.Init(); err != nil {
+		t.Fatalf("Init() from cache failed: %v", err)
+	}
+	defer cached.Stop()
+	if got := cached.rules.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("cached matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Init() from cache downloaded (requests = %d)", n)
+	}
+}
+
+func TestBundleManager_InvalidBundles(t *testing.T) {
+	rules := gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"}))
+	tests := []struct {
+		name    string
+		members map[string][]byte
+	}{
+		{"no manifest", map[string][]byte{"rules.k2r.gz": rules}},
+		{"component missing from manifest", map[string][]byte{"manifest.json": []byte(`{"porn": "porn.k2r.gz"}`)}},
+		{"member not found", map[string][]byte{"manifest.json": []byte(`{"rules": "rules.k2r.gz"}`)}},
+		{"invalid manifest", map[string][]byte{"manifest.json": []byte(`{`), "rules.k2r.gz": rules}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := buildTestBundle(t, tt.members)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			cacheDir := t.TempDir()
+			m := NewBundleManager(server.URL, cacheDir)
+			m.rules = NewRemoteRuleManager(server.URL, cacheDir, TargetDirect)
+			defer m.rules.Close()
+
+			if err := m.Update(); err == nil {
+				t.Fatal("Update() succeeded for an invalid bundle")
+			}
+			if m.GetLastError() == nil || m.Status().Loaded {
+				t.Errorf("Status() = %+v, want error and not loaded", m.Status())
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateBundleURL(t *testing.T) {
+	config := &Config{CacheDir: t.TempDir(), BundleURL: "https://example.com/bundle.tar.gz"}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+	config.RuleURL = "https://example.com/rules.k2r.gz"
+	if err := config.Validate(); err == nil {
+		t.Error("Validate() accepted BundleURL with RuleURL")
+	}
+}
@@ -0,0 +1,152 @@
+package k2rule
+
+import (
+	"fmt"
+
+	"github.com/kaitu-io/k2rule/internal/cache"
+)
+
+// Decision cache names (keys of Config.Caches)
+const (
+	CacheSticky      = "sticky"      // Pinned decisions (Config.StickyTTL)
+	CacheRDNS        = "rdns"        // PTR answers (Config.EnableRDNS)
+	CacheTmpRules    = "tmp_rules"   // TmpRules (SetTmpRule); entries never expire, so TTL = unbounded
+	CacheRegistrable = "registrable" // Domain rule lookups by registrable domain (Config.RegistrableCache)
+)
+
+// defaultCacheMaxEntries is the entry limit of LRU and LFU caches without MaxEntries
+const defaultCacheMaxEntries = 10000
+
+// CachePolicy selects how a decision cache evicts entries
+type CachePolicy string
+
+const (
+	CachePolicyTTL CachePolicy = "ttl" // Entries only leave when they expire; unbounded (default)
+	CachePolicyLRU CachePolicy = "lru" // At most MaxEntries; the least recently used entry is evicted
+	CachePolicyLFU CachePolicy = "lfu" // At most MaxEntries; the least frequently used entry is evicted
+)
+
+// CacheConfig sizes a decision cache. Routers with little memory bound the caches
+// with LRU or LFU; phones can keep the unbounded TTL default. The TmpRule store evicts
+// only when MaxEntries TmpRules are set in total; bounding it gives up its lock sharding.
+//
+// Example:
+//
+//	config.Caches = map[string]k2rule.CacheConfig{
+//	    k2rule.CacheSticky: {Policy: k2rule.CachePolicyLRU, MaxEntries: 2000},
+//	}
+type CacheConfig struct {
+	Policy     CachePolicy `json:"policy,omitempty"`      // "" = CachePolicyTTL
+	MaxEntries int         `json:"max_entries,omitempty"` // Entry limit of LRU/LFU caches (0 = 10000)
+}
+
+// CacheInfo is the state of a decision cache (see CacheStats)
+type CacheInfo struct {
+	Name        string      `json:"name"`
+	Policy      CachePolicy `json:"policy"`
+	MaxEntries  int         `json:"max_entries,omitempty"` // 0 = unbounded
+	Entries     int         `json:"entries"`
+	Hits        uint64      `json:"hits"`
+	Misses      uint64      `json:"misses"`
+	Evictions   uint64      `json:"evictions"`   // Entries evicted to make room
+	Expirations uint64      `json:"expirations"` // Entries removed after they expired
+}
+
+// CacheStats returns the policy, size and counters of each decision cache
+func CacheStats() []CacheInfo {
+	defaultEngine.mu.RLock()
+	defer defaultEngine.mu.RUnlock()
+	return []CacheInfo{
+		cacheInfo(CacheSticky, globalCaches.sticky, defaultEngine.sticky.entries.Stats()),
+		cacheInfo(CacheRDNS, globalCaches.rdns, globalRDNS.entries.Stats()),
+		cacheInfo(CacheTmpRules, globalCaches.tmpRules, defaultEngine.tmpRules.stats()),
+		cacheInfo(CacheRegistrable, globalCaches.registrable, globalRegistrable.entries.Stats()),
+	}
+}
+
+// globalCaches holds the applied cache configurations, for CacheStats (defaultEngine.mu)
+var globalCaches struct {
+	sticky, rdns, tmpRules, registrable CacheConfig
+}
+
+// configureCaches applies the Config.Caches settings (nil = defaults) to the shared
+// caches and those of the default engine; defaultEngine.mu held
+func configureCaches(caches map[string]CacheConfig) {
+	rdns, registrable := caches[CacheRDNS].normalized(), caches[CacheRegistrable].normalized()
+	globalRDNS.entries.Configure(rdns.policy(), rdns.MaxEntries)
+	globalRegistrable.entries.Configure(registrable.policy(), registrable.MaxEntries)
+	sticky, tmpRules := defaultEngine.configureCaches(caches)
+	globalCaches.sticky, globalCaches.rdns, globalCaches.tmpRules, globalCaches.registrable = sticky, rdns, tmpRules, registrable
+}
+
+// configureCaches applies the Config.Caches settings of the caches owned by e (sticky
+// decisions and TmpRules), returning the normalized configurations
+func (e *Engine) configureCaches(caches map[string]CacheConfig) (sticky, tmpRules CacheConfig) {
+	sticky, tmpRules = caches[CacheSticky].normalized(), caches[CacheTmpRules].normalized()
+	e.sticky.entries.Configure(sticky.policy(), sticky.MaxEntries)
+	e.tmpRules.configure(tmpRules.policy(), tmpRules.MaxEntries)
+	return sticky, tmpRules
+}
+
+// validateCaches checks the Config.Caches settings
+func validateCaches(caches map[string]CacheConfig) error {
+	for name, c := range caches {
+		switch name {
+		case CacheSticky, CacheRDNS, CacheTmpRules, CacheRegistrable:
+		default:
+			return fmt.Errorf("unknown cache %q in Caches", name)
+		}
+		switch c.Policy {
+		case "", CachePolicyTTL:
+			if c.MaxEntries != 0 {
+				return fmt.Errorf("%s cache: MaxEntries requires the lru or lfu policy", name)
+			}
+		case CachePolicyLRU, CachePolicyLFU:
+			if c.MaxEntries < 0 {
+				return fmt.Errorf("%s cache: MaxEntries cannot be negative", name)
+			}
+		default:
+			return fmt.Errorf("%s cache: unknown policy %q", name, c.Policy)
+		}
+	}
+	return nil
+}
+
+// normalized returns c with defaults applied
+func (c CacheConfig) normalized() CacheConfig {
+	switch c.Policy {
+	case CachePolicyLRU, CachePolicyLFU:
+		if c.MaxEntries <= 0 {
+			c.MaxEntries = defaultCacheMaxEntries
+		}
+	default:
+		c.Policy, c.MaxEntries = CachePolicyTTL, 0
+	}
+	return c
+}
+
+// policy returns the eviction policy of a normalized config
+func (c CacheConfig) policy() cache.Policy {
+	switch c.Policy {
+	case CachePolicyLRU:
+		return cache.LRU
+	case CachePolicyLFU:
+		return cache.LFU
+	}
+	return cache.TTL
+}
+
+// cacheInfo builds the CacheInfo of a cache
+func cacheInfo(name string, c CacheConfig, stats cache.Stats) CacheInfo {
+	c = c.normalized()
+	return CacheInfo{
+		Name:        name,
+		Policy:      c.Policy,
+		MaxEntries:  c.MaxEntries,
+		Entries:     stats.Entries,
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		Evictions:   stats.Evictions,
+		Expirations: stats.Expirations,
+	}
+}
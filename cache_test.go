@@ -0,0 +1,52 @@
+package k2rule
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheStats(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer UnstickAll()
+
+	stats := CacheStats()
+	if len(stats) != 4 || stats[0].Name != CacheSticky || stats[1].Name != CacheRDNS || stats[2].Name != CacheTmpRules || stats[3].Name != CacheRegistrable {
+		t.Fatalf("CacheStats() = %+v, want sticky, rdns, tmp_rules and registrable", stats)
+	}
+	if stats[0].Policy != CachePolicyTTL || stats[0].MaxEntries != 0 {
+		t.Errorf("default sticky cache = %+v, want unbounded TTL", stats[0])
+	}
+
+	config := &Config{
+		RuleFile:  filepath.Join(t.TempDir(), "missing.k2r.gz"),
+		GeoIPFile: filepath.Join(t.TempDir(), "missing.mmdb"),
+		CacheDir:  t.TempDir(),
+		StickyTTL: Duration(time.Hour),
+		Caches: map[string]CacheConfig{
+			CacheSticky: {Policy: CachePolicyLRU, MaxEntries: 2},
+			CacheRDNS:   {Policy: CachePolicyLFU},
+		},
+	}
+	Init(config) // missing files don't matter here
+	base := CacheStats()[0]
+
+	for _, domain := range []string{"a.com", "b.com", "c.com", "c.com"} {
+		defaultEngine.sticky.put(domain, MatchResult{Target: TargetProxy}, time.Hour)
+		defaultEngine.sticky.get(domain, time.Hour)
+	}
+	defaultEngine.sticky.get("a.com", time.Hour)
+
+	stats = CacheStats()
+	sticky, rdns := stats[0], stats[1]
+	if sticky.Policy != CachePolicyLRU || sticky.MaxEntries != 2 || sticky.Entries != 2 {
+		t.Errorf("sticky cache = %+v, want LRU with 2 of 2 entries", sticky)
+	}
+	if sticky.Evictions-base.Evictions != 1 || sticky.Hits-base.Hits != 4 || sticky.Misses-base.Misses != 1 {
+		t.Errorf("sticky counters = %+v (from %+v), want 1 eviction, 4 hits, 1 miss", sticky, base)
+	}
+	if rdns.Policy != CachePolicyLFU || rdns.MaxEntries != defaultCacheMaxEntries {
+		t.Errorf("rdns cache = %+v, want LFU with the default limit", rdns)
+	}
+}
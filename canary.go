@@ -0,0 +1,126 @@
+package k2rule
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// UpdateStrategy controls how downloaded rule updates are activated.
+// The zero value is Immediate: a new rule file is hot-swapped as soon as it is downloaded.
+type UpdateStrategy struct {
+	Canary *CanaryStrategy `json:"canary,omitempty"` // nil = Immediate
+}
+
+// CanaryStrategy applies a new rule generation to a percentage of lookups first.
+//
+// Inputs are hash-bucketed, so a given domain/IP consistently sees either the old or the
+// new rules during the canary. After Duration the update is promoted, unless the share of
+// canary lookups whose decision changed exceeds MaxDiffRate, in which case it is discarded
+// and the active rules are kept. A rule file that fails to load never reaches the canary.
+//
+// Canary results (diffs, counters) are available through GetShadowReport.
+type CanaryStrategy struct {
+	Percent     int      `json:"percent"`                 // Share of inputs (1-100) served by the new rules
+	Duration    Duration `json:"duration"`                // Canary period before promotion
+	MaxDiffRate float64  `json:"max_diff_rate,omitempty"` // Abort threshold (0..1) for changed decisions; 0 = no limit
+}
+
+// validate checks the canary parameters
+func (c *CanaryStrategy) validate() error {
+	if c.Percent < 1 || c.Percent > 100 {
+		return fmt.Errorf("canary Percent must be between 1 and 100")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("canary Duration must be positive")
+	}
+	if c.MaxDiffRate < 0 || c.MaxDiffRate > 1 {
+		return fmt.Errorf("canary MaxDiffRate must be between 0 and 1")
+	}
+	return nil
+}
+
+// canaryBucket returns the stable bucket (0-99) of an input
+func canaryBucket(input string) int {
+	h := fnv.New32a()
+	h.Write([]byte(input))
+	return int(h.Sum32() % 100)
+}
+
+// match matches input against the active rules, serving canary buckets from the
+// pending rules and shadow-evaluating the rest (internal use only).
+// Country is the GeoIP country resolved by the active rules, if any.
+func (m *RemoteRuleManager) match(input string, ip net.IP, geoIPMgr *GeoIPManager) MatchResult {
+	active := m.matchInput(input, ip, geoIPMgr)
+
+	if m.canary != nil {
+		if pending := m.pending.Load(); pending != nil && canaryBucket(input) < m.canary.Percent {
+			result := matchRules(pending, Target(pending.Fallback()), input, ip, geoIPMgr)
+			m.shadow.record(input, active.Target, result.Target)
+			result.Country = active.Country
+			return result
+		}
+		return active
+	}
+
+	m.shadowEvaluate(input, ip, geoIPMgr, active.Target)
+	return active
+}
+
+// stagingEnabled reports whether downloaded updates are staged as pending (shadow or canary)
+func (m *RemoteRuleManager) stagingEnabled() bool {
+	return m.shadowEnabled() || m.canary != nil
+}
+
+// startCanary schedules the promote/abort decision for a staged reader
+func (m *RemoteRuleManager) startCanary(reader *slice.MmapReader) {
+	if m.canary == nil {
+		return
+	}
+	m.logger().Info("rules canary started", "percent", m.canary.Percent, "duration", m.canary.Duration)
+
+	safeGo("rules", func() {
+		timer := time.NewTimer(time.Duration(m.canary.Duration))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-m.stopCh:
+			return
+		}
+
+		// Superseded by a newer download, promoted or discarded meanwhile
+		if m.pending.Load() != reader {
+			return
+		}
+		m.finishCanary()
+	})
+}
+
+// finishCanary promotes the pending rules, or discards them if the diff threshold is exceeded
+func (m *RemoteRuleManager) finishCanary() {
+	evaluated := m.shadow.evaluated.Load()
+	differed := m.shadow.differed.Load()
+
+	if m.canary.MaxDiffRate > 0 && evaluated > 0 {
+		rate := float64(differed) / float64(evaluated)
+		if rate > m.canary.MaxDiffRate {
+			err := fmt.Errorf("rules canary aborted: %.1f%% of %d lookups changed (max %.1f%%)",
+				rate*100, evaluated, m.canary.MaxDiffRate*100)
+			m.logger().Warn("rules canary aborted", "evaluated", evaluated, "differed", differed)
+			if discardErr := m.discardPending(); discardErr != nil {
+				m.logger().Warn("failed to discard canary rules", "error", discardErr)
+			}
+			m.setLastError(err)
+			return
+		}
+	}
+
+	if err := m.promotePending(); err != nil {
+		m.logger().Warn("rules canary promotion failed", "error", err)
+		m.setLastError(err)
+	}
+}
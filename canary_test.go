@@ -0,0 +1,128 @@
+package k2rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newCanaryTestManager returns a manager with "old.com" active and "new.com" staged under canary
+func newCanaryTestManager(t *testing.T, canary *CanaryStrategy) *RemoteRuleManager {
+	t.Helper()
+
+	body := gzipBytes(t, buildTestPornK2R(t, []string{"old.com"}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	manager.canary = canary
+	t.Cleanup(func() { manager.Close() })
+
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	body = gzipBytes(t, buildTestPornK2R(t, []string{"new.com"}))
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if manager.pending.Load() == nil {
+		t.Fatal("expected update to be staged for canary")
+	}
+	return manager
+}
+
+func TestCanary_PromotesAfterDuration(t *testing.T) {
+	manager := newCanaryTestManager(t, &CanaryStrategy{Percent: 100, Duration: Duration(50 * time.Millisecond)})
+
+	// Percent=100: every input is served by the new rules during the canary
+	if got := manager.match("new.com", nil, nil).Target; got != TargetReject {
+		t.Errorf("match(new.com) during canary = %v, want REJECT", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.pending.Load() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if manager.pending.Load() != nil {
+		t.Fatal("canary not promoted after duration")
+	}
+	if got := manager.matchInput("new.com", nil, nil).Target; got != TargetReject {
+		t.Errorf("active match(new.com) after promotion = %v, want REJECT", got)
+	}
+}
+
+func TestCanary_AbortsOnDiffRate(t *testing.T) {
+	manager := newCanaryTestManager(t, &CanaryStrategy{
+		Percent:     100,
+		Duration:    Duration(50 * time.Millisecond),
+		MaxDiffRate: 0.1,
+	})
+
+	manager.match("new.com", nil, nil) // changed decision
+	manager.match("old.com", nil, nil) // changed decision
+	manager.match("same.com", nil, nil)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.pending.Load() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if manager.pending.Load() != nil {
+		t.Fatal("canary still pending after duration")
+	}
+	if manager.GetLastError() == nil {
+		t.Error("GetLastError() = nil after aborted canary")
+	}
+	if got := manager.matchInput("old.com", nil, nil).Target; got != TargetReject {
+		t.Errorf("active rules changed after aborted canary: old.com = %v", got)
+	}
+}
+
+func TestCanaryBucket(t *testing.T) {
+	if canaryBucket("example.com") != canaryBucket("example.com") {
+		t.Error("canaryBucket is not stable")
+	}
+
+	inCanary := 0
+	for i := 0; i < 10000; i++ {
+		if canaryBucket(string(rune(i))+".example.com") < 10 {
+			inCanary++
+		}
+	}
+	if inCanary < 700 || inCanary > 1300 {
+		t.Errorf("%d of 10000 inputs in a 10%% canary, want ~1000", inCanary)
+	}
+}
+
+func TestCanaryStrategy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		canary  CanaryStrategy
+		wantErr bool
+	}{
+		{"valid", CanaryStrategy{Percent: 10, Duration: Duration(time.Hour)}, false},
+		{"zero percent", CanaryStrategy{Percent: 0, Duration: Duration(time.Hour)}, true},
+		{"over 100 percent", CanaryStrategy{Percent: 101, Duration: Duration(time.Hour)}, true},
+		{"no duration", CanaryStrategy{Percent: 10}, true},
+		{"bad diff rate", CanaryStrategy{Percent: 10, Duration: Duration(time.Hour), MaxDiffRate: 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{CacheDir: "/tmp", UpdateStrategy: UpdateStrategy{Canary: &tt.canary}}
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	c := &Config{
+		CacheDir:         "/tmp",
+		ShadowSampleRate: 0.5,
+		UpdateStrategy:   UpdateStrategy{Canary: &CanaryStrategy{Percent: 10, Duration: Duration(time.Hour)}},
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate() accepted shadow mode combined with canary")
+	}
+}
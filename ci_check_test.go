@@ -3,6 +3,7 @@ package k2rule
 import (
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -38,3 +39,33 @@ func TestGoGeneratorBuilds(t *testing.T) {
 		t.Fatalf("go build ./cmd/k2rule-gen failed: %v\n%s", err, out)
 	}
 }
+
+// crossBuildTargets are the GOOS/GOARCH pairs the core packages must compile for.
+// Platforms without mmap (plan9, wasip1, js, aix) degrade to the in-memory reader.
+var crossBuildTargets = []string{
+	"linux/amd64", "linux/arm64", "linux/arm", "linux/386",
+	"android/arm64", "darwin/arm64", "darwin/amd64",
+	"windows/amd64", "windows/arm64",
+	"freebsd/amd64", "openbsd/amd64", "netbsd/amd64", "dragonfly/amd64",
+	"solaris/amd64", "illumos/amd64", "aix/ppc64",
+	"plan9/amd64", "wasip1/wasm", "js/wasm",
+}
+
+// TestCrossBuild verifies the core packages compile on every supported platform
+// (skipped with -short; ios needs cgo and is covered by darwin/arm64).
+func TestCrossBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cross-compiling is slow")
+	}
+	for _, target := range crossBuildTargets {
+		goos, goarch, _ := strings.Cut(target, "/")
+		t.Run(goos+"_"+goarch, func(t *testing.T) {
+			cmd := exec.Command("go", "build", ".", "./internal/...")
+			cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("go build for %s failed: %v\n%s", target, err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package k2rule
+
+import "net/netip"
+
+// ContainsIP reports whether ip is contained in any of the prefixes.
+// IPv4-mapped IPv6 addresses (::ffff:1.2.3.4) match IPv4 prefixes.
+//
+// Example:
+//
+//	prefixes := k2rule.LoadedCIDRsFor(k2rule.TargetProxy)
+//	if k2rule.ContainsIP(prefixes, netip.MustParseAddr("1.2.3.4")) { ... }
+func ContainsIP(rules []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range rules {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadedCIDRsFor returns the IP-CIDR rules of the loaded rule file that route to target
+// (IPv4 and IPv6, in file order). Returns nil if no rules are loaded.
+// TmpRules, GeoIP rules and the fallback are not included.
+func LoadedCIDRsFor(target Target) []netip.Prefix {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil {
+		return nil
+	}
+	return manager.reader.CIDRs(uint8(target))
+}
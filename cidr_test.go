@@ -0,0 +1,96 @@
+package k2rule
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+func TestContainsIP(t *testing.T) {
+	rules := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := ContainsIP(rules, netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("ContainsIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	if ContainsIP(nil, netip.MustParseAddr("10.0.0.1")) {
+		t.Error("ContainsIP(nil) = true, want false")
+	}
+}
+
+func TestLoadedCIDRsFor(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if got := LoadedCIDRsFor(TargetProxy); got != nil {
+		t.Errorf("LoadedCIDRsFor without rules = %v, want nil", got)
+	}
+
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	if err := w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, uint8(TargetProxy)); err != nil {
+		t.Fatalf("AddCidrV4Slice failed: %v", err)
+	}
+	var v6 [16]byte
+	v6[0], v6[1], v6[2], v6[3] = 0x20, 0x01, 0x0d, 0xb8
+	if err := w.AddCidrV6Slice([]slice.CidrV6Entry{{Network: v6, PrefixLen: 32}}, uint8(TargetProxy)); err != nil {
+		t.Fatalf("AddCidrV6Slice failed: %v", err)
+	}
+	if err := w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 192<<24 | 0<<16 | 2<<8, PrefixLen: 24}}, uint8(TargetReject)); err != nil {
+		t.Fatalf("AddCidrV4Slice failed: %v", err)
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	proxy := LoadedCIDRsFor(TargetProxy)
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	if len(proxy) != len(want) {
+		t.Fatalf("LoadedCIDRsFor(PROXY) = %v, want %v", proxy, want)
+	}
+	for i := range want {
+		if proxy[i] != want[i] {
+			t.Errorf("LoadedCIDRsFor(PROXY)[%d] = %v, want %v", i, proxy[i], want[i])
+		}
+	}
+
+	reject := LoadedCIDRsFor(TargetReject)
+	if len(reject) != 1 || reject[0] != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Errorf("LoadedCIDRsFor(REJECT) = %v, want [192.0.2.0/24]", reject)
+	}
+	if !ContainsIP(reject, netip.MustParseAddr("192.0.2.7")) {
+		t.Error("ContainsIP(LoadedCIDRsFor(REJECT), 192.0.2.7) = false")
+	}
+}
@@ -4,6 +4,7 @@
 //
 //	k2rule-gen generate-all -o output/ [-v]
 //	k2rule-gen generate-porn -o output/porn_domains.k2r.gz [-v]
+//	k2rule-gen generate-geoip -countries CN,HK [-i GeoLite2-Country.mmdb] -o output/geoip-cn.mmdb.gz [-v]
 //
 // The generate-all command reads clash_rules/*.yml, downloads rule providers
 // via HTTP, converts with SliceConverter, gzips, and writes .k2r.gz files.
@@ -11,9 +12,14 @@
 // The generate-porn command fetches the Bon-Appetit/porn-domains blocklist,
 // filters heuristic-detected domains, builds a K2RULEV3 with target=Reject,
 // and writes a gzip-compressed .k2r.gz file.
+//
+// The generate-geoip command trims a MaxMind country database (a local .mmdb or
+// .mmdb.gz file, or a URL; default GeoLite2-Country) to the networks of the
+// selected countries, for apps whose GeoIP rules only name a few countries.
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"flag"
@@ -27,8 +33,10 @@ import (
 	"time"
 
 	"github.com/kaitu-io/k2rule/internal/clash"
+	"github.com/kaitu-io/k2rule/internal/mmdb"
 	"github.com/kaitu-io/k2rule/internal/porn"
 	"github.com/kaitu-io/k2rule/internal/slice"
+	"github.com/oschwald/maxminddb-golang"
 )
 
 // URL constants for the Bon-Appetit/porn-domains repository.
@@ -37,6 +45,9 @@ const (
 	pornDomainsBaseURL = "https://cdn.jsdelivr.net/gh/Bon-Appetit/porn-domains@main/"
 )
 
+// geoLite2CountryURL is the default source of generate-geoip (k2rule.DefaultGeoIPURL)
+const geoLite2CountryURL = "https://cdn.jsdelivr.net/npm/geolite2-country/GeoLite2-Country.mmdb.gz"
+
 // httpClient is the shared HTTP client with a reasonable timeout.
 var httpClient = &http.Client{
 	Timeout: 300 * time.Second,
@@ -57,7 +68,7 @@ type pornDomainsFile struct {
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "Usage: k2rule-gen <command> [options]")
-		fmt.Fprintln(os.Stderr, "Commands: generate-all, generate-porn")
+		fmt.Fprintln(os.Stderr, "Commands: generate-all, generate-porn, generate-geoip")
 		os.Exit(1)
 	}
 
@@ -68,9 +79,11 @@ func main() {
 		runGenerateAll(os.Args[2:])
 	case "generate-porn":
 		runGeneratePorn(os.Args[2:])
+	case "generate-geoip":
+		runGenerateGeoIP(os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", subcommand)
-		fmt.Fprintln(os.Stderr, "Commands: generate-all, generate-porn")
+		fmt.Fprintln(os.Stderr, "Commands: generate-all, generate-porn, generate-geoip")
 		os.Exit(1)
 	}
 }
@@ -107,6 +120,24 @@ func runGeneratePorn(args []string) {
 	}
 }
 
+// runGenerateGeoIP parses flags and runs the generate-geoip subcommand.
+func runGenerateGeoIP(args []string) {
+	fs := flag.NewFlagSet("generate-geoip", flag.ExitOnError)
+	input := fs.String("i", geoLite2CountryURL, "Source country database (.mmdb or .mmdb.gz file, or URL)")
+	countries := fs.String("countries", "", "Comma-separated ISO country codes to keep, e.g. CN,HK")
+	outputPath := fs.String("o", "output/geoip.mmdb.gz", "Output file path (gzip-compressed when ending in .gz)")
+	verbose := fs.Bool("v", false, "Verbose output")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := generateGeoIP(*input, strings.Split(*countries, ","), *outputPath, *verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 // generateAll reads all YAML files from clash_rules/, downloads rule providers,
 // converts to K2RULEV3 format, gzip-compresses, and writes .k2r.gz files.
 func generateAll(outputDir string, verbose bool) error {
@@ -283,6 +314,73 @@ func generatePorn(outputPath string, verbose bool) error {
 	return nil
 }
 
+// generateGeoIP trims the country database at input to the given countries and
+// writes it to outputPath.
+func generateGeoIP(input string, countries []string, outputPath string, verbose bool) error {
+	logger := newLogger(verbose)
+
+	if dir := filepath.Dir(outputPath); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create output dir: %w", err)
+		}
+	}
+
+	logger.Info("Reading country database", "input", input)
+	var raw []byte
+	var err error
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		var content string
+		content, err = downloadURL(input)
+		raw = []byte(content)
+	} else {
+		raw, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return fmt.Errorf("read country database: %w", err)
+	}
+	if strings.HasSuffix(input, ".gz") {
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("decompress country database: %w", err)
+		}
+		if raw, err = io.ReadAll(gz); err != nil {
+			return fmt.Errorf("decompress country database: %w", err)
+		}
+	}
+
+	src, err := maxminddb.FromBytes(raw)
+	if err != nil {
+		return fmt.Errorf("open country database: %w", err)
+	}
+	w, stats, err := mmdb.TrimCountries(src, countries)
+	if err != nil {
+		return fmt.Errorf("trim: %w", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		return fmt.Errorf("build database: %w", err)
+	}
+
+	if strings.HasSuffix(outputPath, ".gz") {
+		err = writeGzip(buf.Bytes(), outputPath)
+	} else {
+		err = os.WriteFile(outputPath, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	logger.Info("Successfully generated trimmed GeoIP database",
+		"output", outputPath,
+		"countries", strings.Join(countries, ","),
+		"source_networks", stats.Networks,
+		"kept_networks", stats.Kept,
+		"source_size_bytes", len(raw),
+		"size_bytes", buf.Len(),
+	)
+	return nil
+}
+
 // downloadURL downloads content from a URL and returns the response body as a string.
 func downloadURL(url string) (string, error) {
 	resp, err := httpClient.Get(url)
@@ -6,15 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/kaitu-io/k2rule/internal/clash"
+	"github.com/kaitu-io/k2rule/internal/mmdb"
 	"github.com/kaitu-io/k2rule/internal/porn"
 	"github.com/kaitu-io/k2rule/internal/slice"
+	"github.com/oschwald/maxminddb-golang"
 )
 
 // TestGenerateAllFromClashYAML tests the generate-all logic by using mock provider rules
@@ -147,6 +151,59 @@ func TestGeneratePornDomains(t *testing.T) {
 	}
 }
 
+// TestGenerateGeoIP trims a gzipped country database served over HTTP and verifies
+// the output keeps only the selected countries.
+func TestGenerateGeoIP(t *testing.T) {
+	world := mmdb.NewWriter("GeoLite2-Country", "")
+	world.Insert(netip.MustParsePrefix("1.0.1.0/24"), map[string]string{"country.iso_code": "CN"})
+	world.Insert(netip.MustParsePrefix("8.8.8.0/24"), map[string]string{"country.iso_code": "US"})
+	var raw bytes.Buffer
+	if _, err := world.WriteTo(&raw); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(raw.Bytes())
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gz.Bytes())
+	}))
+	defer server.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "out", "geoip-cn.mmdb.gz")
+	if err := generateGeoIP(server.URL+"/GeoLite2-Country.mmdb.gz", []string{"CN"}, outputPath, false); err != nil {
+		t.Fatalf("generateGeoIP failed: %v", err)
+	}
+	compressed, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	data, err := decompressGzipBytes(compressed)
+	if err != nil {
+		t.Fatalf("Output is not valid gzip: %v", err)
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		t.Fatalf("Output is not a valid mmdb: %v", err)
+	}
+	var rec struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if db.Lookup(net.ParseIP("1.0.1.1"), &rec); rec.Country.IsoCode != "CN" {
+		t.Errorf("Lookup(1.0.1.1) = %q, want CN", rec.Country.IsoCode)
+	}
+	rec.Country.IsoCode = ""
+	if db.Lookup(net.ParseIP("8.8.8.8"), &rec); rec.Country.IsoCode != "" {
+		t.Errorf("Lookup(8.8.8.8) = %q, want no record", rec.Country.IsoCode)
+	}
+
+	if err := generateGeoIP(server.URL+"/GeoLite2-Country.mmdb.gz", []string{""}, outputPath, false); err == nil {
+		t.Error("generateGeoIP without countries succeeded")
+	}
+}
+
 // TestGeneratedFileSizeReasonable verifies that a K2RULEV3 file with many domains
 // compresses to a reasonable size.
 func TestGeneratedFileSizeReasonable(t *testing.T) {
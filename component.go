@@ -0,0 +1,117 @@
+package k2rule
+
+import "errors"
+
+// ErrLocalFile is returned by Component.Update for components loaded from a local file
+// (Config.RuleFile, GeoIPFile, PornFile)
+var ErrLocalFile = errors.New("component is loaded from a local file")
+
+// Component controls one data component (rules, GeoIP, porn database) of the
+// active configuration, so applications can drive updates themselves.
+//
+// For components loaded from Config.BundleURL or ManifestURL, Update and Stop act on
+// the combined source and thus on all of its components; for rules composed from a
+// Config.Subscription they act on all of its sources.
+//
+// Example:
+//
+//	if rules := k2rule.RuleComponent(); rules != nil {
+//	    rules.Stop() // no background updates; refresh on our own schedule
+//	    if err := rules.Update(); err != nil {
+//	        log.Printf("rule update failed: %v", err)
+//	    }
+//	    log.Printf("rules generation %d", rules.Status().Generation)
+//	}
+type Component interface {
+	// Update downloads the component now (ETag-conditional)
+	Update() error
+	// Stop stops background auto-updates; the loaded data keeps serving lookups
+	// (except the remote porn database, whose mapping is released)
+	Stop()
+	// Status returns a snapshot of the component state
+	Status() ComponentInfo
+}
+
+// RuleComponent returns the rule component of the active configuration
+// (nil before Init and in pure global mode)
+func RuleComponent() Component {
+	defaultEngine.mu.RLock()
+	defer defaultEngine.mu.RUnlock()
+
+	if defaultEngine.manager == nil {
+		return nil
+	}
+	if defaultEngine.config != nil && defaultEngine.config.RuleFile != "" {
+		return fileComponent{status: defaultEngine.manager.Status, source: defaultEngine.config.RuleFile}
+	}
+	return combinedOrSelf(defaultEngine.manager)
+}
+
+// GeoIPComponent returns the GeoIP component of the active configuration (nil before Init)
+func GeoIPComponent() Component {
+	defaultEngine.mu.RLock()
+	defer defaultEngine.mu.RUnlock()
+
+	if defaultEngine.geoIPMgr == nil {
+		return nil
+	}
+	if defaultEngine.config != nil && defaultEngine.config.GeoIPFile != "" {
+		return fileComponent{status: defaultEngine.geoIPMgr.Status, source: defaultEngine.config.GeoIPFile}
+	}
+	return combinedOrSelf(defaultEngine.geoIPMgr)
+}
+
+// PornComponent returns the porn database component of the active configuration
+// (nil when Antiporn is false)
+func PornComponent() Component {
+	defaultEngine.mu.RLock()
+	defer defaultEngine.mu.RUnlock()
+
+	if defaultEngine.pornManager != nil {
+		return combinedOrSelf(defaultEngine.pornManager)
+	}
+	if defaultEngine.matcher != nil && defaultEngine.matcher.pornChecker != nil && defaultEngine.matcher.pornChecker.reader != nil {
+		reader := defaultEngine.matcher.pornChecker.reader
+		return fileComponent{status: func() ComponentInfo { return readerStatus(ComponentPorn, "", reader) }, source: defaultEngine.config.PornFile}
+	}
+	return nil
+}
+
+// combinedOrSelf wraps c when it is fed by the active bundle, manifest or subscription
+// (defaultEngine.mu held)
+func combinedOrSelf(c Component) Component {
+	switch {
+	case defaultEngine.bundleMgr != nil:
+		return combinedComponent{Component: c, source: defaultEngine.bundleMgr}
+	case defaultEngine.manifestMgr != nil:
+		return combinedComponent{Component: c, source: defaultEngine.manifestMgr}
+	case defaultEngine.subscriptionMgr != nil && c == Component(defaultEngine.subscriptionMgr.rules):
+		return combinedComponent{Component: c, source: defaultEngine.subscriptionMgr}
+	}
+	return c
+}
+
+// combinedComponent is a component loaded from a bundle, manifest or subscription:
+// Update and Stop go through the combined source
+type combinedComponent struct {
+	Component           // Component manager (Status)
+	source    Component // BundleManager, ManifestManager or SubscriptionManager
+}
+
+func (c combinedComponent) Update() error { return c.source.Update() }
+func (c combinedComponent) Stop()         { c.source.Stop() }
+
+// fileComponent is a component loaded from a local file (no updates)
+type fileComponent struct {
+	status func() ComponentInfo
+	source string // Local file path
+}
+
+func (c fileComponent) Update() error { return ErrLocalFile }
+func (c fileComponent) Stop()         {}
+
+func (c fileComponent) Status() ComponentInfo {
+	info := c.status()
+	info.Source = c.source
+	return info
+}
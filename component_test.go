@@ -0,0 +1,129 @@
+package k2rule
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestComponents_NothingInitialized(t *testing.T) {
+	resetGlobalState()
+
+	if RuleComponent() != nil || GeoIPComponent() != nil || PornComponent() != nil {
+		t.Error("component accessors should return nil before Init")
+	}
+}
+
+func TestRuleComponent_Remote(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})))
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	rules := RuleComponent()
+	if rules == nil {
+		t.Fatal("RuleComponent() = nil")
+	}
+	if err := rules.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("requests = %d, want 1", requests.Load())
+	}
+	if info := rules.Status(); info.Name != ComponentRules || !info.Loaded || info.Generation != 1 {
+		t.Errorf("Status() = %+v", info)
+	}
+
+	// Stop is idempotent
+	rules.Stop()
+	rules.Stop()
+}
+
+func TestRuleComponent_LocalFile(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, RuleFile: rulePath}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	rules := RuleComponent()
+	if rules == nil {
+		t.Fatal("RuleComponent() = nil")
+	}
+	if err := rules.Update(); !errors.Is(err, ErrLocalFile) {
+		t.Errorf("Update() = %v, want ErrLocalFile", err)
+	}
+	if info := rules.Status(); info.Source != rulePath || !info.Loaded {
+		t.Errorf("Status() = %+v, want Source %q", info, rulePath)
+	}
+	if PornComponent() != nil {
+		t.Error("PornComponent() should be nil without Antiporn")
+	}
+}
+
+func TestComponents_Combined(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	bundle := buildTestBundle(t, map[string][]byte{
+		"manifest.json": []byte(`{"rules": "rules.k2r.gz"}`),
+		"rules.k2r.gz":  gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})),
+	})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	m := NewBundleManager(server.URL+"/bundle.tar.gz", cacheDir)
+	m.rules = NewRemoteRuleManager(m.url, cacheDir, TargetDirect)
+	defer m.rules.Close()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = m.rules
+	defaultEngine.bundleMgr = m
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	// Updating the rule component downloads the bundle, not the rules URL
+	if err := RuleComponent().Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if got := m.rules.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if info := RuleComponent().Status(); info.Name != ComponentRules || !info.Loaded {
+		t.Errorf("Status() = %+v", info)
+	}
+	if requests.Load() != 1 || m.Status().Generation != 1 {
+		t.Errorf("bundle requests = %d, generation = %d, want 1 and 1", requests.Load(), m.Status().Generation)
+	}
+}
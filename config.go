@@ -1,7 +1,11 @@
 package k2rule
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
 )
 
 // Config holds all K2Rule initialization settings.
@@ -9,30 +13,199 @@ import (
 //
 // Default behavior (all URLs auto-download from jsDelivr CDN):
 //   - Empty RuleURL  → DefaultRuleURL (cn_blacklist.k2r.gz) unless IsGlobal=true
-//   - Empty GeoIPURL → DefaultGeoIPURL (MaxMind GeoLite2)
+//   - Empty GeoIPURL → DefaultGeoIPURL (MaxMind GeoLite2; k2rule-gen generate-geoip trims it to a few countries),
+//     or MaxMind's own endpoint when GeoIPMaxMind is set
 //   - Empty PornURL  → DefaultPornURL (porn_domains.k2r.gz) when Antiporn=true
 //
 // Priority: File paths take precedence over URLs
+//
+// Config round-trips through encoding/json (Target encodes as "PROXY", Duration as "6h"),
+// so applications can persist settings directly. Callbacks (OnPanic, OnProgress, OnReject, URLExpander, SourceAuth.BearerToken) are never encoded.
 type Config struct {
 	// Rule configuration
-	RuleURL  string // Remote rule file URL ("" = use DefaultRuleURL, ignored if IsGlobal=true)
-	RuleFile string // Local rule file path (takes precedence over RuleURL)
+	RuleURL  string      `json:"rule_url,omitempty"`  // Remote rule file URL ("" = use DefaultRuleURL, ignored if IsGlobal=true)
+	RuleFile string      `json:"rule_file,omitempty"` // Local rule file path (takes precedence over RuleURL)
+	RuleAuth *SourceAuth `json:"rule_auth,omitempty"` // Optional credentials for a private RuleURL mirror
 
 	// GeoIP configuration (always initialized with defaults)
-	GeoIPURL  string // Remote GeoIP database URL ("" = use DefaultGeoIPURL)
-	GeoIPFile string // Local .mmdb file path (takes precedence over GeoIPURL)
+	GeoIPURL  string      `json:"geoip_url,omitempty"`  // Remote GeoIP database URL ("" = use DefaultGeoIPURL)
+	GeoIPFile string      `json:"geoip_file,omitempty"` // Local .mmdb file path (takes precedence over GeoIPURL)
+	GeoIPAuth *SourceAuth `json:"geoip_auth,omitempty"` // Optional credentials for a private GeoIPURL mirror
+
+	// GeoIPMaxMind downloads the GeoIP database directly from MaxMind with a license key
+	// (tar.gz archive from MaxMindDownloadURL) instead of GeoIPURL. GeoIPFile still
+	// takes precedence.
+	GeoIPMaxMind *MaxMindLicense `json:"geoip_maxmind,omitempty"`
 
 	// Porn detection (only initialized when Antiporn=true)
-	Antiporn bool   // Enable anti-porn resource loading (default: false)
-	PornURL  string // Remote porn database URL ("" = use DefaultPornURL)
-	PornFile string // Local .k2r.gz file path (takes precedence over PornURL)
+	Antiporn     bool        `json:"antiporn,omitempty"`       // Enable anti-porn resource loading (default: false)
+	PornURL      string      `json:"porn_url,omitempty"`       // Remote porn database URL ("" = use DefaultPornURL)
+	PornFile     string      `json:"porn_file,omitempty"`      // Local .k2r.gz file path (takes precedence over PornURL)
+	PornAuth     *SourceAuth `json:"porn_auth,omitempty"`      // Optional credentials for a private PornURL mirror
+	PornPatchURL string      `json:"porn_patch_url,omitempty"` // Optional differential patch URL (see PornPatch); "" = full downloads only
+
+	// RuleOverridesPorn exempts domains the rule file targets DIRECT by name (a domain
+	// rule for the domain or a parent domain) from IsPorn, so allow-listed domains of the
+	// rule file aren't blocked by porn detection. Follows rule file updates; user overlay
+	// entries (AddDomain/RemoveDomain with CategoryPorn) still take precedence.
+	RuleOverridesPorn bool `json:"rule_overrides_porn,omitempty"`
+
+	// HostsFile is an /etc/hosts-style file whose names are local services: always DIRECT
+	// (REJECT when mapped to 0.0.0.0 or ::), with the mapped IPs in MatchResult.HostIP. See AddHost.
+	HostsFile string `json:"hosts_file,omitempty"`
+
+	// Bundle: one tar.gz archive with all databases (see BundleManifest), downloaded from a single
+	// URL with a single ETag. Replaces RuleURL/GeoIPURL/PornURL; local files still take precedence.
+	BundleURL  string      `json:"bundle_url,omitempty"`
+	BundleAuth *SourceAuth `json:"bundle_auth,omitempty"` // Optional credentials for a private BundleURL mirror
+
+	// Manifest-driven updates: a small index.json (see Manifest) listing the URL, size and SHA-256
+	// of each database, polled every 15 minutes; blobs are downloaded only when their hash changes.
+	// Replaces RuleURL/GeoIPURL/PornURL; local files still take precedence. Blobs are cached
+	// by SHA-256, so a manifest on another mirror with the same content needs no download;
+	// the RuleURL/GeoIPURL/PornURL caches are keyed by URL and re-download on a URL change.
+	ManifestURL  string      `json:"manifest_url,omitempty"`
+	ManifestAuth *SourceAuth `json:"manifest_auth,omitempty"` // Optional credentials for the manifest and its blobs
+
+	// Subscription composes the rules from several sources (k2r, Clash rule providers, hosts
+	// files), each fetched on its own interval (see Subscription, LoadSubscription). Replaces
+	// RuleURL; RuleFile still takes precedence.
+	Subscription *Subscription `json:"subscription,omitempty"`
 
 	// Shared settings
-	CacheDir string // Cache directory (REQUIRED: caller must provide a writable path)
+	CacheDir string `json:"cache_dir"` // Cache directory (REQUIRED: caller must provide a writable path)
+
+	// User-Agent sent with downloads ("" = DefaultUserAgent(), e.g. "k2rule/1.0.0 (linux/amd64)").
+	// DisableUserAgent sends no User-Agent at all. A per-source value can be set with
+	// SourceAuth.Headers["User-Agent"].
+	UserAgent        string `json:"user_agent,omitempty"`
+	DisableUserAgent bool   `json:"disable_user_agent,omitempty"`
+
+	// DownloadTimeout bounds database downloads of all components; ComponentDownloadTimeouts
+	// overrides it per component (keys ComponentRules, ComponentGeoIP, ComponentPorn,
+	// ComponentBundle, ComponentManifest, ComponentSubscription; zero fields fall back to
+	// DownloadTimeout). Zero values keep the defaults: 60s total for rules, porn and
+	// subscription sources, 120s for GeoIP and manifests, 180s for bundles, and the
+	// transport's connect timeouts.
+	DownloadTimeout           DownloadTimeout            `json:"download_timeout"`
+	ComponentDownloadTimeouts map[string]DownloadTimeout `json:"component_download_timeouts,omitempty"`
+
+	// UpdateJitter randomizes every auto-update interval by ±UpdateJitter (fraction, 0..1),
+	// so clients started together don't download in lockstep. 0 = fixed intervals.
+	// See also PauseUpdates, RunUpdatesNow and Schedules.
+	UpdateJitter float64 `json:"update_jitter,omitempty"`
+
+	// ReadOnlyCache treats CacheDir as read-only (e.g. pre-seeded in a sandbox): components
+	// load only what the cache already holds, nothing is downloaded or written there, and
+	// Component.Update returns nil after logging the attempt as skipped. User overlay
+	// changes are kept in memory. ReaderMode auto loads files into memory in this mode.
+	ReadOnlyCache bool `json:"read_only_cache,omitempty"`
+
+	// ReaderMode selects how rule and porn files are held in memory (zero value = auto:
+	// in the Go heap up to 4 MiB decompressed, memory-mapped above). See ReaderMode.
+	ReaderMode ReaderMode `json:"reader_mode,omitempty"`
+
+	// PinnedCertSHA256 pins the TLS certificates of download sources. Keys are a source URL
+	// (RuleURL, GeoIPURL, PornURL, PornPatchURL, BundleURL, ManifestURL, a Subscription
+	// source URL or a Default*URL; object storage URLs as written or as resolved to HTTPS)
+	// or a hostname; a URL key that matches no source fails Validate. Values are
+	// hex SHA-256 hashes of a certificate or of its SubjectPublicKeyInfo. A download succeeds
+	// only if the verified chain contains a pinned certificate. Pinned URLs must use https.
+	PinnedCertSHA256 map[string][]string `json:"pinned_cert_sha256,omitempty"`
 
 	// Global proxy mode
-	IsGlobal     bool   // true = global proxy mode, false = rule-based mode
-	GlobalTarget Target // Target for global mode (default: TargetProxy)
+	IsGlobal     bool   `json:"is_global"`     // true = global proxy mode, false = rule-based mode
+	GlobalTarget Target `json:"global_target"` // Target for global mode (default: TargetProxy)
+
+	// Shadow evaluation (see ShadowReport): when > 0, rule updates downloaded from RuleURL are
+	// staged as pending instead of activated, and this fraction (0..1] of Match calls is also
+	// evaluated against the pending rules. Activate with PromotePendingRules.
+	ShadowSampleRate float64 `json:"shadow_sample_rate,omitempty"`
+
+	// UpdateStrategy controls how rule updates downloaded from RuleURL are activated
+	// (zero value = Immediate; see CanaryStrategy).
+	UpdateStrategy UpdateStrategy `json:"update_strategy"`
+
+	// StickyTTL pins each domain's rule decision for this long after a lookup (refreshed on
+	// access), so rule reloads don't flip targets under long-lived connections. 0 = disabled.
+	// TmpRule and global mode still take precedence. See Unstick.
+	StickyTTL Duration `json:"sticky_ttl,omitempty"`
+
+	// RegistrableCache caches domain rule lookups by registrable domain, so
+	// "a.cdn.example.com" and "b.cdn.example.com" share the "example.com" entry when the
+	// rule file decides the whole site alike. Sized by Caches[CacheRegistrable].
+	RegistrableCache bool `json:"registrable_cache,omitempty"`
+
+	// FailClosed makes Match return TargetReject while no rule data is loaded (no cache and
+	// the download not yet succeeded, or a rule file failed to load) instead of proxying all
+	// traffic. LAN IPs, source domains, TmpRules and global mode are unaffected. See Health.
+	FailClosed bool `json:"fail_closed,omitempty"`
+
+	// UnknownInputTarget routes inputs that are neither IP addresses nor valid domain names
+	// (empty strings, URLs, "host:port", garbage). nil = the rule fallback, as for domains no
+	// rule matches. TmpRules and global mode still take precedence.
+	UnknownInputTarget *Target `json:"unknown_input_target,omitempty"`
+
+	// DomainFallback and IPFallback override the rule file's fallback for unmatched domains
+	// and unmatched IP addresses respectively, e.g. PROXY for domains but DIRECT for raw IPs
+	// (usually LAN or CDN traffic). nil = the file fallback. The active network profile's
+	// Fallback still takes precedence; UnknownInputTarget applies to invalid inputs.
+	DomainFallback *Target `json:"domain_fallback,omitempty"`
+	IPFallback     *Target `json:"ip_fallback,omitempty"`
+
+	// MatchEventRate is the fraction (0..1] of Match decisions published as EventMatch
+	// to subscribers (see Subscribe). 0 = none.
+	MatchEventRate float64 `json:"match_event_rate,omitempty"`
+
+	// URL shortener expansion for MatchExpanded (nil URLExpander = disabled)
+	URLExpander    URLExpander `json:"-"`                         // Resolves one shortener hop, e.g. NewHTTPURLExpander(nil)
+	ShortenerHosts []string    `json:"shortener_hosts,omitempty"` // Hosts to expand (empty = DefaultShortenerHosts)
+	ExpandTimeout  Duration    `json:"expand_timeout,omitempty"`  // Total expansion timeout per call (0 = 3s)
+
+	// Reverse DNS for IP inputs: when enabled, Match looks up the PTR name of an IP (bounded
+	// by RDNSTimeout, cached for RDNSCacheTTL including failures) and applies domain rules
+	// to it before IP-CIDR and GeoIP rules. Improves accuracy for cloud-hosted services.
+	EnableRDNS   bool     `json:"enable_rdns,omitempty"`
+	RDNSTimeout  Duration `json:"rdns_timeout,omitempty"`   // PTR lookup timeout (0 = 200ms)
+	RDNSCacheTTL Duration `json:"rdns_cache_ttl,omitempty"` // Cache lifetime of PTR answers (0 = 10m)
+
+	// Caches sizes the decision caches by name (CacheSticky, CacheRDNS, CacheTmpRules,
+	// CacheRegistrable); caches not listed are unbounded and only drop expired entries. See CacheStats.
+	Caches map[string]CacheConfig `json:"caches,omitempty"`
+
+	// HookLimits bound the external calls triggered by Match by hook name (HookRDNS,
+	// HookReject) with a rate limit and a circuit breaker; hooks not listed use their
+	// defaults. See HookStats.
+	HookLimits map[string]HookLimit `json:"hook_limits,omitempty"`
+
+	// HitStats enables periodic top-N reports of matched domains/networks (nil = disabled)
+	HitStats *HitStatsConfig `json:"hit_stats,omitempty"`
+
+	// NetworkProfiles adjust routing per network; the first profile selected by the
+	// context reported with SetNetworkContext is active
+	NetworkProfiles []NetworkProfile `json:"network_profiles,omitempty"`
+
+	// KeywordRules route domains containing a keyword (see SetKeywordRules)
+	KeywordRules []KeywordRule `json:"keyword_rules,omitempty"`
+
+	// Telemetry enables opt-in sampling of unmatched domains for rule maintainers (nil = disabled)
+	Telemetry *TelemetryConfig `json:"telemetry,omitempty"`
+
+	// OnReject is notified asynchronously whenever Match or MatchVerbose returns
+	// TargetReject, e.g. to alert parents of blocked content (nil = disabled). It runs
+	// on one background goroutine; decisions beyond OnRejectLimit per minute, or while
+	// the handler falls behind, are dropped.
+	OnReject      RejectHandler `json:"-"`
+	OnRejectLimit int           `json:"on_reject_limit,omitempty"` // Max OnReject calls per minute (0 = 60)
+
+	// OnProgress is called during downloads with the bytes transferred so far and the
+	// total (-1 = unknown), e.g. to show a progress bar on first launch (nil = disabled).
+	// It runs on the downloading goroutine, so it must return quickly.
+	OnProgress ProgressHandler `json:"-"`
+
+	// OnPanic is called when a background download/update goroutine recovers from a panic
+	// (nil = log only). The panic never crashes the host process either way.
+	OnPanic PanicHandler `json:"-"`
 }
 
 // Validate checks for configuration conflicts.
@@ -40,6 +213,9 @@ type Config struct {
 // - Both RuleURL and RuleFile are set
 // - Both GeoIPURL and GeoIPFile are set
 // - Both PornURL and PornFile are set
+// - BundleURL or ManifestURL is combined with another source URL
+// - GeoIPMaxMind is incomplete or combined with GeoIPURL, GeoIPAuth, BundleURL or ManifestURL
+// - Subscription is invalid or combined with RuleURL, BundleURL or ManifestURL
 func (c *Config) Validate() error {
 	if c.CacheDir == "" {
 		return fmt.Errorf("CacheDir is required")
@@ -53,6 +229,96 @@ func (c *Config) Validate() error {
 	if c.PornURL != "" && c.PornFile != "" {
 		return fmt.Errorf("cannot specify both PornURL and PornFile")
 	}
+	if c.BundleURL != "" && c.ManifestURL != "" {
+		return fmt.Errorf("cannot specify both BundleURL and ManifestURL")
+	}
+	if (c.BundleURL != "" || c.ManifestURL != "") && (c.RuleURL != "" || c.GeoIPURL != "" || c.PornURL != "" || c.PornPatchURL != "") {
+		return fmt.Errorf("cannot combine BundleURL or ManifestURL with RuleURL, GeoIPURL, PornURL or PornPatchURL")
+	}
+	if c.GeoIPMaxMind != nil {
+		if c.GeoIPURL != "" || c.GeoIPAuth != nil || c.BundleURL != "" || c.ManifestURL != "" {
+			return fmt.Errorf("cannot combine GeoIPMaxMind with GeoIPURL, GeoIPAuth, BundleURL or ManifestURL")
+		}
+		if err := c.GeoIPMaxMind.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Subscription != nil {
+		if c.RuleURL != "" || c.BundleURL != "" || c.ManifestURL != "" {
+			return fmt.Errorf("cannot combine Subscription with RuleURL, BundleURL or ManifestURL")
+		}
+		if err := c.Subscription.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ShadowSampleRate < 0 || c.ShadowSampleRate > 1 {
+		return fmt.Errorf("ShadowSampleRate must be between 0 and 1")
+	}
+	if c.MatchEventRate < 0 || c.MatchEventRate > 1 {
+		return fmt.Errorf("MatchEventRate must be between 0 and 1")
+	}
+	if c.UpdateJitter < 0 || c.UpdateJitter >= 1 {
+		return fmt.Errorf("UpdateJitter must be at least 0 and less than 1")
+	}
+	if c.DisableUserAgent && c.UserAgent != "" {
+		return fmt.Errorf("cannot specify both UserAgent and DisableUserAgent")
+	}
+	if err := c.DownloadTimeout.validate(); err != nil {
+		return err
+	}
+	for component, timeout := range c.ComponentDownloadTimeouts {
+		switch component {
+		case ComponentRules, ComponentGeoIP, ComponentPorn, ComponentBundle, ComponentManifest, ComponentSubscription:
+		default:
+			return fmt.Errorf("unknown component %q in ComponentDownloadTimeouts", component)
+		}
+		if err := timeout.validate(); err != nil {
+			return fmt.Errorf("%s: %w", component, err)
+		}
+	}
+	if err := validatePins(c); err != nil {
+		return err
+	}
+	if err := c.ReaderMode.validate(); err != nil {
+		return err
+	}
+	if c.StickyTTL < 0 {
+		return fmt.Errorf("StickyTTL cannot be negative")
+	}
+	if c.RDNSTimeout < 0 || c.RDNSCacheTTL < 0 {
+		return fmt.Errorf("RDNSTimeout and RDNSCacheTTL cannot be negative")
+	}
+	if err := validateCaches(c.Caches); err != nil {
+		return err
+	}
+	if err := validateHookLimits(c.HookLimits); err != nil {
+		return err
+	}
+	if c.OnRejectLimit < 0 {
+		return fmt.Errorf("OnRejectLimit cannot be negative")
+	}
+	if c.HitStats != nil && (c.HitStats.Interval < 0 || c.HitStats.TopN < 0) {
+		return fmt.Errorf("HitStats Interval and TopN cannot be negative")
+	}
+	if _, err := compileNetworkProfiles(c.NetworkProfiles); err != nil {
+		return err
+	}
+	if _, err := compileKeywordRules(c.KeywordRules); err != nil {
+		return err
+	}
+	if c.Telemetry != nil {
+		if err := c.Telemetry.validate(); err != nil {
+			return err
+		}
+	}
+	if canary := c.UpdateStrategy.Canary; canary != nil {
+		if err := canary.validate(); err != nil {
+			return err
+		}
+		if c.ShadowSampleRate > 0 {
+			return fmt.Errorf("cannot combine ShadowSampleRate with a canary UpdateStrategy")
+		}
+	}
 	return nil
 }
 
@@ -68,3 +334,104 @@ func (c *Config) SetDefaults() {
 		c.GlobalTarget = TargetProxy // Default global target
 	}
 }
+
+// DownloadTimeout bounds a database download. Zero fields keep the defaults.
+type DownloadTimeout struct {
+	// Connect bounds establishing a connection: the TCP dial and, separately, the TLS
+	// handshake (0 = transport default). Not applied to custom transports set with
+	// WithHTTPClient unless they are an *http.Transport.
+	Connect Duration `json:"connect,omitempty"`
+	// Total bounds the whole request including reading the body (0 = component default)
+	Total Duration `json:"total,omitempty"`
+}
+
+// validate rejects negative timeouts
+func (t DownloadTimeout) validate() error {
+	if t.Connect < 0 || t.Total < 0 {
+		return fmt.Errorf("DownloadTimeout cannot be negative")
+	}
+	return nil
+}
+
+// downloadTimeout returns the download timeouts of component: its
+// ComponentDownloadTimeouts entry with zero fields taken from DownloadTimeout
+func (c *Config) downloadTimeout(component string) DownloadTimeout {
+	t := c.ComponentDownloadTimeouts[component]
+	if t.Connect == 0 {
+		t.Connect = c.DownloadTimeout.Connect
+	}
+	if t.Total == 0 {
+		t.Total = c.DownloadTimeout.Total
+	}
+	return t
+}
+
+// ReaderMode selects how a loaded rule file is held in memory
+type ReaderMode string
+
+const (
+	// ReaderModeAuto keeps small files (up to 4 MiB decompressed, and a small fraction
+	// of available memory) in the Go heap and memory-maps larger ones. Platforms without
+	// mmap (js/wasm, wasip1, plan9) always load into memory.
+	ReaderModeAuto ReaderMode = "auto"
+	// ReaderModeMmap decompresses to a file in CacheDir and memory-maps it: pages are
+	// shared between processes and can be evicted under memory pressure.
+	ReaderModeMmap ReaderMode = "mmap"
+	// ReaderModeInMemory decompresses into the Go heap: no temp file and no page faults,
+	// at the cost of resident memory for the whole file.
+	ReaderModeInMemory ReaderMode = "memory"
+)
+
+// validate rejects unknown modes
+func (m ReaderMode) validate() error {
+	switch m {
+	case "", ReaderModeAuto, ReaderModeMmap, ReaderModeInMemory:
+		return nil
+	}
+	return fmt.Errorf("unknown ReaderMode %q", string(m))
+}
+
+// sliceMode converts the mode for the slice package
+func (m ReaderMode) sliceMode() slice.Mode {
+	switch m {
+	case ReaderModeMmap:
+		return slice.ModeMmap
+	case ReaderModeInMemory:
+		return slice.ModeInMemory
+	}
+	return slice.ModeAuto
+}
+
+// Duration is a time.Duration that encodes to JSON as a string ("30s", "6h").
+// Decoding also accepts a plain number of nanoseconds for compatibility with time.Duration.
+type Duration time.Duration
+
+// String returns the duration formatted like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON encodes the duration as a string, e.g. "1m30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string ("90s") or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	*d = Duration(n)
+	return nil
+}
@@ -1,7 +1,11 @@
 package k2rule
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig_Validate(t *testing.T) {
@@ -66,6 +70,98 @@ func TestConfig_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "cannot specify both PornURL and PornFile",
 		},
+		{
+			name: "invalid: negative DownloadTimeout",
+			config: &Config{
+				CacheDir:        "/tmp/test",
+				DownloadTimeout: DownloadTimeout{Total: -1},
+			},
+			wantErr: true,
+			errMsg:  "DownloadTimeout cannot be negative",
+		},
+		{
+			name: "invalid: unknown ComponentDownloadTimeouts key",
+			config: &Config{
+				CacheDir:                  "/tmp/test",
+				ComponentDownloadTimeouts: map[string]DownloadTimeout{"rule": {Total: Duration(time.Second)}},
+			},
+			wantErr: true,
+			errMsg:  `unknown component "rule" in ComponentDownloadTimeouts`,
+		},
+		{
+			name: "invalid: unknown ReaderMode",
+			config: &Config{
+				CacheDir:   "/tmp/test",
+				ReaderMode: "mapped",
+			},
+			wantErr: true,
+			errMsg:  `unknown ReaderMode "mapped"`,
+		},
+		{
+			name: "invalid: negative RDNSTimeout",
+			config: &Config{
+				CacheDir:    "/tmp/test",
+				EnableRDNS:  true,
+				RDNSTimeout: Duration(-time.Second),
+			},
+			wantErr: true,
+			errMsg:  "RDNSTimeout and RDNSCacheTTL cannot be negative",
+		},
+		{
+			name: "invalid: Telemetry without a destination",
+			config: &Config{
+				CacheDir:  "/tmp/test",
+				Telemetry: &TelemetryConfig{SampleRate: 0.5},
+			},
+			wantErr: true,
+			errMsg:  "Telemetry requires Endpoint or OnBatch",
+		},
+		{
+			name: "invalid: negative OnRejectLimit",
+			config: &Config{
+				CacheDir:      "/tmp/test",
+				OnRejectLimit: -1,
+			},
+			wantErr: true,
+			errMsg:  "OnRejectLimit cannot be negative",
+		},
+		{
+			name: "invalid: unknown cache",
+			config: &Config{
+				CacheDir: "/tmp/test",
+				Caches:   map[string]CacheConfig{"fakeip": {Policy: CachePolicyLRU}},
+			},
+			wantErr: true,
+			errMsg:  `unknown cache "fakeip" in Caches`,
+		},
+		{
+			name: "invalid: MaxEntries with TTL policy",
+			config: &Config{
+				CacheDir: "/tmp/test",
+				Caches:   map[string]CacheConfig{CacheRDNS: {MaxEntries: 100}},
+			},
+			wantErr: true,
+			errMsg:  "rdns cache: MaxEntries requires the lru or lfu policy",
+		},
+		{
+			name: "valid: bounded caches",
+			config: &Config{
+				CacheDir: "/tmp/test",
+				Caches: map[string]CacheConfig{
+					CacheSticky: {Policy: CachePolicyLFU, MaxEntries: 500},
+					CacheRDNS:   {Policy: CachePolicyLRU},
+				},
+			},
+		},
+		{
+			name: "invalid: UpdateJitter of 1",
+			config: &Config{
+				CacheDir:     "/tmp/test",
+				UpdateJitter: 1,
+			},
+			wantErr: true,
+			errMsg:  "UpdateJitter must be at least 0 and less than 1",
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,3 +258,83 @@ func TestConfig_SetDefaults(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_JSONRoundTrip(t *testing.T) {
+	config := &Config{
+		RuleURL:      "https://mirror.example.com/rules.k2r.gz",
+		RuleAuth:     &SourceAuth{Username: "user", Password: "pass", BearerToken: func() (string, error) { return "t", nil }},
+		GeoIPFile:    "/data/GeoLite2-Country.mmdb",
+		Antiporn:     true,
+		CacheDir:     "/tmp/k2rule",
+		IsGlobal:     true,
+		GlobalTarget: TargetReject,
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"global_target":"REJECT"`) {
+		t.Errorf("global_target not encoded by name: %s", data)
+	}
+	if strings.Contains(string(data), "BearerToken") {
+		t.Errorf("BearerToken should not be encoded: %s", data)
+	}
+
+	var decoded Config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	config.RuleAuth.BearerToken = nil
+	if !reflect.DeepEqual(&decoded, config) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", decoded, *config)
+	}
+}
+
+func TestConfig_UnmarshalJSON_TargetName(t *testing.T) {
+	var c Config
+	if err := json.Unmarshal([]byte(`{"cache_dir":"/c","is_global":true,"global_target":"direct"}`), &c); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if c.GlobalTarget != TargetDirect || !c.IsGlobal || c.CacheDir != "/c" {
+		t.Errorf("decoded config = %+v", c)
+	}
+
+	if err := json.Unmarshal([]byte(`{"global_target":"bogus"}`), &c); err == nil {
+		t.Error("expected error for invalid target")
+	}
+}
+
+func TestDuration_JSON(t *testing.T) {
+	data, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `"1m30s"` {
+		t.Errorf("Marshal = %s, want \"1m30s\"", data)
+	}
+
+	tests := []struct {
+		input   string
+		want    Duration
+		wantErr bool
+	}{
+		{`"6h"`, Duration(6 * time.Hour), false},
+		{`"1m30s"`, Duration(90 * time.Second), false},
+		{`1000000000`, Duration(time.Second), false},
+		{`"soon"`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got Duration
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !k2rule_nodeprecated
+
+// Deprecated APIs kept until v1.0.0. Build with -tags k2rule_nodeprecated to leave
+// them out, so a consumer's CI can verify it no longer depends on them.
+
+package k2rule
+
+import "net"
+
+// MatchDomain matches a domain and returns the target.
+//
+// Deprecated: Use Match() instead, which automatically detects input type.
+// This function will be removed in v1.0.0.
+func MatchDomain(domain string) Target {
+	return Match(domain)
+}
+
+// MatchIP matches an IP address and returns the target.
+//
+// Deprecated: Use Match() instead, which automatically detects input type
+// and performs GeoIP lookup if initialized.
+// This function will be removed in v1.0.0.
+func MatchIP(ip net.IP) Target {
+	return Match(ip.String())
+}
+
+// MatchGeoIP matches a GeoIP country code and returns the target.
+//
+// Deprecated: GeoIP lookup is now automatic when using Match() with an IP address.
+// Initialize GeoIP with InitGeoIP() and use Match() instead.
+// This function will be removed in v1.0.0.
+func MatchGeoIP(country string) Target {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	matcher := defaultEngine.matcher
+	defaultEngine.mu.RUnlock()
+
+	// Prefer RemoteRuleManager (mmap-based)
+	if manager != nil {
+		return manager.matchGeoIP(country)
+	}
+
+	// Fallback to old matcher
+	if matcher == nil || matcher.reader == nil {
+		return TargetDirect
+	}
+
+	if target := matcher.reader.MatchGeoIP(country); target != nil {
+		return Target(*target)
+	}
+
+	return Target(matcher.reader.Fallback())
+}
@@ -0,0 +1,36 @@
+//go:build !k2rule_nodeprecated
+
+package k2rule
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchDomain_Deprecated(t *testing.T) {
+	// Test deprecated MatchDomain function
+	target := MatchDomain("google.com")
+	// Without rules, should return Direct
+	if target != TargetDirect {
+		t.Logf("MatchDomain(google.com) = %s", target)
+	}
+}
+
+func TestMatchIP_Deprecated(t *testing.T) {
+	// Test deprecated MatchIP function
+	ip := net.ParseIP("8.8.8.8")
+	target := MatchIP(ip)
+	// Without rules, should return Direct
+	if target != TargetDirect {
+		t.Logf("MatchIP(8.8.8.8) = %s", target)
+	}
+}
+
+func TestMatchGeoIP_Deprecated(t *testing.T) {
+	// Test deprecated MatchGeoIP function
+	target := MatchGeoIP("US")
+	// Without rules, should return Direct
+	if target != TargetDirect {
+		t.Logf("MatchGeoIP(US) = %s", target)
+	}
+}
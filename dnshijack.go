@@ -0,0 +1,142 @@
+package k2rule
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// DefaultHijackIPs are answers of known DNS poisoning: addresses injected by the
+// GFW for blocked domains, and the reserved 240.0.0.0/4 range that no real
+// service uses. The list is not exhaustive; extend it with DNSHijackConfig.HijackIPs.
+var DefaultHijackIPs = []string{
+	"8.7.198.45",
+	"37.61.54.158",
+	"46.82.174.68",
+	"59.24.3.173",
+	"78.16.49.15",
+	"93.46.8.89",
+	"159.106.121.75",
+	"203.98.7.65",
+	"240.0.0.0/4",
+}
+
+// HijackReason explains why DNSHijackDetector.Check reports an answer as hijacked
+type HijackReason string
+
+const (
+	HijackNone      HijackReason = ""           // Answer looks genuine
+	HijackListedIP  HijackReason = "hijack-ip"  // Answer in the hijack IP list
+	HijackPrivateIP HijackReason = "private-ip" // Private or loopback answer for a public domain
+)
+
+// HijackSignal is the verdict of DNSHijackDetector.Check
+type HijackSignal struct {
+	Reason HijackReason `json:"reason,omitempty"` // HijackNone = not hijacked
+	IP     net.IP       `json:"ip,omitempty"`     // First offending answer
+}
+
+// Hijacked reports whether the answer was detected as poisoned or hijacked
+func (s HijackSignal) Hijacked() bool {
+	return s.Reason != HijackNone
+}
+
+// DNSHijackConfig configures a DNSHijackDetector
+type DNSHijackConfig struct {
+	// HijackIPs lists IPs and CIDRs of poisoned answers (nil = DefaultHijackIPs).
+	// Pass append(k2rule.DefaultHijackIPs, ...) to extend the defaults.
+	HijackIPs []string `json:"hijack_ips,omitempty"`
+
+	// PrivateDomains lists domain suffixes allowed to resolve to private IPs, e.g.
+	// split-horizon company domains ("corp.example.com")
+	PrivateDomains []string `json:"private_domains,omitempty"`
+
+	// AllowPrivate disables the private-ip check (only HijackIPs are reported)
+	AllowPrivate bool `json:"allow_private,omitempty"`
+}
+
+// DNSHijackDetector detects poisoned or hijacked DNS answers, so callers can
+// re-resolve the domain through the proxy or route it to PROXY. Safe for
+// concurrent use.
+//
+// Example:
+//
+//	detector, _ := k2rule.NewDNSHijackDetector(k2rule.DNSHijackConfig{})
+//	if detector.Check(domain, answers).Hijacked() {
+//	    k2rule.SetTmpRule(domain, k2rule.TargetProxy)
+//	}
+type DNSHijackDetector struct {
+	prefixes       []netip.Prefix
+	privateDomains []string
+	allowPrivate   bool
+}
+
+// NewDNSHijackDetector returns a detector for config. Returns an error for an
+// invalid IP, CIDR or domain.
+func NewDNSHijackDetector(config DNSHijackConfig) (*DNSHijackDetector, error) {
+	hijackIPs := config.HijackIPs
+	if hijackIPs == nil {
+		hijackIPs = DefaultHijackIPs
+	}
+	d := &DNSHijackDetector{allowPrivate: config.AllowPrivate}
+	for _, s := range hijackIPs {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			d.prefixes = append(d.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(s); err == nil {
+			d.prefixes = append(d.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			return nil, fmt.Errorf("invalid hijack IP %q", s)
+		}
+	}
+	for _, s := range config.PrivateDomains {
+		domain := normalizeOverlayDomain(s)
+		if !isValidDomain(domain) {
+			return nil, fmt.Errorf("invalid private domain %q", s)
+		}
+		d.privateDomains = append(d.privateDomains, domain)
+	}
+	return d, nil
+}
+
+// Check inspects the answers of a DNS query for domain. An answer is hijacked when
+// it is in the hijack IP list, or when it is a private or loopback IP (see Match)
+// and domain is public: not a single label, not under a local network suffix
+// (.local, .lan, .internal, …), PrivateDomains or the DNS suffix of
+// SetNetworkContext, and not an AddHost/HostsFile name.
+func (d *DNSHijackDetector) Check(domain string, answers []net.IP) HijackSignal {
+	domain = normalizeOverlayDomain(domain)
+	checkPrivate := !d.allowPrivate && d.isPublic(domain)
+	for _, ip := range answers {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		if ContainsIP(d.prefixes, addr) {
+			return HijackSignal{Reason: HijackListedIP, IP: ip}
+		}
+		if checkPrivate && isPrivateIP(ip) {
+			return HijackSignal{Reason: HijackPrivateIP, IP: ip}
+		}
+	}
+	return HijackSignal{}
+}
+
+// isPublic reports whether domain is expected to resolve to public IPs only
+func (d *DNSHijackDetector) isPublic(domain string) bool {
+	if !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return false
+	}
+	for _, suffixes := range [][]string{privateSuffixes, d.privateDomains} {
+		for _, suffix := range suffixes {
+			if hasDomainSuffix(domain, suffix) {
+				return false
+			}
+		}
+	}
+	if ctx, _ := ActiveNetworkProfile(); ctx.DNSSuffix != "" && hasDomainSuffix(domain, strings.ToLower(ctx.DNSSuffix)) {
+		return false
+	}
+	_, isHost := globalHosts.lookup(domain)
+	return !isHost
+}
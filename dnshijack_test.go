@@ -0,0 +1,81 @@
+package k2rule
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDNSHijackDetector_Check(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	detector, err := NewDNSHijackDetector(DNSHijackConfig{PrivateDomains: []string{"corp.example.com"}})
+	if err != nil {
+		t.Fatalf("NewDNSHijackDetector failed: %v", err)
+	}
+	AddHost("nas.home.example", net.ParseIP("192.168.1.10"))
+	SetNetworkContext(NetworkContext{DNSSuffix: "office.example.org"})
+
+	tests := []struct {
+		domain  string
+		answers []string
+		want    HijackReason
+	}{
+		{"www.google.com", []string{"142.250.80.4"}, HijackNone},
+		{"www.google.com", []string{"142.250.80.4", "59.24.3.173"}, HijackListedIP},
+		{"www.youtube.com", []string{"243.185.187.39"}, HijackListedIP}, // 240.0.0.0/4
+		{"twitter.com", []string{"127.0.0.1"}, HijackPrivateIP},
+		{"Twitter.com.", []string{"10.0.0.1"}, HijackPrivateIP},
+		{"wiki.corp.example.com", []string{"10.0.0.1"}, HijackNone},    // PrivateDomains
+		{"printer.local", []string{"192.168.1.5"}, HijackNone},         // local suffix
+		{"router", []string{"192.168.1.1"}, HijackNone},                // single label
+		{"nas.home.example", []string{"192.168.1.10"}, HijackNone},     // AddHost
+		{"git.office.example.org", []string{"172.16.0.9"}, HijackNone}, // network DNS suffix
+		{"router.local", []string{"59.24.3.173"}, HijackListedIP},      // listed everywhere
+		{"example.com", nil, HijackNone},
+	}
+	for _, tt := range tests {
+		answers := make([]net.IP, len(tt.answers))
+		for i, s := range tt.answers {
+			answers[i] = net.ParseIP(s)
+		}
+		signal := detector.Check(tt.domain, answers)
+		if signal.Reason != tt.want || signal.Hijacked() != (tt.want != HijackNone) {
+			t.Errorf("Check(%s, %v) = %+v, want %q", tt.domain, tt.answers, signal, tt.want)
+		}
+		if signal.Hijacked() && signal.IP.String() != tt.answers[len(tt.answers)-1] {
+			t.Errorf("Check(%s, %v).IP = %s, want the offending answer", tt.domain, tt.answers, signal.IP)
+		}
+	}
+}
+
+func TestNewDNSHijackDetector_Config(t *testing.T) {
+	detector, err := NewDNSHijackDetector(DNSHijackConfig{
+		HijackIPs:    []string{"198.51.100.7", "2001:db8::/32"},
+		AllowPrivate: true,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSHijackDetector failed: %v", err)
+	}
+	for answer, want := range map[string]HijackReason{
+		"198.51.100.7":   HijackListedIP,
+		"2001:db8::1":    HijackListedIP,
+		"59.24.3.173":    HijackNone, // defaults replaced
+		"192.168.0.1":    HijackNone, // AllowPrivate
+		"198.51.100.8":   HijackNone,
+		"::ffff:1.2.3.4": HijackNone,
+	} {
+		if got := detector.Check("example.com", []net.IP{net.ParseIP(answer)}).Reason; got != want {
+			t.Errorf("Check(%s) = %q, want %q", answer, got, want)
+		}
+	}
+
+	for _, config := range []DNSHijackConfig{
+		{HijackIPs: []string{"not-an-ip"}},
+		{PrivateDomains: []string{"bad domain"}},
+	} {
+		if _, err := NewDNSHijackDetector(config); err == nil {
+			t.Errorf("NewDNSHijackDetector(%+v) succeeded", config)
+		}
+	}
+}
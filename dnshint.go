@@ -0,0 +1,80 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// DNSHint is a set of rule-author hints for DNS components about a domain, carried by
+// the domain slice of the rule file that matched it (MatchResult.DNS). Hints are
+// advice: k2rule itself never resolves names.
+type DNSHint uint8
+
+const (
+	// DNSHintRemoteDNS asks to resolve the domain through the remote (proxy-side) DNS
+	DNSHintRemoteDNS = DNSHint(slice.DNSHintRemoteDNS)
+	// DNSHintFakeIP allows answering the domain with a fake IP
+	DNSHintFakeIP = DNSHint(slice.DNSHintFakeIP)
+	// DNSHintRealIP requires a real IP for the domain (never a fake IP), e.g. for
+	// services that check the resolved address
+	DNSHintRealIP = DNSHint(slice.DNSHintRealIP)
+)
+
+// dnsHintNames are the names of the hint bits, lowest bit first
+var dnsHintNames = []struct {
+	hint DNSHint
+	name string
+}{
+	{DNSHintRemoteDNS, "remote_dns"},
+	{DNSHintFakeIP, "fake_ip"},
+	{DNSHintRealIP, "real_ip"},
+}
+
+// Has reports whether all hints of h2 are set
+func (h DNSHint) Has(h2 DNSHint) bool {
+	return h&h2 == h2
+}
+
+// Names returns the names of the set hints ("remote_dns", "fake_ip", "real_ip")
+func (h DNSHint) Names() []string {
+	var names []string
+	for _, n := range dnsHintNames {
+		if h&n.hint != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// MarshalJSON encodes the hints as a list of names
+func (h DNSHint) MarshalJSON() ([]byte, error) {
+	names := h.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON accepts a list of hint names
+func (h *DNSHint) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("invalid DNS hints: %s", data)
+	}
+	*h = 0
+	for _, name := range names {
+		found := false
+		for _, n := range dnsHintNames {
+			if n.name == name {
+				*h |= n.hint
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid DNS hint: %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package k2rule
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/filelock"
+)
+
+// SourceAuth configures authentication for a private rule/GeoIP/porn mirror.
+//
+// Credentials are applied to each request only. They are never part of the
+// cache key (cache files are named by URL hash) and never logged, and they are
+// dropped when the source redirects to another host.
+type SourceAuth struct {
+	// Headers are static headers added to every request (e.g. "X-Api-Key").
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Username/Password enable HTTP Basic authentication when Username is set.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// BearerToken is called before every request and its result is sent as
+	// "Authorization: Bearer <token>". Use it to refresh short-lived tokens.
+	// Takes precedence over Basic authentication. Not serialized.
+	BearerToken func() (string, error) `json:"-"`
+
+	// ObjectStorage signs requests with AWS Signature Version 4, for private
+	// s3://, gs:// and oss:// sources (see ObjectStorageAuth).
+	ObjectStorage *ObjectStorageAuth `json:"object_storage,omitempty"`
+
+	// Signer is called after all other credentials are applied, e.g. to sign
+	// requests for a provider with its own scheme. Not serialized.
+	Signer RequestSigner `json:"-"`
+}
+
+// apply adds the configured credentials to req
+func (a *SourceAuth) apply(req *http.Request) error {
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+	if a.BearerToken != nil {
+		token, err := a.BearerToken()
+		if err != nil {
+			return fmt.Errorf("failed to get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	if a.ObjectStorage != nil {
+		a.ObjectStorage.sign(req, time.Now())
+	}
+	if a.Signer != nil {
+		if err := a.Signer.SignRequest(req); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
+	return nil
+}
+
+// changedHeaders returns the names of the headers of after that differ from before
+func changedHeaders(before, after http.Header) []string {
+	var names []string
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// redirectPolicy returns the CheckRedirect of a download. The SourceAuth headers
+// (authHeaders) are dropped when a redirect leaves the host of the original request;
+// Go only drops Authorization and Cookie itself. Pinned downloads must stay on https.
+func redirectPolicy(authHeaders []string, pinned bool) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		if pinned && req.URL.Scheme != "https" {
+			return fmt.Errorf("pinned URL redirected to non-https URL")
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for _, name := range authHeaders {
+				req.Header.Del(name)
+			}
+		}
+		return nil
+	}
+}
+
+// progressInterval is the number of downloaded bytes between OnProgress reports
+const progressInterval = 64 << 10
+
+// ProgressHandler is notified while a component downloads: bytesDone bytes of the
+// response body (as transferred, i.e. before gunzip) out of total (-1 = unknown,
+// no Content-Length). component is "rules", "geoip", "porn", "bundle" or "manifest".
+type ProgressHandler func(component string, bytesDone, total int64)
+
+// downloader performs conditional GET downloads into the cache directory.
+// Shared by RemoteRuleManager, GeoIPManager and PornRemoteManager.
+type downloader struct {
+	timeout   time.Duration
+	auth      *SourceAuth         // optional
+	pins      map[string][]string // optional certificate pins (Config.PinnedCertSHA256)
+	userAgent string              // "" = no User-Agent header
+	transport http.RoundTripper   // nil = http.DefaultTransport
+	component string              // Component name reported to progress
+	progress  ProgressHandler     // optional (Config.OnProgress)
+}
+
+// newDownloader creates a downloader with the given total request timeout
+func newDownloader(timeout time.Duration) *downloader {
+	return &downloader{timeout: timeout, userAgent: DefaultUserAgent()}
+}
+
+// configure applies the shared download settings of config, the download timeouts
+// of component and the source's credentials
+func (d *downloader) configure(config *Config, component string, auth *SourceAuth) {
+	d.setTimeout(config.downloadTimeout(component))
+	d.auth = auth
+	d.pins = config.PinnedCertSHA256
+	d.component = component
+	d.progress = config.OnProgress
+	switch {
+	case config.DisableUserAgent:
+		d.userAgent = ""
+	case config.UserAgent != "":
+		d.userAgent = config.UserAgent
+	}
+}
+
+// setTimeout applies the non-zero fields of t
+func (d *downloader) setTimeout(t DownloadTimeout) {
+	if t.Total > 0 {
+		d.timeout = time.Duration(t.Total)
+	}
+	if t.Connect > 0 {
+		d.transport = connectTransport(d.transport, time.Duration(t.Connect))
+	}
+}
+
+// connectTransport returns a copy of base whose dials and TLS handshakes each time out
+// after timeout. Transports other than *http.Transport are returned unchanged.
+func connectTransport(base http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	t = t.Clone()
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	t.DialContext = dialer.DialContext
+	t.TLSHandshakeTimeout = timeout
+	return t
+}
+
+// fetch downloads rawURL into destPath (via a .tmp file + atomic rename).
+// Object storage URLs (s3://, gs://, oss://) are fetched from their HTTPS endpoint.
+// The write and rename hold a cross-process lock on destPath+".lock".
+// If etag is non-empty it is sent as If-None-Match; a 304 response returns modified=false
+// and leaves destPath untouched. When gunzip is true the body is decompressed while writing.
+func (d *downloader) fetch(rawURL, etag, destPath string, gunzip bool) (newETag string, modified bool, err error) {
+	return d.fetchValidated(rawURL, etag, destPath, gunzip, nil)
+}
+
+// fetchValidated is fetch with a check of the downloaded file before it replaces destPath
+// (validate nil = none); a validation error leaves destPath untouched.
+func (d *downloader) fetchValidated(rawURL, etag, destPath string, gunzip bool, validate func(path string) error) (newETag string, modified bool, err error) {
+	sourceURL := rawURL
+	rawURL, err = resolveObjectURL(rawURL, d.auth)
+	if err != nil {
+		return "", false, err
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// ETag optimization: 304 Not Modified
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	// An empty value suppresses Go's default User-Agent; SourceAuth.Headers may override it per source
+	req.Header.Set("User-Agent", d.userAgent)
+
+	var authHeaders []string
+	if d.auth != nil {
+		before := req.Header.Clone()
+		if err := d.auth.apply(req); err != nil {
+			return "", false, err
+		}
+		authHeaders = changedHeaders(before, req.Header)
+	}
+
+	client := &http.Client{Timeout: d.timeout, Transport: d.transport}
+	pins := pinsFor(d.pins, sourceURL, rawURL)
+	client.CheckRedirect = redirectPolicy(authHeaders, pins != nil)
+	if pins != nil {
+		// Pinned sources must stay on TLS, including across redirects
+		if req.URL.Scheme != "https" {
+			return "", false, fmt.Errorf("pinned URL must use https")
+		}
+		transport, err := pinnedTransport(d.transport, pins)
+		if err != nil {
+			return "", false, err
+		}
+		client.Transport = transport
+		defer client.CloseIdleConnections()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 304 Not Modified - no need to update
+	if resp.StatusCode == http.StatusNotModified {
+		return etag, false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	// Serialize writers across processes sharing the cache directory
+	lock, err := filelock.Acquire(destPath + ".lock")
+	if err != nil {
+		return "", false, err
+	}
+	defer lock.Release()
+
+	// Download to a uniquely named temporary file
+	tmpFile, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	var body io.Reader = resp.Body
+	if d.progress != nil {
+		pr := &progressReader{r: resp.Body, component: d.component, total: resp.ContentLength, report: d.progress}
+		pr.notify()
+		defer pr.notify()
+		body = pr
+	}
+	if gunzip {
+		gzReader, err := gzip.NewReader(body)
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+			return "", false, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzReader.Close()
+		body = gzReader
+	}
+
+	_, err = io.Copy(tmpFile, body)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", false, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if validate != nil {
+		if err := validate(tmpPath); err != nil {
+			os.Remove(tmpPath)
+			return "", false, fmt.Errorf("download rejected by validator: %w", err)
+		}
+	}
+
+	// Atomic rename (overwrite old cache)
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return "", false, fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return resp.Header.Get("ETag"), true, nil
+}
+
+// progressReader reports the bytes read through it to a ProgressHandler, every
+// progressInterval bytes and on notify
+type progressReader struct {
+	r         io.Reader
+	component string
+	done      int64
+	total     int64
+	reported  int64
+	report    ProgressHandler
+}
+
+// Read reads from the underlying reader, reporting progress
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if p.done-p.reported >= progressInterval {
+		p.notify()
+	}
+	return n, err
+}
+
+// notify reports the current progress; a panicking handler doesn't fail the download
+func (p *progressReader) notify() {
+	p.reported = p.done
+	safeCall(p.component, func() error {
+		p.report(p.component, p.done, p.total)
+		return nil
+	})
+}
+
+// redactURL returns rawURL with any userinfo password masked, for logging
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
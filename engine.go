@@ -0,0 +1,258 @@
+package k2rule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Engine is an independent rule set: its own configuration, rule, GeoIP and porn
+// databases (whose download hosts it routes DIRECT), global mode, TmpRules and pinned
+// decisions (StickyTTL). The package-level
+// functions (Init, Match, SetTmpRule, ...) use a default engine; NewEngine creates more,
+// e.g. for per-profile routing in a multi-tenant proxy.
+//
+// Process-wide features are shared by all engines and configured by Init only: the user
+// overlay and category policies, hosts, network profiles, keyword rules, middleware,
+// experiments, the rDNS and registrable caches, and hook limits. Hit stats, telemetry,
+// reject notifications and events only record the default engine.
+//
+// Example:
+//
+//	work, err := k2rule.NewEngine(&k2rule.Config{RuleFile: "work.k2r.gz", CacheDir: dir})
+//	if err != nil {
+//	    return err
+//	}
+//	defer work.Close()
+//	target := work.Match("intranet.example.com")
+type Engine struct {
+	mu              sync.RWMutex
+	config          *Config // Single source of truth for configuration
+	manager         *RemoteRuleManager
+	geoIPMgr        *GeoIPManager
+	pornManager     *PornRemoteManager
+	bundleMgr       *BundleManager
+	manifestMgr     *ManifestManager
+	subscriptionMgr *SubscriptionManager
+	matcher         *Matcher
+	sources         *sourceDomains // Download source hosts (always DIRECT)
+
+	state    atomic.Pointer[engineState] // Published snapshot read by Match (nil = none yet)
+	tmpRules tmpRuleStore                // SetTmpRule overrides
+	sticky   stickyCache                 // Pinned decisions (Config.StickyTTL)
+}
+
+// defaultEngine backs the package-level functions
+var defaultEngine = &Engine{}
+
+// NewEngine creates an engine with its own rule set, loading the sources of config
+// like Init. Settings of process-wide features (see Engine) are ignored.
+func NewEngine(config *Config) (*Engine, error) {
+	if config == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	e := &Engine{}
+	if err := e.initialize(config); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Close stops the background updates of e and releases its databases (after a grace
+// period for Match calls in progress). Match then returns DIRECT as before Init.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	managers := e.installedManagers()
+	e.config, e.manager, e.geoIPMgr, e.pornManager = nil, nil, nil, nil
+	e.bundleMgr, e.manifestMgr, e.subscriptionMgr, e.matcher = nil, nil, nil, nil
+	e.sources = nil
+	e.publishState()
+	e.mu.Unlock()
+
+	retireManagers(managers, managerSet{})
+	e.tmpRules.clear()
+	e.sticky.entries.Clear()
+	return nil
+}
+
+// UpdateConfig re-initializes e with config (see the package-level UpdateConfig)
+func (e *Engine) UpdateConfig(config *Config) error {
+	if config == nil {
+		return fmt.Errorf("config cannot be nil")
+	}
+	if e == defaultEngine {
+		return Init(config)
+	}
+	return e.initialize(config)
+}
+
+// Config returns a copy of the configuration of e (zero before Init)
+func (e *Engine) Config() Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.config == nil {
+		return Config{}
+	}
+
+	// Return a copy to prevent external modification
+	return *e.config
+}
+
+// Match returns the target of input (see the package-level Match)
+func (e *Engine) Match(input string) Target {
+	return e.match(input).Target
+}
+
+// MatchVerbose is like Match but also returns details of the decision (see the
+// package-level MatchVerbose)
+func (e *Engine) MatchVerbose(input string) MatchResult {
+	return e.matchVerbose(input)
+}
+
+// IsPorn checks if a domain is a porn domain with the porn database of e
+func (e *Engine) IsPorn(domain string) bool {
+	return e.isPorn(domain)
+}
+
+// LookupCountry returns the ISO country code of an IP address using the GeoIP
+// database of e
+func (e *Engine) LookupCountry(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	geoIPMgr := e.loadState().geoIPMgr
+	if geoIPMgr == nil {
+		return "", fmt.Errorf("GeoIP not initialized")
+	}
+	return geoIPMgr.LookupCountry(parsed)
+}
+
+// Generation returns the generation of the rule data e decides with (0 = none)
+func (e *Engine) Generation() uint64 {
+	return e.loadState().ruleGeneration()
+}
+
+// ToggleGlobal switches global mode of e on/off (see the package-level ToggleGlobal)
+func (e *Engine) ToggleGlobal(enabled bool) {
+	e.mu.Lock()
+	if e.config == nil {
+		e.mu.Unlock()
+		return
+	}
+	e.config.IsGlobal = enabled
+	target := e.config.GlobalTarget
+	e.publishState()
+	e.mu.Unlock()
+
+	e.publish(Event{Type: EventGlobalMode, IsGlobal: enabled, Target: target})
+}
+
+// SetGlobalTarget sets the global mode target of e
+func (e *Engine) SetGlobalTarget(target Target) {
+	e.mu.Lock()
+	if e.config == nil {
+		e.mu.Unlock()
+		return
+	}
+	e.config.GlobalTarget = target
+	isGlobal := e.config.IsGlobal
+	e.publishState()
+	e.mu.Unlock()
+
+	e.publish(Event{Type: EventGlobalMode, IsGlobal: isGlobal, Target: target})
+}
+
+// SetTmpRule sets a TmpRule of e (see the package-level SetTmpRule)
+func (e *Engine) SetTmpRule(input string, target Target) {
+	// Storage optimization: skip storing if static rules already return the same target
+	// AND global mode is not active (since TmpRule must override Global mode).
+	e.mu.RLock()
+	isGlobal := e.config != nil && e.config.IsGlobal
+	e.mu.RUnlock()
+
+	if !isGlobal {
+		staticTarget := e.matchStaticRules(input)
+		if staticTarget == target {
+			e.tmpRules.delete(input) // clear any existing override
+			e.publish(Event{Type: EventTmpRule, Input: input, Target: target})
+			return
+		}
+	}
+	e.tmpRules.store(input, target)
+	e.publish(Event{Type: EventTmpRule, Input: input, Target: target})
+}
+
+// ClearTmpRule removes a single TmpRule of e
+func (e *Engine) ClearTmpRule(input string) {
+	e.tmpRules.delete(input)
+	e.publish(Event{Type: EventTmpRule, Input: input, Removed: true})
+}
+
+// ClearTmpRules removes all TmpRules of e
+func (e *Engine) ClearTmpRules() {
+	e.tmpRules.clear()
+	e.publish(Event{Type: EventTmpRule, Removed: true})
+}
+
+// TmpRuleCount returns the number of TmpRules of e
+func (e *Engine) TmpRuleCount() int {
+	return e.tmpRules.count()
+}
+
+// Unstick removes the pinned decision of a domain of e (see the package-level Unstick)
+func (e *Engine) Unstick(domain string) {
+	e.sticky.delete(domain)
+}
+
+// UnstickAll removes all pinned decisions of e
+func (e *Engine) UnstickAll() {
+	e.sticky.entries.Clear()
+}
+
+// ImportTmpRules sets TmpRules of e from a list (see the package-level ImportTmpRules)
+func (e *Engine) ImportTmpRules(r io.Reader, target Target) (n int, err error) {
+	var inputs []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if ip := net.ParseIP(text); ip != nil {
+			inputs = append(inputs, ip.String())
+			continue
+		}
+		domain := normalizeOverlayDomain(text)
+		if !isValidDomain(domain) {
+			return 0, fmt.Errorf("invalid TmpRule entry on line %d: %q", line, text)
+		}
+		inputs = append(inputs, domain)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read TmpRules: %w", err)
+	}
+
+	for _, input := range inputs {
+		e.SetTmpRule(input, target)
+	}
+	return len(inputs), nil
+}
+
+// publish delivers an event of e to subscribers (default engine only)
+func (e *Engine) publish(ev Event) {
+	if e == defaultEngine {
+		publish(ev)
+	}
+}
@@ -0,0 +1,136 @@
+package k2rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestEngine creates an engine rejecting domains, with a local GeoIP database
+func newTestEngine(t *testing.T, domains []string) *Engine {
+	t.Helper()
+	dir := t.TempDir()
+	rulePath := filepath.Join(dir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, domains))
+	mmdbPath := filepath.Join(dir, "geo.mmdb")
+	if err := os.WriteFile(mmdbPath, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEngine(&Config{RuleFile: rulePath, GeoIPFile: mmdbPath, CacheDir: dir})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestEngine_Independent(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	a := newTestEngine(t, []string{"a-only.com"})
+	b := newTestEngine(t, []string{"b-only.com"})
+
+	if got := a.Match("a-only.com"); got != TargetReject {
+		t.Errorf("a.Match(a-only.com) = %v, want REJECT", got)
+	}
+	if got := b.Match("a-only.com"); got != TargetDirect {
+		t.Errorf("b.Match(a-only.com) = %v, want DIRECT", got)
+	}
+	if got := Match("a-only.com"); got != TargetDirect {
+		t.Errorf("Match(a-only.com) = %v, want DIRECT (default engine not initialized)", got)
+	}
+
+	a.SetTmpRule("shared.com", TargetProxy)
+	if a.TmpRuleCount() != 1 || b.TmpRuleCount() != 0 || TmpRuleCount() != 0 {
+		t.Errorf("TmpRuleCount = %d/%d/%d, want 1/0/0", a.TmpRuleCount(), b.TmpRuleCount(), TmpRuleCount())
+	}
+	if got := b.Match("shared.com"); got != TargetDirect {
+		t.Errorf("b.Match(shared.com) = %v, want DIRECT (TmpRule of a)", got)
+	}
+
+	b.ToggleGlobal(true)
+	if got := b.Match("anything.com"); got != TargetProxy {
+		t.Errorf("b.Match in global mode = %v, want PROXY", got)
+	}
+	if got := a.Match("anything.com"); got != TargetDirect {
+		t.Errorf("a.Match with b in global mode = %v, want DIRECT", got)
+	}
+
+	if country, err := a.LookupCountry("8.8.8.8"); err != nil || country != "US" {
+		t.Errorf("a.LookupCountry(8.8.8.8) = %q, %v, want US", country, err)
+	}
+	if r := a.MatchVerbose("8.8.8.8"); r.Country != "US" {
+		t.Errorf("a.MatchVerbose(8.8.8.8).Country = %q, want US", r.Country)
+	}
+	if a.Generation() == 0 {
+		t.Error("a.Generation() = 0, want rules loaded")
+	}
+}
+
+func TestEngine_Close(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	e := newTestEngine(t, []string{"blocked.com"})
+	e.SetTmpRule("tmp.com", TargetProxy)
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := e.Match("blocked.com"); got != TargetDirect {
+		t.Errorf("Match after Close = %v, want DIRECT", got)
+	}
+	if e.TmpRuleCount() != 0 || e.Generation() != 0 {
+		t.Errorf("after Close: %d TmpRules, generation %d, want none", e.TmpRuleCount(), e.Generation())
+	}
+}
+
+func TestNewEngine_InvalidConfig(t *testing.T) {
+	if _, err := NewEngine(nil); err == nil {
+		t.Error("NewEngine(nil) succeeded")
+	}
+	if _, err := NewEngine(&Config{RuleFile: filepath.Join(t.TempDir(), "missing.k2r.gz"), CacheDir: t.TempDir()}); err == nil {
+		t.Error("NewEngine with a missing rule file succeeded")
+	}
+}
+
+func TestEngine_SourceDomainsPerEngine(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"porn.example"})))
+	}))
+	defer server.Close()
+	pornURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/porn.k2r.gz"
+
+	dir := t.TempDir()
+	mmdbPath := filepath.Join(dir, "geo.mmdb")
+	if err := os.WriteFile(mmdbPath, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(&Config{IsGlobal: true, GeoIPFile: mmdbPath, Antiporn: true, PornURL: pornURL, CacheDir: dir})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	defer e.Close()
+
+	if err := Init(&Config{IsGlobal: true, GlobalTarget: TargetProxy, GeoIPFile: mmdbPath, CacheDir: t.TempDir()}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if r := e.MatchVerbose("localhost"); r.Target != TargetDirect || r.Reason != ReasonSource {
+		t.Errorf("e.MatchVerbose(localhost) = %v (%s), want DIRECT (source) after default Init", r.Target, r.Reason)
+	}
+	if got := Match("localhost"); got != TargetProxy {
+		t.Errorf("Match(localhost) = %v, want PROXY (source domain of another engine)", got)
+	}
+
+	e.Close()
+	if r := e.MatchVerbose("localhost"); r.Reason == ReasonSource {
+		t.Error("source domains kept after Close")
+	}
+}
@@ -0,0 +1,123 @@
+package k2rule
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of an Event
+type EventType string
+
+// Event types
+const (
+	EventReload     EventType = "reload"      // A component loaded new data (Component, Generation)
+	EventGlobalMode EventType = "global_mode" // ToggleGlobal / SetGlobalTarget (IsGlobal, Target)
+	EventTmpRule    EventType = "tmp_rule"    // SetTmpRule / ClearTmpRule / ClearTmpRules (Input, Target, Removed)
+	EventMatch      EventType = "match"       // Sampled Match decision (Input, Target, Result; see Config.MatchEventRate)
+	EventNetwork    EventType = "network"     // SetNetworkContext / Init changed the active network profile (Profile)
+)
+
+// Event is a state change or decision published to subscribers (see Subscribe).
+// Only the fields listed for its Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	Component  string `json:"component,omitempty"`  // EventReload: ComponentRules, ComponentGeoIP or ComponentPorn
+	Generation uint64 `json:"generation,omitempty"` // EventReload: generation of the new data
+
+	IsGlobal bool `json:"is_global,omitempty"` // EventGlobalMode: global mode after the change
+
+	Input   string      `json:"input,omitempty"`   // EventTmpRule ("" = all rules cleared), EventMatch
+	Target  Target      `json:"target"`            // EventGlobalMode: GlobalTarget; EventTmpRule: override; EventMatch: decision
+	Removed bool        `json:"removed,omitempty"` // EventTmpRule: the override was removed
+	Result  MatchResult `json:"result"`            // EventMatch: decision details
+
+	Profile string `json:"profile,omitempty"` // EventNetwork: active network profile ("" = none)
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []chan<- Event
+	subscribed    atomic.Bool // Fast path: any subscriber registered
+)
+
+// Subscribe registers ch to receive events: component reloads, global mode changes,
+// TmpRule changes and, with Config.MatchEventRate > 0, sampled Match decisions.
+//
+// Events are sent without blocking; when ch is full the event is dropped for this
+// subscriber, so use a buffered channel sized for the expected burst.
+//
+// Example:
+//
+//	events := make(chan k2rule.Event, 64)
+//	k2rule.Subscribe(events)
+//	defer k2rule.Unsubscribe(events)
+//	for e := range events {
+//	    if e.Type == k2rule.EventReload {
+//	        ui.Refresh()
+//	    }
+//	}
+func Subscribe(ch chan<- Event) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, ch)
+	subscribed.Store(true)
+}
+
+// Unsubscribe stops sending events to ch (the channel is not closed)
+func Unsubscribe(ch chan<- Event) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for i, sub := range subscribers {
+		if sub == ch {
+			subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+			break
+		}
+	}
+	subscribed.Store(len(subscribers) > 0)
+}
+
+// publish sends e to all subscribers without blocking
+func publish(e Event) {
+	if !subscribed.Load() {
+		return
+	}
+	e.Time = time.Now()
+
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- e:
+		default: // subscriber is full, drop
+		}
+	}
+}
+
+// publishReload publishes an EventReload for c
+func publishReload(c Component) {
+	if !subscribed.Load() {
+		return
+	}
+	info := c.Status()
+	publish(Event{Type: EventReload, Component: info.Name, Generation: info.Generation})
+}
+
+// publishMatch publishes a sampled EventMatch for a Match decision
+func publishMatch(input string, result MatchResult) {
+	if !subscribed.Load() {
+		return
+	}
+	rate := 0.0
+	if config := defaultEngine.loadState().config; config != nil {
+		rate = config.MatchEventRate
+	}
+
+	if rate <= 0 || rand.Float64() >= rate {
+		return
+	}
+	publish(Event{Type: EventMatch, Input: input, Target: result.Target, Result: result})
+}
@@ -0,0 +1,127 @@
+package k2rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of type typ from ch, skipping others
+func nextEvent(t *testing.T, ch <-chan Event, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event received", typ)
+			return Event{}
+		}
+	}
+}
+
+func TestEvents_GlobalModeAndTmpRules(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	events := make(chan Event, 16)
+	Subscribe(events)
+	defer Unsubscribe(events)
+
+	ToggleGlobal(true)
+	if e := nextEvent(t, events, EventGlobalMode); !e.IsGlobal || e.Target != TargetProxy || e.Time.IsZero() {
+		t.Errorf("ToggleGlobal event = %+v", e)
+	}
+	SetGlobalTarget(TargetReject)
+	if e := nextEvent(t, events, EventGlobalMode); !e.IsGlobal || e.Target != TargetReject {
+		t.Errorf("SetGlobalTarget event = %+v", e)
+	}
+
+	SetTmpRule("example.com", TargetDirect)
+	if e := nextEvent(t, events, EventTmpRule); e.Input != "example.com" || e.Target != TargetDirect || e.Removed {
+		t.Errorf("SetTmpRule event = %+v", e)
+	}
+	ClearTmpRule("example.com")
+	if e := nextEvent(t, events, EventTmpRule); e.Input != "example.com" || !e.Removed {
+		t.Errorf("ClearTmpRule event = %+v", e)
+	}
+	ClearTmpRules()
+	if e := nextEvent(t, events, EventTmpRule); e.Input != "" || !e.Removed {
+		t.Errorf("ClearTmpRules event = %+v", e)
+	}
+}
+
+func TestEvents_MatchSampling(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	events := make(chan Event, 16)
+	Subscribe(events)
+	defer Unsubscribe(events)
+
+	// MatchEventRate 0: no match events
+	Match("example.com")
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event with MatchEventRate 0: %+v", e)
+	default:
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config.MatchEventRate = 1
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	Match("example.com")
+	if e := nextEvent(t, events, EventMatch); e.Input != "example.com" || e.Target != TargetProxy || !e.Result.Fallback {
+		t.Errorf("match event = %+v", e)
+	}
+}
+
+func TestEvents_ReloadAndUnsubscribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})))
+	}))
+	defer server.Close()
+
+	events := make(chan Event, 16)
+	Subscribe(events)
+
+	m := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	defer m.Close()
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if e := nextEvent(t, events, EventReload); e.Component != ComponentRules || e.Generation != 1 {
+		t.Errorf("reload event = %+v", e)
+	}
+
+	// A full subscriber never blocks publishers
+	full := make(chan Event)
+	Subscribe(full)
+	defer Unsubscribe(full)
+	ClearTmpRules()
+
+	Unsubscribe(events)
+	for len(events) > 0 {
+		<-events
+	}
+	ClearTmpRules()
+	if len(events) != 0 {
+		t.Error("event delivered after Unsubscribe")
+	}
+}
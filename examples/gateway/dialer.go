@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kaitu-io/k2rule"
+)
+
+// errRejected is returned for connections k2rule REJECTs
+var errRejected = errors.New("rejected by rules")
+
+// dialer dials connections by the k2rule decision for their host
+type dialer struct {
+	upstream string // Upstream HTTP proxy for PROXY traffic ("" = none)
+	direct   net.Dialer
+}
+
+// DialContext dials addr (host:port) directly, through the upstream proxy or not at
+// all, as k2rule decides for host. THROTTLE and custom targets are dialed directly:
+// enforcing them is up to the embedding application (see SetTargetHandler).
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	result := k2rule.MatchVerbose(host)
+	log.Printf("%s → %s (%s)", addr, result.Target, result.Reason)
+
+	switch result.Target {
+	case k2rule.TargetReject:
+		return nil, errRejected
+	case k2rule.TargetProxy:
+		return d.dialProxy(ctx, addr)
+	default:
+		return d.direct.DialContext(ctx, network, addr)
+	}
+}
+
+// dialProxy opens a tunnel to addr through the upstream proxy (HTTP CONNECT)
+func (d *dialer) dialProxy(ctx context.Context, addr string) (net.Conn, error) {
+	if d.upstream == "" {
+		return nil, fmt.Errorf("no upstream proxy for %s", addr)
+	}
+	conn, err := d.direct.DialContext(ctx, "tcp", d.upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	req := &http.Request{Method: http.MethodConnect, Host: addr, Header: http.Header{}}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write CONNECT request: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy refused %s: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a bufio.Reader that may hold
+// bytes read past the CONNECT response
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kaitu-io/k2rule"
+)
+
+// DNS record types and response codes used by the forwarder
+const (
+	dnsTypeA      = 1
+	dnsTypeAAAA   = 28
+	dnsRcodeNX    = 3
+	dnsHeaderSize = 12
+	dnsTimeout    = 5 * time.Second
+)
+
+// errMalformed is returned for DNS messages the forwarder cannot parse
+var errMalformed = errors.New("malformed DNS message")
+
+// dnsForwarder is a UDP DNS forwarder choosing the resolver of each query by the
+// k2rule decision for its name
+type dnsForwarder struct {
+	dialer *dialer
+	direct string // Resolver for DIRECT domains (UDP)
+	remote string // Resolver for PROXY domains (TCP through the upstream proxy)
+}
+
+// serve answers the queries received on conn until it is closed
+func (f *dnsForwarder) serve(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if reply := f.handle(query); reply != nil {
+				conn.WriteTo(reply, addr)
+			}
+		}()
+	}
+}
+
+// handle returns the reply to query (nil = no reply)
+func (f *dnsForwarder) handle(query []byte) []byte {
+	name, questionEnd, err := parseQuestion(query)
+	if err != nil {
+		return nil
+	}
+	result := k2rule.MatchVerbose(name)
+
+	var reply []byte
+	switch {
+	case result.Target == k2rule.TargetReject:
+		return nxdomain(query, questionEnd)
+	case result.Target == k2rule.TargetProxy || result.DNS.Has(k2rule.DNSHintRemoteDNS):
+		reply, err = f.exchangeRemote(query)
+	default:
+		reply, err = f.exchangeDirect(query)
+	}
+	if err != nil {
+		log.Printf("DNS %s: %v", name, err)
+		return nil
+	}
+
+	// Connections to the answered IPs follow the decision for name
+	if ips, ttl := parseAnswers(reply); len(ips) > 0 {
+		k2rule.BindDomainIPs(name, ips, ttl)
+	}
+	return reply
+}
+
+// exchangeDirect sends query to the direct resolver over UDP
+func (f *dnsForwarder) exchangeDirect(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", f.direct, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// exchangeRemote sends query to the remote resolver over TCP through the upstream proxy
+func (f *dnsForwarder) exchangeRemote(query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	conn, err := f.dialer.dialProxy(ctx, f.remote)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// nxdomain builds an NXDOMAIN reply to query, whose question ends at questionEnd
+func nxdomain(query []byte, questionEnd int) []byte {
+	reply := append([]byte(nil), query[:questionEnd]...)
+	reply[2] = 0x80 | query[2]&0x79 // QR, opcode and RD of the query
+	reply[3] = 0x80 | dnsRcodeNX    // RA
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	binary.BigEndian.PutUint16(reply[6:], 0)
+	binary.BigEndian.PutUint16(reply[8:], 0)
+	binary.BigEndian.PutUint16(reply[10:], 0)
+	return reply
+}
+
+// parseQuestion returns the name of the first question of msg and the offset after it
+func parseQuestion(msg []byte) (string, int, error) {
+	if len(msg) < dnsHeaderSize || binary.BigEndian.Uint16(msg[4:]) == 0 {
+		return "", 0, errMalformed
+	}
+	name, off, err := readName(msg, dnsHeaderSize)
+	if err != nil || off+4 > len(msg) {
+		return "", 0, errMalformed
+	}
+	return name, off + 4, nil
+}
+
+// parseAnswers returns the A and AAAA addresses of the answer section of msg and
+// their smallest TTL
+func parseAnswers(msg []byte) ([]net.IP, time.Duration) {
+	_, off, err := parseQuestion(msg)
+	if err != nil {
+		return nil, 0
+	}
+	var ips []net.IP
+	var minTTL uint32
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[6:])); i++ {
+		if _, off, err = readName(msg, off); err != nil || off+10 > len(msg) {
+			break
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			break
+		}
+		if (rrType == dnsTypeA && length == net.IPv4len) || (rrType == dnsTypeAAAA && length == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte(nil), msg[off:off+length]...)))
+			if minTTL == 0 || ttl < minTTL {
+				minTTL = ttl
+			}
+		}
+		off += length
+	}
+	return ips, time.Duration(minTTL) * time.Second
+}
+
+// readName reads the (possibly compressed) name at off of msg, returning it and the
+// offset after it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for steps := 0; steps < 128; steps++ {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+	return "", 0, errMalformed
+}
@@ -0,0 +1,102 @@
+// Command gateway is a split-tunnel gateway demo: an HTTP proxy and a DNS forwarder
+// that route each connection and query with k2rule, plus the admin API for stats.
+//
+// k2rule only decides; it does not ship a dialer or DNS server. The example
+// implements minimal ones with the standard library (see dialer.go, proxy.go and
+// dns.go) to show how the pieces fit together:
+//
+//   - DIRECT connections are dialed directly, PROXY connections are tunneled through
+//     the upstream HTTP proxy (CONNECT), REJECTed ones are refused (plain HTTP requests
+//     get the block page).
+//   - DNS queries for PROXY domains, or domains with the remote-dns hint, are resolved
+//     by the remote resolver through the upstream proxy (DNS over TCP); others by the
+//     direct resolver. REJECTed domains are answered with NXDOMAIN. Answers are bound
+//     to their domain (BindDomainIPs), so connections by IP follow the domain's decision.
+//
+// Usage:
+//
+//	go run ./examples/gateway -upstream 127.0.0.1:3128 -rules cn_blacklist.k2r.gz
+//
+//	curl -x http://127.0.0.1:8080 https://example.com
+//	dig @127.0.0.1 -p 5353 example.com
+//	curl http://127.0.0.1:9090/v1/stats
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/kaitu-io/k2rule"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8080", "HTTP proxy listen address")
+	dnsAddr := flag.String("dns", "127.0.0.1:5353", "DNS forwarder listen address (\"\" = disabled)")
+	adminAddr := flag.String("admin", "127.0.0.1:9090", "admin API listen address (\"\" = disabled)")
+	upstream := flag.String("upstream", "", "upstream HTTP proxy for PROXY traffic, host:port (\"\" = PROXY traffic fails)")
+	directDNS := flag.String("direct-dns", "223.5.5.5:53", "resolver for DIRECT domains")
+	remoteDNS := flag.String("remote-dns", "8.8.8.8:53", "resolver for PROXY domains, reached through the upstream proxy")
+	rules := flag.String("rules", "", "local rule file (\"\" = download the default rules)")
+	cacheDir := flag.String("cache-dir", filepath.Join(os.TempDir(), "k2rule-gateway"), "k2rule cache directory")
+	global := flag.Bool("global", false, "start in global mode")
+	antiporn := flag.Bool("antiporn", false, "block porn domains")
+	flag.Parse()
+
+	err := k2rule.Init(&k2rule.Config{
+		RuleFile: *rules,
+		CacheDir: *cacheDir,
+		IsGlobal: *global,
+		Antiporn: *antiporn,
+	})
+	if err != nil {
+		log.Fatalf("Init failed: %v", err)
+	}
+
+	blockPage, err := k2rule.NewBlockPage(k2rule.BlockPageConfig{})
+	if err != nil {
+		log.Fatalf("Block page: %v", err)
+	}
+	d := &dialer{upstream: *upstream}
+
+	proxyListener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Proxy: %v", err)
+	}
+	proxyServer := &http.Server{Handler: newProxy(d, blockPage), ReadHeaderTimeout: 10 * time.Second}
+	go proxyServer.Serve(proxyListener)
+	log.Printf("HTTP proxy on %s", proxyListener.Addr())
+
+	if *dnsAddr != "" {
+		conn, err := net.ListenPacket("udp", *dnsAddr)
+		if err != nil {
+			log.Fatalf("DNS: %v", err)
+		}
+		defer conn.Close()
+		forwarder := &dnsForwarder{dialer: d, direct: *directDNS, remote: *remoteDNS}
+		go forwarder.serve(conn)
+		log.Printf("DNS forwarder on %s", conn.LocalAddr())
+	}
+
+	if *adminAddr != "" {
+		adminListener, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			log.Fatalf("Admin API: %v", err)
+		}
+		adminServer := &http.Server{Handler: k2rule.NewAdminHandler(), ReadHeaderTimeout: 10 * time.Second}
+		go adminServer.Serve(adminListener)
+		defer adminServer.Close()
+		log.Printf("Admin API on http://%s/v1/stats", adminListener.Addr())
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	proxyServer.Close()
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaitu-io/k2rule"
+	"github.com/kaitu-io/k2rule/internal/mmdb"
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// TestGateway runs the proxy and the DNS forwarder on loopback listeners against
+// rules with a DIRECT fallback, a PROXY domain and a REJECT domain
+func TestGateway(t *testing.T) {
+	initTestRules(t)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+
+	remoteDNS := startDNSStub(t, "tcp", net.IPv4(192, 0, 2, 2))
+	directDNS := startDNSStub(t, "udp", net.IPv4(192, 0, 2, 1))
+	upstream := startUpstream(t, map[string]string{"proxied.test:80": origin.Listener.Addr().String()})
+
+	blockPage, err := k2rule.NewBlockPage(k2rule.BlockPageConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dialer{upstream: upstream.addr}
+	proxyServer := httptest.NewServer(newProxy(d, blockPage))
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	forwarder := &dnsForwarder{dialer: d, direct: directDNS, remote: remoteDNS}
+	go forwarder.serve(conn)
+
+	t.Run("proxy DIRECT", func(t *testing.T) {
+		// localhost: DIRECT by the rules' fallback (127.0.0.1 would be the LAN bypass)
+		originURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1)
+		status, body := get(t, client, originURL)
+		if status != http.StatusOK || body != "origin" {
+			t.Errorf("GET %s = %d %q, want 200 origin", originURL, status, body)
+		}
+		if len(upstream.requests()) != 0 {
+			t.Errorf("DIRECT request went through the upstream proxy: %v", upstream.requests())
+		}
+	})
+
+	t.Run("proxy PROXY", func(t *testing.T) {
+		status, body := get(t, client, "http://proxied.test/")
+		if status != http.StatusOK || body != "origin" {
+			t.Errorf("GET proxied.test = %d %q, want 200 origin", status, body)
+		}
+		if got := upstream.requests(); len(got) != 1 || got[0] != "proxied.test:80" {
+			t.Errorf("upstream CONNECT requests = %v, want [proxied.test:80]", got)
+		}
+	})
+
+	t.Run("proxy REJECT", func(t *testing.T) {
+		if status, _ := get(t, client, "http://blocked.test/"); status != http.StatusForbidden {
+			t.Errorf("GET blocked.test = %d, want 403", status)
+		}
+		resp, err := connect(proxyServer.Listener.Addr().String(), "blocked.test:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("CONNECT blocked.test = %d, want 403", resp.StatusCode)
+		}
+	})
+
+	t.Run("DNS DIRECT", func(t *testing.T) {
+		reply := queryDNS(t, conn.LocalAddr().String(), "direct.test")
+		if ips, _ := parseAnswers(reply); len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+			t.Errorf("direct.test answered with %v, want the direct resolver's 192.0.2.1", ips)
+		}
+		if r := k2rule.MatchVerbose("192.0.2.1"); r.Domain != "direct.test" {
+			t.Errorf("MatchVerbose(192.0.2.1).Domain = %q, want direct.test (bound answer)", r.Domain)
+		}
+	})
+
+	t.Run("DNS PROXY", func(t *testing.T) {
+		reply := queryDNS(t, conn.LocalAddr().String(), "proxied.test")
+		if ips, _ := parseAnswers(reply); len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 2)) {
+			t.Errorf("proxied.test answered with %v, want the remote resolver's 192.0.2.2", ips)
+		}
+		if got := upstream.requests(); len(got) != 2 || got[1] != remoteDNS {
+			t.Errorf("upstream CONNECT requests = %v, want the remote resolver %s last", got, remoteDNS)
+		}
+	})
+
+	t.Run("DNS REJECT", func(t *testing.T) {
+		reply := queryDNS(t, conn.LocalAddr().String(), "blocked.test")
+		if rcode := reply[3] & 0x0F; rcode != dnsRcodeNX {
+			t.Errorf("blocked.test rcode = %d, want NXDOMAIN", rcode)
+		}
+	})
+
+	t.Run("admin stats", func(t *testing.T) {
+		admin := httptest.NewServer(k2rule.NewAdminHandler())
+		defer admin.Close()
+		resp, err := http.Get(admin.URL + "/v1/stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var stats k2rule.AdminStatsV1
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("decoding /v1/stats: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || stats.APIVersion != k2rule.AdminAPIVersion || stats.Generation == 0 {
+			t.Errorf("/v1/stats = %d %+v, want 200 with rules loaded", resp.StatusCode, stats)
+		}
+	})
+}
+
+// initTestRules initializes k2rule with local files: proxied.test → PROXY,
+// blocked.test → REJECT, anything else DIRECT
+func initTestRules(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+
+	w := slice.NewSliceWriter(uint8(k2rule.TargetDirect))
+	w.AddDomainSlice([]string{"proxied.test"}, uint8(k2rule.TargetProxy))
+	w.AddDomainSlice([]string{"blocked.test"}, uint8(k2rule.TargetReject))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules bytes.Buffer
+	gz := gzip.NewWriter(&rules)
+	gz.Write(data)
+	gz.Close()
+	rulePath := filepath.Join(dir, "rules.k2r.gz")
+	if err := os.WriteFile(rulePath, rules.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	geo := mmdb.NewWriter("GeoLite2-Country", "")
+	if err := geo.Insert(netip.MustParsePrefix("8.8.8.0/24"), map[string]string{"country.iso_code": "US"}); err != nil {
+		t.Fatal(err)
+	}
+	var geoData bytes.Buffer
+	if _, err := geo.WriteTo(&geoData); err != nil {
+		t.Fatal(err)
+	}
+	geoPath := filepath.Join(dir, "geo.mmdb")
+	if err := os.WriteFile(geoPath, geoData.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k2rule.Init(&k2rule.Config{RuleFile: rulePath, GeoIPFile: geoPath, CacheDir: dir}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+}
+
+// get fetches rawURL through client, returning the status and body
+func get(t *testing.T, client *http.Client, rawURL string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// connect sends a CONNECT request for target to the proxy at addr
+func connect(addr, target string) (*http.Response, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	return http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+}
+
+// queryDNS sends an A query for name to the forwarder at addr and returns the reply
+func queryDNS(t *testing.T, addr, name string) []byte {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0, 0, dnsTypeA, 0, 1)
+	if _, err := conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 512)
+	n, err := conn.Read(reply)
+	if err != nil {
+		t.Fatalf("query %s: %v", name, err)
+	}
+	return reply[:n]
+}
+
+// startDNSStub starts a resolver on loopback answering every query with ip, over UDP
+// or TCP (length-prefixed), and returns its address
+func startDNSStub(t *testing.T, network string, ip net.IP) string {
+	t.Helper()
+	answer := func(query []byte) []byte {
+		_, end, err := parseQuestion(query)
+		if err != nil {
+			return nil
+		}
+		reply := append([]byte(nil), query[:end]...)
+		reply[2], reply[3] = 0x81, 0x80
+		binary.BigEndian.PutUint16(reply[6:], 1)
+		reply = append(reply, 0xC0, dnsHeaderSize, 0, dnsTypeA, 0, 1, 0, 0, 0, 60, 0, 4)
+		return append(reply, ip.To4()...)
+	}
+
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(answer(buf[:n]), addr)
+			}
+		}()
+		return conn.LocalAddr().String()
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(c, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(c, query); err != nil {
+					return
+				}
+				reply := answer(query)
+				binary.BigEndian.PutUint16(length[:], uint16(len(reply)))
+				c.Write(append(length[:], reply...))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// upstreamStub is an HTTP CONNECT proxy tunneling to routes[target] (or target
+// itself), recording the requested targets
+type upstreamStub struct {
+	addr   string
+	routes map[string]string
+
+	mu        sync.Mutex
+	requested []string
+}
+
+// startUpstream starts an upstream stub on loopback
+func startUpstream(t *testing.T, routes map[string]string) *upstreamStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	u := &upstreamStub{addr: listener.Addr().String(), routes: routes}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go u.serve(c)
+		}
+	}()
+	return u
+}
+
+// serve tunnels one CONNECT request
+func (u *upstreamStub) serve(c net.Conn) {
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil || req.Method != http.MethodConnect {
+		c.Close()
+		return
+	}
+	u.mu.Lock()
+	u.requested = append(u.requested, req.Host)
+	u.mu.Unlock()
+
+	target := req.Host
+	if route, ok := u.routes[target]; ok {
+		target = route
+	}
+	backend, err := net.Dial("tcp", target)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		c.Close()
+		return
+	}
+	io.WriteString(c, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	go pipe(backend, c)
+	pipe(c, backend)
+}
+
+// requests returns the targets requested so far
+func (u *upstreamStub) requests() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.requested...)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/kaitu-io/k2rule"
+)
+
+// hopHeaders are the headers of the proxy hop, not forwarded to the origin
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// proxy is an HTTP proxy (CONNECT tunnels and plain HTTP requests) dialing through
+// the k2rule dialer
+type proxy struct {
+	dialer    *dialer
+	blockPage *k2rule.BlockPage
+	transport *http.Transport
+}
+
+// newProxy creates a proxy dialing with d and serving REJECTed plain HTTP requests
+// with blockPage
+func newProxy(d *dialer, blockPage *k2rule.BlockPage) *proxy {
+	return &proxy{
+		dialer:    d,
+		blockPage: blockPage,
+		transport: &http.Transport{DialContext: d.DialContext},
+	}
+}
+
+// ServeHTTP serves a proxy request
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy, not a web server", http.StatusBadRequest)
+		return
+	}
+	p.serveHTTP(w, r)
+}
+
+// serveConnect tunnels a CONNECT request to its host
+func (p *proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		dialError(w, r.Host, err)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	// Bytes the client sent after the CONNECT request are still buffered
+	if n := buf.Reader.Buffered(); n > 0 {
+		data, _ := buf.Reader.Peek(n)
+		upstream.Write(data)
+	}
+	go pipe(upstream, client)
+	pipe(client, upstream)
+}
+
+// serveHTTP forwards a plain HTTP request to its host
+func (p *proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if k2rule.Match(r.URL.Hostname()) == k2rule.TargetReject {
+		p.blockPage.ServeHTTP(w, r)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		dialError(w, r.URL.Host, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for key, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// dialError reports a failed connection to addr to the client
+func dialError(w http.ResponseWriter, addr string, err error) {
+	if errors.Is(err, errRejected) {
+		http.Error(w, "blocked: "+addr, http.StatusForbidden)
+		return
+	}
+	log.Printf("%s: %v", addr, err)
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// pipe copies src to dst, closing both when done
+func pipe(dst, src net.Conn) {
+	io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
@@ -0,0 +1,177 @@
+package k2rule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// URLExpander resolves a shortened URL to the URL it redirects to (one hop).
+type URLExpander func(ctx context.Context, rawURL string) (string, error)
+
+// DefaultShortenerHosts are the URL shortener hosts expanded by MatchExpanded
+// when Config.ShortenerHosts is empty.
+var DefaultShortenerHosts = []string{
+	"bit.ly", "t.co", "tinyurl.com", "goo.gl", "ow.ly", "is.gd",
+	"buff.ly", "rebrand.ly", "cutt.ly", "t.ly", "shorturl.at", "tiny.cc",
+}
+
+const (
+	defaultExpandTimeout = 3 * time.Second // per MatchExpanded call, all hops
+	maxExpandHops        = 3               // shortener chains (bit.ly → t.co → ...)
+	expandCacheTTL       = time.Hour
+	maxExpandCacheSize   = 4096 // entries; the cache is cleared when full
+)
+
+// globalExpandCache caches shortener expansions: short URL → *expandEntry
+var globalExpandCache expandCache
+
+type expandCache struct {
+	entries sync.Map
+	size    atomic.Int64
+}
+
+type expandEntry struct {
+	expanded string
+	expires  time.Time
+}
+
+// MatchExpanded matches a URL, first expanding known URL shortener hosts
+// (Config.ShortenerHosts) with Config.URLExpander so that blocklists can't be
+// bypassed via bit.ly/t.co links. The destination host is then matched with Match.
+//
+// Expansions are cached for an hour and bounded by Config.ExpandTimeout (default 3s).
+// Without an expander, or when expansion fails, the original host is matched.
+//
+// Example:
+//
+//	config.URLExpander = k2rule.NewHTTPURLExpander(nil)
+//	target := k2rule.MatchExpanded(ctx, "https://bit.ly/3abcd")
+func MatchExpanded(ctx context.Context, rawURL string) Target {
+	return Match(urlHost(ExpandURL(ctx, rawURL)))
+}
+
+// ExpandURL returns the destination of a shortened URL (following up to 3 shortener hops),
+// or rawURL itself if it is not a shortener URL or cannot be expanded.
+func ExpandURL(ctx context.Context, rawURL string) string {
+	defaultEngine.mu.RLock()
+	config := defaultEngine.config
+	defaultEngine.mu.RUnlock()
+
+	if config == nil || config.URLExpander == nil {
+		return rawURL
+	}
+
+	hosts := config.ShortenerHosts
+	if len(hosts) == 0 {
+		hosts = DefaultShortenerHosts
+	}
+	timeout := time.Duration(config.ExpandTimeout)
+	if timeout <= 0 {
+		timeout = defaultExpandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	current := rawURL
+	for hop := 0; hop < maxExpandHops && isShortenerHost(urlHost(current), hosts); hop++ {
+		expanded, err := globalExpandCache.expand(ctx, config.URLExpander, current)
+		if err != nil {
+			slog.Debug("url expansion failed", "url", redactURL(current), "error", err)
+			break
+		}
+		current = expanded
+	}
+	return current
+}
+
+// expand returns the cached expansion of rawURL or calls expander
+func (c *expandCache) expand(ctx context.Context, expander URLExpander, rawURL string) (string, error) {
+	if v, ok := c.entries.Load(rawURL); ok {
+		if e := v.(*expandEntry); time.Now().Before(e.expires) {
+			return e.expanded, nil
+		}
+	}
+
+	expanded, err := expander(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	if expanded == "" {
+		return "", fmt.Errorf("empty expansion")
+	}
+
+	if c.size.Add(1) > maxExpandCacheSize {
+		c.clear()
+	}
+	c.entries.Store(rawURL, &expandEntry{expanded: expanded, expires: time.Now().Add(expandCacheTTL)})
+	return expanded, nil
+}
+
+// clear removes all cached expansions
+func (c *expandCache) clear() {
+	c.entries.Range(func(key, _ any) bool {
+		c.entries.Delete(key)
+		return true
+	})
+	c.size.Store(0)
+}
+
+// NewHTTPURLExpander returns a URLExpander that issues a HEAD request without following
+// redirects and returns the Location header. A nil client uses a default client.
+func NewHTTPURLExpander(client *http.Client) URLExpander {
+	if client == nil {
+		client = &http.Client{}
+	}
+	noFollow := *client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return func(ctx context.Context, rawURL string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := noFollow.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand: %w", err)
+		}
+		resp.Body.Close()
+
+		location, err := resp.Location()
+		if err != nil {
+			return "", fmt.Errorf("no redirect (HTTP %d)", resp.StatusCode)
+		}
+		return location.String(), nil
+	}
+}
+
+// urlHost returns the lowercased hostname of a URL (scheme optional)
+func urlHost(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "//" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// isShortenerHost reports whether host (or a parent domain) is in hosts
+func isShortenerHost(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package k2rule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMatchExpanded(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer globalExpandCache.clear()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var calls atomic.Int32
+	expander := func(ctx context.Context, rawURL string) (string, error) {
+		calls.Add(1)
+		switch rawURL {
+		case "https://bit.ly/abc":
+			return "https://t.co/def", nil // chained shortener
+		case "https://t.co/def":
+			return "https://www.blocked.com/page", nil
+		}
+		return "", context.DeadlineExceeded
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{URLExpander: expander}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	ctx := context.Background()
+	if got := MatchExpanded(ctx, "https://bit.ly/abc"); got != TargetReject {
+		t.Errorf("MatchExpanded(bit.ly/abc) = %v, want REJECT", got)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expander called %d times, want 2", calls.Load())
+	}
+
+	// Cached: no further expander calls
+	if got := ExpandURL(ctx, "https://bit.ly/abc"); got != "https://www.blocked.com/page" {
+		t.Errorf("ExpandURL = %q", got)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expander called %d times after cache hit, want 2", calls.Load())
+	}
+
+	// Non-shortener hosts are never expanded
+	if got := MatchExpanded(ctx, "https://blocked.com/x"); got != TargetReject {
+		t.Errorf("MatchExpanded(blocked.com) = %v, want REJECT", got)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expander called for non-shortener host")
+	}
+
+	// Failed expansion falls back to the original host
+	if got := ExpandURL(ctx, "https://bit.ly/unknown"); got != "https://bit.ly/unknown" {
+		t.Errorf("ExpandURL(unknown) = %q, want original URL", got)
+	}
+}
+
+func TestExpandURL_Timeout(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer globalExpandCache.clear()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{
+		ExpandTimeout: Duration(20 * time.Millisecond),
+		URLExpander: func(ctx context.Context, rawURL string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	start := time.Now()
+	if got := ExpandURL(context.Background(), "https://bit.ly/slow"); got != "https://bit.ly/slow" {
+		t.Errorf("ExpandURL = %q, want original URL", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ExpandURL took %v, want ~20ms", elapsed)
+	}
+}
+
+func TestNewHTTPURLExpander(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s, want HEAD", r.Method)
+		}
+		if r.URL.Path == "/short" {
+			http.Redirect(w, r, "https://example.com/long", http.StatusMovedPermanently)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	expand := NewHTTPURLExpander(nil)
+	got, err := expand(context.Background(), server.URL+"/short")
+	if err != nil {
+		t.Fatalf("expand failed: %v", err)
+	}
+	if got != "https://example.com/long" {
+		t.Errorf("expand = %q, want https://example.com/long", got)
+	}
+
+	if _, err := expand(context.Background(), server.URL+"/plain"); err == nil {
+		t.Error("expand of non-redirect succeeded, want error")
+	}
+}
+
+func TestIsShortenerHost(t *testing.T) {
+	if !isShortenerHost("bit.ly", DefaultShortenerHosts) || !isShortenerHost("www.bit.ly", DefaultShortenerHosts) {
+		t.Error("bit.ly not detected as shortener")
+	}
+	if isShortenerHost("notbit.ly", DefaultShortenerHosts) {
+		t.Error("notbit.ly detected as shortener")
+	}
+}
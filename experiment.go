@@ -0,0 +1,177 @@
+package k2rule
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// ExperimentSelector reports whether a decision takes part in an experiment, e.g.
+// fallback decisions for domains (nil = every decision)
+type ExperimentSelector func(input string, result MatchResult) bool
+
+// ExperimentStats counts the exposure of an experiment since it was defined.
+// Comparing the outcome (e.g. connection failures) of the Diverted decisions with the
+// other Eligible ones measures the impact of the routing change.
+type ExperimentStats struct {
+	Name     string  `json:"name"`
+	Fraction float64 `json:"fraction"` // Fraction of eligible inputs diverted
+	Target   Target  `json:"target"`   // Target of diverted decisions
+	Eligible uint64  `json:"eligible"` // Decisions selected by the experiment's selector
+	Diverted uint64  `json:"diverted"` // Eligible decisions diverted to Target
+}
+
+// experiment is a defined experiment with its exposure counters
+type experiment struct {
+	name      string
+	fraction  float64
+	target    Target
+	selector  ExperimentSelector
+	threshold uint64 // Inputs whose hash is below threshold are diverted
+	eligible  atomic.Uint64
+	diverted  atomic.Uint64
+}
+
+// globalExperiments are the defined experiments in definition order (copy-on-write,
+// writers hold experimentsMu)
+var (
+	globalExperiments atomic.Pointer[[]*experiment]
+	experimentsMu     sync.Mutex
+)
+
+// DefineExperiment diverts fraction (0..1) of the decisions selected by selector to
+// target, to measure the impact of a routing change on a slice of traffic before
+// rolling it out. Inputs are assigned by a hash of the experiment name and the input,
+// so an input is diverted consistently across calls and restarts. Experiments apply
+// after middleware; a decision takes part in the first defined experiment that selects
+// it, and diverted results carry MatchResult.Experiment. LAN and source-domain decisions
+// (ReasonLAN, ReasonSource) are never diverted, so an experiment can't cut off local
+// networks or rule updates. Only Match and MatchVerbose count exposure; IsExplicitMatch,
+// the admin API and SelfTest don't. Defining an existing name replaces it and resets
+// its counts (see GetExperimentStats).
+//
+// Example (proxy 10% of unmatched domains):
+//
+//	k2rule.DefineExperiment("proxy-fallback", 0.1, k2rule.TargetProxy,
+//		func(input string, r k2rule.MatchResult) bool { return r.Fallback && r.Target == k2rule.TargetDirect })
+func DefineExperiment(name string, fraction float64, target Target, selector ExperimentSelector) error {
+	if name == "" {
+		return fmt.Errorf("experiment name is required")
+	}
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("experiment fraction must be between 0 and 1")
+	}
+	e := &experiment{name: name, fraction: fraction, target: target, selector: selector}
+	switch {
+	case fraction >= 1:
+		e.threshold = math.MaxUint64
+	default:
+		e.threshold = uint64(fraction * (1 << 64))
+	}
+
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	var list []*experiment
+	replaced := false
+	for _, old := range loadExperiments() {
+		if old.name == name {
+			old, replaced = e, true
+		}
+		list = append(list, old)
+	}
+	if !replaced {
+		list = append(list, e)
+	}
+	globalExperiments.Store(&list)
+	return nil
+}
+
+// RemoveExperiment removes an experiment; reports whether it was defined
+func RemoveExperiment(name string) bool {
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	var list []*experiment
+	removed := false
+	for _, e := range loadExperiments() {
+		if e.name == name {
+			removed = true
+			continue
+		}
+		list = append(list, e)
+	}
+	globalExperiments.Store(&list)
+	return removed
+}
+
+// ClearExperiments removes all experiments
+func ClearExperiments() {
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	globalExperiments.Store(nil)
+}
+
+// GetExperimentStats returns the exposure counts of the defined experiments
+func GetExperimentStats() []ExperimentStats {
+	list := loadExperiments()
+	stats := make([]ExperimentStats, len(list))
+	for i, e := range list {
+		stats[i] = ExperimentStats{
+			Name:     e.name,
+			Fraction: e.fraction,
+			Target:   e.target,
+			Eligible: e.eligible.Load(),
+			Diverted: e.diverted.Load(),
+		}
+	}
+	return stats
+}
+
+// loadExperiments returns the defined experiments
+func loadExperiments() []*experiment {
+	if list := globalExperiments.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// applyExperiments diverts result when the first experiment selecting it assigns
+// input to its treatment group; count is false for lookups that don't route traffic,
+// which leave the exposure counts alone
+func applyExperiments(input string, result MatchResult, count bool) MatchResult {
+	experiments := loadExperiments()
+	if len(experiments) == 0 || result.Reason == ReasonLAN || result.Reason == ReasonSource {
+		return result
+	}
+	// Selectors see REJECT-DROP and REJECT-RESET as Match reports them
+	result = result.withRejectMode()
+	for _, e := range experiments {
+		if e.selector != nil && !e.selector(input, result) {
+			continue
+		}
+		if count {
+			e.eligible.Add(1)
+		}
+		if e.bucket(input) < e.threshold {
+			if count {
+				e.diverted.Add(1)
+			}
+			result.Target = e.target
+			result.Reason = ReasonExperiment
+			result.RejectMode = RejectDefault
+			result.Experiment = e.name
+		}
+		return result
+	}
+	return result
+}
+
+// bucket returns the stable hash of input within the experiment
+func (e *experiment) bucket(input string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.name))
+	h.Write([]byte{0})
+	h.Write([]byte(input))
+	return h.Sum64()
+}
@@ -0,0 +1,113 @@
+package k2rule
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefineExperiment(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	fallbackOnly := func(input string, r MatchResult) bool { return r.Fallback }
+	if err := DefineExperiment("proxy-fallback", 0.25, TargetProxy, fallbackOnly); err != nil {
+		t.Fatalf("DefineExperiment failed: %v", err)
+	}
+
+	// Deterministic per input, roughly the configured fraction
+	diverted := 0
+	for i := 0; i < 2000; i++ {
+		input := fmt.Sprintf("site%d.example", i)
+		r := MatchVerbose(input)
+		if again := MatchVerbose(input); again.Target != r.Target {
+			t.Fatalf("MatchVerbose(%s) diverted inconsistently", input)
+		}
+		if r.Experiment != "" {
+			diverted++
+			if r.Target != TargetProxy || r.Experiment != "proxy-fallback" {
+				t.Fatalf("diverted result = %+v", r)
+			}
+		} else if r.Target != TargetDirect {
+			t.Fatalf("control result = %+v, want the fallback", r)
+		}
+	}
+	if diverted < 400 || diverted > 600 {
+		t.Errorf("diverted %d of 2000 inputs, want about 500", diverted)
+	}
+
+	// Rule matches aren't selected
+	if r := MatchVerbose("blocked.com"); r.Target != TargetReject || r.Experiment != "" {
+		t.Errorf("MatchVerbose(blocked.com) = %+v, want the rule decision", r)
+	}
+
+	stats := GetExperimentStats()
+	if len(stats) != 1 || stats[0].Eligible != 4000 || stats[0].Diverted != uint64(2*diverted) {
+		t.Errorf("GetExperimentStats() = %+v, want 4000 eligible and %d diverted", stats, 2*diverted)
+	}
+
+	// Queries don't count exposure
+	IsExplicitMatch("site1.example")
+	if stats := GetExperimentStats(); stats[0].Eligible != 4000 {
+		t.Errorf("Eligible after IsExplicitMatch = %d, want 4000", stats[0].Eligible)
+	}
+
+	// Redefining resets the counts; a REJECT-DROP target is folded
+	if err := DefineExperiment("proxy-fallback", 1, TargetRejectDrop, nil); err != nil {
+		t.Fatalf("DefineExperiment failed: %v", err)
+	}
+	if r := MatchVerbose("blocked.com"); r.Target != TargetReject || r.RejectMode != RejectDrop {
+		t.Errorf("MatchVerbose(blocked.com) = %+v, want REJECT drop", r)
+	}
+	if stats := GetExperimentStats(); len(stats) != 1 || stats[0].Eligible != 1 || stats[0].Diverted != 1 {
+		t.Errorf("GetExperimentStats() after redefinition = %+v", stats)
+	}
+
+	if !RemoveExperiment("proxy-fallback") || RemoveExperiment("proxy-fallback") {
+		t.Error("RemoveExperiment should report whether the experiment existed")
+	}
+	if r := MatchVerbose("site1.example"); r.Experiment != "" {
+		t.Errorf("MatchVerbose after RemoveExperiment = %+v", r)
+	}
+
+	for _, fraction := range []float64{-0.1, 1.5} {
+		if err := DefineExperiment("bad", fraction, TargetProxy, nil); err == nil {
+			t.Errorf("DefineExperiment(fraction %v) succeeded", fraction)
+		}
+	}
+	if err := DefineExperiment("", 0.5, TargetProxy, nil); err == nil {
+		t.Error("DefineExperiment without a name succeeded")
+	}
+}
+
+func TestDefineExperiment_LANAndSourceExempt(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	registerSourceDomains("https://cdn.jsdelivr.net/gh/kaitu-io/k2rule@release/cn_whitelist.k2r.gz")
+	if err := DefineExperiment("reject-all", 1, TargetReject, nil); err != nil {
+		t.Fatalf("DefineExperiment failed: %v", err)
+	}
+
+	for _, input := range []string{"192.168.1.1", "cdn.jsdelivr.net"} {
+		if r := MatchVerbose(input); r.Target != TargetDirect || r.Experiment != "" {
+			t.Errorf("MatchVerbose(%s) = %+v, want DIRECT without experiment", input, r)
+		}
+	}
+	if stats := GetExperimentStats(); stats[0].Eligible != 0 {
+		t.Errorf("Eligible = %d, want 0", stats[0].Eligible)
+	}
+}
@@ -0,0 +1,120 @@
+package k2rule
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kaitu-io/k2rule/internal/mmdb"
+)
+
+// Format is an output format of ExportDomains
+type Format string
+
+// Export formats
+const (
+	// FormatText is one domain per line; each domain also covers its subdomains
+	FormatText Format = "text"
+	// FormatClashDomain is a Clash rule provider with behavior "domain":
+	// payload entries "+.example.com"
+	FormatClashDomain Format = "clash-domain"
+	// FormatClashClassical is a Clash rule provider with behavior "classical":
+	// payload entries "DOMAIN-SUFFIX,example.com"
+	FormatClashClassical Format = "clash-classical"
+)
+
+// ExportDomains returns the domain rules of the loaded rule file that route to target
+// in the given format, so the rules can feed tools that can't read K2RULEV3.
+// Domains appear in file order without duplicates. TmpRules, the user overlay and the
+// fallback are not included; IP rules are available from LoadedCIDRsFor
+// and ExportMMDB.
+//
+// Example:
+//
+//	data, err := k2rule.ExportDomains(k2rule.TargetProxy, k2rule.FormatClashDomain)
+//	if err == nil {
+//	    os.WriteFile("proxy-domains.yaml", data, 0644)
+//	}
+func ExportDomains(target Target, format Format) ([]byte, error) {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil || manager.reader.Get() == nil {
+		return nil, fmt.Errorf("no rules loaded")
+	}
+
+	domains := manager.reader.Domains(uint8(target))
+	seen := make(map[string]struct{}, len(domains))
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatText:
+	case FormatClashDomain, FormatClashClassical:
+		buf.WriteString("payload:\n")
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	for _, domain := range domains {
+		if _, ok := seen[domain]; ok {
+			continue
+		}
+		seen[domain] = struct{}{}
+
+		switch format {
+		case FormatText:
+			buf.WriteString(domain + "\n")
+		case FormatClashDomain:
+			buf.WriteString("  - " + yamlQuote("+."+domain) + "\n")
+		case FormatClashClassical:
+			buf.WriteString("  - " + yamlQuote("DOMAIN-SUFFIX,"+domain) + "\n")
+		}
+	}
+	if len(seen) == 0 && format != FormatText {
+		// Empty payload list
+		return []byte("payload: []\n"), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportMMDB writes the IP rules of the loaded rule file as a MaxMind DB mapping
+// networks to {"target": "PROXY"|"DIRECT"|"REJECT"}, so resolvers and firewalls
+// with mmdb support can enforce the same IP policy. Where rules overlap, the
+// network gets the target of the rule that comes first in the file, as in Match.
+// GeoIP rules, TmpRules and the fallback are not included; addresses without a
+// rule have no record.
+//
+// Example:
+//
+//	f, _ := os.Create("k2rule-ip.mmdb")
+//	defer f.Close()
+//	err := k2rule.ExportMMDB(f)
+func ExportMMDB(w io.Writer) error {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil || manager.reader.Get() == nil {
+		return fmt.Errorf("no rules loaded")
+	}
+
+	db := mmdb.NewWriter("k2rule-targets", "k2rule IP rule targets")
+	rules := manager.reader.IPRules()
+	// Insert in reverse: later inserts win, so the first rule in the file wins
+	for i := len(rules) - 1; i >= 0; i-- {
+		target := Target(rules[i].Target).String()
+		if err := db.Insert(rules[i].Prefix, map[string]string{"target": target}); err != nil {
+			return fmt.Errorf("failed to add %s: %w", rules[i].Prefix, err)
+		}
+	}
+	if _, err := db.WriteTo(w); err != nil {
+		return fmt.Errorf("failed to write mmdb: %w", err)
+	}
+	return nil
+}
+
+// yamlQuote returns s as a single-quoted YAML scalar
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,136 @@
+package k2rule
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestExportDomains(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if _, err := ExportDomains(TargetProxy, FormatText); err == nil {
+		t.Error("ExportDomains() without rules succeeded")
+	}
+
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	w.AddDomainSlice([]string{"google.com", "youtube.com"}, uint8(TargetProxy))
+	w.AddDomainTrieSlice([]string{"google.com", "example.org"}, uint8(TargetProxy))
+	w.AddDomainSlice([]string{"baidu.com"}, uint8(TargetDirect))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{FormatText, "youtube.com\ngoogle.com\nexample.org\n"},
+		{FormatClashDomain, "payload:\n  - '+.youtube.com'\n  - '+.google.com'\n  - '+.example.org'\n"},
+		{FormatClashClassical, "payload:\n  - 'DOMAIN-SUFFIX,youtube.com'\n  - 'DOMAIN-SUFFIX,google.com'\n  - 'DOMAIN-SUFFIX,example.org'\n"},
+	}
+	for _, tt := range tests {
+		got, err := ExportDomains(TargetProxy, tt.format)
+		if err != nil {
+			t.Fatalf("ExportDomains(%s) failed: %v", tt.format, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("ExportDomains(%s) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	if got, _ := ExportDomains(TargetReject, FormatClashDomain); string(got) != "payload: []\n" {
+		t.Errorf("ExportDomains(REJECT) = %q, want empty payload", got)
+	}
+	if _, err := ExportDomains(TargetProxy, "json"); err == nil {
+		t.Error("ExportDomains() accepted an unknown format")
+	}
+}
+
+func TestExportMMDB(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	var buf bytes.Buffer
+	if err := ExportMMDB(&buf); err == nil {
+		t.Error("ExportMMDB() without rules succeeded")
+	}
+
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 10<<24 | 1<<16, PrefixLen: 16}}, uint8(TargetDirect))
+	w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, uint8(TargetProxy))
+	w.AddIPRangeV4Slice([]slice.IPRangeV4Entry{{Start: 10<<24 | 2<<16, End: 10<<24 | 2<<16 | 9}}, uint8(TargetReject)) // shadowed by 10.0.0.0/8
+	w.AddCidrV6Slice([]slice.CidrV6Entry{{Network: netip.MustParseAddr("2001:db8::").As16(), PrefixLen: 32}}, uint8(TargetReject))
+	w.AddDomainSlice([]string{"google.com"}, uint8(TargetProxy))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if err := ExportMMDB(&buf); err != nil {
+		t.Fatalf("ExportMMDB() error: %v", err)
+	}
+	db, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes() error: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string // "" = no record
+	}{
+		{"10.1.2.3", "DIRECT"},
+		{"10.0.0.1", "PROXY"},
+		{"10.2.0.5", "PROXY"},
+		{"11.0.0.1", ""},
+		{"2001:db8::1", "REJECT"},
+		{"2001:db9::1", ""},
+	}
+	for _, tt := range tests {
+		var rec struct {
+			Target string `maxminddb:"target"`
+		}
+		if err := db.Lookup(net.ParseIP(tt.ip), &rec); err != nil {
+			t.Errorf("Lookup(%s) error: %v", tt.ip, err)
+		}
+		if rec.Target != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.ip, rec.Target, tt.want)
+		}
+		if tt.want != "" {
+			if got := manager.reader.MatchIP(net.ParseIP(tt.ip)); got == nil || Target(*got).String() != tt.want {
+				t.Errorf("MatchIP(%s) = %v, mmdb says %s", tt.ip, got, tt.want)
+			}
+		}
+	}
+}
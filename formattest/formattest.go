@@ -0,0 +1,309 @@
+// Package formattest is the conformance suite of the K2RULEV3 (.k2r) rule format.
+//
+// The suite is a set of vectors (golden/vectors.json): each describes a rule file
+// by its slices, names a golden file written by this repository's writer
+// (golden/<file>), and lists lookups with the target the file must produce. It
+// keeps the producers (the Rust writer, k2rule-gen) and the readers of the format
+// from silently diverging:
+//
+//   - Readers in other languages load the golden files and check the cases.
+//   - Writers in other languages build each vector from its slices and check the
+//     result with Verify, e.g. by writing the files to a directory and running
+//     go test ./formattest -args -dir <directory>
+//
+// A case's target is the target of the first slice that matches the lookup, or
+// null when no slice matches (lookups then use the file's fallback target).
+// Slices with an expiry in the past are skipped; the suite only uses expiries long
+// past or far in the future, so cases don't depend on when they run.
+// Targets are 0 = DIRECT, 1 = PROXY, 2 = REJECT.
+package formattest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+//go:embed golden
+var golden embed.FS
+
+// Slice types of SliceSpec.Type
+const (
+	TypeSortedDomain  = "sorted_domain"
+	TypeDomainTrie    = "domain_trie"
+	TypeDomainTargets = "domain_targets"
+	TypeCidrV4        = "cidr_v4"
+	TypeCidrV6        = "cidr_v6"
+	TypeRangeV4       = "range_v4"
+	TypeRangeV6       = "range_v6"
+	TypeGeoIP         = "geoip"
+)
+
+// Vector is a rule file of the suite with its expected lookups
+type Vector struct {
+	Name        string      `json:"name"`
+	File        string      `json:"file"` // Golden file name in golden/
+	Description string      `json:"description"`
+	Fallback    uint8       `json:"fallback"`               // Header fallback target
+	Timestamp   int64       `json:"timestamp"`              // Header timestamp (Unix seconds)
+	CompressMin int         `json:"compress_min,omitempty"` // Compress slices of at least this many bytes (0 = off)
+	Slices      []SliceSpec `json:"slices"`                 // In file order
+	Cases       []Case      `json:"cases"`
+}
+
+// SliceSpec is one slice of a vector. Which list is used depends on Type.
+type SliceSpec struct {
+	Type      string         `json:"type"`
+	Target    uint8          `json:"target"`              // Unused for domain_targets
+	Domains   []string       `json:"domains,omitempty"`   // sorted_domain, domain_trie
+	Entries   []DomainTarget `json:"entries,omitempty"`   // domain_targets
+	CIDRs     []string       `json:"cidrs,omitempty"`     // cidr_v4, cidr_v6: "10.0.0.0/8"
+	Ranges    []string       `json:"ranges,omitempty"`    // range_v4, range_v6: "10.0.0.1-10.0.0.9" (inclusive)
+	Countries []string       `json:"countries,omitempty"` // geoip: ISO 3166-1 alpha-2 codes
+	Expires   int64          `json:"expires,omitempty"`   // Unix seconds from which readers skip the slice (0 = never)
+	DNSHints  uint8          `json:"dns_hints,omitempty"` // DNS hint bits of a domain slice (0 = none)
+}
+
+// DomainTarget is a domain_targets entry
+type DomainTarget struct {
+	Domain string `json:"domain"`
+	Target uint8  `json:"target"`
+}
+
+// Case is one lookup: exactly one of Domain, IP and Country is set
+type Case struct {
+	Domain  string `json:"domain,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Country string `json:"country,omitempty"`
+	Target  *uint8 `json:"target"` // nil = no slice matches
+}
+
+// String returns the lookup of c, e.g. "domain example.com"
+func (c Case) String() string {
+	switch {
+	case c.Domain != "":
+		return "domain " + c.Domain
+	case c.IP != "":
+		return "ip " + c.IP
+	}
+	return "country " + c.Country
+}
+
+// Vectors returns the vectors of the suite
+func Vectors() ([]Vector, error) {
+	data, err := golden.ReadFile("golden/vectors.json")
+	if err != nil {
+		return nil, err
+	}
+	var suite struct {
+		Vectors []Vector `json:"vectors"`
+	}
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("failed to parse vectors.json: %w", err)
+	}
+	return suite.Vectors, nil
+}
+
+// Golden returns the golden file of v
+func Golden(v Vector) ([]byte, error) {
+	return golden.ReadFile("golden/" + v.File)
+}
+
+// Build writes v with this repository's writer. The result is byte-identical to
+// the golden file unless the writer changed.
+func Build(v Vector) ([]byte, error) {
+	w := slice.NewSliceWriter(v.Fallback)
+	w.SetBuildTime(time.Unix(v.Timestamp, 0))
+	if v.CompressMin > 0 {
+		w.EnableSliceCompression(v.CompressMin)
+	}
+	for i, s := range v.Slices {
+		if err := addSlice(w, s); err != nil {
+			return nil, fmt.Errorf("%s: slice %d (%s): %w", v.Name, i, s.Type, err)
+		}
+		if s.Expires != 0 {
+			if err := w.ExpireLastSlice(time.Unix(s.Expires, 0)); err != nil {
+				return nil, fmt.Errorf("%s: slice %d (%s): %w", v.Name, i, s.Type, err)
+			}
+		}
+		if s.DNSHints != 0 {
+			if err := w.SetLastSliceDNSHints(slice.DNSHint(s.DNSHints)); err != nil {
+				return nil, fmt.Errorf("%s: slice %d (%s): %w", v.Name, i, s.Type, err)
+			}
+		}
+	}
+	return w.Build()
+}
+
+// Verify checks a rule file built from v (by any writer) against the cases of v,
+// with both readers of this repository (heap and mmap). It returns an error
+// listing every discrepancy.
+func Verify(data []byte, v Vector) error {
+	heap, err := slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		return fmt.Errorf("%s: heap reader: %w", v.Name, err)
+	}
+	mapped, err := slice.NewInMemoryReaderFromBytes(data)
+	if err != nil {
+		return fmt.Errorf("%s: mmap reader: %w", v.Name, err)
+	}
+	defer mapped.Close()
+	if err := mapped.Err(); err != nil {
+		return fmt.Errorf("%s: mmap reader: %w", v.Name, err)
+	}
+
+	var failures []string
+	if got := heap.Fallback(); got != v.Fallback {
+		failures = append(failures, fmt.Sprintf("fallback = %d, want %d", got, v.Fallback))
+	}
+	readers := []struct {
+		name  string
+		match func(Case) (*uint8, error)
+	}{
+		{"heap", func(c Case) (*uint8, error) {
+			return lookup(c, heap.MatchDomain, heap.MatchIP, heap.MatchGeoIP)
+		}},
+		{"mmap", func(c Case) (*uint8, error) {
+			return lookup(c, mapped.MatchDomain, mapped.MatchIP, mapped.MatchGeoIP)
+		}},
+	}
+	for _, c := range v.Cases {
+		for _, r := range readers {
+			got, err := r.match(c)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", c, err))
+				break
+			}
+			if !sameTarget(got, c.Target) {
+				failures = append(failures, fmt.Sprintf("%s (%s reader) = %s, want %s", c, r.name, formatTarget(got), formatTarget(c.Target)))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s: %s", v.Name, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// lookup runs c with the match functions of a reader
+func lookup(c Case, domain func(string) *uint8, ip func(net.IP) *uint8, country func(string) *uint8) (*uint8, error) {
+	switch {
+	case c.Domain != "":
+		return domain(c.Domain), nil
+	case c.IP != "":
+		parsed := net.ParseIP(c.IP)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid IP")
+		}
+		return ip(parsed), nil
+	case c.Country != "":
+		return country(c.Country), nil
+	}
+	return nil, fmt.Errorf("case without domain, ip or country")
+}
+
+// addSlice appends s to w
+func addSlice(w *slice.SliceWriter, s SliceSpec) error {
+	switch s.Type {
+	case TypeSortedDomain:
+		return w.AddDomainSlice(s.Domains, s.Target)
+	case TypeDomainTrie:
+		return w.AddDomainTrieSlice(s.Domains, s.Target)
+	case TypeDomainTargets:
+		entries := make([]slice.DomainTarget, len(s.Entries))
+		for i, e := range s.Entries {
+			entries[i] = slice.DomainTarget{Domain: e.Domain, Target: e.Target}
+		}
+		return w.AddDomainTargetSlice(entries)
+	case TypeCidrV4, TypeCidrV6:
+		var v4 []slice.CidrV4Entry
+		var v6 []slice.CidrV6Entry
+		for _, cidr := range s.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return err
+			}
+			if s.Type == TypeCidrV4 {
+				if !prefix.Addr().Is4() {
+					return fmt.Errorf("%s is not an IPv4 CIDR", cidr)
+				}
+				a := prefix.Addr().As4()
+				v4 = append(v4, slice.CidrV4Entry{
+					Network:   uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3]),
+					PrefixLen: uint8(prefix.Bits()),
+				})
+			} else {
+				v6 = append(v6, slice.CidrV6Entry{Network: prefix.Addr().As16(), PrefixLen: uint8(prefix.Bits())})
+			}
+		}
+		if s.Type == TypeCidrV4 {
+			return w.AddCidrV4Slice(v4, s.Target)
+		}
+		return w.AddCidrV6Slice(v6, s.Target)
+	case TypeRangeV4, TypeRangeV6:
+		var v4 []slice.IPRangeV4Entry
+		var v6 []slice.IPRangeV6Entry
+		for _, r := range s.Ranges {
+			start, end, err := parseRange(r)
+			if err != nil {
+				return err
+			}
+			if s.Type == TypeRangeV4 {
+				if !start.Is4() || !end.Is4() {
+					return fmt.Errorf("%s is not an IPv4 range", r)
+				}
+				a, b := start.As4(), end.As4()
+				v4 = append(v4, slice.IPRangeV4Entry{
+					Start: uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3]),
+					End:   uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]),
+				})
+			} else {
+				v6 = append(v6, slice.IPRangeV6Entry{Start: start.As16(), End: end.As16()})
+			}
+		}
+		if s.Type == TypeRangeV4 {
+			return w.AddIPRangeV4Slice(v4, s.Target)
+		}
+		return w.AddIPRangeV6Slice(v6, s.Target)
+	case TypeGeoIP:
+		return w.AddGeoIPSlice(s.Countries, s.Target)
+	}
+	return fmt.Errorf("unknown slice type %q", s.Type)
+}
+
+// parseRange parses an inclusive "start-end" address range
+func parseRange(r string) (start, end netip.Addr, err error) {
+	from, to, ok := strings.Cut(r, "-")
+	if !ok {
+		return start, end, fmt.Errorf("invalid range %q", r)
+	}
+	if start, err = netip.ParseAddr(from); err != nil {
+		return start, end, err
+	}
+	if end, err = netip.ParseAddr(to); err != nil {
+		return start, end, err
+	}
+	return start, end, nil
+}
+
+// sameTarget reports whether two lookup results are equal
+func sameTarget(a, b *uint8) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// formatTarget returns a lookup result for messages
+func formatTarget(t *uint8) string {
+	if t == nil {
+		return "no match"
+	}
+	return fmt.Sprintf("%d", *t)
+}
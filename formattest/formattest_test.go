@@ -0,0 +1,126 @@
+package formattest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+var (
+	update = flag.Bool("update", false, "rewrite the golden files from vectors.json")
+	dir    = flag.String("dir", "", "verify the files of another writer in this directory (named like the golden files)")
+)
+
+func TestGolden(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatalf("Vectors() failed: %v", err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no vectors")
+	}
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			built, err := Build(v)
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+			if *update {
+				if err := os.WriteFile(filepath.Join("golden", v.File), built, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := Golden(v)
+			if err != nil {
+				t.Fatalf("Golden() failed: %v (run go test ./formattest -update)", err)
+			}
+			if !bytes.Equal(built, want) {
+				t.Errorf("writer output differs from golden/%s; if the format change is intended, run go test ./formattest -update", v.File)
+			}
+			if err := Verify(want, v); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestGolden_CompressedFeature(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatalf("Vectors() failed: %v", err)
+	}
+	for _, v := range vectors {
+		data, err := Golden(v)
+		if err != nil {
+			t.Fatalf("Golden(%s) failed: %v", v.Name, err)
+		}
+		r, err := slice.NewSliceReaderFromBytes(data)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		if got, want := r.HasFeature(slice.FeatureCompressedSlices), v.CompressMin > 0; got != want {
+			t.Errorf("%s: FeatureCompressedSlices = %v, want %v", v.Name, got, want)
+		}
+	}
+}
+
+func TestVerify_ReportsDivergence(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatalf("Vectors() failed: %v", err)
+	}
+	v := vectors[0]
+	data, err := Golden(v)
+	if err != nil {
+		t.Fatalf("Golden() failed: %v", err)
+	}
+
+	// A file built without its last slice misses some cases
+	short := v
+	short.Slices = v.Slices[:len(v.Slices)-1]
+	truncated, err := Build(short)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	if err := Verify(truncated, v); err == nil {
+		t.Error("Verify() accepted a file missing a slice")
+	}
+
+	wrong := v
+	wrong.Fallback++
+	if err := Verify(data, wrong); err == nil {
+		t.Error("Verify() accepted a wrong fallback")
+	}
+	if err := Verify([]byte("K2RULEV3 truncated"), v); err == nil {
+		t.Error("Verify() accepted a truncated file")
+	}
+}
+
+// TestExternal verifies the files of another writer, e.g.
+//
+//	go test ./formattest -run External -args -dir /tmp/rust-out
+func TestExternal(t *testing.T) {
+	if *dir == "" {
+		t.Skip("no -dir")
+	}
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatalf("Vectors() failed: %v", err)
+	}
+	for _, v := range vectors {
+		data, err := os.ReadFile(filepath.Join(*dir, v.File))
+		if err != nil {
+			t.Errorf("%s: %v", v.Name, err)
+			continue
+		}
+		if err := Verify(data, v); err != nil {
+			t.Error(err)
+		}
+	}
+}
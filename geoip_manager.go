@@ -1,24 +1,35 @@
 package k2rule
 
 import (
-	"compress/gzip"
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
-	"log/slog"
 	"net"
-	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kaitu-io/k2rule/internal/mmdb"
 	"github.com/oschwald/maxminddb-golang"
 )
 
 // DefaultGeoIPURL is the default MaxMind GeoLite2 Country database URL
 const DefaultGeoIPURL = "https://cdn.jsdelivr.net/npm/geolite2-country/GeoLite2-Country.mmdb.gz"
 
+// Negative cache of IPs without a country: bogon-heavy traffic repeats the same
+// misses, each a full trie traversal. Entries are short-lived so a database update
+// is picked up even without the reload that clears them.
+const (
+	geoIPNegativeTTL = time.Minute
+	geoIPNegativeMax = 65536
+)
+
+// errNoCountry is returned by LookupCountry for IPs without a country
+var errNoCountry = errors.New("no country found for IP")
+
 // countryRecord is a minimal decode struct for MaxMind lookups.
 // Only decodes iso_code, skipping Names/Continent/RegisteredCountry/Traits (~50 → ~5 allocs).
 type countryRecord struct {
@@ -33,37 +44,55 @@ type GeoIPManager struct {
 	url      string
 	cacheDir string
 	reader   *maxminddb.Reader
+	dl       *downloader // shared HTTP downloader (auth, timeouts)
 
 	// Offset cache: maps data-section offset → ISO country code.
 	// GeoLite2-Country has ~250 unique country records, so cache is bounded.
 	// After warmup, all lookups are zero-alloc (trie traversal + cache hit).
 	cache sync.Map // map[uintptr]string
 
+	// Negative cache: IPs without a country → expiry (UnixNano), see geoIPNegativeTTL
+	negative    sync.Map // map[netip.Addr]int64
+	negativeLen atomic.Int64
+
+	// LookupCountry counters (see LookupStats)
+	hits, misses, none, negativeHits atomic.Uint64
+
 	// Update metadata
 	mu         sync.RWMutex
 	etag       string
 	lastUpdate time.Time
+	lastErr    error
+	size       int64  // Size of the loaded .mmdb file
+	generation uint64 // Number of successful loads
 	stopCh     chan struct{}
+	stopOnce   sync.Once
+
+	managerBase // ManagerOption settings (interval, logger, validator)
 }
 
 // NewGeoIPManager creates a new GeoIP manager
-func NewGeoIPManager(url, cacheDir string) *GeoIPManager {
+func NewGeoIPManager(url, cacheDir string, opts ...ManagerOption) *GeoIPManager {
 	if url == "" {
 		url = DefaultGeoIPURL
 	}
 
-	return &GeoIPManager{
-		url:      url,
-		cacheDir: cacheDir,
-		stopCh:   make(chan struct{}),
+	m := &GeoIPManager{
+		url:    url,
+		dl:     newDownloader(120 * time.Second),
+		stopCh: make(chan struct{}),
 	}
+	m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dl, opts)
+	return m
 }
 
 // Init initializes the GeoIP manager: checks cache → downloads if needed → starts auto-update
 func (m *GeoIPManager) Init() error {
 	// Create cache directory
-	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
+	if !m.readOnly {
+		if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
 	}
 
 	// 1. Check cache
@@ -71,28 +100,36 @@ func (m *GeoIPManager) Init() error {
 	if _, err := os.Stat(cachedPath); err == nil {
 		// Cache exists, try to load it
 		if err := m.loadDatabase(cachedPath); err == nil {
-			slog.Info("geoip loaded from cache")
+			m.logger().Info("geoip loaded from cache")
+			publishReload(m)
 			// Successfully loaded from cache, start background update check
-			go m.startAutoUpdate()
+			if !m.readOnly {
+				safeGo("geoip", m.startAutoUpdate)
+			}
 			return nil
 		}
 		// Cache corrupted, will re-download
-		slog.Warn("geoip cache corrupted, will re-download")
+		m.logger().Warn("geoip cache corrupted, will re-download")
+	}
+
+	if m.readOnly {
+		m.logger().Warn("geoip cache not found, download skipped, cache is read-only")
+		return nil
 	}
 
 	// 2. Cache doesn't exist or is corrupted, download in background (non-blocking)
-	slog.Info("geoip cache not found, downloading in background")
-	go func() {
-		retryForever("geoip", func() error { return m.downloadAndLoad(false) })
+	m.logger().Info("geoip cache not found, downloading in background")
+	safeGo("geoip", func() {
+		retryForever("geoip", m.stopCh, func() error { return m.downloadAndLoad(false) })
 		m.startAutoUpdate()
-	}()
+	})
 
 	return nil
 }
 
-// Stop stops the auto-update background task
+// Stop stops the auto-update background task and closes the database
 func (m *GeoIPManager) Stop() {
-	close(m.stopCh)
+	m.stopUpdates()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.reader != nil {
@@ -101,11 +138,25 @@ func (m *GeoIPManager) Stop() {
 	}
 }
 
+// stopUpdates stops the background download and auto-update tasks only
+func (m *GeoIPManager) stopUpdates() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// Update manually triggers a GeoIP database update check
+func (m *GeoIPManager) Update() error {
+	if m.skipUpdate("geoip") {
+		return nil
+	}
+	return m.downloadAndLoad(true)
+}
+
 // LookupCountry looks up the ISO country code for an IP address.
 // Returns the 2-letter country code (e.g., "US", "CN") or error if not found.
 //
 // Uses LookupOffset + offset cache for zero-alloc lookups after warmup (~250 unique records).
-// Only decodes the iso_code field via minimal countryRecord struct.
+// Only decodes the iso_code field via minimal countryRecord struct. IPs without a
+// country are remembered for geoIPNegativeTTL.
 func (m *GeoIPManager) LookupCountry(ip net.IP) (string, error) {
 	m.mu.RLock()
 	reader := m.reader
@@ -115,17 +166,31 @@ func (m *GeoIPManager) LookupCountry(ip net.IP) (string, error) {
 		return "", fmt.Errorf("GeoIP database not loaded")
 	}
 
+	// Step 0: Negative cache — no trie traversal for known misses
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+	if expires, ok := m.negative.Load(addr); ok && expires.(int64) > time.Now().UnixNano() {
+		m.negativeHits.Add(1)
+		return "", errNoCountry
+	}
+
 	// Step 1: Trie traversal — reads mmap pages only, zero heap allocations
 	offset, err := reader.LookupOffset(ip)
 	if err != nil {
 		return "", fmt.Errorf("GeoIP lookup failed: %w", err)
 	}
 	if offset == maxminddb.NotFound {
-		return "", fmt.Errorf("no country found for IP")
+		m.noCountry(addr)
+		return "", errNoCountry
 	}
 
-	// Step 2: Cache hit — zero allocations
+	// Step 2: Cache hit — zero allocations ("" = record without a country)
 	if cached, ok := m.cache.Load(offset); ok {
+		if cached.(string) == "" {
+			m.noCountry(addr)
+			return "", errNoCountry
+		}
+		m.hits.Add(1)
 		return cached.(string), nil
 	}
 
@@ -136,81 +201,103 @@ func (m *GeoIPManager) LookupCountry(ip net.IP) (string, error) {
 	}
 
 	code := record.Country.IsoCode
+	m.cache.Store(offset, code)
 	if code == "" {
-		return "", fmt.Errorf("no country found for IP")
+		m.noCountry(addr)
+		return "", errNoCountry
 	}
-
-	m.cache.Store(offset, code)
+	m.misses.Add(1)
 	return code, nil
 }
 
-// downloadAndLoad downloads the GeoIP database and loads it
-func (m *GeoIPManager) downloadAndLoad(useETag bool) error {
-	req, err := http.NewRequest("GET", m.url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// noCountry counts a lookup without a country and caches it in the negative
+// cache. A full cache is swept of expired entries; new misses are not cached
+// while it stays full.
+func (m *GeoIPManager) noCountry(addr netip.Addr) {
+	m.none.Add(1)
+	if !addr.IsValid() {
+		return
 	}
-
-	// ETag optimization: 304 Not Modified
-	m.mu.RLock()
-	currentETag := m.etag
-	m.mu.RUnlock()
-
-	if useETag && currentETag != "" {
-		req.Header.Set("If-None-Match", currentETag)
+	if m.negativeLen.Load() >= geoIPNegativeMax {
+		now := time.Now().UnixNano()
+		m.negative.Range(func(key, expires any) bool {
+			if expires.(int64) <= now {
+				if _, loaded := m.negative.LoadAndDelete(key); loaded {
+					m.negativeLen.Add(-1)
+				}
+			}
+			return true
+		})
+		if m.negativeLen.Load() >= geoIPNegativeMax {
+			return
+		}
 	}
+	expires := time.Now().Add(geoIPNegativeTTL).UnixNano()
+	if _, loaded := m.negative.Swap(addr, expires); !loaded {
+		m.negativeLen.Add(1)
+	}
+}
 
-	slog.Debug("downloading geoip", "url", m.url)
+// clearNegative empties the negative cache (the database changed)
+func (m *GeoIPManager) clearNegative() {
+	m.negative.Range(func(key, _ any) bool {
+		if _, loaded := m.negative.LoadAndDelete(key); loaded {
+			m.negativeLen.Add(-1)
+		}
+		return true
+	})
+}
 
-	client := &http.Client{Timeout: 120 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
-	}
-	defer resp.Body.Close()
+// GeoIPLookupStats are the LookupCountry counters of a GeoIPManager
+type GeoIPLookupStats struct {
+	Hits            uint64 `json:"hits"`             // Country served from the offset cache
+	Misses          uint64 `json:"misses"`           // Country record decoded (first lookup of a record)
+	None            uint64 `json:"none"`             // IPs without a country, looked up in the database
+	NegativeHits    uint64 `json:"negative_hits"`    // IPs without a country, served from the negative cache
+	NegativeEntries int    `json:"negative_entries"` // IPs currently in the negative cache
+}
 
-	// 304 Not Modified - no need to update
-	if resp.StatusCode == http.StatusNotModified {
-		slog.Debug("geoip not modified")
-		return nil
+// LookupStats returns the LookupCountry counters. Lookups before the database is
+// loaded and invalid IPs are not counted.
+func (m *GeoIPManager) LookupStats() GeoIPLookupStats {
+	return GeoIPLookupStats{
+		Hits:            m.hits.Load(),
+		Misses:          m.misses.Load(),
+		None:            m.none.Load(),
+		NegativeHits:    m.negativeHits.Load(),
+		NegativeEntries: int(m.negativeLen.Load()),
 	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
-	}
+// downloadAndLoad downloads the GeoIP database and loads it
+func (m *GeoIPManager) downloadAndLoad(useETag bool) (err error) {
+	defer func() { m.setLastError(err) }()
 
-	// Download to temporary file
-	tmpPath := m.getCachePath() + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+	// ETag optimization: 304 Not Modified
+	var currentETag string
+	if useETag {
+		currentETag = m.GetETag()
 	}
 
-	// Decompress gzip if URL ends with .gz
-	var reader io.Reader = resp.Body
-	if filepath.Ext(m.url) == ".gz" {
-		gzReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			tmpFile.Close()
-			os.Remove(tmpPath)
-			return fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
-	}
+	m.logger().Debug("downloading geoip", "url", redactURL(m.url))
 
-	_, err = io.Copy(tmpFile, reader)
-	tmpFile.Close()
+	// Extract tar.gz archives (MaxMind downloads), decompress gzip if URL ends with .gz
+	cachePath := m.getCachePath()
+	var etag string
+	var modified bool
+	if isTarGzURL(m.url) {
+		etag, modified, err = m.fetchArchive(currentETag, cachePath)
+	} else {
+		etag, modified, err = m.dl.fetchValidated(m.url, currentETag, cachePath, filepath.Ext(m.url) == ".gz", m.validator)
+	}
 	if err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to write temp file: %w", err)
+		return err
 	}
 
-	// Atomic rename (overwrite old cache)
-	cachePath := m.getCachePath()
-	if err := os.Rename(tmpPath, cachePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	// 304 Not Modified - no need to update
+	if !modified {
+		m.logger().Debug("geoip not modified")
+		return nil
 	}
 
 	// Hot-reload (atomic swap)
@@ -220,15 +307,31 @@ func (m *GeoIPManager) downloadAndLoad(useETag bool) error {
 
 	// Update metadata
 	m.mu.Lock()
-	m.etag = resp.Header.Get("ETag")
+	m.etag = etag
 	m.lastUpdate = time.Now()
 	m.mu.Unlock()
 
-	slog.Info("geoip downloaded and loaded")
+	m.logger().Info("geoip downloaded and loaded")
+	publishReload(m)
 
 	return nil
 }
 
+// fetchArchive downloads a tar.gz archive and extracts its .mmdb file to cachePath;
+// the archive itself is not kept
+func (m *GeoIPManager) fetchArchive(etag, cachePath string) (newETag string, modified bool, err error) {
+	archivePath := cachePath + ".tar.gz"
+	newETag, modified, err = m.dl.fetch(m.url, etag, archivePath, false)
+	if err != nil || !modified {
+		return newETag, modified, err
+	}
+	defer os.Remove(archivePath)
+	if err := extractMMDB(archivePath, cachePath, m.validator); err != nil {
+		return "", false, err
+	}
+	return newETag, true, nil
+}
+
 // loadDatabase loads a GeoIP database from a file.
 // Uses maxminddb.Open directly (mmap, MAP_SHARED, PROT_READ).
 func (m *GeoIPManager) loadDatabase(path string) error {
@@ -237,19 +340,27 @@ func (m *GeoIPManager) loadDatabase(path string) error {
 		return fmt.Errorf("failed to open GeoIP database: %w", err)
 	}
 
+	var size int64
+	if stat, err := os.Stat(path); err == nil {
+		size = stat.Size()
+	}
+
 	// Atomic swap + clear offset cache (offsets belong to old reader)
 	m.mu.Lock()
 	oldReader := m.reader
 	m.reader = reader
 	m.cache = sync.Map{}
+	m.size = size
+	m.generation++
 	m.mu.Unlock()
+	m.clearNegative()
 
 	// Grace period: concurrent LookupCountry() calls may still hold the old reader pointer
 	if oldReader != nil {
-		go func() {
+		safeGo("geoip", func() {
 			time.Sleep(5 * time.Second)
 			oldReader.Close()
-		}()
+		})
 	}
 
 	return nil
@@ -257,20 +368,7 @@ func (m *GeoIPManager) loadDatabase(path string) error {
 
 // startAutoUpdate runs background auto-update (every 7 days)
 func (m *GeoIPManager) startAutoUpdate() {
-	ticker := time.NewTicker(7 * 24 * time.Hour)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			// Check for updates (use ETag)
-			if err := m.downloadAndLoad(true); err != nil {
-				slog.Warn("geoip auto-update failed", "error", err)
-			}
-		case <-m.stopCh:
-			return
-		}
-	}
+	m.autoUpdate(ComponentGeoIP, 7*24*time.Hour, m.stopCh, func() error { return m.downloadAndLoad(true) })
 }
 
 // getCachePath returns the cache file path (based on URL hash)
@@ -293,3 +391,53 @@ func (m *GeoIPManager) GetLastUpdate() time.Time {
 	defer m.mu.RUnlock()
 	return m.lastUpdate
 }
+
+// GetLastError returns the error of the most recent download attempt (nil after success)
+func (m *GeoIPManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Status returns a snapshot of the GeoIP database state.
+// BuildTime comes from the mmdb metadata build epoch.
+func (m *GeoIPManager) Status() ComponentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info := ComponentInfo{
+		Name:       ComponentGeoIP,
+		Source:     m.url,
+		Loaded:     m.reader != nil,
+		Generation: m.generation,
+		LastUpdate: m.lastUpdate,
+		LastError:  errorString(m.lastErr),
+		Size:       m.size,
+		ReadOnly:   m.readOnly,
+	}
+	if m.reader != nil {
+		info.BuildTime = time.Unix(int64(m.reader.Metadata.BuildEpoch), 0)
+		info.Entries = int(m.reader.Metadata.NodeCount)
+		info.DatabaseType = m.reader.Metadata.DatabaseType
+	}
+	return info
+}
+
+// trimmedCountries returns the countries of a trimmed database (k2rule-gen
+// generate-geoip); ok is false for full databases or none loaded
+func (m *GeoIPManager) trimmedCountries() (countries []string, ok bool) {
+	m.mu.RLock()
+	reader := m.reader
+	m.mu.RUnlock()
+	if reader == nil {
+		return nil, false
+	}
+	return mmdb.TrimmedCountries(reader.Metadata)
+}
+
+// setLastError records the outcome of a download attempt
+func (m *GeoIPManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
@@ -2,7 +2,11 @@ package k2rule
 
 import (
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestGeoIPManager_Init(t *testing.T) {
@@ -86,3 +90,43 @@ func TestNewGeoIPManager_NoCacheDirFallback(t *testing.T) {
 		t.Errorf("expected empty cacheDir, got %q", manager.cacheDir)
 	}
 }
+
+func TestGeoIPManager_LookupStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewGeoIPManager("", t.TempDir())
+	if err := m.loadDatabase(path); err != nil {
+		t.Fatalf("loadDatabase failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if country, err := m.LookupCountry(net.ParseIP("8.8.8.8")); err != nil || country != "US" {
+			t.Fatalf("LookupCountry(8.8.8.8) = %q, %v, want US", country, err)
+		}
+		if _, err := m.LookupCountry(net.ParseIP("10.0.0.1")); err == nil {
+			t.Fatal("LookupCountry(10.0.0.1) succeeded")
+		}
+	}
+	want := GeoIPLookupStats{Hits: 2, Misses: 1, None: 1, NegativeHits: 2, NegativeEntries: 1}
+	if got := m.LookupStats(); got != want {
+		t.Errorf("LookupStats() = %+v, want %+v", got, want)
+	}
+
+	// Expired entries are looked up again
+	addr := netip.MustParseAddr("10.0.0.1")
+	m.negative.Store(addr, time.Now().Add(-time.Second).UnixNano())
+	m.LookupCountry(net.ParseIP("10.0.0.1"))
+	if got := m.LookupStats(); got.None != 2 || got.NegativeEntries != 1 {
+		t.Errorf("LookupStats() after expiry = %+v, want None 2, 1 entry", got)
+	}
+
+	// A reload clears the negative cache
+	if err := m.loadDatabase(path); err != nil {
+		t.Fatalf("loadDatabase failed: %v", err)
+	}
+	if got := m.LookupStats().NegativeEntries; got != 0 {
+		t.Errorf("NegativeEntries after reload = %d, want 0", got)
+	}
+}
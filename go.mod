@@ -5,7 +5,6 @@ go 1.21
 require (
 	github.com/edsrzf/mmap-go v1.2.0
 	github.com/oschwald/maxminddb-golang v1.11.0
+	golang.org/x/sys v0.9.0
 	gopkg.in/yaml.v3 v3.0.1
 )
-
-require golang.org/x/sys v0.9.0 // indirect
@@ -0,0 +1,60 @@
+package k2rule
+
+import "sync"
+
+var (
+	targetHandlersMu sync.RWMutex
+	targetHandlers   = make(map[Target]string)
+)
+
+// SetTargetHandler maps a target to an upstream identifier of the embedding proxy,
+// e.g. a Clash proxy name or a sing-box outbound tag. An empty handler removes the mapping.
+//
+// Example:
+//
+//	k2rule.SetTargetHandler(k2rule.TargetProxy, "hk-01")
+//	k2rule.SetTargetHandler(k2rule.TargetDirect, "direct")
+//	target, tag := k2rule.ResolveHandler("google.com") // PROXY, "hk-01"
+func SetTargetHandler(target Target, handler string) {
+	targetHandlersMu.Lock()
+	defer targetHandlersMu.Unlock()
+	if handler == "" {
+		delete(targetHandlers, target)
+		return
+	}
+	targetHandlers[target] = handler
+}
+
+// TargetHandler returns the upstream identifier mapped to a target
+func TargetHandler(target Target) (string, bool) {
+	targetHandlersMu.RLock()
+	defer targetHandlersMu.RUnlock()
+	handler, ok := targetHandlers[target]
+	return handler, ok
+}
+
+// TargetHandlers returns a copy of all target → handler mappings
+func TargetHandlers() map[Target]string {
+	targetHandlersMu.RLock()
+	defer targetHandlersMu.RUnlock()
+	handlers := make(map[Target]string, len(targetHandlers))
+	for target, handler := range targetHandlers {
+		handlers[target] = handler
+	}
+	return handlers
+}
+
+// ClearTargetHandlers removes all target → handler mappings
+func ClearTargetHandlers() {
+	targetHandlersMu.Lock()
+	defer targetHandlersMu.Unlock()
+	targetHandlers = make(map[Target]string)
+}
+
+// ResolveHandler matches input and returns the target with its mapped handler
+// ("" if the target has no handler).
+func ResolveHandler(input string) (Target, string) {
+	target := Match(input)
+	handler, _ := TargetHandler(target)
+	return target, handler
+}
@@ -0,0 +1,39 @@
+package k2rule
+
+import "testing"
+
+func TestResolveHandler(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer ClearTargetHandlers()
+
+	SetTargetHandler(TargetProxy, "hk-01")
+	SetTargetHandler(TargetDirect, "direct")
+	SetTmpRule("proxied.com", TargetProxy)
+	SetTmpRule("blocked.com", TargetReject)
+
+	tests := []struct {
+		input       string
+		wantTarget  Target
+		wantHandler string
+	}{
+		{"proxied.com", TargetProxy, "hk-01"},
+		{"192.168.1.1", TargetDirect, "direct"},
+		{"blocked.com", TargetReject, ""}, // no handler mapped
+	}
+	for _, tt := range tests {
+		target, handler := ResolveHandler(tt.input)
+		if target != tt.wantTarget || handler != tt.wantHandler {
+			t.Errorf("ResolveHandler(%q) = (%v, %q), want (%v, %q)",
+				tt.input, target, handler, tt.wantTarget, tt.wantHandler)
+		}
+	}
+
+	SetTargetHandler(TargetProxy, "")
+	if _, ok := TargetHandler(TargetProxy); ok {
+		t.Error("empty handler did not remove the mapping")
+	}
+	if got := TargetHandlers(); len(got) != 1 || got[TargetDirect] != "direct" {
+		t.Errorf("TargetHandlers() = %v, want only DIRECT", got)
+	}
+}
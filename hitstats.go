@@ -0,0 +1,317 @@
+package k2rule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// HitStatsFileName is the report file written to CacheDir when HitStatsConfig.WriteFile is set
+const HitStatsFileName = "hitstats.json"
+
+// maxUnknownKeyLen truncates counted unknown inputs
+const maxUnknownKeyLen = 64
+
+// maxHitKeysFactor bounds the distinct keys tracked per report window to TopN × factor;
+// when full, the less frequent half is dropped
+const maxHitKeysFactor = 20
+
+// HitStatsConfig enables hit statistics: Match and IsPorn results are counted and
+// exported periodically as top-N reports, giving rule maintainers data about which
+// rules matter in the field.
+//
+// Domains are counted as looked up (or aggregated to their parent domain with Anonymize);
+// IPs are always aggregated to their /24 (IPv4) or /48 (IPv6) network. LAN IPs are not counted.
+type HitStatsConfig struct {
+	Interval  Duration `json:"interval,omitempty"`   // Report period (0 = 1h)
+	TopN      int      `json:"top_n,omitempty"`      // Entries per list in a report (0 = 100)
+	MinCount  uint64   `json:"min_count,omitempty"`  // Omit entries with fewer hits from reports
+	Anonymize bool     `json:"anonymize,omitempty"`  // Count domains as their parent domain, e.g. "example.co.uk"
+	WriteFile bool     `json:"write_file,omitempty"` // Write each report to CacheDir/hitstats.json
+
+	// OnExport is called with each periodic report (nil = none). Not serialized.
+	OnExport func(HitReport) `json:"-"`
+}
+
+// HitReport is the hit statistics of one report period
+type HitReport struct {
+	Start    time.Time             `json:"start"`
+	End      time.Time             `json:"end"`
+	Total    uint64                `json:"total"`              // Counted lookups
+	Targets  map[string][]HitCount `json:"targets"`            // Rule matches per target name, most hits first
+	Fallback []HitCount            `json:"fallback,omitempty"` // Lookups no rule matched (candidates for new rules)
+	Porn     []HitCount            `json:"porn,omitempty"`     // Porn detections
+	Unknown  []HitCount            `json:"unknown,omitempty"`  // Inputs that were neither IPs nor valid domains (truncated)
+}
+
+// HitCount is the number of hits of a domain or network
+type HitCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// globalHits is the active hit collector (nil = disabled)
+var globalHits atomic.Pointer[hitCollector]
+
+// hitCollector counts hits for the current report period
+type hitCollector struct {
+	config   HitStatsConfig
+	cacheDir string
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	mu       sync.Mutex
+	start    time.Time
+	total    uint64
+	targets  map[Target]map[string]uint64
+	fallback map[string]uint64
+	porn     map[string]uint64
+	unknown  map[string]uint64
+}
+
+// newHitCollector creates a collector with defaults applied
+func newHitCollector(config HitStatsConfig, cacheDir string) *hitCollector {
+	if config.Interval <= 0 {
+		config.Interval = Duration(time.Hour)
+	}
+	if config.TopN <= 0 {
+		config.TopN = 100
+	}
+	c := &hitCollector{config: config, cacheDir: cacheDir, stopCh: make(chan struct{})}
+	c.reset(time.Now())
+	return c
+}
+
+// startHitStats replaces the active collector (config nil = disable)
+func startHitStats(config *HitStatsConfig, cacheDir string) {
+	var c *hitCollector
+	if config != nil {
+		c = newHitCollector(*config, cacheDir)
+	}
+	if old := globalHits.Swap(c); old != nil {
+		old.stop()
+	}
+	if c != nil {
+		c.startExport()
+	}
+}
+
+// CurrentHitReport returns the hit statistics of the current period so far
+// (ok = false when hit statistics are disabled).
+func CurrentHitReport() (report HitReport, ok bool) {
+	c := globalHits.Load()
+	if c == nil {
+		return HitReport{}, false
+	}
+	return c.snapshot(time.Now(), false), true
+}
+
+// FlushHitStats ends the current report period now and exports its report
+// (OnExport, WriteFile) as a periodic export would.
+func FlushHitStats() (report HitReport, ok bool) {
+	c := globalHits.Load()
+	if c == nil {
+		return HitReport{}, false
+	}
+	return c.export(), true
+}
+
+// record counts a Match decision
+func (c *hitCollector) record(input string, result MatchResult) {
+	if result.Unknown {
+		key := input
+		if len(key) > maxUnknownKeyLen {
+			// Cut on a rune boundary so the key stays valid UTF-8 in the JSON report
+			n := maxUnknownKeyLen
+			for n > 0 && !utf8.RuneStart(key[n]) {
+				n--
+			}
+			key = key[:n]
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.total++
+		c.unknown = c.add(c.unknown, key)
+		return
+	}
+
+	var key string
+	if ip := net.ParseIP(input); ip != nil {
+		if isPrivateIP(ip) {
+			return
+		}
+		key = ipNetworkKey(ip)
+	} else {
+		key = c.domainKey(input)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total++
+	if result.Fallback {
+		c.fallback = c.add(c.fallback, key)
+		return
+	}
+	c.targets[result.Target] = c.add(c.targets[result.Target], key)
+}
+
+// recordPorn counts a porn detection
+func (c *hitCollector) recordPorn(domain string) {
+	key := c.domainKey(domain)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.porn = c.add(c.porn, key)
+}
+
+// add increments key in counts, pruning the less frequent half when the map is full
+func (c *hitCollector) add(counts map[string]uint64, key string) map[string]uint64 {
+	if counts == nil {
+		counts = make(map[string]uint64)
+	}
+	if _, ok := counts[key]; !ok && len(counts) >= c.config.TopN*maxHitKeysFactor {
+		for _, hc := range topHits(counts, len(counts), 0)[len(counts)/2:] {
+			delete(counts, hc.Key)
+		}
+	}
+	counts[key]++
+	return counts
+}
+
+// domainKey returns the counted key of a domain
+func (c *hitCollector) domainKey(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if c.config.Anonymize {
+		return parentDomain(domain)
+	}
+	return domain
+}
+
+// reset starts a new report period (c.mu held or not yet shared)
+func (c *hitCollector) reset(now time.Time) {
+	c.start = now
+	c.total = 0
+	c.targets = make(map[Target]map[string]uint64)
+	c.fallback = nil
+	c.porn = nil
+	c.unknown = nil
+}
+
+// snapshot builds the report of the current period, optionally starting a new one
+func (c *hitCollector) snapshot(now time.Time, reset bool) HitReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := HitReport{
+		Start:    c.start,
+		End:      now,
+		Total:    c.total,
+		Targets:  make(map[string][]HitCount, len(c.targets)),
+		Fallback: topHits(c.fallback, c.config.TopN, c.config.MinCount),
+		Porn:     topHits(c.porn, c.config.TopN, c.config.MinCount),
+		Unknown:  topHits(c.unknown, c.config.TopN, c.config.MinCount),
+	}
+	for target, counts := range c.targets {
+		if top := topHits(counts, c.config.TopN, c.config.MinCount); len(top) > 0 {
+			report.Targets[target.String()] = top
+		}
+	}
+	if reset {
+		c.reset(now)
+	}
+	return report
+}
+
+// export ends the current period and delivers its report
+func (c *hitCollector) export() HitReport {
+	report := c.snapshot(time.Now(), true)
+	if c.config.WriteFile && c.cacheDir != "" {
+		if err := writeHitReport(filepath.Join(c.cacheDir, HitStatsFileName), report); err != nil {
+			slog.Warn("failed to write hit statistics", "error", err)
+		}
+	}
+	if c.config.OnExport != nil {
+		safeCall("hitstats", func() error {
+			c.config.OnExport(report)
+			return nil
+		})
+	}
+	return report
+}
+
+// startExport exports a report every Interval until stopped
+func (c *hitCollector) startExport() {
+	sched := newScheduler("hitstats", every(time.Duration(c.config.Interval)), 0, nil, func(bool) error {
+		c.export()
+		return nil
+	})
+	safeGo("hitstats", func() { sched.run(c.stopCh) })
+}
+
+// stop ends periodic exports
+func (c *hitCollector) stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+// writeHitReport atomically writes report as JSON to path
+func writeHitReport(path string, report HitReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode hit report: %w", err)
+	}
+	return writeFileAtomic(path, bytes.NewReader(data))
+}
+
+// topHits returns up to n entries with at least minCount hits, most hits first
+// (ties ordered by key)
+func topHits(counts map[string]uint64, n int, minCount uint64) []HitCount {
+	hits := make([]HitCount, 0, len(counts))
+	for key, count := range counts {
+		if count >= minCount {
+			hits = append(hits, HitCount{Key: key, Count: count})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Count != hits[j].Count {
+			return hits[i].Count > hits[j].Count
+		}
+		return hits[i].Key < hits[j].Key
+	})
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	return hits
+}
+
+// ipNetworkKey returns the /24 (IPv4) or /48 (IPv6) network of ip
+func ipNetworkKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// parentDomain approximates the registrable domain: the last two labels, or three
+// when the second-level label looks like a country-code suffix ("example.co.uk")
+func parentDomain(domain string) string {
+	labels := strings.Split(domain, ".")
+	n := 2
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && len(labels[len(labels)-2]) <= 3 {
+		n = 3
+	}
+	if len(labels) <= n {
+		return domain
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
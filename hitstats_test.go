@@ -0,0 +1,137 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestHitStats_Report(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	cacheDir := t.TempDir()
+	rulePath := filepath.Join(cacheDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", cacheDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var exported []HitReport
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	startHitStats(&HitStatsConfig{
+		TopN:      2,
+		WriteFile: true,
+		OnExport:  func(r HitReport) { exported = append(exported, r) },
+	}, cacheDir)
+
+	for i := 0; i < 3; i++ {
+		Match("ads.blocked.com")
+	}
+	Match("blocked.com")
+	Match("a.blocked.com")   // beyond TopN
+	Match("unknown.com")     // fallback
+	Match("8.8.8.8")         // aggregated to /24
+	MatchVerbose("8.8.8.4")  // same /24
+	Match("192.168.1.1")     // LAN: not counted
+	IsExplicitMatch("x.com") // queries are not counted
+	Match("x.com:443")       // unknown input
+
+	report, ok := CurrentHitReport()
+	if !ok {
+		t.Fatal("CurrentHitReport() disabled")
+	}
+	if report.Total != 9 {
+		t.Errorf("Total = %d, want 9", report.Total)
+	}
+	if want := []HitCount{{"x.com:443", 1}}; fmt.Sprint(report.Unknown) != fmt.Sprint(want) {
+		t.Errorf("Unknown = %v, want %v", report.Unknown, want)
+	}
+	wantReject := []HitCount{{"ads.blocked.com", 3}, {"a.blocked.com", 1}}
+	if got := report.Targets["REJECT"]; fmt.Sprint(got) != fmt.Sprint(wantReject) {
+		t.Errorf("REJECT = %v, want %v", got, wantReject)
+	}
+	wantFallback := []HitCount{{"8.8.8.0/24", 2}, {"unknown.com", 1}}
+	if fmt.Sprint(report.Fallback) != fmt.Sprint(wantFallback) {
+		t.Errorf("Fallback = %v, want %v", report.Fallback, wantFallback)
+	}
+
+	flushed, _ := FlushHitStats()
+	if len(exported) != 1 || flushed.Total != 9 {
+		t.Fatalf("FlushHitStats exported %d reports, total %d", len(exported), flushed.Total)
+	}
+	data, err := os.ReadFile(filepath.Join(cacheDir, HitStatsFileName))
+	if err != nil {
+		t.Fatalf("report file not written: %v", err)
+	}
+	var written HitReport
+	if err := json.Unmarshal(data, &written); err != nil || written.Total != 9 {
+		t.Errorf("written report = %+v, %v", written, err)
+	}
+	if next, _ := CurrentHitReport(); next.Total != 0 {
+		t.Errorf("Total after flush = %d, want 0", next.Total)
+	}
+}
+
+func TestHitStats_AnonymizeAndPrune(t *testing.T) {
+	c := newHitCollector(HitStatsConfig{TopN: 1, Anonymize: true, MinCount: 2}, "")
+	c.record("www.example.co.uk", MatchResult{Target: TargetProxy})
+	c.record("mail.example.co.uk", MatchResult{Target: TargetProxy})
+	c.record("a.b.example.com", MatchResult{Target: TargetProxy})
+	c.recordPorn("x.adult-example.com")
+
+	report := c.snapshot(time.Now(), false)
+	if got := report.Targets["PROXY"]; len(got) != 1 || got[0] != (HitCount{"example.co.uk", 2}) {
+		t.Errorf("PROXY = %v, want [{example.co.uk 2}]", got)
+	}
+	if report.Porn != nil {
+		t.Errorf("Porn = %v, want nil below MinCount", report.Porn)
+	}
+
+	// Distinct keys are bounded to TopN × maxHitKeysFactor
+	for i := 0; i < 100; i++ {
+		c.record(fmt.Sprintf("host%d.net", i), MatchResult{Target: TargetDirect})
+	}
+	if n := len(c.targets[TargetDirect]); n > maxHitKeysFactor {
+		t.Errorf("tracked %d keys, want <= %d", n, maxHitKeysFactor)
+	}
+}
+
+func TestParentDomain(t *testing.T) {
+	tests := map[string]string{
+		"www.google.com":    "google.com",
+		"google.com":        "google.com",
+		"a.b.example.co.uk": "example.co.uk",
+		"www.bbc.com.cn":    "bbc.com.cn",
+		"localhost":         "localhost",
+	}
+	for in, want := range tests {
+		if got := parentDomain(in); got != want {
+			t.Errorf("parentDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHitStats_UnknownKeyTruncatedOnRuneBoundary(t *testing.T) {
+	c := newHitCollector(HitStatsConfig{}, "")
+	c.record("a"+strings.Repeat("é", maxUnknownKeyLen), MatchResult{Unknown: true})
+
+	report := c.snapshot(time.Now(), false)
+	if len(report.Unknown) != 1 {
+		t.Fatalf("Unknown = %v, want one key", report.Unknown)
+	}
+	key := report.Unknown[0].Key
+	if !utf8.ValidString(key) || len(key) > maxUnknownKeyLen {
+		t.Errorf("key = %q (%d bytes), want valid UTF-8 of at most %d bytes", key, len(key), maxUnknownKeyLen)
+	}
+}
@@ -0,0 +1,25 @@
+package k2rule
+
+import "github.com/kaitu-io/k2rule/internal/homograph"
+
+// IsSuspiciousHomograph reports whether domain (Punycode "xn--" or Unicode form) is an
+// internationalized name that visually imitates another, e.g. "xn--80ak6aa92e.com"
+// rendering as "аррӏе.com". Detected: Latin mixed with Cyrillic, Greek or Armenian in
+// one label, labels made entirely of lookalikes of ASCII letters (outside IDN TLDs),
+// lookalikes of well-known brands, invisible characters and malformed Punycode.
+// Plain ASCII domains are never reported. No I/O.
+//
+// Useful for phishing protection alongside porn detection:
+//
+//	if k2rule.IsSuspiciousHomograph(host) {
+//	    return k2rule.TargetReject
+//	}
+func IsSuspiciousHomograph(domain string) bool {
+	return homograph.IsSuspicious(domain)
+}
+
+// HomographReason returns why IsSuspiciousHomograph reports domain ("" if it doesn't):
+// "invalid-punycode", "invisible-char", "mixed-script", "whole-script" or "brand-lookalike"
+func HomographReason(domain string) string {
+	return string(homograph.Check(domain).Reason)
+}
@@ -0,0 +1,23 @@
+package k2rule
+
+import "testing"
+
+func TestIsSuspiciousHomograph(t *testing.T) {
+	tests := []struct {
+		domain string
+		reason string
+	}{
+		{"apple.com", ""},
+		{"www.xn--bcher-kva.de", ""},
+		{"xn--80ak6aa92e.com", "brand-lookalike"},
+		{"secure.xn--pypal-4ve.com", "mixed-script"},
+	}
+	for _, tt := range tests {
+		if got := IsSuspiciousHomograph(tt.domain); got != (tt.reason != "") {
+			t.Errorf("IsSuspiciousHomograph(%q) = %v", tt.domain, got)
+		}
+		if got := HomographReason(tt.domain); got != tt.reason {
+			t.Errorf("HomographReason(%q) = %q, want %q", tt.domain, got, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package k2rule
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Names of the external hooks triggered by Match (keys of Config.HookLimits)
+const (
+	HookRDNS   = "rdns"   // PTR lookups against the system resolver (Config.EnableRDNS)
+	HookReject = "reject" // OnReject handler calls
+)
+
+// Circuit breaker states of a hook (HookInfo.State)
+const (
+	HookClosed   = "closed"    // Calls pass (subject to the rate limit)
+	HookOpen     = "open"      // Calls are skipped until the cooldown ends
+	HookHalfOpen = "half-open" // Cooldown over: one probe call decides whether to close again
+)
+
+// defaultHookLimits are the limits of hooks not listed in Config.HookLimits
+var defaultHookLimits = map[string]HookLimit{
+	HookRDNS:   {Rate: 50, Burst: 100, FailureThreshold: 10, Cooldown: Duration(30 * time.Second)},
+	HookReject: {Rate: 10, Burst: 60, FailureThreshold: 5, Cooldown: Duration(time.Minute)},
+}
+
+// HookLimit bounds the calls of an external hook, so a storm of lookups cannot flood
+// DNS servers or handler endpoints: a token bucket limits the call rate, and a circuit
+// breaker skips calls for Cooldown after FailureThreshold consecutive failures (PTR
+// lookups that time out or fail with a server error, OnReject handlers that panic).
+// Skipped PTR lookups leave the IP without a name; skipped OnReject calls are dropped.
+//
+// Example:
+//
+//	config.HookLimits = map[string]k2rule.HookLimit{
+//	    k2rule.HookRDNS: {Rate: 10, Burst: 20},
+//	}
+type HookLimit struct {
+	Rate             float64  `json:"rate,omitempty"`              // Calls per second (0 = hook default)
+	Burst            int      `json:"burst,omitempty"`             // Calls allowed at once (0 = hook default)
+	FailureThreshold int      `json:"failure_threshold,omitempty"` // Consecutive failures opening the breaker (0 = hook default)
+	Cooldown         Duration `json:"cooldown,omitempty"`          // Time the breaker stays open (0 = hook default)
+}
+
+// HookInfo is the state of a hook limiter (see HookStats)
+type HookInfo struct {
+	Name           string    `json:"name"`
+	Limit          HookLimit `json:"limit"`           // Effective limits (defaults applied)
+	State          string    `json:"state"`           // HookClosed, HookOpen or HookHalfOpen
+	Allowed        uint64    `json:"allowed"`         // Calls made
+	Limited        uint64    `json:"limited"`         // Calls skipped by the rate limit
+	ShortCircuited uint64    `json:"short_circuited"` // Calls skipped while the breaker was open
+	Failures       uint64    `json:"failures"`        // Calls that failed
+	Trips          uint64    `json:"trips"`           // Times the breaker opened
+}
+
+// HookStats returns the limits, breaker state and counters of each hook
+func HookStats() []HookInfo {
+	now := time.Now()
+	return []HookInfo{
+		globalHookLimits.rdns.info(HookRDNS, now),
+		globalHookLimits.reject.info(HookReject, now),
+	}
+}
+
+// globalHookLimits are the limiters of the hooks (configured by Init)
+var globalHookLimits struct {
+	rdns, reject hookLimiter
+}
+
+func init() {
+	configureHookLimits(nil)
+}
+
+// configureHookLimits applies the Config.HookLimits settings (nil = defaults),
+// resetting the limiters and their counters
+func configureHookLimits(limits map[string]HookLimit) {
+	globalHookLimits.rdns.configure(limits[HookRDNS].normalized(HookRDNS))
+	globalHookLimits.reject.configure(limits[HookReject].normalized(HookReject))
+}
+
+// validateHookLimits checks the Config.HookLimits settings
+func validateHookLimits(limits map[string]HookLimit) error {
+	for name, l := range limits {
+		if _, ok := defaultHookLimits[name]; !ok {
+			return fmt.Errorf("unknown hook %q in HookLimits", name)
+		}
+		if l.Rate < 0 || l.Burst < 0 || l.FailureThreshold < 0 || l.Cooldown < 0 {
+			return fmt.Errorf("%s hook: limits cannot be negative", name)
+		}
+	}
+	return nil
+}
+
+// normalized returns l with the defaults of hook applied
+func (l HookLimit) normalized(hook string) HookLimit {
+	def := defaultHookLimits[hook]
+	if l.Rate == 0 {
+		l.Rate = def.Rate
+	}
+	if l.Burst == 0 {
+		l.Burst = def.Burst
+	}
+	if l.FailureThreshold == 0 {
+		l.FailureThreshold = def.FailureThreshold
+	}
+	if l.Cooldown == 0 {
+		l.Cooldown = def.Cooldown
+	}
+	return l
+}
+
+// hookLimiter is the token bucket and circuit breaker of a hook. Callers ask allow
+// before a call and report its outcome with done.
+type hookLimiter struct {
+	mu        sync.Mutex
+	limit     HookLimit
+	tokens    float64
+	refilled  time.Time
+	failures  int       // Consecutive failures
+	openUntil time.Time // Breaker open until (zero = closed)
+	probing   bool      // Half-open probe call in progress
+
+	allowed, limited, shorted, failed, trips uint64
+}
+
+// configure sets the limits and resets the limiter
+func (h *hookLimiter) configure(limit HookLimit) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limit, h.tokens, h.refilled = limit, float64(limit.Burst), time.Time{}
+	h.failures, h.openUntil, h.probing = 0, time.Time{}, false
+	h.allowed, h.limited, h.shorted, h.failed, h.trips = 0, 0, 0, 0, 0
+}
+
+// allow reports whether a call may be made at now
+func (h *hookLimiter) allow(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	halfOpen := false
+	if !h.openUntil.IsZero() {
+		if now.Before(h.openUntil) || h.probing {
+			h.shorted++
+			return false
+		}
+		halfOpen = true
+	}
+
+	if !h.refilled.IsZero() {
+		h.tokens += now.Sub(h.refilled).Seconds() * h.limit.Rate
+		if burst := float64(h.limit.Burst); h.tokens > burst {
+			h.tokens = burst
+		}
+	}
+	h.refilled = now
+	if h.tokens < 1 {
+		h.limited++
+		return false
+	}
+	h.tokens--
+	h.allowed++
+	h.probing = halfOpen
+	return true
+}
+
+// done records the outcome of an allowed call
+func (h *hookLimiter) done(failed bool, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !failed {
+		h.failures, h.openUntil, h.probing = 0, time.Time{}, false
+		return
+	}
+	h.failed++
+	h.failures++
+	if h.probing || h.failures >= h.limit.FailureThreshold {
+		h.openUntil = now.Add(time.Duration(h.limit.Cooldown))
+		h.failures, h.probing = 0, false
+		h.trips++
+	}
+}
+
+// info returns the state of the limiter at now
+func (h *hookLimiter) info(name string, now time.Time) HookInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := HookClosed
+	switch {
+	case h.openUntil.IsZero():
+	case now.Before(h.openUntil):
+		state = HookOpen
+	default:
+		state = HookHalfOpen
+	}
+	return HookInfo{
+		Name:           name,
+		Limit:          h.limit,
+		State:          state,
+		Allowed:        h.allowed,
+		Limited:        h.limited,
+		ShortCircuited: h.shorted,
+		Failures:       h.failed,
+		Trips:          h.trips,
+	}
+}
@@ -0,0 +1,125 @@
+package k2rule
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHookLimiter_RateLimit(t *testing.T) {
+	var h hookLimiter
+	h.configure(HookLimit{Rate: 2, Burst: 3, FailureThreshold: 5, Cooldown: Duration(time.Minute)})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !h.allow(now) {
+			t.Fatalf("call %d within the burst was limited", i)
+		}
+	}
+	if h.allow(now) {
+		t.Error("call beyond the burst was allowed")
+	}
+	if !h.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("call after refilling one token was limited")
+	}
+	if info := h.info(HookRDNS, now); info.Allowed != 4 || info.Limited != 1 || info.State != HookClosed {
+		t.Errorf("info = %+v, want 4 allowed, 1 limited, closed", info)
+	}
+}
+
+func TestHookLimiter_CircuitBreaker(t *testing.T) {
+	var h hookLimiter
+	h.configure(HookLimit{Rate: 1000, Burst: 1000, FailureThreshold: 3, Cooldown: Duration(time.Minute)})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !h.allow(now) {
+			t.Fatalf("call %d was skipped before the breaker opened", i)
+		}
+		h.done(true, now)
+	}
+	if h.allow(now) {
+		t.Error("call allowed while the breaker is open")
+	}
+	if info := h.info(HookRDNS, now); info.State != HookOpen || info.Trips != 1 || info.ShortCircuited != 1 {
+		t.Errorf("info = %+v, want open after 1 trip", info)
+	}
+
+	// After the cooldown one probe passes; its failure reopens the breaker
+	later := now.Add(2 * time.Minute)
+	if !h.allow(later) {
+		t.Fatal("probe call skipped after the cooldown")
+	}
+	if h.allow(later) {
+		t.Error("second call allowed while the probe is in progress")
+	}
+	h.done(true, later)
+	if info := h.info(HookRDNS, later); info.State != HookOpen || info.Trips != 2 {
+		t.Errorf("info = %+v, want reopened", info)
+	}
+
+	// A successful probe closes it
+	later = later.Add(2 * time.Minute)
+	if !h.allow(later) {
+		t.Fatal("probe call skipped after the second cooldown")
+	}
+	h.done(false, later)
+	if info := h.info(HookRDNS, later); info.State != HookClosed {
+		t.Errorf("state = %s after a successful probe, want closed", info.State)
+	}
+}
+
+func TestHookLimits_RDNSBreaker(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	var queries int
+	orig := rdnsLookup
+	rdnsLookup = func(ctx context.Context, addr string) ([]string, error) {
+		queries++
+		if addr == "203.0.113.99" {
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+		return nil, fmt.Errorf("server failure")
+	}
+	defer func() { rdnsLookup = orig }()
+
+	config := &Config{CacheDir: t.TempDir(), EnableRDNS: true, HookLimits: map[string]HookLimit{HookRDNS: {FailureThreshold: 2}}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	configureHookLimits(config.HookLimits)
+
+	// Names that don't exist aren't failures
+	for i := 0; i < 3; i++ {
+		globalRDNS.clear()
+		globalRDNS.hostname(config, net.ParseIP("203.0.113.99"))
+	}
+	if info := HookStats()[0]; info.Failures != 0 || info.State != HookClosed {
+		t.Fatalf("rdns hook = %+v after NXDOMAIN answers, want no failures", info)
+	}
+
+	for i := 1; i <= 5; i++ {
+		globalRDNS.hostname(config, net.ParseIP(fmt.Sprintf("203.0.113.%d", i)))
+	}
+	if queries != 5 {
+		t.Errorf("%d PTR queries, want 5 (3 answers + 2 failures opening the breaker)", queries)
+	}
+	if info := HookStats()[0]; info.Name != HookRDNS || info.State != HookOpen || info.ShortCircuited != 3 {
+		t.Errorf("rdns hook = %+v, want open with 3 skipped lookups", info)
+	}
+}
+
+func TestConfigValidate_HookLimits(t *testing.T) {
+	for _, limits := range []map[string]HookLimit{
+		{"webhook": {}},
+		{HookRDNS: {Rate: -1}},
+	} {
+		config := &Config{CacheDir: t.TempDir(), HookLimits: limits}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Validate(%v) = nil, want an error", limits)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package k2rule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// globalHosts holds hosts-file mappings (Config.HostsFile, AddHost)
+var globalHosts hostsTable
+
+// hostsTable maps names to IPs: entries from the hosts file plus AddHost entries,
+// published as one map for lock-free lookups
+type hostsTable struct {
+	mu     sync.Mutex
+	file   map[string][]net.IP
+	added  map[string][]net.IP
+	merged atomic.Pointer[map[string][]net.IP]
+}
+
+// AddHost maps name to ips like a hosts file line, replacing an earlier AddHost
+// mapping of name. Hosts entries (AddHost and Config.HostsFile) are local services:
+// Match returns DIRECT for the name, or REJECT when it is mapped to 0.0.0.0 or ::
+// (the blocklist convention), and MatchVerbose reports the first IP in HostIP.
+// AddHost entries take precedence over the hosts file and persist across Init.
+//
+// Example:
+//
+//	k2rule.AddHost("nas.home", net.ParseIP("192.168.1.20"))
+//	r := k2rule.MatchVerbose("nas.home") // → DIRECT, HostIP "192.168.1.20"
+func AddHost(name string, ips ...net.IP) error {
+	name = normalizeOverlayDomain(name)
+	if name == "" || !isValidDomain(name) {
+		return fmt.Errorf("invalid host name %q", name)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no IPs for host %q", name)
+	}
+	for _, ip := range ips {
+		if ip == nil {
+			return fmt.Errorf("invalid IP for host %q", name)
+		}
+	}
+
+	globalHosts.mu.Lock()
+	defer globalHosts.mu.Unlock()
+	if globalHosts.added == nil {
+		globalHosts.added = make(map[string][]net.IP)
+	}
+	globalHosts.added[name] = append([]net.IP(nil), ips...)
+	globalHosts.publishLocked()
+	return nil
+}
+
+// RemoveHost removes the AddHost mapping of name (hosts file entries are unaffected)
+func RemoveHost(name string) {
+	globalHosts.mu.Lock()
+	defer globalHosts.mu.Unlock()
+	delete(globalHosts.added, normalizeOverlayDomain(name))
+	globalHosts.publishLocked()
+}
+
+// ClearHosts removes all AddHost mappings
+func ClearHosts() {
+	globalHosts.mu.Lock()
+	defer globalHosts.mu.Unlock()
+	globalHosts.added = nil
+	globalHosts.publishLocked()
+}
+
+// LookupHost returns the IPs name is mapped to by AddHost or Config.HostsFile
+func LookupHost(name string) ([]net.IP, bool) {
+	ips, ok := globalHosts.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return append([]net.IP(nil), ips...), true
+}
+
+// lookup returns the mapping of name (shared, must not be modified)
+func (h *hostsTable) lookup(name string) ([]net.IP, bool) {
+	merged := h.merged.Load()
+	if merged == nil || len(*merged) == 0 {
+		return nil, false
+	}
+	ips, ok := (*merged)[normalizeOverlayDomain(name)]
+	return ips, ok
+}
+
+// loadFile replaces the hosts file entries with those of path ("" = none)
+func (h *hostsTable) loadFile(path string) error {
+	var entries map[string][]net.IP
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if entries, err = parseHosts(file); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.file = entries
+	h.publishLocked()
+	return nil
+}
+
+// publishLocked publishes the merged mappings (h.mu held)
+func (h *hostsTable) publishLocked() {
+	merged := make(map[string][]net.IP, len(h.file)+len(h.added))
+	for name, ips := range h.file {
+		merged[name] = ips
+	}
+	for name, ips := range h.added {
+		merged[name] = ips
+	}
+	h.merged.Store(&merged)
+}
+
+// parseHosts reads /etc/hosts-style lines ("IP name [aliases...] [# comment]").
+// Lines with an invalid IP or name are skipped; a name on several lines collects
+// the IPs of all of them.
+func parseHosts(r io.Reader) (map[string][]net.IP, error) {
+	entries := make(map[string][]net.IP)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(strings.SplitN(fields[0], "%", 2)[0]) // drop IPv6 zone
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if name = normalizeOverlayDomain(name); isValidDomain(name) {
+				entries[name] = append(entries[name], ip)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %w", err)
+	}
+	return entries, nil
+}
+
+// hostsTarget returns the target of a hosts mapping: REJECT when all IPs are
+// unspecified (0.0.0.0 or ::), else DIRECT
+func hostsTarget(ips []net.IP) Target {
+	for _, ip := range ips {
+		if !ip.IsUnspecified() {
+			return TargetDirect
+		}
+	}
+	return TargetReject
+}
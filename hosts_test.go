@@ -0,0 +1,91 @@
+package k2rule
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	entries, err := parseHosts(strings.NewReader(`
+# comment
+127.0.0.1   localhost
+192.168.1.20 NAS.home nas   # trailing comment
+fe80::1%lo0  router.home
+0.0.0.0      ads.tracker.com
+not-an-ip    bad.home
+10.0.0.5
+10.0.0.6     nas.home
+`))
+	if err != nil {
+		t.Fatalf("parseHosts failed: %v", err)
+	}
+	if got := entries["nas.home"]; len(got) != 2 || !got[0].Equal(net.ParseIP("192.168.1.20")) || !got[1].Equal(net.ParseIP("10.0.0.6")) {
+		t.Errorf("nas.home = %v, want [192.168.1.20 10.0.0.6]", got)
+	}
+	if got := entries["router.home"]; len(got) != 1 || !got[0].Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("router.home = %v, want [fe80::1]", got)
+	}
+	for _, name := range []string{"localhost", "nas", "ads.tracker.com"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("%s missing", name)
+		}
+	}
+	if _, ok := entries["bad.home"]; ok {
+		t.Error("line with invalid IP was parsed")
+	}
+}
+
+func TestHosts_Match(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("192.168.1.20 nas.home\n0.0.0.0 ads.tracker.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := globalHosts.loadFile(path); err != nil {
+		t.Fatalf("loadFile failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{IsGlobal: true, GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if r := MatchVerbose("NAS.home."); r.Target != TargetDirect || r.HostIP != "192.168.1.20" {
+		t.Errorf("MatchVerbose(nas.home) = %+v, want DIRECT via 192.168.1.20", r)
+	}
+	if got := Match("ads.tracker.com"); got != TargetReject {
+		t.Errorf("Match(0.0.0.0 entry) = %v, want REJECT", got)
+	}
+	if got := Match("other.home"); got != TargetProxy {
+		t.Errorf("Match(other.home) = %v, want PROXY (global)", got)
+	}
+
+	// AddHost overrides the file and survives reloading it
+	if err := AddHost("nas.home", net.ParseIP("10.0.0.9")); err != nil {
+		t.Fatalf("AddHost failed: %v", err)
+	}
+	if err := globalHosts.loadFile(path); err != nil {
+		t.Fatalf("loadFile failed: %v", err)
+	}
+	if ips, ok := LookupHost("nas.home"); !ok || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.9")) {
+		t.Errorf("LookupHost(nas.home) = %v, %v; want [10.0.0.9]", ips, ok)
+	}
+	RemoveHost("nas.home")
+	if r := MatchVerbose("nas.home"); r.HostIP != "192.168.1.20" {
+		t.Errorf("after RemoveHost HostIP = %q, want file entry", r.HostIP)
+	}
+
+	if err := AddHost("bad name!", net.ParseIP("10.0.0.1")); err == nil {
+		t.Error("AddHost(invalid name) succeeded")
+	}
+	if err := AddHost("nas2.home"); err == nil {
+		t.Error("AddHost without IPs succeeded")
+	}
+	if err := globalHosts.loadFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadFile(missing) succeeded")
+	}
+}
@@ -0,0 +1,274 @@
+// Package cache provides a size-bounded map with per-entry expiry and a pluggable
+// eviction policy (TTL-only, LRU or LFU), used by the decision caches.
+package cache
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy selects which entry is evicted when a bounded cache is full
+type Policy uint8
+
+const (
+	// TTL never evicts by size: entries only leave when they expire (unbounded)
+	TTL Policy = iota
+	// LRU evicts the least recently used entry
+	LRU
+	// LFU evicts the least frequently used entry (least recently used among equals)
+	LFU
+)
+
+// NoExpiry as the ttl of Put keeps the entry until it is deleted or evicted
+const NoExpiry time.Duration = -1
+
+// sweepInterval is the number of Puts between sweeps of expired entries
+const sweepInterval = 1024
+
+// Stats are the counters of a cache
+type Stats struct {
+	Entries     int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64 // Entries evicted to make room
+	Expirations uint64 // Entries removed after they expired
+}
+
+// Cache maps keys to values that expire. The zero value is an unbounded TTL cache.
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	policy  Policy
+	max     int // 0 = unbounded
+	items   map[K]*list.Element
+	buckets map[uint64]*list.List // Use count → entries, most recently used first
+	minFreq uint64
+	puts    uint64
+	stats   Stats
+}
+
+// entry is a cached value; freq is the use count (LFU) or 1
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires int64 // UnixNano
+	freq    uint64
+}
+
+// New returns a cache with the given policy and entry limit (ignored for TTL)
+func New[K comparable, V any](policy Policy, maxEntries int) *Cache[K, V] {
+	c := &Cache[K, V]{}
+	c.Configure(policy, maxEntries)
+	return c
+}
+
+// Configure changes the policy and entry limit, keeping the entries that fit
+func (c *Cache[K, V]) Configure(policy Policy, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if policy == TTL {
+		maxEntries = 0
+	}
+	if policy == c.policy && maxEntries == c.max {
+		return
+	}
+
+	// Re-insert from least to most used (and recently used) under the new policy
+	freqs := make([]uint64, 0, len(c.buckets))
+	for freq := range c.buckets {
+		freqs = append(freqs, freq)
+	}
+	sort.Slice(freqs, func(i, j int) bool { return freqs[i] < freqs[j] })
+	old := make([]*entry[K, V], 0, len(c.items))
+	for _, freq := range freqs {
+		for e := c.buckets[freq].Back(); e != nil; e = e.Prev() {
+			old = append(old, e.Value.(*entry[K, V]))
+		}
+	}
+	c.policy, c.max = policy, maxEntries
+	c.items, c.buckets, c.minFreq = nil, nil, 0
+	for _, e := range old {
+		if policy != LFU {
+			e.freq = 1
+		}
+		c.insert(e)
+	}
+	c.evict(0)
+}
+
+// Get returns the unexpired value of key. refresh > 0 extends its expiry to now+refresh.
+func (c *Cache[K, V]) Get(key K, refresh time.Duration) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, false
+	}
+	e := elem.Value.(*entry[K, V])
+	now := time.Now().UnixNano()
+	if e.expires <= now {
+		c.remove(elem)
+		c.stats.Expirations++
+		c.stats.Misses++
+		var zero V
+		return zero, false
+	}
+	if refresh > 0 {
+		e.expires = now + int64(refresh)
+	}
+	c.touch(elem)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Put stores value for key until ttl passes (or NoExpiry), evicting per the policy when full
+func (c *Cache[K, V]) Put(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().UnixNano() + int64(ttl)
+	if ttl == NoExpiry {
+		expires = math.MaxInt64
+	}
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.touch(elem)
+		return
+	}
+
+	c.puts++
+	if c.puts%sweepInterval == 0 {
+		c.sweep()
+	}
+	c.evict(1)
+	c.insert(&entry[K, V]{key: key, value: value, expires: expires, freq: 1})
+}
+
+// Delete removes key
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Clear removes all entries (counters are kept)
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items, c.buckets, c.minFreq = nil, nil, 0
+}
+
+// Range calls fn for each unexpired entry until fn returns false, without counting
+// it as a use. fn must not call methods of c.
+func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UnixNano()
+	for key, elem := range c.items {
+		e := elem.Value.(*entry[K, V])
+		if e.expires > now && !fn(key, e.value) {
+			return
+		}
+	}
+}
+
+// Len returns the number of entries, including expired ones not removed yet
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// Stats returns the current counters
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.items)
+	return stats
+}
+
+// insert adds e as the most recently used entry of its use count (c.mu held)
+func (c *Cache[K, V]) insert(e *entry[K, V]) {
+	if c.items == nil {
+		c.items = make(map[K]*list.Element)
+		c.buckets = make(map[uint64]*list.List)
+	}
+	l := c.buckets[e.freq]
+	if l == nil {
+		l = list.New()
+		c.buckets[e.freq] = l
+	}
+	c.items[e.key] = l.PushFront(e)
+	if len(c.items) == 1 || e.freq < c.minFreq {
+		c.minFreq = e.freq
+	}
+}
+
+// touch records a use of elem (c.mu held)
+func (c *Cache[K, V]) touch(elem *list.Element) {
+	switch c.policy {
+	case LRU:
+		c.buckets[1].MoveToFront(elem)
+	case LFU:
+		e := elem.Value.(*entry[K, V])
+		if c.unlink(elem) && e.freq == c.minFreq {
+			c.minFreq++
+		}
+		e.freq++
+		c.insert(e)
+	}
+}
+
+// remove deletes elem (c.mu held)
+func (c *Cache[K, V]) remove(elem *list.Element) {
+	e := elem.Value.(*entry[K, V])
+	if !c.unlink(elem) || e.freq != c.minFreq {
+		return
+	}
+	first := true
+	for freq := range c.buckets {
+		if first || freq < c.minFreq {
+			c.minFreq, first = freq, false
+		}
+	}
+}
+
+// unlink deletes elem without updating minFreq, reporting whether its use-count
+// bucket became empty (c.mu held)
+func (c *Cache[K, V]) unlink(elem *list.Element) bool {
+	e := elem.Value.(*entry[K, V])
+	l := c.buckets[e.freq]
+	l.Remove(elem)
+	delete(c.items, e.key)
+	if l.Len() > 0 {
+		return false
+	}
+	delete(c.buckets, e.freq)
+	return true
+}
+
+// evict removes entries per the policy until room more entries fit (c.mu held)
+func (c *Cache[K, V]) evict(room int) {
+	for c.max > 0 && len(c.items) > 0 && len(c.items)+room > c.max {
+		c.remove(c.buckets[c.minFreq].Back())
+		c.stats.Evictions++
+	}
+}
+
+// sweep removes expired entries (c.mu held)
+func (c *Cache[K, V]) sweep() {
+	now := time.Now().UnixNano()
+	for _, elem := range c.items {
+		if elem.Value.(*entry[K, V]).expires <= now {
+			c.remove(elem)
+			c.stats.Expirations++
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	c := New[string, int](TTL, 2) // limit ignored
+	for i := 0; i < 5; i++ {
+		c.Put(fmt.Sprint(i), i, time.Hour)
+	}
+	if c.Len() != 5 {
+		t.Errorf("Len() = %d, want 5 (TTL cache is unbounded)", c.Len())
+	}
+
+	c.Put("short", 1, time.Millisecond)
+	c.Put("sliding", 2, 20*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.Get("sliding", 50*time.Millisecond); !ok {
+		t.Fatal("Get(sliding) missed before expiry")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("short", 0); ok {
+		t.Error("Get(short) hit after expiry")
+	}
+	if v, ok := c.Get("sliding", 0); !ok || v != 2 {
+		t.Errorf("Get(sliding) = %d, %v after refresh, want 2, true", v, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Expirations != 1 || stats.Evictions != 0 || stats.Entries != 6 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := New[string, int](LRU, 3)
+	c.Put("a", 1, time.Hour)
+	c.Put("b", 2, time.Hour)
+	c.Put("c", 3, time.Hour)
+	c.Get("a", 0) // b is now least recently used
+	c.Put("d", 4, time.Hour)
+
+	if _, ok := c.Get("b", 0); ok {
+		t.Error("least recently used entry b was kept")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key, 0); !ok {
+			t.Errorf("Get(%s) missed", key)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 3 {
+		t.Errorf("Stats() = %+v, want 1 eviction, 3 entries", stats)
+	}
+}
+
+func TestLFU(t *testing.T) {
+	c := New[string, int](LFU, 3)
+	c.Put("a", 1, time.Hour)
+	c.Put("b", 2, time.Hour)
+	c.Put("c", 3, time.Hour)
+	for i := 0; i < 3; i++ {
+		c.Get("a", 0)
+		c.Get("c", 0)
+	}
+	c.Get("b", 0)
+	c.Put("d", 4, time.Hour) // b (2 uses) is evicted, not the most recent use
+	c.Put("e", 5, time.Hour) // d (1 use) is evicted
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": true} {
+		if _, ok := c.Get(key, 0); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	c := New[int, int](TTL, 0)
+	for i := 0; i < 10; i++ {
+		c.Put(i, i, time.Hour)
+	}
+	c.Get(0, 0) // TTL caches don't track use
+
+	// Shrinking to LRU keeps the most recently stored entries
+	c.Configure(LRU, 4)
+	if c.Len() != 4 {
+		t.Fatalf("Len() = %d after Configure(LRU, 4), want 4", c.Len())
+	}
+	for _, key := range []int{6, 7, 8, 9} {
+		if _, ok := c.Get(key, 0); !ok {
+			t.Errorf("Get(%d) missed after Configure", key)
+		}
+	}
+
+	c.Configure(LFU, 4)
+	c.Get(9, 0)
+	c.Put(100, 100, time.Hour)
+	if _, ok := c.Get(9, 0); !ok {
+		t.Error("most used entry evicted after switching to LFU")
+	}
+
+	c.Clear()
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after Clear", c.Len())
+	}
+	c.Put(1, 1, time.Hour)
+	c.Delete(1)
+	if _, ok := c.Get(1, 0); ok {
+		t.Error("Get(1) hit after Delete")
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var c Cache[string, string]
+	if _, ok := c.Get("x", 0); ok {
+		t.Error("empty cache hit")
+	}
+	c.Put("x", "y", time.Hour)
+	if v, ok := c.Get("x", 0); !ok || v != "y" {
+		t.Errorf("Get(x) = %q, %v", v, ok)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c := New[int, int](LRU, 2*sweepInterval)
+	c.Put(-1, -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	for i := 0; i < sweepInterval; i++ {
+		c.Put(i, i, time.Hour)
+	}
+	if stats := c.Stats(); stats.Expirations != 1 || stats.Entries != sweepInterval {
+		t.Errorf("Stats() = %+v, want the expired entry swept", stats)
+	}
+}
+
+func TestNoExpiryAndRange(t *testing.T) {
+	c := New[string, int](LRU, 10)
+	c.Put("kept", 1, NoExpiry)
+	c.Put("expired", 2, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if v, ok := c.Get("kept", 0); !ok || v != 1 {
+		t.Errorf("Get(kept) = %d, %v, want 1, true", v, ok)
+	}
+
+	seen := map[string]int{}
+	c.Range(func(k string, v int) bool {
+		seen[k] = v
+		return true
+	})
+	if len(seen) != 1 || seen["kept"] != 1 {
+		t.Errorf("Range saw %v, want only the unexpired entry", seen)
+	}
+	if stats := c.Stats(); stats.Hits != 1 {
+		t.Errorf("Stats().Hits = %d, want Range not counted", stats.Hits)
+	}
+}
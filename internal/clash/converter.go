@@ -13,9 +13,12 @@ import (
 
 // target constants matching Go codebase values.
 const (
-	targetDirect uint8 = 0
-	targetProxy  uint8 = 1
-	targetReject uint8 = 2
+	targetDirect      uint8 = 0
+	targetProxy       uint8 = 1
+	targetReject      uint8 = 2
+	targetThrottle    uint8 = 3
+	targetRejectDrop  uint8 = 4
+	targetRejectReset uint8 = 5
 )
 
 // parseTarget parses a Clash target string to its uint8 value.
@@ -26,6 +29,12 @@ func parseTarget(s string) uint8 {
 		return targetDirect
 	case "REJECT":
 		return targetReject
+	case "THROTTLE":
+		return targetThrottle
+	case "REJECT-DROP":
+		return targetRejectDrop
+	case "REJECT-RESET":
+		return targetRejectReset
 	default:
 		return targetProxy
 	}
@@ -256,6 +265,33 @@ func (c *SliceConverter) Convert(yamlContent string) ([]byte, error) {
 	return writer.Build()
 }
 
+// ConvertProvider converts the content of a single rule provider (YAML "payload:"
+// list or plain text, see LoadProvider) with the given behavior ("domain", "ipcidr"
+// or "classical"; "" = "domain") to K2RULEV3 binary data routing all of its rules to
+// target, like a "RULE-SET,<provider>,<target>" rule.
+func (c *SliceConverter) ConvertProvider(content, behavior string, target, fallback uint8) ([]byte, error) {
+	if behavior == "" {
+		behavior = "domain"
+	}
+	const name = "provider"
+	if err := c.LoadProvider(name, content); err != nil {
+		return nil, err
+	}
+	rules := c.providerRules[name]
+	delete(c.providerRules, name)
+
+	var slices []*sliceData
+	c.addProviderSlices(&slices, behavior, rules, target)
+
+	writer := slice.NewSliceWriter(fallback)
+	for _, sd := range slices {
+		if err := writeSliceData(writer, sd); err != nil {
+			return nil, err
+		}
+	}
+	return writer.Build()
+}
+
 // appendOrMerge appends a new sliceData to the list, merging with the last
 // entry if they have the same kind and target.
 func appendOrMerge(slices []*sliceData, sd *sliceData) []*sliceData {
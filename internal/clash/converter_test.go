@@ -10,9 +10,12 @@ import (
 
 // Target constants matching the existing Go codebase
 const (
-	targetDirect uint8 = 0
-	targetProxy  uint8 = 1
-	targetReject uint8 = 2
+	targetDirect      uint8 = 0
+	targetProxy       uint8 = 1
+	targetReject      uint8 = 2
+	targetThrottle    uint8 = 3
+	targetRejectDrop  uint8 = 4
+	targetRejectReset uint8 = 5
 )
 
 func ptrUint8(v uint8) *uint8 { return &v }
@@ -79,6 +82,60 @@ rules:
 	})
 }
 
+// TestConverterThrottleTarget verifies THROTTLE rules keep their own target.
+func TestConverterThrottleTarget(t *testing.T) {
+	yaml := `
+rules:
+  - DOMAIN-SUFFIX,video.example,THROTTLE
+  - DOMAIN-SUFFIX,ads.example,REJECT
+  - MATCH,DIRECT
+`
+	data, err := clash.NewSliceConverter().Convert(yaml)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	reader, err := slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes failed: %v", err)
+	}
+
+	if got := reader.MatchDomain("cdn.video.example"); got == nil || *got != targetThrottle {
+		t.Errorf("MatchDomain(cdn.video.example): got %v, want %d", got, targetThrottle)
+	}
+	if got := reader.MatchDomain("ads.example"); got == nil || *got != targetReject {
+		t.Errorf("MatchDomain(ads.example): got %v, want %d", got, targetReject)
+	}
+}
+
+// TestConverterRejectModes verifies REJECT-DROP and REJECT-RESET keep their own targets.
+func TestConverterRejectModes(t *testing.T) {
+	yaml := `
+rules:
+  - DOMAIN-SUFFIX,tracker.example,REJECT-DROP
+  - DOMAIN-SUFFIX,ads.example,REJECT-RESET
+  - DOMAIN,plain.example,REJECT
+  - MATCH,DIRECT
+`
+	data, err := clash.NewSliceConverter().Convert(yaml)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	reader, err := slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes failed: %v", err)
+	}
+
+	for domain, want := range map[string]uint8{
+		"cdn.tracker.example": targetRejectDrop,
+		"ads.example":         targetRejectReset,
+		"plain.example":       targetReject,
+	} {
+		if got := reader.MatchDomain(domain); got == nil || *got != want {
+			t.Errorf("MatchDomain(%s): got %v, want %d", domain, got, want)
+		}
+	}
+}
+
 // TestConverterRuleProviders verifies domain behavior providers with inline rules.
 func TestConverterRuleProviders(t *testing.T) {
 	yaml := `
@@ -514,3 +571,39 @@ rules:
 		}
 	})
 }
+
+// TestConverterConvertProvider verifies conversion of a standalone provider file.
+func TestConverterConvertProvider(t *testing.T) {
+	converter := clash.NewSliceConverter()
+
+	data, err := converter.ConvertProvider("# ads\n+.doubleclick.net\n.tracker.test\nads.example.com\n", "", targetReject, targetProxy)
+	if err != nil {
+		t.Fatalf("ConvertProvider failed: %v", err)
+	}
+	reader, err := slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes failed: %v", err)
+	}
+	if got := reader.Fallback(); got != targetProxy {
+		t.Errorf("Fallback() = %d, want %d", got, targetProxy)
+	}
+	for _, domain := range []string{"ad.doubleclick.net", "tracker.test", "ads.example.com"} {
+		if got := reader.MatchDomain(domain); got == nil || *got != targetReject {
+			t.Errorf("MatchDomain(%s): got %v, want %d", domain, got, targetReject)
+		}
+	}
+
+	data, err = converter.ConvertProvider("payload:\n  - 10.0.0.0/8\n  - 2001:db8::/32\n", "ipcidr", targetDirect, targetProxy)
+	if err != nil {
+		t.Fatalf("ConvertProvider failed: %v", err)
+	}
+	reader, err = slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes failed: %v", err)
+	}
+	for _, ip := range []string{"10.1.2.3", "2001:db8::1"} {
+		if got := reader.MatchIP(mustParseIP(ip)); got == nil || *got != targetDirect {
+			t.Errorf("MatchIP(%s): got %v, want %d", ip, got, targetDirect)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package filelock provides cross-process advisory file locks (flock on Unix,
+// LockFileEx on Windows) so that several processes sharing one cache directory
+// (e.g. an app and its network extension) don't race on downloads and renames.
+//
+// On platforms without support, locks are no-ops.
+package filelock
+
+import (
+	"fmt"
+	"os"
+)
+
+// Lock is an exclusive lock held on a lock file
+type Lock struct {
+	f *os.File
+}
+
+// Acquire blocks until an exclusive lock on path is held. The lock file is created
+// if needed and left in place after Release (deleting it would race with waiters).
+func Acquire(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release unlocks and closes the lock file
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := unlockFile(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f = nil
+	return err
+}
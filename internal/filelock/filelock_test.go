@@ -0,0 +1,73 @@
+package filelock
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	lock, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	// Double release is a no-op
+	if err := lock.Release(); err != nil {
+		t.Fatalf("second Release failed: %v", err)
+	}
+
+	// Re-acquirable after release
+	lock, err = Acquire(path)
+	if err != nil {
+		t.Fatalf("re-Acquire failed: %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	first, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// flock locks belong to the open file description, so a second Acquire
+	// (separate open) blocks like another process would
+	var mu sync.Mutex
+	acquired := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		second, err := Acquire(path)
+		if err != nil {
+			t.Errorf("second Acquire failed: %v", err)
+			return
+		}
+		mu.Lock()
+		acquired = true
+		mu.Unlock()
+		second.Release()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	early := acquired
+	mu.Unlock()
+	if early {
+		t.Fatal("second Acquire succeeded while the lock was held")
+	}
+
+	first.Release()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second Acquire did not proceed after Release")
+	}
+}
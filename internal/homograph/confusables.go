@@ -0,0 +1,61 @@
+package homograph
+
+// confusables maps non-ASCII characters to the ASCII letters they are visually
+// confused with in typical URL bar fonts. Based on the Unicode confusables data
+// (UTS #39), restricted to lowercase lookalikes of [a-z0-9] in scripts that are
+// commonly abused in domain spoofing.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'ь': "b", 'с': "c", 'ԁ': "d", 'е': "e", 'ё': "e", 'ԍ': "g", 'һ': "h",
+	'і': "i", 'ї': "i", 'ј': "j", 'к': "k", 'ӏ': "l", 'о': "o", 'р': "p", 'ԛ': "q",
+	'ѕ': "s", 'ѵ': "v", 'ԝ': "w", 'х': "x", 'у': "y", 'ү': "y", 'з': "3", 'б': "6",
+	// Greek
+	'α': "a", 'β': "b", 'ϲ': "c", 'ε': "e", 'η': "n", 'ι': "i", 'ί': "i", 'κ': "k",
+	'ν': "v", 'ο': "o", 'ό': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'ύ': "u", 'χ': "x",
+	'γ': "y", 'ω': "w",
+	// Armenian
+	'ա': "w", 'զ': "q", 'հ': "h", 'ո': "n", 'ռ': "n", 'ս': "u", 'ց': "g", 'օ': "o",
+	'ք': "f", 'լ': "l",
+	// Latin lookalikes (IPA, small capitals, dotless i)
+	'ı': "i", 'ɑ': "a", 'ɡ': "g", 'ɩ': "i", 'ɪ': "i", 'ʏ': "y", 'ʀ': "r", 'ᴏ': "o",
+	'ᴄ': "c", 'ᴠ': "v", 'ᴡ': "w", 'ᴢ': "z", 'ƅ': "b", 'ɗ': "d", 'ɦ': "h", 'ʋ': "v",
+	// Latin with diacritics
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g", 'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i",
+	'ĵ': "j", 'ķ': "k", 'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'ŕ': "r", 'ŗ': "r", 'ř': "r", 'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s",
+	'ţ': "t", 'ť': "t", 'ŧ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w", 'ý': "y", 'ÿ': "y", 'ŷ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+	// Multi-letter lookalikes
+	'ɯ': "w", 'ʍ': "m", 'ǉ': "lj", 'ǌ': "nj", 'ǳ': "dz", 'ﬁ': "fi", 'ﬂ': "fl",
+}
+
+// invisible are characters that render with no width or as whitespace
+var invisible = map[rune]bool{
+	'\u00AD': true, // soft hyphen
+	'\u034F': true, // combining grapheme joiner
+	'\u180E': true, // mongolian vowel separator
+	'\u200B': true, // zero width space
+	'\u200C': true, // zero width non-joiner
+	'\u200D': true, // zero width joiner
+	'\u2060': true, // word joiner
+	'\uFEFF': true, // zero width no-break space
+}
+
+// brands are frequently spoofed labels; a non-ASCII label whose skeleton equals
+// one of them is reported regardless of script
+var brands = map[string]bool{
+	"google": true, "gmail": true, "youtube": true, "apple": true, "icloud": true,
+	"microsoft": true, "outlook": true, "office": true, "live": true, "amazon": true,
+	"paypal": true, "facebook": true, "instagram": true, "whatsapp": true, "twitter": true,
+	"netflix": true, "linkedin": true, "github": true, "dropbox": true, "yahoo": true,
+	"binance": true, "coinbase": true, "blockchain": true, "metamask": true, "steam": true,
+	"alipay": true, "taobao": true, "wechat": true, "tiktok": true, "telegram": true,
+	"ebay": true, "adobe": true, "chase": true, "wellsfargo": true, "bankofamerica": true,
+}
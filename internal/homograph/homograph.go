@@ -0,0 +1,160 @@
+// Package homograph detects internationalized domain names that visually imitate
+// other domains (IDN homograph attacks), e.g. "xn--80ak6aa92e.com" rendering as
+// "аррӏе.com" in Cyrillic.
+//
+// Checks follow the spirit of browser IDN display policies: mixing Latin with
+// Cyrillic, Greek or Armenian in one label, labels written entirely in lookalike
+// characters of another script, lookalikes of well-known brands, and invisible
+// characters. Plain ASCII domains are never reported.
+package homograph
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Reason explains why a domain was reported
+type Reason string
+
+// Reasons reported by Check
+const (
+	ReasonNone            Reason = ""
+	ReasonInvalidPunycode Reason = "invalid-punycode" // "xn--" label that doesn't decode
+	ReasonInvisible       Reason = "invisible-char"   // Zero-width or other invisible character
+	ReasonMixedScript     Reason = "mixed-script"     // Latin mixed with Cyrillic, Greek or Armenian
+	ReasonWholeScript     Reason = "whole-script"     // Label made only of lookalikes of ASCII letters
+	ReasonBrandLookalike  Reason = "brand-lookalike"  // Label whose skeleton is a well-known brand
+)
+
+// Result is the outcome of Check
+type Result struct {
+	Reason   Reason // ReasonNone if the domain is not suspicious
+	Label    string // Offending label in Unicode form
+	Skeleton string // ASCII lookalike of Label (if all its characters have one)
+}
+
+// Suspicious reports whether a reason was found
+func (r Result) Suspicious() bool {
+	return r.Reason != ReasonNone
+}
+
+// script groups of letters relevant to spoofing
+type script uint8
+
+const (
+	scriptOther script = iota
+	scriptLatin
+	scriptCyrillic
+	scriptGreek
+	scriptArmenian
+)
+
+// IsSuspicious reports whether domain (Punycode or Unicode form) looks like a
+// homograph of another domain
+func IsSuspicious(domain string) bool {
+	return Check(domain).Suspicious()
+}
+
+// Check inspects every label of domain (Punycode or Unicode form) and returns the
+// first problem found
+func Check(domain string) Result {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	labels := strings.Split(domain, ".")
+
+	// Whole-script lookalikes are expected under an IDN TLD (e.g. Cyrillic under .рф)
+	tld, err := toUnicode(labels[len(labels)-1])
+	idnTLD := err == nil && !isASCII(tld)
+
+	for _, label := range labels {
+		u, err := toUnicode(label)
+		if err != nil {
+			return Result{Reason: ReasonInvalidPunycode, Label: label}
+		}
+		if isASCII(u) {
+			continue
+		}
+		if r := checkLabel(u, idnTLD); r.Suspicious() {
+			return r
+		}
+	}
+	return Result{}
+}
+
+// checkLabel checks one non-ASCII label in Unicode form
+func checkLabel(label string, idnTLD bool) Result {
+	scripts := make(map[script]bool)
+	for _, c := range label {
+		if invisible[c] {
+			return Result{Reason: ReasonInvisible, Label: label}
+		}
+		if unicode.IsLetter(c) {
+			scripts[scriptOf(c)] = true
+		}
+	}
+
+	if scripts[scriptLatin] && (scripts[scriptCyrillic] || scripts[scriptGreek] || scripts[scriptArmenian]) {
+		return Result{Reason: ReasonMixedScript, Label: label, Skeleton: Skeleton(label)}
+	}
+
+	skeleton := Skeleton(label)
+	if !isASCII(skeleton) {
+		return Result{}
+	}
+	if brands[skeleton] {
+		return Result{Reason: ReasonBrandLookalike, Label: label, Skeleton: skeleton}
+	}
+	if !idnTLD && !scripts[scriptLatin] && len(scripts) == 1 && !scripts[scriptOther] {
+		return Result{Reason: ReasonWholeScript, Label: label, Skeleton: skeleton}
+	}
+	return Result{}
+}
+
+// Skeleton maps each character of s with an ASCII lookalike to that lookalike;
+// other characters are kept
+func Skeleton(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		if ascii, ok := confusables[c]; ok {
+			b.WriteString(ascii)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// scriptOf returns the script group of letter c
+func scriptOf(c rune) script {
+	switch {
+	case unicode.Is(unicode.Latin, c):
+		return scriptLatin
+	case unicode.Is(unicode.Cyrillic, c):
+		return scriptCyrillic
+	case unicode.Is(unicode.Greek, c):
+		return scriptGreek
+	case unicode.Is(unicode.Armenian, c):
+		return scriptArmenian
+	}
+	return scriptOther
+}
+
+// isASCII reports whether s contains only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Brands returns the well-known brand labels checked for lookalikes, sorted
+func Brands() []string {
+	list := make([]string, 0, len(brands))
+	for brand := range brands {
+		list = append(list, brand)
+	}
+	sort.Strings(list)
+	return list
+}
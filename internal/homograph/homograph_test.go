@@ -0,0 +1,69 @@
+package homograph
+
+import "testing"
+
+func TestDecodePunycode(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"xn--80ak6aa92e", "аррӏе"},
+		{"xn--pypal-4ve", "pаypal"},
+		{"xn--bcher-kva", "bücher"},
+		{"xn--r8jz45g", "例え"},
+		{"xn--e28h", "😀"},
+		{"XN--80A1ACNY", "почта"},
+		{"example", "example"},
+	}
+	for _, tt := range tests {
+		got, err := toUnicode(tt.label)
+		if err != nil || got != tt.want {
+			t.Errorf("toUnicode(%q) = %q, %v, want %q", tt.label, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"xn--a", "xn--99999999999", "xn--ü-kva", "xn--a-$"} {
+		if _, err := toUnicode(bad); err == nil {
+			t.Errorf("toUnicode(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   Reason
+	}{
+		// Not suspicious
+		{"google.com", ReasonNone},
+		{"xn--bcher-kva.de", ReasonNone},      // bücher.de
+		{"xn--caf-dma.fr", ReasonNone},        // café.fr
+		{"xn--80a1acny.xn--p1ai", ReasonNone}, // почта.рф
+		{"xn--h1ahn.com", ReasonNone},         // мир.com: no ASCII lookalike
+		{"xn--r8jz45g.jp", ReasonNone},        // 例え.jp
+		{"xn--e28h.ws", ReasonNone},           // emoji
+		{"xn--80a1aib.xn--p1ai", ReasonNone},  // соса.рф: lookalike under an IDN TLD
+
+		// Suspicious
+		{"xn--80ak6aa92e.com", ReasonBrandLookalike}, // аррӏе.com
+		{"xn--gogle-dua.com", ReasonBrandLookalike},  // gõogle.com
+		{"xn--pypal-4ve.com", ReasonMixedScript},     // pаypal.com (Cyrillic а)
+		{"login.xn--pypal-4ve.com", ReasonMixedScript},
+		{"xn--80a1aib.com", ReasonWholeScript}, // соса.com
+		{"аррӏе.com", ReasonBrandLookalike},    // Unicode input
+		{"goo\u200bgle.com", ReasonInvisible},
+		{"xn--a.com", ReasonInvalidPunycode},
+	}
+	for _, tt := range tests {
+		if got := Check(tt.domain); got.Reason != tt.want {
+			t.Errorf("Check(%q) = %+v, want reason %q", tt.domain, got, tt.want)
+		}
+		if IsSuspicious(tt.domain) != (tt.want != ReasonNone) {
+			t.Errorf("IsSuspicious(%q) = %v", tt.domain, !(tt.want != ReasonNone))
+		}
+	}
+
+	if got := Check("xn--80ak6aa92e.com"); got.Label != "аррӏе" || got.Skeleton != "apple" {
+		t.Errorf("Check(аррӏе.com) = %+v, want label аррӏе and skeleton apple", got)
+	}
+}
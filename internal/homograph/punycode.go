@@ -0,0 +1,131 @@
+package homograph
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Punycode parameters (RFC 3492 section 5)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// acePrefix marks an IDNA label encoded with Punycode
+const acePrefix = "xn--"
+
+// toUnicode returns the Unicode form of a domain label: labels starting with "xn--"
+// are Punycode-decoded, others are returned unchanged. A decoded label must contain
+// non-ASCII characters and no control characters.
+func toUnicode(label string) (string, error) {
+	if len(label) < len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+		return label, nil
+	}
+	decoded, err := decodePunycode(strings.ToLower(label[len(acePrefix):]))
+	if err != nil {
+		return "", err
+	}
+	if isASCII(decoded) {
+		return "", fmt.Errorf("invalid punycode: label has no non-ASCII characters")
+	}
+	for _, c := range decoded {
+		if unicode.IsControl(c) {
+			return "", fmt.Errorf("invalid punycode: control character")
+		}
+	}
+	return decoded, nil
+}
+
+// decodePunycode decodes a Punycode string (without the "xn--" prefix)
+func decodePunycode(encoded string) (string, error) {
+	// Basic code points come before the last delimiter
+	var output []rune
+	pos := 0
+	if i := strings.LastIndexByte(encoded, '-'); i >= 0 {
+		for _, c := range encoded[:i] {
+			if c >= 0x80 {
+				return "", fmt.Errorf("invalid punycode: non-basic code point")
+			}
+			output = append(output, c)
+		}
+		pos = i + 1
+	}
+
+	n, bias, i := punyInitialN, punyInitialBias, 0
+	for pos < len(encoded) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(encoded) {
+				return "", fmt.Errorf("invalid punycode: truncated input")
+			}
+			digit, ok := punyDigit(encoded[pos])
+			if !ok {
+				return "", fmt.Errorf("invalid punycode: bad digit %q", encoded[pos])
+			}
+			pos++
+			if digit > (1<<31-1-i)/w {
+				return "", fmt.Errorf("invalid punycode: overflow")
+			}
+			i += digit * w
+			t := k - bias
+			if t < punyTMin {
+				t = punyTMin
+			} else if t > punyTMax {
+				t = punyTMax
+			}
+			if digit < t {
+				break
+			}
+			if w > (1<<31-1)/(punyBase-t) {
+				return "", fmt.Errorf("invalid punycode: overflow")
+			}
+			w *= punyBase - t
+		}
+		bias = punyAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		if n > 0x10FFFF || (n >= 0xD800 && n <= 0xDFFF) {
+			return "", fmt.Errorf("invalid punycode: code point out of range")
+		}
+		i %= len(output) + 1
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
+
+// punyDigit returns the value of a Punycode digit
+func punyDigit(c byte) (int, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	}
+	return 0, false
+}
+
+// punyAdapt is the bias adaptation function (RFC 3492 section 6.1)
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,99 @@
+package mmdb
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// trimmedPrefix starts the description of trimmed databases, followed by the countries
+const trimmedPrefix = "k2rule trimmed country database: "
+
+// TrimStats describes the result of TrimCountries
+type TrimStats struct {
+	Networks int // Networks of the source database
+	Kept     int // Networks of the selected countries
+}
+
+// TrimCountries returns a writer holding only the networks of the given countries
+// (ISO 3166-1 alpha-2 codes) from a MaxMind country database such as
+// GeoLite2-Country, with just the country.iso_code field of each record. Lookups
+// of other networks find no record, so the trimmed database is a fraction of the
+// source's size. The description of the database lists the countries (see
+// TrimmedCountries).
+func TrimCountries(src *maxminddb.Reader, countries []string) (*Writer, TrimStats, error) {
+	var stats TrimStats
+	keep := make(map[string]bool, len(countries))
+	var codes []string
+	for _, c := range countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" || keep[c] {
+			continue
+		}
+		if len(c) != 2 {
+			return nil, stats, fmt.Errorf("invalid country code %q", c)
+		}
+		keep[c] = true
+		codes = append(codes, c)
+	}
+	if len(keep) == 0 {
+		return nil, stats, fmt.Errorf("no countries selected")
+	}
+	sort.Strings(codes)
+
+	w := NewWriter(src.Metadata.DatabaseType, trimmedPrefix+strings.Join(codes, ","))
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	networks := src.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		record.Country.IsoCode = ""
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, stats, err
+		}
+		stats.Networks++
+		if !keep[record.Country.IsoCode] {
+			continue
+		}
+		prefix, err := toPrefix(network)
+		if err != nil {
+			return nil, stats, err
+		}
+		if err := w.Insert(prefix, map[string]string{"country.iso_code": record.Country.IsoCode}); err != nil {
+			return nil, stats, err
+		}
+		stats.Kept++
+	}
+	if err := networks.Err(); err != nil {
+		return nil, stats, err
+	}
+	return w, stats, nil
+}
+
+// TrimmedCountries returns the countries kept in a database written by
+// TrimCountries, given its metadata; ok is false for other databases.
+func TrimmedCountries(meta maxminddb.Metadata) (countries []string, ok bool) {
+	list, ok := strings.CutPrefix(meta.Description["en"], trimmedPrefix)
+	if !ok {
+		return nil, false
+	}
+	return strings.Split(list, ","), true
+}
+
+// toPrefix converts a network of the maxminddb reader (IPv4 networks have 4-byte
+// addresses with SkipAliasedNetworks)
+func toPrefix(network *net.IPNet) (netip.Prefix, error) {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("invalid network %s", network)
+	}
+	bits, _ := network.Mask.Size()
+	return netip.PrefixFrom(addr, bits), nil
+}
@@ -0,0 +1,91 @@
+package mmdb
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestTrimCountries(t *testing.T) {
+	// A small "world" database in the layout of GeoLite2-Country
+	world := NewWriter("GeoLite2-Country", "")
+	networks := []struct {
+		prefix, country string
+	}{
+		{"1.0.1.0/24", "CN"},
+		{"1.0.2.0/23", "CN"},
+		{"8.8.8.0/24", "US"},
+		{"14.0.0.0/21", "HK"},
+		{"2400:da00::/32", "CN"},
+		{"2001:4860::/32", "US"},
+	}
+	for _, n := range networks {
+		world.Insert(netip.MustParsePrefix(n.prefix), map[string]string{"country.iso_code": n.country, "country.names": "long name"})
+	}
+	src := open(t, world)
+
+	if _, _, err := TrimCountries(src, nil); err == nil {
+		t.Error("TrimCountries() without countries succeeded")
+	}
+	if _, _, err := TrimCountries(src, []string{"CHN"}); err == nil {
+		t.Error("TrimCountries() accepted a 3-letter code")
+	}
+
+	w, stats, err := TrimCountries(src, []string{"cn", "HK"})
+	if err != nil {
+		t.Fatalf("TrimCountries() error: %v", err)
+	}
+	if stats.Networks != len(networks) || stats.Kept != 4 {
+		t.Errorf("stats = %+v, want %d networks, 4 kept", stats, len(networks))
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	db, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes() error: %v", err)
+	}
+	if db.Metadata.DatabaseType != "GeoLite2-Country" {
+		t.Errorf("DatabaseType = %q, want GeoLite2-Country", db.Metadata.DatabaseType)
+	}
+	if countries, ok := TrimmedCountries(db.Metadata); !ok || strings.Join(countries, ",") != "CN,HK" {
+		t.Errorf("TrimmedCountries() = %v, %v, want [CN HK], true", countries, ok)
+	}
+	if _, ok := TrimmedCountries(src.Metadata); ok {
+		t.Error("TrimmedCountries() of the source database reported trimmed")
+	}
+
+	tests := []struct {
+		ip   string
+		want string // "" = no record
+	}{
+		{"1.0.1.1", "CN"},
+		{"1.0.3.255", "CN"},
+		{"14.0.7.1", "HK"},
+		{"2400:da00::1", "CN"},
+		{"8.8.8.8", ""},
+		{"2001:4860::8888", ""},
+	}
+	for _, tt := range tests {
+		var rec struct {
+			Country struct {
+				IsoCode string `maxminddb:"iso_code"`
+				Names   string `maxminddb:"names"`
+			} `maxminddb:"country"`
+		}
+		if err := db.Lookup(net.ParseIP(tt.ip), &rec); err != nil {
+			t.Errorf("Lookup(%s) error: %v", tt.ip, err)
+		}
+		if rec.Country.IsoCode != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.ip, rec.Country.IsoCode, tt.want)
+		}
+		if rec.Country.Names != "" {
+			t.Errorf("Lookup(%s) kept country.names", tt.ip)
+		}
+	}
+}
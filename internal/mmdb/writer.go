@@ -0,0 +1,331 @@
+// Package mmdb writes MaxMind DB (.mmdb) files mapping IP networks to records of
+// string fields, for resolvers and firewalls that load custom mmdb data.
+//
+// Only what k2rule writes is supported: an IPv6 tree (IPv4 networks live in
+// ::/96, as in MaxMind's own databases) with 24- or 32-bit records and maps of
+// strings as data. A field key with dots ("country.iso_code") is written as nested
+// maps, as in MaxMind's country databases.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metadataMarker starts the metadata section
+const metadataMarker = "\xAB\xCD\xEFMaxMind.com"
+
+// MaxMind DB data types
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9  // extended
+	typeArray  = 11 // extended
+)
+
+// Writer builds an mmdb file in memory
+type Writer struct {
+	databaseType string
+	description  string
+	buildTime    time.Time
+	root         *node
+}
+
+// node is a binary trie node; a leaf carries data (data != nil) and no children
+type node struct {
+	children [2]*node
+	data     *record
+}
+
+// record is the data of a leaf, encoded once per distinct value
+type record struct {
+	fields map[string]string
+	key    string // canonical encoding of fields (dedup key)
+}
+
+// NewWriter creates a writer for a database of the given type, e.g. "k2rule-targets"
+func NewWriter(databaseType, description string) *Writer {
+	return &Writer{
+		databaseType: databaseType,
+		description:  description,
+		buildTime:    time.Now(),
+		root:         &node{},
+	}
+}
+
+// SetBuildTime sets the build epoch stored in the metadata (default: NewWriter time)
+func (w *Writer) SetBuildTime(t time.Time) {
+	w.buildTime = t
+}
+
+// Insert maps prefix to fields. A later Insert replaces the data of every address
+// it covers, including more specific networks inserted before.
+func (w *Writer) Insert(prefix netip.Prefix, fields map[string]string) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("invalid prefix")
+	}
+	prefix = prefix.Masked()
+	addr := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		// IPv4 networks live in ::/96 (As16 returns ::ffff:a.b.c.d)
+		addr = [16]byte{}
+		copy(addr[12:], prefix.Addr().AsSlice())
+		bits += 96
+	}
+
+	rec := newRecord(fields)
+	n := w.root
+	for depth := 0; depth < bits; depth++ {
+		if n.data != nil {
+			// Split a covering leaf so the new network can be carved out
+			n.children = [2]*node{{data: n.data}, {data: n.data}}
+			n.data = nil
+		}
+		bit := addr[depth/8] >> (7 - depth%8) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+	n.data = rec
+	n.children = [2]*node{}
+	return nil
+}
+
+// newRecord creates a record with its dedup key
+func newRecord(fields map[string]string) *record {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var key bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&key, "%q=%q;", k, fields[k])
+	}
+	return &record{fields: fields, key: key.String()}
+}
+
+// WriteTo writes the mmdb file to out
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	merge(w.root)
+	root := w.root
+	if root.data != nil {
+		// The root must be an inner node
+		root = &node{children: [2]*node{{data: root.data}, {data: root.data}}}
+	}
+
+	// Number inner nodes breadth-first
+	var nodes []*node
+	index := make(map[*node]int)
+	queue := []*node{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil && c.data == nil && (c.children[0] != nil || c.children[1] != nil) {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	// Data section: one entry per distinct record
+	var data bytes.Buffer
+	offsets := make(map[string]int)
+	for _, n := range nodes {
+		for _, c := range n.children {
+			if c == nil || c.data == nil {
+				continue
+			}
+			if _, ok := offsets[c.data.key]; !ok {
+				offsets[c.data.key] = data.Len()
+				encodeFields(&data, c.data.fields)
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	recordSize := 24
+	if uint64(nodeCount)+16+uint64(data.Len()) >= 1<<24 {
+		recordSize = 32
+	}
+	if uint64(nodeCount)+16+uint64(data.Len()) >= 1<<32 {
+		return 0, fmt.Errorf("database too large")
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for _, c := range n.children {
+			value := uint32(nodeCount) // empty
+			switch {
+			case c == nil:
+			case c.data != nil:
+				value = uint32(nodeCount + 16 + offsets[c.data.key])
+			case c.children[0] != nil || c.children[1] != nil:
+				value = uint32(index[c])
+			}
+			if recordSize == 24 {
+				buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+			} else {
+				buf.Write(binary.BigEndian.AppendUint32(nil, value))
+			}
+		}
+	}
+	buf.Write(make([]byte, 16)) // data section separator
+	buf.Write(data.Bytes())
+
+	buf.WriteString(metadataMarker)
+	encodeControl(&buf, typeMap, 9)
+	encodeString(&buf, "binary_format_major_version")
+	encodeUint(&buf, typeUint16, 2)
+	encodeString(&buf, "binary_format_minor_version")
+	encodeUint(&buf, typeUint16, 0)
+	encodeString(&buf, "build_epoch")
+	encodeUint(&buf, typeUint64, uint64(w.buildTime.Unix()))
+	encodeString(&buf, "database_type")
+	encodeString(&buf, w.databaseType)
+	encodeString(&buf, "description")
+	encodeStringMap(&buf, map[string]string{"en": w.description})
+	encodeString(&buf, "ip_version")
+	encodeUint(&buf, typeUint16, 6)
+	encodeString(&buf, "languages")
+	encodeControl(&buf, typeArray, 1)
+	encodeString(&buf, "en")
+	encodeString(&buf, "node_count")
+	encodeUint(&buf, typeUint32, uint64(nodeCount))
+	encodeString(&buf, "record_size")
+	encodeUint(&buf, typeUint16, uint64(recordSize))
+
+	n, err := out.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// merge turns inner nodes whose halves carry the same record into leaves, so
+// adjacent networks inserted separately share one node
+func merge(n *node) {
+	if n == nil || n.data != nil {
+		return
+	}
+	merge(n.children[0])
+	merge(n.children[1])
+	a, b := n.children[0], n.children[1]
+	if a != nil && b != nil && a.data != nil && b.data != nil && a.data.key == b.data.key {
+		n.data = a.data
+		n.children = [2]*node{}
+	}
+}
+
+// encodeControl writes a control byte (plus extended type and size bytes)
+func encodeControl(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	var extended []byte
+	if typ <= 7 {
+		ctrl = byte(typ) << 5
+	} else {
+		extended = []byte{byte(typ - 7)}
+	}
+
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		sizeBytes = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		sizeBytes = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	buf.WriteByte(ctrl)
+	buf.Write(extended)
+	buf.Write(sizeBytes)
+}
+
+// encodeString writes a UTF-8 string
+func encodeString(buf *bytes.Buffer, s string) {
+	encodeControl(buf, typeString, len(s))
+	buf.WriteString(s)
+}
+
+// encodeUint writes an unsigned integer with the minimal number of bytes
+func encodeUint(buf *bytes.Buffer, typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	n := 8
+	for n > 0 && b[8-n] == 0 {
+		n--
+	}
+	encodeControl(buf, typ, n)
+	buf.Write(b[8-n:])
+}
+
+// encodeFields writes record fields as a map, nesting the fields of dotted keys
+func encodeFields(buf *bytes.Buffer, fields map[string]string) {
+	flat := make(map[string]string)
+	nested := make(map[string]map[string]string)
+	for k, v := range fields {
+		outer, inner, ok := strings.Cut(k, ".")
+		if !ok {
+			flat[k] = v
+			continue
+		}
+		if nested[outer] == nil {
+			nested[outer] = make(map[string]string)
+		}
+		nested[outer][inner] = v
+	}
+	if len(nested) == 0 {
+		encodeStringMap(buf, flat)
+		return
+	}
+
+	keys := make([]string, 0, len(flat)+len(nested))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	for k := range nested {
+		if _, ok := flat[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	encodeControl(buf, typeMap, len(keys))
+	for _, k := range keys {
+		encodeString(buf, k)
+		if v, ok := flat[k]; ok {
+			encodeString(buf, v)
+		} else {
+			encodeFields(buf, nested[k])
+		}
+	}
+}
+
+// encodeStringMap writes a map of strings with keys in sorted order
+func encodeStringMap(buf *bytes.Buffer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	encodeControl(buf, typeMap, len(keys))
+	for _, k := range keys {
+		encodeString(buf, k)
+		encodeString(buf, m[k])
+	}
+}
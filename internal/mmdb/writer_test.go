@@ -0,0 +1,152 @@
+package mmdb
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type testRecord struct {
+	Target string `maxminddb:"target"`
+}
+
+// open writes w and opens the result with the maxminddb reader
+func open(t *testing.T, w *Writer) *maxminddb.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	db, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes() error: %v", err)
+	}
+	return db
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter("test-db", "test database")
+	w.SetBuildTime(time.Unix(1700000000, 0))
+	inserts := []struct {
+		prefix, target string
+	}{
+		{"10.0.0.0/8", "PROXY"},
+		{"10.1.0.0/16", "DIRECT"}, // carved out of 10.0.0.0/8
+		{"192.168.1.1/32", "REJECT"},
+		{"2001:db8::/32", "PROXY"},
+		{"2001:db8:1::/48", "DIRECT"},
+	}
+	for _, in := range inserts {
+		if err := w.Insert(netip.MustParsePrefix(in.prefix), map[string]string{"target": in.target}); err != nil {
+			t.Fatalf("Insert(%s) error: %v", in.prefix, err)
+		}
+	}
+	db := open(t, w)
+
+	if db.Metadata.DatabaseType != "test-db" || db.Metadata.IPVersion != 6 || db.Metadata.BuildEpoch != 1700000000 ||
+		db.Metadata.Description["en"] != "test database" {
+		t.Errorf("Metadata = %+v", db.Metadata)
+	}
+
+	tests := []struct {
+		ip   string
+		want string // "" = no record
+	}{
+		{"10.0.0.1", "PROXY"},
+		{"10.255.255.255", "PROXY"},
+		{"10.1.2.3", "DIRECT"},
+		{"10.2.0.0", "PROXY"},
+		{"11.0.0.1", ""},
+		{"192.168.1.1", "REJECT"},
+		{"192.168.1.2", ""},
+		{"2001:db8::1", "PROXY"},
+		{"2001:db8:1::1", "DIRECT"},
+		{"2001:db9::1", ""},
+	}
+	for _, tt := range tests {
+		var rec testRecord
+		if err := db.Lookup(net.ParseIP(tt.ip), &rec); err != nil {
+			t.Errorf("Lookup(%s) error: %v", tt.ip, err)
+		}
+		if rec.Target != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.ip, rec.Target, tt.want)
+		}
+	}
+
+	// A later covering insert replaces more specific networks
+	w.Insert(netip.MustParsePrefix("10.0.0.0/7"), map[string]string{"target": "REJECT"})
+	db = open(t, w)
+	var rec testRecord
+	if db.Lookup(net.ParseIP("10.1.2.3"), &rec); rec.Target != "REJECT" {
+		t.Errorf("Lookup(10.1.2.3) after covering insert = %q, want REJECT", rec.Target)
+	}
+}
+
+func TestWriterLarge(t *testing.T) {
+	// Enough networks for long strings and many nodes; exercises size encodings
+	w := NewWriter("test-db", "")
+	w.Insert(netip.MustParsePrefix("::/0"), map[string]string{"target": "all"})
+	for i := 0; i < 5000; i++ {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{100, byte(i >> 8), byte(i), 0}), 24)
+		w.Insert(prefix, map[string]string{"target": fmt.Sprintf("%0300d", i%7)})
+	}
+	w.Insert(netip.MustParsePrefix("100.0.0.0/16"), map[string]string{"target": "DIRECT"})
+	db := open(t, w)
+
+	var rec testRecord
+	if db.Lookup(net.ParseIP("100.19.135.1"), &rec); rec.Target != fmt.Sprintf("%0300d", 4999%7) {
+		t.Errorf("Lookup(100.19.135.1) = %q", rec.Target)
+	}
+	if db.Lookup(net.ParseIP("100.0.5.1"), &rec); rec.Target != "DIRECT" {
+		t.Errorf("Lookup(100.0.5.1) = %q, want DIRECT", rec.Target)
+	}
+	for _, ip := range []string{"2001::1", "1.1.1.1"} {
+		if db.Lookup(net.ParseIP(ip), &rec); rec.Target != "all" {
+			t.Errorf("Lookup(%s) = %q, want all", ip, rec.Target)
+		}
+	}
+}
+
+func TestWriterNestedFields(t *testing.T) {
+	w := NewWriter("GeoLite2-Country", "")
+	w.Insert(netip.MustParsePrefix("1.0.1.0/24"), map[string]string{"country.iso_code": "CN", "note": "x"})
+	db := open(t, w)
+
+	var rec struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Note string `maxminddb:"note"`
+	}
+	if err := db.Lookup(net.ParseIP("1.0.1.7"), &rec); err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	if rec.Country.IsoCode != "CN" || rec.Note != "x" {
+		t.Errorf("Lookup(1.0.1.7) = %+v, want country.iso_code CN and note x", rec)
+	}
+}
+
+func TestWriterMergesAdjacentNetworks(t *testing.T) {
+	size := func(prefixes ...string) int {
+		w := NewWriter("test-db", "")
+		w.SetBuildTime(time.Unix(1700000000, 0))
+		for _, p := range prefixes {
+			w.Insert(netip.MustParsePrefix(p), map[string]string{"target": "PROXY"})
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error: %v", err)
+		}
+		return buf.Len()
+	}
+
+	// Two halves of 10.0.0.0/23 collapse into the tree of the /23 itself
+	if split, whole := size("10.0.0.0/24", "10.0.1.0/24"), size("10.0.0.0/23"); split != whole {
+		t.Errorf("size of two adjacent /24 = %d, want %d (one /23)", split, whole)
+	}
+}
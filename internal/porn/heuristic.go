@@ -1,8 +1,11 @@
 package porn
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/kaitu-io/k2rule/keyword"
 )
 
 // IsPornHeuristic checks if a domain is likely a porn site using heuristic patterns.
@@ -20,53 +23,150 @@ import (
 //  6. Verb+noun patterns
 //  7. Repetition patterns
 //  8. Adult TLDs
+//
+// It is DefaultHeuristics().Match; see Heuristics to run a subset of the layers.
 func IsPornHeuristic(domain string) bool {
-	if domain == "" {
-		return false
-	}
+	return defaultHeuristics.Match(domain)
+}
 
-	domainLower := strings.ToLower(domain)
+// Layer is one heuristic detection layer
+type Layer struct {
+	Name  string                   // Layer name, e.g. LayerKeyword
+	Match func(domain string) bool // Reports whether the (lowercase) domain matches
+}
 
-	// Layer 1: Check for false positives first (early exit)
-	if falsePositivePattern.MatchString(domainLower) {
-		return false
-	}
+// Heuristics is a pipeline of detection layers: a domain matches when Exclude
+// doesn't and any layer does. Layers run in order and stop at the first match.
+// Custom layers can be mixed with the built-in ones.
+//
+// Example (block adult TLDs only):
+//
+//	h, _ := porn.NewHeuristics(porn.LayerTLD)
+//	h.Match("example.xxx") // true
+type Heuristics struct {
+	Exclude func(domain string) bool // Known false positives (nil = none)
+	Layers  []Layer
+}
 
-	// Layer 2: Check keyword patterns (regex)
-	if pornPattern.MatchString(domainLower) {
-		return true
-	}
+// Built-in layer names, in default pipeline order
+const (
+	LayerKeyword     = "keyword"
+	LayerTLD         = "tld"
+	Layer3xPrefix    = "3x-prefix"
+	LayerTerminology = "terminology"
+	LayerCompound    = "compound"
+	LayerVerbNoun    = "verb-noun"
+	LayerRepetition  = "repetition"
+)
 
-	// Layer 3: Check special optimized patterns (3x prefix)
-	if has3xPrefix(domainLower) {
-		return true
-	}
+// builtinLayers are the built-in layers in default pipeline order
+var builtinLayers = []Layer{
+	{LayerKeyword, KeywordMatch},
+	{LayerTLD, TLDMatch},
+	{Layer3xPrefix, Prefix3xMatch},
+	{LayerTerminology, TerminologyMatch},
+	{LayerCompound, CompoundMatch},
+	{LayerVerbNoun, VerbNounMatch},
+	{LayerRepetition, RepetitionMatch},
+}
+
+// defaultHeuristics is the pipeline used by IsPornHeuristic
+var defaultHeuristics = DefaultHeuristics()
 
-	// Layer 4: Check porn terminology
-	for _, term := range pornTerminology {
-		if strings.Contains(domainLower, term) {
-			return true
+// DefaultHeuristics returns the full pipeline used by IsPornHeuristic: the false
+// positive exclusion followed by all built-in layers
+func DefaultHeuristics() Heuristics {
+	return Heuristics{Exclude: IsFalsePositive, Layers: append([]Layer(nil), builtinLayers...)}
+}
+
+// NewHeuristics returns a pipeline of the named built-in layers (in the given order)
+// with the false positive exclusion
+func NewHeuristics(names ...string) (Heuristics, error) {
+	h := Heuristics{Exclude: IsFalsePositive}
+	for _, name := range names {
+		layer, ok := BuiltinLayer(name)
+		if !ok {
+			return Heuristics{}, fmt.Errorf("unknown heuristic layer %q", name)
 		}
+		h.Layers = append(h.Layers, layer)
 	}
+	return h, nil
+}
 
-	// Layer 5: Check compound terms
-	for _, compound := range pornCompounds {
-		if strings.Contains(domainLower, compound) {
-			return true
+// BuiltinLayer returns the built-in layer with the given name
+func BuiltinLayer(name string) (Layer, bool) {
+	for _, layer := range builtinLayers {
+		if layer.Name == name {
+			return layer, true
 		}
 	}
+	return Layer{}, false
+}
 
-	// Layer 6: Check verb+noun patterns
-	if hasVerbNounPattern(domainLower) {
-		return true
-	}
+// Match reports whether domain matches the pipeline
+func (h Heuristics) Match(domain string) bool {
+	_, ok := h.MatchLayer(domain)
+	return ok
+}
 
-	// Layer 7: Check repetition patterns
-	if hasRepetitionPattern(domainLower) {
-		return true
+// MatchLayer returns the name of the first layer matching domain
+func (h Heuristics) MatchLayer(domain string) (string, bool) {
+	if domain == "" {
+		return "", false
 	}
+	domainLower := strings.ToLower(domain)
+	if h.Exclude != nil && h.Exclude(domainLower) {
+		return "", false
+	}
+	for _, layer := range h.Layers {
+		if layer.Match(domainLower) {
+			return layer.Name, true
+		}
+	}
+	return "", false
+}
 
-	return false
+// IsFalsePositive reports whether domain is a known legitimate domain that the
+// layers would otherwise match (e.g. essex.ac.uk, adulteducation.org)
+func IsFalsePositive(domain string) bool {
+	return falsePositivePattern.MatchString(domain)
+}
+
+// KeywordMatch reports whether domain contains a strong keyword (platform brands)
+// or a careful keyword ("xxx", "sex", "adult")
+func KeywordMatch(domain string) bool {
+	return hasTerm(domain, termKeyword)
+}
+
+// TLDMatch reports whether domain is under an adult TLD (.xxx, .adult, .porn, .sex)
+func TLDMatch(domain string) bool {
+	return tldPattern.MatchString(domain)
+}
+
+// Prefix3xMatch reports whether domain starts with "3x"
+func Prefix3xMatch(domain string) bool {
+	return has3xPrefix(domain)
+}
+
+// TerminologyMatch reports whether domain contains porn industry terminology
+func TerminologyMatch(domain string) bool {
+	return hasTerm(domain, termTerminology)
+}
+
+// CompoundMatch reports whether domain contains a compound term (e.g. "livesex")
+func CompoundMatch(domain string) bool {
+	return hasTerm(domain, termCompound)
+}
+
+// VerbNounMatch reports whether domain contains a verb+noun pattern
+// (e.g. "watchsex", "watch-sex", "watchgirlsex")
+func VerbNounMatch(domain string) bool {
+	return hasVerbNounPattern(domain)
+}
+
+// RepetitionMatch reports whether domain contains a repetition pattern (e.g. "sexsex")
+func RepetitionMatch(domain string) bool {
+	return hasTerm(domain, termRepetition)
 }
 
 // has3xPrefix checks if domain starts with "3x" pattern
@@ -74,75 +174,176 @@ func has3xPrefix(domain string) bool {
 	return pattern3x.MatchString(domain)
 }
 
-// hasVerbNounPattern checks if domain contains verb+noun sequential pattern
+// hasVerbNounPattern checks if domain contains verb+noun sequential pattern:
+// direct concatenation (watchsex) or with a separator (watch-sex, watch_sex,
+// watch.sex) anywhere, or with a 1-4 char word in between after the first
+// occurrence of the verb (watchgirlsex)
 func hasVerbNounPattern(domain string) bool {
-	for _, pair := range verbNounPatterns {
-		verb, noun := pair[0], pair[1]
-
-		// Pattern 1: Direct concatenation (watchsex)
-		if strings.Contains(domain, verb+noun) {
-			return true
+	var verbEnds [maxVerbs]int // End offset of the first occurrence of each verb (0 = none)
+	found := false
+	terms.Scan(domain, func(id, end int) bool {
+		term := &heuristicTerms[id]
+		if term.kinds&termVerbNoun != 0 {
+			found = true
+			return false
 		}
-
-		// Pattern 2: With separator (watch-sex, watch_sex, watch.sex)
-		for _, sep := range []string{"-", "_", "."} {
-			if strings.Contains(domain, verb+sep+noun) {
-				return true
-			}
+		if term.verb >= 0 && verbEnds[term.verb] == 0 {
+			verbEnds[term.verb] = end
 		}
+		return true
+	})
+	if found {
+		return true
+	}
 
-		// Pattern 3: With optional 1-4 char word in between (watchgirlsex)
-		if idx := strings.Index(domain, verb); idx != -1 {
-			afterVerb := domain[idx+len(verb):]
-			if len(afterVerb) >= len(noun) {
-				for skip := 0; skip <= 4 && skip <= len(afterVerb)-len(noun); skip++ {
-					if strings.HasPrefix(afterVerb[skip:], noun) {
-						return true
-					}
+	for verb, end := range verbEnds[:len(verbs)] {
+		if end == 0 {
+			continue
+		}
+		afterVerb := domain[end:]
+		for _, noun := range verbNouns[verb] {
+			for skip := 1; skip <= 4 && skip <= len(afterVerb)-len(noun); skip++ {
+				if hasPrefixFold(afterVerb[skip:], noun) {
+					return true
 				}
 			}
 		}
 	}
-
 	return false
 }
 
-// hasRepetitionPattern checks if domain contains repetition patterns
-func hasRepetitionPattern(domain string) bool {
-	// Character repetitions
-	if strings.Contains(domain, "xxx") || strings.Contains(domain, "xxxxxx") {
-		return true
+// hasPrefixFold reports whether s starts with the lowercase prefix, ignoring ASCII case
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
 	}
-
-	// Word repetitions
-	repetitions := []string{"sexsex", "camcam", "girlgirl"}
-	for _, pattern := range repetitions {
-		if strings.Contains(domain, pattern) {
-			return true
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
 		}
 	}
+	return true
+}
 
-	return false
+// Term kinds: the layers a term of the heuristic automaton belongs to
+const (
+	termKeyword uint8 = 1 << iota
+	termTerminology
+	termCompound
+	termVerbNoun // verb+noun concatenation or verb+separator+noun
+	termRepetition
+)
+
+// heuristicTerm is a pattern of the heuristic automaton
+type heuristicTerm struct {
+	kinds uint8 // Term kinds (0 for verbs only)
+	verb  int   // Index in verbs, or -1
+}
+
+// maxVerbs bounds the distinct verbs of verbNounPatterns (per-call state is an array)
+const maxVerbs = 16
+
+// Verbs of verbNounPatterns in first-seen order, and their nouns (built with terms)
+var (
+	verbs     []string
+	verbNouns [][]string
+)
+
+// repetitionTerms are the repetition patterns (character and word repetitions)
+var repetitionTerms = []string{"xxx", "sexsex", "camcam", "girlgirl"}
+
+// Heuristic automaton: all keywords, terminology, compounds, repetitions,
+// verb+noun patterns and verbs in one Aho–Corasick automaton (initialized in init())
+var (
+	terms          *keyword.Matcher
+	heuristicTerms []heuristicTerm // Keyword index → term
+)
+
+// hasTerm reports whether domain contains a term of the given kind
+func hasTerm(domain string, kind uint8) bool {
+	found := false
+	terms.Scan(domain, func(id, _ int) bool {
+		found = heuristicTerms[id].kinds&kind != 0
+		return !found
+	})
+	return found
+}
+
+// buildTerms compiles the heuristic automaton
+func buildTerms() {
+	ids := map[string]int{}
+	var patterns []string
+	add := func(pattern string, kind uint8, verb int) {
+		id, ok := ids[pattern]
+		if !ok {
+			id = len(patterns)
+			ids[pattern] = id
+			patterns = append(patterns, pattern)
+			heuristicTerms = append(heuristicTerms, heuristicTerm{verb: -1})
+		}
+		heuristicTerms[id].kinds |= kind
+		if verb >= 0 {
+			heuristicTerms[id].verb = verb
+		}
+	}
+	for _, list := range []struct {
+		terms []string
+		kind  uint8
+	}{
+		{pornKeywords, termKeyword},
+		{carefulKeywords, termKeyword},
+		{pornTerminology, termTerminology},
+		{pornCompounds, termCompound},
+		{repetitionTerms, termRepetition},
+	} {
+		for _, term := range list.terms {
+			add(term, list.kind, -1)
+		}
+	}
+	for _, pair := range verbNounPatterns {
+		for _, sep := range []string{"", "-", "_", "."} {
+			add(pair[0]+sep+pair[1], termVerbNoun, -1)
+		}
+	}
+	verbIndex := map[string]int{}
+	for _, pair := range verbNounPatterns {
+		i, ok := verbIndex[pair[0]]
+		if !ok {
+			i = len(verbs)
+			verbIndex[pair[0]] = i
+			add(pair[0], 0, i)
+			verbs = append(verbs, pair[0])
+			verbNouns = append(verbNouns, nil)
+		}
+		verbNouns[i] = append(verbNouns[i], pair[1])
+	}
+	if len(verbs) > maxVerbs {
+		panic("porn: too many verbs in verbNounPatterns")
+	}
+	terms = keyword.MustNew(patterns)
 }
 
 // Compiled regex patterns (initialized in init())
 var (
-	pornPattern           *regexp.Regexp
-	falsePositivePattern  *regexp.Regexp
-	pattern3x             *regexp.Regexp
+	tldPattern           *regexp.Regexp
+	falsePositivePattern *regexp.Regexp
+	pattern3x            *regexp.Regexp
 )
 
 func init() {
 	// False positive patterns
 	falsePositivePattern = regexp.MustCompile(`(?i)(essex|middlesex|sussex|wessex)\.|adult(education|learning)\.|macosx\.`)
 
-	// Main porn pattern
-	strongKeywords := strings.Join(pornKeywords, "|")
-	carefulKeywords := strings.Join(carefulKeywords, "|")
-	adultTLDs := strings.Join(adultTLDs, "|")
+	// Keywords, terminology, compounds, repetitions and verb+noun patterns
+	buildTerms()
 
-	pornPatternStr := `(?i)(` + strongKeywords + `)|(` + carefulKeywords + `)|\.` + `(` + adultTLDs + `)$`
-	pornPattern = regexp.MustCompile(pornPatternStr)
+	// Adult TLD pattern
+	adultTLDs := strings.Join(adultTLDs, "|")
+	tldPattern = regexp.MustCompile(`(?i)\.(` + adultTLDs + `)$`)
 
 	// 3x prefix pattern
 	pattern3x = regexp.MustCompile(`(?i)^3x`)
@@ -1,6 +1,9 @@
 package porn
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestIsPornHeuristic(t *testing.T) {
 	tests := []struct {
@@ -86,6 +89,56 @@ func TestIsPornHeuristic_Subdomains(t *testing.T) {
 	}
 }
 
+func TestHeuristics(t *testing.T) {
+	tests := []struct {
+		domain    string
+		wantLayer string // "" = no match
+	}{
+		{"pornhub.com", LayerKeyword},
+		{"example.xxx", LayerKeyword}, // adult TLDs are also keywords
+		{"3xvids.net", Layer3xPrefix},
+		{"milf-videos.net", LayerTerminology},
+		{"bigass.tv", LayerCompound},
+		{"watchgirlcams.com", LayerVerbNoun},
+		{"google.com", ""},
+		{"essex.ac.uk", ""},
+	}
+	h := DefaultHeuristics()
+	for _, tt := range tests {
+		if got, _ := h.MatchLayer(tt.domain); got != tt.wantLayer {
+			t.Errorf("MatchLayer(%q) = %q, want %q", tt.domain, got, tt.wantLayer)
+		}
+	}
+
+	// TLD-only pipeline
+	tld, err := NewHeuristics(LayerTLD)
+	if err != nil {
+		t.Fatalf("NewHeuristics(tld) error: %v", err)
+	}
+	if !tld.Match("Example.XXX") || tld.Match("pornhub.com") {
+		t.Error("TLD-only pipeline should match example.xxx only")
+	}
+	if _, err := NewHeuristics("nope"); err == nil {
+		t.Error("NewHeuristics() accepted an unknown layer")
+	}
+
+	// Custom layers compose with built-in ones; exclusion runs first
+	custom := Heuristics{Exclude: IsFalsePositive, Layers: []Layer{
+		{Name: "casino", Match: func(d string) bool { return strings.Contains(d, "casino") }},
+	}}
+	if layer, ok := custom.MatchLayer("Big-Casino.com"); !ok || layer != "casino" {
+		t.Errorf("custom MatchLayer = %q, %v, want casino", layer, ok)
+	}
+
+	// Individual layer functions
+	if !KeywordMatch("xvideos.com") || !TLDMatch("example.sex") || TLDMatch("xxx.com") {
+		t.Error("KeywordMatch/TLDMatch mismatch")
+	}
+	if !VerbNounMatch("watch-sex.com") || !RepetitionMatch("camcam.net") || CompoundMatch("google.com") {
+		t.Error("VerbNounMatch/RepetitionMatch/CompoundMatch mismatch")
+	}
+}
+
 func BenchmarkIsPornHeuristic(b *testing.B) {
 	domains := []string{
 		"pornhub.com",
@@ -101,3 +154,104 @@ func BenchmarkIsPornHeuristic(b *testing.B) {
 		IsPornHeuristic(domain)
 	}
 }
+
+// Loop implementations replaced by the heuristic automaton, kept as reference
+// for equivalence and benchmarks
+
+func containsAnyLoop(domain string, terms []string) bool {
+	domain = strings.ToLower(domain)
+	for _, term := range terms {
+		if strings.Contains(domain, term) {
+			return true
+		}
+	}
+	return false
+}
+
+func verbNounLoop(domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, pair := range verbNounPatterns {
+		verb, noun := pair[0], pair[1]
+		if strings.Contains(domain, verb+noun) {
+			return true
+		}
+		for _, sep := range []string{"-", "_", "."} {
+			if strings.Contains(domain, verb+sep+noun) {
+				return true
+			}
+		}
+		if idx := strings.Index(domain, verb); idx != -1 {
+			afterVerb := domain[idx+len(verb):]
+			for skip := 0; skip <= 4 && skip <= len(afterVerb)-len(noun); skip++ {
+				if strings.HasPrefix(afterVerb[skip:], noun) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// heuristicCorpus returns domains built from the term lists plus common benign domains
+func heuristicCorpus() []string {
+	corpus := []string{
+		"google.com", "microsoft.com", "github.com", "essex.ac.uk", "WatchGirlSex.COM",
+		"live.show.example", "freecamxgirls.net", "see-a-xcam.org", "showmethecams.tv",
+		"downloadvideos.io", "mature-wines.com", "getsomegay.info", "meet_milf.net",
+	}
+	for _, list := range [][]string{pornKeywords, carefulKeywords, pornTerminology, pornCompounds} {
+		for _, term := range list {
+			corpus = append(corpus, term+".com", "my-"+strings.ToUpper(term)+"s.net")
+		}
+	}
+	for _, pair := range verbNounPatterns {
+		corpus = append(corpus, pair[0]+"ab"+pair[1]+".com", pair[0]+"abcde"+pair[1]+".com", pair[0]+"."+pair[1])
+	}
+	return corpus
+}
+
+func TestHeuristicLayers_MatchLoops(t *testing.T) {
+	for _, domain := range heuristicCorpus() {
+		checks := []struct {
+			layer     string
+			got, want bool
+		}{
+			{LayerKeyword, KeywordMatch(domain), containsAnyLoop(domain, append(append([]string{}, pornKeywords...), carefulKeywords...))},
+			{LayerTerminology, TerminologyMatch(domain), containsAnyLoop(domain, pornTerminology)},
+			{LayerCompound, CompoundMatch(domain), containsAnyLoop(domain, pornCompounds)},
+			{LayerVerbNoun, VerbNounMatch(domain), verbNounLoop(domain)},
+			{LayerRepetition, RepetitionMatch(domain), containsAnyLoop(domain, repetitionTerms)},
+		}
+		for _, c := range checks {
+			if c.got != c.want {
+				t.Errorf("%s layer on %q = %v, loop = %v", c.layer, domain, c.got, c.want)
+			}
+		}
+	}
+}
+
+// BenchmarkHeuristicLayers compares the automaton layers with the loops they replaced
+func BenchmarkHeuristicLayers(b *testing.B) {
+	domains := []string{"google.com", "cdn.assets.microsoft-online.com", "freeporn.net", "api.weather.example.org", "watchgirlcams.com"}
+	layers := []struct {
+		name      string
+		automaton func(string) bool
+		loop      func(string) bool
+	}{
+		{LayerTerminology, TerminologyMatch, func(d string) bool { return containsAnyLoop(d, pornTerminology) }},
+		{LayerCompound, CompoundMatch, func(d string) bool { return containsAnyLoop(d, pornCompounds) }},
+		{LayerVerbNoun, VerbNounMatch, verbNounLoop},
+	}
+	for _, layer := range layers {
+		b.Run(layer.name+"/automaton", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				layer.automaton(domains[i%len(domains)])
+			}
+		})
+		b.Run(layer.name+"/loop", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				layer.loop(domains[i%len(domains)])
+			}
+		})
+	}
+}
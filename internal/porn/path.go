@@ -0,0 +1,85 @@
+package porn
+
+import "strings"
+
+// Bounds for IsPornPath (inputs are attacker-controlled URLs)
+const (
+	maxPathInspectLen = 2048 // bytes of path+query inspected
+	maxPathTokens     = 64   // tokens inspected
+	minPathTokenLen   = 3    // shorter tokens are ignored
+)
+
+// pornPathTerms are explicit terms matched as whole path tokens.
+// Ambiguous terminology ("teen", "gay", "asian", "mature") is deliberately excluded:
+// URL paths of news and shopping sites contain them far more often than domains do.
+var pornPathTerms = map[string]struct{}{
+	"pussy": {}, "blowjob": {}, "cumshot": {}, "gangbang": {}, "milf": {},
+	"bdsm": {}, "shemale": {}, "nsfw": {}, "porno": {}, "sexe": {},
+	"xxx": {}, "hardcore": {}, "nude": {}, "nudes": {}, "naked": {},
+}
+
+// IsPornPath checks whether the tokens of a URL path/query look like porn content.
+//
+// The input is lowercased and split on non-alphanumeric characters; each token is
+// matched against brand keywords (substring), compound terms and explicit terms
+// (whole token). Only the first maxPathInspectLen bytes / maxPathTokens tokens are
+// inspected, so the cost is bounded regardless of input size.
+func IsPornPath(pathAndQuery string) bool {
+	if len(pathAndQuery) > maxPathInspectLen {
+		pathAndQuery = pathAndQuery[:maxPathInspectLen]
+	}
+	s := strings.ToLower(pathAndQuery)
+
+	tokens := 0
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && isTokenChar(s[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		token := s[start:i]
+		start = -1
+		if len(token) < minPathTokenLen {
+			continue
+		}
+		if isPornToken(token) {
+			return true
+		}
+		tokens++
+		if tokens >= maxPathTokens {
+			break
+		}
+	}
+	return false
+}
+
+// isTokenChar reports whether c is part of a path token ([a-z0-9], input is lowercased)
+func isTokenChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// isPornToken matches a single lowercased path token
+func isPornToken(token string) bool {
+	if _, ok := pornPathTerms[token]; ok {
+		return true
+	}
+	if strings.HasPrefix(token, "xxx") {
+		return true
+	}
+	for _, keyword := range pornKeywords {
+		if strings.Contains(token, keyword) {
+			return true
+		}
+	}
+	for _, compound := range pornCompounds {
+		if token == compound {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package porn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsPornPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		// Porn content
+		{"/videos/milf-compilation.mp4", true},
+		{"/watch?v=123&tag=hentai", true},
+		{"/r?url=https://www.pornhub.com/view", true},
+		{"/img/XXX_thumb.jpg", true},
+		{"/c/freeporn/", true},
+
+		// Legitimate content with ambiguous words
+		{"/news/gay-rights-bill-passes", false},
+		{"/shop/teen-fashion/summer", false},
+		{"/analytics/dashboard", false},
+		{"/essex/sussex/middlesex", false},
+		{"/adult-education/courses", false},
+		{"", false},
+		{"/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsPornPath(tt.path); got != tt.want {
+				t.Errorf("IsPornPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPornPath_Bounded(t *testing.T) {
+	// Keyword beyond the inspected length is ignored
+	long := "/" + strings.Repeat("a", maxPathInspectLen) + "/porn"
+	if IsPornPath(long) {
+		t.Error("IsPornPath inspected beyond maxPathInspectLen")
+	}
+
+	// Keyword beyond the inspected token count is ignored
+	many := strings.Repeat("/abc", maxPathTokens) + "/porn"
+	if IsPornPath(many) {
+		t.Error("IsPornPath inspected beyond maxPathTokens")
+	}
+}
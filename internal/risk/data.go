@@ -0,0 +1,27 @@
+package risk
+
+// suspiciousTLDs are TLDs over-represented in phishing feeds (free or very cheap
+// registrations, or easily confused with file extensions)
+var suspiciousTLDs = map[string]bool{
+	"tk": true, "ml": true, "ga": true, "cf": true, "gq": true,
+	"xyz": true, "top": true, "zip": true, "mov": true, "icu": true,
+	"buzz": true, "rest": true, "cyou": true, "monster": true, "click": true,
+	"country": true, "kim": true, "work": true, "support": true, "live": true,
+}
+
+// phishingKeywords are credential-lure words common in phishing hostnames
+var phishingKeywords = []string{
+	"login", "signin", "sign-in", "verify", "verification", "secure", "account",
+	"update", "confirm", "banking", "wallet", "unlock", "recover", "suspended",
+	"billing", "password", "webscr", "authenticate",
+}
+
+// secondLevelSuffixes are common two-label public suffixes; the registrable label
+// is the one before them (e.g. "example" in "example.co.uk")
+var secondLevelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true,
+	"com.cn": true, "net.cn": true, "org.cn": true, "gov.cn": true, "edu.cn": true,
+	"com.hk": true, "com.tw": true, "com.sg": true, "com.au": true, "net.au": true,
+	"co.jp": true, "ne.jp": true, "co.kr": true, "co.nz": true, "co.in": true,
+	"com.br": true, "com.mx": true, "com.tr": true, "co.za": true, "com.ru": true,
+}
@@ -0,0 +1,251 @@
+// Package risk scores domains for phishing likelihood from lexical signals: label
+// entropy, digit and hyphen ratios, brand keywords and typos outside the brand's
+// own domain, phishing keywords, suspicious TLDs, IDN homographs and an optional
+// reputation list. Scores range from 0 (no signal) to 100.
+package risk
+
+import (
+	"math"
+	"strings"
+
+	"github.com/kaitu-io/k2rule/internal/homograph"
+)
+
+// Reasons reported in Result.Reasons. Brand and homograph reasons carry a detail
+// after a colon, e.g. "brand-keyword:paypal", "homograph:mixed-script".
+const (
+	ReasonReputation      = "reputation-listed"
+	ReasonHomograph       = "homograph"
+	ReasonBrandTypo       = "brand-typo"
+	ReasonBrandKeyword    = "brand-keyword"
+	ReasonPhishingKeyword = "phishing-keyword"
+	ReasonSuspiciousTLD   = "suspicious-tld"
+	ReasonHighEntropy     = "high-entropy"
+	ReasonManyDigits      = "many-digits"
+	ReasonManyHyphens     = "many-hyphens"
+	ReasonDeepSubdomain   = "deep-subdomain"
+	ReasonLongDomain      = "long-domain"
+)
+
+// Signal weights (summed and capped at 100)
+const (
+	weightHomograph       = 50
+	weightBrandTypo       = 40
+	weightBrandKeyword    = 35
+	weightHighEntropy     = 20
+	weightPhishingKeyword = 15
+	weightSuspiciousTLD   = 15
+	weightManyDigits      = 15
+	weightManyHyphens     = 10
+	weightDeepSubdomain   = 10
+	weightLongDomain      = 5
+)
+
+// MaxScore is the highest score
+const MaxScore = 100
+
+// brands are the well-known brands checked for keywords and typos
+var brands = homograph.Brands()
+
+// Result is a risk score with the signals that contributed to it
+type Result struct {
+	Score   int      `json:"score"`             // 0 (no signal) .. 100
+	Reasons []string `json:"reasons,omitempty"` // Signals in decreasing weight order
+}
+
+// Scorer scores domains. The zero value uses lexical signals only.
+type Scorer struct {
+	// Reputation reports whether a domain is on a known-bad list (nil = none).
+	// Listed domains score MaxScore.
+	Reputation func(domain string) bool
+}
+
+// Score returns the phishing risk of domain
+func (s *Scorer) Score(domain string) Result {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return Result{}
+	}
+	if s != nil && s.Reputation != nil && s.Reputation(domain) {
+		return Result{Score: MaxScore, Reasons: []string{ReasonReputation}}
+	}
+
+	var r Result
+	add := func(weight int, reason string) {
+		r.Score += weight
+		r.Reasons = append(r.Reasons, reason)
+	}
+
+	labels := strings.Split(domain, ".")
+	tld := labels[len(labels)-1]
+	name, subdomains := registrableLabel(labels)
+
+	if h := homograph.Check(domain); h.Suspicious() {
+		add(weightHomograph, ReasonHomograph+":"+string(h.Reason))
+	}
+	if brand, ok := brandTypo(name); ok {
+		add(weightBrandTypo, ReasonBrandTypo+":"+brand)
+	} else if brand, ok := brandKeyword(name, subdomains); ok {
+		add(weightBrandKeyword, ReasonBrandKeyword+":"+brand)
+	}
+	if longest := longestLabel(labels[:len(labels)-1]); len(longest) >= 10 && entropy(longest) >= 3.5 {
+		add(weightHighEntropy, ReasonHighEntropy)
+	}
+	if hasPhishingKeyword(labels[:len(labels)-1]) {
+		add(weightPhishingKeyword, ReasonPhishingKeyword)
+	}
+	if suspiciousTLDs[tld] {
+		add(weightSuspiciousTLD, ReasonSuspiciousTLD)
+	}
+	if digitRatio(name) > 0.3 {
+		add(weightManyDigits, ReasonManyDigits)
+	}
+	if strings.Count(domain, "-") >= 2 {
+		add(weightManyHyphens, ReasonManyHyphens)
+	}
+	if len(subdomains) >= 4 {
+		add(weightDeepSubdomain, ReasonDeepSubdomain)
+	}
+	if len(domain) > 50 {
+		add(weightLongDomain, ReasonLongDomain)
+	}
+
+	r.Score = min(r.Score, MaxScore)
+	return r
+}
+
+// registrableLabel returns the label registered under the public suffix (e.g.
+// "example" for "a.b.example.co.uk") and the subdomain labels before it
+func registrableLabel(labels []string) (string, []string) {
+	n := len(labels)
+	if n < 2 {
+		return labels[0], nil
+	}
+	suffixLen := 1
+	if n >= 3 && secondLevelSuffixes[labels[n-2]+"."+labels[n-1]] {
+		suffixLen = 2
+	}
+	i := n - suffixLen - 1
+	return labels[i], labels[:i]
+}
+
+// brandTypo returns the brand that name misspells by one or two edits
+// (e.g. "paypa1", "gooogle"); exact brand names are not typos
+func brandTypo(name string) (string, bool) {
+	for _, brand := range brands {
+		if name == brand || len(brand) < 5 {
+			continue
+		}
+		maxEdits := 1
+		if len(brand) >= 8 {
+			maxEdits = 2
+		}
+		if d := editDistance(name, brand, maxEdits); d > 0 && d <= maxEdits {
+			return brand, true
+		}
+	}
+	return "", false
+}
+
+// brandKeyword returns a brand contained in the registrable label or a subdomain
+// of a domain that is not the brand's own (e.g. "paypal-login.com",
+// "paypal.com.evil.net")
+func brandKeyword(name string, subdomains []string) (string, bool) {
+	for _, brand := range brands {
+		if name == brand || len(brand) < 5 {
+			continue
+		}
+		if strings.Contains(name, brand) {
+			return brand, true
+		}
+		for _, label := range subdomains {
+			if strings.Contains(label, brand) {
+				return brand, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hasPhishingKeyword reports whether a label contains a credential-lure keyword
+func hasPhishingKeyword(labels []string) bool {
+	for _, label := range labels {
+		for _, keyword := range phishingKeywords {
+			if strings.Contains(label, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// longestLabel returns the longest of labels
+func longestLabel(labels []string) string {
+	var longest string
+	for _, label := range labels {
+		if len(label) > len(longest) {
+			longest = label
+		}
+	}
+	return longest
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(s))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// digitRatio returns the fraction of digits in s
+func digitRatio(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	digits := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits++
+		}
+	}
+	return float64(digits) / float64(len(s))
+}
+
+// editDistance returns the Levenshtein distance of a and b, or limit+1 once it
+// exceeds limit
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
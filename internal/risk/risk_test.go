@@ -0,0 +1,74 @@
+package risk
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	var s Scorer
+	tests := []struct {
+		domain      string
+		minScore    int
+		maxScore    int
+		wantReasons []string
+	}{
+		{"google.com", 0, 0, nil},
+		{"www.paypal.com", 0, 0, nil},
+		{"bbc.co.uk", 0, 0, nil},
+		{"paypal-login-verify.com", 60, 100, []string{"brand-keyword:paypal", "phishing-keyword", "many-hyphens"}},
+		{"paypa1.com", 40, 60, []string{"brand-typo:paypal"}},
+		{"paypal.com.secure-account.tk", 60, 100, []string{"brand-keyword:paypal", "phishing-keyword", "suspicious-tld"}},
+		{"xn--pypal-4ve.com", 50, 100, []string{"homograph:mixed-script"}},
+		{"qx7v9k2mz4wp8r.xyz", 40, 100, []string{"high-entropy", "suspicious-tld", "many-digits"}},
+		{"a.b.c.d.example.com", 10, 10, []string{"deep-subdomain"}},
+	}
+	for _, tt := range tests {
+		got := s.Score(tt.domain)
+		if got.Score < tt.minScore || got.Score > tt.maxScore {
+			t.Errorf("Score(%q) = %d %v, want %d..%d", tt.domain, got.Score, got.Reasons, tt.minScore, tt.maxScore)
+		}
+		for _, want := range tt.wantReasons {
+			found := false
+			for _, reason := range got.Reasons {
+				found = found || reason == want
+			}
+			if !found {
+				t.Errorf("Score(%q).Reasons = %v, missing %q", tt.domain, got.Reasons, want)
+			}
+		}
+		if tt.wantReasons == nil && len(got.Reasons) != 0 {
+			t.Errorf("Score(%q).Reasons = %v, want none", tt.domain, got.Reasons)
+		}
+	}
+
+	if got := s.Score("paypal.xn--pypal-4ve.secure-login-verify.tk"); got.Score != MaxScore {
+		t.Errorf("Score() = %d, want capped at %d", got.Score, MaxScore)
+	}
+}
+
+func TestScoreReputation(t *testing.T) {
+	s := Scorer{Reputation: func(domain string) bool { return domain == "evil.example" }}
+	if got := s.Score("EVIL.example."); got.Score != MaxScore || got.Reasons[0] != ReasonReputation {
+		t.Errorf("Score(listed) = %+v, want %d with %s", got, MaxScore, ReasonReputation)
+	}
+	if got := s.Score("good.example"); got.Score != 0 {
+		t.Errorf("Score(unlisted) = %+v, want 0", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"paypal", "paypal", 1, 0},
+		{"paypa1", "paypal", 1, 1},
+		{"gooogle", "google", 1, 1},
+		{"microsfot", "microsoft", 2, 2},
+		{"amazon", "google", 2, 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
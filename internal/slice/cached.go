@@ -2,6 +2,7 @@ package slice
 
 import (
 	"net"
+	"net/netip"
 	"os"
 	"sync/atomic"
 	"time"
@@ -12,6 +13,7 @@ import (
 type CachedMmapReader struct {
 	current    atomic.Value  // Stores *MmapReader
 	generation atomic.Uint64 // Version number for debugging/monitoring
+	mode       Mode          // How loaded files are held (set before the first Load)
 }
 
 // NewCachedMmapReader creates a new cached mmap reader
@@ -19,10 +21,15 @@ func NewCachedMmapReader() *CachedMmapReader {
 	return &CachedMmapReader{}
 }
 
+// SetMode sets how subsequently loaded files are held (default ModeAuto)
+func (c *CachedMmapReader) SetMode(mode Mode) {
+	c.mode = mode
+}
+
 // Load loads or reloads a rule file with atomic hot-swap
 // Old readers are closed with a grace period to allow ongoing reads to complete
 func (c *CachedMmapReader) Load(path string) error {
-	newReader, err := NewMmapReaderFromGzip(path)
+	newReader, err := OpenGzip(path, c.mode)
 	if err != nil {
 		return err
 	}
@@ -42,18 +49,27 @@ func (c *CachedMmapReader) Load(path string) error {
 	return nil
 }
 
-// LoadFromBytes loads from raw bytes (for testing or embedded rules)
+// LoadFromBytes loads from raw bytes (for testing or embedded rules), which must not be
+// modified afterwards
 func (c *CachedMmapReader) LoadFromBytes(data []byte) error {
-	// For bytes, we need to create a temporary file
-	// This is less efficient but maintains compatibility
-	tmpFile, err := createTempFileFromBytes(data)
-	if err != nil {
-		return err
-	}
-
-	newReader, err := NewMmapReader(tmpFile)
-	if err != nil {
-		return err
+	var newReader *MmapReader
+	if ResolveMode(c.mode, int64(len(data))) == ModeInMemory {
+		reader, err := NewInMemoryReaderFromBytes(data)
+		if err != nil {
+			return err
+		}
+		newReader = reader
+	} else {
+		// Mmap needs a file: write the bytes to a temporary one
+		tmpFile, err := createTempFileFromBytes(data)
+		if err != nil {
+			return err
+		}
+		reader, err := NewMmapReader(tmpFile)
+		if err != nil {
+			return err
+		}
+		newReader = reader
 	}
 
 	// Atomic swap
@@ -94,6 +110,25 @@ func (c *CachedMmapReader) Close() error {
 	return reader.Close()
 }
 
+// Touch faults in the pages of the current file (see MmapReader.Touch)
+func (c *CachedMmapReader) Touch() int {
+	reader := c.Get()
+	if reader == nil {
+		return 0
+	}
+	return reader.Touch()
+}
+
+// Err reports the first slice of the current file that fails to decompress
+// (see MmapReader.Err)
+func (c *CachedMmapReader) Err() error {
+	reader := c.Get()
+	if reader == nil {
+		return nil
+	}
+	return reader.Err()
+}
+
 // Matching methods (delegate to current reader)
 
 // Fallback returns the fallback target
@@ -114,6 +149,33 @@ func (c *CachedMmapReader) SliceCount() int {
 	return reader.SliceCount()
 }
 
+// DomainCount returns the number of domain rules of the current file (see MmapReader.DomainCount)
+func (c *CachedMmapReader) DomainCount() int {
+	reader := c.Get()
+	if reader == nil {
+		return 0
+	}
+	return reader.DomainCount()
+}
+
+// Size returns the size of the current (uncompressed) file in bytes
+func (c *CachedMmapReader) Size() int64 {
+	reader := c.Get()
+	if reader == nil {
+		return 0
+	}
+	return reader.Size()
+}
+
+// Timestamp returns the build timestamp of the current file
+func (c *CachedMmapReader) Timestamp() time.Time {
+	reader := c.Get()
+	if reader == nil {
+		return time.Time{}
+	}
+	return reader.Timestamp()
+}
+
 // MatchDomain matches a domain (zero-copy, lock-free)
 func (c *CachedMmapReader) MatchDomain(domain string) *uint8 {
 	reader := c.Get()
@@ -123,6 +185,26 @@ func (c *CachedMmapReader) MatchDomain(domain string) *uint8 {
 	return reader.MatchDomain(domain)
 }
 
+// MatchDomainHints matches a domain, also returning the DNS hints of the matching
+// slice (see MmapReader.MatchDomainHints)
+func (c *CachedMmapReader) MatchDomainHints(domain string) (uint8, DNSHint, bool) {
+	reader := c.Get()
+	if reader == nil {
+		return 0, 0, false
+	}
+	return reader.MatchDomainHints(domain)
+}
+
+// MatchDomainSuffix matches a domain, also returning the stored domain that matched
+// (see MmapReader.MatchDomainSuffix)
+func (c *CachedMmapReader) MatchDomainSuffix(domain string) (string, uint8, bool) {
+	reader := c.Get()
+	if reader == nil {
+		return "", 0, false
+	}
+	return reader.MatchDomainSuffix(domain)
+}
+
 // MatchIP matches an IP address (zero-copy, lock-free)
 func (c *CachedMmapReader) MatchIP(ip net.IP) *uint8 {
 	reader := c.Get()
@@ -141,6 +223,33 @@ func (c *CachedMmapReader) MatchGeoIP(country string) *uint8 {
 	return reader.MatchGeoIP(country)
 }
 
+// Domains returns all domain rules with the given target (see MmapReader.Domains)
+func (c *CachedMmapReader) Domains(target uint8) []string {
+	reader := c.Get()
+	if reader == nil {
+		return nil
+	}
+	return reader.Domains(target)
+}
+
+// CIDRs returns all CIDR rules with the given target
+func (c *CachedMmapReader) CIDRs(target uint8) []netip.Prefix {
+	reader := c.Get()
+	if reader == nil {
+		return nil
+	}
+	return reader.CIDRs(target)
+}
+
+// IPRules returns all IP rules in file order (see MmapReader.IPRules)
+func (c *CachedMmapReader) IPRules() []IPRule {
+	reader := c.Get()
+	if reader == nil {
+		return nil
+	}
+	return reader.IPRules()
+}
+
 // Helper function
 
 func createTempFileFromBytes(data []byte) (string, error) {
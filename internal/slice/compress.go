@@ -0,0 +1,127 @@
+package slice
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressed slice layout (SliceFlagCompressed):
+//
+//	uncompressed_size (4 bytes LE)
+//	raw DEFLATE stream (variable)
+//
+// SliceEntry.Size is the compressed size; SliceEntry.Count is unchanged.
+
+// maxInflatedSize bounds the declared uncompressed size of a slice (guards corrupt files)
+const maxInflatedSize = 1 << 30
+
+// maxDeflateRatio is the largest expansion DEFLATE can achieve (about 1032:1); a slice
+// declaring more than that is corrupt and would only allocate memory in vain
+const maxDeflateRatio = 1032
+
+// compressSlice encodes data as a compressed slice
+func compressSlice(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	buf.Write(size[:])
+
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflatedSize returns the declared uncompressed size of a compressed slice
+func inflatedSize(data []byte) (int, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("compressed slice truncated")
+	}
+	size := int(binary.LittleEndian.Uint32(data[:4]))
+	if size > maxInflatedSize {
+		return 0, fmt.Errorf("compressed slice too large: %d bytes", size)
+	}
+	if uint64(size) > uint64(len(data)-4)*maxDeflateRatio {
+		return 0, fmt.Errorf("compressed slice declares %d bytes from %d compressed bytes", size, len(data)-4)
+	}
+	return size, nil
+}
+
+// inflateSlice decompresses a compressed slice into dst, which must have the declared size
+func inflateSlice(data, dst []byte) error {
+	fr := flate.NewReader(bytes.NewReader(data[4:]))
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, dst); err != nil {
+		return fmt.Errorf("failed to decompress slice: %w", err)
+	}
+	return nil
+}
+
+// inflatedSlice is a compressed slice decompressed on first access into an
+// anonymous mapping (outside the Go heap, released by Close), or into the Go heap
+// for in-memory readers and for slices smaller than a page (a mapping takes whole
+// pages, so small slices would mostly waste it: up to 16 KiB each on iOS)
+type inflatedSlice struct {
+	once sync.Once
+	heap bool
+	data []byte
+	err  error
+}
+
+// get returns the decompressed data of raw, decompressing it on first use
+func (s *inflatedSlice) get(raw []byte) ([]byte, error) {
+	s.once.Do(func() {
+		size, err := inflatedSize(raw)
+		if err != nil {
+			s.err = err
+			return
+		}
+		if size == 0 {
+			return
+		}
+		if s.heap || size < pageSize {
+			s.heap = true
+			m := make([]byte, size)
+			if err := inflateSlice(raw, m); err != nil {
+				s.err = err
+				return
+			}
+			s.data = m
+			return
+		}
+		m, err := mapAnon(size)
+		if err != nil {
+			s.err = fmt.Errorf("failed to map decompressed slice (%d bytes, %d pages): %w", size, mappedSize(size)/pageSize, err)
+			return
+		}
+		if err := inflateSlice(raw, m); err != nil {
+			unmap(m)
+			s.err = err
+			return
+		}
+		s.data = m
+	})
+	return s.data, s.err
+}
+
+// close releases the anonymous mapping
+func (s *inflatedSlice) close() error {
+	if s.data == nil || s.heap {
+		s.data = nil
+		return nil
+	}
+	err := unmap(s.data)
+	s.data = nil
+	return err
+}
@@ -11,8 +11,10 @@ import (
 const (
 	// Magic bytes for K2Rule v3 slice-based format
 	Magic = "K2RULEV3"
-	// FormatVersion is the current format version
-	FormatVersion = 1
+	// FormatVersion is the highest format version this package reads.
+	// Version 2 adds feature bits (see Feature), slice flags and the slice types after
+	// SliceTypeExactIPv6; files using none of them are written as version 1.
+	FormatVersion = 2
 	// HeaderSize is the size of SliceHeader in bytes
 	HeaderSize = 64
 	// EntrySize is the size of SliceEntry in bytes
@@ -35,6 +37,112 @@ const (
 	SliceTypeExactIPv4 SliceType = 0x05
 	// SliceTypeExactIPv6 is exact IPv6 addresses
 	SliceTypeExactIPv6 SliceType = 0x06
+	// SliceTypeDomainTrie is a domain set as a label suffix trie (see trie.go)
+	SliceTypeDomainTrie SliceType = 0x07
+	// SliceTypeRangeV4 is inclusive IPv4 address ranges (see range.go)
+	SliceTypeRangeV4 SliceType = 0x08
+	// SliceTypeRangeV6 is inclusive IPv6 address ranges (see range.go)
+	SliceTypeRangeV6 SliceType = 0x09
+	// SliceTypeDomainTargets is a domain list with per-entry targets as a label suffix trie (see trie.go)
+	SliceTypeDomainTargets SliceType = 0x0A
+	// SliceTypeExpiry is the expiry table of other slices (see parseExpiry); it holds no rules
+	SliceTypeExpiry SliceType = 0x0B
+	// SliceTypeDNSHints is the DNS hint table of other slices (see parseDNSHints); it holds no rules
+	SliceTypeDNSHints SliceType = 0x0C
+)
+
+// expiryRecordSize is the size of an expiry table record: slice index uint32 LE +
+// expiry int64 LE (Unix seconds)
+const expiryRecordSize = 12
+
+// dnsHintRecordSize is the size of a DNS hint table record: slice index uint32 LE +
+// hints uint8 + 3 reserved bytes
+const dnsHintRecordSize = 8
+
+// Known reports whether this package understands the slice type
+func (t SliceType) Known() bool {
+	return t >= SliceTypeSortedDomain && t <= SliceTypeDNSHints
+}
+
+// DNSHint is a set of rule-author hints for DNS components resolving the domains of a
+// slice (see SliceWriter.SetLastSliceDNSHints)
+type DNSHint uint8
+
+const (
+	// DNSHintRemoteDNS asks to resolve the domains through the remote (proxy-side) DNS
+	DNSHintRemoteDNS DNSHint = 1 << 0
+	// DNSHintFakeIP allows answering the domains with fake IPs
+	DNSHintFakeIP DNSHint = 1 << 1
+	// DNSHintRealIP requires real IPs for the domains (never fake IPs)
+	DNSHintRealIP DNSHint = 1 << 2
+)
+
+// KnownDNSHints are all DNS hint bits this package understands
+const KnownDNSHints = DNSHintRemoteDNS | DNSHintFakeIP | DNSHintRealIP
+
+// IsDomain reports whether the slice holds domain rules
+func (t SliceType) IsDomain() bool {
+	return t == SliceTypeSortedDomain || t == SliceTypeDomainTrie || t == SliceTypeDomainTargets
+}
+
+// Feature is a header feature bit. Readers reject files whose RequiredFeatures
+// contain bits they don't support, and ignore unknown OptionalFeatures bits.
+type Feature uint32
+
+const (
+	// FeatureCompressedSlices marks files containing compressed slices (required to read them)
+	FeatureCompressedSlices Feature = 1 << 0
+	// FeatureTargetNames marks files carrying a target name table slice (optional)
+	FeatureTargetNames Feature = 1 << 1
+	// FeaturePriorities marks files carrying rule priorities (optional)
+	FeaturePriorities Feature = 1 << 2
+	// FeatureSliceExpiry marks files carrying a slice expiry table (optional: readers
+	// predating it keep applying expiring slices)
+	FeatureSliceExpiry Feature = 1 << 3
+	// FeatureDNSHints marks files carrying a DNS hint table (optional: hints are advice)
+	FeatureDNSHints Feature = 1 << 4
+)
+
+// SupportedFeatures are the required features this package can read
+const SupportedFeatures Feature = FeatureCompressedSlices
+
+// KnownFeatures are all feature bits this package understands
+const KnownFeatures = FeatureCompressedSlices | FeatureTargetNames | FeaturePriorities | FeatureSliceExpiry | FeatureDNSHints
+
+// Names returns the names of the set feature bits, lowest bit first; unknown bits
+// are named by value, e.g. "0x80"
+func (f Feature) Names() []string {
+	var names []string
+	for bit := Feature(1); bit != 0; bit <<= 1 {
+		if f&bit == 0 {
+			continue
+		}
+		switch bit {
+		case FeatureCompressedSlices:
+			names = append(names, "CompressedSlices")
+		case FeatureTargetNames:
+			names = append(names, "TargetNames")
+		case FeaturePriorities:
+			names = append(names, "Priorities")
+		case FeatureSliceExpiry:
+			names = append(names, "SliceExpiry")
+		case FeatureDNSHints:
+			names = append(names, "DNSHints")
+		default:
+			names = append(names, fmt.Sprintf("%#x", uint32(bit)))
+		}
+	}
+	return names
+}
+
+// Slice entry flags (SliceEntry.Flags)
+const (
+	// SliceFlagRequired marks a slice that readers must understand: a file with a
+	// required slice of unknown type is rejected. Unknown slices without it are skipped.
+	SliceFlagRequired uint8 = 1 << 0
+	// SliceFlagCompressed marks a slice whose data is DEFLATE-compressed (see compressSlice);
+	// files containing one also require FeatureCompressedSlices.
+	SliceFlagCompressed uint8 = 1 << 1
 )
 
 // String returns the string representation of SliceType
@@ -52,6 +160,18 @@ func (t SliceType) String() string {
 		return "ExactIPv4"
 	case SliceTypeExactIPv6:
 		return "ExactIPv6"
+	case SliceTypeDomainTrie:
+		return "DomainTrie"
+	case SliceTypeRangeV4:
+		return "RangeV4"
+	case SliceTypeRangeV6:
+		return "RangeV6"
+	case SliceTypeDomainTargets:
+		return "DomainTargets"
+	case SliceTypeExpiry:
+		return "Expiry"
+	case SliceTypeDNSHints:
+		return "DNSHints"
 	default:
 		return fmt.Sprintf("Unknown(%d)", t)
 	}
@@ -66,7 +186,9 @@ type SliceHeader struct {
 	_reserved1     [3]byte  // Reserved padding
 	Timestamp      int64    // Unix timestamp
 	Checksum       [16]byte // SHA-256 checksum (first 16 bytes)
-	_reserved2     [16]byte // Reserved for future use
+	Required       Feature  // Features readers must support (version 2+)
+	Optional       Feature  // Features readers may ignore (version 2+)
+	_reserved2     [8]byte  // Reserved for future use
 }
 
 // Validate validates the header
@@ -77,9 +199,17 @@ func (h *SliceHeader) Validate() error {
 	if h.Version > FormatVersion {
 		return fmt.Errorf("unsupported version: %d (max supported: %d)", h.Version, FormatVersion)
 	}
+	if unknown := h.Required &^ SupportedFeatures; unknown != 0 {
+		return fmt.Errorf("unsupported required features: %#x", uint32(unknown))
+	}
 	return nil
 }
 
+// HasFeature reports whether the file declares feature f (required or optional)
+func (h *SliceHeader) HasFeature(f Feature) bool {
+	return (h.Required|h.Optional)&f != 0
+}
+
 // Fallback returns the fallback target as uint8
 func (h *SliceHeader) Fallback() uint8 {
 	return h.FallbackTarget
@@ -94,10 +224,14 @@ func (h *SliceHeader) Time() time.Time {
 type SliceEntry struct {
 	SliceType  uint8    // Slice type
 	Target     uint8    // Target for this slice
-	_reserved  [2]byte  // Reserved
+	Flags      uint8    // Slice flags (SliceFlagRequired, SliceFlagCompressed)
+	_reserved  [1]byte  // Reserved
 	Offset     uint32   // Offset to slice data (from file start)
 	Size       uint32   // Size of slice data
 	Count      uint32   // Number of entries in this slice
+
+	expires int64   // Expiry from the expiry table (Unix seconds, 0 = never)
+	hints   DNSHint // DNS hints from the DNS hint table (0 = none)
 }
 
 // GetType returns the SliceType
@@ -110,6 +244,49 @@ func (e *SliceEntry) GetTarget() uint8 {
 	return e.Target
 }
 
+// IsCompressed reports whether the slice data is compressed
+func (e *SliceEntry) IsCompressed() bool {
+	return e.Flags&SliceFlagCompressed != 0
+}
+
+// Expires returns when the slice expires (zero time = never)
+func (e *SliceEntry) Expires() time.Time {
+	if e.expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(e.expires, 0)
+}
+
+// DNSHints returns the DNS hints of the slice (0 = none)
+func (e *SliceEntry) DNSHints() DNSHint {
+	return e.hints
+}
+
+// expired reports whether the slice has expired; readers skip expired slices.
+// The clock is only read for slices that expire.
+func (e *SliceEntry) expired() bool {
+	return e.expires != 0 && time.Now().Unix() >= e.expires
+}
+
+// domainCount sums the entry counts of the domain slices
+func domainCount(entries []*SliceEntry) int {
+	n := 0
+	for _, e := range entries {
+		if e.GetType().IsDomain() && !e.expired() {
+			n += int(e.Count)
+		}
+	}
+	return n
+}
+
+// Validate rejects required slices of unknown type
+func (e *SliceEntry) Validate() error {
+	if !e.GetType().Known() && e.Flags&SliceFlagRequired != 0 {
+		return fmt.Errorf("unsupported required slice type %s", e.GetType())
+	}
+	return nil
+}
+
 // ParseHeader parses a SliceHeader from bytes (little-endian)
 func ParseHeader(data []byte) (*SliceHeader, error) {
 	if len(data) < HeaderSize {
@@ -141,6 +318,12 @@ func ParseHeader(data []byte) (*SliceHeader, error) {
 	if err := binary.Read(buf, binary.LittleEndian, &h.Checksum); err != nil {
 		return nil, fmt.Errorf("failed to read checksum: %w", err)
 	}
+	if err := binary.Read(buf, binary.LittleEndian, &h.Required); err != nil {
+		return nil, fmt.Errorf("failed to read required features: %w", err)
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &h.Optional); err != nil {
+		return nil, fmt.Errorf("failed to read optional features: %w", err)
+	}
 	if err := binary.Read(buf, binary.LittleEndian, &h._reserved2); err != nil {
 		return nil, fmt.Errorf("failed to read reserved2: %w", err)
 	}
@@ -163,6 +346,9 @@ func ParseEntry(data []byte) (*SliceEntry, error) {
 	if err := binary.Read(buf, binary.LittleEndian, &e.Target); err != nil {
 		return nil, fmt.Errorf("failed to read target: %w", err)
 	}
+	if err := binary.Read(buf, binary.LittleEndian, &e.Flags); err != nil {
+		return nil, fmt.Errorf("failed to read flags: %w", err)
+	}
 	if err := binary.Read(buf, binary.LittleEndian, &e._reserved); err != nil {
 		return nil, fmt.Errorf("failed to read reserved: %w", err)
 	}
@@ -178,3 +364,105 @@ func ParseEntry(data []byte) (*SliceEntry, error) {
 
 	return &e, nil
 }
+
+// parseIndex parses and validates the header and slice index of a rule file. All
+// offset arithmetic is done in 64 bits and every slice must lie within data, so
+// crafted files fail here instead of causing out-of-range accesses later.
+func parseIndex(data []byte) (*SliceHeader, []*SliceEntry, error) {
+	if len(data) < HeaderSize {
+		return nil, nil, fmt.Errorf("insufficient data for header: got %d bytes, need %d", len(data), HeaderSize)
+	}
+
+	header, err := ParseHeader(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse header: %w", err)
+	}
+	if err := header.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	entriesEnd := uint64(HeaderSize) + uint64(header.SliceCount)*EntrySize
+	if uint64(len(data)) < entriesEnd {
+		return nil, nil, fmt.Errorf("slice index truncated: expected %d bytes, got %d", entriesEnd, len(data))
+	}
+
+	sliceCount := int(header.SliceCount)
+	entries := make([]*SliceEntry, 0, sliceCount)
+	for i := 0; i < sliceCount; i++ {
+		offset := HeaderSize + i*EntrySize
+		entry, err := ParseEntry(data[offset:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse entry %d: %w", i, err)
+		}
+		if err := entry.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if uint64(entry.Offset)+uint64(entry.Size) > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("entry %d: slice data out of range (offset %d, size %d, file %d bytes)", i, entry.Offset, entry.Size, len(data))
+		}
+		entries = append(entries, entry)
+	}
+	for i, entry := range entries {
+		switch entry.GetType() {
+		case SliceTypeExpiry:
+			if err := parseExpiry(data, entry, entries); err != nil {
+				return nil, nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+		case SliceTypeDNSHints:
+			if err := parseDNSHints(data, entry, entries); err != nil {
+				return nil, nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+		}
+	}
+	return header, entries, nil
+}
+
+// parseExpiry applies an expiry table to the entries. The table is stored
+// uncompressed as Count records of expiryRecordSize bytes, each naming a slice by its
+// index in the file and the Unix time from which readers skip it. Files with a
+// table declare the optional FeatureSliceExpiry.
+func parseExpiry(data []byte, table *SliceEntry, entries []*SliceEntry) error {
+	if table.IsCompressed() {
+		return fmt.Errorf("compressed expiry table")
+	}
+	if uint64(table.Count)*expiryRecordSize != uint64(table.Size) {
+		return fmt.Errorf("expiry table size %d does not match %d records", table.Size, table.Count)
+	}
+	records := data[table.Offset : table.Offset+table.Size]
+	for i := 0; i < int(table.Count); i++ {
+		record := records[i*expiryRecordSize:]
+		index := binary.LittleEndian.Uint32(record)
+		expires := int64(binary.LittleEndian.Uint64(record[4:]))
+		if uint64(index) >= uint64(len(entries)) || entries[index] == table {
+			return fmt.Errorf("expiry record %d: invalid slice index %d", i, index)
+		}
+		if expires <= 0 {
+			return fmt.Errorf("expiry record %d: invalid expiry %d", i, expires)
+		}
+		entries[index].expires = expires
+	}
+	return nil
+}
+
+// parseDNSHints applies a DNS hint table to the entries. The table is stored
+// uncompressed as Count records of dnsHintRecordSize bytes, each naming a domain slice
+// by its index in the file and its hints. Unknown hint bits are ignored. Files with a
+// table declare the optional FeatureDNSHints.
+func parseDNSHints(data []byte, table *SliceEntry, entries []*SliceEntry) error {
+	if table.IsCompressed() {
+		return fmt.Errorf("compressed DNS hint table")
+	}
+	if uint64(table.Count)*dnsHintRecordSize != uint64(table.Size) {
+		return fmt.Errorf("DNS hint table size %d does not match %d records", table.Size, table.Count)
+	}
+	records := data[table.Offset : table.Offset+table.Size]
+	for i := 0; i < int(table.Count); i++ {
+		record := records[i*dnsHintRecordSize:]
+		index := binary.LittleEndian.Uint32(record)
+		if uint64(index) >= uint64(len(entries)) || !entries[index].GetType().IsDomain() {
+			return fmt.Errorf("DNS hint record %d: invalid slice index %d", i, index)
+		}
+		entries[index].hints = DNSHint(record[4]) & KnownDNSHints
+	}
+	return nil
+}
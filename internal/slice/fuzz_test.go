@@ -0,0 +1,224 @@
+package slice
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzzSeeds returns valid rule files covering every slice type, plain and compressed
+func fuzzSeeds(tb testing.TB) [][]byte {
+	tb.Helper()
+	var seeds [][]byte
+	for _, compress := range []bool{false, true} {
+		w := NewSliceWriter(1)
+		if compress {
+			w.EnableSliceCompression(1)
+		}
+		steps := []error{
+			w.AddDomainSlice([]string{"google.com", "youtube.com", "a.b.example.org"}, 2),
+			w.AddDomainTrieSlice([]string{"trie.com", "www.trie.net", "deep.a.b.c.trie.org"}, 0),
+			w.AddCidrV4Slice([]CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, 1),
+			w.AddCidrV6Slice([]CidrV6Entry{{Network: [16]byte{0x20, 0x01, 0x0d, 0xb8}, PrefixLen: 32}}, 2),
+			w.AddIPRangeV4Slice([]IPRangeV4Entry{{Start: 1 << 24, End: 2 << 24}}, 0),
+			w.AddIPRangeV6Slice([]IPRangeV6Entry{{Start: [16]byte{0xfd}, End: [16]byte{0xfd, 0xff}}}, 1),
+			w.AddGeoIPSlice([]string{"CN", "US"}, 0),
+		}
+		for _, err := range steps {
+			if err != nil {
+				tb.Fatalf("writer error: %v", err)
+			}
+		}
+		data, err := w.Build()
+		if err != nil {
+			tb.Fatalf("Build error: %v", err)
+		}
+		seeds = append(seeds, data)
+	}
+	return seeds
+}
+
+// fuzzInputs are the lookups run against each fuzzed file
+var fuzzInputs = struct {
+	domains   []string
+	ips       []net.IP
+	countries []string
+}{
+	domains:   []string{"google.com", "www.trie.net", "x.deep.a.b.c.trie.org", "", ".", "a..b", "unknown.example"},
+	ips:       []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("1.5.0.0"), net.ParseIP("2001:db8::1"), net.ParseIP("fd00::1"), net.ParseIP("8.8.8.8")},
+	countries: []string{"CN", "US", "", "X"},
+}
+
+// fuzzReader is the query interface shared by SliceReader and MmapReader
+type fuzzReader interface {
+	MatchDomain(string) *uint8
+	MatchIP(net.IP) *uint8
+	MatchGeoIP(string) *uint8
+	Fallback() uint8
+}
+
+// exercise runs every lookup against r
+func exercise(r fuzzReader) {
+	for _, d := range fuzzInputs.domains {
+		r.MatchDomain(d)
+	}
+	for _, ip := range fuzzInputs.ips {
+		r.MatchIP(ip)
+	}
+	for _, c := range fuzzInputs.countries {
+		r.MatchGeoIP(c)
+	}
+	r.Fallback()
+}
+
+func FuzzParseHeader(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed[:HeaderSize])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := ParseHeader(data)
+		if err != nil {
+			return
+		}
+		h.Validate()
+		h.Time()
+		h.HasFeature(FeatureCompressedSlices)
+	})
+}
+
+func FuzzParseEntry(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed[HeaderSize : HeaderSize+EntrySize])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := ParseEntry(data)
+		if err != nil {
+			return
+		}
+		e.Validate()
+		_ = e.GetType().String()
+	})
+}
+
+func FuzzSliceReader(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewSliceReaderFromBytes(data)
+		if err != nil {
+			return
+		}
+		exercise(r)
+	})
+}
+
+func FuzzMmapReader(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewInMemoryReaderFromBytes(append([]byte(nil), data...))
+		if err != nil {
+			return
+		}
+		defer r.Close()
+		exercise(r)
+		r.IPRules()
+		for target := 0; target < 3; target++ {
+			r.Domains(uint8(target))
+		}
+		r.Touch()
+	})
+}
+
+func FuzzDomainTrie(f *testing.F) {
+	data, _, err := encodeDomainTrie([]string{"trie.com", "www.trie.net", "deep.a.b.c.trie.org"})
+	if err != nil {
+		f.Fatalf("encodeDomainTrie error: %v", err)
+	}
+	f.Add(data, "www.trie.net")
+	f.Add(data, "x.deep.a.b.c.trie.org")
+	targets, _, err := encodeDomainTargetTrie([]DomainTarget{{"trie.com", 1}, {"ads.trie.com", 3}, {"www.trie.net", 2}})
+	if err != nil {
+		f.Fatalf("encodeDomainTargetTrie error: %v", err)
+	}
+	f.Add(targets, "x.ads.trie.com")
+	f.Fuzz(func(t *testing.T, data []byte, domain string) {
+		matchDomainTrie(data, domain)
+		decodeDomainTrie(data)
+		matchDomainTargetTrie(data, domain)
+		decodeDomainTargetTrie(data)
+	})
+}
+
+func TestParseIndex_SliceOutOfRange(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddRawSlice(SliceTypeGeoIP, 0, 0, []byte("CN\x00\x00"), 1)
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	// Point the slice past the end of the file, with an offset+size that overflows 32 bits
+	binary.LittleEndian.PutUint32(data[HeaderSize+4:], 0xFFFFFFF0)
+	binary.LittleEndian.PutUint32(data[HeaderSize+8:], 0x20)
+
+	if _, err := NewSliceReaderFromBytes(data); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("NewSliceReaderFromBytes error = %v, want out of range", err)
+	}
+	if _, err := NewInMemoryReaderFromBytes(data); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("NewInMemoryReaderFromBytes error = %v, want out of range", err)
+	}
+}
+
+func TestMatchesIPv6CIDR_LongPrefix(t *testing.T) {
+	ip := [16]byte{0x20, 0x01}
+	for _, prefixLen := range []uint8{129, 200, 255} {
+		if !matchesIPv6CIDR(&ip, &ip, prefixLen) {
+			t.Errorf("prefix length %d: identical address did not match", prefixLen)
+		}
+	}
+}
+
+func TestInflatedSize_Ratio(t *testing.T) {
+	data := []byte{0, 0, 0, 0, 0x03, 0x00}
+	binary.LittleEndian.PutUint32(data, 2*maxDeflateRatio+1)
+	if _, err := inflatedSize(data); err == nil {
+		t.Error("inflatedSize accepted a size beyond the DEFLATE ratio")
+	}
+	binary.LittleEndian.PutUint32(data, 100)
+	if size, err := inflatedSize(data); err != nil || size != 100 {
+		t.Errorf("inflatedSize = %d, %v; want 100", size, err)
+	}
+}
+
+func TestDecodeDomainTrie_SharedNodes(t *testing.T) {
+	// Root with 64 children all pointing back at the root: without a visit budget
+	// the walk is exponential in the depth limit
+	const children = 64
+	pool := []byte{1, 'a'}
+	nodes := binary.LittleEndian.AppendUint32(nil, children)
+	for i := 0; i < children; i++ {
+		nodes = binary.LittleEndian.AppendUint32(nodes, 0) // label "a"
+		nodes = binary.LittleEndian.AppendUint32(nodes, 0) // child = root
+	}
+	data := binary.LittleEndian.AppendUint32(nil, 1)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(pool)))
+	data = append(append(data, pool...), nodes...)
+
+	done := make(chan []string, 1)
+	go func() { done <- decodeDomainTrie(data) }()
+	select {
+	case domains := <-done:
+		if len(domains) != 0 {
+			t.Errorf("decodeDomainTrie = %v, want none", domains)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("decodeDomainTrie did not terminate")
+	}
+	if matchDomainTrie(data, "a.a.a.a") {
+		t.Error("matchDomainTrie matched a trie without terminals")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package slice
+
+import "golang.org/x/sys/unix"
+
+// mmapSupported reports whether the platform can memory-map files
+const mmapSupported = true
+
+// availableMemory returns free plus buffer memory in bytes
+func availableMemory() (uint64, bool) {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0, false
+	}
+	return (uint64(info.Freeram) + uint64(info.Bufferram)) * uint64(info.Unit), true
+}
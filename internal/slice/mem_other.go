@@ -0,0 +1,13 @@
+//go:build !linux
+
+package slice
+
+import "runtime"
+
+// mmapSupported reports whether the platform can memory-map files
+const mmapSupported = runtime.GOOS != "js" && runtime.GOOS != "wasip1" && runtime.GOOS != "plan9" && runtime.GOOS != "aix"
+
+// availableMemory is unknown on this platform
+func availableMemory() (uint64, bool) {
+	return 0, false
+}
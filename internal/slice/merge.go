@@ -0,0 +1,463 @@
+package slice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrMergeConflict is returned (wrapped) by MergeWithOptions with ConflictError when
+// a rule of one input is overridden by a rule of another input with a different target
+var ErrMergeConflict = errors.New("merge conflict")
+
+// ConflictPolicy decides which input wins when the inputs of a merge route the
+// same domain, network or country to different targets
+type ConflictPolicy uint8
+
+const (
+	// ConflictFirstWins lets the rules of earlier inputs win (default)
+	ConflictFirstWins ConflictPolicy = iota
+	// ConflictPriority lets the rules of inputs with a higher MergeOptions.Priorities
+	// value win; inputs of equal priority keep their order
+	ConflictPriority
+	// ConflictError fails the merge with ErrMergeConflict when a rule of one input
+	// is overridden by a rule of another input with a different target
+	ConflictError
+)
+
+// MergeOptions configures MergeWithOptions
+type MergeOptions struct {
+	Conflict    ConflictPolicy
+	Priorities  []int  // Priority of each input (ConflictPriority); missing entries are 0
+	Fallback    *uint8 // Fallback target of the result (nil = fallback of the winning input)
+	CompressMin int    // Compress slices of at least this many bytes (0 = off)
+
+	// TargetMaps replaces the rule targets of each input before merging (nil maps and
+	// unlisted targets keep their value); conflicts are decided on the replaced targets
+	TargetMaps []map[uint8]uint8
+}
+
+// Merge combines rule files (.k2r, or gzip-compressed .k2r.gz) into one file at
+// outPath, with the rules of earlier inputs winning conflicts. See MergeWithOptions.
+func Merge(outPath string, inputs ...string) error {
+	return MergeWithOptions(outPath, MergeOptions{}, inputs...)
+}
+
+// MergeWithOptions combines rule files (.k2r, or gzip-compressed .k2r.gz) into one
+// file at outPath, gzip-compressed when outPath ends in ".gz". The merged file
+// matches every lookup like the inputs evaluated one after another in the order
+// set by opts.Conflict, stopping at the first input with a matching rule:
+//
+//   - Rules overridden by a rule of a winning input (the same domain or one of its
+//     parents, a containing network, the same country) are dropped.
+//   - All domains are written as one DomainTargets slice, IP rules as one CIDR
+//     slice per address family and target with non-overlapping networks, and
+//     countries as one GeoIP slice per target.
+//
+// Other slices (e.g. target names) are not carried over. Expired slices of the
+// inputs are left out; expiring slices that are still active are merged without
+// their expiry, so merge again after they expire. The file is replaced
+// atomically, so readers never see a partial result.
+func MergeWithOptions(outPath string, opts MergeOptions, inputs ...string) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no inputs to merge")
+	}
+
+	readers := make([]*MmapReader, len(inputs))
+	for i, path := range inputs {
+		r, err := openMergeInput(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer r.Close()
+		readers[i] = r
+	}
+
+	// Evaluation order of the inputs
+	order := make([]int, len(inputs))
+	for i := range order {
+		order[i] = i
+	}
+	if opts.Conflict == ConflictPriority {
+		priority := func(i int) int {
+			if i < len(opts.Priorities) {
+				return opts.Priorities[i]
+			}
+			return 0
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return priority(order[a]) > priority(order[b])
+		})
+	}
+
+	m := &merger{inputs: inputs, strict: opts.Conflict == ConflictError, targetMaps: opts.TargetMaps}
+	for _, i := range order {
+		if err := m.add(i, readers[i]); err != nil {
+			return err
+		}
+	}
+
+	fallback := readers[order[0]].Fallback()
+	if opts.Fallback != nil {
+		fallback = *opts.Fallback
+	}
+	w := NewSliceWriter(fallback)
+	if opts.CompressMin > 0 {
+		w.EnableSliceCompression(opts.CompressMin)
+	}
+	if err := m.write(w); err != nil {
+		return err
+	}
+	return writeMerged(outPath, w)
+}
+
+// openMergeInput loads a rule file into the heap, decompressing it when it starts
+// with the gzip magic bytes
+func openMergeInput(path string) (*MmapReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gzReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+		}
+		data, err = io.ReadAll(gzReader)
+		gzReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+		}
+	}
+	r, err := NewInMemoryReaderFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// mergeRule is a kept rule of a merge: its target and the input it came from
+type mergeRule struct {
+	target uint8
+	input  int
+}
+
+// merger accumulates the rules of the inputs in evaluation order
+type merger struct {
+	inputs    []string
+	strict    bool                            // ConflictError
+	domains   map[string]mergeRule            // Kept domains
+	prefixes  [129]map[netip.Prefix]mergeRule // Kept networks by prefix length
+	networks  []netip.Prefix                  // Kept networks in order
+	countries map[string]mergeRule            // Kept country codes
+
+	targetMaps []map[uint8]uint8 // MergeOptions.TargetMaps
+}
+
+// add appends the rules of input i, which all lose to the rules added before
+func (m *merger) add(i int, r *MmapReader) error {
+	if m.domains == nil {
+		m.domains = make(map[string]mergeRule)
+		m.countries = make(map[string]mergeRule)
+	}
+	target := func(t uint8) uint8 {
+		if i < len(m.targetMaps) {
+			if mapped, ok := m.targetMaps[i][t]; ok {
+				return mapped
+			}
+		}
+		return t
+	}
+
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		if !entry.GetType().IsDomain() {
+			continue
+		}
+		var level []DomainTarget
+		data := r.getSliceData(entry)
+		switch entry.GetType() {
+		case SliceTypeSortedDomain, SliceTypeDomainTrie:
+			var domains []string
+			if entry.GetType() == SliceTypeSortedDomain {
+				domains = decodeSortedDomains(data)
+			} else {
+				domains = decodeDomainTrie(data)
+			}
+			for _, d := range domains {
+				level = append(level, DomainTarget{Domain: d, Target: target(entry.GetTarget())})
+			}
+		case SliceTypeDomainTargets:
+			level = decodeDomainTargetTrie(data)
+			for j := range level {
+				level[j].Target = target(level[j].Target)
+			}
+		}
+
+		// Entries of one slice don't override each other: within a DomainTargets
+		// slice the most specific domain decides, like in the merged slice
+		var kept []DomainTarget
+		for _, e := range level {
+			domain := strings.ToLower(e.Domain)
+			if winner, parent, ok := m.coveringDomain(domain); ok {
+				if err := m.conflict(i, e.Target, winner, "domain "+domain, parent); err != nil {
+					return err
+				}
+				continue
+			}
+			kept = append(kept, DomainTarget{Domain: domain, Target: e.Target})
+		}
+		for _, e := range kept {
+			if _, ok := m.domains[e.Domain]; !ok {
+				m.domains[e.Domain] = mergeRule{target: e.Target, input: i}
+			}
+		}
+	}
+
+	// IP rules apply in file order: a rule contained in an earlier one never matches
+	for _, rule := range r.IPRules() {
+		rule.Target = target(rule.Target)
+		if winner, network, ok := m.coveringPrefix(rule.Prefix); ok {
+			if err := m.conflict(i, rule.Target, winner, "network "+rule.Prefix.String(), network.String()); err != nil {
+				return err
+			}
+			continue
+		}
+		bits := rule.Prefix.Bits()
+		if m.prefixes[bits] == nil {
+			m.prefixes[bits] = make(map[netip.Prefix]mergeRule)
+		}
+		m.prefixes[bits][rule.Prefix] = mergeRule{target: rule.Target, input: i}
+		m.networks = append(m.networks, rule.Prefix)
+	}
+
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		if entry.GetType() != SliceTypeGeoIP {
+			continue
+		}
+		data := r.getSliceData(entry)
+		for j := 0; j < int(entry.Count) && (j+1)*4 <= len(data); j++ {
+			country := string(data[j*4 : j*4+2])
+			if winner, ok := m.countries[country]; ok {
+				if err := m.conflict(i, target(entry.GetTarget()), winner, "country "+country, country); err != nil {
+					return err
+				}
+				continue
+			}
+			m.countries[country] = mergeRule{target: target(entry.GetTarget()), input: i}
+		}
+	}
+	return nil
+}
+
+// coveringDomain returns the kept rule for domain or its closest parent
+func (m *merger) coveringDomain(domain string) (mergeRule, string, bool) {
+	for suffix := domain; ; {
+		if rule, ok := m.domains[suffix]; ok {
+			return rule, suffix, true
+		}
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			return mergeRule{}, "", false
+		}
+		suffix = suffix[dot+1:]
+	}
+}
+
+// coveringPrefix returns the kept rule for a network containing prefix
+func (m *merger) coveringPrefix(prefix netip.Prefix) (mergeRule, netip.Prefix, bool) {
+	for bits := 0; bits <= prefix.Bits(); bits++ {
+		if m.prefixes[bits] == nil {
+			continue
+		}
+		network := netip.PrefixFrom(prefix.Addr(), bits).Masked()
+		if rule, ok := m.prefixes[bits][network]; ok {
+			return rule, network, true
+		}
+	}
+	return mergeRule{}, netip.Prefix{}, false
+}
+
+// conflict reports a rule of input i with target overridden by winner (the rule
+// for by), if that is an error
+func (m *merger) conflict(i int, target uint8, winner mergeRule, rule, by string) error {
+	if !m.strict || winner.input == i || winner.target == target {
+		return nil
+	}
+	return fmt.Errorf("%w: %s → %d of %s overridden by %s → %d of %s", ErrMergeConflict,
+		rule, target, m.inputs[i], by, winner.target, m.inputs[winner.input])
+}
+
+// write appends the merged slices to w
+func (m *merger) write(w *SliceWriter) error {
+	if len(m.domains) > 0 {
+		entries := make([]DomainTarget, 0, len(m.domains))
+		for domain, rule := range m.domains {
+			entries = append(entries, DomainTarget{Domain: domain, Target: rule.target})
+		}
+		sort.Slice(entries, func(a, b int) bool { return entries[a].Domain < entries[b].Domain })
+		if err := w.AddDomainTargetSlice(entries); err != nil {
+			return err
+		}
+	}
+
+	for _, family := range []int{32, 128} {
+		byTarget := m.flatten(family)
+		for _, target := range sortedTargets(byTarget) {
+			if family == 32 {
+				cidrs := make([]CidrV4Entry, len(byTarget[target]))
+				for i, p := range byTarget[target] {
+					a := p.Addr().As4()
+					cidrs[i] = CidrV4Entry{
+						Network:   uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3]),
+						PrefixLen: uint8(p.Bits()),
+					}
+				}
+				if err := w.AddCidrV4Slice(cidrs, target); err != nil {
+					return err
+				}
+				continue
+			}
+			cidrs := make([]CidrV6Entry, len(byTarget[target]))
+			for i, p := range byTarget[target] {
+				cidrs[i] = CidrV6Entry{Network: p.Addr().As16(), PrefixLen: uint8(p.Bits())}
+			}
+			if err := w.AddCidrV6Slice(cidrs, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	byTarget := make(map[uint8][]string)
+	for country, rule := range m.countries {
+		byTarget[rule.target] = append(byTarget[rule.target], country)
+	}
+	for _, target := range sortedTargets(byTarget) {
+		countries := byTarget[target]
+		sort.Strings(countries)
+		if err := w.AddGeoIPSlice(countries, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatten returns the kept networks of one address family (32 or 128 bits) as
+// non-overlapping prefixes by target, where each address keeps the target of the
+// most specific kept network containing it. Kept networks never contain an
+// earlier kept network, so that is the target of the first matching input rule.
+func (m *merger) flatten(family int) map[uint8][]netip.Prefix {
+	type span struct {
+		start, end netip.Addr
+		target     uint8
+	}
+	var spans []span
+	for _, p := range m.networks {
+		if p.Addr().BitLen() != family {
+			continue
+		}
+		spans = append(spans, span{p.Addr(), lastAddr(p), m.prefixes[p.Bits()][p].target})
+	}
+	// Networks are nested or disjoint: sort by start, containing networks first
+	sort.Slice(spans, func(a, b int) bool {
+		if c := spans[a].start.Compare(spans[b].start); c != 0 {
+			return c < 0
+		}
+		return spans[a].end.Compare(spans[b].end) > 0
+	})
+
+	var out []span
+	emit := func(start, end netip.Addr, target uint8) {
+		if n := len(out); n > 0 && out[n-1].target == target && out[n-1].end.Next() == start {
+			out[n-1].end = end
+			return
+		}
+		out = append(out, span{start, end, target})
+	}
+	var stack []span
+	var cursor netip.Addr // First address not emitted yet (invalid = none left)
+	pop := func() {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cursor.IsValid() && cursor.Compare(top.end) <= 0 {
+			emit(cursor, top.end, top.target)
+			cursor = top.end.Next()
+		}
+	}
+	for _, s := range spans {
+		for len(stack) > 0 && stack[len(stack)-1].end.Less(s.start) {
+			pop()
+		}
+		if len(stack) > 0 && cursor.Less(s.start) {
+			emit(cursor, s.start.Prev(), stack[len(stack)-1].target)
+		}
+		cursor = s.start
+		stack = append(stack, s)
+	}
+	for len(stack) > 0 {
+		pop()
+	}
+
+	byTarget := make(map[uint8][]netip.Prefix)
+	for _, s := range out {
+		byTarget[s.target] = rangePrefixes(byTarget[s.target], s.start, s.end)
+	}
+	return byTarget
+}
+
+// sortedTargets returns the targets of m in ascending order
+func sortedTargets[V any](m map[uint8]V) []uint8 {
+	targets := make([]uint8, 0, len(m))
+	for t := range m {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+	return targets
+}
+
+// writeMerged writes w to outPath through a temporary file in the same directory,
+// gzip-compressed when outPath ends in ".gz"
+func writeMerged(outPath string, w *SliceWriter) error {
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), filepath.Base(outPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if strings.HasSuffix(outPath, ".gz") {
+		gz := gzip.NewWriter(tmp)
+		if _, err = w.WriteTo(gz); err == nil {
+			err = gz.Close()
+		}
+	} else {
+		_, err = w.WriteTo(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
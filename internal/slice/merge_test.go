@@ -0,0 +1,332 @@
+package slice
+
+import (
+	"compress/gzip"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeMergeInput writes a rule file built by build to dir/name (gzip-compressed
+// when name ends in .gz)
+func writeMergeInput(t *testing.T, dir, name string, fallback uint8, build func(w *SliceWriter)) string {
+	t.Helper()
+	w := NewSliceWriter(fallback)
+	build(w)
+	path := filepath.Join(dir, name)
+	if err := writeMerged(path, w); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mergeInputs returns two inputs that disagree on some domains, networks and countries
+func mergeInputs(t *testing.T, dir string) (community, corporate string) {
+	community = writeMergeInput(t, dir, "community.k2r.gz", 1, func(w *SliceWriter) {
+		w.AddDomainSlice([]string{"ads.example.com", "tracker.test"}, 2)
+		w.AddDomainTrieSlice([]string{"example.com", "cn"}, 0)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.0.0.0"), PrefixLen: 8}}, 0)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("192.168.0.0"), PrefixLen: 16}}, 1)
+		w.AddIPRangeV6Slice([]IPRangeV6Entry{{Start: v6("2001:db8::"), End: v6("2001:db8::ff")}}, 2)
+		w.AddGeoIPSlice([]string{"CN", "HK"}, 0)
+	})
+	corporate = writeMergeInput(t, dir, "corporate.k2r", 0, func(w *SliceWriter) {
+		w.AddDomainTargetSlice([]DomainTarget{
+			{Domain: "intranet.example.com", Target: 1},
+			{Domain: "tracker.test", Target: 0},
+			{Domain: "corp.internal", Target: 0},
+		})
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.1.0.0"), PrefixLen: 16}}, 1)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("192.168.1.0"), PrefixLen: 24}}, 0)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("172.16.0.0"), PrefixLen: 12}}, 0)
+		w.AddGeoIPSlice([]string{"HK", "US"}, 1)
+	})
+	return community, corporate
+}
+
+// chainedLookups checks that merged matches every lookup like the inputs evaluated
+// one after another in order
+func chainedLookups(t *testing.T, merged string, order ...string) {
+	t.Helper()
+	out, err := openMergeInput(merged)
+	if err != nil {
+		t.Fatalf("open merged: %v", err)
+	}
+	defer out.Close()
+	var inputs []*MmapReader
+	for _, path := range order {
+		r, err := openMergeInput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		inputs = append(inputs, r)
+	}
+	first := func(match func(r *MmapReader) *uint8) *uint8 {
+		for _, r := range inputs {
+			if got := match(r); got != nil {
+				return got
+			}
+		}
+		return nil
+	}
+
+	for _, domain := range []string{
+		"ads.example.com", "x.ads.example.com", "example.com", "intranet.example.com",
+		"a.intranet.example.com", "tracker.test", "corp.internal", "news.cn", "example.org",
+	} {
+		want := first(func(r *MmapReader) *uint8 { return r.MatchDomain(domain) })
+		if got := out.MatchDomain(domain); !sameTarget(got, want) {
+			t.Errorf("MatchDomain(%s) = %v, want %v", domain, fmtTarget(got), fmtTarget(want))
+		}
+	}
+	for _, ip := range []string{
+		"10.0.0.1", "10.1.2.3", "10.2.0.0", "10.255.255.255", "192.168.0.1", "192.168.1.1",
+		"192.168.2.1", "172.16.5.5", "8.8.8.8", "2001:db8::1", "2001:db8::100",
+	} {
+		want := first(func(r *MmapReader) *uint8 { return r.MatchIP(net.ParseIP(ip)) })
+		if got := out.MatchIP(net.ParseIP(ip)); !sameTarget(got, want) {
+			t.Errorf("MatchIP(%s) = %v, want %v", ip, fmtTarget(got), fmtTarget(want))
+		}
+	}
+	for _, country := range []string{"CN", "HK", "US", "JP"} {
+		want := first(func(r *MmapReader) *uint8 { return r.MatchGeoIP(country) })
+		if got := out.MatchGeoIP(country); !sameTarget(got, want) {
+			t.Errorf("MatchGeoIP(%s) = %v, want %v", country, fmtTarget(got), fmtTarget(want))
+		}
+	}
+}
+
+func sameTarget(a, b *uint8) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func fmtTarget(t *uint8) any {
+	if t == nil {
+		return "no match"
+	}
+	return *t
+}
+
+func TestMerge_FirstWins(t *testing.T) {
+	dir := t.TempDir()
+	community, corporate := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r")
+
+	if err := Merge(out, community, corporate); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	chainedLookups(t, out, community, corporate)
+
+	r, err := openMergeInput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.Fallback(); got != 1 {
+		t.Errorf("Fallback() = %d, want 1 (first input)", got)
+	}
+	// intranet.example.com is covered by example.com of the first input
+	if got := r.DomainCount(); got != 5 {
+		t.Errorf("DomainCount() = %d, want 5", got)
+	}
+	// One domain slice, CIDR slices for IPv4 DIRECT and PROXY and IPv6 REJECT, two GeoIP slices
+	if got := r.SliceCount(); got != 6 {
+		t.Errorf("SliceCount() = %d, want 6", got)
+	}
+}
+
+func TestMerge_Reversed(t *testing.T) {
+	dir := t.TempDir()
+	community, corporate := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r")
+
+	if err := Merge(out, corporate, community); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	chainedLookups(t, out, corporate, community)
+}
+
+func TestMerge_Priority(t *testing.T) {
+	dir := t.TempDir()
+	community, corporate := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r.gz")
+
+	fallback := uint8(2)
+	err := MergeWithOptions(out, MergeOptions{
+		Conflict:    ConflictPriority,
+		Priorities:  []int{0, 10},
+		Fallback:    &fallback,
+		CompressMin: 16,
+	}, community, corporate)
+	if err != nil {
+		t.Fatalf("MergeWithOptions() failed: %v", err)
+	}
+	chainedLookups(t, out, corporate, community)
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := gzip.NewReader(f); err != nil {
+		t.Errorf("output of a .gz path is not gzip-compressed: %v", err)
+	}
+	r, err := openMergeInput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.Fallback(); got != 2 {
+		t.Errorf("Fallback() = %d, want 2", got)
+	}
+	if got := r.MatchDomain("intranet.example.com"); got == nil || *got != 1 {
+		t.Errorf("intranet.example.com = %v, want 1 (higher priority input)", fmtTarget(got))
+	}
+}
+
+func TestMerge_ConflictError(t *testing.T) {
+	dir := t.TempDir()
+	community, corporate := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r")
+
+	err := MergeWithOptions(out, MergeOptions{Conflict: ConflictError}, community, corporate)
+	if !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("MergeWithOptions() = %v, want ErrMergeConflict", err)
+	}
+	if _, statErr := os.Stat(out); !os.IsNotExist(statErr) {
+		t.Error("failed merge wrote the output file")
+	}
+
+	// Overlapping rules with the same target are no conflict
+	a := writeMergeInput(t, dir, "a.k2r", 1, func(w *SliceWriter) {
+		w.AddDomainSlice([]string{"example.com"}, 2)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.0.0.0"), PrefixLen: 8}}, 0)
+	})
+	b := writeMergeInput(t, dir, "b.k2r", 1, func(w *SliceWriter) {
+		w.AddDomainSlice([]string{"ads.example.com", "other.org"}, 2)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.1.0.0"), PrefixLen: 16}}, 0)
+	})
+	if err := MergeWithOptions(out, MergeOptions{Conflict: ConflictError}, a, b); err != nil {
+		t.Fatalf("MergeWithOptions() failed: %v", err)
+	}
+	chainedLookups(t, out, a, b)
+}
+
+func TestMerge_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if err := Merge(filepath.Join(dir, "out.k2r")); err == nil {
+		t.Error("Merge() without inputs succeeded")
+	}
+	if err := Merge(filepath.Join(dir, "out.k2r"), filepath.Join(dir, "missing.k2r")); err == nil {
+		t.Error("Merge() of a missing input succeeded")
+	}
+	bad := filepath.Join(dir, "bad.k2r")
+	os.WriteFile(bad, []byte("not a rule file"), 0o644)
+	if err := Merge(filepath.Join(dir, "out.k2r"), bad); err == nil {
+		t.Error("Merge() of an invalid input succeeded")
+	}
+}
+
+func TestMergeFlatten(t *testing.T) {
+	dir := t.TempDir()
+	// Nested networks with alternating targets, and one reaching the last address
+	in := writeMergeInput(t, dir, "nested.k2r", 0, func(w *SliceWriter) {
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.1.1.0"), PrefixLen: 24}}, 0)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.1.0.0"), PrefixLen: 16}}, 2)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.0.0.0"), PrefixLen: 8}}, 1)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("255.0.0.0"), PrefixLen: 8}}, 2)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("255.255.255.255"), PrefixLen: 32}}, 0) // shadowed
+	})
+	out := filepath.Join(dir, "merged.k2r")
+	if err := Merge(out, in); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	r, err := openMergeInput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for ip, want := range map[string]uint8{
+		"10.1.1.1": 0, "10.1.0.1": 2, "10.1.2.1": 2, "10.0.0.1": 1, "10.200.0.1": 1,
+		"255.0.0.1": 2, "255.255.255.255": 2,
+	} {
+		if got := r.MatchIP(net.ParseIP(ip)); got == nil || *got != want {
+			t.Errorf("MatchIP(%s) = %v, want %d", ip, fmtTarget(got), want)
+		}
+	}
+	// Merged networks don't overlap
+	rules := r.IPRules()
+	for i, a := range rules {
+		for _, b := range rules[i+1:] {
+			if a.Prefix.Overlaps(b.Prefix) {
+				t.Errorf("%s overlaps %s", a.Prefix, b.Prefix)
+			}
+		}
+	}
+}
+
+func TestMerge_TargetMaps(t *testing.T) {
+	dir := t.TempDir()
+	community, corporate := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r")
+
+	// The community list's REJECT rules become PROXY; the corporate input is unmapped
+	err := MergeWithOptions(out, MergeOptions{TargetMaps: []map[uint8]uint8{{2: 1}}}, community, corporate)
+	if err != nil {
+		t.Fatalf("MergeWithOptions() failed: %v", err)
+	}
+	r, err := openMergeInput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for domain, want := range map[string]uint8{"ads.example.com": 1, "tracker.test": 1, "corp.internal": 0} {
+		if got := r.MatchDomain(domain); got == nil || *got != want {
+			t.Errorf("MatchDomain(%s) = %v, want %d", domain, fmtTarget(got), want)
+		}
+	}
+	if got := r.MatchIP(net.ParseIP("2001:db8::1")); got == nil || *got != 1 {
+		t.Errorf("MatchIP(2001:db8::1) = %v, want 1", fmtTarget(got))
+	}
+}
+
+func TestMerge_ExpiredSlices(t *testing.T) {
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	event := writeMergeInput(t, dir, "event.k2r", 1, func(w *SliceWriter) {
+		w.AddDomainSlice([]string{"example.com"}, 2)
+		w.ExpireLastSlice(past)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: v4("10.0.0.0"), PrefixLen: 8}}, 2)
+		w.ExpireLastSlice(past)
+		w.AddGeoIPSlice([]string{"CN"}, 2)
+		w.ExpireLastSlice(past)
+		w.AddDomainSlice([]string{"tracker.test"}, 2)
+		w.ExpireLastSlice(time.Now().Add(time.Hour))
+	})
+	community, _ := mergeInputs(t, dir)
+	out := filepath.Join(dir, "merged.k2r")
+	if err := Merge(out, event, community); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	chainedLookups(t, out, event, community)
+
+	r, err := openMergeInput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.HasFeature(FeatureSliceExpiry) {
+		t.Error("merged file has FeatureSliceExpiry")
+	}
+	if got := r.MatchDomain("example.com"); got == nil || *got != 0 {
+		t.Errorf("MatchDomain(example.com) = %v, want 0 from community", fmtTarget(got))
+	}
+}
@@ -0,0 +1,14 @@
+//go:build aix
+
+package slice
+
+import "os"
+
+// mmap-go has no implementation for these platforms; mmapSupported is false there,
+// so readers use ModeInMemory and these are never reached.
+
+func mapFile(f *os.File) ([]byte, error) { return nil, ErrMmapUnsupported }
+
+func mapAnon(size int) ([]byte, error) { return nil, ErrMmapUnsupported }
+
+func unmap(b []byte) error { return nil }
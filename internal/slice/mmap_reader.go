@@ -7,21 +7,25 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
-
-	mmap "github.com/edsrzf/mmap-go"
+	"time"
 )
 
 // MmapReader provides zero-copy access to K2Rule files using memory-mapped I/O
 type MmapReader struct {
 	file    *os.File      // File handle
-	data    mmap.MMap     // Memory-mapped region (zero-copy)
+	data    []byte        // Memory-mapped region (zero-copy)
 	size    int64         // File size
 	header  *SliceHeader  // Parsed header (resident in memory ~64 bytes)
 	entries []*SliceEntry // Slice entries (resident in memory ~100s of bytes)
+	memory  bool          // data is a heap copy of the file, not a mapping (ModeInMemory)
+
+	// Compressed slices, decompressed on first access into anonymous mappings
+	inflated map[*SliceEntry]*inflatedSlice
 }
 
 // NewMmapReader creates a new mmap reader from an uncompressed file
@@ -43,11 +47,17 @@ func NewMmapReader(path string) (*MmapReader, error) {
 		return nil, fmt.Errorf("file is empty")
 	}
 
-	// Memory-map the file (zero-copy on all platforms)
-	data, err := mmap.Map(file, mmap.RDONLY, 0)
+	if !mmapSupported {
+		file.Close()
+		return nil, ErrMmapUnsupported
+	}
+
+	// Memory-map the whole file from offset 0 (offsets must be page-aligned; the
+	// kernel zero-fills the tail of the last page)
+	data, err := mapFile(file)
 	if err != nil {
 		file.Close()
-		return nil, fmt.Errorf("failed to mmap file: %w", err)
+		return nil, fmt.Errorf("failed to mmap file (%d bytes, page size %d): %w", size, pageSize, err)
 	}
 
 	reader := &MmapReader{
@@ -93,12 +103,18 @@ func NewMmapReaderFromGzip(gzipPath string) (*MmapReader, error) {
 // Close unmaps the memory and closes the file
 func (r *MmapReader) Close() error {
 	var err error
-	if r.data != nil {
-		if unmapErr := r.data.Unmap(); unmapErr != nil {
+	for _, s := range r.inflated {
+		if unmapErr := s.close(); unmapErr != nil && err == nil {
+			err = unmapErr
+		}
+	}
+	r.inflated = nil
+	if r.data != nil && !r.memory {
+		if unmapErr := unmap(r.data); unmapErr != nil {
 			err = unmapErr
 		}
-		r.data = nil
 	}
+	r.data = nil
 	if r.file != nil {
 		if closeErr := r.file.Close(); closeErr != nil && err == nil {
 			err = closeErr
@@ -110,56 +126,99 @@ func (r *MmapReader) Close() error {
 
 // parseHeaderAndEntries parses header and slice entries (resident in memory)
 func (r *MmapReader) parseHeaderAndEntries() error {
-	if len(r.data) < HeaderSize {
-		return fmt.Errorf("insufficient data for header: got %d bytes, need %d", len(r.data), HeaderSize)
-	}
-
-	// Parse header
-	header, err := ParseHeader(r.data)
+	header, entries, err := parseIndex(r.data)
 	if err != nil {
-		return fmt.Errorf("failed to parse header: %w", err)
-	}
-
-	if err := header.Validate(); err != nil {
-		return fmt.Errorf("invalid header: %w", err)
+		return err
 	}
-
 	r.header = header
 
-	// Parse slice entries
-	sliceCount := int(header.SliceCount)
-	entriesEnd := HeaderSize + sliceCount*EntrySize
-
-	if len(r.data) < entriesEnd {
-		return fmt.Errorf("slice index truncated: expected %d bytes, got %d", entriesEnd, len(r.data))
-	}
-
-	entries := make([]*SliceEntry, 0, sliceCount)
-	for i := 0; i < sliceCount; i++ {
-		offset := HeaderSize + i*EntrySize
-		entry, err := ParseEntry(r.data[offset:])
-		if err != nil {
-			return fmt.Errorf("failed to parse entry %d: %w", i, err)
+	for _, entry := range entries {
+		if entry.IsCompressed() {
+			if r.inflated == nil {
+				r.inflated = make(map[*SliceEntry]*inflatedSlice)
+			}
+			r.inflated[entry] = &inflatedSlice{heap: r.memory}
 		}
-		entries = append(entries, entry)
 	}
 
 	r.entries = entries
 	return nil
 }
 
-// getSliceData returns a zero-copy slice view into the mmap region
+// getSliceData returns a zero-copy slice view into the mmap region.
+// Compressed slices are decompressed on first access; a slice that fails to
+// decompress reads as empty (matches nothing) and is reported by Err.
 func (r *MmapReader) getSliceData(entry *SliceEntry) []byte {
-	offset := int(entry.Offset)
-	size := int(entry.Size)
+	if entry.IsCompressed() {
+		s := r.inflated[entry]
+		if s == nil {
+			return nil
+		}
+		data, err := s.get(r.rawSliceData(entry))
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	return r.rawSliceData(entry)
+}
+
+// rawSliceData returns the stored (possibly compressed) slice bytes in the mmap region
+func (r *MmapReader) rawSliceData(entry *SliceEntry) []byte {
+	offset, size := uint64(entry.Offset), uint64(entry.Size)
 
 	// Zero-copy: return a slice view into the mmap region
-	if offset+size > len(r.data) {
+	if offset+size > uint64(len(r.data)) {
 		return nil
 	}
 	return r.data[offset : offset+size]
 }
 
+// Err decompresses the compressed slices not accessed yet and returns the first
+// slice that failed to decompress or map (nil = all slices readable). Lookups
+// treat such a slice as empty, so call Err after loading to surface the failure.
+func (r *MmapReader) Err() error {
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		if s := r.inflated[entry]; s != nil {
+			if _, err := s.get(r.rawSliceData(entry)); err != nil {
+				return fmt.Errorf("slice %s: %w", entry.GetType(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// touchSink keeps Touch's page reads from being optimized away
+var touchSink byte
+
+// Touch faults in every page of the mapping and decompresses compressed slices, so
+// first lookups after a load don't pay for page faults. Returns the number of pages read.
+func (r *MmapReader) Touch() int {
+	var sum byte
+	pages := 0
+	for off := 0; off < len(r.data); off += pageSize {
+		sum ^= r.data[off]
+		pages++
+	}
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		if entry.IsCompressed() {
+			data := r.getSliceData(entry)
+			for off := 0; off < len(data); off += pageSize {
+				sum ^= data[off]
+				pages++
+			}
+		}
+	}
+	touchSink = sum
+	return pages
+}
+
 // Fallback returns the fallback target
 func (r *MmapReader) Fallback() uint8 {
 	if r.header == nil {
@@ -168,32 +227,156 @@ func (r *MmapReader) Fallback() uint8 {
 	return r.header.Fallback()
 }
 
+// HasFeature reports whether the file declares header feature f
+func (r *MmapReader) HasFeature(f Feature) bool {
+	return r.header != nil && r.header.HasFeature(f)
+}
+
+// Version returns the format version of the file header
+func (r *MmapReader) Version() uint32 {
+	if r.header == nil {
+		return 0
+	}
+	return r.header.Version
+}
+
+// Features returns the required and optional features declared by the file
+func (r *MmapReader) Features() Feature {
+	if r.header == nil {
+		return 0
+	}
+	return r.header.Required | r.header.Optional
+}
+
 // SliceCount returns the number of slices
 func (r *MmapReader) SliceCount() int {
 	return len(r.entries)
 }
 
+// DomainCount returns the number of domain rules in all domain slices (from the
+// slice index; a domain stored in several slices is counted in each)
+func (r *MmapReader) DomainCount() int {
+	return domainCount(r.entries)
+}
+
+// Size returns the size of the mapped (uncompressed) file in bytes
+func (r *MmapReader) Size() int64 {
+	return r.size
+}
+
+// Timestamp returns the build timestamp stored in the file header
+func (r *MmapReader) Timestamp() time.Time {
+	if r.header == nil {
+		return time.Time{}
+	}
+	return r.header.Time()
+}
+
 // MatchDomain matches a domain against all domain slices (zero-copy)
 func (r *MmapReader) MatchDomain(domain string) *uint8 {
-	normalized := strings.ToLower(domain)
+	if _, target, ok := r.matchDomainEntry(strings.ToLower(domain)); ok {
+		return &target
+	}
+	return nil
+}
+
+// MatchDomainHints is like MatchDomain, also returning the DNS hints of the slice
+// that matched (always 0 for files without FeatureDNSHints)
+func (r *MmapReader) MatchDomainHints(domain string) (target uint8, hints DNSHint, ok bool) {
+	entry, target, ok := r.matchDomainEntry(strings.ToLower(domain))
+	if !ok {
+		return 0, 0, false
+	}
+	return target, entry.hints, true
+}
 
+// matchDomainEntry returns the first domain slice matching a lowercased domain with
+// its target
+func (r *MmapReader) matchDomainEntry(normalized string) (*SliceEntry, uint8, bool) {
 	for _, entry := range r.entries {
-		if entry.GetType() != SliceTypeSortedDomain {
+		if entry.expired() {
 			continue
 		}
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			if r.matchDomainInSlice(entry, normalized) {
+				return entry, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTrie:
+			if matchDomainTrie(r.getSliceData(entry), normalized) {
+				return entry, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTargets:
+			if target, ok := matchDomainTargetTrie(r.getSliceData(entry), normalized); ok {
+				return entry, target, true
+			}
+		}
+	}
+	return nil, 0, false
+}
 
-		if r.matchDomainInSlice(entry, normalized) {
-			target := entry.GetTarget()
-			return &target
+// UniformBelow reports whether MatchDomain gives parent and all of its subdomains the
+// same result: no domain slice stores a domain strictly below parent. Files with
+// expiring slices never are, as their results change over time.
+func (r *MmapReader) UniformBelow(parent string) bool {
+	if r.HasFeature(FeatureSliceExpiry) {
+		return false
+	}
+	parent = strings.ToLower(parent)
+	for _, entry := range r.entries {
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			if sortedDomainsBelow(r.getSliceData(entry), parent) {
+				return false
+			}
+		case SliceTypeDomainTrie:
+			if trieDomainsBelow(r.getSliceData(entry), parent, false) {
+				return false
+			}
+		case SliceTypeDomainTargets:
+			if trieDomainsBelow(r.getSliceData(entry), parent, true) {
+				return false
+			}
 		}
 	}
+	return true
+}
 
-	return nil
+// MatchDomainSuffix is like MatchDomain, also returning the stored domain that
+// matched: domain itself or the parent domain whose rule covers it. Within a
+// SortedDomain slice the longest stored suffix is reported.
+func (r *MmapReader) MatchDomainSuffix(domain string) (suffix string, target uint8, ok bool) {
+	normalized := strings.ToLower(domain)
+
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			if suffix, ok := r.domainSuffixInSlice(entry, normalized); ok {
+				return suffix, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTrie:
+			if start, ok := domainTrieSuffix(r.getSliceData(entry), normalized); ok {
+				return normalized[start:], entry.GetTarget(), true
+			}
+		case SliceTypeDomainTargets:
+			if start, target, ok := domainTargetTrieSuffix(r.getSliceData(entry), normalized); ok {
+				return normalized[start:], target, true
+			}
+		}
+	}
+
+	return "", 0, false
 }
 
 // MatchIP matches an IP address against all IP slices (zero-copy)
 func (r *MmapReader) MatchIP(ip net.IP) *uint8 {
 	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
 		switch entry.GetType() {
 		case SliceTypeCidrV4:
 			if ip4 := ip.To4(); ip4 != nil {
@@ -210,6 +393,16 @@ func (r *MmapReader) MatchIP(ip net.IP) *uint8 {
 					return &target
 				}
 			}
+		case SliceTypeRangeV4:
+			if ip4 := ip.To4(); ip4 != nil && matchRangeV4(r.getSliceData(entry), int(entry.Count), ipToUint32(ip4)) {
+				target := entry.GetTarget()
+				return &target
+			}
+		case SliceTypeRangeV6:
+			if ip16 := ip.To16(); ip16 != nil && matchRangeV6(r.getSliceData(entry), int(entry.Count), [16]byte(ip16)) {
+				target := entry.GetTarget()
+				return &target
+			}
 		}
 	}
 
@@ -222,6 +415,9 @@ func (r *MmapReader) MatchGeoIP(country string) *uint8 {
 	countryBytes := []byte(countryUpper)
 
 	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
 		if entry.GetType() != SliceTypeGeoIP {
 			continue
 		}
@@ -235,6 +431,117 @@ func (r *MmapReader) MatchGeoIP(country string) *uint8 {
 	return nil
 }
 
+// IPRule is an IP rule of a rule file: a network and its target
+type IPRule struct {
+	Prefix netip.Prefix
+	Target uint8
+}
+
+// IPRules returns all IPv4 and IPv6 CIDR and range rules in file order, ranges as
+// their covering prefixes. The first rule containing an address is the one MatchIP
+// applies.
+func (r *MmapReader) IPRules() []IPRule {
+	var rules []IPRule
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		data := r.getSliceData(entry)
+		count := int(entry.Count)
+		target := entry.GetTarget()
+
+		var prefixes []netip.Prefix
+		switch entry.GetType() {
+		case SliceTypeCidrV4:
+			// Each entry is 8 bytes: network (4) + prefix_len (1) + padding (3)
+			for i := 0; i < count && (i+1)*8 <= len(data); i++ {
+				e := data[i*8:]
+				addr := netip.AddrFrom4([4]byte{e[0], e[1], e[2], e[3]})
+				prefixes = append(prefixes, netip.PrefixFrom(addr, int(min(e[4], 32))).Masked())
+			}
+		case SliceTypeCidrV6:
+			// Each entry is 24 bytes: network (16) + prefix_len (1) + padding (7)
+			for i := 0; i < count && (i+1)*24 <= len(data); i++ {
+				e := data[i*24:]
+				addr := netip.AddrFrom16([16]byte(e[:16]))
+				prefixes = append(prefixes, netip.PrefixFrom(addr, int(min(e[16], 128))).Masked())
+			}
+		case SliceTypeRangeV4, SliceTypeRangeV6:
+			prefixes = decodeRangePrefixes(entry.GetType(), data, count)
+		}
+		for _, prefix := range prefixes {
+			rules = append(rules, IPRule{Prefix: prefix, Target: target})
+		}
+	}
+	return rules
+}
+
+// CIDRs returns all IPv4 and IPv6 CIDR rules with the given target, in file order
+// (ranges as their covering prefixes)
+func (r *MmapReader) CIDRs(target uint8) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, rule := range r.IPRules() {
+		if rule.Target == target {
+			prefixes = append(prefixes, rule.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// Domains returns the domains of all domain slices with the given target, in file
+// order. Each domain also matches its subdomains, except those of a DomainTargets
+// slice stored with another target.
+func (r *MmapReader) Domains(target uint8) []string {
+	var domains []string
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		if entry.GetType() == SliceTypeDomainTargets {
+			for _, e := range decodeDomainTargetTrie(r.getSliceData(entry)) {
+				if e.Target == target {
+					domains = append(domains, e.Domain)
+				}
+			}
+			continue
+		}
+		if entry.GetTarget() != target {
+			continue
+		}
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			domains = append(domains, decodeSortedDomains(r.getSliceData(entry))...)
+		case SliceTypeDomainTrie:
+			domains = append(domains, decodeDomainTrie(r.getSliceData(entry))...)
+		}
+	}
+	return domains
+}
+
+// decodeSortedDomains returns the domains of SortedDomain slice data (stored order)
+func decodeSortedDomains(data []byte) []string {
+	if len(data) < 4 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint32(data[0:4]))
+	if count <= 0 || uint64(len(data)) < 4+(uint64(count)+1)*4 {
+		return nil
+	}
+	stringsStart := 4 + (count+1)*4
+
+	domains := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		off := int(binary.LittleEndian.Uint32(data[4+i*4:]))
+		nextOff := int(binary.LittleEndian.Uint32(data[4+(i+1)*4:]))
+		if off > nextOff || nextOff > len(data)-stringsStart {
+			break
+		}
+		// Stored reversed and dot-prefixed: "moc.elgoog." → "google.com"
+		domains = append(domains, strings.TrimPrefix(reverseString(string(data[stringsStart+off:stringsStart+nextOff])), "."))
+	}
+	return domains
+}
+
 // matchDomainInSlice matches a domain within a single sorted domain slice using binary search (zero-copy).
 // The slice data layout:
 //
@@ -245,24 +552,31 @@ func (r *MmapReader) MatchGeoIP(country string) *uint8 {
 //	sentinel   (4 bytes LE)   total strings length
 //	strings area (variable)   reversed, lowercased, dot-prefixed domains sorted lexicographically
 func (r *MmapReader) matchDomainInSlice(entry *SliceEntry, domain string) bool {
+	_, ok := r.domainSuffixInSlice(entry, domain)
+	return ok
+}
+
+// domainSuffixInSlice is like matchDomainInSlice, also returning the matched suffix
+// of domain (the longest one stored)
+func (r *MmapReader) domainSuffixInSlice(entry *SliceEntry, domain string) (string, bool) {
 	// Zero-copy: get domain slice data as a view into the mmap region
 	sliceData := r.getSliceData(entry)
 	if sliceData == nil || len(sliceData) < 4 {
-		return false
+		return "", false
 	}
 
 	// Read count (4 bytes LE)
 	count := int(binary.LittleEndian.Uint32(sliceData[0:4]))
 	if count == 0 {
-		return false
+		return "", false
 	}
 
 	// Validate we have enough data for the offsets array + sentinel
 	// offsets region: (count+1) * 4 bytes, starting at byte 4
-	offsetsEnd := 4 + (count+1)*4
-	if len(sliceData) < offsetsEnd {
-		return false
+	if uint64(len(sliceData)) < 4+(uint64(count)+1)*4 {
+		return "", false
 	}
+	offsetsEnd := 4 + (count+1)*4
 
 	// strings area starts right after offsets+sentinel
 	stringsStart := offsetsEnd
@@ -271,7 +585,7 @@ func (r *MmapReader) matchDomainInSlice(entry *SliceEntry, domain string) bool {
 	getDomainAt := func(i int) string {
 		off := int(binary.LittleEndian.Uint32(sliceData[4+i*4 : 4+i*4+4]))
 		nextOff := int(binary.LittleEndian.Uint32(sliceData[4+(i+1)*4 : 4+(i+1)*4+4]))
-		if stringsStart+nextOff > len(sliceData) || off > nextOff {
+		if off > nextOff || nextOff > len(sliceData)-stringsStart {
 			return ""
 		}
 		return string(sliceData[stringsStart+off : stringsStart+nextOff])
@@ -295,33 +609,65 @@ func (r *MmapReader) matchDomainInSlice(entry *SliceEntry, domain string) bool {
 			return getDomainAt(j) >= target
 		})
 		if idx < count && getDomainAt(idx) == target {
-			return true
+			return suffix, true
 		}
 	}
 
-	return false
+	return "", false
+}
+
+// sortedDomainsBelow reports whether SortedDomain slice data stores a domain strictly
+// below parent (lowercased); malformed data reports true
+func sortedDomainsBelow(sliceData []byte, parent string) bool {
+	if len(sliceData) < 4 {
+		return false
+	}
+	count := int(binary.LittleEndian.Uint32(sliceData[0:4]))
+	if count == 0 {
+		return false
+	}
+	if uint64(len(sliceData)) < 4+(uint64(count)+1)*4 {
+		return true
+	}
+	stringsStart := 4 + (count+1)*4
+	getDomainAt := func(i int) string {
+		off := int(binary.LittleEndian.Uint32(sliceData[4+i*4 : 4+i*4+4]))
+		nextOff := int(binary.LittleEndian.Uint32(sliceData[4+(i+1)*4 : 4+(i+1)*4+4]))
+		if off > nextOff || nextOff > len(sliceData)-stringsStart {
+			return ""
+		}
+		return string(sliceData[stringsStart+off : stringsStart+nextOff])
+	}
+
+	// Domains below "example.com" are stored as "moc.elpmaxe.<...>": the first stored
+	// string after the prefix itself has it if any does
+	prefix := reverseString("." + parent)
+	idx := sort.Search(count, func(j int) bool {
+		return getDomainAt(j) > prefix
+	})
+	return idx < count && strings.HasPrefix(getDomainAt(idx), prefix)
 }
 
 // matchCidrV4InSlice matches an IPv4 address within a single CIDR v4 slice (zero-copy)
 func (r *MmapReader) matchCidrV4InSlice(entry *SliceEntry, ip uint32) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 8 bytes: network (4) + prefix_len (1) + padding (3)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*8
-		if entryOffset+8 > len(r.data) {
+		entryOffset := i * 8
+		if entryOffset+8 > len(data) {
 			break
 		}
 
 		// Zero-copy: directly access mmap region
 		// Network is in big-endian (network byte order)
-		network := uint32(r.data[entryOffset])<<24 |
-			uint32(r.data[entryOffset+1])<<16 |
-			uint32(r.data[entryOffset+2])<<8 |
-			uint32(r.data[entryOffset+3])
+		network := uint32(data[entryOffset])<<24 |
+			uint32(data[entryOffset+1])<<16 |
+			uint32(data[entryOffset+2])<<8 |
+			uint32(data[entryOffset+3])
 
-		prefixLen := r.data[entryOffset+4]
+		prefixLen := data[entryOffset+4]
 
 		// Calculate mask
 		var mask uint32
@@ -343,20 +689,20 @@ func (r *MmapReader) matchCidrV4InSlice(entry *SliceEntry, ip uint32) bool {
 
 // matchCidrV6InSlice matches an IPv6 address within a single CIDR v6 slice (zero-copy)
 func (r *MmapReader) matchCidrV6InSlice(entry *SliceEntry, ip [16]byte) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 24 bytes: network (16) + prefix_len (1) + padding (7)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*24
-		if entryOffset+24 > len(r.data) {
+		entryOffset := i * 24
+		if entryOffset+24 > len(data) {
 			break
 		}
 
 		// Zero-copy: directly access mmap region
 		var network [16]byte
-		copy(network[:], r.data[entryOffset:entryOffset+16])
-		prefixLen := r.data[entryOffset+16]
+		copy(network[:], data[entryOffset:entryOffset+16])
+		prefixLen := data[entryOffset+16]
 
 		if matchesIPv6CIDR(&ip, &network, prefixLen) {
 			return true
@@ -368,18 +714,18 @@ func (r *MmapReader) matchCidrV6InSlice(entry *SliceEntry, ip [16]byte) bool {
 
 // matchGeoIPInSlice matches a country code within a single GeoIP slice (zero-copy)
 func (r *MmapReader) matchGeoIPInSlice(entry *SliceEntry, country []byte) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 4 bytes: country_code (2) + padding (2)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*4
-		if entryOffset+4 > len(r.data) {
+		entryOffset := i * 4
+		if entryOffset+4 > len(data) {
 			break
 		}
 
 		// Zero-copy: directly access mmap region
-		storedCountry := r.data[entryOffset : entryOffset+2]
+		storedCountry := data[entryOffset : entryOffset+2]
 		if len(country) >= 2 && storedCountry[0] == country[0] && storedCountry[1] == country[1] {
 			return true
 		}
@@ -418,12 +764,25 @@ func decompressGzip(gzipPath, outPath string) error {
 	}
 	defer gzReader.Close()
 
-	outFile, err := os.Create(outPath)
+	// Write to a unique temp file + rename: another process sharing the cache
+	// directory may be decompressing the same file, or already mapping outPath
+	outFile, err := os.CreateTemp(filepath.Dir(outPath), filepath.Base(outPath)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
+	tmpPath := outFile.Name()
 
 	_, err = io.Copy(outFile, gzReader)
-	return err
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
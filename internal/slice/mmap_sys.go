@@ -0,0 +1,25 @@
+//go:build !aix
+
+package slice
+
+import (
+	"os"
+
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+// mapFile maps the whole file read-only
+func mapFile(f *os.File) ([]byte, error) {
+	return mmap.Map(f, mmap.RDONLY, 0)
+}
+
+// mapAnon maps size bytes of zeroed anonymous memory
+func mapAnon(size int) ([]byte, error) {
+	return mmap.MapRegion(nil, size, mmap.RDWR, mmap.ANON, 0)
+}
+
+// unmap releases a mapping returned by mapFile or mapAnon
+func unmap(b []byte) error {
+	m := mmap.MMap(b)
+	return m.Unmap()
+}
@@ -0,0 +1,150 @@
+package slice
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Mode selects how a rule file is held in memory
+type Mode uint8
+
+const (
+	// ModeAuto picks ModeInMemory for small files (or without mmap support), else ModeMmap
+	ModeAuto Mode = iota
+	// ModeMmap decompresses to a temp file and memory-maps it (pages shared and evictable)
+	ModeMmap
+	// ModeInMemory decompresses into the Go heap (no temp file, no page faults)
+	ModeInMemory
+)
+
+// AutoInMemoryMaxSize is the largest decompressed file ModeAuto keeps in memory.
+// Below it, lookups on a heap copy are as fast as on a mapping and skip the temp
+// file and page faults; above it, mmap keeps the data out of the Go heap.
+const AutoInMemoryMaxSize = 4 << 20
+
+// autoMemoryFraction caps ModeAuto in-memory files to this fraction of available memory
+const autoMemoryFraction = 16
+
+// String returns the mode name
+func (m Mode) String() string {
+	switch m {
+	case ModeAuto:
+		return "auto"
+	case ModeMmap:
+		return "mmap"
+	case ModeInMemory:
+		return "memory"
+	}
+	return fmt.Sprintf("Mode(%d)", m)
+}
+
+// ResolveMode returns the concrete mode (ModeMmap or ModeInMemory) for a file of
+// the given decompressed size (-1 = unknown). Platforms without mmap always use
+// ModeInMemory (OpenGzip rejects an explicit ModeMmap there); ModeAuto also avoids
+// the heap when memory is scarce.
+func ResolveMode(mode Mode, size int64) Mode {
+	if !mmapSupported {
+		return ModeInMemory
+	}
+	if mode != ModeAuto {
+		return mode
+	}
+	if size < 0 || size > AutoInMemoryMaxSize {
+		return ModeMmap
+	}
+	if avail, ok := availableMemory(); ok && uint64(size) > avail/autoMemoryFraction {
+		return ModeMmap
+	}
+	return ModeInMemory
+}
+
+// OpenGzip opens a gzip-compressed rule file in the given mode. An explicit ModeMmap
+// fails with ErrMmapUnsupported on platforms without mmap (ModeAuto uses ModeInMemory).
+func OpenGzip(gzipPath string, mode Mode) (*MmapReader, error) {
+	if mode == ModeMmap && !mmapSupported {
+		return nil, ErrMmapUnsupported
+	}
+	if ResolveMode(mode, gzipSize(gzipPath)) == ModeInMemory {
+		return NewInMemoryReaderFromGzip(gzipPath)
+	}
+	return NewMmapReaderFromGzip(gzipPath)
+}
+
+// NewInMemoryReader creates a reader holding a copy of an uncompressed file in the Go heap
+func NewInMemoryReader(path string) (*MmapReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return NewInMemoryReaderFromBytes(data)
+}
+
+// NewInMemoryReaderFromGzip decompresses a gzip-compressed file into the Go heap
+func NewInMemoryReaderFromGzip(gzipPath string) (*MmapReader, error) {
+	file, err := os.Open(gzipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+	}
+	defer gzReader.Close()
+
+	data, err := io.ReadAll(gzReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+	}
+	return NewInMemoryReaderFromBytes(data)
+}
+
+// NewInMemoryReaderFromBytes creates a reader over data (uncompressed K2RULEV3),
+// which must not be modified afterwards
+func NewInMemoryReaderFromBytes(data []byte) (*MmapReader, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+	reader := &MmapReader{
+		data:   data,
+		size:   int64(len(data)),
+		memory: true,
+	}
+	if err := reader.parseHeaderAndEntries(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Mode returns ModeInMemory or ModeMmap
+func (r *MmapReader) Mode() Mode {
+	if r.memory {
+		return ModeInMemory
+	}
+	return ModeMmap
+}
+
+// gzipSize returns the decompressed size recorded in a gzip trailer (ISIZE, modulo
+// 4 GiB), or -1 if unknown
+func gzipSize(path string) int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.Size() < 18 {
+		return -1
+	}
+	var trailer [4]byte
+	if _, err := file.ReadAt(trailer[:], stat.Size()-4); err != nil {
+		return -1
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:]))
+}
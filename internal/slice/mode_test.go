@@ -0,0 +1,228 @@
+package slice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildBenchData builds a rule file with n domains, n/4 IPv4 CIDRs and a GeoIP slice.
+func buildBenchData(tb testing.TB, n int, compress bool) []byte {
+	tb.Helper()
+	domains := make([]string, n)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("host%d.example%d.com", i, i%97)
+	}
+	cidrs := make([]CidrV4Entry, n/4)
+	for i := range cidrs {
+		cidrs[i] = CidrV4Entry{Network: uint32(10<<24 | i<<8), PrefixLen: 24}
+	}
+
+	w := NewSliceWriter(1)
+	if compress {
+		w.EnableSliceCompression(1024)
+	}
+	if err := w.AddDomainSlice(domains, 2); err != nil {
+		tb.Fatalf("AddDomainSlice error: %v", err)
+	}
+	if err := w.AddCidrV4Slice(cidrs, 0); err != nil {
+		tb.Fatalf("AddCidrV4Slice error: %v", err)
+	}
+	if err := w.AddGeoIPSlice([]string{"CN", "IR"}, 0); err != nil {
+		tb.Fatalf("AddGeoIPSlice error: %v", err)
+	}
+	data, err := w.Build()
+	if err != nil {
+		tb.Fatalf("Build error: %v", err)
+	}
+	return data
+}
+
+// writeGzipFile writes data gzip-compressed to dir/name and returns the path.
+func writeGzipFile(tb testing.TB, dir, name string, data []byte) string {
+	tb.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(data)
+	if err := gw.Close(); err != nil {
+		tb.Fatalf("gzip.Close error: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		tb.Fatalf("WriteFile error: %v", err)
+	}
+	return path
+}
+
+func TestResolveMode(t *testing.T) {
+	if got := ResolveMode(ModeMmap, 1); got != ModeMmap {
+		t.Errorf("ResolveMode(Mmap, 1) = %v, want mmap", got)
+	}
+	if got := ResolveMode(ModeInMemory, 1<<30); got != ModeInMemory {
+		t.Errorf("ResolveMode(InMemory, 1GiB) = %v, want memory", got)
+	}
+	if got := ResolveMode(ModeAuto, 64<<10); got != ModeInMemory {
+		t.Errorf("ResolveMode(Auto, 64KiB) = %v, want memory", got)
+	}
+	if got := ResolveMode(ModeAuto, AutoInMemoryMaxSize+1); got != ModeMmap {
+		t.Errorf("ResolveMode(Auto, >max) = %v, want mmap", got)
+	}
+	if got := ResolveMode(ModeAuto, -1); got != ModeMmap {
+		t.Errorf("ResolveMode(Auto, unknown) = %v, want mmap", got)
+	}
+}
+
+func TestInMemoryReader(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			data := buildBenchData(t, 2000, compress)
+			path := writeGzipFile(t, t.TempDir(), "rules.k2r.gz", data)
+
+			mem, err := OpenGzip(path, ModeInMemory)
+			if err != nil {
+				t.Fatalf("OpenGzip(InMemory) error: %v", err)
+			}
+			defer mem.Close()
+			mapped, err := OpenGzip(path, ModeMmap)
+			if err != nil {
+				t.Fatalf("OpenGzip(Mmap) error: %v", err)
+			}
+			defer mapped.Close()
+
+			if mem.Mode() != ModeInMemory || mapped.Mode() != ModeMmap {
+				t.Fatalf("modes = %v, %v", mem.Mode(), mapped.Mode())
+			}
+			if gzipSize(path) != int64(len(data)) {
+				t.Errorf("gzipSize = %d, want %d", gzipSize(path), len(data))
+			}
+
+			for _, domain := range []string{"host5.example5.com", "www.host1999.example59.com", "unknown.com"} {
+				if a, b := mem.MatchDomain(domain), mapped.MatchDomain(domain); (a == nil) != (b == nil) || (a != nil && *a != *b) {
+					t.Errorf("MatchDomain(%s) differs: %v vs %v", domain, a, b)
+				}
+			}
+			for _, ip := range []string{"10.0.3.1", "10.9.0.1", "8.8.8.8"} {
+				if a, b := mem.MatchIP(net.ParseIP(ip)), mapped.MatchIP(net.ParseIP(ip)); (a == nil) != (b == nil) || (a != nil && *a != *b) {
+					t.Errorf("MatchIP(%s) differs: %v vs %v", ip, a, b)
+				}
+			}
+			if got := mem.MatchGeoIP("IR"); got == nil || *got != 0 {
+				t.Errorf("MatchGeoIP(IR) = %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestCachedMmapReader_SetMode(t *testing.T) {
+	data := buildBenchData(t, 100, false)
+	path := writeGzipFile(t, t.TempDir(), "rules.k2r.gz", data)
+
+	c := NewCachedMmapReader()
+	c.SetMode(ModeMmap)
+	if err := c.Load(path); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if got := c.Get().Mode(); got != ModeMmap {
+		t.Errorf("Mode after SetMode(Mmap) = %v", got)
+	}
+
+	c = NewCachedMmapReader()
+	if err := c.LoadFromBytes(data); err != nil {
+		t.Fatalf("LoadFromBytes error: %v", err)
+	}
+	if got := c.Get().Mode(); got != ModeInMemory {
+		t.Errorf("Mode of small file with ModeAuto = %v, want memory", got)
+	}
+	if got := c.MatchDomain("host7.example7.com"); got == nil || *got != 2 {
+		t.Errorf("MatchDomain = %v, want 2", got)
+	}
+	c.Close()
+}
+
+// benchSizes are the domain counts benchmarked (about 30 KiB to 3 MiB decompressed)
+var benchSizes = []int{1000, 10000, 100000}
+
+// benchReaders opens one reader per implementation for a file of n domains.
+func benchReaders(b *testing.B, n int) map[string]interface {
+	MatchDomain(string) *uint8
+	MatchIP(net.IP) *uint8
+} {
+	b.Helper()
+	data := buildBenchData(b, n, false)
+	path := writeGzipFile(b, b.TempDir(), "rules.k2r.gz", data)
+
+	sr, err := NewSliceReaderFromBytes(data)
+	if err != nil {
+		b.Fatalf("NewSliceReaderFromBytes error: %v", err)
+	}
+	mapped, err := OpenGzip(path, ModeMmap)
+	if err != nil {
+		b.Fatalf("OpenGzip(Mmap) error: %v", err)
+	}
+	b.Cleanup(func() { mapped.Close() })
+	mem, err := OpenGzip(path, ModeInMemory)
+	if err != nil {
+		b.Fatalf("OpenGzip(InMemory) error: %v", err)
+	}
+	return map[string]interface {
+		MatchDomain(string) *uint8
+		MatchIP(net.IP) *uint8
+	}{"SliceReader": sr, "Mmap": mapped, "InMemory": mem}
+}
+
+func BenchmarkMatchDomain(b *testing.B) {
+	for _, n := range benchSizes {
+		for _, name := range []string{"SliceReader", "Mmap", "InMemory"} {
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				r := benchReaders(b, n)[name]
+				domains := []string{
+					fmt.Sprintf("host%d.example%d.com", n/2, (n/2)%97),
+					"www.unknown-domain.net",
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r.MatchDomain(domains[i%len(domains)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMatchIP(b *testing.B) {
+	ips := []net.IP{net.ParseIP("10.0.7.1"), net.ParseIP("8.8.8.8")}
+	for _, n := range benchSizes {
+		for _, name := range []string{"SliceReader", "Mmap", "InMemory"} {
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				r := benchReaders(b, n)[name]
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r.MatchIP(ips[i%len(ips)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	for _, n := range benchSizes {
+		data := buildBenchData(b, n, false)
+		dir := b.TempDir()
+		path := writeGzipFile(b, dir, "rules.k2r.gz", data)
+		for _, mode := range []Mode{ModeMmap, ModeInMemory} {
+			b.Run(fmt.Sprintf("%v/%d", mode, n), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					r, err := OpenGzip(path, mode)
+					if err != nil {
+						b.Fatalf("OpenGzip error: %v", err)
+					}
+					r.Close()
+				}
+			})
+		}
+	}
+}
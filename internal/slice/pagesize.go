@@ -0,0 +1,25 @@
+package slice
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// pageSize is the memory page size: 4 KiB on most platforms, 16 KiB on iOS and
+// Apple silicon macOS, up to 64 KiB on some arm64 Linux kernels
+var pageSize = os.Getpagesize()
+
+// ErrMmapUnsupported is returned when ModeMmap is requested on a platform
+// without memory-mapped files
+var ErrMmapUnsupported = errors.New("mmap is not supported on " + runtime.GOOS)
+
+// PageSize returns the memory page size mappings are made of
+func PageSize() int {
+	return pageSize
+}
+
+// mappedSize returns the memory a mapping of n bytes occupies (whole pages)
+func mappedSize(n int) int {
+	return (n + pageSize - 1) / pageSize * pageSize
+}
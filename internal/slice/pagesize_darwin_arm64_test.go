@@ -0,0 +1,54 @@
+//go:build darwin && arm64
+
+package slice
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPageSize16K verifies the mmap layer sees the 16 KiB pages of iOS and Apple
+// silicon macOS.
+func TestPageSize16K(t *testing.T) {
+	if PageSize() != 16384 {
+		t.Fatalf("PageSize() = %d, want 16384", PageSize())
+	}
+}
+
+// TestMmapReader16KPages verifies a file spanning several 16 KiB pages maps, touches
+// one page per 16 KiB and matches domains on every page, and that compressed slices
+// that would fit a 4 KiB page are still kept out of a mapping.
+func TestMmapReader16KPages(t *testing.T) {
+	domains := make([]string, 0, 4000)
+	for i := 0; i < cap(domains); i++ {
+		domains = append(domains, fmt.Sprintf("host-%d.example.com", i))
+	}
+	w := NewSliceWriter(0)
+	w.AddDomainSlice(domains, 1)
+	data := buildData(t, w)
+	mr := newMmapReaderFromGzip(t, data)
+
+	if pages := mr.Touch(); pages != (len(data)+16383)/16384 {
+		t.Errorf("Touch() = %d pages for %d bytes", pages, len(data))
+	}
+	for _, domain := range []string{"host-0.example.com", "host-2000.example.com", "host-3999.example.com"} {
+		if got := mr.MatchDomain(domain); got == nil || *got != 1 {
+			t.Errorf("MatchDomain(%s) = %v, want 1", domain, got)
+		}
+	}
+
+	small := make([]string, 0, 400) // ~8 KiB decompressed
+	for i := 0; i < cap(small); i++ {
+		small = append(small, fmt.Sprintf("h-%d.example.com", i))
+	}
+	w = NewSliceWriter(0)
+	w.AddDomainSlice(small, 1)
+	w.EnableSliceCompression(1)
+	mr = newMmapReaderFromGzip(t, buildData(t, w))
+	if err := mr.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if s := mr.inflated[mr.entries[0]]; s == nil || !s.heap {
+		t.Error("8 KiB slice was mapped into a 16 KiB page")
+	}
+}
@@ -0,0 +1,98 @@
+package slice
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMmapPageBoundaries verifies files ending just before, on and just after a
+// page boundary map and match their last domain.
+func TestMmapPageBoundaries(t *testing.T) {
+	dir := t.TempDir()
+	var domains []string
+	tested := 0
+	for i := 0; tested < 3 || len(domains) < 2*pageSize/8; i++ {
+		domains = append(domains, fmt.Sprintf("h%d.example.com", i))
+		w := NewSliceWriter(0)
+		w.AddDomainSlice(domains, 1)
+		data := buildData(t, w)
+		if len(data) < pageSize-32 || len(data) > pageSize+32 {
+			continue
+		}
+		tested++
+
+		path := filepath.Join(dir, fmt.Sprintf("rules-%d.k2r", len(data)))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewMmapReader(path)
+		if err != nil {
+			t.Fatalf("NewMmapReader(%d bytes) error: %v", len(data), err)
+		}
+		last := domains[len(domains)-1]
+		if got := r.MatchDomain(last); got == nil || *got != 1 {
+			t.Errorf("%d bytes: MatchDomain(%s) = %v, want 1", len(data), last, got)
+		}
+		if pages, want := r.Touch(), mappedSize(len(data))/pageSize; pages != want {
+			t.Errorf("%d bytes: Touch() = %d pages, want %d", len(data), pages, want)
+		}
+		r.Close()
+	}
+	if tested < 3 {
+		t.Fatalf("only %d file sizes near the page size %d", tested, pageSize)
+	}
+}
+
+// TestInflatedSlicePlacement verifies compressed slices smaller than a page are
+// decompressed into the heap instead of a mostly empty mapping.
+func TestInflatedSlicePlacement(t *testing.T) {
+	large := make([]string, 0, pageSize/4)
+	for i := 0; i < cap(large); i++ {
+		large = append(large, fmt.Sprintf("host-%d.example.com", i))
+	}
+	names := make([]string, 0, 20)
+	for i := 0; i < cap(names); i++ {
+		names = append(names, fmt.Sprintf("small-%d.example.com", i))
+	}
+	w := NewSliceWriter(0)
+	w.AddDomainSlice(names, 1)
+	w.AddDomainSlice(large, 2)
+	w.EnableSliceCompression(1)
+	mr := newMmapReaderFromGzip(t, buildData(t, w))
+
+	if err := mr.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	small, big := mr.inflated[mr.entries[0]], mr.inflated[mr.entries[1]]
+	if small == nil || big == nil {
+		t.Fatal("slices were not compressed")
+	}
+	if !small.heap {
+		t.Error("slice smaller than a page was mapped")
+	}
+	if big.heap != !mmapSupported {
+		t.Errorf("slice of %d bytes: heap = %v", len(big.data), big.heap)
+	}
+	if got := mr.MatchDomain("small-3.example.com"); got == nil || *got != 1 {
+		t.Errorf("MatchDomain(small-3.example.com) = %v, want 1", got)
+	}
+	if got := mr.MatchDomain("host-7.example.com"); got == nil || *got != 2 {
+		t.Errorf("MatchDomain(host-7.example.com) = %v, want 2", got)
+	}
+}
+
+func TestMappedSize(t *testing.T) {
+	tests := []struct{ n, want int }{
+		{0, 0},
+		{1, pageSize},
+		{pageSize, pageSize},
+		{pageSize + 1, 2 * pageSize},
+	}
+	for _, tt := range tests {
+		if got := mappedSize(tt.n); got != tt.want {
+			t.Errorf("mappedSize(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package slice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"sort"
+)
+
+// IP range slice data layouts (SliceTypeRangeV4, SliceTypeRangeV6): inclusive
+// [start, end] pairs in network byte order, sorted by start and merged so that
+// ranges neither overlap nor touch. A lookup binary-searches the last range
+// starting at or below the address.
+//
+//	RangeV4 entry (8 bytes):  start (4) + end (4)
+//	RangeV6 entry (32 bytes): start (16) + end (16)
+
+// IPRangeV4Entry is an inclusive IPv4 address range
+type IPRangeV4Entry struct {
+	Start uint32 // First address in host byte order
+	End   uint32 // Last address in host byte order
+}
+
+// IPRangeV6Entry is an inclusive IPv6 address range
+type IPRangeV6Entry struct {
+	Start [16]byte // First address
+	End   [16]byte // Last address
+}
+
+// encodeRangesV4 sorts and merges ranges and encodes them as RangeV4 slice data
+func encodeRangesV4(ranges []IPRangeV4Entry) ([]byte, uint32, error) {
+	sorted := make([]IPRangeV4Entry, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Start > r.End {
+			return nil, 0, fmt.Errorf("invalid IPv4 range: start after end")
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	merged := sorted[:0]
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && (merged[n-1].End == ^uint32(0) || r.Start <= merged[n-1].End+1) {
+			if r.End > merged[n-1].End {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	buf := make([]byte, len(merged)*8)
+	for i, r := range merged {
+		binary.BigEndian.PutUint32(buf[i*8:], r.Start)
+		binary.BigEndian.PutUint32(buf[i*8+4:], r.End)
+	}
+	return buf, uint32(len(merged)), nil
+}
+
+// encodeRangesV6 sorts and merges ranges and encodes them as RangeV6 slice data
+func encodeRangesV6(ranges []IPRangeV6Entry) ([]byte, uint32, error) {
+	sorted := make([]IPRangeV6Entry, 0, len(ranges))
+	for _, r := range ranges {
+		if bytes.Compare(r.Start[:], r.End[:]) > 0 {
+			return nil, 0, fmt.Errorf("invalid IPv6 range: start after end")
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Start[:], sorted[j].Start[:]) < 0 })
+
+	merged := sorted[:0]
+	for _, r := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			next, overflow := nextIPv6(last.End)
+			if overflow || bytes.Compare(r.Start[:], next[:]) <= 0 {
+				if bytes.Compare(r.End[:], last.End[:]) > 0 {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	buf := make([]byte, len(merged)*32)
+	for i, r := range merged {
+		copy(buf[i*32:], r.Start[:])
+		copy(buf[i*32+16:], r.End[:])
+	}
+	return buf, uint32(len(merged)), nil
+}
+
+// nextIPv6 returns ip+1 (overflow = ip was the last address)
+func nextIPv6(ip [16]byte) ([16]byte, bool) {
+	for i := 15; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return ip, false
+		}
+	}
+	return ip, true
+}
+
+// matchRangeV4 reports whether ip falls in a range of RangeV4 slice data
+func matchRangeV4(data []byte, count int, ip uint32) bool {
+	count = min(count, len(data)/8)
+	// First range starting above ip; the one before it is the only candidate
+	i := sort.Search(count, func(i int) bool {
+		return binary.BigEndian.Uint32(data[i*8:]) > ip
+	})
+	return i > 0 && ip <= binary.BigEndian.Uint32(data[(i-1)*8+4:])
+}
+
+// matchRangeV6 reports whether ip falls in a range of RangeV6 slice data
+func matchRangeV6(data []byte, count int, ip [16]byte) bool {
+	count = min(count, len(data)/32)
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(data[i*32:i*32+16], ip[:]) > 0
+	})
+	return i > 0 && bytes.Compare(ip[:], data[(i-1)*32+16:(i-1)*32+32]) <= 0
+}
+
+// rangePrefixes appends the minimal set of CIDR prefixes covering [start, end]
+// (both of the same address family) to prefixes
+func rangePrefixes(prefixes []netip.Prefix, start, end netip.Addr) []netip.Prefix {
+	for start.IsValid() && start.Compare(end) <= 0 {
+		// Largest aligned block at start that doesn't extend past end
+		bitLen := start.BitLen()
+		size := bitLen - trailingZeroBits(start)
+		for ; size < bitLen; size++ {
+			last := netip.PrefixFrom(start, size).Masked()
+			if lastAddr(last).Compare(end) <= 0 {
+				break
+			}
+		}
+		prefix := netip.PrefixFrom(start, size)
+		prefixes = append(prefixes, prefix)
+		start = lastAddr(prefix).Next() // invalid after the last address
+	}
+	return prefixes
+}
+
+// trailingZeroBits returns the number of trailing zero bits of addr
+func trailingZeroBits(addr netip.Addr) int {
+	b := addr.As16()
+	n := 0
+	for i := 15; i >= 0; i-- {
+		if b[i] != 0 {
+			n += bits.TrailingZeros8(b[i])
+			break
+		}
+		n += 8
+	}
+	return min(n, addr.BitLen())
+}
+
+// lastAddr returns the last address of prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr()
+	b := addr.As16()
+	hostBits := addr.BitLen() - prefix.Bits()
+	for i := 15; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		b[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	if addr.Is4() {
+		return netip.AddrFrom4([4]byte(b[12:]))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// decodeRangePrefixes returns the CIDR prefixes covering all ranges of a RangeV4
+// or RangeV6 slice
+func decodeRangePrefixes(t SliceType, data []byte, count int) []netip.Prefix {
+	var prefixes []netip.Prefix
+	switch t {
+	case SliceTypeRangeV4:
+		for i := 0; i < count && (i+1)*8 <= len(data); i++ {
+			start := netip.AddrFrom4([4]byte(data[i*8 : i*8+4]))
+			end := netip.AddrFrom4([4]byte(data[i*8+4 : i*8+8]))
+			prefixes = rangePrefixes(prefixes, start, end)
+		}
+	case SliceTypeRangeV6:
+		for i := 0; i < count && (i+1)*32 <= len(data); i++ {
+			start := netip.AddrFrom16([16]byte(data[i*32 : i*32+16]))
+			end := netip.AddrFrom16([16]byte(data[i*32+16 : i*32+32]))
+			prefixes = rangePrefixes(prefixes, start, end)
+		}
+	}
+	return prefixes
+}
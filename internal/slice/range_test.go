@@ -0,0 +1,107 @@
+package slice
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func v4(s string) uint32 {
+	return ipToUint32(net.ParseIP(s).To4())
+}
+
+func v6(s string) [16]byte {
+	return netip.MustParseAddr(s).As16()
+}
+
+// TestIPRangeSlices verifies RangeV4/RangeV6 matching in both readers,
+// including merged, adjacent and edge-of-space ranges.
+func TestIPRangeSlices(t *testing.T) {
+	w := NewSliceWriter(0)
+	if err := w.AddIPRangeV4Slice([]IPRangeV4Entry{
+		{Start: v4("1.2.3.7"), End: v4("1.2.4.19")},
+		{Start: v4("10.0.0.0"), End: v4("10.0.0.9")},
+		{Start: v4("10.0.0.10"), End: v4("10.0.0.20")}, // adjacent: merged
+		{Start: v4("1.2.3.100"), End: v4("1.2.3.200")}, // contained: merged
+		{Start: v4("255.255.255.250"), End: v4("255.255.255.255")},
+	}, 1); err != nil {
+		t.Fatalf("AddIPRangeV4Slice() error: %v", err)
+	}
+	if err := w.AddIPRangeV6Slice([]IPRangeV6Entry{
+		{Start: v6("2001:db8::5"), End: v6("2001:db8::1:0")},
+		{Start: v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fff0"), End: v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")},
+	}, 2); err != nil {
+		t.Fatalf("AddIPRangeV6Slice() error: %v", err)
+	}
+	data := buildData(t, w)
+
+	entry, _ := ParseEntry(data[HeaderSize:])
+	if entry.GetType() != SliceTypeRangeV4 || entry.Flags&SliceFlagRequired == 0 || entry.Count != 3 {
+		t.Errorf("entry = %s flags %#x count %d, want required RangeV4 with 3 merged ranges", entry.GetType(), entry.Flags, entry.Count)
+	}
+
+	tests := []struct {
+		ip   string
+		want uint8 // 0 = no match
+	}{
+		{"1.2.3.6", 0},
+		{"1.2.3.7", 1},
+		{"1.2.3.255", 1},
+		{"1.2.4.19", 1},
+		{"1.2.4.20", 0},
+		{"10.0.0.15", 1},
+		{"10.0.0.21", 0},
+		{"255.255.255.255", 1},
+		{"0.0.0.0", 0},
+		{"2001:db8::4", 0},
+		{"2001:db8::5", 2},
+		{"2001:db8::ffff", 2},
+		{"2001:db8::1:0", 2},
+		{"2001:db8::1:1", 0},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", 2},
+	}
+	readers := map[string]interface{ MatchIP(net.IP) *uint8 }{
+		"SliceReader": newSliceReader(t, data),
+		"MmapReader":  newMmapReaderFromGzip(t, data),
+	}
+	for name, r := range readers {
+		for _, tt := range tests {
+			got := r.MatchIP(net.ParseIP(tt.ip))
+			if (got == nil) != (tt.want == 0) || (got != nil && *got != tt.want) {
+				t.Errorf("%s.MatchIP(%s) = %v, want %d", name, tt.ip, got, tt.want)
+			}
+		}
+	}
+
+	if err := NewSliceWriter(0).AddIPRangeV4Slice([]IPRangeV4Entry{{Start: 2, End: 1}}, 1); err == nil {
+		t.Error("AddIPRangeV4Slice() accepted a range with start after end")
+	}
+}
+
+// TestIPRangeCIDRs verifies ranges are reported as their covering prefixes.
+func TestIPRangeCIDRs(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddIPRangeV4Slice([]IPRangeV4Entry{{Start: v4("1.2.3.7"), End: v4("1.2.4.19")}}, 1)
+	w.AddIPRangeV6Slice([]IPRangeV6Entry{{Start: v6("::"), End: v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")}}, 1)
+	mr := newMmapReaderFromGzip(t, buildData(t, w))
+
+	want := []string{
+		"1.2.3.7/32", "1.2.3.8/29", "1.2.3.16/28", "1.2.3.32/27", "1.2.3.64/26", "1.2.3.128/25",
+		"1.2.4.0/28", "1.2.4.16/30",
+		"::/0",
+	}
+	got := mr.CIDRs(1)
+	if len(got) != len(want) {
+		t.Fatalf("CIDRs(1) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("CIDRs(1)[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	rules := mr.IPRules()
+	if len(rules) != len(want) || rules[0].Target != 1 || rules[0].Prefix.String() != want[0] {
+		t.Errorf("IPRules() = %v, want %d rules with target 1", rules, len(want))
+	}
+}
@@ -14,50 +14,50 @@ import (
 
 // SliceReader reads and queries K2Rule slice-based rule files
 type SliceReader struct {
-	data    []byte
-	header  *SliceHeader
-	entries []*SliceEntry
+	data     []byte
+	header   *SliceHeader
+	entries  []*SliceEntry
+	inflated map[*SliceEntry][]byte // Decompressed data of compressed slices
 }
 
 // NewSliceReaderFromBytes loads a SliceReader from raw bytes
 func NewSliceReaderFromBytes(data []byte) (*SliceReader, error) {
-	if len(data) < HeaderSize {
-		return nil, fmt.Errorf("insufficient data for header: got %d bytes, need %d", len(data), HeaderSize)
-	}
-
-	// Parse header
-	header, err := ParseHeader(data)
+	header, entries, err := parseIndex(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse header: %w", err)
-	}
-
-	if err := header.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid header: %w", err)
+		return nil, err
 	}
 
-	// Parse slice entries
-	sliceCount := int(header.SliceCount)
-	entriesEnd := HeaderSize + sliceCount*EntrySize
-
-	if len(data) < entriesEnd {
-		return nil, fmt.Errorf("slice index truncated: expected %d bytes, got %d", entriesEnd, len(data))
+	reader := &SliceReader{
+		data:    data,
+		header:  header,
+		entries: entries,
 	}
 
-	entries := make([]*SliceEntry, 0, sliceCount)
-	for i := 0; i < sliceCount; i++ {
-		offset := HeaderSize + i*EntrySize
-		entry, err := ParseEntry(data[offset:])
+	// Decompress compressed slices up front (the whole file is on the heap anyway)
+	total := 0
+	for i, entry := range entries {
+		if !entry.IsCompressed() {
+			continue
+		}
+		raw := reader.rawSliceData(entry)
+		size, err := inflatedSize(raw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse entry %d: %w", i, err)
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if total += size; total > maxInflatedSize {
+			return nil, fmt.Errorf("entry %d: compressed slices too large: over %d bytes", i, maxInflatedSize)
+		}
+		buf := make([]byte, size)
+		if err := inflateSlice(raw, buf); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
 		}
-		entries = append(entries, entry)
+		if reader.inflated == nil {
+			reader.inflated = make(map[*SliceEntry][]byte)
+		}
+		reader.inflated[entry] = buf
 	}
 
-	return &SliceReader{
-		data:    data,
-		header:  header,
-		entries: entries,
-	}, nil
+	return reader, nil
 }
 
 // NewSliceReaderFromGzip loads a SliceReader from gzip-compressed bytes
@@ -96,34 +96,117 @@ func (r *SliceReader) Fallback() uint8 {
 	return r.header.Fallback()
 }
 
+// HasFeature reports whether the file declares header feature f
+func (r *SliceReader) HasFeature(f Feature) bool {
+	return r.header.HasFeature(f)
+}
+
 // SliceCount returns the number of slices
 func (r *SliceReader) SliceCount() int {
 	return len(r.entries)
 }
 
+// DomainCount returns the number of domain rules in all domain slices (see MmapReader.DomainCount)
+func (r *SliceReader) DomainCount() int {
+	return domainCount(r.entries)
+}
+
+// rawSliceData returns the stored (possibly compressed) data of a slice
+func (r *SliceReader) rawSliceData(entry *SliceEntry) []byte {
+	offset, size := uint64(entry.Offset), uint64(entry.Size)
+	if offset+size > uint64(len(r.data)) {
+		return nil
+	}
+	return r.data[offset : offset+size]
+}
+
+// getSliceData returns the (decompressed) data of a slice
+func (r *SliceReader) getSliceData(entry *SliceEntry) []byte {
+	if entry.IsCompressed() {
+		return r.inflated[entry]
+	}
+	return r.rawSliceData(entry)
+}
+
 // MatchDomain matches a domain against all domain slices
 // Returns the target of the first matching slice, or nil if no match
 func (r *SliceReader) MatchDomain(domain string) *uint8 {
-	normalized := strings.ToLower(domain)
+	if _, target, ok := r.matchDomainEntry(strings.ToLower(domain)); ok {
+		return &target
+	}
+	return nil
+}
+
+// MatchDomainHints is like MatchDomain, also returning the DNS hints of the slice
+// that matched (always 0 for files without FeatureDNSHints)
+func (r *SliceReader) MatchDomainHints(domain string) (target uint8, hints DNSHint, ok bool) {
+	entry, target, ok := r.matchDomainEntry(strings.ToLower(domain))
+	if !ok {
+		return 0, 0, false
+	}
+	return target, entry.hints, true
+}
 
+// matchDomainEntry returns the first domain slice matching a lowercased domain with
+// its target
+func (r *SliceReader) matchDomainEntry(normalized string) (*SliceEntry, uint8, bool) {
 	for _, entry := range r.entries {
-		if entry.GetType() != SliceTypeSortedDomain {
+		if entry.expired() {
 			continue
 		}
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			if r.matchDomainInSlice(entry, normalized) {
+				return entry, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTrie:
+			if matchDomainTrie(r.getSliceData(entry), normalized) {
+				return entry, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTargets:
+			if target, ok := matchDomainTargetTrie(r.getSliceData(entry), normalized); ok {
+				return entry, target, true
+			}
+		}
+	}
+	return nil, 0, false
+}
 
-		if r.matchDomainInSlice(entry, normalized) {
-			target := entry.GetTarget()
-			return &target
+// MatchDomainSuffix is like MatchDomain, also returning the stored domain that
+// matched (see MmapReader.MatchDomainSuffix)
+func (r *SliceReader) MatchDomainSuffix(domain string) (suffix string, target uint8, ok bool) {
+	normalized := strings.ToLower(domain)
+
+	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
+		switch entry.GetType() {
+		case SliceTypeSortedDomain:
+			if suffix, ok := r.domainSuffixInSlice(entry, normalized); ok {
+				return suffix, entry.GetTarget(), true
+			}
+		case SliceTypeDomainTrie:
+			if start, ok := domainTrieSuffix(r.getSliceData(entry), normalized); ok {
+				return normalized[start:], entry.GetTarget(), true
+			}
+		case SliceTypeDomainTargets:
+			if start, target, ok := domainTargetTrieSuffix(r.getSliceData(entry), normalized); ok {
+				return normalized[start:], target, true
+			}
 		}
 	}
 
-	return nil
+	return "", 0, false
 }
 
 // MatchIP matches an IP address against all IP slices
 // Returns the target of the first matching slice, or nil if no match
 func (r *SliceReader) MatchIP(ip net.IP) *uint8 {
 	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
 		switch entry.GetType() {
 		case SliceTypeCidrV4:
 			if ip4 := ip.To4(); ip4 != nil {
@@ -140,6 +223,16 @@ func (r *SliceReader) MatchIP(ip net.IP) *uint8 {
 					return &target
 				}
 			}
+		case SliceTypeRangeV4:
+			if ip4 := ip.To4(); ip4 != nil && matchRangeV4(r.getSliceData(entry), int(entry.Count), ipToUint32(ip4)) {
+				target := entry.GetTarget()
+				return &target
+			}
+		case SliceTypeRangeV6:
+			if ip16 := ip.To16(); ip16 != nil && matchRangeV6(r.getSliceData(entry), int(entry.Count), [16]byte(ip16)) {
+				target := entry.GetTarget()
+				return &target
+			}
 		}
 	}
 
@@ -153,6 +246,9 @@ func (r *SliceReader) MatchGeoIP(country string) *uint8 {
 	countryBytes := []byte(countryUpper)
 
 	for _, entry := range r.entries {
+		if entry.expired() {
+			continue
+		}
 		if entry.GetType() != SliceTypeGeoIP {
 			continue
 		}
@@ -176,27 +272,30 @@ func (r *SliceReader) MatchGeoIP(country string) *uint8 {
 //	sentinel   (4 bytes LE)   total strings length
 //	strings area (variable)   reversed, lowercased, dot-prefixed domains sorted lexicographically
 func (r *SliceReader) matchDomainInSlice(entry *SliceEntry, domain string) bool {
-	offset := int(entry.Offset)
-	size := int(entry.Size)
+	_, ok := r.domainSuffixInSlice(entry, domain)
+	return ok
+}
 
-	if size < 4 || offset+size > len(r.data) {
-		return false
+// domainSuffixInSlice is like matchDomainInSlice, also returning the matched suffix
+// of domain (the longest one stored)
+func (r *SliceReader) domainSuffixInSlice(entry *SliceEntry, domain string) (string, bool) {
+	sliceData := r.getSliceData(entry)
+	if len(sliceData) < 4 {
+		return "", false
 	}
 
-	sliceData := r.data[offset : offset+size]
-
 	// Read count (4 bytes LE)
 	count := int(binary.LittleEndian.Uint32(sliceData[0:4]))
 	if count == 0 {
-		return false
+		return "", false
 	}
 
 	// Validate we have enough data for the offsets array + sentinel
 	// offsets region: (count+1) * 4 bytes, starting at byte 4
-	offsetsEnd := 4 + (count+1)*4
-	if len(sliceData) < offsetsEnd {
-		return false
+	if uint64(len(sliceData)) < 4+(uint64(count)+1)*4 {
+		return "", false
 	}
+	offsetsEnd := 4 + (count+1)*4
 
 	// strings area starts right after offsets+sentinel
 	stringsStart := offsetsEnd
@@ -205,7 +304,7 @@ func (r *SliceReader) matchDomainInSlice(entry *SliceEntry, domain string) bool
 	getDomainAt := func(i int) string {
 		off := int(binary.LittleEndian.Uint32(sliceData[4+i*4 : 4+i*4+4]))
 		nextOff := int(binary.LittleEndian.Uint32(sliceData[4+(i+1)*4 : 4+(i+1)*4+4]))
-		if stringsStart+nextOff > len(sliceData) || off > nextOff {
+		if off > nextOff || nextOff > len(sliceData)-stringsStart {
 			return ""
 		}
 		return string(sliceData[stringsStart+off : stringsStart+nextOff])
@@ -229,32 +328,32 @@ func (r *SliceReader) matchDomainInSlice(entry *SliceEntry, domain string) bool
 			return getDomainAt(j) >= target
 		})
 		if idx < count && getDomainAt(idx) == target {
-			return true
+			return suffix, true
 		}
 	}
 
-	return false
+	return "", false
 }
 
 // matchCidrV4InSlice matches an IPv4 address within a single CIDR v4 slice
 func (r *SliceReader) matchCidrV4InSlice(entry *SliceEntry, ip uint32) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 8 bytes: network (4) + prefix_len (1) + padding (3)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*8
-		if entryOffset+8 > len(r.data) {
+		entryOffset := i * 8
+		if entryOffset+8 > len(data) {
 			break
 		}
 
 		// Network is in big-endian (network byte order)
-		network := uint32(r.data[entryOffset])<<24 |
-			uint32(r.data[entryOffset+1])<<16 |
-			uint32(r.data[entryOffset+2])<<8 |
-			uint32(r.data[entryOffset+3])
+		network := uint32(data[entryOffset])<<24 |
+			uint32(data[entryOffset+1])<<16 |
+			uint32(data[entryOffset+2])<<8 |
+			uint32(data[entryOffset+3])
 
-		prefixLen := r.data[entryOffset+4]
+		prefixLen := data[entryOffset+4]
 
 		// Calculate mask
 		var mask uint32
@@ -276,19 +375,19 @@ func (r *SliceReader) matchCidrV4InSlice(entry *SliceEntry, ip uint32) bool {
 
 // matchCidrV6InSlice matches an IPv6 address within a single CIDR v6 slice
 func (r *SliceReader) matchCidrV6InSlice(entry *SliceEntry, ip [16]byte) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 24 bytes: network (16) + prefix_len (1) + padding (7)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*24
-		if entryOffset+24 > len(r.data) {
+		entryOffset := i * 24
+		if entryOffset+24 > len(data) {
 			break
 		}
 
 		var network [16]byte
-		copy(network[:], r.data[entryOffset:entryOffset+16])
-		prefixLen := r.data[entryOffset+16]
+		copy(network[:], data[entryOffset:entryOffset+16])
+		prefixLen := data[entryOffset+16]
 
 		if matchesIPv6CIDR(&ip, &network, prefixLen) {
 			return true
@@ -300,17 +399,17 @@ func (r *SliceReader) matchCidrV6InSlice(entry *SliceEntry, ip [16]byte) bool {
 
 // matchGeoIPInSlice matches a country code within a single GeoIP slice
 func (r *SliceReader) matchGeoIPInSlice(entry *SliceEntry, country []byte) bool {
-	offset := int(entry.Offset)
+	data := r.getSliceData(entry)
 	count := int(entry.Count)
 
 	// Each entry is 4 bytes: country_code (2) + padding (2)
 	for i := 0; i < count; i++ {
-		entryOffset := offset + i*4
-		if entryOffset+4 > len(r.data) {
+		entryOffset := i * 4
+		if entryOffset+4 > len(data) {
 			break
 		}
 
-		storedCountry := r.data[entryOffset : entryOffset+2]
+		storedCountry := data[entryOffset : entryOffset+2]
 		if len(country) >= 2 && storedCountry[0] == country[0] && storedCountry[1] == country[1] {
 			return true
 		}
@@ -329,6 +428,7 @@ func ipToUint32(ip net.IP) uint32 {
 }
 
 func matchesIPv6CIDR(ip, network *[16]byte, prefixLen uint8) bool {
+	prefixLen = min(prefixLen, 128) // corrupt files may declare longer prefixes
 	fullBytes := int(prefixLen / 8)
 	remainingBits := int(prefixLen % 8)
 
@@ -3,9 +3,12 @@ package slice
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
+	"fmt"
 	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 // helper: buildData creates a K2RULEV3 binary blob from the given writer.
@@ -518,3 +521,438 @@ func TestReaderRoundtrip(t *testing.T) {
 		}
 	})
 }
+
+// TestHeaderVersionWithoutFeatures verifies files without feature bits stay version 1.
+func TestHeaderVersionWithoutFeatures(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddDomainSlice([]string{"example.com"}, 1)
+	data := buildData(t, w)
+
+	h, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader() error: %v", err)
+	}
+	if h.Version != 1 || h.Required != 0 || h.Optional != 0 {
+		t.Errorf("header = version %d, required %#x, optional %#x; want 1, 0, 0", h.Version, h.Required, h.Optional)
+	}
+}
+
+// TestHeaderVersionNewSlices verifies files with flagged slices or slice types added
+// after version 1 are written as version 2, so version 1 readers reject them.
+func TestHeaderVersionNewSlices(t *testing.T) {
+	tests := []struct {
+		name string
+		add  func(w *SliceWriter)
+	}{
+		{"domain trie", func(w *SliceWriter) { w.AddDomainTrieSlice([]string{"example.com"}, 1) }},
+		{"range", func(w *SliceWriter) {
+			w.AddIPRangeV4Slice([]IPRangeV4Entry{{Start: 0x0A000001, End: 0x0A000005}}, 1)
+		}},
+		{"unflagged new type", func(w *SliceWriter) { w.AddRawSlice(SliceType(0x7F), 1, 0, nil, 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewSliceWriter(0)
+			tt.add(w)
+			h, err := ParseHeader(buildData(t, w))
+			if err != nil {
+				t.Fatalf("ParseHeader() error: %v", err)
+			}
+			if h.Version != 2 {
+				t.Errorf("header version = %d, want 2", h.Version)
+			}
+		})
+	}
+}
+
+// TestFeatureBits verifies unknown optional features are ignored and unknown
+// required features are rejected by both readers.
+func TestFeatureBits(t *testing.T) {
+	const unknownFeature Feature = 1 << 31
+
+	tests := []struct {
+		name     string
+		required Feature
+		optional Feature
+		wantErr  bool
+	}{
+		{"optional known", 0, FeatureTargetNames, false},
+		{"optional unknown", 0, unknownFeature, false},
+		{"required unknown", unknownFeature, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewSliceWriter(0)
+			w.AddDomainSlice([]string{"example.com"}, 1)
+			w.SetFeatures(tt.required, tt.optional)
+			data := buildData(t, w)
+
+			if h, _ := ParseHeader(data); h.Version != 2 {
+				t.Errorf("Version = %d, want 2 for a file with features", h.Version)
+			}
+
+			r, err := NewSliceReaderFromBytes(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSliceReaderFromBytes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			mr, mmapErr := NewMmapReaderFromGzip(writeTempGzip(t, data))
+			if (mmapErr != nil) != tt.wantErr {
+				t.Fatalf("NewMmapReaderFromGzip() error = %v, wantErr %v", mmapErr, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer mr.Close()
+
+			if !r.HasFeature(tt.optional) || !mr.HasFeature(tt.optional) {
+				t.Errorf("HasFeature(%#x) = false, want true", uint32(tt.optional))
+			}
+			if r.HasFeature(FeaturePriorities) {
+				t.Error("HasFeature(FeaturePriorities) = true for a file without it")
+			}
+			if mr.Version() != 2 || mr.Features() != tt.optional {
+				t.Errorf("Version(), Features() = %d, %v, want 2, %v", mr.Version(), mr.Features().Names(), tt.optional.Names())
+			}
+			if got := mr.MatchDomain("example.com"); got == nil || *got != 1 {
+				t.Errorf("MatchDomain(example.com) = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestFeatureNames(t *testing.T) {
+	got := (FeatureCompressedSlices | FeaturePriorities | 1<<31).Names()
+	want := []string{"CompressedSlices", "Priorities", "0x80000000"}
+	if len(got) != len(want) {
+		t.Fatalf("Names() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Names()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestUnknownSliceTypes verifies unknown optional slices are skipped and unknown
+// required slices are rejected.
+func TestUnknownSliceTypes(t *testing.T) {
+	const extension SliceType = 0x7f
+
+	w := NewSliceWriter(0)
+	w.AddRawSlice(extension, 2, 0, []byte("future data"), 1)
+	w.AddDomainSlice([]string{"example.com"}, 1)
+	r := newSliceReader(t, buildData(t, w))
+	if got := r.MatchDomain("example.com"); got == nil || *got != 1 {
+		t.Errorf("MatchDomain(example.com) = %v, want 1 with an optional unknown slice", got)
+	}
+
+	w = NewSliceWriter(0)
+	w.AddRawSlice(extension, 2, SliceFlagRequired, []byte("future data"), 1)
+	w.AddDomainSlice([]string{"example.com"}, 1)
+	data := buildData(t, w)
+	if _, err := NewSliceReaderFromBytes(data); err == nil {
+		t.Error("NewSliceReaderFromBytes() accepted a required unknown slice")
+	}
+	if _, err := NewMmapReaderFromGzip(writeTempGzip(t, data)); err == nil {
+		t.Error("NewMmapReaderFromGzip() accepted a required unknown slice")
+	}
+}
+
+// TestUnsupportedVersion verifies files from a newer format version are rejected.
+func TestUnsupportedVersion(t *testing.T) {
+	w := NewSliceWriter(0)
+	data := buildData(t, w)
+	data[8] = FormatVersion + 1
+	if _, err := NewSliceReaderFromBytes(data); err == nil {
+		t.Error("NewSliceReaderFromBytes() accepted an unsupported version")
+	}
+}
+
+// TestCompressedSlices verifies compressed slices round-trip through both readers
+// and shrink the file.
+func TestCompressedSlices(t *testing.T) {
+	domains := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		domains = append(domains, fmt.Sprintf("host-%d.example.com", i))
+	}
+	build := func(compress bool) []byte {
+		w := NewSliceWriter(0)
+		w.AddDomainSlice(domains, 1)
+		w.AddCidrV4Slice([]CidrV4Entry{{Network: 0x0A000000, PrefixLen: 8}}, 2)
+		w.AddCidrV6Slice([]CidrV6Entry{{Network: [16]byte{0x20, 0x01, 0x0d, 0xb8}, PrefixLen: 32}}, 2)
+		w.AddGeoIPSlice([]string{"CN"}, 3)
+		if compress {
+			w.EnableSliceCompression(1)
+		}
+		return buildData(t, w)
+	}
+
+	plain, data := build(false), build(true)
+	if len(data) >= len(plain) {
+		t.Errorf("compressed file is %d bytes, plain %d bytes", len(data), len(plain))
+	}
+	h, _ := ParseHeader(data)
+	if h.Required&FeatureCompressedSlices == 0 {
+		t.Error("FeatureCompressedSlices not required by a file with compressed slices")
+	}
+	entry, _ := ParseEntry(data[HeaderSize:])
+	if !entry.IsCompressed() {
+		t.Error("domain slice was not compressed")
+	}
+
+	mr := newMmapReaderFromGzip(t, data)
+	// Touch reads the mapped file plus every decompressed slice
+	if pages := mr.Touch(); pages < 2 {
+		t.Errorf("Touch() = %d pages, want mapped file and decompressed slices", pages)
+	}
+	if err := mr.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+	readers := map[string]interface {
+		MatchDomain(string) *uint8
+		MatchIP(net.IP) *uint8
+		MatchGeoIP(string) *uint8
+	}{
+		"SliceReader": newSliceReader(t, data),
+		"MmapReader":  mr,
+	}
+	for name, r := range readers {
+		t.Run(name, func(t *testing.T) {
+			for _, domain := range []string{"host-0.example.com", "a.host-499.example.com"} {
+				if got := r.MatchDomain(domain); got == nil || *got != 1 {
+					t.Errorf("MatchDomain(%s) = %v, want 1", domain, got)
+				}
+			}
+			if got := r.MatchDomain("host-500.example.com"); got != nil {
+				t.Errorf("MatchDomain(host-500.example.com) = %d, want nil", *got)
+			}
+			for _, ip := range []string{"10.1.2.3", "2001:db8::1"} {
+				if got := r.MatchIP(net.ParseIP(ip)); got == nil || *got != 2 {
+					t.Errorf("MatchIP(%s) = %v, want 2", ip, got)
+				}
+			}
+			if got := r.MatchGeoIP("cn"); got == nil || *got != 3 {
+				t.Errorf("MatchGeoIP(cn) = %v, want 3", got)
+			}
+		})
+	}
+
+	if got := mr.CIDRs(2); len(got) != 2 || got[0].String() != "10.0.0.0/8" || got[1].String() != "2001:db8::/32" {
+		t.Errorf("CIDRs(2) = %v", got)
+	}
+}
+
+// TestCorruptCompressedSlice verifies a compressed slice that fails to
+// decompress is rejected by SliceReader, matches nothing in MmapReader and is reported by Err.
+func TestCorruptCompressedSlice(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddRawSlice(SliceTypeSortedDomain, 1, SliceFlagCompressed, []byte{0xff, 0, 0, 0, 0xde, 0xad}, 1)
+	w.SetFeatures(FeatureCompressedSlices, 0)
+	data := buildData(t, w)
+
+	if _, err := NewSliceReaderFromBytes(data); err == nil {
+		t.Error("NewSliceReaderFromBytes() accepted a corrupt compressed slice")
+	}
+	mr := newMmapReaderFromGzip(t, data)
+	if got := mr.MatchDomain("example.com"); got != nil {
+		t.Errorf("MatchDomain(example.com) = %d, want nil", *got)
+	}
+	if err := mr.Err(); err == nil {
+		t.Error("Err() = nil for a corrupt compressed slice")
+	}
+}
+
+// TestDomainCount verifies DomainCount sums the domain slices only.
+func TestDomainCount(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddDomainSlice([]string{"a.com", "b.com", "A.com"}, 1) // deduplicated
+	w.AddDomainTrieSlice([]string{"c.com", "d.c.com"}, 2)    // d.c.com is covered by c.com
+	w.AddDomainTargetSlice([]DomainTarget{{Domain: "e.com", Target: 1}})
+	w.AddCidrV4Slice([]CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, 1)
+	w.AddGeoIPSlice([]string{"CN"}, 0)
+	data := buildData(t, w)
+
+	heap, err := NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes() error: %v", err)
+	}
+	mapped, err := NewInMemoryReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewInMemoryReaderFromBytes() error: %v", err)
+	}
+	defer mapped.Close()
+	if got := heap.DomainCount(); got != 4 {
+		t.Errorf("SliceReader.DomainCount() = %d, want 4", got)
+	}
+	if got := mapped.DomainCount(); got != 4 {
+		t.Errorf("MmapReader.DomainCount() = %d, want 4", got)
+	}
+	if got := NewCachedMmapReader().DomainCount(); got != 0 {
+		t.Errorf("empty CachedMmapReader.DomainCount() = %d, want 0", got)
+	}
+}
+
+// TestSliceExpiry verifies readers skip expired slices and apply expiring ones until
+// they expire.
+func TestSliceExpiry(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	w := NewSliceWriter(0)
+	if err := w.ExpireLastSlice(future); err == nil {
+		t.Error("ExpireLastSlice() succeeded without slices")
+	}
+	w.AddDomainSlice([]string{"event.example"}, 2)
+	if err := w.ExpireLastSlice(past); err != nil {
+		t.Fatalf("ExpireLastSlice() error: %v", err)
+	}
+	w.AddDomainTrieSlice([]string{"event.example", "sale.example"}, 1)
+	w.ExpireLastSlice(future)
+	w.AddCidrV4Slice([]CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, 2)
+	w.ExpireLastSlice(past)
+	w.AddGeoIPSlice([]string{"CN"}, 2)
+	w.ExpireLastSlice(past)
+	w.AddGeoIPSlice([]string{"CN"}, 0)
+	data := buildData(t, w)
+
+	heap := newSliceReader(t, data)
+	mapped, err := NewInMemoryReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewInMemoryReaderFromBytes() error: %v", err)
+	}
+	defer mapped.Close()
+	if !heap.HasFeature(FeatureSliceExpiry) || heap.SliceCount() != 6 {
+		t.Errorf("HasFeature(FeatureSliceExpiry), SliceCount() = %v, %d, want true, 6 (with the expiry table)",
+			heap.HasFeature(FeatureSliceExpiry), heap.SliceCount())
+	}
+	if got := heap.entries[1].Expires(); got.Unix() != future.Unix() {
+		t.Errorf("Expires() = %v, want %v", got, future)
+	}
+	if !heap.entries[4].Expires().IsZero() {
+		t.Error("Expires() of a permanent slice is not zero")
+	}
+
+	readers := map[string]interface {
+		MatchDomain(string) *uint8
+		MatchIP(net.IP) *uint8
+		MatchGeoIP(string) *uint8
+		DomainCount() int
+	}{"heap": heap, "mmap": mapped}
+	for name, r := range readers {
+		if got := r.MatchDomain("event.example"); got == nil || *got != 1 {
+			t.Errorf("%s: MatchDomain(event.example) = %v, want 1 (expired slice skipped)", name, got)
+		}
+		if got := r.MatchIP(net.ParseIP("10.1.2.3")); got != nil {
+			t.Errorf("%s: MatchIP(10.1.2.3) = %v, want no match", name, *got)
+		}
+		if got := r.MatchGeoIP("CN"); got == nil || *got != 0 {
+			t.Errorf("%s: MatchGeoIP(CN) = %v, want 0", name, got)
+		}
+		if got := r.DomainCount(); got != 2 {
+			t.Errorf("%s: DomainCount() = %d, want 2", name, got)
+		}
+	}
+	if got := mapped.Domains(2); len(got) != 0 {
+		t.Errorf("Domains(2) = %v, want none", got)
+	}
+
+	// Slices age out without reloading the file
+	heap.entries[1].expires = time.Now().Unix()
+	if got := heap.MatchDomain("sale.example"); got != nil {
+		t.Errorf("MatchDomain(sale.example) after expiry = %v, want no match", *got)
+	}
+}
+
+// TestSliceDNSHints verifies DNS hints are written to the hint table and reported by
+// both readers for the slice that matched.
+func TestSliceDNSHints(t *testing.T) {
+	w := NewSliceWriter(0)
+	if err := w.SetLastSliceDNSHints(DNSHintRealIP); err == nil {
+		t.Error("SetLastSliceDNSHints() succeeded without slices")
+	}
+	w.AddDomainSlice([]string{"game.example"}, 1)
+	if err := w.SetLastSliceDNSHints(DNSHintRealIP); err != nil {
+		t.Fatalf("SetLastSliceDNSHints() error: %v", err)
+	}
+	if err := w.SetLastSliceDNSHints(DNSHintFakeIP | DNSHintRealIP); err == nil {
+		t.Error("SetLastSliceDNSHints() accepted FakeIP with RealIP")
+	}
+	w.AddDomainTrieSlice([]string{"blocked.example"}, 1)
+	w.SetLastSliceDNSHints(DNSHintRemoteDNS | DNSHintFakeIP)
+	w.AddDomainSlice([]string{"plain.example"}, 2)
+	w.AddCidrV4Slice([]CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, 2)
+	if err := w.SetLastSliceDNSHints(DNSHintRealIP); err == nil {
+		t.Error("SetLastSliceDNSHints() accepted an IP slice")
+	}
+	data := buildData(t, w)
+
+	heap := newSliceReader(t, data)
+	mapped, err := NewInMemoryReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewInMemoryReaderFromBytes() error: %v", err)
+	}
+	defer mapped.Close()
+	if !heap.HasFeature(FeatureDNSHints) || heap.SliceCount() != 5 {
+		t.Errorf("HasFeature(FeatureDNSHints), SliceCount() = %v, %d, want true, 5 (with the hint table)",
+			heap.HasFeature(FeatureDNSHints), heap.SliceCount())
+	}
+
+	readers := map[string]interface {
+		MatchDomainHints(string) (uint8, DNSHint, bool)
+	}{"heap": heap, "mmap": mapped}
+	tests := []struct {
+		domain string
+		target uint8
+		hints  DNSHint
+		ok     bool
+	}{
+		{"www.game.example", 1, DNSHintRealIP, true},
+		{"blocked.example", 1, DNSHintRemoteDNS | DNSHintFakeIP, true},
+		{"plain.example", 2, 0, true},
+		{"other.example", 0, 0, false},
+	}
+	for name, r := range readers {
+		for _, tt := range tests {
+			target, hints, ok := r.MatchDomainHints(tt.domain)
+			if target != tt.target || hints != tt.hints || ok != tt.ok {
+				t.Errorf("%s: MatchDomainHints(%s) = %d, %#x, %v, want %d, %#x, %v",
+					name, tt.domain, target, hints, ok, tt.target, tt.hints, tt.ok)
+			}
+		}
+	}
+
+	// Hints must name domain slices
+	bad := NewSliceWriter(0)
+	bad.AddCidrV4Slice([]CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, 2)
+	bad.AddRawSlice(SliceTypeDNSHints, 0, 0, []byte{0, 0, 0, 0, byte(DNSHintRealIP), 0, 0, 0}, 1)
+	if _, err := NewSliceReaderFromBytes(buildData(t, bad)); err == nil {
+		t.Error("NewSliceReaderFromBytes() accepted hints for an IP slice")
+	}
+}
+
+// TestSliceExpiryInvalid verifies malformed expiry tables are rejected.
+func TestSliceExpiryInvalid(t *testing.T) {
+	record := func(index uint32, expires int64) []byte {
+		b := binary.LittleEndian.AppendUint32(nil, index)
+		return binary.LittleEndian.AppendUint64(b, uint64(expires))
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		count uint32
+	}{
+		{"size mismatch", record(0, 1)[:8], 1},
+		{"index out of range", record(5, 1), 1},
+		{"self reference", record(1, 1), 1},
+		{"zero expiry", record(0, 0), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewSliceWriter(0)
+			w.AddDomainSlice([]string{"example.com"}, 1)
+			w.AddRawSlice(SliceTypeExpiry, 0, 0, tt.data, tt.count)
+			if _, err := NewSliceReaderFromBytes(buildData(t, w)); err == nil {
+				t.Error("NewSliceReaderFromBytes() accepted an invalid expiry table")
+			}
+		})
+	}
+}
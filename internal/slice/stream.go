@@ -0,0 +1,205 @@
+package slice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// DomainKey returns the sort key of domain in a SortedDomain slice
+// (lowercased, dot-prefixed, reversed). DomainStreamBuilder expects domains
+// in ascending DomainKey order.
+func DomainKey(domain string) string {
+	return normalizeDomain(domain)
+}
+
+// DomainStreamBuilder builds a SortedDomain slice from a domain stream that
+// is already sorted by DomainKey (e.g. the output of an external sort).
+// Strings and offsets are spooled to temporary files, so memory use stays
+// constant however many domains are added. It is safe for concurrent use;
+// concurrent callers must still add domains in sorted order.
+//
+// The output is byte-identical to AddDomainSlice for the same domain set
+// and is read by the existing readers.
+type DomainStreamBuilder struct {
+	mu      sync.Mutex
+	strings *os.File
+	offsets *os.File
+	sw      *bufio.Writer
+	ow      *bufio.Writer
+	last    string
+	count   uint32
+	size    int64 // strings area length
+	sealed  bool
+	err     error // first write error (sticky)
+}
+
+// NewDomainStreamBuilder creates a builder spooling to temporary files in dir
+// ("" = os.TempDir()). Close removes them.
+func NewDomainStreamBuilder(dir string) (*DomainStreamBuilder, error) {
+	strs, err := os.CreateTemp(dir, "k2rule-domains-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	offs, err := os.CreateTemp(dir, "k2rule-offsets-*.tmp")
+	if err != nil {
+		strs.Close()
+		os.Remove(strs.Name())
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return &DomainStreamBuilder{
+		strings: strs,
+		offsets: offs,
+		sw:      bufio.NewWriterSize(strs, 64*1024),
+		ow:      bufio.NewWriterSize(offs, 64*1024),
+	}, nil
+}
+
+// Add appends a domain. Duplicates of the previous domain are skipped; a domain
+// sorting before the previous one is an error.
+func (b *DomainStreamBuilder) Add(domain string) error {
+	key := normalizeDomain(domain)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return b.err
+	}
+	if b.sealed {
+		return fmt.Errorf("domain stream already added to a writer")
+	}
+	if b.count > 0 {
+		if key == b.last {
+			return nil
+		}
+		if key < b.last {
+			return fmt.Errorf("domain %q out of order: sorts before %q", domain, reverseString(b.last))
+		}
+	}
+	if b.count == math.MaxUint32 || b.size+int64(len(key)) > math.MaxUint32 {
+		return fmt.Errorf("domain stream too large")
+	}
+
+	var off [4]byte
+	binary.LittleEndian.PutUint32(off[:], uint32(b.size))
+	if _, err := b.ow.Write(off[:]); err != nil {
+		b.err = fmt.Errorf("failed to spool offset: %w", err)
+		return b.err
+	}
+	if _, err := b.sw.WriteString(key); err != nil {
+		b.err = fmt.Errorf("failed to spool domain: %w", err)
+		return b.err
+	}
+
+	b.last = key
+	b.count++
+	b.size += int64(len(key))
+	return nil
+}
+
+// Count returns the number of distinct domains added
+func (b *DomainStreamBuilder) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.count)
+}
+
+// Size returns the size of the encoded slice data in bytes
+func (b *DomainStreamBuilder) Size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return 4 + (int64(b.count)+1)*4 + b.size
+}
+
+// seal flushes the spool files and rejects further Add calls
+func (b *DomainStreamBuilder) seal() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.sealed = true
+	return nil
+}
+
+func (b *DomainStreamBuilder) flush() error {
+	if err := b.ow.Flush(); err != nil {
+		b.err = fmt.Errorf("failed to flush offsets: %w", err)
+		return b.err
+	}
+	if err := b.sw.Flush(); err != nil {
+		b.err = fmt.Errorf("failed to flush domains: %w", err)
+		return b.err
+	}
+	return nil
+}
+
+// WriteTo writes the encoded SortedDomain slice data to w:
+// count, offsets, sentinel, then the strings area.
+func (b *DomainStreamBuilder) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	if err := b.flush(); err != nil {
+		return 0, err
+	}
+
+	var word [4]byte
+	binary.LittleEndian.PutUint32(word[:], b.count)
+	n, err := w.Write(word[:])
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	m, err := io.Copy(w, io.NewSectionReader(b.offsets, 0, int64(b.count)*4))
+	written += m
+	if err != nil {
+		return written, err
+	}
+
+	// Sentinel: total strings length
+	binary.LittleEndian.PutUint32(word[:], uint32(b.size))
+	n, err = w.Write(word[:])
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	m, err = io.Copy(w, io.NewSectionReader(b.strings, 0, b.size))
+	written += m
+	return written, err
+}
+
+// Close removes the spool files
+func (b *DomainStreamBuilder) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for _, f := range []*os.File{b.strings, b.offsets} {
+		if f == nil {
+			continue
+		}
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		os.Remove(f.Name())
+	}
+	b.strings, b.offsets = nil, nil
+	if b.err == nil {
+		b.err = fmt.Errorf("domain stream closed")
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package slice
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// sortedByKey returns domains sorted by DomainKey
+func sortedByKey(domains []string) []string {
+	out := append([]string(nil), domains...)
+	sort.Slice(out, func(i, j int) bool { return DomainKey(out[i]) < DomainKey(out[j]) })
+	return out
+}
+
+// stripTimestamp zeroes the header timestamp so two builds compare equal
+func stripTimestamp(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	copy(out[20:28], make([]byte, 8))
+	return out
+}
+
+// TestDomainStreamMatchesAddDomainSlice verifies the streamed slice is
+// byte-identical to AddDomainSlice and readable by both readers.
+func TestDomainStreamMatchesAddDomainSlice(t *testing.T) {
+	domains := []string{"google.com", "Example.ORG", "a.b.c.net", "google.com", "x.io"}
+	for i := 0; i < 10000; i++ {
+		domains = append(domains, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	b, err := NewDomainStreamBuilder(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDomainStreamBuilder() error: %v", err)
+	}
+	defer b.Close()
+	for _, d := range sortedByKey(domains) {
+		if err := b.Add(d); err != nil {
+			t.Fatalf("Add(%s) error: %v", d, err)
+		}
+	}
+	if b.Count() != len(domains)-1 {
+		t.Errorf("Count() = %d, want %d (duplicate skipped)", b.Count(), len(domains)-1)
+	}
+
+	streamed := NewSliceWriter(0)
+	if err := streamed.AddDomainStream(b, 1); err != nil {
+		t.Fatalf("AddDomainStream() error: %v", err)
+	}
+	streamed.AddGeoIPSlice([]string{"CN"}, 2)
+	var buf bytes.Buffer
+	n, err := streamed.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo() = %d, %v (buffer %d bytes)", n, err, buf.Len())
+	}
+
+	inMemory := NewSliceWriter(0)
+	inMemory.AddDomainSlice(domains, 1)
+	inMemory.AddGeoIPSlice([]string{"CN"}, 2)
+	want := buildData(t, inMemory)
+	if !bytes.Equal(stripTimestamp(buf.Bytes()), stripTimestamp(want)) {
+		t.Fatal("streamed file differs from AddDomainSlice output")
+	}
+
+	r := newSliceReader(t, buf.Bytes())
+	mr := newMmapReaderFromGzip(t, buf.Bytes())
+	for _, d := range []string{"www.google.com", "example.org", "host9999.example.com"} {
+		if got := r.MatchDomain(d); got == nil || *got != 1 {
+			t.Errorf("SliceReader.MatchDomain(%s) = %v, want 1", d, got)
+		}
+		if got := mr.MatchDomain(d); got == nil || *got != 1 {
+			t.Errorf("MmapReader.MatchDomain(%s) = %v, want 1", d, got)
+		}
+	}
+	if got := mr.MatchGeoIP("CN"); got == nil || *got != 2 {
+		t.Errorf("MatchGeoIP(CN) = %v, want 2", got)
+	}
+}
+
+func TestDomainStreamRejectsUnsorted(t *testing.T) {
+	b, err := NewDomainStreamBuilder(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDomainStreamBuilder() error: %v", err)
+	}
+	defer b.Close()
+
+	// Keys compare reversed: "moc.a." sorts before "moc.b."
+	if err := b.Add("b.com"); err != nil {
+		t.Fatalf("Add(b.com) error: %v", err)
+	}
+	if err := b.Add("a.com"); err == nil {
+		t.Error("Add(a.com) after b.com succeeded, want out-of-order error")
+	}
+
+	w := NewSliceWriter(0)
+	if err := w.AddDomainStream(b, 1); err != nil {
+		t.Fatalf("AddDomainStream() error: %v", err)
+	}
+	if err := b.Add("c.com"); err == nil {
+		t.Error("Add() after AddDomainStream succeeded, want error")
+	}
+}
+
+func TestDomainStreamConcurrentAndCleanup(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewDomainStreamBuilder(dir)
+	if err != nil {
+		t.Fatalf("NewDomainStreamBuilder() error: %v", err)
+	}
+
+	// Concurrent adds of the same domain are serialized and deduplicated
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Add("example.com")
+		}()
+	}
+	wg.Wait()
+	if b.Count() != 1 {
+		t.Errorf("Count() = %d, want 1", b.Count())
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("spool files left after Close: %v", files)
+	}
+	if _, err := b.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("WriteTo() after Close succeeded")
+	}
+}
@@ -0,0 +1,434 @@
+package slice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DomainTrie slice data layout (SliceTypeDomainTrie), all integers LE:
+//
+//	count       (4 bytes)   number of domains stored (after pruning)
+//	labels_len  (4 bytes)   size of the label pool
+//	label pool  (variable)  interned labels: [len (1 byte)][bytes]
+//	nodes       (variable)  root node first:
+//	  header    (4 bytes)   child count; bit 31 set = terminal (a domain ends here)
+//	  children  (8 bytes each, sorted by label) label offset in pool (4) + node offset in nodes area (4)
+//
+// The trie is keyed by domain labels from the TLD down, so "www.google.com"
+// walks com → google → www. A terminal node matches its domain and every
+// subdomain; its descendants are pruned when encoding. Labels shared across
+// the set ("com", "www", ...) are stored once.
+//
+// DomainTargets slice data (SliceTypeDomainTargets) uses the same layout with a
+// target per entry: the header of a terminal node is followed by its target
+// (1 byte) before the children table, and terminal nodes keep descendants whose
+// target differs. The most specific stored domain decides, so one slice holds
+// a domain list with several targets and is matched in one walk.
+
+// trieTerminal is the terminal bit of a node header
+const trieTerminal = 1 << 31
+
+// trieNode is an in-memory trie node used while encoding
+type trieNode struct {
+	terminal bool
+	target   uint8 // DomainTargets only
+	children map[string]*trieNode
+}
+
+// DomainTarget is a domain and its target, stored in a DomainTargets slice
+type DomainTarget struct {
+	Domain string
+	Target uint8
+}
+
+// domainLabels returns the lowercased labels of domain from the TLD down
+// (leading dots are ignored, matching normalizeDomain)
+func domainLabels(domain string) []string {
+	labels := strings.Split(strings.TrimLeft(strings.ToLower(domain), "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+// encodeDomainTrie builds DomainTrie slice data for domains; it returns the data
+// and the number of domains stored
+func encodeDomainTrie(domains []string) ([]byte, uint32, error) {
+	root := &trieNode{}
+	for _, d := range domains {
+		node := root
+		for _, label := range domainLabels(d) {
+			if len(label) > 255 {
+				return nil, 0, fmt.Errorf("domain %q: label longer than 255 bytes", d)
+			}
+			if node.terminal {
+				break // covered by a shorter suffix
+			}
+			node = node.child(label)
+		}
+		if !node.terminal {
+			node.terminal = true
+			node.children = nil // subdomains are covered
+		}
+	}
+	data, count := encodeTrieNodes(root, false)
+	return data, count, nil
+}
+
+// encodeDomainTargetTrie builds DomainTargets slice data for entries (the first
+// entry of a duplicated domain wins); it returns the data and the number of
+// entries stored
+func encodeDomainTargetTrie(entries []DomainTarget) ([]byte, uint32, error) {
+	root := &trieNode{}
+	for _, e := range entries {
+		node := root
+		for _, label := range domainLabels(e.Domain) {
+			if len(label) > 255 {
+				return nil, 0, fmt.Errorf("domain %q: label longer than 255 bytes", e.Domain)
+			}
+			node = node.child(label)
+		}
+		if !node.terminal {
+			node.terminal = true
+			node.target = e.Target
+		}
+	}
+	pruneTargets(root, false, 0)
+	data, count := encodeTrieNodes(root, true)
+	return data, count, nil
+}
+
+// child returns the child of n for label, creating it if needed
+func (n *trieNode) child(label string) *trieNode {
+	if n.children == nil {
+		n.children = make(map[string]*trieNode)
+	}
+	child, ok := n.children[label]
+	if !ok {
+		child = &trieNode{}
+		n.children[label] = child
+	}
+	return child
+}
+
+// pruneTargets drops entries below n whose target equals the one they inherit
+// (inherited, if covered) and the branches left empty; it reports whether n is empty
+func pruneTargets(n *trieNode, covered bool, inherited uint8) bool {
+	if n.terminal && covered && n.target == inherited {
+		n.terminal = false
+	}
+	if n.terminal {
+		covered, inherited = true, n.target
+	}
+	for label, child := range n.children {
+		if pruneTargets(child, covered, inherited) {
+			delete(n.children, label)
+		}
+	}
+	return !n.terminal && len(n.children) == 0
+}
+
+// encodeTrieNodes encodes the trie below root (valued: with terminal targets) and
+// returns the slice data and the number of terminal nodes
+func encodeTrieNodes(root *trieNode, valued bool) ([]byte, uint32) {
+	var pool bytes.Buffer
+	labelOffsets := make(map[string]uint32)
+	var nodes []byte
+	var count uint32
+
+	// encode writes node at the end of nodes and returns its offset
+	var encode func(n *trieNode) uint32
+	encode = func(n *trieNode) uint32 {
+		labels := make([]string, 0, len(n.children))
+		for label := range n.children {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		offset := uint32(len(nodes))
+		header := uint32(len(labels))
+		if n.terminal {
+			header |= trieTerminal
+			count++
+		}
+		nodes = binary.LittleEndian.AppendUint32(nodes, header)
+		if valued && n.terminal {
+			nodes = append(nodes, n.target)
+		}
+		table := len(nodes)
+		nodes = append(nodes, make([]byte, 8*len(labels))...)
+
+		for i, label := range labels {
+			labelOff, ok := labelOffsets[label]
+			if !ok {
+				labelOff = uint32(pool.Len())
+				labelOffsets[label] = labelOff
+				pool.WriteByte(byte(len(label)))
+				pool.WriteString(label)
+			}
+			childOff := encode(n.children[label])
+			binary.LittleEndian.PutUint32(nodes[table+i*8:], labelOff)
+			binary.LittleEndian.PutUint32(nodes[table+i*8+4:], childOff)
+		}
+		return offset
+	}
+	encode(root)
+
+	out := make([]byte, 8, 8+pool.Len()+len(nodes))
+	binary.LittleEndian.PutUint32(out[0:4], count)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(pool.Len()))
+	out = append(out, pool.Bytes()...)
+	out = append(out, nodes...)
+	return out, count
+}
+
+// matchDomainTrie reports whether domain (lowercased) or one of its parent
+// domains is stored in DomainTrie slice data
+func matchDomainTrie(data []byte, domain string) bool {
+	_, ok := domainTrieSuffix(data, domain)
+	return ok
+}
+
+// domainTrieSuffix is like matchDomainTrie, also returning the index in domain
+// where the stored suffix that matched starts
+func domainTrieSuffix(data []byte, domain string) (int, bool) {
+	if len(data) < 8 {
+		return 0, false
+	}
+	poolLen := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	if 8+poolLen > uint64(len(data)) {
+		return 0, false
+	}
+	pool := data[8 : 8+poolLen]
+	nodes := data[8+poolLen:]
+
+	// Walk labels from the TLD down without allocating
+	node := 0
+	end := len(domain)
+	for {
+		if node+4 > len(nodes) {
+			return 0, false
+		}
+		header := binary.LittleEndian.Uint32(nodes[node:])
+		if header&trieTerminal != 0 {
+			return min(end+1, len(domain)), true
+		}
+		if end <= 0 {
+			return 0, false
+		}
+
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[start:end]
+		end = start - 1
+
+		children := int(header &^ trieTerminal)
+		table := node + 4
+		if uint64(table)+uint64(children)*8 > uint64(len(nodes)) {
+			return 0, false
+		}
+		i := sort.Search(children, func(i int) bool {
+			return string(trieLabel(pool, nodes[table+i*8:])) >= label
+		})
+		if i == children || string(trieLabel(pool, nodes[table+i*8:])) != label {
+			return 0, false
+		}
+		node = int(binary.LittleEndian.Uint32(nodes[table+i*8+4:]))
+	}
+}
+
+// trieDomainsBelow reports whether DomainTrie (valued: DomainTargets) slice data
+// stores a domain strictly below parent (lowercased); malformed data reports true
+func trieDomainsBelow(data []byte, parent string, valued bool) bool {
+	if len(data) < 8 {
+		return false
+	}
+	poolLen := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	if 8+poolLen > uint64(len(data)) {
+		return true
+	}
+	pool := data[8 : 8+poolLen]
+	nodes := data[8+poolLen:]
+
+	node := 0
+	end := len(parent)
+	for {
+		if node+4 > len(nodes) {
+			return true
+		}
+		header := binary.LittleEndian.Uint32(nodes[node:])
+		table := node + 4
+		if header&trieTerminal != 0 {
+			if !valued {
+				return false // descendants of terminal nodes are pruned
+			}
+			table++
+		}
+		children := int(header &^ trieTerminal)
+		if end <= 0 {
+			return children > 0
+		}
+
+		next := strings.LastIndexByte(parent[:end], '.') + 1
+		label := parent[next:end]
+		end = next - 1
+
+		if uint64(table)+uint64(children)*8 > uint64(len(nodes)) {
+			return true
+		}
+		i := sort.Search(children, func(i int) bool {
+			return string(trieLabel(pool, nodes[table+i*8:])) >= label
+		})
+		if i == children || string(trieLabel(pool, nodes[table+i*8:])) != label {
+			return false
+		}
+		node = int(binary.LittleEndian.Uint32(nodes[table+i*8+4:]))
+	}
+}
+
+// matchDomainTargetTrie returns the target of the most specific stored domain
+// that is domain (lowercased) or one of its parent domains in DomainTargets slice data
+func matchDomainTargetTrie(data []byte, domain string) (uint8, bool) {
+	_, target, ok := domainTargetTrieSuffix(data, domain)
+	return target, ok
+}
+
+// domainTargetTrieSuffix is like matchDomainTargetTrie, also returning the index in
+// domain where the stored suffix that decided starts
+func domainTargetTrieSuffix(data []byte, domain string) (int, uint8, bool) {
+	if len(data) < 8 {
+		return 0, 0, false
+	}
+	poolLen := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	if 8+poolLen > uint64(len(data)) {
+		return 0, 0, false
+	}
+	pool := data[8 : 8+poolLen]
+	nodes := data[8+poolLen:]
+
+	var start int
+	var target uint8
+	var found bool
+	node := 0
+	end := len(domain)
+	for {
+		if node+4 > len(nodes) {
+			return start, target, found
+		}
+		header := binary.LittleEndian.Uint32(nodes[node:])
+		table := node + 4
+		if header&trieTerminal != 0 {
+			if table >= len(nodes) {
+				return start, target, found
+			}
+			start, target, found = min(end+1, len(domain)), nodes[table], true
+			table++
+		}
+		if end <= 0 {
+			return start, target, found
+		}
+
+		next := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[next:end]
+		end = next - 1
+
+		children := int(header &^ trieTerminal)
+		if uint64(table)+uint64(children)*8 > uint64(len(nodes)) {
+			return start, target, found
+		}
+		i := sort.Search(children, func(i int) bool {
+			return string(trieLabel(pool, nodes[table+i*8:])) >= label
+		})
+		if i == children || string(trieLabel(pool, nodes[table+i*8:])) != label {
+			return start, target, found
+		}
+		node = int(binary.LittleEndian.Uint32(nodes[table+i*8+4:]))
+	}
+}
+
+// decodeDomainTrie returns the domains stored in DomainTrie slice data,
+// sorted by their labels from the TLD down
+func decodeDomainTrie(data []byte) []string {
+	var domains []string
+	for _, e := range decodeTrie(data, false) {
+		domains = append(domains, e.Domain)
+	}
+	return domains
+}
+
+// decodeDomainTargetTrie returns the entries stored in DomainTargets slice data,
+// sorted by their labels from the TLD down
+func decodeDomainTargetTrie(data []byte) []DomainTarget {
+	return decodeTrie(data, true)
+}
+
+// decodeTrie returns the entries of DomainTrie (valued = false, targets zero) or
+// DomainTargets slice data
+func decodeTrie(data []byte, valued bool) []DomainTarget {
+	if len(data) < 8 {
+		return nil
+	}
+	poolLen := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	if 8+poolLen > uint64(len(data)) {
+		return nil
+	}
+	pool := data[8 : 8+poolLen]
+	nodes := data[8+poolLen:]
+
+	var entries []DomainTarget
+	// A valid trie visits each node once; the budget bounds corrupt data whose
+	// offsets share or cycle between nodes (as depth bounds recursion)
+	budget := len(nodes)/4 + 1
+	// walk visits the node at offset with the labels leading to it (TLD first)
+	var walk func(node int, labels []string)
+	walk = func(node int, labels []string) {
+		if node+4 > len(nodes) || len(labels) > 127 || budget == 0 {
+			return
+		}
+		budget--
+		header := binary.LittleEndian.Uint32(nodes[node:])
+		table := node + 4
+		if header&trieTerminal != 0 {
+			domain := make([]string, len(labels))
+			for i, label := range labels {
+				domain[len(labels)-1-i] = label
+			}
+			entry := DomainTarget{Domain: strings.Join(domain, ".")}
+			if !valued {
+				entries = append(entries, entry)
+				return
+			}
+			if table >= len(nodes) {
+				return
+			}
+			entry.Target = nodes[table]
+			entries = append(entries, entry)
+			table++
+		}
+		children := int(header &^ trieTerminal)
+		if uint64(table)+uint64(children)*8 > uint64(len(nodes)) {
+			return
+		}
+		for i := 0; i < children; i++ {
+			label := trieLabel(pool, nodes[table+i*8:])
+			walk(int(binary.LittleEndian.Uint32(nodes[table+i*8+4:])), append(labels, string(label)))
+		}
+	}
+	walk(0, nil)
+	return entries
+}
+
+// trieLabel returns the label referenced by a child table entry (nil if out of range)
+func trieLabel(pool, child []byte) []byte {
+	off := int(binary.LittleEndian.Uint32(child))
+	if off >= len(pool) {
+		return nil
+	}
+	n := int(pool[off])
+	if off+1+n > len(pool) {
+		return nil
+	}
+	return pool[off+1 : off+1+n]
+}
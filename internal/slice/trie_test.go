@@ -0,0 +1,271 @@
+package slice
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestDomainTrieMatchesSortedDomain verifies a DomainTrie slice gives the same
+// answers as a SortedDomain slice in both readers.
+func TestDomainTrieMatchesSortedDomain(t *testing.T) {
+	domains := []string{"google.com", "www.google.com", ".Example.ORG", "a.b.c.net", "co.uk"}
+	queries := []string{
+		"google.com", "mail.google.com", "GOOGLE.COM", "oogle.com", "google.com.cn",
+		"example.org", "x.example.org", "c.net", "b.c.net", "a.b.c.net", "z.a.b.c.net",
+		"co.uk", "bbc.co.uk", "uk", "com", "",
+	}
+
+	sorted := NewSliceWriter(0)
+	sorted.AddDomainSlice(domains, 1)
+	want := newSliceReader(t, buildData(t, sorted))
+
+	w := NewSliceWriter(0)
+	if err := w.AddDomainTrieSlice(domains, 1); err != nil {
+		t.Fatalf("AddDomainTrieSlice() error: %v", err)
+	}
+	data := buildData(t, w)
+
+	entry, _ := ParseEntry(data[HeaderSize:])
+	if entry.GetType() != SliceTypeDomainTrie || entry.Flags&SliceFlagRequired == 0 {
+		t.Errorf("entry = %s flags %#x, want required DomainTrie", entry.GetType(), entry.Flags)
+	}
+	if entry.Count != 4 {
+		t.Errorf("Count = %d, want 4 (www.google.com pruned)", entry.Count)
+	}
+
+	r := newSliceReader(t, data)
+	mr := newMmapReaderFromGzip(t, data)
+	for _, q := range queries {
+		expected := want.MatchDomain(q) != nil
+		if got := r.MatchDomain(q) != nil; got != expected {
+			t.Errorf("SliceReader.MatchDomain(%q) matched = %v, want %v", q, got, expected)
+		}
+		if got := mr.MatchDomain(q) != nil; got != expected {
+			t.Errorf("MmapReader.MatchDomain(%q) matched = %v, want %v", q, got, expected)
+		}
+	}
+}
+
+// TestDomainTrieSize verifies shared labels make the trie smaller than sorted strings.
+func TestDomainTrieSize(t *testing.T) {
+	var domains []string
+	for i := 0; i < 200; i++ {
+		for _, parent := range []string{"cdn.example.com", "static.example.net"} {
+			domains = append(domains, fmt.Sprintf("node%d.%s", i, parent))
+		}
+	}
+
+	trie, _, err := encodeDomainTrie(domains)
+	if err != nil {
+		t.Fatalf("encodeDomainTrie() error: %v", err)
+	}
+	sorted := NewSliceWriter(0)
+	sorted.AddDomainSlice(domains, 1)
+	if size := len(sorted.slices[0].data); len(trie) >= size {
+		t.Errorf("trie is %d bytes, sorted slice %d bytes", len(trie), size)
+	}
+}
+
+func TestDomainTrieInvalid(t *testing.T) {
+	w := NewSliceWriter(0)
+	if err := w.AddDomainTrieSlice([]string{strings.Repeat("a", 256) + ".com"}, 1); err == nil {
+		t.Error("AddDomainTrieSlice() accepted a label longer than 255 bytes")
+	}
+
+	// Truncated or corrupt data never matches (and never panics)
+	data, _, _ := encodeDomainTrie([]string{"www.example.com"})
+	for i := 0; i < len(data); i++ {
+		if matchDomainTrie(data[:i], "www.example.com") {
+			t.Errorf("truncated data (%d bytes) matched", i)
+		}
+	}
+	corrupt := append([]byte(nil), data...)
+	for i := 8; i < len(corrupt); i++ {
+		corrupt[i] = 0xff
+	}
+	matchDomainTrie(corrupt, "www.example.com")
+}
+
+// TestDomainsExport verifies Domains decodes SortedDomain and DomainTrie slices.
+func TestDomainsExport(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddDomainSlice([]string{"Google.com", "youtube.com"}, 1)
+	w.AddDomainTrieSlice([]string{"www.example.org", "example.org", "a.b.net"}, 1)
+	w.AddDomainSlice([]string{"other.com"}, 2)
+	mr := newMmapReaderFromGzip(t, buildData(t, w))
+
+	got := strings.Join(mr.Domains(1), ",")
+	if want := "youtube.com,google.com,a.b.net,example.org"; got != want {
+		t.Errorf("Domains(1) = %s, want %s", got, want)
+	}
+	if got := mr.Domains(3); got != nil {
+		t.Errorf("Domains(3) = %v, want nil", got)
+	}
+}
+
+// TestDomainTargets verifies per-entry targets in one DomainTargets slice, with the
+// most specific domain deciding.
+func TestDomainTargets(t *testing.T) {
+	entries := []DomainTarget{
+		{"example.com", 1},
+		{"ads.example.com", 3},
+		{"cdn.ads.example.com", 1},
+		{"www.example.com", 1}, // same as inherited: pruned
+		{"EXAMPLE.com", 2},     // duplicate: first wins
+		{"google.com", 2},
+		{"co.uk", 0},
+	}
+	w := NewSliceWriter(9)
+	if err := w.AddDomainTargetSlice(entries); err != nil {
+		t.Fatalf("AddDomainTargetSlice() error: %v", err)
+	}
+	w.AddDomainSlice([]string{"other.net", "google.com"}, 4)
+	data := buildData(t, w)
+
+	entry, _ := ParseEntry(data[HeaderSize:])
+	if entry.GetType() != SliceTypeDomainTargets || entry.Flags&SliceFlagRequired == 0 {
+		t.Errorf("entry = %s flags %#x, want required DomainTargets", entry.GetType(), entry.Flags)
+	}
+	if entry.Count != 5 {
+		t.Errorf("Count = %d, want 5 (www.example.com and duplicate pruned)", entry.Count)
+	}
+
+	tests := []struct {
+		domain string
+		want   int // -1 = no match
+	}{
+		{"example.com", 1},
+		{"www.example.com", 1},
+		{"ADS.example.com", 3},
+		{"x.ads.example.com", 3},
+		{"cdn.ads.example.com", 1},
+		{"a.cdn.ads.example.com", 1},
+		{"google.com", 2}, // DomainTargets slice comes first
+		{"bbc.co.uk", 0},
+		{"other.net", 4},
+		{"com", -1},
+		{"xexample.com", -1},
+		{"", -1},
+	}
+	r := newSliceReader(t, data)
+	mr := newMmapReaderFromGzip(t, data)
+	for _, tt := range tests {
+		for name, got := range map[string]*uint8{"SliceReader": r.MatchDomain(tt.domain), "MmapReader": mr.MatchDomain(tt.domain)} {
+			if (got == nil) != (tt.want < 0) || (got != nil && int(*got) != tt.want) {
+				t.Errorf("%s.MatchDomain(%q) = %v, want %d", name, tt.domain, got, tt.want)
+			}
+		}
+	}
+
+	if got := strings.Join(mr.Domains(1), ","); got != "example.com,cdn.ads.example.com" {
+		t.Errorf("Domains(1) = %s", got)
+	}
+	if got := strings.Join(mr.Domains(2), ","); got != "google.com" {
+		t.Errorf("Domains(2) = %s", got)
+	}
+}
+
+// TestDomainTargetsSize verifies one DomainTargets slice is smaller than one
+// DomainTrie slice per target.
+func TestDomainTargetsSize(t *testing.T) {
+	var entries []DomainTarget
+	perTarget := make(map[uint8][]string)
+	for i := 0; i < 300; i++ {
+		domain := fmt.Sprintf("node%d.site%d.com", i%3, i/3) // targets share parent domains
+		entries = append(entries, DomainTarget{domain, uint8(i % 3)})
+		perTarget[uint8(i%3)] = append(perTarget[uint8(i%3)], domain)
+	}
+
+	combined, _, err := encodeDomainTargetTrie(entries)
+	if err != nil {
+		t.Fatalf("encodeDomainTargetTrie() error: %v", err)
+	}
+	separate := 0
+	for _, domains := range perTarget {
+		data, _, _ := encodeDomainTrie(domains)
+		separate += len(data) + EntrySize
+	}
+	if len(combined)+EntrySize >= separate {
+		t.Errorf("DomainTargets is %d bytes, DomainTrie slices %d bytes", len(combined)+EntrySize, separate)
+	}
+}
+
+func TestDomainTargetsInvalid(t *testing.T) {
+	w := NewSliceWriter(0)
+	if err := w.AddDomainTargetSlice([]DomainTarget{{strings.Repeat("a", 256) + ".com", 1}}); err == nil {
+		t.Error("AddDomainTargetSlice() accepted a label longer than 255 bytes")
+	}
+
+	// Truncated data never reports a target it doesn't hold (and never panics)
+	data, _, _ := encodeDomainTargetTrie([]DomainTarget{{"www.example.com", 2}})
+	for i := 0; i < len(data); i++ {
+		if _, ok := matchDomainTargetTrie(data[:i], "www.example.com"); ok {
+			t.Errorf("truncated data (%d bytes) matched", i)
+		}
+	}
+}
+
+// TestMatchDomainSuffix verifies both readers report the stored domain that matched
+// for each domain slice type.
+func TestMatchDomainSuffix(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddDomainSlice([]string{"googleapis.com", "storage.googleapis.com"}, 1)
+	w.AddDomainTrieSlice([]string{"example.org", "www.example.org"}, 2)
+	w.AddDomainTargetSlice([]DomainTarget{{"net", 3}, {"ads.site.net", 4}})
+	data := buildData(t, w)
+
+	tests := []struct {
+		domain string
+		suffix string
+		target uint8
+	}{
+		{"maps.googleapis.com", "googleapis.com", 1},
+		{"a.Storage.googleapis.com", "storage.googleapis.com", 1},
+		{"googleapis.com", "googleapis.com", 1},
+		{"www.example.org", "example.org", 2},
+		{"x.ads.site.net", "ads.site.net", 4},
+		{"site.net", "net", 3},
+		{"example.com", "", 0},
+	}
+	r := newSliceReader(t, data)
+	mr := newMmapReaderFromGzip(t, data)
+	for _, tt := range tests {
+		for name, match := range map[string]func(string) (string, uint8, bool){
+			"SliceReader": r.MatchDomainSuffix, "MmapReader": mr.MatchDomainSuffix,
+		} {
+			suffix, target, ok := match(tt.domain)
+			if suffix != tt.suffix || target != tt.target || ok != (tt.suffix != "") {
+				t.Errorf("%s.MatchDomainSuffix(%q) = %q, %d, %v, want %q, %d", name, tt.domain, suffix, target, ok, tt.suffix, tt.target)
+			}
+		}
+	}
+}
+
+func TestUniformBelow(t *testing.T) {
+	w := NewSliceWriter(0)
+	w.AddDomainSlice([]string{"googleapis.com", "storage.googleapis.com"}, 1)
+	w.AddDomainTrieSlice([]string{"example.org", "www.example.org", "a.b.test.org"}, 2)
+	w.AddDomainTargetSlice([]DomainTarget{{"net", 3}, {"ads.site.net", 4}})
+	mr := newMmapReaderFromGzip(t, buildData(t, w))
+
+	tests := []struct {
+		parent string
+		want   bool
+	}{
+		{"googleapis.com", false}, // storage.googleapis.com
+		{"storage.googleapis.com", true},
+		{"googleapis.co", true},
+		{"example.org", true}, // www.example.org is pruned by example.org
+		{"test.org", false},   // a.b.test.org
+		{"b.test.org", false},
+		{"site.net", false}, // ads.site.net
+		{"other.net", true},
+		{"Unlisted.COM", true},
+	}
+	for _, tt := range tests {
+		if got := mr.UniformBelow(tt.parent); got != tt.want {
+			t.Errorf("UniformBelow(%q) = %v, want %v", tt.parent, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package slice
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
 	"sort"
 	"strings"
 	"time"
@@ -23,14 +27,22 @@ type CidrV6Entry struct {
 type sliceRecord struct {
 	sliceType uint8
 	target    uint8
+	flags     uint8
 	data      []byte
+	stream    *DomainStreamBuilder // data spooled on disk (AddDomainStream)
 	count     uint32
+	expires   int64   // Unix seconds (0 = never, see ExpireLastSlice)
+	hints     DNSHint // DNS hints (0 = none, see SetLastSliceDNSHints)
 }
 
 // SliceWriter builds K2RULEV3 binary files with sorted domain slices.
 type SliceWriter struct {
 	fallbackTarget uint8
 	slices         []sliceRecord
+	required       Feature
+	optional       Feature
+	compressMin    int       // compress slices of at least this many bytes (0 = disabled)
+	buildTime      time.Time // header timestamp (zero = time of writing)
 }
 
 // NewSliceWriter creates a new SliceWriter with the given fallback target.
@@ -113,6 +125,61 @@ func (w *SliceWriter) AddDomainSlice(domains []string, target uint8) error {
 	return nil
 }
 
+// AddDomainTrieSlice appends the domains as a DomainTrie slice, a label suffix
+// trie that is usually smaller than a SortedDomain slice for sets sharing many
+// parent domains. The slice is flagged SliceFlagRequired so that readers without
+// DomainTrie support reject the file instead of silently missing its rules.
+func (w *SliceWriter) AddDomainTrieSlice(domains []string, target uint8) error {
+	data, count, err := encodeDomainTrie(domains)
+	if err != nil {
+		return err
+	}
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(SliceTypeDomainTrie),
+		target:    target,
+		flags:     SliceFlagRequired,
+		data:      data,
+		count:     count,
+	})
+	return nil
+}
+
+// AddDomainTargetSlice appends the entries as a DomainTargets slice: one trie
+// holding domains with different targets, matched in one lookup instead of one
+// per target slice. Within the slice the most specific domain decides (an entry
+// for "ads.example.com" overrides one for "example.com"); the first entry of a
+// duplicated domain wins. Slices are still matched in file order. The slice is
+// flagged SliceFlagRequired like DomainTrie; its index Target is unused (0).
+func (w *SliceWriter) AddDomainTargetSlice(entries []DomainTarget) error {
+	data, count, err := encodeDomainTargetTrie(entries)
+	if err != nil {
+		return err
+	}
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(SliceTypeDomainTargets),
+		flags:     SliceFlagRequired,
+		data:      data,
+		count:     count,
+	})
+	return nil
+}
+
+// AddDomainStream appends a SortedDomain slice whose data is spooled by b.
+// b is sealed: further Add calls fail. The writer reads b's spool files when
+// writing, so b must not be closed before Build or WriteTo returns.
+func (w *SliceWriter) AddDomainStream(b *DomainStreamBuilder, target uint8) error {
+	if err := b.seal(); err != nil {
+		return err
+	}
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(SliceTypeSortedDomain),
+		target:    target,
+		stream:    b,
+		count:     uint32(b.Count()),
+	})
+	return nil
+}
+
 // AddCidrV4Slice appends a CidrV4 slice entry.
 // Each entry is written as 8 bytes: network (BE 4 bytes) + prefix_len (1 byte) + 3 padding bytes.
 func (w *SliceWriter) AddCidrV4Slice(cidrs []CidrV4Entry, target uint8) error {
@@ -161,6 +228,42 @@ func (w *SliceWriter) AddCidrV6Slice(cidrs []CidrV6Entry, target uint8) error {
 	return nil
 }
 
+// AddIPRangeV4Slice appends a RangeV4 slice for address ranges that aren't clean
+// CIDRs. Ranges are sorted and merged; each is written as 8 bytes: start + end (BE).
+// The slice is flagged SliceFlagRequired so that readers without range support
+// reject the file instead of silently missing its rules.
+func (w *SliceWriter) AddIPRangeV4Slice(ranges []IPRangeV4Entry, target uint8) error {
+	data, count, err := encodeRangesV4(ranges)
+	if err != nil {
+		return err
+	}
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(SliceTypeRangeV4),
+		target:    target,
+		flags:     SliceFlagRequired,
+		data:      data,
+		count:     count,
+	})
+	return nil
+}
+
+// AddIPRangeV6Slice appends a RangeV6 slice (see AddIPRangeV4Slice).
+// Each range is written as 32 bytes: start (16) + end (16).
+func (w *SliceWriter) AddIPRangeV6Slice(ranges []IPRangeV6Entry, target uint8) error {
+	data, count, err := encodeRangesV6(ranges)
+	if err != nil {
+		return err
+	}
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(SliceTypeRangeV6),
+		target:    target,
+		flags:     SliceFlagRequired,
+		data:      data,
+		count:     count,
+	})
+	return nil
+}
+
 // AddGeoIPSlice appends a GeoIP slice entry.
 // Each country code is stored as 2 uppercase bytes + 2 padding bytes (4 bytes total).
 func (w *SliceWriter) AddGeoIPSlice(countries []string, target uint8) error {
@@ -188,57 +291,265 @@ func (w *SliceWriter) AddGeoIPSlice(countries []string, target uint8) error {
 	return nil
 }
 
+// SetFeatures declares the header feature bits of the file.
+// Files with any feature bit, flagged slice or slice type added after version 1 are
+// written as format version 2, others as version 1 so that older readers keep
+// accepting them.
+func (w *SliceWriter) SetFeatures(required, optional Feature) {
+	w.required = required
+	w.optional = optional
+}
+
+// SetBuildTime sets the header timestamp (default: the time the file is written),
+// making the output reproducible
+func (w *SliceWriter) SetBuildTime(t time.Time) {
+	w.buildTime = t
+}
+
+// EnableSliceCompression compresses every slice whose data is at least minSize bytes
+// (and shrinks when compressed). Files with compressed slices require
+// FeatureCompressedSlices, so readers predating it reject them instead of misreading.
+// Slices added with AddDomainStream are always written uncompressed.
+func (w *SliceWriter) EnableSliceCompression(minSize int) {
+	if minSize < 1 {
+		minSize = 1
+	}
+	w.compressMin = minSize
+}
+
+// ExpireLastSlice makes readers skip the most recently added slice from at on, so
+// temporary rules (e.g. an event-specific block) age out without a new release.
+// Expiries are stored in an expiry table slice declared by the optional
+// FeatureSliceExpiry; readers predating it apply the slice forever.
+func (w *SliceWriter) ExpireLastSlice(at time.Time) error {
+	if len(w.slices) == 0 {
+		return fmt.Errorf("no slice to expire")
+	}
+	if at.Unix() <= 0 {
+		return fmt.Errorf("invalid expiry %s", at)
+	}
+	w.slices[len(w.slices)-1].expires = at.Unix()
+	return nil
+}
+
+// SetLastSliceDNSHints attaches DNS hints to the most recently added slice, which must
+// be a domain slice, e.g. DNSHintRealIP for domains that break with fake IPs. Hints are
+// stored in a DNS hint table slice declared by the optional FeatureDNSHints; readers
+// predating it ignore them.
+func (w *SliceWriter) SetLastSliceDNSHints(hints DNSHint) error {
+	if len(w.slices) == 0 {
+		return fmt.Errorf("no slice to set DNS hints on")
+	}
+	last := &w.slices[len(w.slices)-1]
+	if !SliceType(last.sliceType).IsDomain() {
+		return fmt.Errorf("DNS hints require a domain slice, last slice is %s", SliceType(last.sliceType))
+	}
+	if hints&^KnownDNSHints != 0 {
+		return fmt.Errorf("unknown DNS hints %#x", uint8(hints&^KnownDNSHints))
+	}
+	if hints&DNSHintFakeIP != 0 && hints&DNSHintRealIP != 0 {
+		return fmt.Errorf("DNS hints FakeIP and RealIP are exclusive")
+	}
+	last.hints = hints
+	return nil
+}
+
+// dnsHintTable returns the DNS hint table slice of the slices (nil = no hints)
+func dnsHintTable(slices []sliceRecord) *sliceRecord {
+	var data []byte
+	var count uint32
+	for i, s := range slices {
+		if s.hints == 0 {
+			continue
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(i))
+		data = append(data, byte(s.hints), 0, 0, 0)
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return &sliceRecord{sliceType: uint8(SliceTypeDNSHints), data: data, count: count}
+}
+
+// expiryTable returns the expiry table slice of the slices (nil = none expire)
+func expiryTable(slices []sliceRecord) *sliceRecord {
+	var data []byte
+	var count uint32
+	for i, s := range slices {
+		if s.expires == 0 {
+			continue
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(i))
+		data = binary.LittleEndian.AppendUint64(data, uint64(s.expires))
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return &sliceRecord{sliceType: uint8(SliceTypeExpiry), data: data, count: count}
+}
+
+// encodedSlices returns the slices as written, compressing them if enabled
+func (w *SliceWriter) encodedSlices() ([]sliceRecord, Feature, error) {
+	if w.compressMin == 0 {
+		return w.slices, 0, nil
+	}
+	var features Feature
+	out := make([]sliceRecord, len(w.slices))
+	for i, s := range w.slices {
+		out[i] = s
+		if s.stream != nil || len(s.data) < w.compressMin {
+			continue
+		}
+		compressed, err := compressSlice(s.data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to compress slice %d: %w", i, err)
+		}
+		if len(compressed) < len(s.data) {
+			out[i].data = compressed
+			out[i].flags |= SliceFlagCompressed
+			features |= FeatureCompressedSlices
+		}
+	}
+	return out, features, nil
+}
+
+// needsVersion2 reports whether slices must be written as format version 2:
+// version 1 readers skip slice types they don't know and ignore slice flags, so
+// they would silently miss the rules of such slices instead of rejecting the file.
+func needsVersion2(slices []sliceRecord) bool {
+	for _, s := range slices {
+		if s.flags != 0 || SliceType(s.sliceType) > SliceTypeExactIPv6 {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRawSlice appends a slice with pre-encoded data, e.g. an extension slice type.
+// flags may include SliceFlagRequired to make readers that don't know sliceType reject the file.
+func (w *SliceWriter) AddRawSlice(sliceType SliceType, target, flags uint8, data []byte, count uint32) {
+	w.slices = append(w.slices, sliceRecord{
+		sliceType: uint8(sliceType),
+		target:    target,
+		flags:     flags,
+		data:      data,
+		count:     count,
+	})
+}
+
 // Build assembles the full binary file: header (64 bytes) + slice index (16 bytes each) + slice data.
 // Returns the complete binary representation.
 func (w *SliceWriter) Build() ([]byte, error) {
-	sliceCount := uint32(len(w.slices))
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes the full binary file to out. Slices added with AddDomainStream
+// are copied from their spool files, so files far larger than memory can be written.
+func (w *SliceWriter) WriteTo(out io.Writer) (int64, error) {
+	slices, compressed, err := w.encodedSlices()
+	if err != nil {
+		return 0, err
+	}
+	required := w.required | compressed
+	optional := w.optional
+	if table := expiryTable(slices); table != nil {
+		slices = append(slices[:len(slices):len(slices)], *table)
+		optional |= FeatureSliceExpiry
+	}
+	if table := dnsHintTable(slices); table != nil {
+		slices = append(slices[:len(slices):len(slices)], *table)
+		optional |= FeatureDNSHints
+	}
+	sliceCount := uint32(len(slices))
 
 	// Calculate offsets for each slice data section
 	// Data begins after header + slice index
-	dataStart := uint32(HeaderSize) + sliceCount*uint32(EntrySize)
+	dataStart := int64(HeaderSize) + int64(sliceCount)*int64(EntrySize)
 
 	offsets := make([]uint32, sliceCount)
+	sizes := make([]uint32, sliceCount)
 	currentOffset := dataStart
-	for i, s := range w.slices {
-		offsets[i] = currentOffset
-		currentOffset += uint32(len(s.data))
+	for i, s := range slices {
+		size := int64(len(s.data))
+		if s.stream != nil {
+			size = s.stream.Size()
+		}
+		if currentOffset+size > math.MaxUint32 {
+			return 0, fmt.Errorf("file exceeds 4 GiB at slice %d", i)
+		}
+		offsets[i] = uint32(currentOffset)
+		sizes[i] = uint32(size)
+		currentOffset += size
 	}
 
-	totalSize := int(currentOffset)
-	out := make([]byte, totalSize)
+	head := make([]byte, dataStart)
 
 	// --- Write header (64 bytes) ---
 	// Magic [8]byte
-	copy(out[0:8], Magic)
-	// Version uint32 LE
-	binary.LittleEndian.PutUint32(out[8:12], FormatVersion)
+	copy(head[0:8], Magic)
+	// Version uint32 LE (feature bits, flagged slices and newer slice types need version 2)
+	version := uint32(1)
+	if required != 0 || optional != 0 || needsVersion2(slices) {
+		version = 2
+	}
+	binary.LittleEndian.PutUint32(head[8:12], version)
 	// SliceCount uint32 LE
-	binary.LittleEndian.PutUint32(out[12:16], sliceCount)
+	binary.LittleEndian.PutUint32(head[12:16], sliceCount)
 	// FallbackTarget uint8
-	out[16] = w.fallbackTarget
+	head[16] = w.fallbackTarget
 	// Reserved [3]byte at 17..19 (already zero)
 	// Timestamp int64 LE at 20..27
 	ts := time.Now().Unix()
-	binary.LittleEndian.PutUint64(out[20:28], uint64(ts))
+	if !w.buildTime.IsZero() {
+		ts = w.buildTime.Unix()
+	}
+	binary.LittleEndian.PutUint64(head[20:28], uint64(ts))
 	// Checksum [16]byte at 28..43 (zero for now — reserved for future use)
-	// Reserved [16]byte at 44..59 (already zero)
+	// Required/Optional features uint32 LE at 44..51
+	binary.LittleEndian.PutUint32(head[44:48], uint32(required))
+	binary.LittleEndian.PutUint32(head[48:52], uint32(optional))
+	// Reserved [8]byte at 52..59 (already zero)
 
 	// --- Write slice index (16 bytes per entry) ---
-	for i, s := range w.slices {
+	for i, s := range slices {
 		base := HeaderSize + i*EntrySize
-		out[base] = s.sliceType          // SliceType uint8
-		out[base+1] = s.target           // Target uint8
-		// Reserved [2]byte at base+2..base+3 (zero)
-		binary.LittleEndian.PutUint32(out[base+4:base+8], offsets[i])   // Offset uint32
-		binary.LittleEndian.PutUint32(out[base+8:base+12], uint32(len(s.data))) // Size uint32
-		binary.LittleEndian.PutUint32(out[base+12:base+16], s.count)    // Count uint32
+		head[base] = s.sliceType // SliceType uint8
+		head[base+1] = s.target  // Target uint8
+		head[base+2] = s.flags   // Flags uint8
+		// Reserved [1]byte at base+3 (zero)
+		binary.LittleEndian.PutUint32(head[base+4:base+8], offsets[i])  // Offset uint32
+		binary.LittleEndian.PutUint32(head[base+8:base+12], sizes[i])   // Size uint32
+		binary.LittleEndian.PutUint32(head[base+12:base+16], s.count)   // Count uint32
+	}
+
+	n, err := out.Write(head)
+	written := int64(n)
+	if err != nil {
+		return written, err
 	}
 
 	// --- Write slice data ---
-	for i, s := range w.slices {
-		start := int(offsets[i])
-		copy(out[start:start+len(s.data)], s.data)
+	for i, s := range slices {
+		if s.stream != nil {
+			m, err := s.stream.WriteTo(out)
+			written += m
+			if err != nil {
+				return written, fmt.Errorf("failed to write slice %d: %w", i, err)
+			}
+			continue
+		}
+		n, err := out.Write(s.data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
 	}
 
-	return out, nil
+	return written, nil
 }
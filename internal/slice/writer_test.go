@@ -1,9 +1,11 @@
 package slice
 
 import (
+	"bytes"
 	"encoding/binary"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestSliceWriterEmpty verifies an empty writer produces a valid 64-byte header-only file.
@@ -599,6 +601,28 @@ func TestSliceWriterHeaderTimestampNonZero(t *testing.T) {
 	}
 }
 
+// TestSliceWriterSetBuildTime verifies SetBuildTime makes the output reproducible.
+func TestSliceWriterSetBuildTime(t *testing.T) {
+	build := func() []byte {
+		w := NewSliceWriter(1)
+		w.SetBuildTime(time.Unix(1700000000, 0))
+		w.AddDomainSlice([]string{"example.com"}, 2)
+		data, err := w.Build()
+		if err != nil {
+			t.Fatalf("Build() error: %v", err)
+		}
+		return data
+	}
+
+	data := build()
+	if got := int64(binary.LittleEndian.Uint64(data[20:28])); got != 1700000000 {
+		t.Errorf("timestamp = %d, want 1700000000", got)
+	}
+	if !bytes.Equal(build(), data) {
+		t.Error("builds with the same build time differ")
+	}
+}
+
 // TestSliceWriterOffsetAfterIndex verifies slice data offsets start after the slice index.
 func TestSliceWriterOffsetAfterIndex(t *testing.T) {
 	w := NewSliceWriter(0)
@@ -0,0 +1,163 @@
+// Package statefile persists JSON state in the cache directory (user overlay,
+// subscription stamps, …) with a schema version, so library upgrades migrate
+// older files forward instead of misreading them, and downgrades never overwrite
+// files written by a newer version.
+//
+// A state file is an envelope around the state:
+//
+//	{"schema": "overlay", "version": 1, "data": {...}}
+//
+// Files written before versioning (the bare state, without envelope) are version 0.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kaitu-io/k2rule/internal/filelock"
+)
+
+// ErrNewerVersion is returned (wrapped) for files written by a newer schema
+// version: by Load, whose decoded state is then best-effort, and by Save, which
+// leaves such files untouched.
+var ErrNewerVersion = errors.New("state file written by a newer version")
+
+// Migration upgrades the data of a state file by one version
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// Schema describes a kind of state file. Migrations[i] upgrades version i to
+// i+1, so the current version is len(Migrations); a schema whose format hasn't
+// changed since versioning has one migration, Identity, from the bare files.
+type Schema struct {
+	Name       string
+	Migrations []Migration
+
+	// Cache marks state that can be rebuilt (e.g. download stamps): Save replaces
+	// files of other versions without ErrNewerVersion or backups
+	Cache bool
+}
+
+// Identity is a Migration that keeps the data unchanged
+func Identity(data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
+
+// envelope is the persisted form of a state file
+type envelope struct {
+	Schema  string          `json:"schema"`
+	Version *int            `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Version returns the current version of the schema
+func (s Schema) Version() int {
+	return len(s.Migrations)
+}
+
+// Load decodes the state file at path into v, migrating older versions in memory.
+// found is false (and v untouched) when the file doesn't exist.
+func (s Schema) Load(path string, v any) (found bool, err error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	version, data := s.decode(raw)
+	var newer error
+	if version > s.Version() {
+		newer = fmt.Errorf("%s: %w (%d > %d)", filepath.Base(path), ErrNewerVersion, version, s.Version())
+	} else {
+		for ; version < s.Version(); version++ {
+			if data, err = s.Migrations[version](data); err != nil {
+				return true, fmt.Errorf("%s: migrating from version %d: %w", filepath.Base(path), version, err)
+			}
+		}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return true, newer
+}
+
+// decode returns the version and data of a state file
+func (s Schema) decode(raw []byte) (int, json.RawMessage) {
+	var env envelope
+	if json.Unmarshal(raw, &env) == nil && env.Schema == s.Name && env.Version != nil {
+		return *env.Version, env.Data
+	}
+	return 0, raw
+}
+
+// Save writes v to path with the current version, atomically (temporary file and
+// rename, holding the lock on path + ".lock"). An older-version file it replaces
+// is first copied to path + ".v<version>.bak" (the first backup of each version is
+// kept), so user data survives a faulty migration. Returns ErrNewerVersion rather
+// than overwrite a file of a newer version.
+func (s Schema) Save(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	version := s.Version()
+	out, err := json.MarshalIndent(envelope{Schema: s.Name, Version: &version, Data: data}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	lock, err := filelock.Acquire(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if raw, err := os.ReadFile(path); err == nil && !s.Cache {
+		switch old, _ := s.decode(raw); {
+		case old > version:
+			return fmt.Errorf("%s: %w (%d > %d)", filepath.Base(path), ErrNewerVersion, old, version)
+		case old < version:
+			if err := backup(path, raw, old); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(out)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// backup copies the file of an older version to path + ".v<version>.bak" unless a
+// backup of that version exists
+func backup(path string, raw []byte, version int) error {
+	bak := fmt.Sprintf("%s.v%d.bak", path, version)
+	if _, err := os.Stat(bak); err == nil {
+		return nil
+	}
+	if err := os.WriteFile(bak, raw, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// renameField returns a migration renaming a top-level field
+func renameField(from, to string) Migration {
+	return func(data json.RawMessage) (json.RawMessage, error) {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m[to] = m[from]
+		delete(m, from)
+		return json.Marshal(m)
+	}
+}
+
+type state struct {
+	Domains []string `json:"domains"`
+}
+
+func TestSchema_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	schema := Schema{Name: "test", Migrations: []Migration{Identity}}
+
+	var got state
+	if found, err := schema.Load(path, &got); found || err != nil {
+		t.Fatalf("Load(missing) = %v, %v, want not found", found, err)
+	}
+
+	want := state{Domains: []string{"a.example"}}
+	if err := schema.Save(path, want); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	raw, _ := os.ReadFile(path)
+	if !strings.Contains(string(raw), `"schema": "test"`) || !strings.Contains(string(raw), `"version": 1`) {
+		t.Errorf("saved file = %s, want envelope with schema and version", raw)
+	}
+	if found, err := schema.Load(path, &got); !found || err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v, %v, %v, want %+v", got, found, err, want)
+	}
+}
+
+func TestSchema_Migrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	legacy := []byte(`{"hosts": ["a.example", "b.example"]}`)
+	if err := os.WriteFile(path, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 0 (bare) → 1 (envelope) → 2 (hosts renamed to domains)
+	schema := Schema{Name: "test", Migrations: []Migration{Identity, renameField("hosts", "domains")}}
+	var got state
+	if found, err := schema.Load(path, &got); !found || err != nil {
+		t.Fatalf("Load(legacy) = %v, %v", found, err)
+	}
+	if want := []string{"a.example", "b.example"}; !reflect.DeepEqual(got.Domains, want) {
+		t.Errorf("migrated domains = %v, want %v", got.Domains, want)
+	}
+
+	// Loading doesn't write; the first Save backs up the legacy file once
+	if _, err := os.Stat(path + ".v0.bak"); !os.IsNotExist(err) {
+		t.Errorf("Load wrote a backup: %v", err)
+	}
+	if err := schema.Save(path, got); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if bak, err := os.ReadFile(path + ".v0.bak"); err != nil || string(bak) != string(legacy) {
+		t.Errorf("backup = %s, %v, want the legacy file", bak, err)
+	}
+
+	// An envelope of version 1 migrates from 1
+	v1 := []byte(`{"schema": "test", "version": 1, "data": {"hosts": ["c.example"]}}`)
+	if err := os.WriteFile(path, v1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got = state{}
+	if _, err := schema.Load(path, &got); err != nil || len(got.Domains) != 1 || got.Domains[0] != "c.example" {
+		t.Errorf("Load(v1) = %+v, %v, want [c.example]", got, err)
+	}
+
+	// A failing migration is reported
+	failing := Schema{Name: "test", Migrations: []Migration{Identity, func(json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("boom")
+	}}}
+	if _, err := failing.Load(path, &got); err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("Load with failing migration = %v, want error", err)
+	}
+}
+
+func TestSchema_NewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	newer := []byte(`{"schema": "test", "version": 5, "data": {"domains": ["a.example"], "extra": true}}`)
+	if err := os.WriteFile(path, newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := Schema{Name: "test", Migrations: []Migration{Identity}}
+	var got state
+	found, err := schema.Load(path, &got)
+	if !found || !errors.Is(err, ErrNewerVersion) {
+		t.Fatalf("Load(newer) = %v, %v, want ErrNewerVersion", found, err)
+	}
+	if len(got.Domains) != 1 {
+		t.Errorf("best-effort state = %+v, want the known fields", got)
+	}
+	if err := schema.Save(path, got); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Save over newer = %v, want ErrNewerVersion", err)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != string(newer) {
+		t.Errorf("newer file was modified: %s", raw)
+	}
+
+	// Caches are replaced
+	cache := Schema{Name: "test", Migrations: []Migration{Identity}, Cache: true}
+	if err := cache.Save(path, got); err != nil {
+		t.Errorf("Save(cache) over newer = %v", err)
+	}
+}
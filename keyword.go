@@ -0,0 +1,95 @@
+package k2rule
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/kaitu-io/k2rule/keyword"
+)
+
+// KeywordRule routes every domain containing Keyword (ASCII case-insensitive) to
+// Target, like a Clash DOMAIN-KEYWORD rule
+type KeywordRule struct {
+	Keyword string `json:"keyword"`
+	Target  Target `json:"target"`
+}
+
+// globalKeywords holds the keyword rules consulted by Match (nil = none)
+var globalKeywords atomic.Pointer[keywordRules]
+
+// keywordRules are compiled keyword rules
+type keywordRules struct {
+	rules   []KeywordRule
+	matcher *keyword.Matcher
+}
+
+// SetKeywordRules replaces the keyword rules consulted by Match, e.g. corporate DLP
+// terms in hostnames. All keywords are matched in one pass (keyword.Matcher); when
+// several occur in a domain, the first rule in the list wins. Keyword rules are
+// checked after category policies and before global mode and rule files, so they
+// apply in global mode too. Config.KeywordRules sets them at Init; nil removes them.
+//
+// Example:
+//
+//	k2rule.SetKeywordRules([]k2rule.KeywordRule{
+//	    {Keyword: "payroll", Target: k2rule.TargetReject},
+//	    {Keyword: "corp-vpn", Target: k2rule.TargetDirect},
+//	})
+func SetKeywordRules(rules []KeywordRule) error {
+	compiled, err := compileKeywordRules(rules)
+	if err != nil {
+		return err
+	}
+
+	defaultEngine.mu.Lock()
+	defer defaultEngine.mu.Unlock()
+	globalKeywords.Store(compiled)
+	if defaultEngine.config != nil {
+		defaultEngine.config.KeywordRules = compiled.list()
+	}
+	return nil
+}
+
+// KeywordRules returns the keyword rules consulted by Match
+func KeywordRules() []KeywordRule {
+	return globalKeywords.Load().list()
+}
+
+// compileKeywordRules validates and compiles rules (nil for none)
+func compileKeywordRules(rules []KeywordRule) (*keywordRules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	keywords := make([]string, len(rules))
+	for i, rule := range rules {
+		if rule.Keyword == "" {
+			return nil, fmt.Errorf("keyword rule %d: keyword is required", i)
+		}
+		keywords[i] = rule.Keyword
+	}
+	matcher, err := keyword.New(keywords)
+	if err != nil {
+		return nil, err
+	}
+	return &keywordRules{rules: append([]KeywordRule(nil), rules...), matcher: matcher}, nil
+}
+
+// list returns a copy of the rules (nil for none)
+func (k *keywordRules) list() []KeywordRule {
+	if k == nil {
+		return nil
+	}
+	return append([]KeywordRule(nil), k.rules...)
+}
+
+// match returns the rule of the first keyword occurring in domain
+func (k *keywordRules) match(domain string) (KeywordRule, bool) {
+	if k == nil {
+		return KeywordRule{}, false
+	}
+	i, ok := k.matcher.Match(domain)
+	if !ok {
+		return KeywordRule{}, false
+	}
+	return k.rules[i], true
+}
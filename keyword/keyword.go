@@ -0,0 +1,162 @@
+// Package keyword matches a list of keywords against short strings such as
+// hostnames in a single pass, with an Aho–Corasick automaton. It is the engine of
+// the porn heuristics' word lists and of k2rule's keyword rules
+// (k2rule.SetKeywordRules, k2rule.SetCategoryKeywords), and can be used on its
+// own, e.g. to flag corporate DLP terms in hostnames:
+//
+//	m, _ := keyword.New([]string{"payroll", "secret-project"})
+//	if i, ok := m.Match("payroll-export.example.com"); ok {
+//	    log.Printf("hostname contains %q", m.Keyword(i))
+//	}
+//
+// ASCII letters match case-insensitively; other bytes match exactly. A Matcher is
+// immutable and safe for concurrent use; lookups don't allocate.
+package keyword
+
+import "fmt"
+
+// Matcher reports which of its keywords occur in a string.
+//
+// Failure links are folded into a dense transition table over the input classes
+// (one class per byte used by the keywords, class 0 for all other bytes), so each
+// input byte costs one table lookup.
+type Matcher struct {
+	keywords []string
+	classes  [256]uint8 // byte → input class
+	width    int        // number of input classes
+	next     []int32    // state*width + class → next state
+	out      [][]int32  // state → indexes of the keywords ending there (incl. via failure links)
+}
+
+// New compiles keywords; a keyword's index is its position in the list.
+// Returns an error for an empty keyword.
+func New(keywords []string) (*Matcher, error) {
+	m := &Matcher{keywords: append([]string(nil), keywords...), width: 1}
+	for i, k := range keywords {
+		if k == "" {
+			return nil, fmt.Errorf("keyword %d is empty", i)
+		}
+		for j := 0; j < len(k); j++ {
+			c := lower(k[j])
+			if m.classes[c] == 0 {
+				if m.width == 256 {
+					return nil, fmt.Errorf("keywords use more than 255 distinct bytes")
+				}
+				m.classes[c] = uint8(m.width)
+				m.width++
+			}
+		}
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		m.classes[c] = m.classes[c+'a'-'A']
+	}
+
+	// Trie (0 = no transition; the root is state 0 and never a target)
+	m.next = make([]int32, m.width)
+	m.out = [][]int32{nil}
+	for i, k := range keywords {
+		state := int32(0)
+		for j := 0; j < len(k); j++ {
+			slot := int(state)*m.width + int(m.classes[k[j]])
+			if m.next[slot] == 0 {
+				m.next[slot] = int32(len(m.out))
+				m.next = append(m.next, make([]int32, m.width)...)
+				m.out = append(m.out, nil)
+			}
+			state = m.next[slot]
+		}
+		m.out[state] = append(m.out[state], int32(i))
+	}
+
+	// Breadth-first: missing transitions follow the failure link, whose outputs
+	// are inherited
+	fail := make([]int32, len(m.out))
+	queue := make([]int32, 0, len(m.out))
+	for class := 0; class < m.width; class++ {
+		if s := m.next[class]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for class := 0; class < m.width; class++ {
+			slot := int(state)*m.width + class
+			fallback := m.next[int(fail[state])*m.width+class]
+			if s := m.next[slot]; s != 0 {
+				fail[s] = fallback
+				m.out[s] = append(m.out[s], m.out[fallback]...)
+				queue = append(queue, s)
+			} else {
+				m.next[slot] = fallback
+			}
+		}
+	}
+	return m, nil
+}
+
+// MustNew is like New but panics on error, for keyword lists known at compile time
+func MustNew(keywords []string) *Matcher {
+	m, err := New(keywords)
+	if err != nil {
+		panic("keyword: " + err.Error())
+	}
+	return m
+}
+
+// Len returns the number of keywords
+func (m *Matcher) Len() int {
+	return len(m.keywords)
+}
+
+// Keyword returns the keyword with index i
+func (m *Matcher) Keyword(i int) string {
+	return m.keywords[i]
+}
+
+// Contains reports whether any keyword occurs in s
+func (m *Matcher) Contains(s string) bool {
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		state = m.next[int(state)*m.width+int(m.classes[s[i]])]
+		if len(m.out[state]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the lowest index of the keywords occurring in s, so earlier
+// keywords take precedence like rules in a list
+func (m *Matcher) Match(s string) (int, bool) {
+	best := -1
+	m.Scan(s, func(index, _ int) bool {
+		if best < 0 || index < best {
+			best = index
+		}
+		return best != 0
+	})
+	return best, best >= 0
+}
+
+// Scan calls fn with the index and end offset of each keyword occurrence in s, in
+// order of end offset, until fn returns false
+func (m *Matcher) Scan(s string, fn func(index, end int) bool) {
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		state = m.next[int(state)*m.width+int(m.classes[s[i]])]
+		for _, index := range m.out[state] {
+			if !fn(int(index), i+1) {
+				return
+			}
+		}
+	}
+}
+
+// lower returns the lowercase form of an ASCII letter (other bytes unchanged)
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
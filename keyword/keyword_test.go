@@ -0,0 +1,114 @@
+package keyword
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatcher_Scan(t *testing.T) {
+	keywords := []string{"he", "she", "his", "hers", "x-y"}
+	m := MustNew(keywords)
+
+	type match struct {
+		keyword string
+		end     int
+	}
+	collect := func(s string) []match {
+		var got []match
+		m.Scan(s, func(index, end int) bool {
+			got = append(got, match{m.Keyword(index), end})
+			return true
+		})
+		return got
+	}
+
+	// Overlapping matches through failure links, in end order
+	want := []match{{"she", 4}, {"he", 4}, {"hers", 6}}
+	if got := collect("ushers"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan(ushers) = %v, want %v", got, want)
+	}
+	// ASCII case folding, separators and bytes outside the keywords
+	want = []match{{"his", 3}, {"x-y", 8}}
+	if got := collect("HIS.\xffX-Y"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan(HIS.\\xffX-Y) = %v, want %v", got, want)
+	}
+	if got := collect("example.com"); got != nil {
+		t.Errorf("Scan(example.com) = %v, want none", got)
+	}
+
+	// Stops when fn returns false
+	n := 0
+	m.Scan("hehehe", func(int, int) bool { n++; return false })
+	if n != 1 {
+		t.Errorf("Scan called fn %d times after it returned false, want 1", n)
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	m := MustNew([]string{"payroll", "pay", "secret", "roll"})
+	if m.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", m.Len())
+	}
+	tests := []struct {
+		input string
+		index int // -1 = no match
+	}{
+		{"payroll.corp.example", 0},
+		{"rollpay.example", 1}, // lowest index wins, not the first occurrence
+		{"top-SECRET.example", 2},
+		{"example.com", -1},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		index, ok := m.Match(tt.input)
+		if !ok {
+			index = -1
+		}
+		if index != tt.index {
+			t.Errorf("Match(%q) = %d, want %d", tt.input, index, tt.index)
+		}
+		if m.Contains(tt.input) != (tt.index >= 0) {
+			t.Errorf("Contains(%q) = %v, want %v", tt.input, !(tt.index >= 0), tt.index >= 0)
+		}
+	}
+
+	if _, err := New([]string{"ok", ""}); err == nil {
+		t.Error("New() accepted an empty keyword")
+	}
+	empty := MustNew(nil)
+	if empty.Contains("anything") {
+		t.Error("empty Matcher matched")
+	}
+}
+
+func TestMatcher_MatchesContains(t *testing.T) {
+	keywords := []string{"sex", "sexy", "teen", "teens", "anal", "cam", "webcam", "ass", "sussex", "x.y", "a_b"}
+	m := MustNew(keywords)
+	inputs := []string{"google.com", "freelivesexcams.net", "teenmilfs.org", "Sussex-Web.Cam", "analyst.io", "x.y.a_b", "classroom"}
+	for _, input := range inputs {
+		found := map[string]bool{}
+		m.Scan(input, func(index, _ int) bool {
+			found[keywords[index]] = true
+			return true
+		})
+		for _, k := range keywords {
+			if want := strings.Contains(strings.ToLower(input), k); found[k] != want {
+				t.Errorf("Scan(%s) found %q = %v, want %v", input, k, found[k], want)
+			}
+		}
+	}
+}
+
+func BenchmarkMatcher_Match(b *testing.B) {
+	keywords := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keywords = append(keywords, "term"+strings.Repeat(string(rune('a'+i%26)), 1+i/26))
+	}
+	m := MustNew(keywords)
+	hosts := []string{"api.weather.example.org", "cdn.assets.microsoft-online.com", "termzzz.example.com"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Match(hosts[i%len(hosts)])
+	}
+}
@@ -0,0 +1,83 @@
+package k2rule
+
+import "testing"
+
+func TestSetKeywordRules_Match(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if err := SetKeywordRules([]KeywordRule{
+		{Keyword: "payroll", Target: TargetReject},
+		{Keyword: "pay", Target: TargetProxy},
+		{Keyword: "corp-vpn", Target: TargetDirect},
+	}); err != nil {
+		t.Fatalf("SetKeywordRules failed: %v", err)
+	}
+	tests := []struct {
+		input   string
+		target  Target
+		keyword string
+	}{
+		{"payroll-export.example.com", TargetReject, "payroll"},
+		{"Pay.Example.com", TargetProxy, "pay"},
+		{"rollpay-payroll.example", TargetReject, "payroll"}, // first rule wins
+		{"eu.corp-vpn.example", TargetDirect, "corp-vpn"},
+		{"example.com", TargetDirect, ""}, // no rules loaded: fallback
+	}
+	for _, tt := range tests {
+		result := MatchVerbose(tt.input)
+		if result.Target != tt.target || result.Keyword != tt.keyword {
+			t.Errorf("MatchVerbose(%s) = %+v, want %s by %q", tt.input, result, tt.target, tt.keyword)
+		}
+	}
+	if got := len(KeywordRules()); got != 3 {
+		t.Errorf("KeywordRules() has %d rules, want 3", got)
+	}
+
+	// TmpRules take precedence; keyword rules apply in global mode
+	SetTmpRule("payroll.example", TargetProxy)
+	if got := Match("payroll.example"); got != TargetProxy {
+		t.Errorf("Match with TmpRule = %s, want PROXY", got)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{IsGlobal: true, GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	if got := Match("eu.corp-vpn.example"); got != TargetDirect {
+		t.Errorf("Match in global mode = %s, want DIRECT", got)
+	}
+	if err := SetKeywordRules(nil); err != nil {
+		t.Fatalf("SetKeywordRules(nil) failed: %v", err)
+	}
+	if got := Match("eu.corp-vpn.example"); got != TargetProxy {
+		t.Errorf("Match after SetKeywordRules(nil) = %s, want global PROXY", got)
+	}
+	if GetConfig().KeywordRules != nil {
+		t.Error("GetConfig().KeywordRules is set after SetKeywordRules(nil)")
+	}
+}
+
+func TestKeywordRules_Config(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	config := &Config{
+		CacheDir:     t.TempDir(),
+		IsGlobal:     true,
+		KeywordRules: []KeywordRule{{Keyword: "casino", Target: TargetReject}},
+	}
+	if err := Init(config); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if got := Match("big-casino.example"); got != TargetReject {
+		t.Errorf("Match(big-casino.example) = %s, want REJECT", got)
+	}
+
+	invalid := Config{CacheDir: "/tmp", KeywordRules: []KeywordRule{{Target: TargetReject}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() accepted an empty keyword")
+	}
+	if err := SetKeywordRules([]KeywordRule{{Target: TargetReject}}); err == nil {
+		t.Error("SetKeywordRules() accepted an empty keyword")
+	}
+}
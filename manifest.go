@@ -0,0 +1,506 @@
+package k2rule
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestCheckInterval is how often the manifest is polled; blobs are only
+// downloaded when their hash changes
+const manifestCheckInterval = 15 * time.Minute
+
+// ManifestEntry describes the current version of one component in an update manifest
+type ManifestEntry struct {
+	URL     string `json:"url"`               // Blob URL, absolute or relative to the manifest URL
+	SHA256  string `json:"sha256"`            // Hex SHA-256 of the blob as served
+	Size    int64  `json:"size,omitempty"`    // Blob size in bytes (0 = not checked)
+	Version string `json:"version,omitempty"` // Informational version label
+}
+
+// Manifest is the update index served at Config.ManifestURL (index.json):
+//
+//	{
+//	  "rules": {"url": "cn_whitelist.k2r.gz", "sha256": "9f86d0...", "size": 5242880, "version": "2024.06.01"},
+//	  "geoip": {"url": "GeoLite2-Country.mmdb.gz", "sha256": "..."},
+//	  "porn":  {"url": "porn_domains.k2r.gz", "sha256": "..."}
+//	}
+//
+// Rule and porn blobs are K2RULEV3 (.k2r.gz); the GeoIP blob is a MaxMind .mmdb,
+// optionally gzip-compressed (.gz suffix).
+type Manifest struct {
+	Rules *ManifestEntry `json:"rules,omitempty"`
+	GeoIP *ManifestEntry `json:"geoip,omitempty"`
+	Porn  *ManifestEntry `json:"porn,omitempty"`
+}
+
+// entry returns the entry of a component (nil if not listed)
+func (m Manifest) entry(component string) *ManifestEntry {
+	switch component {
+	case ComponentRules:
+		return m.Rules
+	case ComponentGeoIP:
+		return m.GeoIP
+	case ComponentPorn:
+		return m.Porn
+	}
+	return nil
+}
+
+// setEntry sets the entry of a component
+func (m *Manifest) setEntry(component string, e *ManifestEntry) {
+	switch component {
+	case ComponentRules:
+		m.Rules = e
+	case ComponentGeoIP:
+		m.GeoIP = e
+	case ComponentPorn:
+		m.Porn = e
+	}
+}
+
+// ManifestManager polls a small update manifest (Config.ManifestURL) every 15 minutes
+// and downloads a component's blob only when its SHA-256 changes. Blobs are verified
+// against the manifest hash and size before they are loaded.
+type ManifestManager struct {
+	url      string
+	cacheDir string
+	dl       *downloader // shared HTTP downloader (auth, timeouts); used for manifest and blobs
+
+	componentSet                // Components loaded from manifest blobs
+	sources      *sourceDomains // Source domains of the engine; blob hosts are added (nil = none)
+
+	// Update metadata
+	mu         sync.RWMutex
+	etag       string   // ETag of the last fully applied manifest
+	applied    Manifest // Entries currently loaded (persisted for restarts)
+	lastUpdate time.Time
+	lastErr    error
+	generation uint64 // Number of successful blob loads
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	flight     flightGroup // deduplicates concurrent checks
+
+	managerBase // ManagerOption settings (interval, logger, validator)
+}
+
+// NewManifestManager creates a manifest manager. Components are attached with the
+// rules/geoIP/porn fields before Init.
+func NewManifestManager(url, cacheDir string, opts ...ManagerOption) *ManifestManager {
+	m := &ManifestManager{
+		url:    url,
+		dl:     newDownloader(120 * time.Second),
+		stopCh: make(chan struct{}),
+	}
+	m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dl, opts)
+	return m
+}
+
+// Init initializes the manager: loads the last applied blobs from cache → checks the manifest if needed → starts polling
+func (m *ManifestManager) Init() error {
+	if !m.readOnly {
+		if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	// 1. Check cache (applied manifest + blobs)
+	if !m.readOnly {
+		migrateLegacyBlobs(m.cacheDir)
+	}
+	if err := m.loadCached(); err == nil {
+		m.logger().Info("manifest components loaded from cache")
+		if !m.readOnly {
+			safeGo("manifest", m.startAutoUpdate)
+		}
+		return nil
+	} else if m.readOnly {
+		m.proxyUntilLoaded()
+		m.logger().Warn("manifest cache not loaded, download skipped, cache is read-only", "error", err)
+		return nil
+	} else if !os.IsNotExist(err) {
+		m.logger().Warn("manifest cache corrupted, will re-download", "error", err)
+	}
+
+	// 2. Check the manifest in background (non-blocking); rules proxy everything until loaded
+	m.proxyUntilLoaded()
+	m.logger().Info("manifest cache not found, downloading in background")
+	safeGo("manifest", func() {
+		retryForever("manifest", m.stopCh, func() error { return m.check(false) })
+		m.startAutoUpdate()
+	})
+
+	return nil
+}
+
+// Stop stops the polling background task
+func (m *ManifestManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// Update manually checks the manifest and downloads changed blobs.
+// Concurrent calls share one check and its result.
+func (m *ManifestManager) Update() error {
+	if m.skipUpdate("manifest") {
+		return nil
+	}
+	return m.check(true)
+}
+
+// Manifest returns the entries currently loaded
+func (m *ManifestManager) Manifest() Manifest {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.applied
+}
+
+// loadCached loads the blobs recorded in the persisted applied manifest
+func (m *ManifestManager) loadCached() error {
+	data, err := os.ReadFile(m.getPath("index.json"))
+	if err != nil {
+		return err
+	}
+	var applied Manifest
+	if err := json.Unmarshal(data, &applied); err != nil {
+		return err
+	}
+
+	for _, c := range []string{ComponentRules, ComponentGeoIP, ComponentPorn} {
+		if !m.has(c) {
+			continue
+		}
+		if applied.entry(c) == nil {
+			return fmt.Errorf("no cached %s", c)
+		}
+		if err := m.load(c, blobPath(m.cacheDir, c, applied.entry(c).SHA256)); err != nil {
+			return fmt.Errorf("failed to load cached %s: %w", c, err)
+		}
+		// Blob hosts stay download sources until the next apply
+		if blobURL, err := m.resolve(applied.entry(c).URL); err == nil {
+			m.sources.add(objectSourceURL(blobURL, m.dl.auth))
+		}
+	}
+
+	m.mu.Lock()
+	m.applied = applied
+	m.generation++
+	m.mu.Unlock()
+	return nil
+}
+
+// check fetches the manifest and applies changed entries
+func (m *ManifestManager) check(useETag bool) error {
+	return m.flight.do("check", func() (err error) {
+		defer func() { m.setLastError(err) }()
+
+		var currentETag string
+		if useETag {
+			currentETag = m.GetETag()
+		}
+
+		m.logger().Debug("checking manifest", "url", redactURL(m.url))
+
+		manifestPath := m.getPath("index.new.json")
+		etag, modified, err := m.dl.fetch(m.url, currentETag, manifestPath, false)
+		if err != nil {
+			return err
+		}
+		if !modified {
+			m.logger().Debug("manifest not modified")
+			return nil
+		}
+		defer os.Remove(manifestPath)
+
+		data, err := os.ReadFile(manifestPath)
+		if err != nil {
+			return err
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid manifest: %w", err)
+		}
+
+		// Persist whatever was applied, even if a later entry fails
+		changed := false
+		defer func() {
+			if !changed {
+				return
+			}
+			if err := m.saveApplied(); err != nil {
+				m.logger().Warn("failed to persist applied manifest", "error", err)
+			}
+		}()
+
+		for _, c := range []string{ComponentRules, ComponentGeoIP, ComponentPorn} {
+			if !m.has(c) {
+				continue
+			}
+			entry := manifest.entry(c)
+			if entry == nil {
+				return fmt.Errorf("manifest has no %s entry", c)
+			}
+			current := m.Manifest().entry(c)
+			if current != nil && strings.EqualFold(current.SHA256, entry.SHA256) {
+				continue
+			}
+			if err := m.apply(c, entry); err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		// Only remember the ETag once every entry is applied, so failures are retried
+		m.mu.Lock()
+		m.etag = etag
+		m.mu.Unlock()
+		return nil
+	})
+}
+
+// apply downloads, verifies and loads one component blob
+func (m *ManifestManager) apply(component string, entry *ManifestEntry) error {
+	blobURL, err := m.resolve(entry.URL)
+	if err != nil {
+		return fmt.Errorf("invalid %s URL: %w", component, err)
+	}
+	want, err := hex.DecodeString(entry.SHA256)
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid %s sha256 %q", component, entry.SHA256)
+	}
+
+	// Blob hosts are download sources too (always DIRECT)
+	m.sources.add(objectSourceURL(blobURL, m.dl.auth))
+
+	// Blobs are cached by content, so a blob already downloaded (e.g. through the
+	// manifest of another mirror) is loaded without downloading it again
+	dest := blobPath(m.cacheDir, component, entry.SHA256)
+	if _, err := os.Stat(dest); err == nil {
+		if err := m.load(component, dest); err == nil {
+			m.setApplied(component, entry)
+			m.logger().Info("manifest blob loaded from cache", "component", component, "version", entry.Version)
+			return nil
+		}
+		m.logger().Warn("cached manifest blob corrupted, will re-download", "component", component)
+	}
+
+	m.logger().Debug("downloading manifest blob", "component", component, "url", redactURL(blobURL), "version", entry.Version)
+
+	downloadPath := m.getPath(bundleMemberFiles[component] + ".download")
+	if _, _, err := m.dl.fetch(blobURL, "", downloadPath, false); err != nil {
+		return fmt.Errorf("failed to download %s: %w", component, err)
+	}
+	defer os.Remove(downloadPath)
+
+	if err := verifyBlob(downloadPath, want, entry.Size); err != nil {
+		return fmt.Errorf("%s blob rejected: %w", component, err)
+	}
+	if m.validator != nil {
+		if err := m.validator(downloadPath); err != nil {
+			return fmt.Errorf("%s blob rejected by validator: %w", component, err)
+		}
+	}
+
+	// GeoIP is opened as a plain .mmdb; K2RULEV3 files are loaded gzip-compressed
+	gunzip := component == ComponentGeoIP && strings.HasSuffix(blobURL, ".gz")
+	if err := installFile(downloadPath, dest, gunzip); err != nil {
+		return err
+	}
+	if err := m.load(component, dest); err != nil {
+		return fmt.Errorf("failed to load %s: %w", component, err)
+	}
+
+	m.setApplied(component, entry)
+	m.logger().Info("manifest blob downloaded and loaded", "component", component, "version", entry.Version)
+	return nil
+}
+
+// setApplied records entry as the loaded blob of component and removes the cached
+// blob it replaces
+func (m *ManifestManager) setApplied(component string, entry *ManifestEntry) {
+	e := *entry
+	m.mu.Lock()
+	previous := m.applied.entry(component)
+	m.applied.setEntry(component, &e)
+	m.lastUpdate = time.Now()
+	m.generation++
+	m.mu.Unlock()
+
+	if previous != nil && !strings.EqualFold(previous.SHA256, entry.SHA256) {
+		os.Remove(blobPath(m.cacheDir, component, previous.SHA256))
+	}
+}
+
+// resolve resolves a blob URL relative to the manifest URL
+func (m *ManifestManager) resolve(ref string) (string, error) {
+	base, err := url.Parse(m.url)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// saveApplied persists the applied manifest so restarts don't re-download unchanged blobs
+func (m *ManifestManager) saveApplied() error {
+	data, err := json.Marshal(m.Manifest())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.getPath("index.json"), bytes.NewReader(data))
+}
+
+// verifyBlob checks the SHA-256 (and size, if > 0) of a downloaded file
+func verifyBlob(path string, want []byte, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size > 0 && n != size {
+		return fmt.Errorf("size mismatch: got %d bytes, want %d", n, size)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("sha256 mismatch: got %x, want %x", got, want)
+	}
+	return nil
+}
+
+// installFile copies src to dest atomically, decompressing it when gunzip is true
+func installFile(src, dest string, gunzip bool) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gunzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return writeFileAtomic(dest, r)
+}
+
+// startAutoUpdate polls the manifest (every 15 minutes)
+func (m *ManifestManager) startAutoUpdate() {
+	m.autoUpdate(ComponentManifest, manifestCheckInterval, m.stopCh, m.Update)
+}
+
+// getPath returns a cache file path for this manifest (based on URL hash)
+func (m *ManifestManager) getPath(name string) string {
+	hash := sha256.Sum256([]byte(m.url))
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x.manifest.%s", hash[:8], name))
+}
+
+// blobPath returns the cache path of a component blob. Blobs are keyed by their
+// manifest SHA-256 rather than the manifest URL, so switching to a manifest mirror
+// serving the same content doesn't download it again. Only manifest blobs are keyed
+// this way: without a manifest there is no hash to key by before downloading, so the
+// RuleURL/GeoIPURL/PornURL caches stay keyed by URL.
+func blobPath(cacheDir, component, sha string) string {
+	key := strings.ToLower(sha)
+	if len(key) > 16 {
+		key = key[:16]
+	}
+	return filepath.Join(cacheDir, fmt.Sprintf("%s.%s", key, bundleMemberFiles[component]))
+}
+
+// migrateLegacyBlobs moves manifest blobs cached by earlier versions under the
+// manifest URL hash ("<url hash>.manifest.<member>") to their content-keyed path (see
+// blobPath), for every applied manifest found in cacheDir. Caches of the other
+// sources are not migrated.
+func migrateLegacyBlobs(cacheDir string) {
+	indexes, _ := filepath.Glob(filepath.Join(cacheDir, "*.manifest.index.json"))
+	for _, index := range indexes {
+		data, err := os.ReadFile(index)
+		if err != nil {
+			continue
+		}
+		var applied Manifest
+		if err := json.Unmarshal(data, &applied); err != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(index, "index.json")
+		for _, c := range []string{ComponentRules, ComponentGeoIP, ComponentPorn} {
+			entry := applied.entry(c)
+			if entry == nil {
+				continue
+			}
+			legacy := prefix + bundleMemberFiles[c]
+			if _, err := os.Stat(legacy); err != nil {
+				continue
+			}
+			if _, err := os.Stat(blobPath(cacheDir, c, entry.SHA256)); err == nil {
+				os.Remove(legacy) // already migrated through another manifest
+				continue
+			}
+			os.Rename(legacy, blobPath(cacheDir, c, entry.SHA256))
+		}
+	}
+}
+
+// GetETag returns the ETag of the last fully applied manifest
+func (m *ManifestManager) GetETag() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.etag
+}
+
+// GetLastUpdate returns the time a blob was last downloaded and loaded
+func (m *ManifestManager) GetLastUpdate() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastUpdate
+}
+
+// GetLastError returns the error of the most recent manifest check (nil after success)
+func (m *ManifestManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Status returns a snapshot of the manifest state
+func (m *ManifestManager) Status() ComponentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return ComponentInfo{
+		Name:       ComponentManifest,
+		Source:     m.url,
+		Loaded:     m.generation > 0,
+		Generation: m.generation,
+		LastUpdate: m.lastUpdate,
+		LastError:  errorString(m.lastErr),
+		ReadOnly:   m.readOnly,
+	}
+}
+
+// setLastError records the outcome of a manifest check
+func (m *ManifestManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
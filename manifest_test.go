@@ -0,0 +1,260 @@
+package k2rule
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// manifestTestServer serves index.json and versioned rule blobs
+type manifestTestServer struct {
+	mu       sync.Mutex
+	blobs    map[string][]byte // path → body
+	index    string
+	requests map[string]int // path → count
+}
+
+func (s *manifestTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	if r.URL.Path == "/index.json" {
+		w.Write([]byte(s.index))
+		return
+	}
+	body, ok := s.blobs[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(body)
+}
+
+// publish serves body at path and lists it as the rules entry (hash = sha256 of hashOf)
+func (s *manifestTestServer) publish(path string, body, hashOf []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := sha256.Sum256(hashOf)
+	s.blobs[path] = body
+	s.index = fmt.Sprintf(`{"rules": {"url": %q, "sha256": %q, "size": %d, "version": %q}}`,
+		strings.TrimPrefix(path, "/"), hex.EncodeToString(sum[:]), len(hashOf), path)
+}
+
+func (s *manifestTestServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func TestManifestManager_DownloadsOnlyChangedBlobs(t *testing.T) {
+	ts := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	v1 := gzipBytes(t, buildTestPornK2R(t, []string{"v1.com"}))
+	ts.publish("/rules-v1.k2r.gz", v1, v1)
+
+	cacheDir := t.TempDir()
+	url := server.URL + "/index.json"
+	m := NewManifestManager(url, cacheDir)
+	m.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer m.rules.Close()
+
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if got := m.rules.matchDomain("v1.com"); got != TargetReject {
+		t.Errorf("matchDomain(v1.com) = %v, want REJECT", got)
+	}
+	if e := m.Manifest().Rules; e == nil || e.Version != "/rules-v1.k2r.gz" {
+		t.Errorf("Manifest().Rules = %+v, want v1 entry", e)
+	}
+
+	// Unchanged hash: manifest fetched, blob not downloaded again
+	if err := m.Update(); err != nil {
+		t.Fatalf("second Update() failed: %v", err)
+	}
+	if n := ts.count("/rules-v1.k2r.gz"); n != 1 {
+		t.Errorf("v1 blob downloaded %d times, want 1", n)
+	}
+
+	// New hash: blob downloaded and hot-loaded
+	v2 := gzipBytes(t, buildTestPornK2R(t, []string{"v2.com"}))
+	ts.publish("/rules-v2.k2r.gz", v2, v2)
+	if err := m.Update(); err != nil {
+		t.Fatalf("third Update() failed: %v", err)
+	}
+	if got := m.rules.matchDomain("v2.com"); got != TargetReject {
+		t.Errorf("matchDomain(v2.com) after update = %v, want REJECT", got)
+	}
+
+	// Restart: the applied blobs are loaded from cache without any download
+	restarted := NewManifestManager(url, cacheDir)
+	restarted.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer restarted.rules.Close()
+	if err := restarted.Init(); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	defer restarted.Stop()
+	if got := restarted.rules.matchDomain("v2.com"); got != TargetReject {
+		t.Errorf("cached matchDomain(v2.com) = %v, want REJECT", got)
+	}
+	if n := ts.count("/index.json"); n != 3 {
+		t.Errorf("manifest fetched %d times, want 3", n)
+	}
+}
+
+func TestManifestManager_MirrorSwitchReusesBlobs(t *testing.T) {
+	blob := gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"}))
+	primary := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	primary.publish("/rules.k2r.gz", blob, blob)
+	mirror := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	mirror.publish("/mirror/rules.k2r.gz", blob, blob)
+	primaryServer, mirrorServer := httptest.NewServer(primary), httptest.NewServer(mirror)
+	defer primaryServer.Close()
+	defer mirrorServer.Close()
+
+	cacheDir := t.TempDir()
+	m := NewManifestManager(primaryServer.URL+"/index.json", cacheDir)
+	m.rules = NewRemoteRuleManager(primaryServer.URL, cacheDir, TargetDirect)
+	defer m.rules.Close()
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+
+	// Same content listed by the mirror's manifest: loaded from cache
+	switched := NewManifestManager(mirrorServer.URL+"/index.json", cacheDir)
+	switched.rules = NewRemoteRuleManager(mirrorServer.URL, cacheDir, TargetDirect)
+	defer switched.rules.Close()
+	if err := switched.Update(); err != nil {
+		t.Fatalf("mirror Update() failed: %v", err)
+	}
+	if got := switched.rules.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if n := mirror.count("/mirror/rules.k2r.gz"); n != 0 {
+		t.Errorf("mirror blob downloaded %d times, want 0", n)
+	}
+}
+
+func TestManifestManager_MigratesLegacyBlobs(t *testing.T) {
+	ts := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+	blob := gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"}))
+	ts.publish("/rules.k2r.gz", blob, blob)
+
+	// Cache written by an earlier version: blob named after the manifest URL hash
+	cacheDir := t.TempDir()
+	url := server.URL + "/index.json"
+	m := NewManifestManager(url, cacheDir)
+	sum := sha256.Sum256(blob)
+	index := fmt.Sprintf(`{"rules": {"url": "rules.k2r.gz", "sha256": %q}}`, hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(m.getPath("index.json"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(m.getPath("rules.k2r.gz"), blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer m.rules.Close()
+	if err := m.Init(); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	defer m.Stop()
+	if got := m.rules.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if _, err := os.Stat(m.getPath("rules.k2r.gz")); !os.IsNotExist(err) {
+		t.Errorf("legacy blob still present (err = %v)", err)
+	}
+	if n := ts.count("/index.json") + ts.count("/rules.k2r.gz"); n != 0 {
+		t.Errorf("%d downloads after migration, want 0", n)
+	}
+}
+
+func TestManifestManager_RejectsHashMismatch(t *testing.T) {
+	ts := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	good := gzipBytes(t, buildTestPornK2R(t, []string{"good.com"}))
+	tampered := gzipBytes(t, buildTestPornK2R(t, []string{"evil.com"}))
+	ts.publish("/rules.k2r.gz", tampered, good)
+
+	cacheDir := t.TempDir()
+	m := NewManifestManager(server.URL+"/index.json", cacheDir)
+	m.rules = NewRemoteRuleManager(server.URL, cacheDir, TargetDirect)
+	defer m.rules.Close()
+
+	err := m.Update()
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("Update() = %v, want mismatch error", err)
+	}
+	if m.rules.reader.Get() != nil {
+		t.Error("tampered blob was loaded")
+	}
+	if m.GetETag() != "" || m.Status().Loaded {
+		t.Errorf("failed check recorded as applied: %+v", m.Status())
+	}
+}
+
+func TestManifestManager_KeepsSourceDomains(t *testing.T) {
+	ts := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+	blob := gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"}))
+	ts.publish("/rules.k2r.gz", blob, blob)
+
+	cacheDir := t.TempDir()
+	m := NewManifestManager(server.URL+"/index.json", cacheDir)
+	m.sources = newSourceDomains("https://geoip.example/geo.mmdb")
+	m.rules = NewRemoteRuleManager(server.URL, cacheDir, TargetDirect)
+	defer m.rules.Close()
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+
+	if !m.sources.has("127.0.0.1") {
+		t.Error("blob host not registered as a source domain")
+	}
+	if !m.sources.has("geoip.example") {
+		t.Error("applying a blob unregistered other source domains")
+	}
+}
+
+func TestManifestManager_CachedBlobHostsAreSourceDomains(t *testing.T) {
+	ts := &manifestTestServer{blobs: map[string][]byte{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+	blob := gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"}))
+	ts.publish("/rules.k2r.gz", blob, blob)
+
+	cacheDir := t.TempDir()
+	url := server.URL + "/index.json"
+	m := NewManifestManager(url, cacheDir)
+	m.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer m.rules.Close()
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+
+	// Restart: the source domains of the previous run are gone
+	restarted := NewManifestManager(url, cacheDir)
+	restarted.sources = newSourceDomains()
+	restarted.rules = NewRemoteRuleManager(url, cacheDir, TargetDirect)
+	defer restarted.rules.Close()
+	if err := restarted.loadCached(); err != nil {
+		t.Fatalf("loadCached() failed: %v", err)
+	}
+	if !restarted.sources.has("127.0.0.1") {
+		t.Error("blob host of a cached manifest not registered as a source domain")
+	}
+}
@@ -2,24 +2,14 @@ package k2rule
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kaitu-io/k2rule/internal/slice"
-	"github.com/oschwald/maxminddb-golang"
-)
-
-var (
-	globalConfig        *Config             // Single source of truth for configuration
-	globalManager       *RemoteRuleManager
-	globalGeoIPMgr      *GeoIPManager
-	globalPornManager   *PornRemoteManager
-	globalMatcher       *Matcher
-	globalMutex         sync.RWMutex
-	globalTmpRules      sync.Map // key: string (input), value: Target
-	globalSourceDomains sync.Map // key: hostname string, value: struct{} — source URLs always DIRECT
 )
 
 // Matcher provides rule matching functionality
@@ -59,11 +49,18 @@ type Matcher struct {
 //	}
 //	k2rule.Init(config)
 //	k2rule.ToggleGlobal(true)  // Switch to global mode at runtime
+//
+// Concurrent calls with the same config (or an identical one) share a single
+// initialization and all return its result.
 func Init(config *Config) error {
 	if config == nil {
 		return fmt.Errorf("config cannot be nil")
 	}
+	return initShared(config, defaultEngine.initialize)
+}
 
+// initialize implements Init and NewEngine
+func (e *Engine) initialize(config *Config) error {
 	// Validate config
 	if err := config.Validate(); err != nil {
 		return err
@@ -72,65 +69,155 @@ func Init(config *Config) error {
 	// Set defaults
 	config.SetDefaults()
 
-	globalMutex.Lock()
-	defer globalMutex.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Stop the managers of a previous Init that this one replaces, so their
+	// background updaters don't keep writing to the old CacheDir
+	previous := e.installedManagers()
+	defer func() {
+		e.publishState()
+		retireManagers(previous, e.installedManagers())
+	}()
 
 	// Save config as source of truth
-	globalConfig = config
+	e.config = config
+	if e == defaultEngine {
+		// Process-wide features are configured by the default engine only
+		setPanicHandler(config.OnPanic)
+		startHitStats(config.HitStats, config.CacheDir)
+		startTelemetry(config.Telemetry)
+		startRejectNotifier(config.OnReject, config.OnRejectLimit)
+		configureCaches(config.Caches)
+		globalRegistrable.enabled.Store(config.RegistrableCache)
+		configureHookLimits(config.HookLimits)
+		globalNetwork.setProfiles(config.NetworkProfiles)
+		keywords, _ := compileKeywordRules(config.KeywordRules)
+		globalKeywords.Store(keywords)
+	} else {
+		e.configureCaches(config.Caches)
+	}
 
 	// Register source domain hostnames as always-DIRECT (before any downloads)
 	var sourceURLs []string
-	if config.RuleFile == "" && !config.IsGlobal {
-		sourceURLs = append(sourceURLs, defaultIfEmpty(config.RuleURL, DefaultRuleURL))
+	combinedURL := defaultIfEmpty(config.BundleURL, config.ManifestURL) // one source for all components
+	if combinedURL != "" {
+		combinedAuth := config.BundleAuth
+		if config.BundleURL == "" {
+			combinedAuth = config.ManifestAuth
+		}
+		sourceURLs = append(sourceURLs, objectSourceURL(combinedURL, combinedAuth))
+	} else if config.RuleFile == "" && !config.IsGlobal && config.Subscription != nil {
+		for _, src := range config.Subscription.Sources {
+			sourceURLs = append(sourceURLs, objectSourceURL(src.URL, src.Auth))
+		}
+	} else if config.RuleFile == "" && !config.IsGlobal {
+		sourceURLs = append(sourceURLs, objectSourceURL(defaultIfEmpty(config.RuleURL, DefaultRuleURL), config.RuleAuth))
 	}
-	if config.GeoIPFile == "" {
-		sourceURLs = append(sourceURLs, defaultIfEmpty(config.GeoIPURL, DefaultGeoIPURL))
+	if config.GeoIPFile == "" && combinedURL == "" {
+		sourceURLs = append(sourceURLs, objectSourceURL(geoIPSource(config)))
 	}
-	if config.Antiporn && config.PornFile == "" {
-		sourceURLs = append(sourceURLs, defaultIfEmpty(config.PornURL, DefaultPornURL))
+	if config.Antiporn && config.PornFile == "" && combinedURL == "" {
+		sourceURLs = append(sourceURLs, objectSourceURL(defaultIfEmpty(config.PornURL, DefaultPornURL), config.PornAuth),
+			objectSourceURL(config.PornPatchURL, config.PornAuth))
+	}
+	sources := newSourceDomains(sourceURLs...)
+	e.publishSources(sources)
+	if e == defaultEngine {
+
+		// Load hosts file entries (Config.HostsFile)
+		if err := globalHosts.loadFile(config.HostsFile); err != nil {
+			return fmt.Errorf("failed to load hosts file: %w", err)
+		}
+
+		// Load persisted user overlay entries (AddDomain/RemoveDomain)
+		if err := globalOverlay.load(config.CacheDir, config.ReadOnlyCache); err != nil {
+			return err
+		}
+	}
+
+	// Options shared by all component managers
+	opts := []ManagerOption{WithReaderMode(config.ReaderMode), WithJitter(config.UpdateJitter)}
+	if config.ReadOnlyCache {
+		opts = append(opts, WithReadOnlyCache())
+	}
+
+	// Components without a local file are loaded from the bundle (BundleURL)
+	// or the update manifest (ManifestURL) when set
+	var bundle *BundleManager
+	var manifest *ManifestManager
+	var combined *componentSet
+	if config.BundleURL != "" {
+		bundle = NewBundleManager(config.BundleURL, config.CacheDir, opts...)
+		bundle.dl.configure(config, ComponentBundle, config.BundleAuth)
+		combined = &bundle.componentSet
+	} else if config.ManifestURL != "" {
+		manifest = NewManifestManager(config.ManifestURL, config.CacheDir, opts...)
+		manifest.dl.configure(config, ComponentManifest, config.ManifestAuth)
+		manifest.sources = sources
+		combined = &manifest.componentSet
 	}
-	registerSourceDomains(sourceURLs...)
 
 	// Initialize rule manager
-	// Priority: RuleFile > RuleURL (empty RuleURL uses default)
+	// Priority: RuleFile > BundleURL/ManifestURL/Subscription > RuleURL (empty RuleURL uses default)
+	var subscription *SubscriptionManager
 	if config.RuleFile != "" {
 		// Load from local file
-		manager := NewRemoteRuleManager("", config.CacheDir, TargetDirect)
+		manager := NewRemoteRuleManager("", config.CacheDir, TargetDirect, opts...)
 		if err := manager.reader.Load(config.RuleFile); err != nil {
 			return fmt.Errorf("failed to load rule file: %w", err)
 		}
 		manager.fallback.Store(uint32(manager.reader.Fallback()))
-		globalManager = manager
+		e.manager = manager
+	} else if !config.IsGlobal && combined != nil {
+		combined.rules = NewRemoteRuleManager(combinedURL, config.CacheDir, TargetDirect, opts...)
+		e.manager = combined.rules
+	} else if !config.IsGlobal && config.Subscription != nil {
+		subscription = NewSubscriptionManager(*config.Subscription, config.CacheDir, opts...)
+		for i, dl := range subscription.dls {
+			dl.configure(config, ComponentSubscription, config.Subscription.Sources[i].Auth)
+		}
+		subscription.rules = NewRemoteRuleManager(subscription.Status().Source, config.CacheDir, TargetDirect, opts...)
+		e.manager = subscription.rules
 	} else if !config.IsGlobal {
 		// Not in pure global mode, load rules from URL (empty URL uses default)
 		url := defaultIfEmpty(config.RuleURL, DefaultRuleURL)
-		manager := NewRemoteRuleManager(url, config.CacheDir, TargetDirect)
+		manager := NewRemoteRuleManager(url, config.CacheDir, TargetDirect, opts...)
+		manager.dl.configure(config, ComponentRules, config.RuleAuth)
+		manager.shadowRate = config.ShadowSampleRate
+		manager.canary = config.UpdateStrategy.Canary
 		if err := manager.Init(); err != nil {
 			return fmt.Errorf("failed to init rules: %w", err)
 		}
-		globalManager = manager
+		e.manager = manager
 	}
 
-	// Initialize GeoIP (Priority: GeoIPFile > GeoIPURL)
+	// Initialize GeoIP (Priority: GeoIPFile > BundleURL/ManifestURL > GeoIPMaxMind/GeoIPURL)
 	if config.GeoIPFile != "" {
-		reader, err := maxminddb.Open(config.GeoIPFile)
-		if err != nil {
+		geoIPMgr := &GeoIPManager{
+			stopCh: make(chan struct{}),
+		}
+		if err := geoIPMgr.loadDatabase(config.GeoIPFile); err != nil {
 			return fmt.Errorf("failed to open GeoIP file: %w", err)
 		}
-		globalGeoIPMgr = &GeoIPManager{
-			reader: reader,
+		e.geoIPMgr = geoIPMgr
+	} else if combined != nil {
+		combined.geoIP = &GeoIPManager{
+			url:    combinedURL,
 			stopCh: make(chan struct{}),
 		}
+		e.geoIPMgr = combined.geoIP
 	} else {
-		url := defaultIfEmpty(config.GeoIPURL, DefaultGeoIPURL)
-		geoIPMgr := NewGeoIPManager(url, config.CacheDir)
+		url, auth := geoIPSource(config)
+		geoIPMgr := NewGeoIPManager(url, config.CacheDir, opts...)
+		geoIPMgr.dl.configure(config, ComponentGeoIP, auth)
 		if err := geoIPMgr.Init(); err != nil {
 			return fmt.Errorf("failed to init GeoIP: %w", err)
 		}
-		globalGeoIPMgr = geoIPMgr
+		e.geoIPMgr = geoIPMgr
 	}
 
-	// Initialize porn detection (Priority: PornFile > PornURL)
+	// Initialize porn detection (Priority: PornFile > BundleURL/ManifestURL > PornURL)
 	// Only loads resources when Antiporn=true; IsPorn() still works via heuristic fallback
 	if config.Antiporn {
 		if config.PornFile != "" {
@@ -138,23 +225,118 @@ func Init(config *Config) error {
 			if err != nil {
 				return fmt.Errorf("failed to load porn file: %w", err)
 			}
-			if globalMatcher == nil {
-				globalMatcher = &Matcher{}
+			if e.matcher == nil {
+				e.matcher = &Matcher{}
 			}
-			globalMatcher.pornChecker = checker
+			e.matcher.pornChecker = checker
+		} else if combined != nil {
+			combined.porn = NewPornRemoteManager(combinedURL, config.CacheDir, opts...)
+			e.pornManager = combined.porn
 		} else {
 			url := defaultIfEmpty(config.PornURL, DefaultPornURL)
-			pornMgr := NewPornRemoteManager(url, config.CacheDir)
+			pornMgr := NewPornRemoteManager(url, config.CacheDir, opts...)
+			pornMgr.dl.configure(config, ComponentPorn, config.PornAuth)
+			pornMgr.patchURL = config.PornPatchURL
 			if err := pornMgr.Init(); err != nil {
 				return fmt.Errorf("failed to init porn detection: %w", err)
 			}
-			globalPornManager = pornMgr
+			e.pornManager = pornMgr
 		}
 	}
 
+	// Download/load the combined source once all its components are attached
+	e.bundleMgr = nil
+	e.manifestMgr = nil
+	if bundle != nil && !bundle.empty() {
+		if err := bundle.Init(); err != nil {
+			return fmt.Errorf("failed to init bundle: %w", err)
+		}
+		e.bundleMgr = bundle
+	}
+	if manifest != nil && !manifest.empty() {
+		if err := manifest.Init(); err != nil {
+			return fmt.Errorf("failed to init manifest: %w", err)
+		}
+		e.manifestMgr = manifest
+	}
+	e.subscriptionMgr = nil
+	if subscription != nil {
+		if err := subscription.Init(); err != nil {
+			return fmt.Errorf("failed to init subscription: %w", err)
+		}
+		e.subscriptionMgr = subscription
+	}
+
 	return nil
 }
 
+// managerSet is the component managers installed by Init
+type managerSet struct {
+	rules        *RemoteRuleManager
+	geoIP        *GeoIPManager
+	porn         *PornRemoteManager
+	bundle       *BundleManager
+	manifest     *ManifestManager
+	subscription *SubscriptionManager
+}
+
+// installedManagers returns the installed managers (e.mu held)
+func (e *Engine) installedManagers() managerSet {
+	return managerSet{
+		rules:        e.manager,
+		geoIP:        e.geoIPMgr,
+		porn:         e.pornManager,
+		bundle:       e.bundleMgr,
+		manifest:     e.manifestMgr,
+		subscription: e.subscriptionMgr,
+	}
+}
+
+// retireManagers stops the managers of old that current no longer uses. Background
+// tasks stop immediately; readers are closed after a grace period because
+// concurrent Match() calls may still hold the old managers.
+func retireManagers(old, current managerSet) {
+	var rules *RemoteRuleManager
+	var geoIP *GeoIPManager
+	var porn *PornRemoteManager
+	if old.bundle != nil && old.bundle != current.bundle {
+		old.bundle.Stop()
+	}
+	if old.manifest != nil && old.manifest != current.manifest {
+		old.manifest.Stop()
+	}
+	if old.subscription != nil && old.subscription != current.subscription {
+		old.subscription.Stop()
+	}
+	if old.rules != nil && old.rules != current.rules {
+		rules = old.rules
+		rules.Stop()
+	}
+	if old.geoIP != nil && old.geoIP != current.geoIP {
+		geoIP = old.geoIP
+		geoIP.stopUpdates()
+	}
+	if old.porn != nil && old.porn != current.porn {
+		porn = old.porn
+		porn.stopUpdates()
+	}
+	if rules == nil && geoIP == nil && porn == nil {
+		return
+	}
+	safeGo("init", func() {
+		time.Sleep(5 * time.Second)
+		if rules != nil {
+			rules.Close()
+		}
+		if geoIP != nil {
+			geoIP.Stop()
+		}
+		if porn != nil {
+			porn.Stop()
+		}
+	})
+}
+
 // ToggleGlobal switches global proxy mode on/off (immediate effect).
 // Changes take effect immediately without requiring a restart.
 //
@@ -175,12 +357,7 @@ func Init(config *Config) error {
 //	k2rule.ToggleGlobal(false)  // Back to rule-based mode
 //	k2rule.Match("google.com")  // → Check rules
 func ToggleGlobal(enabled bool) {
-	globalMutex.Lock()
-	defer globalMutex.Unlock()
-
-	if globalConfig != nil {
-		globalConfig.IsGlobal = enabled
-	}
+	defaultEngine.ToggleGlobal(enabled)
 }
 
 // SetGlobalTarget sets the target for global proxy mode.
@@ -192,12 +369,7 @@ func ToggleGlobal(enabled bool) {
 //	k2rule.ToggleGlobal(true)                    // Enable global mode
 //	k2rule.Match("anything.com")                 // → REJECT
 func SetGlobalTarget(target Target) {
-	globalMutex.Lock()
-	defer globalMutex.Unlock()
-
-	if globalConfig != nil {
-		globalConfig.GlobalTarget = target
-	}
+	defaultEngine.SetGlobalTarget(target)
 }
 
 // GetConfig returns a copy of the current configuration.
@@ -209,15 +381,7 @@ func SetGlobalTarget(target Target) {
 //	fmt.Printf("Global mode: %v\n", config.IsGlobal)
 //	fmt.Printf("Cache dir: %s\n", config.CacheDir)
 func GetConfig() Config {
-	globalMutex.RLock()
-	defer globalMutex.RUnlock()
-
-	if globalConfig == nil {
-		return Config{}
-	}
-
-	// Return a copy to prevent external modification
-	return *globalConfig
+	return defaultEngine.Config()
 }
 
 // UpdateConfig hot-reloads the configuration without restarting.
@@ -236,13 +400,7 @@ func UpdateConfig(config *Config) error {
 		return fmt.Errorf("config cannot be nil")
 	}
 
-	if err := config.Validate(); err != nil {
-		return err
-	}
-
-	config.SetDefaults()
-
-	// Re-initialize with new config
+	// Re-initialize with new config (Init validates and applies defaults)
 	return Init(config)
 }
 
@@ -251,7 +409,8 @@ func UpdateConfig(config *Config) error {
 //
 // Priority (from highest to lowest):
 //  1. LAN/Private IPs → DIRECT (hardcoded, always bypassed)
-//  2. TmpRule → Exact match override (set via SetTmpRule)
+//  2. TmpRule → Exact match override (set via SetTmpRule), then network profile rules,
+//     category policies and keyword rules
 //  3. Global mode → GlobalTarget (if IsGlobal = true)
 //  4. Rule matching → Domain/IP-CIDR/GeoIP rules
 //  5. Fallback → Rule file fallback or GlobalTarget
@@ -271,169 +430,270 @@ func UpdateConfig(config *Config) error {
 //	target := k2rule.Match("192.168.1.1")   // → DIRECT (LAN bypass)
 //	target := k2rule.Match("::1")           // → DIRECT (IPv6 loopback)
 func Match(input string) Target {
-	globalMutex.RLock()
-	config := globalConfig
-	manager := globalManager
-	geoIPMgr := globalGeoIPMgr
-	matcher := globalMatcher
-	globalMutex.RUnlock()
+	result := defaultEngine.match(input)
+	recordMatch(input, result)
+	return result.Target
+}
+
+// recordMatch feeds a decision of the default engine to hit stats, telemetry, reject
+// notifications and match events
+func recordMatch(input string, result MatchResult) {
+	if hits := globalHits.Load(); hits != nil {
+		hits.record(input, result)
+	}
+	if telemetry := globalTelemetry.Load(); telemetry != nil {
+		telemetry.record(input, result)
+	}
+	if reject := globalReject.Load(); reject != nil {
+		reject.record(input, result)
+	}
+	publishMatch(input, result)
+}
+
+// match implements Match, also reporting details of the decision (see MatchVerbose)
+func (e *Engine) match(input string) MatchResult {
+	return e.decide(input, true)
+}
+
+// query is match for lookups that don't route traffic (IsExplicitMatch, the admin API,
+// SelfTest): experiments divert as for Match but don't count exposure
+func (e *Engine) query(input string) MatchResult {
+	return e.decide(input, false)
+}
+
+// decide implements match and query
+func (e *Engine) decide(input string, count bool) MatchResult {
+	generation := e.loadState().ruleGeneration()
+	result := applyMiddleware(input, e.matchPipeline(input))
+	result = applyExperiments(input, result, count).withRejectMode()
+	result.Generation = generation
+	return result
+}
+
+// matchPipeline is the decision pipeline of match, before middleware
+func (e *Engine) matchPipeline(input string) MatchResult {
+	state := e.loadState()
+	config := state.config
+	manager := state.manager
+	geoIPMgr := state.geoIPMgr
+	matcher := state.matcher
+	profile := globalNetwork.active.Load()
 
 	// Step 1: Try to parse as IP
 	if ip := net.ParseIP(input); ip != nil {
 		// Step 1a: Check private/LAN IP (hardcoded bypass - highest priority)
 		if isPrivateIP(ip) {
-			return TargetDirect
+			return MatchResult{Target: TargetDirect, Reason: ReasonLAN}
 		}
 
 		// Step 1b: Check TmpRule (exact match, higher priority than Global/static)
-		if target, ok := globalTmpRules.Load(input); ok {
-			return target.(Target)
+		if target, ok := e.tmpRules.load(input); ok {
+			return MatchResult{Target: target, Reason: ReasonUserRule}
 		}
 
-		// Step 1c: Check global mode
-		if config != nil && config.IsGlobal {
-			return config.GlobalTarget
+		// Step 1c: Check network profile rules (SetNetworkContext)
+		if profile != nil {
+			if target, ok := profile.matchIP(ip); ok {
+				return MatchResult{Target: target, Reason: ReasonNetwork, Profile: profile.Name}
+			}
 		}
 
-		// Step 1d: Check IP-CIDR rules (if rules loaded)
-		if manager != nil {
-			if target := manager.matchIPCIDR(ip); target != manager.getFallback() {
-				return target
-			}
+		// Step 1d: Inherit the decision of the domain the IP was resolved for (BindDomainIPs)
+		if domain, ok := globalAffinity.lookup(ip); ok {
+			result := e.matchDomainPipeline(state, profile, domain)
+			result.Domain = domain
+			return result
+		}
 
-			// Step 1e: Check GeoIP rules (if GeoIP initialized)
-			if geoIPMgr != nil {
-				if country, err := geoIPMgr.LookupCountry(ip); err == nil {
-					if target := manager.matchGeoIP(country); target != manager.getFallback() {
-						return target
-					}
+		// Step 1e: Check global mode
+		if isGlobal(config, profile) {
+			return MatchResult{Target: config.GlobalTarget, Reason: ReasonGlobal}
+		}
+
+		// Kill switch: no rule data loaded
+		if failClosed(config, manager, matcher) {
+			return MatchResult{Target: TargetReject, Reason: ReasonFailClosed, Fallback: true}
+		}
+
+		// Step 1f: Check domain rules against the PTR name (Config.EnableRDNS)
+		// Step 1g: Check IP-CIDR rules → GeoIP rules (if GeoIP initialized) → fallback
+		if manager != nil {
+			if hostname := globalRDNS.hostname(config, ip); hostname != "" {
+				if result := manager.match(hostname, nil, nil); !result.Fallback {
+					result.Domain = hostname
+					return result
 				}
 			}
-
-			// Step 1f: Return fallback
-			return manager.getFallback()
+			return profile.applyFallback(inputFallback(config.fallbackFor(true), manager.match(input, ip, geoIPMgr)))
 		}
 
 		// Fallback to old matcher (if no RemoteRuleManager)
 		if matcher != nil && matcher.reader != nil {
 			// Check IP-CIDR rules
 			if target := matcher.reader.MatchIP(ip); target != nil {
-				return Target(*target)
+				return MatchResult{Target: Target(*target), Reason: ReasonRule}
 			}
 
 			// Check GeoIP rules (if GeoIP initialized)
+			var country string
 			if geoIPMgr != nil {
-				if country, err := geoIPMgr.LookupCountry(ip); err == nil {
+				if c, err := geoIPMgr.LookupCountry(ip); err == nil {
+					country = c
 					if target := matcher.reader.MatchGeoIP(country); target != nil {
-						return Target(*target)
+						return MatchResult{Target: Target(*target), Reason: ReasonGeo, Country: country}
 					}
 				}
 			}
 
-			return Target(matcher.reader.Fallback())
+			return profile.applyFallback(inputFallback(config.fallbackFor(true), MatchResult{Target: Target(matcher.reader.Fallback()), Reason: ReasonFallback, Country: country, Fallback: true}))
 		}
 
 		// No rules loaded, use config fallback
 		if config != nil {
-			return config.GlobalTarget
+			return MatchResult{Target: config.GlobalTarget, Reason: ReasonFallback, Fallback: true}
 		}
 
-		return TargetDirect
+		return MatchResult{Target: TargetDirect, Reason: ReasonFallback, Fallback: true}
 	}
 
+	return e.matchDomainPipeline(state, profile, input)
+}
+
+// matchDomainPipeline is the part of matchPipeline for inputs that aren't IPs. It never
+// goes back to the IP steps, so a domain bound to IPs (Step 1d) can't recurse.
+func (e *Engine) matchDomainPipeline(state *engineState, profile *networkProfile, input string) MatchResult {
+	config := state.config
+	manager := state.manager
+	matcher := state.matcher
+
 	// Step 2: Treat as domain
 	// Step 2a: Check source domains (rule/geoip/porn download hosts — always DIRECT)
-	if isSourceDomain(input) {
-		return TargetDirect
+	if state.sources.has(input) {
+		return MatchResult{Target: TargetDirect, Reason: ReasonSource}
+	}
+	// ... and hosts entries (Config.HostsFile, AddHost — local services)
+	if ips, ok := globalHosts.lookup(input); ok {
+		return MatchResult{Target: hostsTarget(ips), Reason: ReasonHosts, HostIP: ips[0].String()}
 	}
 
 	// Step 2b: Check TmpRule (exact match, higher priority than Global/static)
-	if target, ok := globalTmpRules.Load(input); ok {
-		return target.(Target)
+	if target, ok := e.tmpRules.load(input); ok {
+		return MatchResult{Target: target, Reason: ReasonUserRule}
 	}
 
-	// Step 2c: Check global mode
-	if config != nil && config.IsGlobal {
-		return config.GlobalTarget
+	// Step 2c: Check network profile rules (SetNetworkContext)
+	if profile != nil {
+		if target, ok := profile.matchDomain(input); ok {
+			return MatchResult{Target: target, Reason: ReasonNetwork, Profile: profile.Name}
+		}
+	}
+
+	// Step 2d: Check category policies (SetCategoryTarget)
+	if category, target, ok := globalOverlay.matchCategory(input, e.isPorn); ok {
+		return MatchResult{Target: target, Reason: categoryReason(category), Category: category}
+	}
+
+	// Step 2e: Check keyword rules (SetKeywordRules)
+	if rule, ok := globalKeywords.Load().match(input); ok {
+		return MatchResult{Target: rule.Target, Reason: ReasonUserRule, Keyword: rule.Keyword}
+	}
+
+	// Step 2f: Check global mode
+	if isGlobal(config, profile) {
+		return MatchResult{Target: config.GlobalTarget, Reason: ReasonGlobal}
 	}
 
-	// Step 2d: Check domain rules (if rules loaded)
+	// Kill switch: no rule data loaded
+	if failClosed(config, manager, matcher) {
+		return MatchResult{Target: TargetReject, Reason: ReasonFailClosed, Fallback: true}
+	}
+
+	// Step 2g: Inputs that aren't valid domain names → Config.UnknownInputTarget or fallback
+	if !isValidDomain(input) {
+		if config != nil && config.UnknownInputTarget != nil {
+			return MatchResult{Target: *config.UnknownInputTarget, Reason: ReasonUnknownInput, Unknown: true}
+		}
+		return MatchResult{Target: ruleFallback(config, manager, matcher), Reason: ReasonUnknownInput, Fallback: true, Unknown: true}
+	}
+
+	// Step 2h: Check domain rules (if rules loaded)
+	// (decision pinned for Config.StickyTTL across rule reloads, if enabled)
 	if manager != nil {
-		if target := manager.matchDomain(input); target != manager.getFallback() {
-			return target
+		var ttl time.Duration
+		if config != nil {
+			ttl = time.Duration(config.StickyTTL)
 		}
-		return manager.getFallback()
+		if result, ok := e.sticky.get(input, ttl); ok {
+			return profile.applyFallback(inputFallback(config.fallbackFor(false), result))
+		}
+		result := manager.match(input, nil, nil)
+		e.sticky.put(input, result, ttl)
+		return profile.applyFallback(inputFallback(config.fallbackFor(false), result))
 	}
 
 	// Fallback to old matcher (if no RemoteRuleManager)
 	if matcher != nil && matcher.reader != nil {
-		if target := matcher.reader.MatchDomain(input); target != nil {
-			return Target(*target)
+		if target, hints, ok := matcher.reader.MatchDomainHints(input); ok {
+			return MatchResult{Target: Target(target), Reason: ReasonRule, DNS: DNSHint(hints)}
 		}
-		return Target(matcher.reader.Fallback())
+		return profile.applyFallback(inputFallback(config.fallbackFor(false), MatchResult{Target: Target(matcher.reader.Fallback()), Reason: ReasonFallback, Fallback: true}))
 	}
 
 	// No rules loaded, use config fallback
 	if config != nil {
-		return config.GlobalTarget
+		return MatchResult{Target: config.GlobalTarget, Reason: ReasonFallback, Fallback: true}
 	}
 
-	return TargetDirect
+	return MatchResult{Target: TargetDirect, Reason: ReasonFallback, Fallback: true}
 }
 
-// MatchDomain matches a domain and returns the target.
-//
-// Deprecated: Use Match() instead, which automatically detects input type.
-// This function will be removed in v1.0.0.
-func MatchDomain(domain string) Target {
-	return Match(domain)
+// LookupCountry returns the ISO country code (e.g. "US") of an IP address
+// using the global GeoIP database.
+func LookupCountry(ip string) (string, error) {
+	return defaultEngine.LookupCountry(ip)
 }
 
-// MatchIP matches an IP address and returns the target.
-//
-// Deprecated: Use Match() instead, which automatically detects input type
-// and performs GeoIP lookup if initialized.
-// This function will be removed in v1.0.0.
-func MatchIP(ip net.IP) Target {
-	return Match(ip.String())
-}
-
-// MatchGeoIP matches a GeoIP country code and returns the target.
-//
-// Deprecated: GeoIP lookup is now automatic when using Match() with an IP address.
-// Initialize GeoIP with InitGeoIP() and use Match() instead.
-// This function will be removed in v1.0.0.
-func MatchGeoIP(country string) Target {
-	globalMutex.RLock()
-	manager := globalManager
-	matcher := globalMatcher
-	globalMutex.RUnlock()
-
-	// Prefer RemoteRuleManager (mmap-based)
-	if manager != nil {
-		return manager.matchGeoIP(country)
+// GeoIPStats returns the LookupCountry counters of the global GeoIP database
+// (zero before Init or without GeoIP)
+func GeoIPStats() GeoIPLookupStats {
+	geoIPMgr := defaultEngine.loadState().geoIPMgr
+	if geoIPMgr == nil {
+		return GeoIPLookupStats{}
 	}
-
-	// Fallback to old matcher
-	if matcher == nil || matcher.reader == nil {
-		return TargetDirect
-	}
-
-	if target := matcher.reader.MatchGeoIP(country); target != nil {
-		return Target(*target)
-	}
-
-	return Target(matcher.reader.Fallback())
+	return geoIPMgr.LookupStats()
 }
 
 // IsPorn checks if a domain is a porn domain using the global porn checker.
+// User overlay entries (AddDomain/RemoveDomain with CategoryPorn) and keywords
+// (SetCategoryKeywords) are checked first.
 // Uses the remote porn manager if initialized with InitPorn()/InitPornRemote(),
 // otherwise falls back to the old porn checker or heuristic-only detection.
 func IsPorn(domain string) bool {
-	globalMutex.RLock()
-	pornManager := globalPornManager
-	matcher := globalMatcher
-	globalMutex.RUnlock()
+	porn := defaultEngine.isPorn(domain)
+	if porn {
+		if hits := globalHits.Load(); hits != nil {
+			hits.recordPorn(domain)
+		}
+	}
+	return porn
+}
+
+// isPorn implements IsPorn
+func (e *Engine) isPorn(domain string) bool {
+	if member, found := globalOverlay.member(CategoryPorn, domain); found {
+		return member
+	}
+
+	state := e.loadState()
+	pornManager := state.pornManager
+	matcher := state.matcher
+
+	// Domains the rule file allows by name (Config.RuleOverridesPorn)
+	if state.config != nil && state.config.RuleOverridesPorn {
+		if target, ok := state.ruleDomainTarget(domain); ok && target == TargetDirect {
+			return false
+		}
+	}
 
 	// Prefer PornRemoteManager if available
 	if pornManager != nil {
@@ -452,43 +712,42 @@ func IsPorn(domain string) bool {
 // SetTmpRule sets a temporary rule override for the given input (IP or domain).
 // TmpRule has higher priority than Global mode and static rules, but lower than LAN bypass.
 // If the static rules already return the same target, the override is not stored (storage optimization).
+// The store is unbounded unless Config.Caches[CacheTmpRules] sets an eviction policy
+// (see TmpRuleCount and CacheStats).
 func SetTmpRule(input string, target Target) {
-	// Storage optimization: skip storing if static rules already return the same target
-	// AND global mode is not active (since TmpRule must override Global mode).
-	globalMutex.RLock()
-	isGlobal := globalConfig != nil && globalConfig.IsGlobal
-	globalMutex.RUnlock()
-
-	if !isGlobal {
-		staticTarget := matchStaticRules(input)
-		if staticTarget == target {
-			globalTmpRules.Delete(input) // clear any existing override
-			return
-		}
-	}
-	globalTmpRules.Store(input, target)
+	defaultEngine.SetTmpRule(input, target)
 }
 
 // ClearTmpRule removes a single temporary rule override.
 func ClearTmpRule(input string) {
-	globalTmpRules.Delete(input)
+	defaultEngine.ClearTmpRule(input)
 }
 
 // ClearTmpRules removes all temporary rule overrides.
 func ClearTmpRules() {
-	globalTmpRules.Range(func(key, _ any) bool {
-		globalTmpRules.Delete(key)
-		return true
-	})
+	defaultEngine.ClearTmpRules()
+}
+
+// ImportTmpRules sets target as the TmpRule of every domain or IP listed in r, one per
+// line; "#" starts a comment and blank lines are ignored. Domains are lowercased (see
+// SetTmpRule for exact-match semantics) and IPs canonicalized. The list is validated
+// before any rule is set: an invalid entry fails the whole import with its line
+// number. Returns the number of entries imported.
+//
+// Example (support hotfix list):
+//
+//	n, err := k2rule.ImportTmpRules(strings.NewReader("blocked.example\n203.0.113.7 # abuse\n"), k2rule.TargetReject)
+func ImportTmpRules(r io.Reader, target Target) (n int, err error) {
+	return defaultEngine.ImportTmpRules(r, target)
 }
 
 // matchStaticRules matches input against static rules only (IP-CIDR / GeoIP / Domain).
 // Does not check LAN, Global mode, or TmpRule — used by SetTmpRule for storage optimization.
-func matchStaticRules(input string) Target {
-	globalMutex.RLock()
-	manager := globalManager
-	geoIPMgr := globalGeoIPMgr
-	globalMutex.RUnlock()
+func (e *Engine) matchStaticRules(input string) Target {
+	e.mu.RLock()
+	manager := e.manager
+	geoIPMgr := e.geoIPMgr
+	e.mu.RUnlock()
 
 	if manager == nil {
 		return TargetDirect
@@ -516,15 +775,24 @@ func matchStaticRules(input string) Target {
 
 // Source domain helpers
 
-// registerSourceDomains extracts hostnames from the given URLs and registers them
-// as source domains that always route DIRECT (bypasses TmpRule + Global mode).
-func registerSourceDomains(urls ...string) {
-	// Clear existing entries
-	globalSourceDomains.Range(func(key, _ any) bool {
-		globalSourceDomains.Delete(key)
-		return true
-	})
+// sourceDomains is the set of hostnames of an engine's download sources, which
+// always route DIRECT (bypassing TmpRules and global mode). A nil set is empty.
+type sourceDomains struct {
+	hosts sync.Map // key: hostname string, value: struct{}
+}
+
+// newSourceDomains returns a set with the hostnames of urls
+func newSourceDomains(urls ...string) *sourceDomains {
+	s := &sourceDomains{}
+	s.add(urls...)
+	return s
+}
 
+// add registers the hostnames of urls, keeping existing ones
+func (s *sourceDomains) add(urls ...string) {
+	if s == nil {
+		return
+	}
 	for _, rawURL := range urls {
 		if rawURL == "" {
 			continue
@@ -534,19 +802,31 @@ func registerSourceDomains(urls ...string) {
 			continue
 		}
 		if host := u.Hostname(); host != "" {
-			globalSourceDomains.Store(host, struct{}{})
+			s.hosts.Store(host, struct{}{})
 		}
 	}
 }
 
-// isSourceDomain returns true if the domain is a registered source domain.
-func isSourceDomain(domain string) bool {
-	_, ok := globalSourceDomains.Load(domain)
+// has returns true if domain is a registered source domain
+func (s *sourceDomains) has(domain string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.hosts.Load(domain)
 	return ok
 }
 
 // Helper functions
 
+// geoIPSource returns the GeoIP download URL and credentials of config:
+// MaxMind's endpoint with the license (GeoIPMaxMind), or GeoIPURL/DefaultGeoIPURL
+func geoIPSource(config *Config) (string, *SourceAuth) {
+	if config.GeoIPMaxMind != nil {
+		return config.GeoIPMaxMind.downloadURL(), config.GeoIPMaxMind.auth()
+	}
+	return defaultIfEmpty(config.GeoIPURL, DefaultGeoIPURL), config.GeoIPAuth
+}
+
 // defaultIfEmpty returns defaultValue if value is empty, otherwise returns value
 func defaultIfEmpty(value, defaultValue string) string {
 	if value == "" {
@@ -560,6 +840,92 @@ func IsIPAddress(s string) bool {
 	return net.ParseIP(s) != nil
 }
 
+// rulesLoaded reports whether rule data is available for lookups
+func rulesLoaded(manager *RemoteRuleManager, matcher *Matcher) bool {
+	if manager != nil {
+		return manager.reader.Get() != nil
+	}
+	return matcher != nil && matcher.reader != nil
+}
+
+// failClosed reports whether Config.FailClosed rejects because no rule data is loaded
+func failClosed(config *Config, manager *RemoteRuleManager, matcher *Matcher) bool {
+	return config != nil && config.FailClosed && !rulesLoaded(manager, matcher)
+}
+
+// fallbackFor returns Config.IPFallback for IP inputs, Config.DomainFallback for
+// domains (nil = no override, also for a nil config)
+func (c *Config) fallbackFor(ip bool) *Target {
+	switch {
+	case c == nil:
+		return nil
+	case ip:
+		return c.IPFallback
+	}
+	return c.DomainFallback
+}
+
+// categoryReason returns the reason code of a category policy decision
+func categoryReason(category string) ReasonCode {
+	if category == CategoryPorn {
+		return ReasonPorn
+	}
+	return ReasonCategory
+}
+
+// inputFallback replaces the target of a fallback result with override
+// (see Config.fallbackFor; nil = unchanged)
+func inputFallback(override *Target, result MatchResult) MatchResult {
+	if override != nil && result.Fallback && !result.Unknown {
+		result.Target = *override
+	}
+	return result
+}
+
+// ruleFallback returns the fallback target of the loaded rules
+// (Config.GlobalTarget while no rules are loaded)
+func ruleFallback(config *Config, manager *RemoteRuleManager, matcher *Matcher) Target {
+	switch {
+	case manager != nil:
+		return manager.getFallback()
+	case matcher != nil && matcher.reader != nil:
+		return Target(matcher.reader.Fallback())
+	case config != nil:
+		return config.GlobalTarget
+	}
+	return TargetDirect
+}
+
+// isValidDomain reports whether s is syntactically a domain name: at most 253 bytes,
+// dot-separated labels of 1-63 letters, digits, '-' or '_' (an optional trailing dot
+// is allowed). Non-ASCII bytes are accepted for internationalized names.
+func isValidDomain(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	labelLen := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.':
+			if labelLen == 0 {
+				return false
+			}
+			labelLen = 0
+			continue
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c >= 0x80:
+		default:
+			return false
+		}
+		labelLen++
+		if labelLen > 63 {
+			return false
+		}
+	}
+	return labelLen > 0
+}
+
 // IsDomain checks if a string is likely a domain name
 func IsDomain(s string) bool {
 	// Simple heuristic: contains dots and no colons (not IPv6)
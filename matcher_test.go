@@ -1,8 +1,13 @@
 package k2rule
 
 import (
-	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
 )
 
 func TestMatch_AutoDetection(t *testing.T) {
@@ -34,9 +39,10 @@ func TestMatch_WithoutGeoIP(t *testing.T) {
 	// This ensures backward compatibility
 
 	// Reset global state
-	globalMutex.Lock()
-	globalGeoIPMgr = nil
-	globalMutex.Unlock()
+	defaultEngine.mu.Lock()
+	defaultEngine.geoIPMgr = nil
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 
 	target := Match("8.8.8.8")
 	// Should still work, just won't do GeoIP lookup
@@ -66,45 +72,18 @@ func TestMatch_WithGeoIP_Integration(t *testing.T) {
 	t.Logf("Match(2001:4860:4860::8888) with GeoIP = %s", target)
 }
 
-func TestMatchDomain_Deprecated(t *testing.T) {
-	// Test deprecated MatchDomain function
-	target := MatchDomain("google.com")
-	// Without rules, should return Direct
-	if target != TargetDirect {
-		t.Logf("MatchDomain(google.com) = %s", target)
-	}
-}
-
-func TestMatchIP_Deprecated(t *testing.T) {
-	// Test deprecated MatchIP function
-	ip := net.ParseIP("8.8.8.8")
-	target := MatchIP(ip)
-	// Without rules, should return Direct
-	if target != TargetDirect {
-		t.Logf("MatchIP(8.8.8.8) = %s", target)
-	}
-}
-
-func TestMatchGeoIP_Deprecated(t *testing.T) {
-	// Test deprecated MatchGeoIP function
-	target := MatchGeoIP("US")
-	// Without rules, should return Direct
-	if target != TargetDirect {
-		t.Logf("MatchGeoIP(US) = %s", target)
-	}
-}
-
 func TestIsPorn_WithoutPornManager(t *testing.T) {
 	// Test IsPorn without porn manager initialized
 	// Should fall back to heuristic
 
 	// Reset global state
-	globalMutex.Lock()
-	globalPornManager = nil
-	if globalMatcher != nil {
-		globalMatcher.pornChecker = nil
+	defaultEngine.mu.Lock()
+	defaultEngine.pornManager = nil
+	if defaultEngine.matcher != nil {
+		defaultEngine.matcher.pornChecker = nil
 	}
-	globalMutex.Unlock()
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 
 	isPorn := IsPorn("pornhub.com")
 	if !isPorn {
@@ -117,6 +96,63 @@ func TestIsPorn_WithoutPornManager(t *testing.T) {
 	}
 }
 
+func TestIsPorn_RuleOverridesPorn(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// Rule file: pornhub.com DIRECT by name, xvideos.com REJECT
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	if err := w.AddDomainSlice([]string{"pornhub.com"}, uint8(TargetDirect)); err != nil {
+		t.Fatalf("AddDomainSlice failed: %v", err)
+	}
+	if err := w.AddDomainSlice([]string{"xvideos.com"}, uint8(TargetReject)); err != nil {
+		t.Fatalf("AddDomainSlice failed: %v", err)
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetProxy)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	setConfig := func(config *Config) {
+		defaultEngine.mu.Lock()
+		defaultEngine.config = config
+		defaultEngine.manager = manager
+		defaultEngine.publishState()
+		defaultEngine.mu.Unlock()
+	}
+
+	setConfig(&Config{})
+	if !IsPorn("www.pornhub.com") {
+		t.Error("IsPorn(www.pornhub.com) = false without RuleOverridesPorn")
+	}
+
+	setConfig(&Config{RuleOverridesPorn: true})
+	if IsPorn("pornhub.com") || IsPorn("www.pornhub.com") {
+		t.Error("IsPorn(pornhub.com) = true for a domain the rule file targets DIRECT")
+	}
+	if !IsPorn("xvideos.com") {
+		t.Error("IsPorn(xvideos.com) = false, only DIRECT rules override")
+	}
+	if !IsPorn("porn-example.com") {
+		t.Error("IsPorn(porn-example.com) = false, the fallback doesn't override")
+	}
+
+	// User overlay entries take precedence
+	if err := AddDomain(CategoryPorn, "pornhub.com"); err != nil {
+		t.Fatalf("AddDomain failed: %v", err)
+	}
+	if !IsPorn("pornhub.com") {
+		t.Error("IsPorn(pornhub.com) = false after AddDomain")
+	}
+}
+
 func TestIsPorn_WithPornManager(t *testing.T) {
 	t.Skip("Skipping integration test - requires porn database download")
 
@@ -152,9 +188,9 @@ func TestInit_WithGeoIP_DefaultURL(t *testing.T) {
 	}
 
 	// Verify global manager was set
-	globalMutex.RLock()
-	hasManager := globalGeoIPMgr != nil
-	globalMutex.RUnlock()
+	defaultEngine.mu.RLock()
+	hasManager := defaultEngine.geoIPMgr != nil
+	defaultEngine.mu.RUnlock()
 
 	if !hasManager {
 		t.Error("Expected global GeoIP manager to be set")
@@ -355,6 +391,18 @@ func TestGetConfig(t *testing.T) {
 	}
 }
 
+// registerSourceDomains replaces the source domains of the default engine (as Init does)
+func registerSourceDomains(urls ...string) {
+	defaultEngine.mu.Lock()
+	defaultEngine.publishSources(newSourceDomains(urls...))
+	defaultEngine.mu.Unlock()
+}
+
+// isSourceDomain reports whether domain is a source domain of the default engine
+func isSourceDomain(domain string) bool {
+	return defaultEngine.loadState().sources.has(domain)
+}
+
 func TestSourceDomains_RegisterAndCheck(t *testing.T) {
 	// Test registerSourceDomains extracts hostnames correctly
 	registerSourceDomains(
@@ -402,12 +450,13 @@ func TestSourceDomains_OverridesGlobalMode(t *testing.T) {
 	registerSourceDomains("https://cdn.jsdelivr.net/some/path")
 
 	// Set up global mode
-	globalMutex.Lock()
-	globalConfig = &Config{
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{
 		IsGlobal:     true,
 		GlobalTarget: TargetProxy,
 	}
-	globalMutex.Unlock()
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 
 	target := Match("cdn.jsdelivr.net")
 	if target != TargetDirect {
@@ -422,9 +471,10 @@ func TestSourceDomains_OverridesGlobalMode(t *testing.T) {
 
 	// Cleanup
 	registerSourceDomains()
-	globalMutex.Lock()
-	globalConfig = nil
-	globalMutex.Unlock()
+	defaultEngine.mu.Lock()
+	defaultEngine.config = nil
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 }
 
 func TestSourceDomains_ReInitUpdates(t *testing.T) {
@@ -454,7 +504,7 @@ func TestSourceDomains_HigherPriorityThanTmpRule(t *testing.T) {
 	registerSourceDomains("https://cdn.jsdelivr.net/some/path")
 
 	// Set TmpRule to proxy cdn.jsdelivr.net
-	globalTmpRules.Store("cdn.jsdelivr.net", TargetProxy)
+	defaultEngine.tmpRules.store("cdn.jsdelivr.net", TargetProxy)
 
 	target := Match("cdn.jsdelivr.net")
 	if target != TargetDirect {
@@ -462,7 +512,7 @@ func TestSourceDomains_HigherPriorityThanTmpRule(t *testing.T) {
 	}
 
 	// Cleanup
-	globalTmpRules.Delete("cdn.jsdelivr.net")
+	defaultEngine.tmpRules.delete("cdn.jsdelivr.net")
 	registerSourceDomains()
 }
 
@@ -488,3 +538,175 @@ func TestSetGlobalTarget(t *testing.T) {
 		t.Errorf("SetGlobalTarget(TargetReject) did not update config, got %v", currentConfig.GlobalTarget)
 	}
 }
+
+func TestMatch_UnknownInput(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, GlobalTarget: TargetProxy}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	unknown := []string{"", ".", "a..b", "https://blocked.com/", "blocked.com:443", "foo bar", "-" + strings.Repeat("a", 64) + ".com"}
+	for _, input := range unknown {
+		if r := MatchVerbose(input); !r.Unknown || !r.Fallback || r.Target != TargetDirect {
+			t.Errorf("MatchVerbose(%q) = %+v, want unknown input routed to fallback", input, r)
+		}
+	}
+	for _, input := range []string{"blocked.com", "x.blocked.com.", "localhost", "_dmarc.example.com", "例子.中国"} {
+		if r := MatchVerbose(input); r.Unknown {
+			t.Errorf("MatchVerbose(%q) = %+v, want valid domain", input, r)
+		}
+	}
+
+	reject := TargetReject
+	defaultEngine.mu.Lock()
+	defaultEngine.config.UnknownInputTarget = &reject
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	if r := MatchVerbose("blocked.com:443"); !r.Unknown || r.Fallback || r.Target != TargetReject {
+		t.Errorf("MatchVerbose with UnknownInputTarget=REJECT = %+v", r)
+	}
+
+	// TmpRules and global mode take precedence
+	SetTmpRule("service-name:http", TargetProxy)
+	if got := Match("service-name:http"); got != TargetProxy {
+		t.Errorf("Match(TmpRule input) = %v, want PROXY", got)
+	}
+	ToggleGlobal(true)
+	if got := Match("foo bar"); got != TargetProxy {
+		t.Errorf("Match(foo bar) in global mode = %v, want PROXY", got)
+	}
+}
+
+func TestMatch_InputFallbacks(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	proxy, direct, reject := TargetProxy, TargetDirect, TargetReject
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, DomainFallback: &proxy, IPFallback: &direct, UnknownInputTarget: &reject}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		input string
+		want  Target
+	}{
+		{"example.com", TargetProxy},  // DomainFallback
+		{"blocked.com", TargetReject}, // matched rules are unaffected
+		{"8.8.8.8", TargetDirect},     // IPFallback
+		{"foo bar", TargetReject},     // UnknownInputTarget
+	}
+	for _, tt := range tests {
+		if got := Match(tt.input); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+	if r := MatchVerbose("example.com"); !r.Fallback {
+		t.Errorf("MatchVerbose(example.com) = %+v, want a fallback result", r)
+	}
+
+	// The network profile's Fallback takes precedence
+	globalNetwork.setProfiles([]NetworkProfile{{Name: "cafe", SSIDs: []string{"cafe"}, Fallback: &reject}})
+	SetNetworkContext(NetworkContext{SSID: "cafe"})
+	if got := Match("8.8.8.8"); got != TargetReject {
+		t.Errorf("Match(8.8.8.8) with profile Fallback = %v, want REJECT", got)
+	}
+}
+
+func TestInit_RetiresPreviousManagers(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// Downloads fail, so every manager keeps retrying in the background
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := func(dir string) *Config {
+		return &Config{
+			RuleURL:  server.URL + "/rules.k2r.gz",
+			GeoIPURL: server.URL + "/geoip.mmdb.gz",
+			PornURL:  server.URL + "/porn.k2r.gz",
+			Antiporn: true,
+			CacheDir: dir,
+		}
+	}
+	stopped := func(ch chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var previous []managerSet
+	for i := 0; i < 3; i++ {
+		if err := Init(config(filepath.Join(t.TempDir(), "cache"))); err != nil {
+			t.Fatalf("Init #%d error: %v", i, err)
+		}
+		defaultEngine.mu.RLock()
+		current := defaultEngine.installedManagers()
+		defaultEngine.mu.RUnlock()
+		if current.rules == nil || current.geoIP == nil || current.porn == nil {
+			t.Fatalf("Init #%d: managers not installed: %+v", i, current)
+		}
+		if stopped(current.rules.stopCh) || stopped(current.geoIP.stopCh) || stopped(current.porn.stopCh) {
+			t.Errorf("Init #%d: current managers stopped", i)
+		}
+		for j, old := range previous {
+			if !stopped(old.rules.stopCh) || !stopped(old.geoIP.stopCh) || !stopped(old.porn.stopCh) {
+				t.Errorf("Init #%d: managers of Init #%d still running", i, j)
+			}
+		}
+		previous = append(previous, current)
+	}
+
+	// Switching to a combined source retires the per-component managers
+	bundleConfig := &Config{
+		BundleURL: server.URL + "/bundle.tar.gz",
+		Antiporn:  true,
+		CacheDir:  filepath.Join(t.TempDir(), "cache"),
+	}
+	if err := Init(bundleConfig); err != nil {
+		t.Fatalf("Init(bundle) error: %v", err)
+	}
+	defaultEngine.mu.RLock()
+	current := defaultEngine.installedManagers()
+	defaultEngine.mu.RUnlock()
+	if current.bundle == nil || stopped(current.bundle.stopCh) {
+		t.Fatal("bundle manager not running")
+	}
+	last := previous[len(previous)-1]
+	if !stopped(last.rules.stopCh) || !stopped(last.geoIP.stopCh) || !stopped(last.porn.stopCh) {
+		t.Error("per-component managers still running after switching to BundleURL")
+	}
+
+	// Re-Init with the combined source retires the previous bundle manager
+	if err := Init(config(filepath.Join(t.TempDir(), "cache"))); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	if !stopped(current.bundle.stopCh) {
+		t.Error("bundle manager still running after re-Init")
+	}
+}
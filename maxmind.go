@@ -0,0 +1,115 @@
+package k2rule
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaitu-io/k2rule/internal/filelock"
+)
+
+// MaxMindDownloadURL is the official MaxMind database download endpoint; %s is the
+// edition ID. Requests authenticate with HTTP Basic (account ID : license key).
+const MaxMindDownloadURL = "https://download.maxmind.com/geoip/databases/%s/download?suffix=tar.gz"
+
+// DefaultMaxMindEdition is the edition downloaded when MaxMindLicense.Edition is empty
+const DefaultMaxMindEdition = "GeoLite2-Country"
+
+// MaxMindLicense configures GeoIP downloads directly from MaxMind (Config.GeoIPMaxMind),
+// for users whose license terms require it instead of the DefaultGeoIPURL mirror.
+// The license key is sent as Basic authentication only: it is never part of the
+// URL, the cache file name or logs.
+//
+// Example:
+//
+//	config := &k2rule.Config{
+//	    GeoIPMaxMind: &k2rule.MaxMindLicense{AccountID: "123456", LicenseKey: os.Getenv("MAXMIND_LICENSE_KEY")},
+//	    CacheDir:     cacheDir,
+//	}
+type MaxMindLicense struct {
+	AccountID  string `json:"account_id"`
+	LicenseKey string `json:"license_key"`
+	Edition    string `json:"edition,omitempty"` // Edition ID ("" = DefaultMaxMindEdition), e.g. "GeoIP2-Country"
+}
+
+// validate checks that the credentials are set
+func (l *MaxMindLicense) validate() error {
+	if l.AccountID == "" || l.LicenseKey == "" {
+		return fmt.Errorf("GeoIPMaxMind requires AccountID and LicenseKey")
+	}
+	if strings.ContainsAny(l.Edition, "/?#") {
+		return fmt.Errorf("invalid MaxMind edition %q", l.Edition)
+	}
+	return nil
+}
+
+// downloadURL returns the download URL of the edition
+func (l *MaxMindLicense) downloadURL() string {
+	return fmt.Sprintf(MaxMindDownloadURL, url.PathEscape(defaultIfEmpty(l.Edition, DefaultMaxMindEdition)))
+}
+
+// auth returns the Basic credentials of the license
+func (l *MaxMindLicense) auth() *SourceAuth {
+	return &SourceAuth{Username: l.AccountID, Password: l.LicenseKey}
+}
+
+// isTarGzURL reports whether rawURL serves a tar.gz archive: a path ending in
+// .tar.gz or .tgz, or MaxMind's suffix=tar.gz query
+func isTarGzURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Path, ".tar.gz") || strings.HasSuffix(u.Path, ".tgz") ||
+		u.Query().Get("suffix") == "tar.gz"
+}
+
+// extractMMDB writes the first .mmdb file of the tar.gz archive at archivePath to
+// destPath. The extracted file is checked with validate (nil = none) before it
+// atomically replaces destPath, holding the cross-process lock on destPath+".lock".
+func extractMMDB(archivePath, destPath string, validate func(path string) error) error {
+	tmpPath := ""
+	err := readTarGz(archivePath, func(name string, r io.Reader) error {
+		if tmpPath != "" || !strings.HasSuffix(name, ".mmdb") {
+			return nil
+		}
+		tmpFile, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".*.tmp")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tmpPath = tmpFile.Name()
+		_, err = io.Copy(tmpFile, r)
+		tmpFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+		return nil
+	})
+	if tmpPath != "" {
+		defer os.Remove(tmpPath) // no-op after the rename
+	}
+	if err != nil {
+		return err
+	}
+	if tmpPath == "" {
+		return fmt.Errorf("archive has no .mmdb file")
+	}
+	if validate != nil {
+		if err := validate(tmpPath); err != nil {
+			return fmt.Errorf("download rejected by validator: %w", err)
+		}
+	}
+
+	lock, err := filelock.Acquire(destPath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package k2rule
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/mmdb"
+)
+
+// buildTestMMDB returns a country database mapping 8.8.8.0/24 to US
+func buildTestMMDB(t *testing.T) []byte {
+	t.Helper()
+	w := mmdb.NewWriter("GeoLite2-Country", "")
+	if err := w.Insert(netip.MustParsePrefix("8.8.8.0/24"), map[string]string{"country.iso_code": "US"}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsTarGzURL(t *testing.T) {
+	for rawURL, want := range map[string]bool{
+		"https://download.maxmind.com/geoip/databases/GeoLite2-Country/download?suffix=tar.gz": true,
+		"https://mirror.example.com/GeoLite2-Country.tar.gz":                                   true,
+		"https://mirror.example.com/geoip.tgz?v=2":                                             true,
+		"https://mirror.example.com/GeoLite2-Country.mmdb.gz":                                  false,
+		"https://mirror.example.com/geoip.mmdb?suffix=zip":                                     false,
+	} {
+		if got := isTarGzURL(rawURL); got != want {
+			t.Errorf("isTarGzURL(%s) = %v, want %v", rawURL, got, want)
+		}
+	}
+}
+
+func TestMaxMindLicense(t *testing.T) {
+	l := &MaxMindLicense{AccountID: "123456", LicenseKey: "secret"}
+	if err := l.validate(); err != nil {
+		t.Errorf("validate() failed: %v", err)
+	}
+	if got, want := l.downloadURL(), "https://download.maxmind.com/geoip/databases/GeoLite2-Country/download?suffix=tar.gz"; got != want {
+		t.Errorf("downloadURL() = %s, want %s", got, want)
+	}
+	if strings.Contains(l.downloadURL(), "secret") {
+		t.Error("downloadURL() contains the license key")
+	}
+	l.Edition = "GeoIP2-Country"
+	if !strings.Contains(l.downloadURL(), "/GeoIP2-Country/") {
+		t.Errorf("downloadURL() = %s, want edition GeoIP2-Country", l.downloadURL())
+	}
+
+	for name, bad := range map[string]*MaxMindLicense{
+		"no account":  {LicenseKey: "secret"},
+		"no key":      {AccountID: "123456"},
+		"bad edition": {AccountID: "123456", LicenseKey: "secret", Edition: "../x"},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%s: validate() succeeded", name)
+		}
+	}
+
+	for name, config := range map[string]Config{
+		"GeoIPURL":  {CacheDir: "/tmp", GeoIPMaxMind: l, GeoIPURL: "https://example.com/geoip.mmdb.gz"},
+		"GeoIPAuth": {CacheDir: "/tmp", GeoIPMaxMind: l, GeoIPAuth: &SourceAuth{Username: "u"}},
+		"BundleURL": {CacheDir: "/tmp", GeoIPMaxMind: l, BundleURL: "https://example.com/bundle.tar.gz"},
+		"invalid":   {CacheDir: "/tmp", GeoIPMaxMind: &MaxMindLicense{AccountID: "123456"}},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: Validate() succeeded", name)
+		}
+	}
+}
+
+func TestGeoIPManager_MaxMindArchive(t *testing.T) {
+	archive := buildTestBundle(t, map[string][]byte{
+		"GeoLite2-Country_20261013/COPYRIGHT.txt":         []byte("Database and Contents Copyright (c) MaxMind, Inc."),
+		"GeoLite2-Country_20261013/GeoLite2-Country.mmdb": buildTestMMDB(t),
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "123456" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"a1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"a1"`)
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	m := NewGeoIPManager(server.URL+"/geoip/databases/GeoLite2-Country/download?suffix=tar.gz", cacheDir)
+	defer m.Stop()
+
+	// Without the license the download fails and nothing is cached
+	if err := m.Update(); err == nil {
+		t.Fatal("Update() without credentials succeeded")
+	}
+
+	license := &MaxMindLicense{AccountID: "123456", LicenseKey: "secret"}
+	m.dl.auth = license.auth()
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if country, err := m.LookupCountry(net.ParseIP("8.8.8.8")); err != nil || country != "US" {
+		t.Errorf("LookupCountry(8.8.8.8) = %q, %v, want US", country, err)
+	}
+	if _, err := os.Stat(m.getCachePath() + ".tar.gz"); !os.IsNotExist(err) {
+		t.Errorf("archive kept in the cache directory: %v", err)
+	}
+
+	// Not modified: no re-extraction
+	if err := m.Update(); err != nil {
+		t.Fatalf("second Update() failed: %v", err)
+	}
+	if got := m.Status().Generation; got != 1 {
+		t.Errorf("Generation = %d, want 1", got)
+	}
+
+	// An archive without a database is rejected and the loaded one is kept
+	archive = buildTestBundle(t, map[string][]byte{"README.txt": []byte("no database")})
+	m.mu.Lock()
+	m.etag = ""
+	m.mu.Unlock()
+	if err := m.Update(); err == nil || !strings.Contains(err.Error(), ".mmdb") {
+		t.Errorf("Update() with an archive without .mmdb = %v, want error", err)
+	}
+	if country, _ := m.LookupCountry(net.ParseIP("8.8.8.8")); country != "US" {
+		t.Errorf("LookupCountry(8.8.8.8) after failed update = %q, want US", country)
+	}
+}
+
+func TestKeepLocalSettings_MaxMindLicense(t *testing.T) {
+	current := &Config{GeoIPMaxMind: &MaxMindLicense{AccountID: "123456", LicenseKey: "secret"}}
+
+	// Exported documents never carry the license: it is kept from the local config
+	config := &Config{}
+	keepLocalSettings(config, current)
+	if config.GeoIPMaxMind != current.GeoIPMaxMind {
+		t.Error("keepLocalSettings did not keep GeoIPMaxMind")
+	}
+
+	// A document selecting another GeoIP source drops it
+	config = &Config{GeoIPURL: "https://mirror.example.com/geoip.mmdb.gz"}
+	keepLocalSettings(config, current)
+	if config.GeoIPMaxMind != nil {
+		t.Error("keepLocalSettings kept GeoIPMaxMind with a GeoIPURL document")
+	}
+}
@@ -0,0 +1,51 @@
+package k2rule
+
+import "sync/atomic"
+
+// MatchMiddleware post-processes a decision of Match, MatchVerbose and IsExplicitMatch:
+// it receives the input and the rule pipeline's result and returns the final result.
+// Middleware runs in registration order after all built-in steps (LAN bypass, TmpRules,
+// global mode, rules and fallback); it must be safe for concurrent use and fast, as it
+// runs on every lookup. REJECT-DROP and REJECT-RESET decisions reach middleware as
+// TargetReject with MatchResult.RejectMode set, and are folded the same way when
+// middleware returns them.
+//
+// Example (reject risky domains that no rule decided):
+//
+//	k2rule.UseMiddleware(k2rule.NewRiskScorer(nil).Middleware(70, k2rule.TargetReject))
+type MatchMiddleware func(input string, result MatchResult) MatchResult
+
+// globalMiddleware is the registered middleware chain (copy-on-write)
+var globalMiddleware atomic.Pointer[[]MatchMiddleware]
+
+// UseMiddleware appends middleware to the chain applied to every match
+func UseMiddleware(mw ...MatchMiddleware) {
+	for {
+		old := globalMiddleware.Load()
+		var chain []MatchMiddleware
+		if old != nil {
+			chain = append(chain, *old...)
+		}
+		chain = append(chain, mw...)
+		if globalMiddleware.CompareAndSwap(old, &chain) {
+			return
+		}
+	}
+}
+
+// ClearMiddleware removes all middleware registered with UseMiddleware
+func ClearMiddleware() {
+	globalMiddleware.Store(nil)
+}
+
+// applyMiddleware runs the middleware chain over result
+func applyMiddleware(input string, result MatchResult) MatchResult {
+	chain := globalMiddleware.Load()
+	if chain == nil {
+		return result
+	}
+	for _, mw := range *chain {
+		result = mw(input, result.withRejectMode())
+	}
+	return result
+}
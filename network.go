@@ -0,0 +1,271 @@
+package k2rule
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// NetworkContext describes the network the device is on (see SetNetworkContext).
+// Empty fields are unknown.
+type NetworkContext struct {
+	SSID      string `json:"ssid,omitempty"`       // Wi-Fi network name
+	Interface string `json:"interface,omitempty"`  // Active interface, e.g. "en0", "utun3", "wlan0"
+	DNSSuffix string `json:"dns_suffix,omitempty"` // DNS search domain from DHCP/VPN, e.g. "corp.example.com"
+}
+
+// NetworkProfile adjusts routing while the device is on matching networks
+// (see Config.NetworkProfiles and SetNetworkContext).
+//
+// A profile is selected when each of SSIDs, Interfaces and DNSSuffixes is empty or
+// has a pattern matching the context (path.Match syntax, e.g. "utun*"); unknown
+// context fields match no pattern. DNS suffixes are compared case-insensitively.
+type NetworkProfile struct {
+	Name string `json:"name"`
+
+	// Selection
+	SSIDs       []string `json:"ssids,omitempty"`
+	Interfaces  []string `json:"interfaces,omitempty"`
+	DNSSuffixes []string `json:"dns_suffixes,omitempty"`
+
+	// Rules route domains (including subdomains), IPs and CIDRs, e.g.
+	// {"intranet.example.com": DIRECT, "10.20.0.0/16": DIRECT}. They take precedence
+	// over category policies, global mode and rule files, but not over TmpRules.
+	Rules map[string]Target `json:"rules,omitempty"`
+
+	// DirectDNSSuffix routes domains under the context's DNSSuffix DIRECT, so
+	// split-horizon names of the current network resolve and connect locally
+	DirectDNSSuffix bool `json:"direct_dns_suffix,omitempty"`
+
+	Global   *bool   `json:"global,omitempty"`   // Overrides Config.IsGlobal (nil = unchanged)
+	Fallback *Target `json:"fallback,omitempty"` // Overrides the rule fallback for unmatched traffic (nil = unchanged)
+}
+
+// globalNetwork holds the network context and the profile it selects
+var globalNetwork networkState
+
+// networkState is the current network context and active profile
+type networkState struct {
+	mu       sync.Mutex
+	context  NetworkContext
+	profiles []*networkProfile
+	active   atomic.Pointer[networkProfile]
+}
+
+// networkProfile is a compiled NetworkProfile
+type networkProfile struct {
+	NetworkProfile
+	domains   map[string]Target
+	prefixes  []netip.Prefix
+	prefixTo  []Target
+	dnsSuffix string // Context DNS suffix routed DIRECT ("" = none)
+}
+
+// SetNetworkContext reports the network the device is on, e.g. from the platform's
+// network change callback. The first of Config.NetworkProfiles selected by ctx
+// becomes active and Match adapts immediately; an EventNetwork is published when
+// the active profile changes.
+//
+// Example:
+//
+//	k2rule.SetNetworkContext(k2rule.NetworkContext{SSID: "HQ-Staff", DNSSuffix: "corp.example.com"})
+//	k2rule.Match("wiki.corp.example.com") // → DIRECT with a DirectDNSSuffix profile
+func SetNetworkContext(ctx NetworkContext) {
+	globalNetwork.mu.Lock()
+	globalNetwork.context = ctx
+	changed := globalNetwork.selectLocked()
+	globalNetwork.mu.Unlock()
+	if changed {
+		publishNetwork()
+	}
+}
+
+// ActiveNetworkProfile returns the network context and the name of the profile it
+// selected ("" = none)
+func ActiveNetworkProfile() (NetworkContext, string) {
+	globalNetwork.mu.Lock()
+	defer globalNetwork.mu.Unlock()
+	var name string
+	if p := globalNetwork.active.Load(); p != nil {
+		name = p.Name
+	}
+	return globalNetwork.context, name
+}
+
+// setProfiles replaces the configured profiles (already validated) and reselects
+func (s *networkState) setProfiles(profiles []NetworkProfile) {
+	compiled, _ := compileNetworkProfiles(profiles)
+	s.mu.Lock()
+	s.profiles = compiled
+	changed := s.selectLocked()
+	s.mu.Unlock()
+	if changed {
+		publishNetwork()
+	}
+}
+
+// selectLocked activates the first profile selected by the context (s.mu held),
+// reporting whether the active profile changed
+func (s *networkState) selectLocked() bool {
+	var selected *networkProfile
+	for _, p := range s.profiles {
+		if p.selects(s.context) {
+			selected = p
+			if p.DirectDNSSuffix {
+				copied := *p
+				copied.dnsSuffix = normalizeOverlayDomain(s.context.DNSSuffix)
+				selected = &copied
+			}
+			break
+		}
+	}
+	old := s.active.Swap(selected)
+	return profileName(old) != profileName(selected)
+}
+
+// publishNetwork publishes an EventNetwork for the active profile
+func publishNetwork() {
+	publish(Event{Type: EventNetwork, Profile: profileName(globalNetwork.active.Load())})
+}
+
+// profileName returns the name of p ("" for nil)
+func profileName(p *networkProfile) string {
+	if p == nil {
+		return ""
+	}
+	return p.Name
+}
+
+// compileNetworkProfiles validates and compiles profiles
+func compileNetworkProfiles(profiles []NetworkProfile) ([]*networkProfile, error) {
+	names := make(map[string]bool, len(profiles))
+	compiled := make([]*networkProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("network profile name is required")
+		}
+		if names[profile.Name] {
+			return nil, fmt.Errorf("duplicate network profile %q", profile.Name)
+		}
+		names[profile.Name] = true
+
+		for _, patterns := range [][]string{profile.SSIDs, profile.Interfaces, profile.DNSSuffixes} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("network profile %q: invalid pattern %q", profile.Name, pattern)
+				}
+			}
+		}
+
+		p := &networkProfile{NetworkProfile: profile, domains: make(map[string]Target)}
+		p.DNSSuffixes = lowerAll(profile.DNSSuffixes)
+		for key, target := range profile.Rules {
+			if prefix, err := netip.ParsePrefix(key); err == nil {
+				p.prefixes = append(p.prefixes, prefix.Masked())
+				p.prefixTo = append(p.prefixTo, target)
+			} else if addr, err := netip.ParseAddr(key); err == nil {
+				p.prefixes = append(p.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+				p.prefixTo = append(p.prefixTo, target)
+			} else if domain := normalizeOverlayDomain(key); isValidDomain(domain) {
+				p.domains[domain] = target
+			} else {
+				return nil, fmt.Errorf("network profile %q: invalid rule %q", profile.Name, key)
+			}
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// selects reports whether the profile applies to ctx
+func (p *networkProfile) selects(ctx NetworkContext) bool {
+	return matchesAny(p.SSIDs, ctx.SSID) &&
+		matchesAny(p.Interfaces, ctx.Interface) &&
+		matchesAny(p.DNSSuffixes, normalizeOverlayDomain(ctx.DNSSuffix))
+}
+
+// matchDomain returns the profile rule for domain or a parent domain
+func (p *networkProfile) matchDomain(domain string) (Target, bool) {
+	domain = normalizeOverlayDomain(domain)
+	if p.dnsSuffix != "" && hasDomainSuffix(domain, p.dnsSuffix) {
+		return TargetDirect, true
+	}
+	for {
+		if target, ok := p.domains[domain]; ok {
+			return target, true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return 0, false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// matchIP returns the target of the most specific profile rule containing ip
+func (p *networkProfile) matchIP(ip net.IP) (Target, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || len(p.prefixes) == 0 {
+		return 0, false
+	}
+	addr = addr.Unmap()
+	best := -1
+	for i, prefix := range p.prefixes {
+		if prefix.Contains(addr) && (best < 0 || prefix.Bits() > p.prefixes[best].Bits()) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return p.prefixTo[best], true
+}
+
+// applyFallback replaces the target of a fallback result with the profile's Fallback
+func (p *networkProfile) applyFallback(result MatchResult) MatchResult {
+	if p != nil && p.Fallback != nil && result.Fallback && !result.Unknown {
+		result.Target = *p.Fallback
+		result.Profile = p.Name
+	}
+	return result
+}
+
+// isGlobal reports whether global mode is on, honoring the profile's Global override
+func isGlobal(config *Config, p *networkProfile) bool {
+	if p != nil && p.Global != nil {
+		return *p.Global && config != nil
+	}
+	return config != nil && config.IsGlobal
+}
+
+// matchesAny reports whether patterns is empty or one of them matches s (s != "")
+func matchesAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	if s == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll returns the normalized domain forms of patterns
+func lowerAll(patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	lowered := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		lowered[i] = normalizeOverlayDomain(pattern)
+	}
+	return lowered
+}
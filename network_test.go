@@ -0,0 +1,141 @@
+package k2rule
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetNetworkContext(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, path, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	proxy, enabled := TargetProxy, true
+	config := &Config{CacheDir: tmpDir, GlobalTarget: TargetProxy, NetworkProfiles: []NetworkProfile{
+		{
+			Name:            "office",
+			SSIDs:           []string{"HQ-*"},
+			DNSSuffixes:     []string{"Corp.Example.com"},
+			DirectDNSSuffix: true,
+			Rules:           map[string]Target{"blocked.com": TargetDirect, "203.0.113.0/24": TargetProxy},
+		},
+		{Name: "public", SSIDs: []string{"*"}, Fallback: &proxy},
+		{Name: "vpn", Interfaces: []string{"utun*"}, Global: &enabled},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = config
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	globalNetwork.setProfiles(config.NetworkProfiles)
+
+	events := make(chan Event, 8)
+	Subscribe(events)
+	defer Unsubscribe(events)
+
+	if _, name := ActiveNetworkProfile(); name != "" {
+		t.Fatalf("active profile without context = %q", name)
+	}
+	if got := Match("unknown.com"); got != TargetDirect {
+		t.Errorf("Match without profile = %v, want DIRECT", got)
+	}
+
+	SetNetworkContext(NetworkContext{SSID: "HQ-Staff", DNSSuffix: "corp.example.com."})
+	if _, name := ActiveNetworkProfile(); name != "office" {
+		t.Fatalf("active profile = %q, want office", name)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventNetwork || e.Profile != "office" {
+			t.Errorf("event = %+v, want network/office", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("no EventNetwork published")
+	}
+	tests := []struct {
+		input string
+		want  Target
+	}{
+		{"ads.blocked.com", TargetDirect},       // profile rule overrides the rule file
+		{"wiki.corp.example.com", TargetDirect}, // DNS suffix
+		{"203.0.113.4", TargetProxy},            // profile CIDR
+		{"unknown.com", TargetDirect},
+	}
+	for _, tt := range tests {
+		if result := MatchVerbose(tt.input); result.Target != tt.want {
+			t.Errorf("office: Match(%s) = %v, want %v", tt.input, result.Target, tt.want)
+		}
+	}
+	if result := MatchVerbose("ads.blocked.com"); result.Profile != "office" {
+		t.Errorf("Profile = %q, want office", result.Profile)
+	}
+
+	// TmpRules take precedence over profile rules
+	SetTmpRule("blocked.com", TargetProxy)
+	if got := Match("blocked.com"); got != TargetProxy {
+		t.Errorf("Match with TmpRule = %v, want PROXY", got)
+	}
+	ClearTmpRules()
+
+	SetNetworkContext(NetworkContext{SSID: "CoffeeShop"})
+	if result := MatchVerbose("unknown.com"); result.Target != TargetProxy || result.Profile != "public" {
+		t.Errorf("public: Match(unknown.com) = %+v, want PROXY via public", result)
+	}
+	if got := Match("ads.blocked.com"); got != TargetReject {
+		t.Errorf("public: Match(ads.blocked.com) = %v, want REJECT", got)
+	}
+
+	SetNetworkContext(NetworkContext{Interface: "utun3"})
+	if got := Match("ads.blocked.com"); got != TargetProxy {
+		t.Errorf("vpn: Match(ads.blocked.com) = %v, want PROXY (global)", got)
+	}
+
+	SetNetworkContext(NetworkContext{})
+	if got := Match("ads.blocked.com"); got != TargetReject {
+		t.Errorf("no network: Match(ads.blocked.com) = %v, want REJECT", got)
+	}
+}
+
+func TestNetworkProfile_MatchIP(t *testing.T) {
+	profiles, err := compileNetworkProfiles([]NetworkProfile{{
+		Name:  "office",
+		Rules: map[string]Target{"203.0.113.0/24": TargetDirect, "203.0.113.9": TargetReject, "2001:db8::/32": TargetProxy},
+	}})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	p := profiles[0]
+	for ip, want := range map[string]Target{"203.0.113.1": TargetDirect, "203.0.113.9": TargetReject, "2001:db8::1": TargetProxy} {
+		if got, ok := p.matchIP(net.ParseIP(ip)); !ok || got != want {
+			t.Errorf("matchIP(%s) = %v, %v; want %v", ip, got, ok, want)
+		}
+	}
+	if _, ok := p.matchIP(net.ParseIP("198.51.100.1")); ok {
+		t.Error("matchIP(outside) matched")
+	}
+}
+
+func TestCompileNetworkProfiles_Invalid(t *testing.T) {
+	tests := map[string][]NetworkProfile{
+		"missing name": {{SSIDs: []string{"x"}}},
+		"duplicate":    {{Name: "a"}, {Name: "a"}},
+		"bad pattern":  {{Name: "a", SSIDs: []string{"["}}},
+		"bad rule":     {{Name: "a", Rules: map[string]Target{"not a domain!": TargetDirect}}},
+	}
+	for name, profiles := range tests {
+		if _, err := compileNetworkProfiles(profiles); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package k2rule
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Object storage sources: s3://bucket/key, gs://bucket/key and oss://bucket/key URLs are
+// downloaded over HTTPS from the provider's S3-compatible endpoint. Private buckets are
+// accessed with SourceAuth.ObjectStorage HMAC credentials (AWS Signature Version 4, which
+// GCS interoperability keys and OSS S3-compatible access also accept).
+
+// ObjectStorageAuth holds HMAC credentials for S3-compatible object storage
+type ObjectStorageAuth struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token,omitempty"` // Temporary credentials (STS)
+
+	// Region is the bucket region, e.g. "eu-west-1" (s3, default "us-east-1") or
+	// "cn-hangzhou" (oss, required). GCS always signs with "auto".
+	Region string `json:"region,omitempty"`
+
+	// Endpoint overrides the provider endpoint for S3-compatible servers (e.g. MinIO),
+	// e.g. "https://minio.example.com:9000". Objects are addressed path-style.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// RequestSigner signs source download requests, e.g. for a storage provider
+// with its own signature scheme
+type RequestSigner interface {
+	SignRequest(req *http.Request) error
+}
+
+// RequestSignerFunc adapts a function to RequestSigner
+type RequestSignerFunc func(req *http.Request) error
+
+// SignRequest calls f(req)
+func (f RequestSignerFunc) SignRequest(req *http.Request) error {
+	return f(req)
+}
+
+// sigV4Algorithm is the AWS Signature Version 4 algorithm name
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+// isObjectStorageScheme reports whether scheme is an object storage URL scheme
+func isObjectStorageScheme(scheme string) bool {
+	return scheme == "s3" || scheme == "gs" || scheme == "oss"
+}
+
+// resolveObjectURL maps an object storage URL to its HTTPS URL; other URLs are returned unchanged
+func resolveObjectURL(rawURL string, auth *SourceAuth) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !isObjectStorageScheme(u.Scheme) {
+		return rawURL, nil
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", fmt.Errorf("invalid %s URL: bucket and key required", u.Scheme)
+	}
+
+	var storage ObjectStorageAuth
+	if auth != nil && auth.ObjectStorage != nil {
+		storage = *auth.ObjectStorage
+	}
+
+	var resolved string
+	switch {
+	case storage.Endpoint != "":
+		resolved = strings.TrimSuffix(storage.Endpoint, "/") + "/" + bucket + "/" + key
+	case u.Scheme == "s3":
+		region := defaultIfEmpty(storage.Region, "us-east-1")
+		if strings.Contains(bucket, ".") {
+			// Dotted bucket names don't match the wildcard certificate of virtual-hosted style
+			resolved = "https://s3." + region + ".amazonaws.com/" + bucket + "/" + key
+		} else {
+			resolved = "https://" + bucket + ".s3." + region + ".amazonaws.com/" + key
+		}
+	case u.Scheme == "gs":
+		resolved = "https://storage.googleapis.com/" + bucket + "/" + key
+	case u.Scheme == "oss":
+		if storage.Region == "" {
+			return "", fmt.Errorf("oss URL requires ObjectStorage.Region")
+		}
+		resolved = "https://" + bucket + ".oss-" + strings.TrimPrefix(storage.Region, "oss-") + ".aliyuncs.com/" + key
+	}
+	if u.RawQuery != "" {
+		resolved += "?" + u.RawQuery
+	}
+	return resolved, nil
+}
+
+// objectSourceURL returns the HTTPS URL an object storage source is downloaded from,
+// or rawURL itself (used to register source domains)
+func objectSourceURL(rawURL string, auth *SourceAuth) string {
+	if resolved, err := resolveObjectURL(rawURL, auth); err == nil {
+		return resolved
+	}
+	return rawURL
+}
+
+// signingRegion returns the SigV4 region for a request to host
+func (a *ObjectStorageAuth) signingRegion(host string) string {
+	switch {
+	case strings.HasSuffix(host, "storage.googleapis.com"):
+		return "auto"
+	case strings.HasSuffix(host, ".aliyuncs.com"):
+		return "oss-" + strings.TrimPrefix(a.Region, "oss-")
+	default:
+		return defaultIfEmpty(a.Region, "us-east-1")
+	}
+}
+
+// sign adds AWS Signature Version 4 headers to req (unsigned payload)
+func (a *ObjectStorageAuth) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(values, ",")
+		}
+	}
+
+	region := a.signingRegion(req.URL.Hostname())
+	signature, signedHeaders := sigV4Signature(req.Method, req.URL, headers, "UNSIGNED-PAYLOAD",
+		a.SecretAccessKey, region, "s3", now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s/%s/s3/aws4_request, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, a.AccessKeyID, now.Format("20060102"), region, signedHeaders, signature))
+}
+
+// sigV4Signature computes the SigV4 signature of a request over the given
+// (lowercase-named) headers and returns it with the signed header list
+func sigV4Signature(method string, u *url.URL, headers map[string]string, payloadHash, secret, region, service string, now time.Time) (signature, signedHeaders string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		sigV4EncodePath(u.Path),
+		sigV4CanonicalQuery(u.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign)), signedHeaders
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sigV4CanonicalQuery encodes query parameters sorted by name, then value
+func sigV4CanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		sorted := append([]string(nil), values...)
+		sort.Strings(sorted)
+		for _, v := range sorted {
+			pairs = append(pairs, sigV4Encode(name, true)+"="+sigV4Encode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4EncodePath URI-encodes each path segment ("/" if empty)
+func sigV4EncodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return sigV4Encode(path, false)
+}
+
+// sigV4Encode percent-encodes everything but RFC 3986 unreserved characters
+// (and "/" unless encodeSlash)
+func sigV4Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package k2rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSigV4Signature checks signatures against the examples of the AWS S3
+// "Signature Version 4: Authenticating Requests (Authorization Header)" documentation.
+func TestSigV4Signature(t *testing.T) {
+	const (
+		secret      = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+		emptyHash   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		exampleHost = "examplebucket.s3.amazonaws.com"
+	)
+	now := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		url           string
+		headers       map[string]string
+		wantSignature string
+	}{
+		{
+			name: "GET object",
+			url:  "https://examplebucket.s3.amazonaws.com/test.txt",
+			headers: map[string]string{
+				"host": exampleHost, "range": "bytes=0-9",
+				"x-amz-content-sha256": emptyHash, "x-amz-date": "20130524T000000Z",
+			},
+			wantSignature: "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41",
+		},
+		{
+			name: "GET bucket lifecycle",
+			url:  "https://examplebucket.s3.amazonaws.com/?lifecycle",
+			headers: map[string]string{
+				"host": exampleHost, "x-amz-content-sha256": emptyHash, "x-amz-date": "20130524T000000Z",
+			},
+			wantSignature: "fea454ca298b7da1c68078a5d1bdbfbbe0d65c699e0f91ac7a200a0136783543",
+		},
+		{
+			name: "GET bucket list objects",
+			url:  "https://examplebucket.s3.amazonaws.com/?max-keys=2&prefix=J",
+			headers: map[string]string{
+				"host": exampleHost, "x-amz-content-sha256": emptyHash, "x-amz-date": "20130524T000000Z",
+			},
+			wantSignature: "34b48302e7b5fa45bde8084f4b7868a86f0a534bc59db6670ed5711ef69dc6f7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			got, _ := sigV4Signature("GET", u, tt.headers, emptyHash, secret, "us-east-1", "s3", now)
+			if got != tt.wantSignature {
+				t.Errorf("signature = %s, want %s", got, tt.wantSignature)
+			}
+		})
+	}
+}
+
+func TestResolveObjectURL(t *testing.T) {
+	withRegion := func(region, endpoint string) *SourceAuth {
+		return &SourceAuth{ObjectStorage: &ObjectStorageAuth{Region: region, Endpoint: endpoint}}
+	}
+	tests := []struct {
+		rawURL  string
+		auth    *SourceAuth
+		want    string
+		wantErr bool
+	}{
+		{"https://example.com/rules.k2r.gz", nil, "https://example.com/rules.k2r.gz", false},
+		{"s3://rules/cn.k2r.gz", nil, "https://rules.s3.us-east-1.amazonaws.com/cn.k2r.gz", false},
+		{"s3://rules/v1/cn.k2r.gz?versionId=3", withRegion("eu-west-1", ""), "https://rules.s3.eu-west-1.amazonaws.com/v1/cn.k2r.gz?versionId=3", false},
+		{"s3://rules.example.com/cn.k2r.gz", nil, "https://s3.us-east-1.amazonaws.com/rules.example.com/cn.k2r.gz", false},
+		{"s3://rules/cn.k2r.gz", withRegion("", "https://minio.local:9000/"), "https://minio.local:9000/rules/cn.k2r.gz", false},
+		{"gs://rules/cn.k2r.gz", nil, "https://storage.googleapis.com/rules/cn.k2r.gz", false},
+		{"oss://rules/cn.k2r.gz", withRegion("cn-hangzhou", ""), "https://rules.oss-cn-hangzhou.aliyuncs.com/cn.k2r.gz", false},
+		{"oss://rules/cn.k2r.gz", nil, "", true},
+		{"s3://rules/", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveObjectURL(tt.rawURL, tt.auth)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveObjectURL(%q) = %q, %v; want %q, wantErr %v", tt.rawURL, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDownloader_ObjectStorage(t *testing.T) {
+	storage := &ObjectStorageAuth{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	var signerCalled bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rules/cn.k2r.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		// Recompute the signature from the received request
+		headers := map[string]string{"host": r.Host}
+		for name, values := range r.Header {
+			if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+				headers[lower] = strings.Join(values, ",")
+			}
+		}
+		date, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		signature, signed := sigV4Signature(r.Method, r.URL, headers, "UNSIGNED-PAYLOAD", "secret", "us-east-1", "s3", date)
+		want := "AWS4-HMAC-SHA256 Credential=AKID/" + date.Format("20060102") + "/us-east-1/s3/aws4_request, " +
+			"SignedHeaders=" + signed + ", Signature=" + signature
+		if r.Header.Get("Authorization") != want || r.Header.Get("X-Amz-Security-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-Custom-Signature") != "ok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("rules"))
+	}))
+	defer server.Close()
+	storage.Endpoint = server.URL
+
+	d := newDownloader(10 * time.Second)
+	d.auth = &SourceAuth{
+		ObjectStorage: storage,
+		Signer: RequestSignerFunc(func(req *http.Request) error {
+			signerCalled = true
+			req.Header.Set("X-Custom-Signature", "ok")
+			return nil
+		}),
+	}
+	dest := filepath.Join(t.TempDir(), "rules.k2r.gz")
+	if _, _, err := d.fetch("s3://rules/cn.k2r.gz", "", dest, false); err != nil {
+		t.Fatalf("fetch() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "rules" || !signerCalled {
+		t.Errorf("downloaded %q, signer called %v", data, signerCalled)
+	}
+}
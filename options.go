@@ -0,0 +1,151 @@
+package k2rule
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// ManagerOption configures a manager created by NewRemoteRuleManager, NewGeoIPManager,
+// NewPornRemoteManager, NewBundleManager, NewManifestManager or NewSubscriptionManager.
+//
+// Example:
+//
+//	m := k2rule.NewRemoteRuleManager(url, "", k2rule.TargetProxy,
+//		k2rule.WithCacheDir(dir),
+//		k2rule.WithInterval(time.Hour),
+//		k2rule.WithLogger(logger))
+type ManagerOption func(*managerOptions)
+
+// managerOptions collects the ManagerOption settings of a constructor call
+type managerOptions struct {
+	cacheDir string
+	client   *http.Client
+	timeout  DownloadTimeout
+	base     managerBase
+}
+
+// WithCacheDir sets the cache directory (overrides the cacheDir argument)
+func WithCacheDir(dir string) ManagerOption {
+	return func(o *managerOptions) { o.cacheDir = dir }
+}
+
+// WithHTTPClient downloads with the Transport and Timeout of client
+// (a zero Timeout keeps the component's default timeout)
+func WithHTTPClient(client *http.Client) ManagerOption {
+	return func(o *managerOptions) { o.client = client }
+}
+
+// WithDownloadTimeout sets the connect and total download timeouts (zero fields keep
+// the defaults; overrides the Timeout of WithHTTPClient)
+func WithDownloadTimeout(timeout DownloadTimeout) ManagerOption {
+	return func(o *managerOptions) { o.timeout = timeout }
+}
+
+// WithInterval sets the auto-update interval (default: 6h for rules, porn and bundles,
+// 7 days for GeoIP, 15 minutes for manifests; for subscriptions, of the sources without
+// their own interval)
+func WithInterval(interval time.Duration) ManagerOption {
+	return func(o *managerOptions) { o.base.interval = interval }
+}
+
+// WithJitter randomizes each auto-update interval by ±fraction (0..1), so a fleet of
+// clients started together doesn't hit the download servers in lockstep
+func WithJitter(fraction float64) ManagerOption {
+	return func(o *managerOptions) { o.base.jitter = fraction }
+}
+
+// WithReaderMode sets how loaded rule files are held in memory (default ReaderModeAuto)
+func WithReaderMode(mode ReaderMode) ManagerOption {
+	return func(o *managerOptions) { o.base.readerMode = mode }
+}
+
+// WithReadOnlyCache makes the manager treat its cache directory as read-only (e.g. a
+// pre-seeded sandbox): it loads what the cache holds, never downloads or writes there,
+// and Update returns nil after logging the attempt as skipped.
+func WithReadOnlyCache() ManagerOption {
+	return func(o *managerOptions) { o.base.readOnly = true }
+}
+
+// WithLogger sets the logger of the manager (default: slog.Default())
+func WithLogger(logger *slog.Logger) ManagerOption {
+	return func(o *managerOptions) { o.base.log = logger }
+}
+
+// WithValidator sets a check run on every downloaded database file before it replaces
+// the cached copy; an error rejects the download and keeps the current data.
+// For bundles the check receives the archive, for manifests each verified blob.
+func WithValidator(validate func(path string) error) ManagerOption {
+	return func(o *managerOptions) { o.base.validator = validate }
+}
+
+// managerBase holds the ManagerOption settings shared by all managers
+type managerBase struct {
+	interval   time.Duration           // Auto-update interval (0 = component default)
+	jitter     float64                 // Auto-update interval randomization (fraction, 0 = none)
+	log        *slog.Logger            // nil = slog.Default()
+	validator  func(path string) error // nil = no extra validation
+	readerMode ReaderMode              // "" = ReaderModeAuto
+	readOnly   bool                    // Never download or write to the cache directory
+}
+
+// logger returns the manager's logger
+func (b *managerBase) logger() *slog.Logger {
+	if b.log == nil {
+		return slog.Default()
+	}
+	return b.log
+}
+
+// sliceMode returns the reader mode for loaded files. Read-only caches default to
+// in-memory readers, since mmap decompresses into the cache directory.
+func (b *managerBase) sliceMode() slice.Mode {
+	if b.readOnly && (b.readerMode == "" || b.readerMode == ReaderModeAuto) {
+		return slice.ModeInMemory
+	}
+	return b.readerMode.sliceMode()
+}
+
+// skipUpdate reports whether a download must be skipped because the cache is
+// read-only, logging the skipped attempt
+func (b *managerBase) skipUpdate(component string) bool {
+	if !b.readOnly {
+		return false
+	}
+	b.logger().Info(component + " update skipped, cache is read-only")
+	return true
+}
+
+// updateInterval returns the configured auto-update interval, or def
+func (b *managerBase) updateInterval(def time.Duration) time.Duration {
+	if b.interval > 0 {
+		return b.interval
+	}
+	return def
+}
+
+// autoUpdate runs job on the manager's auto-update schedule (every interval, default
+// def) until stop is closed
+func (b *managerBase) autoUpdate(component string, def time.Duration, stop <-chan struct{}, job func() error) {
+	interval := b.updateInterval(def)
+	newScheduler(component, every(interval), b.jitter, b.logger(), func(bool) error { return job() }).run(stop)
+}
+
+// applyManagerOptions applies opts over the positional cacheDir and configures dl;
+// it returns the effective cache directory and the shared settings
+func applyManagerOptions(cacheDir string, dl *downloader, opts []ManagerOption) (string, managerBase) {
+	o := managerOptions{cacheDir: cacheDir}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.client != nil {
+		dl.transport = o.client.Transport
+		if o.client.Timeout > 0 {
+			dl.timeout = o.client.Timeout
+		}
+	}
+	dl.setTimeout(o.timeout)
+	return o.cacheDir, o.base
+}
@@ -0,0 +1,225 @@
+package k2rule
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestManagerOptions_CacheDirAndInterval(t *testing.T) {
+	dir := t.TempDir()
+	m := NewRemoteRuleManager("", "/unused", TargetDirect, WithCacheDir(dir), WithInterval(time.Minute))
+	if m.cacheDir != dir {
+		t.Errorf("cacheDir = %q, want %q", m.cacheDir, dir)
+	}
+	if got := m.updateInterval(6 * time.Hour); got != time.Minute {
+		t.Errorf("updateInterval = %v, want 1m", got)
+	}
+
+	// Defaults without options
+	g := NewGeoIPManager("", dir)
+	if g.cacheDir != dir || g.updateInterval(7*24*time.Hour) != 7*24*time.Hour {
+		t.Errorf("GeoIP defaults: cacheDir %q, interval %v", g.cacheDir, g.updateInterval(7*24*time.Hour))
+	}
+	if g.logger() != slog.Default() {
+		t.Error("logger() without WithLogger should be slog.Default()")
+	}
+}
+
+func TestManagerOptions_HTTPClient(t *testing.T) {
+	data := gzipBytes(t, buildTestPornK2R(t, []string{"adult-example.com"}))
+	var calls atomic.Int32
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(data)),
+				Request:    req,
+			}, nil
+		}),
+	}
+
+	m := NewPornRemoteManager("http://rules.invalid/porn.k2r.gz", t.TempDir(), WithHTTPClient(client))
+	defer m.Stop()
+	if m.dl.timeout != 5*time.Second {
+		t.Errorf("downloader timeout = %v, want 5s", m.dl.timeout)
+	}
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("custom transport called %d times, want 1", calls.Load())
+	}
+	if !m.IsPorn("adult-example.com") {
+		t.Error("IsPorn(adult-example.com) = false after update via custom client")
+	}
+}
+
+func TestManagerOptions_DownloadTimeout(t *testing.T) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	m := NewGeoIPManager("", t.TempDir(), WithHTTPClient(client),
+		WithDownloadTimeout(DownloadTimeout{Connect: Duration(time.Second), Total: Duration(2 * time.Second)}))
+	defer m.Stop()
+	if m.dl.timeout != 2*time.Second {
+		t.Errorf("downloader timeout = %v, want 2s", m.dl.timeout)
+	}
+	if tr, ok := m.dl.transport.(*http.Transport); !ok || tr.TLSHandshakeTimeout != time.Second {
+		t.Errorf("downloader transport = %T, want *http.Transport with a 1s handshake timeout", m.dl.transport)
+	}
+}
+
+func TestManagerOptions_ReaderMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, path, buildTestPornK2R(t, []string{"blocked.com"}))
+
+	for mode, want := range map[ReaderMode]slice.Mode{
+		"":                 slice.ModeInMemory, // auto: small file
+		ReaderModeMmap:     slice.ModeMmap,
+		ReaderModeInMemory: slice.ModeInMemory,
+	} {
+		m := NewRemoteRuleManager("", dir, TargetDirect, WithReaderMode(mode))
+		if err := m.reader.Load(path); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if got := m.reader.Get().Mode(); got != want {
+			t.Errorf("WithReaderMode(%q): reader mode = %v, want %v", mode, got, want)
+		}
+		if got := m.matchDomain("blocked.com"); got != TargetReject {
+			t.Errorf("WithReaderMode(%q): matchDomain = %v, want REJECT", mode, got)
+		}
+		m.Close()
+	}
+}
+
+func TestManagerOptions_LoggerAndValidator(t *testing.T) {
+	version := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if version.Load() == 0 {
+			w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})))
+			return
+		}
+		w.Write([]byte("not a rule file"))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var validated atomic.Int32
+	validator := func(path string) error {
+		validated.Add(1)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			return errors.New("not gzip")
+		}
+		return nil
+	}
+
+	cacheDir := t.TempDir()
+	m := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", cacheDir, TargetDirect,
+		WithLogger(logger), WithValidator(validator))
+	defer m.Close()
+
+	if err := m.Update(); err != nil {
+		t.Fatalf("first Update() failed: %v", err)
+	}
+	if got := m.matchDomain("blocked.com"); got != TargetReject {
+		t.Fatalf("matchDomain(blocked.com) = %v, want REJECT", got)
+	}
+	if !strings.Contains(logs.String(), "downloading") {
+		t.Errorf("WithLogger did not receive download logs: %q", logs.String())
+	}
+
+	// A rejected download keeps the cached file and the loaded rules
+	before, _ := os.ReadFile(m.getCachePath())
+	version.Store(1)
+	if err := m.Update(); err == nil || !strings.Contains(err.Error(), "validator") {
+		t.Fatalf("second Update() error = %v, want validator rejection", err)
+	}
+	after, _ := os.ReadFile(m.getCachePath())
+	if !bytes.Equal(before, after) {
+		t.Error("rejected download replaced the cached file")
+	}
+	if got := m.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("matchDomain(blocked.com) after rejection = %v, want REJECT", got)
+	}
+	if validated.Load() != 2 {
+		t.Errorf("validator called %d times, want 2", validated.Load())
+	}
+}
+
+func TestManagerOptions_ReadOnlyCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"other.com"})))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	m := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", dir, TargetDirect, WithReadOnlyCache())
+	defer m.Close()
+	writeTestK2RGzipFile(t, m.getCachePath(), buildTestPornK2R(t, []string{"blocked.com"}))
+	before, _ := os.ReadDir(dir)
+
+	if err := m.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if got := m.matchDomain("blocked.com"); got != TargetReject {
+		t.Errorf("matchDomain(blocked.com) = %v, want REJECT from cache", got)
+	}
+	if got := m.reader.Get().Mode(); got != slice.ModeInMemory {
+		t.Errorf("reader mode = %v, want memory", got)
+	}
+	if err := m.Update(); err != nil {
+		t.Errorf("Update = %v, want nil (skipped)", err)
+	}
+	if status := m.Status(); !status.ReadOnly || !status.Loaded || status.LastError != "" {
+		t.Errorf("Status = %+v", status)
+	}
+
+	// A missing cache is not downloaded, and nothing is created
+	missingDir := filepath.Join(dir, "missing")
+	geo := NewGeoIPManager(server.URL+"/geo.mmdb", missingDir, WithReadOnlyCache())
+	defer geo.Stop()
+	if err := geo.Init(); err != nil {
+		t.Fatalf("GeoIP Init failed: %v", err)
+	}
+	if err := geo.Update(); err != nil {
+		t.Errorf("GeoIP Update = %v, want nil (skipped)", err)
+	}
+	if geo.Status().Loaded {
+		t.Error("GeoIP loaded without a cached database")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d download requests with a read-only cache", n)
+	}
+	after, _ := os.ReadDir(dir)
+	if len(after) != len(before) {
+		t.Errorf("cache dir entries changed from %d to %d", len(before), len(after))
+	}
+}
@@ -0,0 +1,72 @@
+package k2rule
+
+import "strings"
+
+// domainOverlay is a small add/remove domain set layered over a K2RULEV3 base.
+// Entries use the same suffix semantics as SortedDomain slices: "example.com"
+// covers "example.com" and all of its subdomains.
+//
+// An overlay is immutable once built; updates swap in a new overlay atomically.
+type domainOverlay struct {
+	added   map[string]struct{}
+	removed map[string]struct{}
+}
+
+// newDomainOverlay builds an overlay from added/removed domain lists.
+// A domain present in both lists is treated as removed.
+func newDomainOverlay(added, removed []string) *domainOverlay {
+	o := &domainOverlay{
+		added:   make(map[string]struct{}, len(added)),
+		removed: make(map[string]struct{}, len(removed)),
+	}
+	for _, d := range added {
+		if d = normalizeOverlayDomain(d); d != "" {
+			o.added[d] = struct{}{}
+		}
+	}
+	for _, d := range removed {
+		if d = normalizeOverlayDomain(d); d != "" {
+			delete(o.added, d)
+			o.removed[d] = struct{}{}
+		}
+	}
+	return o
+}
+
+// lookup checks domain against the overlay, most specific suffix first.
+// found=false means the overlay has no opinion and the base must be consulted;
+// otherwise added reports whether the closest matching entry adds or removes the domain.
+func (o *domainOverlay) lookup(domain string) (added bool, found bool) {
+	if o == nil || (len(o.added) == 0 && len(o.removed) == 0) {
+		return false, false
+	}
+
+	suffix := normalizeOverlayDomain(domain)
+	for suffix != "" {
+		if _, ok := o.removed[suffix]; ok {
+			return false, true
+		}
+		if _, ok := o.added[suffix]; ok {
+			return true, true
+		}
+		idx := strings.IndexByte(suffix, '.')
+		if idx < 0 {
+			break
+		}
+		suffix = suffix[idx+1:]
+	}
+	return false, false
+}
+
+// len returns the number of entries in the overlay
+func (o *domainOverlay) len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.added) + len(o.removed)
+}
+
+// normalizeOverlayDomain lowercases and strips leading/trailing dots
+func normalizeOverlayDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,45 @@
+package k2rule
+
+import "testing"
+
+func TestDomainOverlay_Lookup(t *testing.T) {
+	o := newDomainOverlay(
+		[]string{"added.com", "Mixed.Example.ORG", "both.com"},
+		[]string{"removed.com", "safe.added.com", "both.com"},
+	)
+
+	tests := []struct {
+		domain    string
+		wantAdded bool
+		wantFound bool
+	}{
+		{"added.com", true, true},
+		{"www.added.com", true, true},
+		{"safe.added.com", false, true},
+		{"cdn.safe.added.com", false, true},
+		{"mixed.example.org", true, true},
+		{"removed.com", false, true},
+		{"both.com", false, true},
+		{"other.com", false, false},
+		{"com", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			added, found := o.lookup(tt.domain)
+			if added != tt.wantAdded || found != tt.wantFound {
+				t.Errorf("lookup(%q) = (%v, %v), want (%v, %v)", tt.domain, added, found, tt.wantAdded, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestDomainOverlay_Nil(t *testing.T) {
+	var o *domainOverlay
+	if _, found := o.lookup("example.com"); found {
+		t.Error("nil overlay lookup found = true, want false")
+	}
+	if o.len() != 0 {
+		t.Errorf("nil overlay len = %d, want 0", o.len())
+	}
+}
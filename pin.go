@@ -0,0 +1,148 @@
+package k2rule
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parsePin decodes a SHA-256 pin given as hex, optionally colon-separated
+// ("AB:CD:..." as printed by openssl x509 -fingerprint -sha256)
+func parsePin(pin string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 pin %q", pin)
+	}
+	return b, nil
+}
+
+// validatePins checks Config.PinnedCertSHA256. A URL key must name a source of c
+// (as configured or as resolved to HTTPS): a key matching no download would leave
+// the source it was meant for unpinned.
+func validatePins(c *Config) error {
+	var sources map[string]bool
+	for key, list := range c.PinnedCertSHA256 {
+		if strings.Contains(key, "://") {
+			if sources == nil {
+				sources = pinSourceURLs(c)
+			}
+			if !sources[key] {
+				return fmt.Errorf("PinnedCertSHA256[%q] matches no source URL", key)
+			}
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("PinnedCertSHA256[%q] has no pins", key)
+		}
+		for _, pin := range list {
+			if _, err := parsePin(pin); err != nil {
+				return fmt.Errorf("PinnedCertSHA256[%q]: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// pinSourceURLs returns the source URLs of c a pin key may name: each URL as
+// configured and, for object storage URLs, the HTTPS URL it is fetched from
+func pinSourceURLs(c *Config) map[string]bool {
+	type source struct {
+		url  string
+		auth *SourceAuth
+	}
+	sources := []source{
+		{defaultIfEmpty(c.RuleURL, DefaultRuleURL), c.RuleAuth},
+		{defaultIfEmpty(c.GeoIPURL, DefaultGeoIPURL), c.GeoIPAuth},
+		{defaultIfEmpty(c.PornURL, DefaultPornURL), c.PornAuth},
+		{c.PornPatchURL, c.PornAuth},
+		{c.BundleURL, c.BundleAuth},
+		{c.ManifestURL, c.ManifestAuth},
+	}
+	if c.GeoIPMaxMind != nil {
+		sources = append(sources, source{c.GeoIPMaxMind.downloadURL(), nil})
+	}
+	if c.Subscription != nil {
+		for _, src := range c.Subscription.Sources {
+			sources = append(sources, source{src.URL, src.Auth})
+		}
+	}
+
+	urls := make(map[string]bool)
+	for _, src := range sources {
+		if src.url == "" {
+			continue
+		}
+		urls[src.url] = true
+		urls[objectSourceURL(src.url, src.auth)] = true
+	}
+	return urls
+}
+
+// pinsFor returns the decoded pins for a download: an entry for the exact URL of
+// one of urls (the source URL and the URL it resolved to), or else for the
+// hostname of one of them (nil = not pinned)
+func pinsFor(pins map[string][]string, urls ...string) [][]byte {
+	var list []string
+	for _, rawURL := range urls {
+		if l, ok := pins[rawURL]; ok {
+			list = l
+			break
+		}
+	}
+	if list == nil {
+		for _, rawURL := range urls {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				continue
+			}
+			if l, ok := pins[strings.ToLower(u.Hostname())]; ok {
+				list = l
+				break
+			}
+		}
+	}
+
+	var out [][]byte
+	for _, pin := range list {
+		if b, err := parsePin(pin); err == nil {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// pinnedTransport returns a copy of base that rejects TLS connections whose verified
+// chain contains no certificate matching one of pins (by certificate or public key hash).
+// Standard certificate verification still applies.
+func pinnedTransport(base http.RoundTripper, pins [][]byte) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("certificate pinning requires an *http.Transport")
+	}
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				certHash := sha256.Sum256(cert.Raw)
+				keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(pin, certHash[:]) || bytes.Equal(pin, keyHash[:]) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("certificate pin mismatch for %s", cs.ServerName)
+	}
+	return t, nil
+}
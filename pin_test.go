@@ -0,0 +1,138 @@
+package k2rule
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloader_PinnedCert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	certHash := sha256.Sum256(cert.Raw)
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	u, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		name    string
+		pins    map[string][]string
+		wantErr bool
+	}{
+		{"not pinned", nil, false},
+		{"certificate pin by URL", map[string][]string{server.URL: {hex.EncodeToString(certHash[:])}}, false},
+		{"public key pin by host", map[string][]string{u.Hostname(): {hex.EncodeToString(keyHash[:])}}, false},
+		{"colon separated pin", map[string][]string{server.URL: {colonHex(certHash[:])}}, false},
+		{"wrong pin", map[string][]string{server.URL: {strings.Repeat("00", 32)}}, true},
+		{"pin for another host", map[string][]string{"example.com": {strings.Repeat("00", 32)}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDownloader(10 * time.Second)
+			d.transport = server.Client().Transport
+			d.pins = tt.pins
+
+			dest := filepath.Join(t.TempDir(), "data.bin")
+			_, _, err := d.fetch(server.URL, "", dest, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetch error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := os.Stat(dest); !os.IsNotExist(err) {
+					t.Error("dest written despite pin mismatch")
+				}
+			}
+		})
+	}
+}
+
+func TestDownloader_PinnedURLRequiresHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	d := newDownloader(10 * time.Second)
+	d.pins = map[string][]string{server.URL: {strings.Repeat("ab", 32)}}
+	if _, _, err := d.fetch(server.URL, "", filepath.Join(t.TempDir(), "data.bin"), false); err == nil {
+		t.Fatal("fetch of pinned http:// URL succeeded")
+	}
+}
+
+func TestDownloader_PinnedObjectStorageURL(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	certHash := sha256.Sum256(server.Certificate().Raw)
+	const source = "s3://rules/cn.k2r.gz"
+
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"matching pin", hex.EncodeToString(certHash[:]), false},
+		{"wrong pin", strings.Repeat("00", 32), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDownloader(10 * time.Second)
+			d.transport = server.Client().Transport
+			d.auth = &SourceAuth{ObjectStorage: &ObjectStorageAuth{Endpoint: server.URL}}
+			d.pins = map[string][]string{source: {tt.pin}}
+
+			_, _, err := d.fetch(source, "", filepath.Join(t.TempDir(), "data.bin"), false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetch error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidatePins(t *testing.T) {
+	valid := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		pins    map[string][]string
+		wantErr bool
+	}{
+		{"valid", map[string][]string{"cdn.jsdelivr.net": {valid}}, false},
+		{"empty list", map[string][]string{"cdn.jsdelivr.net": {}}, true},
+		{"not hex", map[string][]string{"cdn.jsdelivr.net": {"not-a-pin"}}, true},
+		{"wrong length", map[string][]string{"cdn.jsdelivr.net": {"abcd"}}, true},
+		{"default source URL", map[string][]string{DefaultGeoIPURL: {valid}}, false},
+		{"object storage URL", map[string][]string{"s3://rules/cn.k2r.gz": {valid}}, false},
+		{"resolved object storage URL", map[string][]string{"https://rules.s3.us-east-1.amazonaws.com/cn.k2r.gz": {valid}}, false},
+		{"URL of no source", map[string][]string{"https://mirror.example.com/cn.k2r.gz": {valid}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{CacheDir: t.TempDir(), RuleURL: "s3://rules/cn.k2r.gz", PinnedCertSHA256: tt.pins}
+			if err := config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// colonHex formats b as "AB:CD:..." like openssl fingerprints
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{c}))
+	}
+	return strings.Join(parts, ":")
+}
@@ -1,6 +1,9 @@
 package k2rule
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/kaitu-io/k2rule/internal/porn"
 	"github.com/kaitu-io/k2rule/internal/slice"
 )
@@ -62,3 +65,71 @@ func (c *PornChecker) Close() error {
 func IsPornHeuristic(domain string) bool {
 	return porn.IsPornHeuristic(domain)
 }
+
+// PornHeuristics is a pipeline of porn heuristic layers, for building a detector from
+// a subset of the layers of IsPornHeuristic or adding custom ones (see NewPornHeuristics).
+type PornHeuristics = porn.Heuristics
+
+// PornHeuristicLayer is one porn heuristic layer: a name and a match function
+// receiving the lowercase domain
+type PornHeuristicLayer = porn.Layer
+
+// Porn heuristic layer names, in IsPornHeuristic order
+const (
+	PornLayerKeyword     = porn.LayerKeyword     // Platform brands and "xxx", "sex", "adult"
+	PornLayerTLD         = porn.LayerTLD         // Adult TLDs (.xxx, .adult, .porn, .sex)
+	PornLayer3xPrefix    = porn.Layer3xPrefix    // Domains starting with "3x"
+	PornLayerTerminology = porn.LayerTerminology // Industry terminology
+	PornLayerCompound    = porn.LayerCompound    // Compound terms ("livesex")
+	PornLayerVerbNoun    = porn.LayerVerbNoun    // Verb+noun patterns ("watch-porn")
+	PornLayerRepetition  = porn.LayerRepetition  // Repetitions ("sexsex")
+)
+
+// DefaultPornHeuristics returns the pipeline used by IsPornHeuristic: the false
+// positive exclusion followed by all layers
+func DefaultPornHeuristics() PornHeuristics {
+	return porn.DefaultHeuristics()
+}
+
+// NewPornHeuristics returns a pipeline of the named layers (PornLayer*, in the given
+// order) with the false positive exclusion. Custom layers can be appended to Layers.
+//
+// Example (block adult TLDs only):
+//
+//	h, _ := k2rule.NewPornHeuristics(k2rule.PornLayerTLD)
+//	h.Match("example.xxx") // true
+//	h.Match("pornhub.com") // false
+func NewPornHeuristics(layers ...string) (PornHeuristics, error) {
+	return porn.NewHeuristics(layers...)
+}
+
+// PornLayer returns the built-in porn heuristic layer with the given name (PornLayer*),
+// e.g. to run a single check without the false positive exclusion
+func PornLayer(name string) (PornHeuristicLayer, bool) {
+	return porn.BuiltinLayer(name)
+}
+
+// IsPornURL checks a full URL for porn content.
+//
+// The host is checked with IsPorn (overlay, heuristic, database); the path and query are
+// checked with a bounded token heuristic (no I/O). Useful when the host is a generic CDN
+// or URL shortener. URLs without a scheme ("cdn.example.com/img/x.jpg") are accepted.
+func IsPornURL(rawURL string) bool {
+	if !strings.Contains(rawURL, "://") && !strings.HasPrefix(rawURL, "/") {
+		rawURL = "//" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	if host := u.Hostname(); host != "" && !IsIPAddress(host) && IsPorn(host) {
+		return true
+	}
+
+	query, err := url.QueryUnescape(u.RawQuery)
+	if err != nil {
+		query = u.RawQuery
+	}
+	return porn.IsPornPath(u.Path + "?" + query)
+}
@@ -0,0 +1,48 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// PornPatch is a differential update for the porn database.
+//
+// Instead of re-downloading the full porn_domains.k2r.gz when a handful of domains
+// change, the publisher serves a small JSON patch listing domains added/removed since
+// a base file. Patches are cumulative: each one describes the full difference against
+// its base, so clients only ever apply the latest patch.
+//
+//	{
+//	  "base": 1760000000,
+//	  "version": "2026-10-15.3",
+//	  "added": ["new-site.com"],
+//	  "removed": ["false-positive.com"]
+//	}
+type PornPatch struct {
+	Base    int64    `json:"base"`    // Header timestamp (Unix seconds) of the base K2RULEV3 file
+	Version string   `json:"version"` // Patch version (informational)
+	Added   []string `json:"added"`   // Domains to treat as porn (suffix match)
+	Removed []string `json:"removed"` // Domains to no longer treat as porn (suffix match)
+}
+
+// ParsePornPatch decodes a JSON porn patch
+func ParsePornPatch(data []byte) (*PornPatch, error) {
+	var p PornPatch
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid porn patch: %w", err)
+	}
+	if p.Base == 0 {
+		return nil, fmt.Errorf("invalid porn patch: missing base")
+	}
+	return &p, nil
+}
+
+// loadPornPatchFile reads and parses a porn patch from disk
+func loadPornPatchFile(path string) (*PornPatch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePornPatch(data)
+}
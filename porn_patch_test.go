@@ -0,0 +1,98 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePornPatch(t *testing.T) {
+	p, err := ParsePornPatch([]byte(`{"base":1760000000,"version":"v2","added":["a.com"],"removed":["b.com"]}`))
+	if err != nil {
+		t.Fatalf("ParsePornPatch failed: %v", err)
+	}
+	if p.Base != 1760000000 || p.Version != "v2" || len(p.Added) != 1 || len(p.Removed) != 1 {
+		t.Errorf("ParsePornPatch = %+v", p)
+	}
+
+	if _, err := ParsePornPatch([]byte(`{"added":["a.com"]}`)); err == nil {
+		t.Error("expected error for patch without base")
+	}
+	if _, err := ParsePornPatch([]byte(`not json`)); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestPornRemoteManager_PatchUpdate(t *testing.T) {
+	base := gzipBytes(t, buildTestPornK2R(t, []string{"listed-site.com", "delisted-site.com"}))
+
+	var patch PornPatch
+	fullDownloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/porn.k2r.gz":
+			fullDownloads++
+			w.Header().Set("ETag", `"base"`)
+			w.Write(base)
+		case "/porn.patch.json":
+			json.NewEncoder(w).Encode(patch)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	m := NewPornRemoteManager(server.URL+"/porn.k2r.gz", t.TempDir())
+	m.patchURL = server.URL + "/porn.patch.json"
+
+	// No base loaded yet: patch cannot apply, full download happens
+	if err := m.Update(); err != nil {
+		t.Fatalf("initial Update failed: %v", err)
+	}
+	if fullDownloads != 1 {
+		t.Fatalf("full downloads = %d, want 1", fullDownloads)
+	}
+	if !m.IsPorn("listed-site.com") || !m.IsPorn("delisted-site.com") {
+		t.Fatal("base database not loaded")
+	}
+
+	// Patch against the loaded base: applied without a full download
+	patch = PornPatch{
+		Base:    m.reader.Timestamp().Unix(),
+		Version: "p1",
+		Added:   []string{"new-site.com"},
+		Removed: []string{"delisted-site.com"},
+	}
+	if err := m.Update(); err != nil {
+		t.Fatalf("patch Update failed: %v", err)
+	}
+	if fullDownloads != 1 {
+		t.Errorf("full downloads = %d after patch, want 1", fullDownloads)
+	}
+	if m.GetPatchVersion() != "p1" {
+		t.Errorf("GetPatchVersion() = %q, want p1", m.GetPatchVersion())
+	}
+	if info := m.Status(); info.Version != "p1" || info.Entries == 0 {
+		t.Errorf("Status() = %+v, want patch version p1 and the domain count", info)
+	}
+	if !m.IsPorn("www.new-site.com") {
+		t.Error("IsPorn(www.new-site.com) = false, want true (patch added)")
+	}
+	if m.IsPorn("delisted-site.com") {
+		t.Error("IsPorn(delisted-site.com) = true, want false (patch removed)")
+	}
+	if !m.IsPorn("listed-site.com") {
+		t.Error("IsPorn(listed-site.com) = false, want true (base)")
+	}
+
+	// Patch for a different base: falls back to a full download
+	patch.Base++
+	patch.Version = "p2"
+	if err := m.Update(); err != nil {
+		t.Fatalf("mismatched patch Update failed: %v", err)
+	}
+	if fullDownloads != 2 {
+		t.Errorf("full downloads = %d after mismatched patch, want 2", fullDownloads)
+	}
+}
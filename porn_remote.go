@@ -3,12 +3,10 @@ package k2rule
 import (
 	"crypto/sha256"
 	"fmt"
-	"io"
-	"log/slog"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kaitu-io/k2rule/internal/slice"
@@ -23,33 +21,50 @@ type PornRemoteManager struct {
 	url      string
 	cacheDir string
 	reader   *slice.CachedMmapReader // lock-free mmap reader
-
-	// Update metadata (mu only protects etag/lastUpdate)
-	mu         sync.RWMutex
-	etag       string
-	lastUpdate time.Time
-	stopCh     chan struct{}
+	dl       *downloader             // shared HTTP downloader (auth, timeouts)
+
+	// Differential updates: optional patch layered over the base database
+	patchURL string                        // "" disables patch updates
+	patch    atomic.Pointer[domainOverlay] // lock-free, nil when no patch applies
+
+	// Update metadata (mu only protects etag/lastUpdate/patch metadata)
+	mu           sync.RWMutex
+	etag         string
+	patchETag    string
+	patchVersion string
+	lastUpdate   time.Time
+	lastErr      error
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	flight       flightGroup // deduplicates concurrent updates (Update, auto-update)
+
+	managerBase // ManagerOption settings (interval, logger, validator, reader mode)
 }
 
 // NewPornRemoteManager creates a new porn remote manager
-func NewPornRemoteManager(url, cacheDir string) *PornRemoteManager {
+func NewPornRemoteManager(url, cacheDir string, opts ...ManagerOption) *PornRemoteManager {
 	if url == "" {
 		url = DefaultPornURL
 	}
 
-	return &PornRemoteManager{
-		url:      url,
-		cacheDir: cacheDir,
-		reader:   slice.NewCachedMmapReader(),
-		stopCh:   make(chan struct{}),
+	m := &PornRemoteManager{
+		url:    url,
+		reader: slice.NewCachedMmapReader(),
+		dl:     newDownloader(60 * time.Second),
+		stopCh: make(chan struct{}),
 	}
+	m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dl, opts)
+	m.reader.SetMode(m.sliceMode())
+	return m
 }
 
 // Init initializes the manager: checks cache → downloads if needed → starts auto-update
 func (m *PornRemoteManager) Init() error {
 	// Create cache directory
-	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
+	if !m.readOnly {
+		if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
 	}
 
 	// 1. Check cache
@@ -57,42 +72,67 @@ func (m *PornRemoteManager) Init() error {
 	if _, err := os.Stat(cachedPath); err == nil {
 		// Cache exists, try to load it
 		if err := m.loadDatabase(cachedPath); err == nil {
-			slog.Info("porn loaded from cache")
+			m.logger().Info("porn loaded from cache")
+			publishReload(m)
+			m.applyCachedPatch()
 			// Successfully loaded from cache, start background update check
-			go m.startAutoUpdate()
+			if !m.readOnly {
+				safeGo("porn", m.startAutoUpdate)
+			}
 			return nil
 		}
 		// Cache corrupted, will re-download
-		slog.Warn("porn cache corrupted, will re-download")
+		m.logger().Warn("porn cache corrupted, will re-download")
+	}
+
+	if m.readOnly {
+		m.logger().Warn("porn cache not found, download skipped, cache is read-only")
+		return nil
 	}
 
 	// 2. Cache doesn't exist or is corrupted, download in background (non-blocking)
-	slog.Info("porn cache not found, downloading in background")
-	go func() {
-		retryForever("porn", func() error { return m.downloadAndLoad(false) })
+	m.logger().Info("porn cache not found, downloading in background")
+	safeGo("porn", func() {
+		retryForever("porn", m.stopCh, func() error { return m.downloadAndLoad(false) })
 		m.startAutoUpdate()
-	}()
+	})
 
 	return nil
 }
 
 // Stop stops the auto-update background task and releases mmap resources
 func (m *PornRemoteManager) Stop() {
-	close(m.stopCh)
+	m.stopUpdates()
 	m.reader.Close()
 }
 
-// Update manually triggers a database update check
+// stopUpdates stops the background download and auto-update tasks only
+func (m *PornRemoteManager) stopUpdates() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// Update manually triggers a database update check.
+// When a patch URL is configured and the published patch applies to the loaded
+// base database, only the patch is downloaded.
+//
+// Concurrent calls share one update and its result.
 func (m *PornRemoteManager) Update() error {
-	return m.downloadAndLoad(true)
+	if m.skipUpdate("porn") {
+		return nil
+	}
+	return m.flight.do("update", m.update)
 }
 
 // IsPorn checks if a domain is a porn domain.
-// Uses heuristic first (fast, no I/O), then mmap-based K2RULEV3 lookup (lock-free).
+// Uses heuristic first (fast, no I/O), then the patch overlay (if any),
+// then mmap-based K2RULEV3 lookup (lock-free).
 func (m *PornRemoteManager) IsPorn(domain string) bool {
 	if IsPornHeuristic(domain) {
 		return true
 	}
+	if added, found := m.patch.Load().lookup(domain); found {
+		return added
+	}
 	if target := m.reader.MatchDomain(domain); target != nil {
 		return *target == 2 // targetReject
 	}
@@ -100,73 +140,46 @@ func (m *PornRemoteManager) IsPorn(domain string) bool {
 }
 
 // downloadAndLoad downloads the porn database and loads it
-func (m *PornRemoteManager) downloadAndLoad(useETag bool) error {
-	req, err := http.NewRequest("GET", m.url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+func (m *PornRemoteManager) downloadAndLoad(useETag bool) (err error) {
+	defer func() { m.setLastError(err) }()
 
 	// ETag optimization: 304 Not Modified
-	m.mu.RLock()
-	currentETag := m.etag
-	m.mu.RUnlock()
-
-	if useETag && currentETag != "" {
-		req.Header.Set("If-None-Match", currentETag)
+	var currentETag string
+	if useETag {
+		currentETag = m.GetETag()
 	}
 
-	slog.Debug("downloading porn database", "url", m.url)
+	m.logger().Debug("downloading porn database", "url", redactURL(m.url))
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	cachePath := m.getCachePath()
+	etag, modified, err := m.dl.fetchValidated(m.url, currentETag, cachePath, false, m.validator)
 	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
 	// 304 Not Modified - no need to update
-	if resp.StatusCode == http.StatusNotModified {
-		slog.Debug("porn database not modified")
+	if !modified {
+		m.logger().Debug("porn database not modified")
 		return nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
-	}
-
-	// Download to temporary file
-	tmpPath := m.getCachePath() + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-
-	_, err = io.Copy(tmpFile, resp.Body)
-	tmpFile.Close()
-	if err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to write temp file: %w", err)
-	}
-
-	// Atomic rename (overwrite old cache)
-	cachePath := m.getCachePath()
-	if err := os.Rename(tmpPath, cachePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename temp file: %w", err)
-	}
-
 	// Hot-reload (atomic swap)
 	if err := m.loadDatabase(cachePath); err != nil {
 		return fmt.Errorf("failed to load new database: %w", err)
 	}
 
+	// Patches target a specific base; drop the old overlay and re-check the cached patch
+	m.patch.Store(nil)
+	m.applyCachedPatch()
+
 	// Update metadata
 	m.mu.Lock()
-	m.etag = resp.Header.Get("ETag")
+	m.etag = etag
 	m.lastUpdate = time.Now()
 	m.mu.Unlock()
 
-	slog.Info("porn database downloaded and loaded")
+	m.logger().Info("porn database downloaded and loaded")
+	publishReload(m)
 
 	return nil
 }
@@ -179,20 +192,7 @@ func (m *PornRemoteManager) loadDatabase(path string) error {
 
 // startAutoUpdate runs background auto-update (every 6 hours)
 func (m *PornRemoteManager) startAutoUpdate() {
-	ticker := time.NewTicker(6 * time.Hour)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			// Check for updates (use ETag)
-			if err := m.downloadAndLoad(true); err != nil {
-				slog.Warn("porn auto-update failed", "error", err)
-			}
-		case <-m.stopCh:
-			return
-		}
-	}
+	m.autoUpdate(ComponentPorn, 6*time.Hour, m.stopCh, m.Update)
 }
 
 // getCachePath returns the cache file path (based on URL hash)
@@ -202,6 +202,100 @@ func (m *PornRemoteManager) getCachePath() string {
 	return filepath.Join(m.cacheDir, filename)
 }
 
+// getPatchPath returns the cached patch file path (based on patch URL hash)
+func (m *PornRemoteManager) getPatchPath() string {
+	hash := sha256.Sum256([]byte(m.patchURL))
+	filename := fmt.Sprintf("%x.patch.json", hash[:8])
+	return filepath.Join(m.cacheDir, filename)
+}
+
+// update tries a differential patch first and falls back to a full download
+func (m *PornRemoteManager) update() error {
+	if m.patchURL != "" {
+		applied, err := m.downloadAndApplyPatch()
+		if err != nil {
+			m.logger().Warn("porn patch update failed, falling back to full download", "error", err)
+		}
+		if applied {
+			return nil
+		}
+	}
+	return m.downloadAndLoad(true)
+}
+
+// downloadAndApplyPatch fetches the patch and applies it if it targets the loaded base.
+// Returns applied=false when a full download is required (no base loaded, or patch for another base).
+func (m *PornRemoteManager) downloadAndApplyPatch() (bool, error) {
+	m.mu.RLock()
+	currentETag := m.patchETag
+	m.mu.RUnlock()
+
+	m.logger().Debug("downloading porn patch", "url", redactURL(m.patchURL))
+
+	etag, modified, err := m.dl.fetch(m.patchURL, currentETag, m.getPatchPath(), false)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	m.patchETag = etag
+	m.mu.Unlock()
+
+	// Unchanged patch: still valid if it was applied to the current base
+	if !modified {
+		return m.patch.Load() != nil, nil
+	}
+
+	patch, err := loadPornPatchFile(m.getPatchPath())
+	if err != nil {
+		return false, err
+	}
+	applied := m.applyPatch(patch)
+	if applied {
+		publishReload(m)
+	}
+	return applied, nil
+}
+
+// applyCachedPatch applies the cached patch file if it matches the loaded base
+func (m *PornRemoteManager) applyCachedPatch() {
+	if m.patchURL == "" {
+		return
+	}
+	patch, err := loadPornPatchFile(m.getPatchPath())
+	if err != nil {
+		return
+	}
+	m.applyPatch(patch)
+}
+
+// applyPatch layers patch over the base database if patch.Base matches the loaded file
+func (m *PornRemoteManager) applyPatch(patch *PornPatch) bool {
+	if m.reader.Get() == nil || m.reader.Timestamp().Unix() != patch.Base {
+		m.logger().Debug("porn patch does not match loaded base", "patch_base", patch.Base)
+		return false
+	}
+
+	m.patch.Store(newDomainOverlay(patch.Added, patch.Removed))
+
+	m.mu.Lock()
+	m.patchVersion = patch.Version
+	m.mu.Unlock()
+
+	m.logger().Info("porn patch applied", "version", patch.Version, "added", len(patch.Added), "removed", len(patch.Removed))
+	return true
+}
+
+// GetPatchVersion returns the version of the applied patch ("" if none applies)
+func (m *PornRemoteManager) GetPatchVersion() string {
+	if m.patch.Load() == nil {
+		return ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.patchVersion
+}
+
 // GetETag returns the current ETag
 func (m *PornRemoteManager) GetETag() string {
 	m.mu.RLock()
@@ -215,3 +309,34 @@ func (m *PornRemoteManager) GetLastUpdate() time.Time {
 	defer m.mu.RUnlock()
 	return m.lastUpdate
 }
+
+// GetLastError returns the error of the most recent download attempt (nil after success)
+func (m *PornRemoteManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// GetGeneration returns the number of times the porn database has been loaded
+func (m *PornRemoteManager) GetGeneration() uint64 {
+	return m.reader.Generation()
+}
+
+// Status returns a snapshot of the porn database state
+func (m *PornRemoteManager) Status() ComponentInfo {
+	info := readerStatus(ComponentPorn, m.url, m.reader)
+	info.ReadOnly = m.readOnly
+	m.mu.RLock()
+	info.LastUpdate = m.lastUpdate
+	info.LastError = errorString(m.lastErr)
+	m.mu.RUnlock()
+	info.Version = m.GetPatchVersion()
+	return info
+}
+
+// setLastError records the outcome of a download attempt
+func (m *PornRemoteManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
@@ -177,3 +177,55 @@ func TestDefaultPornURLUsesK2R(t *testing.T) {
 		t.Errorf("DefaultPornURL = %q, must NOT contain .fst.gz", DefaultPornURL)
 	}
 }
+
+func TestIsPornURL(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://pornhub.com/", true},
+		{"https://cdn.example.com/media/hentai/1.jpg", true},
+		{"https://sho.rt/r?to=https%3A%2F%2Fxvideos.com%2Fv%2F1", true},
+		{"cdn.example.com/img/milf.png", true},
+		{"https://news.example.com/world/gay-rights", false},
+		{"https://www.google.com/search?q=weather", false},
+		{"http://1.2.3.4/index.html", false},
+		{"", false},
+		{"://bad url", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := IsPornURL(tt.url); got != tt.want {
+				t.Errorf("IsPornURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPornHeuristicsPipeline(t *testing.T) {
+	h, err := NewPornHeuristics(PornLayerTLD)
+	if err != nil {
+		t.Fatalf("NewPornHeuristics() error: %v", err)
+	}
+	if !h.Match("example.xxx") || h.Match("pornhub.com") {
+		t.Error("TLD-only pipeline should match example.xxx only")
+	}
+
+	layer, ok := PornLayer(PornLayerCompound)
+	if !ok || !layer.Match("livesex.com") {
+		t.Errorf("PornLayer(%s) = %v, %v", PornLayerCompound, layer.Name, ok)
+	}
+	h.Layers = append(h.Layers, layer)
+	if !h.Match("livesex.com") {
+		t.Error("pipeline with appended compound layer should match livesex.com")
+	}
+
+	for _, domain := range []string{"pornhub.com", "essex.ac.uk", "google.com"} {
+		if got := DefaultPornHeuristics().Match(domain); got != IsPornHeuristic(domain) {
+			t.Errorf("DefaultPornHeuristics().Match(%q) = %v, IsPornHeuristic = %v", domain, got, !got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package k2rule
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// Prewarm prepares the loaded databases for latency-sensitive traffic: it faults in
+// every page of the mapped rule and porn files (decompressing compressed slices) and
+// then looks up samples, e.g. the most requested domains, which also warms the GeoIP
+// offset cache. Sample lookups don't record hit statistics, publish events or pin
+// sticky decisions.
+//
+// Call it after Init (and after updates, e.g. on EventReload) before taking traffic.
+// Returns ctx.Err() when cancelled before all samples were looked up, or an error
+// when a compressed slice of a loaded file fails to decompress or map (such a slice
+// matches nothing).
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	k2rule.Prewarm(ctx, []string{"google.com", "baidu.com", "8.8.8.8"})
+func Prewarm(ctx context.Context, samples []string) error {
+	defaultEngine.mu.RLock()
+	config := defaultEngine.config
+	manager := defaultEngine.manager
+	geoIPMgr := defaultEngine.geoIPMgr
+	pornManager := defaultEngine.pornManager
+	matcher := defaultEngine.matcher
+	defaultEngine.mu.RUnlock()
+
+	var loadErr error
+	if manager != nil {
+		manager.reader.Touch()
+		if err := manager.reader.Err(); err != nil {
+			loadErr = fmt.Errorf("rule file: %w", err)
+		}
+	}
+	var pornReader *slice.CachedMmapReader
+	if pornManager != nil {
+		pornReader = pornManager.reader
+	} else if matcher != nil && matcher.pornChecker != nil {
+		pornReader = matcher.pornChecker.reader
+	}
+	if pornReader != nil {
+		pornReader.Touch()
+		if err := pornReader.Err(); err != nil && loadErr == nil {
+			loadErr = fmt.Errorf("porn database: %w", err)
+		}
+	}
+
+	antiporn := config != nil && config.Antiporn
+	for _, input := range samples {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ip := net.ParseIP(input)
+		if manager != nil {
+			manager.matchInput(input, ip, geoIPMgr)
+		} else if ip != nil && geoIPMgr != nil {
+			geoIPMgr.LookupCountry(ip)
+		}
+		if ip == nil && antiporn {
+			defaultEngine.isPorn(input)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return loadErr
+}
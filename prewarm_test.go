@@ -0,0 +1,48 @@
+package k2rule
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrewarm(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// Nothing loaded: no-op
+	if err := Prewarm(context.Background(), []string{"example.com", "8.8.8.8"}); err != nil {
+		t.Fatalf("Prewarm() without Init = %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, RuleFile: rulePath, StickyTTL: Duration(time.Minute)}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	startHitStats(&HitStatsConfig{}, "")
+
+	if err := Prewarm(context.Background(), []string{"blocked.com", "8.8.8.8"}); err != nil {
+		t.Fatalf("Prewarm() = %v", err)
+	}
+	if report, _ := CurrentHitReport(); report.Total != 0 {
+		t.Errorf("Prewarm recorded %d hits, want 0", report.Total)
+	}
+	if _, ok := defaultEngine.sticky.get("blocked.com", time.Minute); ok {
+		t.Error("Prewarm pinned a sticky decision")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Prewarm(ctx, []string{"blocked.com"}); err != context.Canceled {
+		t.Errorf("Prewarm(cancelled) = %v, want context.Canceled", err)
+	}
+}
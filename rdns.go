@@ -0,0 +1,92 @@
+package k2rule
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/cache"
+)
+
+const (
+	defaultRDNSTimeout  = 200 * time.Millisecond
+	defaultRDNSCacheTTL = 10 * time.Minute
+)
+
+// rdnsLookup resolves PTR names (replaced in tests)
+var rdnsLookup = net.DefaultResolver.LookupAddr
+
+// globalRDNS caches PTR answers for Config.EnableRDNS
+var globalRDNS rdnsCache
+
+// rdnsCache maps IP string → PTR answer ("" = no usable name); concurrent lookups of
+// one IP share a query. Its size and eviction policy are set by Config.Caches[CacheRDNS].
+type rdnsCache struct {
+	entries cache.Cache[string, string]
+	flight  flightGroup
+}
+
+// hostname returns the PTR name of ip per config, or "" if disabled or unavailable.
+// Cache misses block for at most the configured timeout.
+func (c *rdnsCache) hostname(config *Config, ip net.IP) string {
+	if config == nil || !config.EnableRDNS {
+		return ""
+	}
+	key := ip.String()
+	if hostname, ok := c.entries.Get(key, 0); ok {
+		return hostname
+	}
+
+	timeout := time.Duration(config.RDNSTimeout)
+	if timeout <= 0 {
+		timeout = defaultRDNSTimeout
+	}
+	ttl := time.Duration(config.RDNSCacheTTL)
+	if ttl <= 0 {
+		ttl = defaultRDNSCacheTTL
+	}
+
+	c.flight.do(key, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		// Skipped lookups aren't cached, so the IP is looked up once the limit allows
+		if !globalHookLimits.rdns.allow(time.Now()) {
+			return nil
+		}
+		names, err := rdnsLookup(ctx, key)
+		globalHookLimits.rdns.done(rdnsFailed(err), time.Now())
+
+		var hostname string
+		if err == nil {
+			for _, name := range names {
+				if name = strings.ToLower(strings.TrimSuffix(name, ".")); isValidDomain(name) {
+					hostname = name
+					break
+				}
+			}
+		}
+		c.entries.Put(key, hostname, ttl)
+		return nil
+	})
+
+	hostname, _ := c.entries.Get(key, 0)
+	return hostname
+}
+
+// rdnsFailed reports whether a PTR lookup error indicates a resolver problem (for the
+// HookRDNS circuit breaker); names that don't exist are answers, not failures
+func rdnsFailed(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return err != nil
+}
+
+// clear removes all cached answers
+func (c *rdnsCache) clear() {
+	c.entries.Clear()
+}
@@ -0,0 +1,89 @@
+package k2rule
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMatch_RDNS(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	var queries atomic.Int32
+	orig := rdnsLookup
+	rdnsLookup = func(ctx context.Context, addr string) ([]string, error) {
+		queries.Add(1)
+		switch addr {
+		case "203.0.113.10":
+			return []string{"Host-1.Tracker.com."}, nil
+		case "203.0.113.11":
+			return []string{"ec2-203-0-113-11.compute.example."}, nil
+		case "203.0.113.12":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("no PTR for %s", addr)
+	}
+	defer func() { rdnsLookup = orig }()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, path, buildTestPornK2R(t, []string{"tracker.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	// Disabled by default
+	if got := Match("203.0.113.10"); got != TargetDirect {
+		t.Errorf("Match without EnableRDNS = %v, want DIRECT", got)
+	}
+	if n := queries.Load(); n != 0 {
+		t.Errorf("%d PTR queries without EnableRDNS", n)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy, EnableRDNS: true, RDNSTimeout: Duration(20 * time.Millisecond)}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	result := MatchVerbose("203.0.113.10")
+	if result.Target != TargetReject || result.Domain != "host-1.tracker.com" {
+		t.Errorf("MatchVerbose(PTR tracker.com) = %+v, want REJECT via host-1.tracker.com", result)
+	}
+	Match("203.0.113.10")
+	if n := queries.Load(); n != 1 {
+		t.Errorf("PTR queries = %d, want 1 (cached)", n)
+	}
+
+	// PTR name without a domain rule falls through to IP rules
+	if result := MatchVerbose("203.0.113.11"); result.Target != TargetDirect || result.Domain != "" || !result.Fallback {
+		t.Errorf("MatchVerbose(unmatched PTR) = %+v, want DIRECT fallback", result)
+	}
+
+	// Lookups are bounded by RDNSTimeout
+	start := time.Now()
+	if got := Match("203.0.113.12"); got != TargetDirect {
+		t.Errorf("Match(slow PTR) = %v, want DIRECT", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow PTR lookup took %v", elapsed)
+	}
+
+	// Failures are cached too
+	Match("203.0.113.13")
+	Match("203.0.113.13")
+	if n := queries.Load(); n != 4 {
+		t.Errorf("PTR queries = %d, want 4", n)
+	}
+}
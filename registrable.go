@@ -0,0 +1,61 @@
+package k2rule
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/cache"
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// registrableCacheTTL is how long a registrable-domain decision is cached
+const registrableCacheTTL = 10 * time.Minute
+
+// globalRegistrable caches domain rule lookups by registrable domain
+// (Config.RegistrableCache), so subdomains of one site share an entry.
+var globalRegistrable registrableCache
+
+// registrableCache maps registrable domain → rule file lookup. A lookup is shared
+// only when the rule file decides the registrable domain and all of its subdomains
+// alike (no rule below it, see slice.MmapReader.UniformBelow); sites with deeper
+// rules are cached as not shareable and looked up per hostname. The key approximates
+// the registrable domain (see parentDomain), which only affects the hit rate.
+// Its size and eviction policy are set by Config.Caches[CacheRegistrable].
+type registrableCache struct {
+	enabled atomic.Bool
+	entries cache.Cache[string, registrableEntry]
+}
+
+// registrableEntry is a cached lookup, valid for the rule file it was made with
+type registrableEntry struct {
+	reader  *slice.MmapReader
+	uniform bool // false = look up each hostname
+	target  uint8
+	hints   slice.DNSHint
+	ok      bool
+}
+
+// matchDomain matches domain against the active rules of m, sharing the lookup
+// with the other subdomains of its registrable domain when possible
+func (c *registrableCache) matchDomain(m *RemoteRuleManager, domain string) MatchResult {
+	reader := m.reader.Get()
+	normalized := strings.ToLower(domain)
+	if !c.enabled.Load() || reader == nil || strings.HasSuffix(normalized, ".") || !isValidDomain(normalized) {
+		return matchRules(m.reader, m.getFallback(), domain, nil, nil)
+	}
+
+	key := parentDomain(normalized)
+	e, ok := c.entries.Get(key, 0)
+	if !ok || e.reader != reader {
+		e = registrableEntry{reader: reader, uniform: reader.UniformBelow(key)}
+		if e.uniform {
+			e.target, e.hints, e.ok = reader.MatchDomainHints(normalized)
+		}
+		c.entries.Put(key, e, registrableCacheTTL)
+	}
+	if !e.uniform {
+		return matchRules(reader, m.getFallback(), normalized, nil, nil)
+	}
+	return domainRuleResult(e.target, e.hints, e.ok, m.getFallback())
+}
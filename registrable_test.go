@@ -0,0 +1,58 @@
+package k2rule
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistrableCache(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	v1 := filepath.Join(tmpDir, "v1.k2r.gz")
+	v2 := filepath.Join(tmpDir, "v2.k2r.gz")
+	writeTestK2RGzipFile(t, v1, buildTestPornK2R(t, []string{"example.com", "ads.site.net"}))
+	writeTestK2RGzipFile(t, v2, buildTestPornK2R(t, []string{"other.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(v1); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{RegistrableCache: true}
+	globalRegistrable.enabled.Store(true)
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		domain string
+		want   Target
+	}{
+		{"a.cdn.example.com", TargetReject},
+		{"b.cdn.example.com", TargetReject}, // shares the example.com entry
+		{"Example.com", TargetReject},
+		{"www.site.net", TargetDirect},
+		{"ads.site.net", TargetReject}, // site.net has a deeper rule: not shared
+		{"x.ads.site.net", TargetReject},
+		{"unlisted.org", TargetDirect},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.domain); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+	if stats := globalRegistrable.entries.Stats(); stats.Hits < 4 || stats.Entries != 3 {
+		t.Errorf("registrable cache stats = %+v, want 3 entries and shared lookups", stats)
+	}
+
+	// Entries of the previous rule file are not used after a reload
+	if err := manager.reader.Load(v2); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := Match("c.cdn.example.com"); got != TargetDirect {
+		t.Errorf("Match(c.cdn.example.com) after reload = %v, want DIRECT", got)
+	}
+}
@@ -0,0 +1,114 @@
+package k2rule
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rejectQueueSize bounds the notifications waiting for the OnReject handler;
+// further REJECT decisions are dropped until the handler catches up
+const rejectQueueSize = 64
+
+// RejectHandler is notified of a Match decision that returned TargetReject.
+// input is the matched domain or IP; reason holds the details (see MatchVerbose),
+// e.g. Category "porn" or the Rule that decided.
+type RejectHandler func(input string, reason MatchResult)
+
+// globalReject is the active OnReject notifier (nil = disabled)
+var globalReject atomic.Pointer[rejectNotifier]
+
+// rejectNotifier delivers REJECT decisions to the handler from one background
+// goroutine, at most limit per minute
+type rejectNotifier struct {
+	handler  RejectHandler
+	limit    int
+	queue    chan rejectNotice
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+}
+
+// rejectNotice is a queued REJECT decision
+type rejectNotice struct {
+	input  string
+	result MatchResult
+}
+
+// startRejectNotifier replaces the active notifier (handler nil = disable).
+// limit is the maximum notifications per minute (0 = 60).
+func startRejectNotifier(handler RejectHandler, limit int) {
+	var n *rejectNotifier
+	if handler != nil {
+		if limit <= 0 {
+			limit = 60
+		}
+		n = &rejectNotifier{
+			handler: handler,
+			limit:   limit,
+			queue:   make(chan rejectNotice, rejectQueueSize),
+			stopCh:  make(chan struct{}),
+		}
+	}
+	if old := globalReject.Swap(n); old != nil {
+		old.stop()
+	}
+	if n != nil {
+		n.start()
+	}
+}
+
+// record queues a REJECT decision for the handler; it never blocks Match
+func (n *rejectNotifier) record(input string, result MatchResult) {
+	if result.Target != TargetReject || !n.allow(time.Now()) {
+		return
+	}
+	select {
+	case n.queue <- rejectNotice{input: input, result: result}:
+	default: // handler is behind
+	}
+}
+
+// allow reports whether another notification fits the rate limit at now
+func (n *rejectNotifier) allow(now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.windowStart) >= time.Minute {
+		n.windowStart = now
+		n.sent = 0
+	}
+	if n.sent >= n.limit {
+		return false
+	}
+	n.sent++
+	return true
+}
+
+// start delivers queued notifications until stopped
+func (n *rejectNotifier) start() {
+	safeGo("reject", func() {
+		for {
+			select {
+			case notice := <-n.queue:
+				if !globalHookLimits.reject.allow(time.Now()) {
+					continue
+				}
+				err := safeCall("reject", func() error {
+					n.handler(notice.input, notice.result)
+					return nil
+				})
+				globalHookLimits.reject.done(err != nil, time.Now())
+			case <-n.stopCh:
+				return
+			}
+		}
+	})
+}
+
+// stop ends deliveries; queued notifications are discarded
+func (n *rejectNotifier) stop() {
+	n.stopOnce.Do(func() { close(n.stopCh) })
+}
@@ -0,0 +1,96 @@
+package k2rule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnReject_NotifiesRejectDecisions(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	SetTmpRule("blocked.com", TargetReject)
+	SetTmpRule("allowed.com", TargetDirect)
+
+	type notice struct {
+		input  string
+		result MatchResult
+	}
+	got := make(chan notice, 10)
+	startRejectNotifier(func(input string, reason MatchResult) {
+		got <- notice{input, reason}
+	}, 2)
+
+	Match("allowed.com")
+	MatchVerbose("blocked.com")
+	Match("blocked.com")
+	Match("blocked.com") // beyond the limit
+
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-got:
+			if n.input != "blocked.com" || n.result.Target != TargetReject || n.result.Fallback {
+				t.Errorf("notice = %q %+v, want blocked.com REJECT", n.input, n.result)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notice %d not delivered", i)
+		}
+	}
+	select {
+	case n := <-got:
+		t.Errorf("unexpected notice beyond the limit: %q", n.input)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOnReject_HandlerPanicRecovered(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	SetTmpRule("blocked.com", TargetReject)
+
+	got := make(chan string, 2)
+	startRejectNotifier(func(input string, reason MatchResult) {
+		got <- input
+		if input == "blocked.com" {
+			panic("boom")
+		}
+	}, 0)
+
+	Match("blocked.com")
+	SetTmpRule("other.com", TargetReject)
+	Match("other.com")
+
+	for _, want := range []string{"blocked.com", "other.com"} {
+		select {
+		case input := <-got:
+			if input != want {
+				t.Errorf("notice = %q, want %q", input, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notice %q not delivered", want)
+		}
+	}
+}
+
+func TestRejectNotifier_RateWindow(t *testing.T) {
+	n := &rejectNotifier{limit: 2}
+	now := time.Now()
+	if !n.allow(now) || !n.allow(now.Add(time.Second)) {
+		t.Fatal("first two notifications should be allowed")
+	}
+	if n.allow(now.Add(30 * time.Second)) {
+		t.Error("third notification in the same minute should be dropped")
+	}
+	if !n.allow(now.Add(time.Minute)) {
+		t.Error("notification in the next minute should be allowed")
+	}
+}
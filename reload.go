@@ -0,0 +1,146 @@
+package k2rule
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReloadRuleFile makes a local rule file (.k2r.gz, e.g. pushed by MDM) the running
+// engine's rule source. The file is loaded and validated first; on error the current
+// rules stay active. GeoIP and porn detection are untouched, unlike UpdateConfig.
+//
+// The rule manager it replaces is stopped (a RuleURL manager or Subscription no longer
+// downloads updates), except one fed by BundleURL/ManifestURL, which keeps loading the
+// other components. GetConfig reports the file as RuleFile, so a later Init(&config) keeps it.
+//
+// Example:
+//
+//	if err := k2rule.ReloadRuleFile("/var/mobile/mdm/rules.k2r.gz"); err != nil {
+//	    log.Printf("MDM rules rejected: %v", err)
+//	}
+func ReloadRuleFile(path string) error {
+	defaultEngine.mu.RLock()
+	config := defaultEngine.config
+	defaultEngine.mu.RUnlock()
+	if config == nil {
+		return fmt.Errorf("not initialized")
+	}
+
+	manager := NewRemoteRuleManager("", config.CacheDir, TargetDirect, WithReaderMode(config.ReaderMode))
+	if err := manager.reader.Load(path); err != nil {
+		return fmt.Errorf("failed to load rule file: %w", err)
+	}
+	if err := manager.reader.Err(); err != nil {
+		manager.Close()
+		return fmt.Errorf("invalid rule file: %w", err)
+	}
+	manager.fallback.Store(uint32(manager.reader.Fallback()))
+
+	defaultEngine.mu.Lock()
+	previous := defaultEngine.installedManagers()
+	defaultEngine.manager = manager
+	defaultEngine.subscriptionMgr = nil
+	defaultEngine.config.RuleFile, defaultEngine.config.RuleURL, defaultEngine.config.Subscription = path, "", nil
+	defaultEngine.publishState()
+	retireManagers(detachCombined(previous), defaultEngine.installedManagers())
+	defaultEngine.mu.Unlock()
+
+	publishReload(manager)
+	return nil
+}
+
+// ReloadGeoIPFile makes a local GeoIP database (.mmdb) the running engine's GeoIP
+// source, like ReloadRuleFile does for rules. Rules and porn detection are untouched.
+func ReloadGeoIPFile(path string) error {
+	defaultEngine.mu.RLock()
+	initialized := defaultEngine.config != nil
+	defaultEngine.mu.RUnlock()
+	if !initialized {
+		return fmt.Errorf("not initialized")
+	}
+
+	geoIPMgr := &GeoIPManager{stopCh: make(chan struct{})}
+	if err := geoIPMgr.loadDatabase(path); err != nil {
+		return fmt.Errorf("failed to open GeoIP file: %w", err)
+	}
+
+	defaultEngine.mu.Lock()
+	previous := defaultEngine.installedManagers()
+	defaultEngine.geoIPMgr = geoIPMgr
+	defaultEngine.config.GeoIPFile, defaultEngine.config.GeoIPURL, defaultEngine.config.GeoIPMaxMind = path, "", nil
+	defaultEngine.publishState()
+	retireManagers(detachCombined(previous), defaultEngine.installedManagers())
+	defaultEngine.mu.Unlock()
+
+	publishReload(geoIPMgr)
+	return nil
+}
+
+// ReloadPornFile makes a local porn database (.k2r.gz) the running engine's porn
+// source, like ReloadRuleFile does for rules. It takes effect while Antiporn is on.
+// Rules and GeoIP are untouched.
+func ReloadPornFile(path string) error {
+	defaultEngine.mu.RLock()
+	initialized := defaultEngine.config != nil
+	defaultEngine.mu.RUnlock()
+	if !initialized {
+		return fmt.Errorf("not initialized")
+	}
+
+	checker, err := NewPornCheckerFromFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load porn file: %w", err)
+	}
+	if err := checker.reader.Err(); err != nil {
+		checker.Close()
+		return fmt.Errorf("invalid porn file: %w", err)
+	}
+
+	defaultEngine.mu.Lock()
+	previous := defaultEngine.installedManagers()
+	var oldChecker *PornChecker
+	matcher := &Matcher{pornChecker: checker}
+	if defaultEngine.matcher != nil {
+		oldChecker = defaultEngine.matcher.pornChecker
+		matcher.reader = defaultEngine.matcher.reader
+	}
+	defaultEngine.matcher = matcher
+	defaultEngine.pornManager = nil
+	defaultEngine.config.PornFile, defaultEngine.config.PornURL = path, ""
+	defaultEngine.publishState()
+	retireManagers(detachCombined(previous), defaultEngine.installedManagers())
+	defaultEngine.mu.Unlock()
+
+	// Concurrent IsPorn() calls may still hold the old checker
+	if oldChecker != nil {
+		safeGo("porn", func() {
+			time.Sleep(5 * time.Second)
+			oldChecker.Close()
+		})
+	}
+	return nil
+}
+
+// detachCombined returns old without the component managers fed by the installed
+// bundle or manifest, which keeps loading into them (defaultEngine.mu held)
+func detachCombined(old managerSet) managerSet {
+	var set *componentSet
+	if old.bundle != nil {
+		set = &old.bundle.componentSet
+	} else if old.manifest != nil {
+		set = &old.manifest.componentSet
+	}
+	if set != nil {
+		if old.rules == set.rules {
+			old.rules = nil
+		}
+		if old.geoIP == set.geoIP {
+			old.geoIP = nil
+		}
+		if old.porn == set.porn {
+			old.porn = nil
+		}
+	}
+	old.bundle, old.manifest = nil, nil
+	return old
+}
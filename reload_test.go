@@ -0,0 +1,179 @@
+package k2rule
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// initForReload initializes the engine from ruleFile (PROXY fallback rules); GeoIP
+// and porn downloads fail fast
+func initForReload(t *testing.T, ruleFile string, antiporn bool) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	config := &Config{
+		RuleFile:     ruleFile,
+		GeoIPURL:     server.URL + "/geoip.mmdb.gz",
+		CacheDir:     filepath.Join(t.TempDir(), "cache"),
+		GlobalTarget: TargetProxy,
+		Antiporn:     antiporn,
+	}
+	if antiporn {
+		config.PornURL = server.URL + "/porn.k2r.gz"
+	}
+	if err := Init(config); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+}
+
+func TestReload_NotInitialized(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	path := filepath.Join(t.TempDir(), "any")
+	for name, reload := range map[string]func(string) error{
+		"ReloadRuleFile":  ReloadRuleFile,
+		"ReloadGeoIPFile": ReloadGeoIPFile,
+		"ReloadPornFile":  ReloadPornFile,
+	} {
+		if err := reload(path); err == nil {
+			t.Errorf("%s() before Init succeeded", name)
+		}
+	}
+}
+
+func TestReloadRuleFile(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.k2r.gz"), filepath.Join(dir, "second.k2r.gz")
+	writeTestK2RGzipFile(t, first, stressRules(t, false))
+	writeTestK2RGzipFile(t, second, stressRules(t, true))
+	initForReload(t, first, false)
+
+	if got := Match("flip.example.com"); got != TargetProxy {
+		t.Fatalf("Match(flip.example.com) before reload = %s, want PROXY", got)
+	}
+	defaultEngine.mu.RLock()
+	geoIPMgr := defaultEngine.geoIPMgr
+	defaultEngine.mu.RUnlock()
+
+	if err := ReloadRuleFile(second); err != nil {
+		t.Fatalf("ReloadRuleFile() failed: %v", err)
+	}
+	if got := Match("flip.example.com"); got != TargetDirect {
+		t.Errorf("Match(flip.example.com) after reload = %s, want DIRECT", got)
+	}
+	if got := Match("stable.example.com"); got != TargetReject {
+		t.Errorf("Match(stable.example.com) after reload = %s, want REJECT", got)
+	}
+	if config := GetConfig(); config.RuleFile != second || config.RuleURL != "" {
+		t.Errorf("GetConfig() RuleFile = %q, RuleURL = %q, want %q and empty", config.RuleFile, config.RuleURL, second)
+	}
+	defaultEngine.mu.RLock()
+	sameGeoIP := defaultEngine.geoIPMgr == geoIPMgr
+	defaultEngine.mu.RUnlock()
+	if !sameGeoIP {
+		t.Error("ReloadRuleFile() replaced the GeoIP manager")
+	}
+
+	// Invalid files are rejected and the current rules stay active
+	garbage := filepath.Join(dir, "garbage.k2r.gz")
+	if err := os.WriteFile(garbage, []byte("not a rule file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{garbage, filepath.Join(dir, "missing.k2r.gz")} {
+		if err := ReloadRuleFile(path); err == nil {
+			t.Errorf("ReloadRuleFile(%s) succeeded", filepath.Base(path))
+		}
+	}
+	if got := Match("flip.example.com"); got != TargetDirect {
+		t.Errorf("Match(flip.example.com) after failed reload = %s, want DIRECT", got)
+	}
+	if got := GetConfig().RuleFile; got != second {
+		t.Errorf("GetConfig().RuleFile after failed reload = %q, want %q", got, second)
+	}
+}
+
+func TestReloadGeoIPFile(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	dir := t.TempDir()
+	ruleFile := filepath.Join(dir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, ruleFile, stressRules(t, false))
+	initForReload(t, ruleFile, false)
+
+	// Any valid MaxMind DB opens; ExportMMDB writes one
+	var buf bytes.Buffer
+	if err := ExportMMDB(&buf); err != nil {
+		t.Fatalf("ExportMMDB() failed: %v", err)
+	}
+	mmdbPath := filepath.Join(dir, "geo.mmdb")
+	if err := os.WriteFile(mmdbPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReloadGeoIPFile(filepath.Join(dir, "missing.mmdb")); err == nil {
+		t.Error("ReloadGeoIPFile() of a missing file succeeded")
+	}
+	if err := ReloadGeoIPFile(mmdbPath); err != nil {
+		t.Fatalf("ReloadGeoIPFile() failed: %v", err)
+	}
+	defaultEngine.mu.RLock()
+	geoIPMgr := defaultEngine.geoIPMgr
+	defaultEngine.mu.RUnlock()
+	if geoIPMgr == nil || !geoIPMgr.Status().Loaded {
+		t.Error("GeoIP database not loaded after ReloadGeoIPFile()")
+	}
+	if config := GetConfig(); config.GeoIPFile != mmdbPath || config.GeoIPURL != "" {
+		t.Errorf("GetConfig() GeoIPFile = %q, GeoIPURL = %q, want %q and empty", config.GeoIPFile, config.GeoIPURL, mmdbPath)
+	}
+	if got := Match("stable.example.com"); got != TargetReject {
+		t.Errorf("Match(stable.example.com) after GeoIP reload = %s, want REJECT", got)
+	}
+}
+
+func TestReloadPornFile(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	dir := t.TempDir()
+	ruleFile := filepath.Join(dir, "rules.k2r.gz")
+	pornFile := filepath.Join(dir, "porn.k2r.gz")
+	writeTestK2RGzipFile(t, ruleFile, stressRules(t, false))
+	writeTestK2RGzipFile(t, pornFile, buildTestPornK2R(t, []string{"blocked-site.test"}))
+	initForReload(t, ruleFile, true)
+
+	if IsPorn("blocked-site.test") {
+		t.Fatal("IsPorn(blocked-site.test) before reload = true")
+	}
+	if err := ReloadPornFile(filepath.Join(dir, "missing.k2r.gz")); err == nil {
+		t.Error("ReloadPornFile() of a missing file succeeded")
+	}
+	if err := ReloadPornFile(pornFile); err != nil {
+		t.Fatalf("ReloadPornFile() failed: %v", err)
+	}
+	if !IsPorn("blocked-site.test") {
+		t.Error("IsPorn(blocked-site.test) after reload = false")
+	}
+	if got := Match("stable.example.com"); got != TargetReject {
+		t.Errorf("Match(stable.example.com) after porn reload = %s, want REJECT", got)
+	}
+	if config := GetConfig(); config.PornFile != pornFile || config.PornURL != "" {
+		t.Errorf("GetConfig() PornFile = %q, PornURL = %q, want %q and empty", config.PornFile, config.PornURL, pornFile)
+	}
+	defaultEngine.mu.RLock()
+	pornManager := defaultEngine.pornManager
+	defaultEngine.mu.RUnlock()
+	if pornManager != nil {
+		t.Error("porn manager still installed after ReloadPornFile()")
+	}
+}
@@ -3,10 +3,7 @@ package k2rule
 import (
 	"crypto/sha256"
 	"fmt"
-	"io"
-	"log/slog"
 	"net"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
@@ -24,23 +21,38 @@ type RemoteRuleManager struct {
 	url         string                    // Rule file URL
 	cacheDir    string                    // Cache directory (~/.cache/k2rule)
 	reader      *slice.CachedMmapReader   // Hot-reload capable reader
+	dl          *downloader               // Shared HTTP downloader (auth, timeouts)
 	fallback    atomic.Uint32             // Default fallback target (stored as uint32 for atomics)
 
+	// Shadow evaluation / canary rollout: updates are staged as pending
+	shadowRate  float64                             // Fraction of Match calls also evaluated against pending rules
+	pending     atomic.Pointer[slice.MmapReader]    // Downloaded but not yet active rules (nil = none)
+	shadow      shadowLog                           // Decision diffs between active and pending rules
+	canary      *CanaryStrategy                     // Canary rollout of pending rules (nil = not used)
+
+	flight      flightGroup                         // Deduplicates concurrent downloads (Update, auto-update, initial load)
+
+	managerBase // ManagerOption settings (interval, logger, validator, reader mode)
+
 	// Update metadata
 	mu          sync.RWMutex
 	etag        string                    // Current ETag
 	lastUpdate  time.Time                 // Last update time
+	lastErr     error                     // Last download/load error (nil after success)
 	stopCh      chan struct{}             // Stop channel for auto-update
+	stopOnce    sync.Once                 // Makes Stop idempotent
 }
 
 // NewRemoteRuleManager creates a new remote rule manager
-func NewRemoteRuleManager(url, cacheDir string, fallback Target) *RemoteRuleManager {
+func NewRemoteRuleManager(url, cacheDir string, fallback Target, opts ...ManagerOption) *RemoteRuleManager {
 	m := &RemoteRuleManager{
-		url:      url,
-		cacheDir: cacheDir,
-		reader:   slice.NewCachedMmapReader(),
-		stopCh:   make(chan struct{}),
+		url:    url,
+		reader: slice.NewCachedMmapReader(),
+		dl:     newDownloader(60 * time.Second),
+		stopCh: make(chan struct{}),
 	}
+	m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dl, opts)
+	m.reader.SetMode(m.sliceMode())
 	m.fallback.Store(uint32(fallback))
 	return m
 }
@@ -48,8 +60,10 @@ func NewRemoteRuleManager(url, cacheDir string, fallback Target) *RemoteRuleMana
 // Init initializes the manager: checks cache → downloads if needed → starts auto-update
 func (m *RemoteRuleManager) Init() error {
 	// Create cache directory
-	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
+	if !m.readOnly {
+		if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
 	}
 
 	// 1. Check cache
@@ -57,94 +71,105 @@ func (m *RemoteRuleManager) Init() error {
 	if _, err := os.Stat(cachedPath); err == nil {
 		// Cache exists, try to load it
 		if err := m.reader.Load(cachedPath); err == nil {
-			slog.Info("rules loaded from cache")
+			m.logger().Info("rules loaded from cache")
+			publishReload(m)
 			// Sync fallback from loaded file
 			m.fallback.Store(uint32(m.reader.Fallback()))
+			// Shadow/canary mode: restore a pending file staged before restart
+			if m.stagingEnabled() {
+				if _, err := os.Stat(m.getPendingPath()); err == nil {
+					if err := m.stagePending(m.getPendingPath()); err != nil {
+						m.logger().Warn("pending rules cache corrupted, ignoring", "error", err)
+					}
+				}
+			}
 			// Successfully loaded from cache, start background update check
-			go m.startAutoUpdate()
+			if !m.readOnly {
+				safeGo("rules", m.startAutoUpdate)
+			}
 			return nil
 		}
 		// Cache corrupted, will re-download
-		slog.Warn("rules cache corrupted, will re-download")
+		m.logger().Warn("rules cache corrupted, will re-download")
+	}
+
+	// Read-only cache: nothing to download into; proxy everything as while downloading
+	if m.readOnly {
+		m.fallback.Store(uint32(TargetProxy))
+		m.logger().Warn("rules cache not found, download skipped, cache is read-only")
+		return nil
 	}
 
 	// 2. Cache doesn't exist or is corrupted, download in background (non-blocking)
 	// Safe fallback: proxy all traffic until rules load to prevent GFW DNS pollution
 	// during the download window. downloadAndLoad() restores the file's actual fallback.
 	m.fallback.Store(uint32(TargetProxy))
-	slog.Info("rules cache not found, downloading in background")
-	go func() {
-		retryForever("rules", func() error { return m.downloadAndLoad(false) })
+	m.logger().Info("rules cache not found, downloading in background")
+	safeGo("rules", func() {
+		retryForever("rules", m.stopCh, func() error { return m.downloadAndLoad(false) })
 		m.startAutoUpdate()
-	}()
+	})
 
 	return nil
 }
 
 // Stop stops the auto-update background task
 func (m *RemoteRuleManager) Stop() {
-	close(m.stopCh)
+	m.stopOnce.Do(func() { close(m.stopCh) })
 }
 
 // Update manually triggers a rule update check
 func (m *RemoteRuleManager) Update() error {
+	if m.skipUpdate("rules") {
+		return nil
+	}
 	return m.downloadAndLoad(true)
 }
 
-// downloadAndLoad downloads the rule file and loads it
+// downloadAndLoad downloads the rule file and loads it.
+// Concurrent calls share one download and its result.
 func (m *RemoteRuleManager) downloadAndLoad(useETag bool) error {
-	req, err := http.NewRequest("GET", m.url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// ETag optimization: 304 Not Modified
-	m.mu.RLock()
-	currentETag := m.etag
-	m.mu.RUnlock()
-
-	if useETag && currentETag != "" {
-		req.Header.Set("If-None-Match", currentETag)
-	}
+	return m.flight.do("download", func() error { return m.fetchAndLoad(useETag) })
+}
 
-	slog.Debug("downloading rules", "url", m.url)
+// fetchAndLoad implements downloadAndLoad
+func (m *RemoteRuleManager) fetchAndLoad(useETag bool) (err error) {
+	defer func() { m.setLastError(err) }()
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+	// ETag optimization: 304 Not Modified
+	var currentETag string
+	if useETag {
+		currentETag = m.GetETag()
 	}
-	defer resp.Body.Close()
 
-	// 304 Not Modified - no need to update
-	if resp.StatusCode == http.StatusNotModified {
-		slog.Debug("rules not modified")
-		return nil
-	}
+	m.logger().Debug("downloading rules", "url", redactURL(m.url))
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	// Shadow/canary mode: once rules are active, updates are staged as pending instead
+	cachePath := m.getCachePath()
+	staging := m.stagingEnabled() && m.reader.Get() != nil
+	if staging {
+		cachePath = m.getPendingPath()
 	}
 
-	// Download to temporary file
-	tmpPath := m.getCachePath() + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
+	etag, modified, err := m.dl.fetchValidated(m.url, currentETag, cachePath, false, m.validator)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return err
 	}
 
-	_, err = io.Copy(tmpFile, resp.Body)
-	tmpFile.Close()
-	if err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to write temp file: %w", err)
+	// 304 Not Modified - no need to update
+	if !modified {
+		m.logger().Debug("rules not modified")
+		return nil
 	}
 
-	// Atomic rename (overwrite old cache)
-	cachePath := m.getCachePath()
-	if err := os.Rename(tmpPath, cachePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	if staging {
+		if err := m.stagePending(cachePath); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.etag = etag
+		m.mu.Unlock()
+		return nil
 	}
 
 	// Hot-reload (atomic swap)
@@ -157,31 +182,19 @@ func (m *RemoteRuleManager) downloadAndLoad(useETag bool) error {
 
 	// Update metadata
 	m.mu.Lock()
-	m.etag = resp.Header.Get("ETag")
+	m.etag = etag
 	m.lastUpdate = time.Now()
 	m.mu.Unlock()
 
-	slog.Info("rules downloaded and loaded")
+	m.logger().Info("rules downloaded and loaded")
+	publishReload(m)
 
 	return nil
 }
 
 // startAutoUpdate runs background auto-update (every 6 hours)
 func (m *RemoteRuleManager) startAutoUpdate() {
-	ticker := time.NewTicker(6 * time.Hour)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			// Check for updates (use ETag)
-			if err := m.downloadAndLoad(true); err != nil {
-				slog.Warn("rules auto-update failed", "error", err)
-			}
-		case <-m.stopCh:
-			return
-		}
-	}
+	m.autoUpdate(ComponentRules, 6*time.Hour, m.stopCh, func() error { return m.downloadAndLoad(true) })
 }
 
 // getCachePath returns the cache file path (based on URL hash)
@@ -191,6 +204,13 @@ func (m *RemoteRuleManager) getCachePath() string {
 	return filepath.Join(m.cacheDir, filename)
 }
 
+// getPendingPath returns the path of the pending (shadow mode) rule file
+func (m *RemoteRuleManager) getPendingPath() string {
+	hash := sha256.Sum256([]byte(m.url))
+	filename := fmt.Sprintf("%x.pending.k2r.gz", hash[:8])
+	return filepath.Join(m.cacheDir, filename)
+}
+
 // GetETag returns the current ETag
 func (m *RemoteRuleManager) GetETag() string {
 	m.mu.RLock()
@@ -210,6 +230,31 @@ func (m *RemoteRuleManager) GetGeneration() uint64 {
 	return m.reader.Generation()
 }
 
+// GetLastError returns the error of the most recent download attempt (nil after success)
+func (m *RemoteRuleManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Status returns a snapshot of the rule database state
+func (m *RemoteRuleManager) Status() ComponentInfo {
+	info := readerStatus(ComponentRules, m.url, m.reader)
+	info.ReadOnly = m.readOnly
+	m.mu.RLock()
+	info.LastUpdate = m.lastUpdate
+	info.LastError = errorString(m.lastErr)
+	m.mu.RUnlock()
+	return info
+}
+
+// setLastError records the outcome of a download attempt
+func (m *RemoteRuleManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
+
 // Internal matching methods (delegate to reader)
 
 // getFallback returns the fallback target (atomic, safe for concurrent access)
@@ -217,6 +262,15 @@ func (m *RemoteRuleManager) getFallback() Target {
 	return Target(m.fallback.Load())
 }
 
+// matchInput matches an IP (when ip != nil) or a domain against all static rules,
+// including the fallback (internal use only)
+func (m *RemoteRuleManager) matchInput(domain string, ip net.IP, geoIPMgr *GeoIPManager) MatchResult {
+	if ip == nil {
+		return globalRegistrable.matchDomain(m, domain)
+	}
+	return matchRules(m.reader, m.getFallback(), domain, ip, geoIPMgr)
+}
+
 // matchDomain matches a domain (internal use only)
 func (m *RemoteRuleManager) matchDomain(domain string) Target {
 	target := m.reader.MatchDomain(domain)
@@ -252,5 +306,8 @@ func (m *RemoteRuleManager) Fallback() Target {
 // Close closes the manager and reader
 func (m *RemoteRuleManager) Close() error {
 	m.Stop()
+	if pending := m.pending.Swap(nil); pending != nil {
+		pending.Close()
+	}
 	return m.reader.Close()
 }
@@ -0,0 +1,150 @@
+package k2rule
+
+import "net"
+
+// ReasonCode identifies which step of the pipeline decided (MatchResult.Reason), e.g.
+// to pick a localized block page for a REJECT. The string values are stable and can
+// be used as translation keys.
+type ReasonCode string
+
+// Reason codes of MatchResult.Reason
+const (
+	ReasonLAN          ReasonCode = "lan"           // LAN/private IP bypass
+	ReasonSource       ReasonCode = "source"        // Download host of a rule source (always DIRECT)
+	ReasonHosts        ReasonCode = "hosts"         // Hosts entry (Config.HostsFile, AddHost); REJECT for 0.0.0.0
+	ReasonUserRule     ReasonCode = "user-rule"     // TmpRule or keyword rule (MatchResult.Keyword)
+	ReasonNetwork      ReasonCode = "network"       // Rule of the active network profile (MatchResult.Profile)
+	ReasonPorn         ReasonCode = "porn"          // CategoryPorn policy (SetCategoryTarget)
+	ReasonCategory     ReasonCode = "category"      // Policy of another category (MatchResult.Category, e.g. "ads")
+	ReasonGlobal       ReasonCode = "global"        // Global mode
+	ReasonFailClosed   ReasonCode = "fail-closed"   // Config.FailClosed with no rule data loaded
+	ReasonUnknownInput ReasonCode = "unknown-input" // Config.UnknownInputTarget
+	ReasonRule         ReasonCode = "rule"          // Domain or IP-CIDR rule of the rule file
+	ReasonGeo          ReasonCode = "geo"           // GeoIP rule of the rule file (MatchResult.Country)
+	ReasonFallback     ReasonCode = "fallback"      // No rule matched (file, Config or network profile fallback)
+	ReasonRisk         ReasonCode = "risk"          // RiskScorer middleware
+	ReasonExperiment   ReasonCode = "experiment"    // DefineExperiment (MatchResult.Experiment)
+)
+
+// MatchResult is the decision of MatchVerbose with details for display and logging,
+// e.g. "8.8.8.8 (US) → PROXY".
+type MatchResult struct {
+	Target  Target     `json:"target"`
+	Reason  ReasonCode `json:"reason,omitempty"`  // Step that decided (decisions of custom middleware keep the reason they set)
+	Country string     `json:"country,omitempty"` // ISO country code of an IP input ("" for domains or without GeoIP)
+
+	// Fallback is true when no rule matched and Target is the rule set's fallback
+	// (or Config.GlobalTarget while no rules are loaded). LAN and source-domain bypasses,
+	// temporary rules and global mode are explicit decisions.
+	Fallback bool `json:"fallback,omitempty"`
+
+	// Unknown is true when the input is neither an IP address nor a valid domain name
+	// (see Config.UnknownInputTarget).
+	Unknown bool `json:"unknown,omitempty"`
+
+	// Category is the category whose SetCategoryTarget policy decided ("" otherwise).
+	Category string `json:"category,omitempty"`
+
+	// Keyword is the keyword of the keyword rule that decided (SetKeywordRules, "" otherwise).
+	Keyword string `json:"keyword,omitempty"`
+
+	// Domain is the domain that decided for an IP input: bound with BindDomainIPs, or
+	// its PTR name matched by a domain rule (Config.EnableRDNS). "" otherwise.
+	Domain string `json:"domain,omitempty"`
+
+	// HostIP is the IP a hosts entry (Config.HostsFile, AddHost) maps the domain to
+	// (the first one; LookupHost returns all). "" otherwise.
+	HostIP string `json:"host_ip,omitempty"`
+
+	// Profile is the network profile whose rule or fallback decided ("" otherwise).
+	Profile string `json:"profile,omitempty"`
+
+	// Experiment is the experiment that diverted the decision to its target
+	// (DefineExperiment, "" otherwise).
+	Experiment string `json:"experiment,omitempty"`
+
+	// RejectMode is how a REJECT decision should be enforced: rules targeting
+	// REJECT-DROP or REJECT-RESET are reported as TargetReject with RejectDrop or
+	// RejectReset. Always RejectDefault for other targets.
+	RejectMode RejectMode `json:"reject_mode,omitempty"`
+
+	// DNS holds the DNS hints of the rule file's domain slice that decided (see
+	// DNSHint); 0 for other decisions and rule files without hints.
+	DNS DNSHint `json:"dns,omitempty"`
+
+	// Generation is the rule generation (GetGeneration) when the decision was made.
+	// Callers caching decisions externally drop entries whose Generation no longer
+	// matches GetGeneration(). 0 while no rule data is loaded.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// withRejectMode folds the REJECT-DROP and REJECT-RESET targets into TargetReject
+// with the matching RejectMode
+func (r MatchResult) withRejectMode() MatchResult {
+	if mode, ok := r.Target.RejectMode(); ok && r.Target != TargetReject {
+		r.Target = TargetReject
+		r.RejectMode = mode
+	} else if !ok {
+		r.RejectMode = RejectDefault
+	}
+	return r
+}
+
+// MatchVerbose is like Match but also returns details of the decision.
+// For IP inputs, Country is filled from GeoIP even when an IP-CIDR rule decided.
+//
+// Example:
+//
+//	r := k2rule.MatchVerbose("8.8.8.8")
+//	fmt.Printf("8.8.8.8 (%s) → %s\n", r.Country, r.Target)
+func MatchVerbose(input string) MatchResult {
+	result := defaultEngine.match(input)
+	recordMatch(input, result)
+	return defaultEngine.withCountry(input, result)
+}
+
+// matchVerbose implements Engine.MatchVerbose
+func (e *Engine) matchVerbose(input string) MatchResult {
+	return e.withCountry(input, e.match(input))
+}
+
+// withCountry fills the Country of a public IP decision that didn't need GeoIP
+func (e *Engine) withCountry(input string, result MatchResult) MatchResult {
+	if result.Country == "" {
+		if ip := net.ParseIP(input); ip != nil && !isPrivateIP(ip) {
+			result.Country, _ = e.LookupCountry(input)
+		}
+	}
+	return result
+}
+
+// GetGeneration returns the generation of the rule data Match decides with: it
+// increments on every rule reload (download, hot-reload, promotion of pending rules).
+// Init and UpdateConfig install a new rule manager whose generations start over, so
+// external decision caches must also be cleared when reconfiguring.
+// 0 while no rule data is loaded.
+//
+// Example:
+//
+//	r := k2rule.MatchVerbose(domain)
+//	cache.Put(domain, r)
+//	// later
+//	if cached, ok := cache.Get(domain); ok && cached.Generation == k2rule.GetGeneration() {
+//	    return cached.Target
+//	}
+func GetGeneration() uint64 {
+	return defaultEngine.Generation()
+}
+
+// IsExplicitMatch reports whether input is decided by an actual rule rather than
+// falling through to the fallback target (see MatchResult.Fallback).
+// Clients use it to offer "add a rule for …?" only for fallback traffic.
+//
+// Example:
+//
+//	if !k2rule.IsExplicitMatch(host) {
+//		promptAddRule(host)
+//	}
+func IsExplicitMatch(input string) bool {
+	return !defaultEngine.query(input).Fallback
+}
@@ -0,0 +1,362 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+func TestMatchVerbose(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		input string
+		want  MatchResult
+	}{
+		{"blocked.com", MatchResult{Target: TargetReject, Reason: ReasonRule, Generation: 1}},
+		{"allowed.com", MatchResult{Target: TargetDirect, Reason: ReasonFallback, Fallback: true, Generation: 1}},
+		{"192.168.1.1", MatchResult{Target: TargetDirect, Reason: ReasonLAN, Generation: 1}},
+		{"8.8.8.8", MatchResult{Target: TargetDirect, Reason: ReasonFallback, Fallback: true, Generation: 1}}, // no GeoIP → no country
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := MatchVerbose(tt.input)
+			if got != tt.want {
+				t.Errorf("MatchVerbose(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+			if got.Target != Match(tt.input) {
+				t.Errorf("MatchVerbose(%q).Target disagrees with Match", tt.input)
+			}
+		})
+	}
+}
+
+func TestMatchVerbose_RejectMode(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	w := slice.NewSliceWriter(0)
+	for domain, target := range map[string]Target{
+		"drop.example":  TargetRejectDrop,
+		"reset.example": TargetRejectReset,
+		"plain.example": TargetReject,
+	} {
+		if err := w.AddDomainSlice([]string{domain}, uint8(target)); err != nil {
+			t.Fatalf("AddDomainSlice failed: %v", err)
+		}
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	SetTmpRule("tmp.example", TargetRejectReset)
+
+	for input, want := range map[string]MatchResult{
+		"drop.example":  {Target: TargetReject, Reason: ReasonRule, RejectMode: RejectDrop, Generation: 1},
+		"reset.example": {Target: TargetReject, Reason: ReasonRule, RejectMode: RejectReset, Generation: 1},
+		"plain.example": {Target: TargetReject, Reason: ReasonRule, Generation: 1},
+		"tmp.example":   {Target: TargetReject, Reason: ReasonUserRule, RejectMode: RejectReset, Generation: 1},
+		"other.example": {Target: TargetDirect, Reason: ReasonFallback, Fallback: true, Generation: 1},
+	} {
+		if got := MatchVerbose(input); got != want {
+			t.Errorf("MatchVerbose(%q) = %+v, want %+v", input, got, want)
+		}
+		if got := Match(input); got != want.Target {
+			t.Errorf("Match(%q) = %s, want %s", input, got, want.Target)
+		}
+	}
+
+	// Middleware returning a REJECT-DROP target is folded as well
+	UseMiddleware(func(input string, result MatchResult) MatchResult {
+		if input == "other.example" {
+			result.Target = TargetRejectDrop
+		}
+		return result
+	})
+	if got := MatchVerbose("other.example"); got.Target != TargetReject || got.RejectMode != RejectDrop {
+		t.Errorf("MatchVerbose(other.example) with middleware = %+v, want REJECT drop", got)
+	}
+
+	data, err = json.Marshal(MatchVerbose("drop.example"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"target":"REJECT","reason":"rule","reject_mode":"drop","generation":1}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+	var decoded MatchResult
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.RejectMode != RejectDrop {
+		t.Errorf("Unmarshal = %+v, %v; want RejectDrop", decoded, err)
+	}
+}
+
+func TestMatchVerbose_Generation(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if got := GetGeneration(); got != 0 {
+		t.Errorf("GetGeneration() before Init = %d, want 0", got)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	cached := MatchVerbose("blocked.com")
+	if cached.Generation != GetGeneration() {
+		t.Fatalf("Generation = %d, want GetGeneration() = %d", cached.Generation, GetGeneration())
+	}
+
+	// A hot-reload invalidates decisions recorded before it
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cached.Generation == GetGeneration() {
+		t.Errorf("GetGeneration() = %d after reload, want it to differ from the cached %d", GetGeneration(), cached.Generation)
+	}
+	if got := MatchVerbose("blocked.com").Generation; got != GetGeneration() {
+		t.Errorf("Generation after reload = %d, want %d", got, GetGeneration())
+	}
+}
+
+func TestMatchVerbose_Reason(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer func() {
+		ClearCategoryTarget(CategoryPorn)
+		ClearCategoryTarget("ads")
+		ResetDomain("ads", "tracker.example")
+	}()
+
+	w := slice.NewSliceWriter(0)
+	if err := w.AddDomainSlice([]string{"blocked.com"}, uint8(TargetReject)); err != nil {
+		t.Fatalf("AddDomainSlice failed: %v", err)
+	}
+	if err := w.AddGeoIPSlice([]string{"US"}, uint8(TargetProxy)); err != nil {
+		t.Fatalf("AddGeoIPSlice failed: %v", err)
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	mmdbPath := filepath.Join(tmpDir, "geo.mmdb")
+	if err := os.WriteFile(mmdbPath, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	geoIPMgr := NewGeoIPManager("", tmpDir)
+	if err := geoIPMgr.loadDatabase(mmdbPath); err != nil {
+		t.Fatalf("loadDatabase failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.manager = manager
+	defaultEngine.geoIPMgr = geoIPMgr
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	SetCategoryTarget(CategoryPorn, TargetReject)
+	SetCategoryTarget("ads", TargetReject)
+	AddDomain("ads", "tracker.example")
+	SetKeywordRules([]KeywordRule{{Keyword: "payroll", Target: TargetReject}})
+	SetTmpRule("tmp.example", TargetReject)
+	AddHost("ads.blackhole.example", net.IPv4zero)
+
+	tests := []struct {
+		input string
+		want  ReasonCode
+	}{
+		{"blocked.com", ReasonRule},
+		{"8.8.8.8", ReasonGeo},
+		{"9.9.9.9", ReasonFallback},
+		{"10.0.0.1", ReasonLAN},
+		{"pornhub.com", ReasonPorn},
+		{"tracker.example", ReasonCategory},
+		{"payroll.corp.example", ReasonUserRule},
+		{"tmp.example", ReasonUserRule},
+		{"ads.blackhole.example", ReasonHosts},
+		{"foo bar", ReasonUnknownInput},
+	}
+	for _, tt := range tests {
+		if got := MatchVerbose(tt.input).Reason; got != tt.want {
+			t.Errorf("MatchVerbose(%s).Reason = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	ToggleGlobal(true)
+	if got := MatchVerbose("example.com").Reason; got != ReasonGlobal {
+		t.Errorf("Reason in global mode = %q, want %q", got, ReasonGlobal)
+	}
+}
+
+func TestIsExplicitMatch(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// Rules whose target equals the fallback (DIRECT) are still explicit
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	w.AddDomainSlice([]string{"direct.com"}, uint8(TargetDirect))
+	w.AddDomainSlice([]string{"blocked.com"}, uint8(TargetReject))
+	w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 0x01020300, PrefixLen: 24}}, uint8(TargetDirect))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	SetTmpRule("tmp.com", TargetProxy)
+
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"blocked.com", true},
+		{"direct.com", true},
+		{"www.direct.com", true},
+		{"1.2.3.4", true},
+		{"192.168.1.1", true}, // LAN bypass
+		{"tmp.com", true},
+		{"unknown.com", false},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := IsExplicitMatch(tt.input); got != tt.want {
+			t.Errorf("IsExplicitMatch(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLookupCountry_Errors(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if _, err := LookupCountry("not-an-ip"); err == nil {
+		t.Error("LookupCountry(not-an-ip) succeeded, want error")
+	}
+	if _, err := LookupCountry("8.8.8.8"); err == nil {
+		t.Error("LookupCountry without GeoIP succeeded, want error")
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.geoIPMgr = NewGeoIPManager("", t.TempDir()) // not loaded
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if _, err := LookupCountry("8.8.8.8"); err == nil {
+		t.Error("LookupCountry with unloaded GeoIP succeeded, want error")
+	}
+}
+
+func TestMatchVerbose_DNSHints(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	if err := w.AddDomainSlice([]string{"game.example"}, uint8(TargetDirect)); err != nil {
+		t.Fatalf("AddDomainSlice failed: %v", err)
+	}
+	if err := w.SetLastSliceDNSHints(slice.DNSHintRealIP); err != nil {
+		t.Fatalf("SetLastSliceDNSHints failed: %v", err)
+	}
+	if err := w.AddDomainSlice([]string{"news.example"}, uint8(TargetProxy)); err != nil {
+		t.Fatalf("AddDomainSlice failed: %v", err)
+	}
+	if err := w.SetLastSliceDNSHints(slice.DNSHintRemoteDNS | slice.DNSHintFakeIP); err != nil {
+		t.Fatalf("SetLastSliceDNSHints failed: %v", err)
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetProxy)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if r := MatchVerbose("cdn.game.example"); r.DNS != DNSHintRealIP || !r.DNS.Has(DNSHintRealIP) {
+		t.Errorf("MatchVerbose(cdn.game.example).DNS = %v, want real_ip", r.DNS.Names())
+	}
+	r := MatchVerbose("news.example")
+	if !r.DNS.Has(DNSHintRemoteDNS|DNSHintFakeIP) || r.DNS.Has(DNSHintRealIP) {
+		t.Errorf("MatchVerbose(news.example).DNS = %v, want remote_dns and fake_ip", r.DNS.Names())
+	}
+	data, err = json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded MatchResult
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.DNS != r.DNS {
+		t.Errorf("DNS hints JSON round trip = %v (%v), want %v (%s)", decoded.DNS, err, r.DNS, data)
+	}
+	if r := MatchVerbose("other.example"); r.DNS != 0 {
+		t.Errorf("MatchVerbose(other.example).DNS = %v, want none for the fallback", r.DNS.Names())
+	}
+}
@@ -1,25 +1,94 @@
 package k2rule
 
 import (
+	"fmt"
 	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
-// retryForever calls fn repeatedly until it returns nil.
+// PanicHandler is called when a background goroutine (download, auto-update)
+// recovers from a panic. component is "rules", "geoip" or "porn".
+type PanicHandler func(component string, recovered any, stack []byte)
+
+// panicHandler is the hook installed from Config.OnPanic (nil = log only)
+var panicHandler atomic.Pointer[PanicHandler]
+
+// setPanicHandler installs h as the process-wide panic hook (nil removes it)
+func setPanicHandler(h PanicHandler) {
+	if h == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&h)
+}
+
+// retryForever calls fn repeatedly until it returns nil or stop is closed (nil = never).
 // Uses exponential backoff: 1s, 2s, 4s, ..., capped at 64s.
-func retryForever(component string, fn func() error) {
+// A panic in fn is recovered and retried like an error.
+func retryForever(component string, stop <-chan struct{}, fn func() error) {
 	backoff := time.Second
 	maxBackoff := 64 * time.Second
 	for {
-		err := fn()
+		err := safeCall(component, fn)
 		if err == nil {
 			return
 		}
 		slog.Warn("retrying after error", "component", component, "error", err, "backoff", backoff)
-		time.Sleep(backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
 		backoff *= 2
 		if backoff > maxBackoff {
 			backoff = maxBackoff
 		}
 	}
 }
+
+// safeGo runs fn in a new goroutine. A panic is recovered and reported instead of
+// crashing the host process.
+func safeGo(component string, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(component, r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// safeCall runs fn, converting a panic into an error (after reporting it).
+// Used for each update attempt so a single bad download doesn't stop the update loop.
+func safeCall(component string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(component, r)
+			err = fmt.Errorf("panic in %s: %v", component, r)
+		}
+	}()
+	return fn()
+}
+
+// reportPanic logs a recovered panic and forwards it to the installed PanicHandler
+func reportPanic(component string, recovered any) {
+	stack := debug.Stack()
+	slog.Error("recovered from panic", "component", component, "panic", recovered, "stack", string(stack))
+
+	h := panicHandler.Load()
+	if h == nil {
+		return
+	}
+	// The hook itself must not take the process down either
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("panic in OnPanic handler", "component", component, "panic", r)
+		}
+	}()
+	(*h)(component, recovered, stack)
+}
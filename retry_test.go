@@ -8,7 +8,7 @@ import (
 
 func TestRetryForever_SucceedsImmediately(t *testing.T) {
 	calls := 0
-	retryForever("test", func() error {
+	retryForever("test", nil, func() error {
 		calls++
 		return nil
 	})
@@ -19,7 +19,7 @@ func TestRetryForever_SucceedsImmediately(t *testing.T) {
 
 func TestRetryForever_SucceedsAfterRetries(t *testing.T) {
 	calls := 0
-	retryForever("test", func() error {
+	retryForever("test", nil, func() error {
 		calls++
 		if calls < 3 {
 			return fmt.Errorf("fail %d", calls)
@@ -31,6 +31,29 @@ func TestRetryForever_SucceedsAfterRetries(t *testing.T) {
 	}
 }
 
+func TestRetryForever_StopsWhenClosed(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan int, 1)
+	go func() {
+		calls := 0
+		retryForever("test", stop, func() error {
+			calls++
+			return fmt.Errorf("fail %d", calls)
+		})
+		done <- calls
+	}()
+	close(stop)
+
+	select {
+	case calls := <-done:
+		if calls != 1 {
+			t.Errorf("expected 1 call before stop, got %d", calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryForever did not return after stop was closed")
+	}
+}
+
 func TestRetryForever_BackoffCapsAt64s(t *testing.T) {
 	b := time.Second
 	max := 64 * time.Second
@@ -44,3 +67,66 @@ func TestRetryForever_BackoffCapsAt64s(t *testing.T) {
 		t.Errorf("backoff should cap at %v, got %v", max, b)
 	}
 }
+
+func TestSafeCall_RecoversPanic(t *testing.T) {
+	var gotComponent string
+	var gotRecovered any
+	setPanicHandler(func(component string, recovered any, stack []byte) {
+		gotComponent = component
+		gotRecovered = recovered
+		if len(stack) == 0 {
+			t.Error("expected non-empty stack")
+		}
+	})
+	defer setPanicHandler(nil)
+
+	err := safeCall("rules", func() error { panic("boom") })
+	if err == nil {
+		t.Fatal("expected error from panicking fn")
+	}
+	if gotComponent != "rules" || gotRecovered != "boom" {
+		t.Errorf("handler got (%q, %v), want (rules, boom)", gotComponent, gotRecovered)
+	}
+}
+
+func TestSafeGo_RecoversPanic(t *testing.T) {
+	done := make(chan string, 1)
+	setPanicHandler(func(component string, recovered any, stack []byte) {
+		done <- component
+	})
+	defer setPanicHandler(nil)
+
+	safeGo("geoip", func() { panic("boom") })
+
+	select {
+	case component := <-done:
+		if component != "geoip" {
+			t.Errorf("component = %q, want geoip", component)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("panic handler not called")
+	}
+}
+
+func TestSafeCall_PanickingHandler(t *testing.T) {
+	setPanicHandler(func(string, any, []byte) { panic("handler boom") })
+	defer setPanicHandler(nil)
+
+	if err := safeCall("porn", func() error { panic("boom") }); err == nil {
+		t.Error("expected error from panicking fn")
+	}
+}
+
+func TestRetryForever_RetriesAfterPanic(t *testing.T) {
+	calls := 0
+	retryForever("test", nil, func() error {
+		calls++
+		if calls == 1 {
+			panic("first attempt")
+		}
+		return nil
+	})
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
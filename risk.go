@@ -0,0 +1,69 @@
+package k2rule
+
+import (
+	"net"
+
+	"github.com/kaitu-io/k2rule/internal/risk"
+)
+
+// RiskResult is a phishing risk score from 0 (no signal) to 100 with the signals that
+// contributed to it, e.g. {Score: 85, Reasons: ["brand-keyword:paypal", "phishing-keyword",
+// "suspicious-tld"]}
+type RiskResult = risk.Result
+
+// DomainRisk scores domain for phishing likelihood from lexical signals only: label
+// entropy, digit and hyphen ratios, brand keywords and typos outside the brand's own
+// domain, credential-lure keywords, suspicious TLDs and IDN homographs. No I/O.
+func DomainRisk(domain string) RiskResult {
+	var s risk.Scorer
+	return s.Score(domain)
+}
+
+// RiskScorer scores domains like DomainRisk, optionally consulting a reputation list
+type RiskScorer struct {
+	scorer risk.Scorer
+}
+
+// NewRiskScorer creates a scorer. reputation is an optional K2RULEV3 list of known-bad
+// domains (e.g. a RemoteRuleManager downloading a phishing feed); domains it maps to
+// a REJECT target score 100. nil = lexical signals only.
+//
+// Example:
+//
+//	feed := k2rule.NewRemoteRuleManager(phishingFeedURL, cacheDir, k2rule.TargetDirect)
+//	feed.Init()
+//	scorer := k2rule.NewRiskScorer(feed)
+func NewRiskScorer(reputation *RemoteRuleManager) *RiskScorer {
+	s := &RiskScorer{}
+	if reputation != nil {
+		s.scorer.Reputation = func(domain string) bool {
+			target := reputation.reader.MatchDomain(domain)
+			if target == nil {
+				return false
+			}
+			_, reject := Target(*target).RejectMode()
+			return reject
+		}
+	}
+	return s
+}
+
+// Score returns the phishing risk of domain
+func (s *RiskScorer) Score(domain string) RiskResult {
+	return s.scorer.Score(domain)
+}
+
+// Middleware returns a MatchMiddleware routing domains scoring at least threshold to
+// target. Only fallback decisions are rescored: explicit rules, TmpRules, global mode,
+// IP inputs and invalid inputs keep their result.
+func (s *RiskScorer) Middleware(threshold int, target Target) MatchMiddleware {
+	return func(input string, result MatchResult) MatchResult {
+		if !result.Fallback || result.Unknown || net.ParseIP(input) != nil {
+			return result
+		}
+		if s.Score(input).Score >= threshold {
+			return MatchResult{Target: target, Reason: ReasonRisk}
+		}
+		return result
+	}
+}
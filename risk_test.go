@@ -0,0 +1,76 @@
+package k2rule
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDomainRiskAndMiddleware(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if r := DomainRisk("paypal-login-verify.com"); r.Score < 60 {
+		t.Errorf("DomainRisk(paypal-login-verify.com) = %+v, want >= 60", r)
+	}
+	if r := DomainRisk("paypal.com"); r.Score != 0 {
+		t.Errorf("DomainRisk(paypal.com) = %+v, want 0", r)
+	}
+
+	tmpDir := t.TempDir()
+	feedPath := filepath.Join(tmpDir, "feed.k2r.gz")
+	writeTestK2RGzipFile(t, feedPath, buildTestPornK2R(t, []string{"listed.example"}))
+	feed := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := feed.reader.Load(feedPath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	scorer := NewRiskScorer(feed)
+	if r := scorer.Score("www.listed.example"); r.Score != 100 {
+		t.Errorf("Score(listed) = %+v, want 100", r)
+	}
+
+	// Rules: paypal-login-verify.net → REJECT, fallback DIRECT
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"paypal-login-verify.net"}))
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	var calls int
+	UseMiddleware(scorer.Middleware(60, TargetProxy), func(input string, r MatchResult) MatchResult {
+		calls++
+		return r
+	})
+
+	tests := []struct {
+		input string
+		want  Target
+	}{
+		{"paypal-login-verify.com", TargetProxy},  // risky fallback → middleware target
+		{"listed.example", TargetProxy},           // reputation
+		{"paypal-login-verify.net", TargetReject}, // explicit rule kept
+		{"example.com", TargetDirect},             // low risk fallback
+		{"8.8.8.8", TargetDirect},                 // IPs untouched
+	}
+	for _, tt := range tests {
+		if got := Match(tt.input); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+	if calls != len(tests) {
+		t.Errorf("second middleware called %d times, want %d", calls, len(tests))
+	}
+	if !IsExplicitMatch("paypal-login-verify.com") {
+		t.Error("IsExplicitMatch(risky domain) = false, want middleware decision to be explicit")
+	}
+
+	ClearMiddleware()
+	if got := Match("paypal-login-verify.com"); got != TargetDirect {
+		t.Errorf("Match() after ClearMiddleware = %v, want DIRECT", got)
+	}
+}
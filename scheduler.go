@@ -0,0 +1,242 @@
+package k2rule
+
+import (
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduleInfo is a snapshot of one background schedule (a component's auto-update,
+// telemetry delivery, hit-stats export), as reported by Schedules and Health
+type ScheduleInfo struct {
+	Name      string    `json:"name"`                 // Component name (ComponentRules, ComponentGeoIP, …, "telemetry", "hitstats")
+	Interval  Duration  `json:"interval"`             // Base interval between runs (before jitter)
+	NextRun   time.Time `json:"next_run"`             // When the next run is due (it waits while updates are paused)
+	LastRun   time.Time `json:"last_run,omitempty"`   // Start of the most recent run (zero = none yet)
+	Running   bool      `json:"running,omitempty"`    // A run is in progress
+	Paused    bool      `json:"paused,omitempty"`     // Updates are paused (see PauseUpdates)
+	LastError string    `json:"last_error,omitempty"` // Error of the most recent run ("" after success)
+}
+
+// scheduler runs a job periodically on its own goroutine (see run). All background
+// loops share it, so pausing, immediate runs and jitter behave the same everywhere.
+type scheduler struct {
+	name     string
+	interval func() time.Duration       // Delay until the next run, evaluated after each run
+	jitter   float64                    // Each delay is randomized by ±jitter (fraction of the delay)
+	job      func(immediate bool) error // immediate = run requested by RunUpdatesNow
+	log      *slog.Logger
+
+	trigger chan struct{} // Immediate run requested
+	wake    chan struct{} // Pause state changed
+
+	mu      sync.Mutex
+	base    time.Duration // Interval before jitter
+	next    time.Time
+	lastRun time.Time
+	lastErr error
+	running bool
+}
+
+// schedulers tracks the running schedulers and the global pause state
+var schedulers = struct {
+	sync.Mutex
+	active map[*scheduler]struct{}
+	paused bool
+}{active: make(map[*scheduler]struct{})}
+
+// newScheduler creates a scheduler running job every interval() ± jitter
+func newScheduler(name string, interval func() time.Duration, jitter float64, log *slog.Logger, job func(immediate bool) error) *scheduler {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &scheduler{
+		name:     name,
+		interval: interval,
+		jitter:   jitter,
+		job:      job,
+		log:      log,
+		trigger:  make(chan struct{}, 1),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// every returns a scheduler interval function with a fixed interval
+func every(d time.Duration) func() time.Duration {
+	return func() time.Duration { return d }
+}
+
+// run runs the job on schedule until stop is closed. The first run is one interval
+// after the call (components load or download their data before starting the schedule).
+// While updates are paused no timer is armed; on resume, an overdue run starts at once.
+func (s *scheduler) run(stop <-chan struct{}) {
+	schedulers.Lock()
+	schedulers.active[s] = struct{}{}
+	schedulers.Unlock()
+	defer func() {
+		schedulers.Lock()
+		delete(schedulers.active, s)
+		schedulers.Unlock()
+	}()
+
+	s.reschedule(time.Now())
+	for {
+		var due <-chan time.Time
+		timer := time.NewTimer(time.Until(s.nextRun()))
+		if !updatesPaused() {
+			due = timer.C
+		}
+
+		select {
+		case <-due:
+			s.runJob(false)
+		case <-s.trigger:
+			timer.Stop()
+			s.runJob(true)
+		case <-s.wake:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// runJob runs the job once (recovering panics) and schedules the next run
+func (s *scheduler) runJob(immediate bool) {
+	s.mu.Lock()
+	s.running = true
+	s.lastRun = time.Now()
+	s.mu.Unlock()
+
+	err := safeCall(s.name, func() error { return s.job(immediate) })
+	if err != nil {
+		s.log.Warn(s.name+" auto-update failed", "error", err)
+	}
+
+	s.mu.Lock()
+	s.running = false
+	s.lastErr = err
+	s.mu.Unlock()
+	s.reschedule(time.Now())
+}
+
+// reschedule sets the next run one jittered interval after now
+func (s *scheduler) reschedule(now time.Time) {
+	base := s.interval()
+	delay := base
+	if s.jitter > 0 {
+		delay += time.Duration(float64(base) * s.jitter * (2*rand.Float64() - 1))
+	}
+	s.mu.Lock()
+	s.base = base
+	s.next = now.Add(delay)
+	s.mu.Unlock()
+}
+
+// nextRun returns when the next run is due
+func (s *scheduler) nextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next
+}
+
+// runNow requests an immediate run (coalesced with a pending request)
+func (s *scheduler) runNow() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// notify wakes the loop to re-evaluate the pause state
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// info returns a snapshot of the schedule
+func (s *scheduler) info(paused bool) ScheduleInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ScheduleInfo{
+		Name:      s.name,
+		Interval:  Duration(s.base),
+		NextRun:   s.next,
+		LastRun:   s.lastRun,
+		Running:   s.running,
+		Paused:    paused,
+		LastError: errorString(s.lastErr),
+	}
+}
+
+// updatesPaused reports whether PauseUpdates is in effect
+func updatesPaused() bool {
+	schedulers.Lock()
+	defer schedulers.Unlock()
+	return schedulers.paused
+}
+
+// PauseUpdates suspends all background schedules (component auto-updates, telemetry
+// delivery, hit-stats exports), e.g. when a mobile app moves to the background.
+// Runs in progress complete; schedules started while paused wait as well.
+// Initial downloads of components without cached data are not paused.
+func PauseUpdates() {
+	setUpdatesPaused(true)
+}
+
+// ResumeUpdates resumes the schedules suspended by PauseUpdates. Runs that became due
+// while paused start immediately; the others keep their schedule.
+func ResumeUpdates() {
+	setUpdatesPaused(false)
+}
+
+// setUpdatesPaused sets the pause state and wakes all schedulers
+func setUpdatesPaused(paused bool) {
+	schedulers.Lock()
+	defer schedulers.Unlock()
+	schedulers.paused = paused
+	for s := range schedulers.active {
+		s.notify()
+	}
+}
+
+// RunUpdatesNow starts a run of every background schedule without waiting for its
+// interval (even while paused), e.g. when a mobile app returns to the foreground after
+// a long time. It doesn't wait for the runs; their next run is rescheduled one
+// interval after they complete. Use Component.Update for a synchronous update.
+func RunUpdatesNow() {
+	schedulers.Lock()
+	defer schedulers.Unlock()
+	for s := range schedulers.active {
+		s.runNow()
+	}
+}
+
+// Schedules returns the state of every running background schedule, sorted by name
+//
+// Example:
+//
+//	for _, s := range k2rule.Schedules() {
+//	    log.Printf("%s: next run %s", s.Name, s.NextRun.Format(time.RFC3339))
+//	}
+func Schedules() []ScheduleInfo {
+	schedulers.Lock()
+	paused := schedulers.paused
+	active := make([]*scheduler, 0, len(schedulers.active))
+	for s := range schedulers.active {
+		active = append(active, s)
+	}
+	schedulers.Unlock()
+
+	infos := make([]ScheduleInfo, len(active))
+	for i, s := range active {
+		infos[i] = s.info(paused)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
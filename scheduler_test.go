@@ -0,0 +1,125 @@
+package k2rule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// startTestScheduler runs a scheduler whose runs are reported on the returned channel
+// (true = immediate run); the scheduler stops at the end of the test
+func startTestScheduler(t *testing.T, name string, interval time.Duration, jitter float64) (*scheduler, <-chan bool) {
+	t.Helper()
+	runs := make(chan bool, 16)
+	s := newScheduler(name, every(interval), jitter, nil, func(immediate bool) error {
+		runs <- immediate
+		return errors.New("update failed")
+	})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.run(stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	// Wait until registered
+	for deadline := time.Now().Add(time.Second); ; {
+		if len(Schedules()) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s, runs
+}
+
+// waitRun returns the next run reported by startTestScheduler, failing after timeout
+func waitRun(t *testing.T, runs <-chan bool, timeout time.Duration) bool {
+	t.Helper()
+	select {
+	case immediate := <-runs:
+		return immediate
+	case <-time.After(timeout):
+		t.Fatal("scheduled run did not happen")
+		return false
+	}
+}
+
+func TestScheduler_Interval(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	_, runs := startTestScheduler(t, "test", 20*time.Millisecond, 0)
+	for i := 0; i < 2; i++ {
+		if waitRun(t, runs, time.Second) {
+			t.Error("interval run reported as immediate")
+		}
+	}
+
+	// The job reports a run before it returns: wait for the run to complete
+	infos := Schedules()
+	for deadline := time.Now().Add(time.Second); len(infos) == 1 && infos[0].Running && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		infos = Schedules()
+	}
+	if len(infos) != 1 || infos[0].Name != "test" || infos[0].Interval != Duration(20*time.Millisecond) {
+		t.Fatalf("Schedules() = %+v, want the test schedule", infos)
+	}
+	if infos[0].LastRun.IsZero() || infos[0].NextRun.Before(infos[0].LastRun) {
+		t.Errorf("Schedules() = %+v, want a last run and the next run after it", infos[0])
+	}
+	if infos[0].LastError != "update failed" {
+		t.Errorf("LastError = %q, want the job error", infos[0].LastError)
+	}
+	if health := Health(); len(health.Schedules) != 1 {
+		t.Errorf("Health().Schedules = %+v, want the test schedule", health.Schedules)
+	}
+}
+
+func TestScheduler_PauseResumeRunNow(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	PauseUpdates()
+	_, runs := startTestScheduler(t, "test", 30*time.Millisecond, 0)
+	select {
+	case <-runs:
+		t.Fatal("scheduler ran while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if infos := Schedules(); !infos[0].Paused || !Health().UpdatesPaused {
+		t.Errorf("Schedules() = %+v, want paused", infos)
+	}
+
+	// Immediate runs happen even while paused
+	RunUpdatesNow()
+	if !waitRun(t, runs, time.Second) {
+		t.Error("RunUpdatesNow run not reported as immediate")
+	}
+
+	// The run that became due while paused starts on resume
+	time.Sleep(50 * time.Millisecond)
+	ResumeUpdates()
+	if waitRun(t, runs, time.Second) {
+		t.Error("overdue run reported as immediate")
+	}
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	s := newScheduler("test", every(time.Hour), 0.1, nil, func(bool) error { return nil })
+	now := time.Now()
+	varied := false
+	for i := 0; i < 20; i++ {
+		s.reschedule(now)
+		delay := s.nextRun().Sub(now)
+		if delay < 54*time.Minute || delay > 66*time.Minute {
+			t.Fatalf("jittered delay %s outside 1h ± 10%%", delay)
+		}
+		varied = varied || delay != time.Hour
+	}
+	if !varied {
+		t.Error("jitter never changed the delay")
+	}
+}
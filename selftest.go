@@ -0,0 +1,142 @@
+package k2rule
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// selfTestCheck is one check of the SelfTest battery; it returns a discrepancy
+// ("" = passed)
+type selfTestCheck struct {
+	name string
+	run  func() string
+}
+
+// SelfTest runs a canned battery of lookups against the loaded databases and
+// returns an error listing every discrepancy (nil = all passed), so deployments
+// can verify a node's policy engine after startup or an update:
+//
+//   - a LAN IP (192.168.1.1) routes DIRECT
+//   - the GeoIP database resolves a known US IP (8.8.8.8) to "US"
+//   - the rule file routes a known CN domain (baidu.com) and CN IP (114.114.114.114)
+//     DIRECT, and a known US IP to the rule file's fallback
+//   - porn detection flags a porn sample (Antiporn only)
+//
+// Rule checks query the rule file directly, so TmpRules, hosts entries, policies and
+// global mode don't affect them. Components that are configured but have no database
+// loaded yet are reported as discrepancies. SelfTest returns ctx.Err() when ctx is
+// done before all checks ran.
+//
+// Example:
+//
+//	if err := k2rule.SelfTest(ctx); err != nil {
+//	    log.Printf("policy engine unhealthy: %v", err)
+//	}
+func SelfTest(ctx context.Context) error {
+	defaultEngine.mu.RLock()
+	config := defaultEngine.config
+	manager := defaultEngine.manager
+	geoIPMgr := defaultEngine.geoIPMgr
+	pornManager := defaultEngine.pornManager
+	defaultEngine.mu.RUnlock()
+
+	if config == nil {
+		return fmt.Errorf("self-test: not initialized")
+	}
+
+	checks := []selfTestCheck{{"LAN IP", func() string {
+		if got := defaultEngine.query("192.168.1.1").Target; got != TargetDirect {
+			return fmt.Sprintf("192.168.1.1 → %s, want DIRECT", got)
+		}
+		return ""
+	}}}
+
+	geoIPLoaded := geoIPMgr != nil && geoIPMgr.Status().Loaded
+	if geoIPMgr != nil {
+		checks = append(checks, selfTestCheck{"GeoIP", func() string {
+			if !geoIPLoaded {
+				return "database not loaded"
+			}
+			country, err := geoIPMgr.LookupCountry(net.ParseIP("8.8.8.8"))
+			if countries, trimmed := geoIPMgr.trimmedCountries(); trimmed && !containsCountry(countries, "US") {
+				// Database trimmed to other countries (k2rule-gen generate-geoip)
+				if err == nil {
+					return fmt.Sprintf("8.8.8.8 → %q, want no record (database trimmed to %s)", country, strings.Join(countries, ","))
+				}
+				return ""
+			}
+			if err != nil {
+				return fmt.Sprintf("8.8.8.8: %v", err)
+			}
+			if country != "US" {
+				return fmt.Sprintf("8.8.8.8 → %q, want US", country)
+			}
+			return ""
+		}})
+	}
+
+	if manager != nil {
+		ruleCheck := func(input string, want func() Target) func() string {
+			return func() string {
+				if manager.reader.Get() == nil {
+					return "rule file not loaded"
+				}
+				var lookup *GeoIPManager
+				if geoIPLoaded {
+					lookup = geoIPMgr
+				}
+				got := manager.matchInput(input, net.ParseIP(input), lookup).Target
+				if got != want() {
+					return fmt.Sprintf("%s → %s, want %s", input, got, want())
+				}
+				return ""
+			}
+		}
+		direct := func() Target { return TargetDirect }
+		checks = append(checks,
+			selfTestCheck{"CN domain", ruleCheck("baidu.com", direct)},
+			selfTestCheck{"US IP", ruleCheck("8.8.8.8", manager.getFallback)},
+		)
+		if geoIPLoaded {
+			checks = append(checks, selfTestCheck{"CN IP", ruleCheck("114.114.114.114", direct)})
+		}
+	}
+
+	if config.Antiporn {
+		checks = append(checks, selfTestCheck{"porn", func() string {
+			if pornManager != nil && !pornManager.Status().Loaded {
+				return "database not loaded"
+			}
+			if !defaultEngine.isPorn("pornhub.com") {
+				return "pornhub.com not detected"
+			}
+			return ""
+		}})
+	}
+
+	var failures []string
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if problem := check.run(); problem != "" {
+			failures = append(failures, check.name+": "+problem)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("self-test failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// containsCountry reports whether countries contains country
+func containsCountry(countries []string, country string) bool {
+	for _, c := range countries {
+		if c == country {
+			return true
+		}
+	}
+	return false
+}
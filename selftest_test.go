@@ -0,0 +1,148 @@
+package k2rule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/mmdb"
+	"github.com/kaitu-io/k2rule/internal/slice"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// loadSelfTestRules installs a rule manager whose rule file routes domains to target
+// with a PROXY fallback
+func loadSelfTestRules(t *testing.T, domains []string, target Target) {
+	t.Helper()
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	w.AddDomainSlice(domains, uint8(target))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	manager.fallback.Store(uint32(manager.reader.Fallback()))
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+}
+
+func TestSelfTest(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if err := SelfTest(context.Background()); err == nil {
+		t.Error("SelfTest() before Init succeeded")
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{Antiporn: true, IsGlobal: true, GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	loadSelfTestRules(t, []string{"baidu.com", "qq.com"}, TargetDirect)
+	SetTmpRule("baidu.com", TargetReject) // not consulted: rule checks query the rule file
+
+	if err := SelfTest(context.Background()); err != nil {
+		t.Errorf("SelfTest() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SelfTest(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("SelfTest(canceled) error = %v, want context.Canceled", err)
+	}
+}
+
+func TestSelfTest_ReportsDiscrepancies(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	loadSelfTestRules(t, []string{"baidu.com"}, TargetReject)
+
+	err := SelfTest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "CN domain: baidu.com → REJECT, want DIRECT") {
+		t.Errorf("SelfTest() error = %v, want CN domain discrepancy", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = NewRemoteRuleManager("", t.TempDir(), TargetDirect)
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	err = SelfTest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "rule file not loaded") {
+		t.Errorf("SelfTest() error = %v, want rule file not loaded", err)
+	}
+}
+
+func TestSelfTest_TrimmedGeoIP(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// GeoIP database trimmed to CN: 8.8.8.8 has no record
+	world := mmdb.NewWriter("GeoLite2-Country", "")
+	world.Insert(netip.MustParsePrefix("114.114.114.0/24"), map[string]string{"country.iso_code": "CN"})
+	world.Insert(netip.MustParsePrefix("8.8.8.0/24"), map[string]string{"country.iso_code": "US"})
+	var buf bytes.Buffer
+	world.WriteTo(&buf)
+	src, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes failed: %v", err)
+	}
+	trimmed, _, err := mmdb.TrimCountries(src, []string{"CN"})
+	if err != nil {
+		t.Fatalf("TrimCountries failed: %v", err)
+	}
+	buf.Reset()
+	trimmed.WriteTo(&buf)
+	geoPath := filepath.Join(t.TempDir(), "geoip-cn.mmdb")
+	if err := os.WriteFile(geoPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	geoIPMgr := &GeoIPManager{stopCh: make(chan struct{})}
+	if err := geoIPMgr.loadDatabase(geoPath); err != nil {
+		t.Fatalf("loadDatabase failed: %v", err)
+	}
+	defer geoIPMgr.Stop()
+
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	w.AddDomainSlice([]string{"baidu.com"}, uint8(TargetDirect))
+	w.AddGeoIPSlice([]string{"CN"}, uint8(TargetDirect))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	rulePath := filepath.Join(t.TempDir(), "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", t.TempDir(), TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	manager.fallback.Store(uint32(manager.reader.Fallback()))
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.geoIPMgr = geoIPMgr
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if err := SelfTest(context.Background()); err != nil {
+		t.Errorf("SelfTest() with a trimmed GeoIP database error: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package k2rule
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/filelock"
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// maxShadowDiffs is the number of most recent decision diffs kept for GetShadowReport
+const maxShadowDiffs = 256
+
+// ShadowDiff is a Match decision that differs between the active and pending rules
+type ShadowDiff struct {
+	Input   string    `json:"input"`
+	Active  Target    `json:"active"`  // Decision of the active rules (returned to the caller)
+	Pending Target    `json:"pending"` // Decision the pending rules would make
+	Time    time.Time `json:"time"`
+}
+
+// ShadowReport summarizes shadow evaluation of the pending rule file.
+//
+// Shadow mode is enabled with Config.ShadowSampleRate. Rule updates downloaded in
+// shadow mode are staged as "pending" instead of activated; a sampled fraction of
+// Match calls is evaluated against both rule files so decision changes can be
+// reviewed before calling PromotePendingRules.
+type ShadowReport struct {
+	Pending          bool         `json:"pending"`            // A pending rule file is staged
+	PendingBuildTime time.Time    `json:"pending_build_time"` // Build time of the pending rule file
+	Evaluated        uint64       `json:"evaluated"`          // Sampled Match calls evaluated against pending rules
+	Differed         uint64       `json:"differed"`           // Sampled calls whose decision would change
+	Diffs            []ShadowDiff `json:"diffs"`              // Most recent diffs, oldest first
+}
+
+// GetShadowReport returns the shadow evaluation results for the pending rule file.
+// Returns an empty report if rules are not loaded from a URL.
+func GetShadowReport() ShadowReport {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil {
+		return ShadowReport{}
+	}
+	return manager.shadowReport()
+}
+
+// PromotePendingRules activates the pending rule file (atomic hot-swap).
+// Returns an error if no rule file is pending.
+func PromotePendingRules() error {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil {
+		return fmt.Errorf("rules not initialized")
+	}
+	return manager.promotePending()
+}
+
+// DiscardPendingRules drops the pending rule file; the active rules are unchanged.
+// The same upstream file is not downloaded again until it changes (ETag).
+func DiscardPendingRules() error {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+
+	if manager == nil {
+		return fmt.Errorf("rules not initialized")
+	}
+	return manager.discardPending()
+}
+
+// ruleReader is implemented by both the active (slice.CachedMmapReader)
+// and the pending (slice.MmapReader) rule readers
+type ruleReader interface {
+	MatchDomainHints(domain string) (uint8, slice.DNSHint, bool)
+	MatchIP(ip net.IP) *uint8
+	MatchGeoIP(country string) *uint8
+}
+
+// matchRules matches an IP (when ip != nil) or a domain against static rules:
+// IP-CIDR → GeoIP → fallback for IPs, domain → fallback for domains.
+// A rule returning the fallback target does not stop the IP lookup chain, but the
+// result still counts as explicit (Fallback is false).
+// Country is the GeoIP country resolved along the way ("" if no lookup was needed).
+func matchRules(r ruleReader, fallback Target, domain string, ip net.IP, geoIPMgr *GeoIPManager) MatchResult {
+	if ip != nil {
+		var matched ReasonCode // Rule that matched with the fallback target ("" = none)
+		if t := r.MatchIP(ip); t != nil {
+			if Target(*t) != fallback {
+				return MatchResult{Target: Target(*t), Reason: ReasonRule}
+			}
+			matched = ReasonRule
+		}
+		var country string
+		if geoIPMgr != nil {
+			if c, err := geoIPMgr.LookupCountry(ip); err == nil {
+				country = c
+				if t := r.MatchGeoIP(country); t != nil {
+					if Target(*t) != fallback {
+						return MatchResult{Target: Target(*t), Reason: ReasonGeo, Country: country}
+					}
+					if matched == "" {
+						matched = ReasonGeo
+					}
+				}
+			}
+		}
+		if matched != "" {
+			return MatchResult{Target: fallback, Reason: matched, Country: country}
+		}
+		return MatchResult{Target: fallback, Reason: ReasonFallback, Country: country, Fallback: true}
+	}
+
+	t, hints, ok := r.MatchDomainHints(domain)
+	return domainRuleResult(t, hints, ok, fallback)
+}
+
+// domainRuleResult builds the result of a domain rule lookup (ok = a rule matched)
+func domainRuleResult(target uint8, hints slice.DNSHint, ok bool, fallback Target) MatchResult {
+	if ok {
+		return MatchResult{Target: Target(target), Reason: ReasonRule, DNS: DNSHint(hints)}
+	}
+	return MatchResult{Target: fallback, Reason: ReasonFallback, Fallback: true}
+}
+
+// shadowLog records decision diffs between active and pending rules
+type shadowLog struct {
+	evaluated atomic.Uint64
+	differed  atomic.Uint64
+
+	mu    sync.Mutex
+	diffs []ShadowDiff // ring buffer (maxShadowDiffs)
+	next  int          // next write position once full
+}
+
+// record counts one evaluation and stores it if the decisions differ
+func (l *shadowLog) record(input string, active, pending Target) {
+	l.evaluated.Add(1)
+	if active == pending {
+		return
+	}
+	l.differed.Add(1)
+
+	diff := ShadowDiff{Input: input, Active: active, Pending: pending, Time: time.Now()}
+	l.mu.Lock()
+	if len(l.diffs) < maxShadowDiffs {
+		l.diffs = append(l.diffs, diff)
+	} else {
+		l.diffs[l.next] = diff
+		l.next = (l.next + 1) % maxShadowDiffs
+	}
+	l.mu.Unlock()
+}
+
+// snapshot returns the recorded diffs, oldest first
+func (l *shadowLog) snapshot() []ShadowDiff {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]ShadowDiff, 0, len(l.diffs))
+	out = append(out, l.diffs[l.next:]...)
+	out = append(out, l.diffs[:l.next]...)
+	return out
+}
+
+// reset clears all recorded results (new pending file or promotion)
+func (l *shadowLog) reset() {
+	l.mu.Lock()
+	l.diffs = nil
+	l.next = 0
+	l.evaluated.Store(0)
+	l.differed.Store(0)
+	l.mu.Unlock()
+}
+
+// shadowEnabled reports whether downloaded updates are staged as pending
+func (m *RemoteRuleManager) shadowEnabled() bool {
+	return m.shadowRate > 0
+}
+
+// shadowEvaluate evaluates a sampled Match call against the pending rules
+func (m *RemoteRuleManager) shadowEvaluate(input string, ip net.IP, geoIPMgr *GeoIPManager, active Target) {
+	if !m.shadowEnabled() {
+		return
+	}
+	pending := m.pending.Load()
+	if pending == nil || rand.Float64() >= m.shadowRate {
+		return
+	}
+	pendingResult := matchRules(pending, Target(pending.Fallback()), input, ip, geoIPMgr)
+	m.shadow.record(input, active, pendingResult.Target)
+}
+
+// stagePending loads a downloaded rule file as the pending rules
+func (m *RemoteRuleManager) stagePending(path string) error {
+	reader, err := slice.OpenGzip(path, m.sliceMode())
+	if err != nil {
+		return fmt.Errorf("failed to load pending rules: %w", err)
+	}
+	m.swapPending(reader)
+	m.shadow.reset()
+	m.logger().Info("rules downloaded and staged as pending")
+	m.startCanary(reader)
+	return nil
+}
+
+// swapPending replaces the pending reader, closing the old one after a grace period
+func (m *RemoteRuleManager) swapPending(reader *slice.MmapReader) {
+	old := m.pending.Swap(reader)
+	if old != nil {
+		safeGo("rules", func() {
+			time.Sleep(5 * time.Second) // concurrent shadowEvaluate() calls may still hold it
+			old.Close()
+		})
+	}
+}
+
+// promotePending activates the pending rule file
+func (m *RemoteRuleManager) promotePending() error {
+	if m.pending.Load() == nil {
+		return fmt.Errorf("no pending rules")
+	}
+
+	pendingPath := m.getPendingPath()
+	cachePath := m.getCachePath()
+	lock, err := filelock.Acquire(cachePath + ".lock")
+	if err != nil {
+		return err
+	}
+	err = os.Rename(pendingPath, cachePath)
+	lock.Release()
+	if err != nil {
+		return fmt.Errorf("failed to promote pending rules: %w", err)
+	}
+	if err := m.reader.Load(cachePath); err != nil {
+		return fmt.Errorf("failed to load promoted rules: %w", err)
+	}
+	m.fallback.Store(uint32(m.reader.Fallback()))
+
+	m.mu.Lock()
+	m.lastUpdate = time.Now()
+	m.mu.Unlock()
+
+	m.swapPending(nil)
+	m.shadow.reset()
+	m.logger().Info("pending rules promoted")
+	publishReload(m)
+	return nil
+}
+
+// discardPending drops the pending rule file
+func (m *RemoteRuleManager) discardPending() error {
+	if m.pending.Load() == nil {
+		return fmt.Errorf("no pending rules")
+	}
+	m.swapPending(nil)
+	m.shadow.reset()
+	if err := os.Remove(m.getPendingPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove pending rules: %w", err)
+	}
+	return nil
+}
+
+// shadowReport builds a ShadowReport for this manager
+func (m *RemoteRuleManager) shadowReport() ShadowReport {
+	report := ShadowReport{
+		Evaluated: m.shadow.evaluated.Load(),
+		Differed:  m.shadow.differed.Load(),
+		Diffs:     m.shadow.snapshot(),
+	}
+	if pending := m.pending.Load(); pending != nil {
+		report.Pending = true
+		report.PendingBuildTime = pending.Timestamp()
+	}
+	return report
+}
@@ -0,0 +1,127 @@
+package k2rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShadowEvaluation_PendingRules(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	body := gzipBytes(t, buildTestPornK2R(t, []string{"old.com"}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	manager.shadowRate = 1
+	defer manager.Close()
+
+	// First download activates directly (nothing to compare against)
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if manager.pending.Load() != nil {
+		t.Fatal("first download should not be staged as pending")
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	// Second download is staged
+	body = gzipBytes(t, buildTestPornK2R(t, []string{"new.com"}))
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if report := GetShadowReport(); !report.Pending {
+		t.Fatal("expected pending rules after second download")
+	}
+
+	if got := Match("old.com"); got != TargetReject {
+		t.Errorf("Match(old.com) = %v, want REJECT from active rules", got)
+	}
+	if got := Match("new.com"); got != TargetDirect {
+		t.Errorf("Match(new.com) = %v, want DIRECT from active rules", got)
+	}
+	Match("same.com")
+
+	report := GetShadowReport()
+	if report.Evaluated != 3 || report.Differed != 2 {
+		t.Errorf("Evaluated/Differed = %d/%d, want 3/2", report.Evaluated, report.Differed)
+	}
+	if len(report.Diffs) != 2 {
+		t.Fatalf("len(Diffs) = %d, want 2", len(report.Diffs))
+	}
+	if d := report.Diffs[1]; d.Input != "new.com" || d.Active != TargetDirect || d.Pending != TargetReject {
+		t.Errorf("Diffs[1] = %+v, want new.com DIRECT → REJECT", d)
+	}
+
+	if err := PromotePendingRules(); err != nil {
+		t.Fatalf("PromotePendingRules() failed: %v", err)
+	}
+	if got := Match("new.com"); got != TargetReject {
+		t.Errorf("Match(new.com) after promote = %v, want REJECT", got)
+	}
+	if report := GetShadowReport(); report.Pending || report.Evaluated != 0 {
+		t.Errorf("report after promote = %+v, want empty", report)
+	}
+	if err := PromotePendingRules(); err == nil {
+		t.Error("PromotePendingRules() without pending rules succeeded")
+	}
+}
+
+func TestShadowEvaluation_Discard(t *testing.T) {
+	body := gzipBytes(t, buildTestPornK2R(t, []string{"old.com"}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	manager.shadowRate = 1
+	defer manager.Close()
+
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	body = gzipBytes(t, buildTestPornK2R(t, []string{"new.com"}))
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+
+	if err := manager.discardPending(); err != nil {
+		t.Fatalf("discardPending() failed: %v", err)
+	}
+	if manager.pending.Load() != nil {
+		t.Error("pending rules still set after discard")
+	}
+	if got := manager.matchInput("new.com", nil, nil).Target; got != TargetDirect {
+		t.Errorf("active rules changed after discard: new.com = %v", got)
+	}
+}
+
+func TestShadowLog_RingBuffer(t *testing.T) {
+	var l shadowLog
+	for i := 0; i < maxShadowDiffs+10; i++ {
+		l.record(string(rune('a'+i%26)), TargetDirect, TargetProxy)
+	}
+	l.record("same", TargetProxy, TargetProxy)
+
+	if got := l.evaluated.Load(); got != maxShadowDiffs+11 {
+		t.Errorf("evaluated = %d, want %d", got, maxShadowDiffs+11)
+	}
+	diffs := l.snapshot()
+	if len(diffs) != maxShadowDiffs {
+		t.Fatalf("len(diffs) = %d, want %d", len(diffs), maxShadowDiffs)
+	}
+	// Oldest kept entry is #10 → 'k'
+	if diffs[0].Input != "k" {
+		t.Errorf("oldest diff = %q, want k", diffs[0].Input)
+	}
+}
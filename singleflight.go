@@ -0,0 +1,115 @@
+package k2rule
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// initFlight deduplicates concurrent Init/UpdateConfig calls
+var initFlight flightGroup
+
+// flightGroup runs at most one call per key at a time; concurrent callers with the
+// same key wait for the running call and share its error (a minimal singleflight).
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an in-progress or completed call
+type flightCall struct {
+	done chan struct{}
+	err  error
+}
+
+// do runs fn unless a call with the same key is in progress, in which case it waits
+// for that call and returns its error
+func (g *flightGroup) do(key string, fn func() error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.err = fn()
+	return c.err
+}
+
+// configKey identifies a configuration for initFlight by its JSON encoding.
+// Callbacks (OnReject, OnPanic, URLExpander, BearerToken, ...) cannot be compared,
+// so configurations holding any have no key (ok = false): concurrent callers only
+// share a call when they pass the same *Config, never install another caller's callbacks.
+func configKey(config *Config) (key string, ok bool) {
+	if hasCallbacks(reflect.ValueOf(config)) {
+		return "", false
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// hasCallbacks reports whether v holds a callback: a non-nil func or interface field
+// excluded from the JSON encoding
+func hasCallbacks(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && hasCallbacks(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field, f := t.Field(i), v.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Tag.Get("json") == "-" && (f.Kind() == reflect.Func || f.Kind() == reflect.Interface) && !f.IsNil() {
+				return true
+			}
+			if hasCallbacks(f) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if hasCallbacks(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		for it := v.MapRange(); it.Next(); {
+			if hasCallbacks(it.Value()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// initShared runs initialize(config) once for all concurrent callers passing the same
+// *Config, or an identical configuration without callbacks, and returns the shared result.
+func initShared(config *Config, initialize func(*Config) error) error {
+	// Same pointer first: the running call may be applying defaults to it
+	return initFlight.do(fmt.Sprintf("%p", config), func() error {
+		key, ok := configKey(config)
+		if !ok {
+			return initialize(config)
+		}
+		return initFlight.do(key, func() error { return initialize(config) })
+	})
+}
@@ -0,0 +1,135 @@
+package k2rule
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup_SharesResult(t *testing.T) {
+	var g flightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	wantErr := errors.New("boom")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = g.do("key", func() error {
+				calls.Add(1)
+				<-release
+				return wantErr
+			})
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn called %d times, want 1", n)
+	}
+	for i, err := range errs {
+		if err != wantErr {
+			t.Errorf("caller %d got %v, want %v", i, err, wantErr)
+		}
+	}
+
+	// Completed calls are not cached
+	if err := g.do("key", func() error { return nil }); err != nil {
+		t.Errorf("do() after completion = %v, want nil", err)
+	}
+}
+
+func TestRemoteRuleManager_ConcurrentUpdateSharesDownload(t *testing.T) {
+	body := gzipBytes(t, buildTestPornK2R(t, []string{"example.com"}))
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(100 * time.Millisecond) // keep the download in flight
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := manager.Update(); err != nil {
+				t.Errorf("Update() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+	if got := manager.matchDomain("example.com"); got != TargetReject {
+		t.Errorf("matchDomain(example.com) = %v, want REJECT", got)
+	}
+}
+
+func TestConfigKey(t *testing.T) {
+	a, ok := configKey(&Config{RuleURL: "https://example.com/a.k2r.gz"})
+	if !ok {
+		t.Fatal("configKey failed")
+	}
+	b, _ := configKey(&Config{RuleURL: "https://example.com/a.k2r.gz"})
+	c, _ := configKey(&Config{RuleURL: "https://example.com/b.k2r.gz"})
+	if a != b {
+		t.Error("identical configs have different keys")
+	}
+	if a == c {
+		t.Error("different configs have the same key")
+	}
+
+	// Callbacks cannot be compared: such configs are never coalesced by content
+	for name, config := range map[string]*Config{
+		"OnReject":    {OnReject: func(string, MatchResult) {}},
+		"BearerToken": {RuleAuth: &SourceAuth{BearerToken: func() (string, error) { return "", nil }}},
+		"HitStats":    {HitStats: &HitStatsConfig{OnExport: func(HitReport) {}}},
+	} {
+		if _, ok := configKey(config); ok {
+			t.Errorf("config with %s has a key", name)
+		}
+	}
+}
+
+func TestInitShared_DifferentCallbacksNotCoalesced(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	initialize := func(*Config) error {
+		calls.Add(1)
+		<-release
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			initShared(&Config{OnReject: func(string, MatchResult) {}}, initialize)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("initialize called %d times, want 2 (one per callback set)", n)
+	}
+}
@@ -0,0 +1,70 @@
+package k2rule
+
+// engineState is a snapshot of the engine state read by Match. Writers publish a
+// new snapshot with publishState whenever they change it (Init, ToggleGlobal,
+// SetGlobalTarget), so the hot path reads it without taking Engine.mu.
+type engineState struct {
+	config      *Config // Copy of Engine.config (nil before Init); never modified
+	manager     *RemoteRuleManager
+	geoIPMgr    *GeoIPManager
+	pornManager *PornRemoteManager
+	matcher     *Matcher
+	sources     *sourceDomains
+}
+
+// publishState publishes the current state of e (e.mu held)
+func (e *Engine) publishState() {
+	state := &engineState{
+		manager:     e.manager,
+		geoIPMgr:    e.geoIPMgr,
+		pornManager: e.pornManager,
+		matcher:     e.matcher,
+		sources:     e.sources,
+	}
+	if e.config != nil {
+		config := *e.config
+		state.config = &config
+	}
+	e.state.Store(state)
+}
+
+// publishSources replaces the source domains of e, publishing them before the rest
+// of a new state so that Init routes its own downloads DIRECT (e.mu held)
+func (e *Engine) publishSources(sources *sourceDomains) {
+	e.sources = sources
+	state := *e.loadState()
+	state.sources = sources
+	e.state.Store(&state)
+}
+
+// loadState returns the published state of e (lock-free)
+func (e *Engine) loadState() *engineState {
+	if state := e.state.Load(); state != nil {
+		return state
+	}
+	return &engineState{}
+}
+
+// ruleDomainTarget returns the target of the rule file's domain rule for domain (or a
+// parent domain), without the fallback; ok = false when no domain rule matches
+func (s *engineState) ruleDomainTarget(domain string) (Target, bool) {
+	var target *uint8
+	switch {
+	case s.manager != nil:
+		target = s.manager.reader.MatchDomain(domain)
+	case s.matcher != nil && s.matcher.reader != nil:
+		target = s.matcher.reader.MatchDomain(domain)
+	}
+	if target == nil {
+		return 0, false
+	}
+	return Target(*target), true
+}
+
+// ruleGeneration returns the generation of the loaded rule data (0 = none)
+func (s *engineState) ruleGeneration() uint64 {
+	if s.manager == nil {
+		return 0
+	}
+	return s.manager.reader.Generation()
+}
@@ -0,0 +1,83 @@
+package k2rule
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatch_DoesNotTakeGlobalMutex(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{IsGlobal: true, GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	// A writer holding defaultEngine.mu (e.g. a slow Init) must not block Match
+	defaultEngine.mu.Lock()
+	done := make(chan Target, 1)
+	go func() { done <- Match("example.com") }()
+	select {
+	case got := <-done:
+		if got != TargetProxy {
+			t.Errorf("Match() = %s, want PROXY", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Match() blocked on defaultEngine.mu")
+	}
+	defaultEngine.mu.Unlock()
+}
+
+func TestToggleGlobal_PublishesState(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if got := Match("example.com"); got != TargetProxy {
+		t.Fatalf("Match() without rules = %s, want PROXY (GlobalTarget fallback)", got)
+	}
+	ToggleGlobal(true)
+	SetGlobalTarget(TargetReject)
+	if got := Match("example.com"); got != TargetReject {
+		t.Errorf("Match() after SetGlobalTarget(REJECT) = %s, want REJECT", got)
+	}
+	ToggleGlobal(false)
+	if got := defaultEngine.loadState().config; got == nil || got.IsGlobal || got.GlobalTarget != TargetReject {
+		t.Errorf("published config = %+v, want IsGlobal=false, GlobalTarget=REJECT", got)
+	}
+}
+
+// TestMatch_ConcurrentToggle exercises Match against ToggleGlobal (run with -race)
+func TestMatch_ConcurrentToggle(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if got := Match("example.com"); got != TargetProxy {
+					t.Errorf("Match() = %s, want PROXY", got)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		ToggleGlobal(j%2 == 0)
+	}
+	wg.Wait()
+}
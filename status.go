@@ -0,0 +1,153 @@
+package k2rule
+
+import (
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// Component names reported by ComponentStatus
+const (
+	ComponentRules        = "rules"
+	ComponentGeoIP        = "geoip"
+	ComponentPorn         = "porn"
+	ComponentBundle       = "bundle"
+	ComponentManifest     = "manifest"
+	ComponentSubscription = "subscription"
+)
+
+// ComponentInfo is a point-in-time snapshot of one data component (rules, GeoIP, porn).
+// Monitoring can alert on stale data by checking LastUpdate/BuildTime and LastError.
+type ComponentInfo struct {
+	Name       string    `json:"name"`                 // Component name (ComponentRules, ComponentGeoIP, ComponentPorn, ComponentBundle, ComponentManifest, ComponentSubscription)
+	Source     string    `json:"source"`               // Remote URL or local file path
+	Loaded     bool      `json:"loaded"`               // true once a database is available for lookups
+	Generation uint64    `json:"generation"`           // Number of successful loads (increments on every hot-reload)
+	LastUpdate time.Time `json:"last_update"`          // Last successful download (zero if only loaded from cache/file)
+	BuildTime  time.Time `json:"build_time"`           // Database build timestamp (K2RULEV3 header or mmdb build epoch)
+	LastError  string    `json:"last_error,omitempty"` // Error of the most recent download attempt ("" after success)
+	Size       int64     `json:"size"`                 // Size of the loaded (uncompressed) database in bytes
+	ReadOnly   bool      `json:"read_only,omitempty"`  // Cache is read-only: updates are skipped (Config.ReadOnlyCache)
+
+	// Database metadata, available as soon as the component is loaded (e.g. right after
+	// Init from cache), so dashboards can check the magnitude of the data
+	Entries      int    `json:"entries,omitempty"`       // Domain rules (rules, porn) or search tree nodes (GeoIP)
+	DatabaseType string `json:"database_type,omitempty"` // GeoIP database type, e.g. "GeoLite2-Country"
+	Version      string `json:"version,omitempty"`       // Applied porn patch version (PornPatchURL; "" without patch)
+}
+
+// ComponentStatus returns the status of every initialized component.
+// Components that are not configured (e.g. porn when Antiporn=false) are omitted.
+//
+// Example:
+//
+//	for _, c := range k2rule.ComponentStatus() {
+//	    if time.Since(c.BuildTime) > 7*24*time.Hour {
+//	        log.Printf("%s database is stale (built %s)", c.Name, c.BuildTime)
+//	    }
+//	}
+func ComponentStatus() []ComponentInfo {
+	var infos []ComponentInfo
+	for _, c := range []Component{RuleComponent(), GeoIPComponent(), PornComponent()} {
+		if c != nil {
+			infos = append(infos, c.Status())
+		}
+	}
+
+	defaultEngine.mu.RLock()
+	bundle := defaultEngine.bundleMgr
+	manifest := defaultEngine.manifestMgr
+	subscription := defaultEngine.subscriptionMgr
+	defaultEngine.mu.RUnlock()
+
+	if bundle != nil {
+		infos = append(infos, bundle.Status())
+	}
+	if manifest != nil {
+		infos = append(infos, manifest.Status())
+	}
+	if subscription != nil {
+		infos = append(infos, subscription.Status())
+	}
+
+	return infos
+}
+
+// readerStatus builds a ComponentInfo from a CachedMmapReader-backed component
+func readerStatus(name, source string, reader *slice.CachedMmapReader) ComponentInfo {
+	info := ComponentInfo{
+		Name:       name,
+		Source:     source,
+		Loaded:     reader.Get() != nil,
+		Generation: reader.Generation(),
+		Size:       reader.Size(),
+	}
+	if info.Loaded {
+		info.BuildTime = reader.Timestamp()
+		info.Entries = reader.DomainCount()
+	}
+	return info
+}
+
+// errorString returns err.Error(), or "" for a nil error
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// HealthStatus summarizes whether routing decisions are based on complete, current data
+type HealthStatus struct {
+	Degraded    bool     `json:"degraded"`           // Some component has no data or its last update failed
+	RulesLoaded bool     `json:"rules_loaded"`       // Rule data is available for lookups
+	FailClosed  bool     `json:"fail_closed"`        // Match currently rejects traffic (Config.FailClosed, no rule data)
+	Problems    []string `json:"problems,omitempty"` // Human-readable reasons, e.g. "rules: not loaded"
+
+	UpdatesPaused bool           `json:"updates_paused,omitempty"` // PauseUpdates is in effect (not a problem by itself)
+	Schedules     []ScheduleInfo `json:"schedules,omitempty"`      // Background schedules and their next runs (see Schedules)
+}
+
+// Health reports whether the engine is degraded: a configured component without
+// loaded data, or whose most recent update failed.
+//
+// Example:
+//
+//	if h := k2rule.Health(); h.Degraded {
+//	    log.Printf("routing degraded: %v", h.Problems)
+//	}
+func Health() HealthStatus {
+	defaultEngine.mu.RLock()
+	config := defaultEngine.config
+	manager := defaultEngine.manager
+	matcher := defaultEngine.matcher
+	defaultEngine.mu.RUnlock()
+
+	health := HealthStatus{
+		RulesLoaded:   rulesLoaded(manager, matcher),
+		UpdatesPaused: updatesPaused(),
+		Schedules:     Schedules(),
+	}
+	if config == nil {
+		health.Degraded = true
+		health.Problems = []string{"not initialized"}
+		return health
+	}
+	health.FailClosed = !config.IsGlobal && failClosed(config, manager, matcher)
+	if !config.IsGlobal && manager == nil && !health.RulesLoaded {
+		health.Problems = append(health.Problems, ComponentRules+": not loaded")
+	}
+
+	for _, info := range ComponentStatus() {
+		switch {
+		case !info.Loaded && info.LastError != "":
+			health.Problems = append(health.Problems, info.Name+": not loaded: "+info.LastError)
+		case !info.Loaded:
+			health.Problems = append(health.Problems, info.Name+": not loaded")
+		case info.LastError != "":
+			health.Problems = append(health.Problems, info.Name+": last update failed: "+info.LastError)
+		}
+	}
+	health.Degraded = len(health.Problems) > 0
+	return health
+}
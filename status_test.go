@@ -0,0 +1,191 @@
+package k2rule
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComponentStatus_NothingInitialized(t *testing.T) {
+	resetGlobalState()
+
+	if infos := ComponentStatus(); len(infos) != 0 {
+		t.Errorf("ComponentStatus() = %v, want empty", infos)
+	}
+}
+
+func TestComponentStatus_RuleFile(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, RuleFile: rulePath}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	infos := ComponentStatus()
+	if len(infos) != 1 {
+		t.Fatalf("ComponentStatus() returned %d entries, want 1", len(infos))
+	}
+
+	info := infos[0]
+	if info.Name != ComponentRules {
+		t.Errorf("Name = %q, want %q", info.Name, ComponentRules)
+	}
+	if info.Source != rulePath {
+		t.Errorf("Source = %q, want %q", info.Source, rulePath)
+	}
+	if !info.Loaded {
+		t.Error("Loaded = false, want true")
+	}
+	if info.Generation != 1 {
+		t.Errorf("Generation = %d, want 1", info.Generation)
+	}
+	if info.BuildTime.IsZero() {
+		t.Error("BuildTime is zero, want header timestamp")
+	}
+	if info.Size == 0 {
+		t.Error("Size = 0, want uncompressed file size")
+	}
+	if info.Entries != 1 {
+		t.Errorf("Entries = %d, want 1 domain rule", info.Entries)
+	}
+}
+
+func TestComponentStatus_GeoIPMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewGeoIPManager("", t.TempDir())
+	if err := m.loadDatabase(path); err != nil {
+		t.Fatalf("loadDatabase failed: %v", err)
+	}
+
+	info := m.Status()
+	if info.DatabaseType != "GeoLite2-Country" || info.Entries == 0 || info.BuildTime.IsZero() {
+		t.Errorf("Status() = %+v, want database type, node count and build time", info)
+	}
+}
+
+func TestRemoteRuleManager_LastError(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(gzipBytes(t, buildTestPornK2R(t, []string{"blocked.com"})))
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetDirect)
+
+	if err := manager.Update(); err == nil {
+		t.Fatal("Update() succeeded, want HTTP error")
+	}
+	if manager.GetLastError() == nil {
+		t.Error("GetLastError() = nil after failed update")
+	}
+	if info := manager.Status(); info.LastError == "" || info.Loaded {
+		t.Errorf("Status() = %+v, want LastError set and Loaded=false", info)
+	}
+
+	status = http.StatusOK
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	info := manager.Status()
+	if info.LastError != "" {
+		t.Errorf("LastError = %q after successful update, want empty", info.LastError)
+	}
+	if !info.Loaded || info.LastUpdate.IsZero() {
+		t.Errorf("Status() = %+v, want Loaded with LastUpdate set", info)
+	}
+}
+
+// gzipBytes gzip-compresses data in memory (for serving K2RULEV3 files over httptest).
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatalf("failed to write gzip: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFailClosedAndHealth(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if h := Health(); !h.Degraded {
+		t.Errorf("Health() before Init = %+v, want degraded", h)
+	}
+
+	// Rules configured but not downloaded yet
+	tmpDir := t.TempDir()
+	manager := NewRemoteRuleManager("http://127.0.0.1:1/rules.k2r.gz", tmpDir, TargetDirect)
+	manager.setLastError(errors.New("connection refused"))
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{CacheDir: tmpDir, GlobalTarget: TargetProxy, FailClosed: true}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	for _, input := range []string{"example.com", "8.8.8.8"} {
+		if r := MatchVerbose(input); r.Target != TargetReject {
+			t.Errorf("MatchVerbose(%s) without rules = %+v, want REJECT", input, r)
+		}
+	}
+	if got := Match("192.168.1.1"); got != TargetDirect {
+		t.Errorf("Match(LAN) with FailClosed = %v, want DIRECT", got)
+	}
+	h := Health()
+	if !h.Degraded || h.RulesLoaded || !h.FailClosed || len(h.Problems) != 1 || !strings.Contains(h.Problems[0], "connection refused") {
+		t.Errorf("Health() without rules = %+v", h)
+	}
+
+	// Global mode needs no rules
+	ToggleGlobal(true)
+	if got := Match("example.com"); got != TargetProxy {
+		t.Errorf("Match() in global mode with FailClosed = %v, want PROXY", got)
+	}
+	if h := Health(); h.FailClosed {
+		t.Errorf("Health() in global mode = %+v, want FailClosed=false", h)
+	}
+	ToggleGlobal(false)
+
+	// Rules loaded: normal routing, healthy
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	manager.setLastError(nil)
+	if got := Match("example.com"); got != TargetDirect {
+		t.Errorf("Match() with rules loaded = %v, want DIRECT", got)
+	}
+	if h := Health(); h.Degraded || !h.RulesLoaded || h.FailClosed {
+		t.Errorf("Health() with rules loaded = %+v, want healthy", h)
+	}
+}
@@ -0,0 +1,46 @@
+package k2rule
+
+import (
+	"time"
+
+	"github.com/kaitu-io/k2rule/internal/cache"
+)
+
+// stickyCache pins rule decisions per domain (Config.StickyTTL), so a rule hot-reload
+// doesn't flip the target of a domain with long-lived connections. It maps
+// normalized domain → pinned decision; expiry is refreshed on every access.
+// Its size and eviction policy are set by Config.Caches[CacheSticky].
+type stickyCache struct {
+	entries cache.Cache[string, MatchResult]
+}
+
+// Unstick removes the pinned decision of a domain; the next Match uses the current rules.
+func Unstick(domain string) {
+	defaultEngine.Unstick(domain)
+}
+
+// UnstickAll removes all pinned decisions.
+func UnstickAll() {
+	defaultEngine.UnstickAll()
+}
+
+// get returns the pinned decision of domain and refreshes its expiry (ttl <= 0 = disabled)
+func (c *stickyCache) get(domain string, ttl time.Duration) (MatchResult, bool) {
+	if ttl <= 0 {
+		return MatchResult{}, false
+	}
+	return c.entries.Get(normalizeOverlayDomain(domain), ttl)
+}
+
+// put pins the decision of domain for ttl (ttl <= 0 = disabled)
+func (c *stickyCache) put(domain string, result MatchResult, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.entries.Put(normalizeOverlayDomain(domain), result, ttl)
+}
+
+// delete removes the pinned decision of domain
+func (c *stickyCache) delete(domain string) {
+	c.entries.Delete(normalizeOverlayDomain(domain))
+}
@@ -0,0 +1,137 @@
+package k2rule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStickyTTL_PinsDecisionAcrossReload(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer UnstickAll()
+
+	tmpDir := t.TempDir()
+	v1 := filepath.Join(tmpDir, "v1.k2r.gz")
+	v2 := filepath.Join(tmpDir, "v2.k2r.gz")
+	writeTestK2RGzipFile(t, v1, buildTestPornK2R(t, []string{"flip.com"}))
+	writeTestK2RGzipFile(t, v2, buildTestPornK2R(t, []string{"other.com"}))
+
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(v1); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{StickyTTL: Duration(time.Hour)}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	if got := Match("flip.com"); got != TargetReject {
+		t.Fatalf("Match(flip.com) = %v, want REJECT", got)
+	}
+
+	// Hot-reload: flip.com no longer blocked, but the decision stays pinned
+	if err := manager.reader.Load(v2); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := Match("flip.com"); got != TargetReject {
+		t.Errorf("Match(flip.com) after reload = %v, want pinned REJECT", got)
+	}
+
+	// TmpRule still overrides a pinned decision
+	SetTmpRule("flip.com", TargetProxy)
+	if got := Match("flip.com"); got != TargetProxy {
+		t.Errorf("Match(flip.com) with TmpRule = %v, want PROXY", got)
+	}
+	ClearTmpRule("flip.com")
+
+	Unstick("flip.com")
+	if got := Match("flip.com"); got != TargetDirect {
+		t.Errorf("Match(flip.com) after Unstick = %v, want DIRECT", got)
+	}
+}
+
+func TestStickyCache_Expiry(t *testing.T) {
+	var c stickyCache
+
+	c.put("a.com", MatchResult{Target: TargetProxy}, 0)
+	if _, ok := c.get("a.com", time.Hour); ok {
+		t.Error("put with ttl=0 should not pin")
+	}
+
+	c.put("a.com", MatchResult{Target: TargetProxy}, 20*time.Millisecond)
+	if got, ok := c.get("a.com", 20*time.Millisecond); !ok || got.Target != TargetProxy {
+		t.Errorf("get = (%v, %v), want (PROXY, true)", got, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.get("a.com", 20*time.Millisecond); ok {
+		t.Error("entry not expired after ttl")
+	}
+}
+
+func TestStickyCache_Eviction(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+	defer UnstickAll()
+
+	defaultEngine.mu.Lock()
+	configureCaches(map[string]CacheConfig{CacheSticky: {Policy: CachePolicyLRU, MaxEntries: 2}})
+	defaultEngine.mu.Unlock()
+
+	c := &defaultEngine.sticky
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		c.put(domain, MatchResult{Target: TargetProxy}, time.Hour)
+	}
+	if _, ok := c.get("a.com", time.Hour); ok {
+		t.Error("least recently used entry kept beyond MaxEntries")
+	}
+	if _, ok := c.get("c.com", time.Hour); !ok {
+		t.Error("newest entry evicted")
+	}
+}
+
+func TestEngine_UnstickNormalizesDomain(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	tmpDir := t.TempDir()
+	v1 := filepath.Join(tmpDir, "v1.k2r.gz")
+	v2 := filepath.Join(tmpDir, "v2.k2r.gz")
+	writeTestK2RGzipFile(t, v1, buildTestPornK2R(t, []string{"flip.com"}))
+	writeTestK2RGzipFile(t, v2, buildTestPornK2R(t, []string{"other.com"}))
+	mmdbPath := filepath.Join(tmpDir, "geo.mmdb")
+	if err := os.WriteFile(mmdbPath, buildTestMMDB(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEngine(&Config{RuleFile: v1, GeoIPFile: mmdbPath, CacheDir: tmpDir, StickyTTL: Duration(time.Hour)})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	defer e.Close()
+
+	if got := e.Match("Flip.com"); got != TargetReject {
+		t.Fatalf("e.Match(Flip.com) = %v, want REJECT", got)
+	}
+	if err := e.manager.reader.Load(v2); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := e.Match("flip.com"); got != TargetReject {
+		t.Errorf("e.Match(flip.com) after reload = %v, want REJECT pinned by Flip.com", got)
+	}
+
+	e.Unstick("FLIP.COM")
+	if got := e.Match("Flip.com"); got != TargetDirect {
+		t.Errorf("e.Match(Flip.com) after Unstick = %v, want DIRECT", got)
+	}
+
+	e.Match("flip.com")
+	e.UnstickAll()
+	if n := e.sticky.entries.Len(); n != 0 {
+		t.Errorf("%d pinned decisions after UnstickAll, want 0", n)
+	}
+}
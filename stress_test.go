@@ -0,0 +1,215 @@
+package k2rule
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// Stress tests: Match is hammered from many goroutines while the rule file is
+// updated, the engine is re-initialized and global mode and TmpRules change, and
+// every decision must be one the engine could have made at some instant. Run them
+// under the race detector:
+//
+//	go test -race -run Stress .
+
+// stressRules builds a rule file (PROXY fallback) routing stable.example.com REJECT,
+// plus flip.example.com DIRECT when flip is set
+func stressRules(t *testing.T, flip bool) []byte {
+	t.Helper()
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	w.AddDomainSlice([]string{"stable.example.com"}, uint8(TargetReject))
+	if flip {
+		w.AddDomainSlice([]string{"flip.example.com"}, uint8(TargetDirect))
+	}
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	return data
+}
+
+// stressAllowed lists the decisions each input may get while writers run: rule
+// decisions, the PROXY fallback and global target, and TmpRule DIRECT
+var stressAllowed = map[string][]Target{
+	"192.168.1.1":        {TargetDirect},
+	"stable.example.com": {TargetReject, TargetProxy},
+	"flip.example.com":   {TargetDirect, TargetProxy},
+	"tmp.example.com":    {TargetDirect, TargetProxy},
+}
+
+// stressIterations returns the number of writer iterations (fewer with -short)
+func stressIterations() int {
+	if testing.Short() {
+		return 10
+	}
+	return 50
+}
+
+// hammerMatch runs Match from several goroutines until writers returns, failing on
+// decisions outside stressAllowed
+func hammerMatch(t *testing.T, writers func()) {
+	t.Helper()
+	var stop atomic.Bool
+	var matches atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 2*runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				for input, allowed := range stressAllowed {
+					got := Match(input)
+					if !containsTarget(allowed, got) {
+						t.Errorf("Match(%s) = %s, want one of %v", input, got, allowed)
+						return
+					}
+					if r := MatchVerbose(input); !containsTarget(allowed, r.Target) {
+						t.Errorf("MatchVerbose(%s) = %s, want one of %v", input, r.Target, allowed)
+						return
+					}
+					matches.Add(1)
+				}
+			}
+		}()
+	}
+	writers()
+	stop.Store(true)
+	wg.Wait()
+	if matches.Load() == 0 {
+		t.Error("no Match calls completed")
+	}
+}
+
+// containsTarget reports whether targets contains target
+func containsTarget(targets []Target, target Target) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleWriters runs ToggleGlobal and SetTmpRule/ClearTmpRule n times each, concurrently
+func toggleWriters(wg *sync.WaitGroup, n int) {
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			ToggleGlobal(i%2 == 0)
+		}
+		ToggleGlobal(false)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			SetTmpRule("tmp.example.com", TargetDirect)
+			ClearTmpRule("tmp.example.com")
+		}
+	}()
+}
+
+func TestStress_MatchDuringUpdates(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	bodies := [][]byte{gzipBytes(t, stressRules(t, true)), gzipBytes(t, stressRules(t, false))}
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := served.Add(1)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+		w.Write(bodies[n%2])
+	}))
+	defer server.Close()
+
+	manager := NewRemoteRuleManager(server.URL+"/rules.k2r.gz", t.TempDir(), TargetProxy)
+	if err := manager.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{GlobalTarget: TargetProxy}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	n := stressIterations()
+	hammerMatch(t, func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := manager.Update(); err != nil {
+					t.Errorf("Update() #%d failed: %v", i, err)
+					return
+				}
+			}
+		}()
+		toggleWriters(&wg, n)
+		wg.Wait()
+	})
+
+	if got := manager.GetGeneration(); got < 2 {
+		t.Errorf("GetGeneration() = %d, want reloads during the test", got)
+	}
+	if got := Match("stable.example.com"); got != TargetReject {
+		t.Errorf("Match(stable.example.com) after writers = %s, want REJECT", got)
+	}
+}
+
+func TestStress_MatchDuringReInit(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	// Only local files are loaded; other sources fail fast
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	ruleFiles := []string{filepath.Join(dir, "flip.k2r.gz"), filepath.Join(dir, "plain.k2r.gz")}
+	writeTestK2RGzipFile(t, ruleFiles[0], stressRules(t, true))
+	writeTestK2RGzipFile(t, ruleFiles[1], stressRules(t, false))
+	config := func(i int) *Config {
+		return &Config{
+			RuleFile:     ruleFiles[i%2],
+			GeoIPURL:     server.URL + "/geoip.mmdb.gz",
+			CacheDir:     filepath.Join(dir, "cache"),
+			GlobalTarget: TargetProxy,
+		}
+	}
+	if err := Init(config(0)); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	// Fewer iterations: every Init retires a set of managers
+	n := stressIterations() / 2
+	hammerMatch(t, func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= n; i++ {
+				if err := Init(config(i)); err != nil {
+					t.Errorf("Init() #%d failed: %v", i, err)
+					return
+				}
+			}
+		}()
+		toggleWriters(&wg, n)
+		wg.Wait()
+	})
+
+	if got := Match("stable.example.com"); got != TargetReject {
+		t.Errorf("Match(stable.example.com) after writers = %s, want REJECT", got)
+	}
+}
@@ -0,0 +1,595 @@
+package k2rule
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kaitu-io/k2rule/internal/clash"
+	"github.com/kaitu-io/k2rule/internal/slice"
+	"github.com/kaitu-io/k2rule/internal/statefile"
+)
+
+// Rule source types (SubscriptionSource.Type)
+const (
+	SourceK2R       = "k2r"        // K2RULEV3 rule file (.k2r or .k2r.gz)
+	SourceClashText = "clash-text" // Clash rule provider: one rule per line, or a YAML "payload:" list
+	SourceHosts     = "hosts"      // /etc/hosts-style file
+)
+
+// subscriptionInterval is the default update interval of a subscription source
+const subscriptionInterval = 6 * time.Hour
+
+// stampsSchema versions the persisted source stamps (<hash>.subscription.json); version 1
+// wraps the key → stamp map of version 0 unchanged
+var stampsSchema = statefile.Schema{
+	Name:       "subscription-stamps",
+	Migrations: []statefile.Migration{statefile.Identity},
+	Cache:      true,
+}
+
+// Subscription composes the rules of several sources, each fetched and converted on
+// its own interval, like the rule providers of Clash (Config.Subscription). Sources
+// are evaluated in order: the first source with a matching rule decides, and the
+// composed rules use Fallback when none matches. The descriptor is JSON or YAML
+// (see ParseSubscription):
+//
+//	sources:
+//	  - name: community
+//	    url: https://cdn.example.com/cn_blacklist.k2r.gz
+//	    type: k2r
+//	    interval: 24h
+//	  - name: ads
+//	    url: https://lists.example.com/ads.txt
+//	    type: clash-text
+//	    behavior: domain
+//	    target: REJECT
+//	  - name: corp
+//	    path: /etc/k2rule/corp.hosts
+//	    type: hosts
+//	    target_map: {DIRECT: PROXY}
+//	fallback: PROXY
+type Subscription struct {
+	Sources  []SubscriptionSource `json:"sources"`
+	Fallback *Target              `json:"fallback,omitempty"` // nil = fallback of the first source (PROXY for clash-text and hosts sources)
+}
+
+// SubscriptionSource is one rule source of a Subscription. Converted rules:
+//   - k2r: the rules of the file as they are
+//   - clash-text: every rule of the provider routes to Target (default PROXY)
+//   - hosts: names (and their subdomains) route DIRECT, or REJECT when mapped to
+//     0.0.0.0 or :: (blocklist convention)
+type SubscriptionSource struct {
+	Name      string            `json:"name,omitempty"`       // Label for logs and errors ("" = URL or Path)
+	URL       string            `json:"url,omitempty"`        // Remote source (HTTP(S) or object storage URL)
+	Path      string            `json:"path,omitempty"`       // Local file, re-read when it changes (instead of URL)
+	Type      string            `json:"type"`                 // SourceK2R, SourceClashText or SourceHosts
+	Behavior  string            `json:"behavior,omitempty"`   // clash-text: "domain" (default), "ipcidr" or "classical"
+	Target    *Target           `json:"target,omitempty"`     // clash-text: target of the rules (nil = PROXY)
+	TargetMap map[string]Target `json:"target_map,omitempty"` // Replaces targets of the converted rules by name, e.g. {"DIRECT": "PROXY"}
+	Interval  Duration          `json:"interval,omitempty"`   // Update interval (0 = 6h, or WithInterval)
+	Auth      *SourceAuth       `json:"auth,omitempty"`       // Optional credentials for URL
+}
+
+// label returns the name of the source for logs and errors
+func (s *SubscriptionSource) label() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.URL != "":
+		return redactURL(s.URL)
+	}
+	return s.Path
+}
+
+// ParseSubscription parses a subscription descriptor in JSON or YAML
+func ParseSubscription(data []byte) (*Subscription, error) {
+	// YAML is converted to JSON, so targets and durations decode the same way
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid subscription: %w", err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subscription: %w", err)
+		}
+		data = converted
+	}
+
+	var sub Subscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return nil, fmt.Errorf("invalid subscription: %w", err)
+	}
+	if err := sub.validate(); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// LoadSubscription reads a subscription descriptor file (JSON or YAML)
+func LoadSubscription(path string) (*Subscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSubscription(data)
+}
+
+// validate checks the sources of the subscription
+func (s *Subscription) validate() error {
+	if len(s.Sources) == 0 {
+		return fmt.Errorf("subscription has no sources")
+	}
+	for i := range s.Sources {
+		src := &s.Sources[i]
+		if (src.URL == "") == (src.Path == "") {
+			return fmt.Errorf("subscription source %d: exactly one of url and path is required", i)
+		}
+		switch src.Type {
+		case SourceK2R, SourceHosts:
+		case SourceClashText:
+			switch src.Behavior {
+			case "", "domain", "ipcidr", "classical":
+			default:
+				return fmt.Errorf("subscription source %s: unknown behavior %q", src.label(), src.Behavior)
+			}
+		default:
+			return fmt.Errorf("subscription source %s: unknown type %q", src.label(), src.Type)
+		}
+		if src.Interval < 0 {
+			return fmt.Errorf("subscription source %s: interval cannot be negative", src.label())
+		}
+		if _, err := src.targetMap(); err != nil {
+			return fmt.Errorf("subscription source %s: %w", src.label(), err)
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of s without source credentials and with userinfo in
+// source URLs redacted (nil for a nil s)
+func (s *Subscription) redacted() *Subscription {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Sources = append([]SubscriptionSource(nil), s.Sources...)
+	for i := range c.Sources {
+		c.Sources[i].URL = redactURL(c.Sources[i].URL)
+		c.Sources[i].Auth = nil
+	}
+	return &c
+}
+
+// targetMap returns TargetMap by target value
+func (s *SubscriptionSource) targetMap() (map[uint8]uint8, error) {
+	if len(s.TargetMap) == 0 {
+		return nil, nil
+	}
+	m := make(map[uint8]uint8, len(s.TargetMap))
+	for name, to := range s.TargetMap {
+		from, err := ParseTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		m[uint8(from)] = uint8(to)
+	}
+	return m, nil
+}
+
+// key identifies the converted rules of the source in the cache directory
+func (s *SubscriptionSource) key() string {
+	var target string
+	if s.Target != nil {
+		target = s.Target.String()
+	}
+	hash := sha256.Sum256([]byte(strings.Join([]string{s.Type, s.URL, s.Path, s.Behavior, target}, "\x00")))
+	return fmt.Sprintf("%x", hash[:8])
+}
+
+// SubscriptionManager fetches the sources of a Subscription (Config.Subscription),
+// converts them to K2RULEV3 and composes them with slice.MergeWithOptions into the
+// rules it loads. Each source is checked on its own interval (ETag-conditional for
+// URLs, modification time for local files); the rules are recomposed whenever a
+// source changes. A failing source keeps its last converted rules.
+type SubscriptionManager struct {
+	sub      Subscription
+	cacheDir string
+	dls      []*downloader // One per source (credentials differ)
+
+	componentSet // Rules composed from the sources
+
+	// Update metadata
+	mu         sync.RWMutex
+	stamps     map[string]string // ETag (URL) or modification stamp (Path) of each converted source, by key
+	lastUpdate time.Time
+	lastErr    error
+	generation uint64 // Number of successful compositions
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	flight     flightGroup // deduplicates concurrent checks
+
+	managerBase // ManagerOption settings (interval, logger, validator)
+}
+
+// NewSubscriptionManager creates a subscription manager. The rule manager is attached
+// with the rules field before Init.
+func NewSubscriptionManager(sub Subscription, cacheDir string, opts ...ManagerOption) *SubscriptionManager {
+	m := &SubscriptionManager{
+		sub:    sub,
+		dls:    make([]*downloader, len(sub.Sources)),
+		stamps: make(map[string]string),
+		stopCh: make(chan struct{}),
+	}
+	for i := range m.dls {
+		m.dls[i] = newDownloader(60 * time.Second)
+		m.dls[i].auth = sub.Sources[i].Auth
+		m.cacheDir, m.managerBase = applyManagerOptions(cacheDir, m.dls[i], opts)
+	}
+	return m
+}
+
+// Init initializes the manager: loads the converted sources from cache → fetches them if needed → starts auto-update
+func (m *SubscriptionManager) Init() error {
+	if !m.readOnly {
+		if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	// 1. Check cache (converted sources + their stamps)
+	if err := m.loadCached(); err == nil {
+		m.logger().Info("subscription loaded from cache")
+		if !m.readOnly {
+			safeGo("subscription", m.startAutoUpdate)
+		}
+		return nil
+	} else if m.readOnly {
+		m.proxyUntilLoaded()
+		m.logger().Warn("subscription cache not loaded, download skipped, cache is read-only", "error", err)
+		return nil
+	} else if !os.IsNotExist(err) {
+		m.logger().Warn("subscription cache corrupted, will re-download", "error", err)
+	}
+
+	// 2. Fetch all sources in background (non-blocking); rules proxy everything until loaded
+	m.proxyUntilLoaded()
+	m.logger().Info("subscription cache not found, downloading in background")
+	safeGo("subscription", func() {
+		retryForever("subscription", m.stopCh, func() error { return m.check(nil, false) })
+		m.startAutoUpdate()
+	})
+	return nil
+}
+
+// Stop stops the background auto-update task
+func (m *SubscriptionManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// Update checks all sources now and recomposes the rules if any changed.
+// Concurrent calls share one check and its result.
+func (m *SubscriptionManager) Update() error {
+	if m.skipUpdate("subscription") {
+		return nil
+	}
+	return m.check(nil, true)
+}
+
+// loadCached composes the converted sources of an earlier run
+func (m *SubscriptionManager) loadCached() error {
+	var stamps map[string]string
+	found, err := stampsSchema.Load(m.getPath("json"), &stamps)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no cached subscription: %w", os.ErrNotExist)
+	}
+	if err := m.compose(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.stamps = stamps
+	m.generation++
+	m.mu.Unlock()
+	return nil
+}
+
+// check refreshes the due sources (nil = all) and recomposes the rules when one
+// changed or none are loaded yet. A failing source doesn't stop the others.
+func (m *SubscriptionManager) check(due []bool, useStamps bool) error {
+	return m.flight.do("check", func() (err error) {
+		defer func() { m.setLastError(err) }()
+
+		var errs []error
+		changed := false
+		for i := range m.sub.Sources {
+			if due != nil && !due[i] {
+				continue
+			}
+			updated, err := m.refresh(i, useStamps)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("source %s: %w", m.sub.Sources[i].label(), err))
+				continue
+			}
+			changed = changed || updated
+		}
+
+		if changed || m.rules.reader.Get() == nil {
+			if err := m.compose(); err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			m.mu.Lock()
+			m.lastUpdate = time.Now()
+			m.generation++
+			m.mu.Unlock()
+			if err := m.saveStamps(); err != nil {
+				m.logger().Warn("failed to persist subscription state", "error", err)
+			}
+			m.logger().Info("subscription rules composed", "sources", len(m.sub.Sources))
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// refresh fetches and converts source i; it reports whether its rules changed
+func (m *SubscriptionManager) refresh(i int, useStamps bool) (bool, error) {
+	src := &m.sub.Sources[i]
+	key := src.key()
+	dest := m.sourcePath(src)
+
+	var previous string
+	if useStamps {
+		if _, err := os.Stat(dest); err == nil {
+			m.mu.RLock()
+			previous = m.stamps[key]
+			m.mu.RUnlock()
+		}
+	}
+
+	input := src.Path
+	var stamp string
+	if src.Path != "" {
+		info, err := os.Stat(src.Path)
+		if err != nil {
+			return false, err
+		}
+		stamp = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+		if previous != "" && stamp == previous {
+			return false, nil
+		}
+	} else {
+		input = dest + ".download"
+		etag, modified, err := m.dls[i].fetchValidated(src.URL, previous, input, false, m.validator)
+		if err != nil {
+			return false, err
+		}
+		if !modified {
+			return false, nil
+		}
+		defer os.Remove(input)
+		stamp = etag
+	}
+
+	data, err := convertSource(src, input)
+	if err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(dest, bytes.NewReader(data)); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	m.stamps[key] = stamp
+	m.mu.Unlock()
+	m.logger().Debug("subscription source updated", "source", src.label())
+	return true, nil
+}
+
+// convertSource converts the file of a source to K2RULEV3 data (uncompressed)
+func convertSource(src *SubscriptionSource, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch src.Type {
+	case SourceK2R:
+		if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+			gz, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+			}
+			data, err = io.ReadAll(gz)
+			gz.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress gzip: %w", err)
+			}
+		}
+		reader, err := slice.NewInMemoryReaderFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if err := reader.Err(); err != nil {
+			return nil, err
+		}
+		return data, nil
+
+	case SourceClashText:
+		target := TargetProxy
+		if src.Target != nil {
+			target = *src.Target
+		}
+		return clash.NewSliceConverter().ConvertProvider(string(data), src.Behavior, uint8(target), uint8(TargetProxy))
+
+	case SourceHosts:
+		entries, err := parseHosts(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		byTarget := make(map[Target][]string)
+		for name, ips := range entries {
+			target := hostsTarget(ips)
+			byTarget[target] = append(byTarget[target], name)
+		}
+		w := slice.NewSliceWriter(uint8(TargetProxy))
+		for _, target := range []Target{TargetReject, TargetDirect} {
+			if names := byTarget[target]; len(names) > 0 {
+				sort.Strings(names)
+				if err := w.AddDomainSlice(names, uint8(target)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return w.Build()
+	}
+	return nil, fmt.Errorf("unknown type %q", src.Type)
+}
+
+// compose merges the converted sources into the rules file and loads it
+func (m *SubscriptionManager) compose() error {
+	opts := slice.MergeOptions{TargetMaps: make([]map[uint8]uint8, len(m.sub.Sources))}
+	if m.sub.Fallback != nil {
+		fallback := uint8(*m.sub.Fallback)
+		opts.Fallback = &fallback
+	}
+	inputs := make([]string, len(m.sub.Sources))
+	for i := range m.sub.Sources {
+		src := &m.sub.Sources[i]
+		inputs[i] = m.sourcePath(src)
+		if _, err := os.Stat(inputs[i]); err != nil {
+			return err
+		}
+		opts.TargetMaps[i], _ = src.targetMap() // validated by Config.Validate
+	}
+
+	dest := m.getPath("k2r.gz")
+	if err := slice.MergeWithOptions(dest, opts, inputs...); err != nil {
+		return err
+	}
+	if err := m.load(ComponentRules, dest); err != nil {
+		return fmt.Errorf("failed to load composed rules: %w", err)
+	}
+	return nil
+}
+
+// saveStamps persists the source stamps so restarts send them with the next checks
+func (m *SubscriptionManager) saveStamps() error {
+	m.mu.RLock()
+	stamps := make(map[string]string, len(m.stamps))
+	for key, stamp := range m.stamps {
+		stamps[key] = stamp
+	}
+	m.mu.RUnlock()
+	return stampsSchema.Save(m.getPath("json"), stamps)
+}
+
+// interval returns the update interval of source i
+func (m *SubscriptionManager) interval(i int) time.Duration {
+	if d := time.Duration(m.sub.Sources[i].Interval); d > 0 {
+		return d
+	}
+	return m.updateInterval(subscriptionInterval)
+}
+
+// startAutoUpdate checks each source when its interval elapses (all of them on an
+// immediate run)
+func (m *SubscriptionManager) startAutoUpdate() {
+	next := make([]time.Time, len(m.sub.Sources))
+	for i := range next {
+		next[i] = time.Now().Add(m.interval(i))
+	}
+	untilEarliest := func() time.Duration {
+		earliest := next[0]
+		for _, t := range next[1:] {
+			if t.Before(earliest) {
+				earliest = t
+			}
+		}
+		return time.Until(earliest)
+	}
+
+	newScheduler(ComponentSubscription, untilEarliest, m.jitter, m.logger(), func(immediate bool) error {
+		now := time.Now()
+		due := make([]bool, len(next))
+		for i := range next {
+			if immediate || !now.Before(next[i]) {
+				due[i] = true
+				next[i] = now.Add(m.interval(i))
+			}
+		}
+		return m.check(due, true)
+	}).run(m.stopCh)
+}
+
+// getPath returns a cache file path for this subscription (based on its sources)
+func (m *SubscriptionManager) getPath(name string) string {
+	keys := make([]string, len(m.sub.Sources))
+	for i := range m.sub.Sources {
+		keys[i] = m.sub.Sources[i].key()
+	}
+	hash := sha256.Sum256([]byte(strings.Join(keys, ",")))
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x.subscription.%s", hash[:8], name))
+}
+
+// sourcePath returns the cache file of a source's converted rules
+func (m *SubscriptionManager) sourcePath(src *SubscriptionSource) string {
+	return filepath.Join(m.cacheDir, src.key()+".source.k2r")
+}
+
+// GetLastUpdate returns the time the rules were last composed from fetched sources
+func (m *SubscriptionManager) GetLastUpdate() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastUpdate
+}
+
+// GetLastError returns the error of the most recent check (nil after success)
+func (m *SubscriptionManager) GetLastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Status returns a snapshot of the subscription state; Source lists the sources
+func (m *SubscriptionManager) Status() ComponentInfo {
+	labels := make([]string, len(m.sub.Sources))
+	for i := range m.sub.Sources {
+		labels[i] = m.sub.Sources[i].label()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return ComponentInfo{
+		Name:       ComponentSubscription,
+		Source:     strings.Join(labels, ", "),
+		Loaded:     m.generation > 0,
+		Generation: m.generation,
+		LastUpdate: m.lastUpdate,
+		LastError:  errorString(m.lastErr),
+		ReadOnly:   m.readOnly,
+	}
+}
+
+// setLastError records the outcome of a check
+func (m *SubscriptionManager) setLastError(err error) {
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+}
@@ -0,0 +1,286 @@
+package k2rule
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// subscriptionTestServer serves source bodies with ETags
+type subscriptionTestServer struct {
+	mu       sync.Mutex
+	bodies   map[string]string // path → body (ETag = body hash)
+	requests map[string]int    // path → full downloads
+}
+
+func (s *subscriptionTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.bodies[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.requests[r.URL.Path]++
+	w.Header().Set("ETag", etag)
+	w.Write([]byte(body))
+}
+
+func (s *subscriptionTestServer) set(path, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies[path] = body
+}
+
+func (s *subscriptionTestServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// newSubscriptionTest serves a k2r source (REJECT porn.example, fallback DIRECT) and a
+// clash-text source, and writes a hosts source; it returns the subscription
+func newSubscriptionTest(t *testing.T) (*subscriptionTestServer, *Subscription, string) {
+	t.Helper()
+	ts := &subscriptionTestServer{bodies: map[string]string{}, requests: map[string]int{}}
+	server := httptest.NewServer(ts)
+	t.Cleanup(server.Close)
+
+	ts.set("/community.k2r.gz", string(gzipBytes(t, buildTestPornK2R(t, []string{"porn.example", "shared.example"}))))
+	ts.set("/direct.txt", "# direct\n+.cn.example\nshared.example\n")
+	hosts := filepath.Join(t.TempDir(), "corp.hosts")
+	if err := os.WriteFile(hosts, []byte("10.0.0.5 nas.corp.example\n0.0.0.0 ads.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	direct := TargetDirect
+	sub := &Subscription{Sources: []SubscriptionSource{
+		{Name: "community", URL: server.URL + "/community.k2r.gz", Type: SourceK2R},
+		{Name: "direct", URL: server.URL + "/direct.txt", Type: SourceClashText, Target: &direct},
+		{Name: "corp", Path: hosts, Type: SourceHosts, TargetMap: map[string]Target{"DIRECT": TargetProxy}},
+	}}
+	return ts, sub, hosts
+}
+
+func TestParseSubscription(t *testing.T) {
+	yamlDoc := `
+sources:
+  - name: ads
+    url: https://lists.example.com/ads.txt
+    type: clash-text
+    behavior: domain
+    target: REJECT
+    interval: 24h
+  - path: /etc/k2rule/corp.hosts
+    type: hosts
+    target_map: {DIRECT: PROXY}
+fallback: DIRECT
+`
+	sub, err := ParseSubscription([]byte(yamlDoc))
+	if err != nil {
+		t.Fatalf("ParseSubscription(YAML) failed: %v", err)
+	}
+	if len(sub.Sources) != 2 || sub.Fallback == nil || *sub.Fallback != TargetDirect {
+		t.Fatalf("ParseSubscription(YAML) = %+v", sub)
+	}
+	ads := sub.Sources[0]
+	if ads.Target == nil || *ads.Target != TargetReject || ads.Interval != Duration(24*60*60*1e9) {
+		t.Errorf("source 0 = %+v, want target REJECT, interval 24h", ads)
+	}
+	if got := sub.Sources[1].TargetMap["DIRECT"]; got != TargetProxy {
+		t.Errorf("source 1 target_map[DIRECT] = %s, want PROXY", got)
+	}
+
+	if _, err := ParseSubscription([]byte(`{"sources": [{"url": "https://example.com/r.k2r.gz", "type": "k2r"}]}`)); err != nil {
+		t.Errorf("ParseSubscription(JSON) failed: %v", err)
+	}
+
+	for name, doc := range map[string]string{
+		"no sources":       `{"sources": []}`,
+		"url and path":     `{"sources": [{"url": "https://example.com/a", "path": "/a", "type": "k2r"}]}`,
+		"no url or path":   `{"sources": [{"type": "k2r"}]}`,
+		"unknown type":     `{"sources": [{"url": "https://example.com/a", "type": "surge"}]}`,
+		"unknown behavior": `{"sources": [{"url": "https://example.com/a", "type": "clash-text", "behavior": "geo"}]}`,
+		"bad target map":   `{"sources": [{"url": "https://example.com/a", "type": "k2r", "target_map": {"NOPE": "PROXY"}}]}`,
+		"bad interval":     `{"sources": [{"url": "https://example.com/a", "type": "k2r", "interval": "-1h"}]}`,
+	} {
+		if _, err := ParseSubscription([]byte(doc)); err == nil {
+			t.Errorf("%s: ParseSubscription() succeeded", name)
+		}
+	}
+}
+
+func TestSubscriptionManager_ComposesSources(t *testing.T) {
+	ts, sub, hosts := newSubscriptionTest(t)
+	cacheDir := t.TempDir()
+	m := NewSubscriptionManager(*sub, cacheDir)
+	m.rules = NewRemoteRuleManager("", cacheDir, TargetDirect)
+	defer m.rules.Close()
+
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	for domain, want := range map[string]Target{
+		"porn.example":     TargetReject, // community
+		"shared.example":   TargetReject, // community wins over direct
+		"www.cn.example":   TargetDirect, // direct
+		"nas.corp.example": TargetProxy,  // corp, DIRECT mapped to PROXY
+		"ads.example":      TargetReject, // corp, 0.0.0.0
+		"other.example":    TargetDirect, // fallback of the first source
+	} {
+		if got := m.rules.matchDomain(domain); got != want {
+			t.Errorf("matchDomain(%s) = %s, want %s", domain, got, want)
+		}
+	}
+	if status := m.Status(); !status.Loaded || status.Generation != 1 || status.Name != ComponentSubscription {
+		t.Errorf("Status() = %+v, want loaded generation 1", status)
+	}
+
+	// Unchanged sources: conditional requests only, no recomposition
+	if err := m.Update(); err != nil {
+		t.Fatalf("second Update() failed: %v", err)
+	}
+	if got := ts.count("/direct.txt"); got != 1 {
+		t.Errorf("direct.txt downloaded %d times, want 1", got)
+	}
+	if got := m.Status().Generation; got != 1 {
+		t.Errorf("Generation after unchanged Update = %d, want 1", got)
+	}
+
+	// A changed source is recomposed
+	ts.set("/direct.txt", "changed.example\n")
+	if err := os.WriteFile(hosts, []byte("10.0.0.5 nas.corp.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Update(); err != nil {
+		t.Fatalf("third Update() failed: %v", err)
+	}
+	if got := m.rules.matchDomain("changed.example"); got != TargetDirect {
+		t.Errorf("matchDomain(changed.example) = %s, want DIRECT", got)
+	}
+	if got := m.rules.matchDomain("www.cn.example"); got != TargetDirect {
+		t.Errorf("matchDomain(www.cn.example) = %s, want fallback DIRECT", got)
+	}
+
+	// A new manager loads the composed rules from cache
+	cached := NewSubscriptionManager(*sub, cacheDir)
+	cached.rules = NewRemoteRuleManager("", cacheDir, TargetDirect)
+	defer cached.rules.Close()
+	if err := cached.loadCached(); err != nil {
+		t.Fatalf("loadCached() failed: %v", err)
+	}
+	if got := cached.rules.matchDomain("changed.example"); got != TargetDirect {
+		t.Errorf("cached matchDomain(changed.example) = %s, want DIRECT", got)
+	}
+}
+
+func TestSubscriptionManager_FailingSourceKeepsRules(t *testing.T) {
+	ts, sub, _ := newSubscriptionTest(t)
+	cacheDir := t.TempDir()
+	m := NewSubscriptionManager(*sub, cacheDir)
+	m.rules = NewRemoteRuleManager("", cacheDir, TargetDirect)
+	defer m.rules.Close()
+
+	if err := m.Update(); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	ts.set("/community.k2r.gz", "not a rule file")
+	ts.set("/direct.txt", "late.example\n")
+	err := m.Update()
+	if err == nil || !strings.Contains(err.Error(), "community") {
+		t.Fatalf("Update() = %v, want error naming the community source", err)
+	}
+	if got := m.rules.matchDomain("porn.example"); got != TargetReject {
+		t.Errorf("matchDomain(porn.example) = %s, want REJECT from the last good community rules", got)
+	}
+	if got := m.rules.matchDomain("late.example"); got != TargetDirect {
+		t.Errorf("matchDomain(late.example) = %s, want DIRECT from the updated source", got)
+	}
+	if m.Status().LastError == "" {
+		t.Error("Status().LastError is empty after a failed source")
+	}
+}
+
+func TestInit_Subscription(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	_, sub, _ := newSubscriptionTest(t)
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	config := &Config{
+		Subscription: sub,
+		GeoIPURL:     unavailable.URL + "/geoip.mmdb.gz",
+		CacheDir:     t.TempDir(),
+	}
+	if err := Init(config); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	rules := RuleComponent()
+	if rules == nil {
+		t.Fatal("RuleComponent() = nil")
+	}
+	if err := rules.Update(); err != nil {
+		t.Fatalf("RuleComponent().Update() failed: %v", err)
+	}
+	if got := Match("porn.example"); got != TargetReject {
+		t.Errorf("Match(porn.example) = %s, want REJECT", got)
+	}
+
+	found := false
+	for _, info := range ComponentStatus() {
+		if info.Name == ComponentSubscription {
+			found = info.Loaded
+		}
+	}
+	if !found {
+		t.Error("ComponentStatus() has no loaded subscription")
+	}
+
+	// A local rule file replaces the subscription
+	path := filepath.Join(t.TempDir(), "rules.k2r.gz")
+	writeTestK2RGzipFile(t, path, buildTestPornK2R(t, []string{"local.example"}))
+	if err := ReloadRuleFile(path); err != nil {
+		t.Fatalf("ReloadRuleFile() failed: %v", err)
+	}
+	if GetConfig().Subscription != nil {
+		t.Error("GetConfig().Subscription is set after ReloadRuleFile")
+	}
+	for _, info := range ComponentStatus() {
+		if info.Name == ComponentSubscription {
+			t.Error("ComponentStatus() still lists the subscription after ReloadRuleFile")
+		}
+	}
+}
+
+func TestConfigValidate_Subscription(t *testing.T) {
+	sub := &Subscription{Sources: []SubscriptionSource{{URL: "https://example.com/r.k2r.gz", Type: SourceK2R}}}
+	for name, config := range map[string]Config{
+		"RuleURL":     {CacheDir: "/tmp", Subscription: sub, RuleURL: "https://example.com/rules.k2r.gz"},
+		"BundleURL":   {CacheDir: "/tmp", Subscription: sub, BundleURL: "https://example.com/bundle.tar.gz"},
+		"invalid":     {CacheDir: "/tmp", Subscription: &Subscription{}},
+		"ManifestURL": {CacheDir: "/tmp", Subscription: sub, ManifestURL: "https://example.com/index.json"},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: Validate() succeeded", name)
+		}
+	}
+	config := Config{CacheDir: "/tmp", Subscription: sub}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
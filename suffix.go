@@ -0,0 +1,69 @@
+package k2rule
+
+// LongestMatchingSuffix reports which stored domain rule of the loaded rule file
+// matches domain: the domain itself or the parent domain whose rule covers it,
+// with a leading dot (e.g. ".googleapis.com"), and its target. Like Match, the
+// first matching rule slice decides; within a slice the most specific stored
+// domain is reported.
+//
+// Only rule file domain rules are consulted (not TmpRules, hosts entries, network
+// profiles, category policies or global mode), so the suffix is a stable key for
+// caching rule file decisions across the hostnames below it. ok is false when no
+// domain rule matches or no rules are loaded.
+//
+// Example:
+//
+//	suffix, target, ok := k2rule.LongestMatchingSuffix("storage.googleapis.com")
+//	// → ".googleapis.com", PROXY, true
+func LongestMatchingSuffix(domain string) (suffix string, target Target, ok bool) {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	matcher := defaultEngine.matcher
+	defaultEngine.mu.RUnlock()
+
+	var matched string
+	var t uint8
+	switch {
+	case manager != nil:
+		matched, t, ok = manager.reader.MatchDomainSuffix(domain)
+	case matcher != nil && matcher.reader != nil:
+		matched, t, ok = matcher.reader.MatchDomainSuffix(domain)
+	}
+	if !ok {
+		return "", 0, false
+	}
+	return "." + matched, Target(t), true
+}
+
+// HasDomainRule reports whether any domain rule of the loaded rule file covers
+// domain, whatever its target, so UIs can show "covered by ruleset" rather than
+// "will use fallback" while a user types a site. Like LongestMatchingSuffix, only
+// rule file domain rules are consulted; false when no rules are loaded.
+//
+// Example:
+//
+//	if k2rule.HasDomainRule(input) {
+//	    label.SetText("Covered by ruleset")
+//	}
+func HasDomainRule(domain string) bool {
+	_, _, ok := LongestMatchingSuffix(domain)
+	return ok
+}
+
+// CountDomainRules returns the number of domain rules in the loaded rule file, all
+// targets together (0 when no rules are loaded). A domain stored in several rule
+// slices is counted once per slice.
+func CountDomainRules() int {
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	matcher := defaultEngine.matcher
+	defaultEngine.mu.RUnlock()
+
+	switch {
+	case manager != nil:
+		return manager.reader.DomainCount()
+	case matcher != nil && matcher.reader != nil:
+		return matcher.reader.DomainCount()
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package k2rule
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+func TestLongestMatchingSuffix(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if _, _, ok := LongestMatchingSuffix("googleapis.com"); ok {
+		t.Error("LongestMatchingSuffix() without rules matched")
+	}
+
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	w.AddDomainSlice([]string{"googleapis.com", "baidu.com"}, uint8(TargetProxy))
+	w.AddDomainSlice([]string{"cn.googleapis.com"}, uint8(TargetDirect))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		domain string
+		suffix string
+		target Target
+		ok     bool
+	}{
+		{"storage.googleapis.com", ".googleapis.com", TargetProxy, true},
+		{"GoogleAPIs.com", ".googleapis.com", TargetProxy, true},
+		{"x.cn.googleapis.com", ".googleapis.com", TargetProxy, true}, // first slice decides, as in Match
+		{"www.baidu.com", ".baidu.com", TargetProxy, true},
+		{"example.com", "", 0, false},
+	}
+	for _, tt := range tests {
+		suffix, target, ok := LongestMatchingSuffix(tt.domain)
+		if suffix != tt.suffix || target != tt.target || ok != tt.ok {
+			t.Errorf("LongestMatchingSuffix(%q) = %q, %s, %v, want %q, %s, %v", tt.domain, suffix, target, ok, tt.suffix, tt.target, tt.ok)
+		}
+		if ok && Match(tt.domain) != target {
+			t.Errorf("Match(%q) = %s, want %s", tt.domain, Match(tt.domain), target)
+		}
+	}
+}
+
+func TestHasDomainRule(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if HasDomainRule("example.com") || CountDomainRules() != 0 {
+		t.Error("HasDomainRule()/CountDomainRules() without rules reported rules")
+	}
+
+	// Fallback PROXY: a DIRECT rule and a rule with the fallback target both count
+	w := slice.NewSliceWriter(uint8(TargetProxy))
+	w.AddDomainSlice([]string{"baidu.com", "qq.com"}, uint8(TargetDirect))
+	w.AddDomainTrieSlice([]string{"google.com"}, uint8(TargetProxy))
+	w.AddDomainTargetSlice([]slice.DomainTarget{{Domain: "ads.example.com", Target: uint8(TargetReject)}})
+	w.AddCidrV4Slice([]slice.CidrV4Entry{{Network: 10 << 24, PrefixLen: 8}}, uint8(TargetDirect))
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"www.baidu.com", true},
+		{"google.com", true},
+		{"x.ads.example.com", true},
+		{"example.com", false},
+		{"github.com", false},
+	}
+	for _, tt := range tests {
+		if got := HasDomainRule(tt.domain); got != tt.want {
+			t.Errorf("HasDomainRule(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+	if got := CountDomainRules(); got != 4 {
+		t.Errorf("CountDomainRules() = %d, want 4", got)
+	}
+
+	// Rules of the old matcher (no RemoteRuleManager)
+	reader, err := slice.NewSliceReaderFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSliceReaderFromBytes failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = nil
+	defaultEngine.matcher = &Matcher{reader: reader}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	if !HasDomainRule("qq.com") || CountDomainRules() != 4 {
+		t.Errorf("old matcher: HasDomainRule(qq.com) = %v, CountDomainRules() = %d, want true, 4", HasDomainRule("qq.com"), CountDomainRules())
+	}
+	manager.Close()
+}
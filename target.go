@@ -1,6 +1,13 @@
 package k2rule
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
 
 // Target represents the routing decision for a request
 type Target uint8
@@ -12,6 +19,144 @@ const (
 	TargetProxy Target = 1
 	// TargetReject blocks the traffic
 	TargetReject Target = 2
+	// TargetThrottle routes traffic with limited bandwidth. k2rule only reports the
+	// decision; the embedding proxy enforces the limit (typically via SetTargetHandler).
+	//
+	// Format compatibility: rule files store targets as a single byte, so THROTTLE (3)
+	// needs no format version bump. Readers older than this release decode it as
+	// Target(3), which String reports as "UNKNOWN(3)" unless registered as a custom
+	// target; publish files using THROTTLE only to clients that understand it.
+	//
+	// Migration: earlier releases documented RegisterTarget(3, ...) for custom targets.
+	// IDs 3-5 are now built in, so a custom target registered there must move above
+	// lastBuiltinTarget and rule files using it must be regenerated. RegisterTarget and
+	// ApplyPolicy reject such a target instead of silently reading it as a built-in.
+	TargetThrottle Target = 3
+	// TargetRejectDrop blocks the traffic by silently dropping it. It is a rule file
+	// target: Match reports it as TargetReject with MatchResult.RejectMode RejectDrop.
+	TargetRejectDrop Target = 4
+	// TargetRejectReset blocks the traffic by actively resetting the connection. It is
+	// a rule file target: Match reports it as TargetReject with RejectMode RejectReset.
+	TargetRejectReset Target = 5
+)
+
+// lastBuiltinTarget is the highest built-in target; custom targets use higher IDs
+const lastBuiltinTarget = TargetRejectReset
+
+// RejectMode is how a REJECT decision should be enforced
+type RejectMode uint8
+
+const (
+	// RejectDefault leaves the choice to the enforcement layer (plain REJECT)
+	RejectDefault RejectMode = iota
+	// RejectDrop silently drops the connection (the client sees a timeout)
+	RejectDrop
+	// RejectReset actively resets the connection (TCP RST / ICMP unreachable)
+	RejectReset
+)
+
+// String returns "default", "drop" or "reset"
+func (m RejectMode) String() string {
+	switch m {
+	case RejectDrop:
+		return "drop"
+	case RejectReset:
+		return "reset"
+	default:
+		return "default"
+	}
+}
+
+// MarshalJSON encodes the mode as its name
+func (m RejectMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts "default", "drop" or "reset"
+func (m *RejectMode) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("invalid reject mode: %s", data)
+	}
+	switch name {
+	case "", "default":
+		*m = RejectDefault
+	case "drop":
+		*m = RejectDrop
+	case "reset":
+		*m = RejectReset
+	default:
+		return fmt.Errorf("invalid reject mode: %s", name)
+	}
+	return nil
+}
+
+// RejectMode returns the reject mode of a REJECT target and whether t is one
+// (TargetReject, TargetRejectDrop or TargetRejectReset)
+func (t Target) RejectMode() (RejectMode, bool) {
+	switch t {
+	case TargetReject:
+		return RejectDefault, true
+	case TargetRejectDrop:
+		return RejectDrop, true
+	case TargetRejectReset:
+		return RejectReset, true
+	default:
+		return RejectDefault, false
+	}
+}
+
+// TargetInfo describes a target for display in UIs
+type TargetInfo struct {
+	ID          Target `json:"id"`                    // Encoded as a number
+	Name        string `json:"name"`                  // Canonical name, e.g. "PROXY"
+	Description string `json:"description,omitempty"` // Human-readable description
+	Color       string `json:"color,omitempty"`       // Optional UI color hint, e.g. "#34C759"
+}
+
+// targetInfoJSON is the JSON form of TargetInfo; the ID is always numeric so that
+// documents listing custom targets can be decoded before the names are registered
+type targetInfoJSON struct {
+	ID          uint8  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Color       string `json:"color,omitempty"`
+}
+
+// MarshalJSON encodes the target info with a numeric ID
+func (i TargetInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(targetInfoJSON{ID: uint8(i.ID), Name: i.Name, Description: i.Description, Color: i.Color})
+}
+
+// UnmarshalJSON decodes target info with a numeric ID
+func (i *TargetInfo) UnmarshalJSON(data []byte) error {
+	var v targetInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = TargetInfo{ID: Target(v.ID), Name: v.Name, Description: v.Description, Color: v.Color}
+	return nil
+}
+
+// builtinTargets is the metadata of the built-in targets
+var builtinTargets = []TargetInfo{
+	{ID: TargetDirect, Name: "DIRECT", Description: "Route directly without proxy", Color: "#34C759"},
+	{ID: TargetProxy, Name: "PROXY", Description: "Route through the proxy", Color: "#007AFF"},
+	{ID: TargetReject, Name: "REJECT", Description: "Block the connection", Color: "#FF3B30"},
+	{ID: TargetThrottle, Name: "THROTTLE", Description: "Route with limited bandwidth", Color: "#FF9500"},
+	{ID: TargetRejectDrop, Name: "REJECT-DROP", Description: "Block by silently dropping the connection", Color: "#FF3B30"},
+	{ID: TargetRejectReset, Name: "REJECT-RESET", Description: "Block by resetting the connection", Color: "#FF3B30"},
+}
+
+var (
+	targetRegistryMu sync.RWMutex
+	targetRegistry   = func() map[Target]TargetInfo {
+		m := make(map[Target]TargetInfo, len(builtinTargets))
+		for _, info := range builtinTargets {
+			m[info.ID] = info
+		}
+		return m
+	}()
 )
 
 // String returns the string representation of Target
@@ -23,12 +168,22 @@ func (t Target) String() string {
 		return "PROXY"
 	case TargetReject:
 		return "REJECT"
+	case TargetThrottle:
+		return "THROTTLE"
+	case TargetRejectDrop:
+		return "REJECT-DROP"
+	case TargetRejectReset:
+		return "REJECT-RESET"
 	default:
+		if info, ok := LookupTarget(t); ok {
+			return info.Name
+		}
 		return fmt.Sprintf("UNKNOWN(%d)", t)
 	}
 }
 
-// ParseTarget parses a string into Target
+// ParseTarget parses a string into Target.
+// Names of custom targets registered with RegisterTarget are matched case-insensitively.
 func ParseTarget(s string) (Target, error) {
 	switch s {
 	case "DIRECT", "direct":
@@ -37,7 +192,113 @@ func ParseTarget(s string) (Target, error) {
 		return TargetProxy, nil
 	case "REJECT", "reject":
 		return TargetReject, nil
+	case "THROTTLE", "throttle":
+		return TargetThrottle, nil
+	case "REJECT-DROP", "reject-drop":
+		return TargetRejectDrop, nil
+	case "REJECT-RESET", "reject-reset":
+		return TargetRejectReset, nil
 	default:
+		if s != "" {
+			targetRegistryMu.RLock()
+			defer targetRegistryMu.RUnlock()
+			for id, info := range targetRegistry {
+				if id > lastBuiltinTarget && strings.EqualFold(info.Name, s) {
+					return id, nil
+				}
+			}
+		}
 		return 0, fmt.Errorf("invalid target: %s", s)
 	}
 }
+
+// RegisterTarget registers (or updates) display metadata for a target.
+// Built-in targets keep their names but may get a new description; names must be unique.
+//
+// Example:
+//
+//	k2rule.RegisterTarget(10, "HK-RELAY", "Route via Hong Kong relay")
+//	k2rule.Target(10).String() // "HK-RELAY"
+func RegisterTarget(id Target, name, description string) error {
+	return RegisterTargetInfo(TargetInfo{ID: id, Name: name, Description: description})
+}
+
+// RegisterTargetInfo registers (or updates) the full metadata of a target.
+// An empty Color keeps the previously registered color.
+func RegisterTargetInfo(info TargetInfo) error {
+	info.Name = strings.TrimSpace(info.Name)
+	if info.Name == "" {
+		return fmt.Errorf("target name cannot be empty")
+	}
+
+	targetRegistryMu.Lock()
+	defer targetRegistryMu.Unlock()
+
+	existing, exists := targetRegistry[info.ID]
+	if exists && info.ID <= lastBuiltinTarget && info.Name != existing.Name {
+		if info.ID > TargetReject {
+			return fmt.Errorf("target id %d is the built-in target %s; custom targets use ids above %d", info.ID, existing.Name, lastBuiltinTarget)
+		}
+		return fmt.Errorf("cannot rename built-in target %s", existing.Name)
+	}
+	for id, other := range targetRegistry {
+		if id != info.ID && strings.EqualFold(other.Name, info.Name) {
+			return fmt.Errorf("target name %q already registered for id %d", info.Name, id)
+		}
+	}
+	if info.Color == "" {
+		info.Color = existing.Color
+	}
+
+	targetRegistry[info.ID] = info
+	return nil
+}
+
+// LookupTarget returns the registered metadata of a target
+func LookupTarget(id Target) (TargetInfo, bool) {
+	targetRegistryMu.RLock()
+	defer targetRegistryMu.RUnlock()
+	info, ok := targetRegistry[id]
+	return info, ok
+}
+
+// AllTargets returns the metadata of all built-in and registered targets, ordered by ID
+func AllTargets() []TargetInfo {
+	targetRegistryMu.RLock()
+	infos := make([]TargetInfo, 0, len(targetRegistry))
+	for _, info := range targetRegistry {
+		infos = append(infos, info)
+	}
+	targetRegistryMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// MarshalJSON encodes a known target as its name ("PROXY") and an unknown one as a number
+func (t Target) MarshalJSON() ([]byte, error) {
+	if _, ok := LookupTarget(t); ok {
+		return json.Marshal(t.String())
+	}
+	return []byte(strconv.Itoa(int(t))), nil
+}
+
+// UnmarshalJSON accepts a target name ("PROXY", "proxy") or a number (1)
+func (t *Target) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		parsed, err := ParseTarget(name)
+		if err != nil {
+			return err
+		}
+		*t = parsed
+		return nil
+	}
+
+	var n uint8
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid target: %s", data)
+	}
+	*t = Target(n)
+	return nil
+}
@@ -1,6 +1,9 @@
 package k2rule
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 func TestTargetString(t *testing.T) {
 	tests := []struct {
@@ -10,6 +13,9 @@ func TestTargetString(t *testing.T) {
 		{TargetDirect, "DIRECT"},
 		{TargetProxy, "PROXY"},
 		{TargetReject, "REJECT"},
+		{TargetThrottle, "THROTTLE"},
+		{TargetRejectDrop, "REJECT-DROP"},
+		{TargetRejectReset, "REJECT-RESET"},
 		{Target(99), "UNKNOWN(99)"},
 	}
 
@@ -35,6 +41,10 @@ func TestParseTarget(t *testing.T) {
 		{"proxy uppercase", "PROXY", TargetProxy, false},
 		{"reject lowercase", "reject", TargetReject, false},
 		{"reject uppercase", "REJECT", TargetReject, false},
+		{"throttle lowercase", "throttle", TargetThrottle, false},
+		{"throttle uppercase", "THROTTLE", TargetThrottle, false},
+		{"reject-drop lowercase", "reject-drop", TargetRejectDrop, false},
+		{"reject-reset uppercase", "REJECT-RESET", TargetRejectReset, false},
 		{"invalid", "invalid", 0, true},
 		{"empty", "", 0, true},
 	}
@@ -52,3 +62,84 @@ func TestParseTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterTarget(t *testing.T) {
+	const relay Target = 200
+	t.Cleanup(func() {
+		targetRegistryMu.Lock()
+		delete(targetRegistry, relay)
+		targetRegistryMu.Unlock()
+	})
+
+	if err := RegisterTarget(relay, "HK-RELAY", "Route via Hong Kong relay"); err != nil {
+		t.Fatalf("RegisterTarget failed: %v", err)
+	}
+	if got := relay.String(); got != "HK-RELAY" {
+		t.Errorf("String() = %q, want HK-RELAY", got)
+	}
+	if got, err := ParseTarget("hk-relay"); err != nil || got != relay {
+		t.Errorf("ParseTarget(hk-relay) = (%v, %v), want (%d, nil)", got, err, relay)
+	}
+
+	if err := RegisterTarget(201, "proxy", ""); err == nil {
+		t.Error("RegisterTarget with duplicate name succeeded")
+	}
+	if err := RegisterTarget(TargetProxy, "VPN", ""); err == nil {
+		t.Error("renaming a built-in target succeeded")
+	}
+	if err := RegisterTarget(TargetThrottle, "SLOW", ""); err == nil {
+		t.Error("renaming THROTTLE succeeded")
+	}
+	if err := RegisterTarget(relay, "", ""); err == nil {
+		t.Error("RegisterTarget with empty name succeeded")
+	}
+
+	all := AllTargets()
+	if len(all) != 7 {
+		t.Fatalf("AllTargets() returned %d targets, want 7", len(all))
+	}
+	for i, want := range []Target{TargetDirect, TargetProxy, TargetReject, TargetThrottle, TargetRejectDrop, TargetRejectReset, relay} {
+		if all[i].ID != want {
+			t.Errorf("AllTargets()[%d].ID = %d, want %d", i, all[i].ID, want)
+		}
+	}
+	if all[0].Color == "" || all[0].Description == "" {
+		t.Error("built-in target missing description/color")
+	}
+}
+
+func TestTargetJSON(t *testing.T) {
+	data, err := json.Marshal(map[string]Target{"a": TargetProxy, "b": Target(99)})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"a":"PROXY","b":99}` {
+		t.Errorf("Marshal = %s", data)
+	}
+
+	tests := []struct {
+		input   string
+		want    Target
+		wantErr bool
+	}{
+		{`"PROXY"`, TargetProxy, false},
+		{`"reject"`, TargetReject, false},
+		{`0`, TargetDirect, false},
+		{`99`, Target(99), false},
+		{`"bogus"`, 0, true},
+		{`300`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got Target
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
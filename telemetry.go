@@ -0,0 +1,282 @@
+package k2rule
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// telemetryPostTimeout bounds one Endpoint delivery
+const telemetryPostTimeout = 30 * time.Second
+
+// privateSuffixes are domain suffixes of local networks, never reported by telemetry
+var privateSuffixes = []string{"local", "localhost", "lan", "home", "internal", "intranet", "corp", "home.arpa"}
+
+// TelemetryConfig enables opt-in telemetry about unmatched traffic: a sample of the
+// domains no rule matched (fallback hits) is delivered in periodic batches to OnBatch
+// and/or Endpoint, showing rule maintainers where the rule sets lack coverage.
+//
+// Privacy controls:
+//   - Only domain inputs that fell through to the fallback are sampled; IPs, rule
+//     matches, TmpRules and global mode decisions are never reported.
+//   - Domains are reduced to their registrable domain ("cdn.example.co.uk" →
+//     "example.co.uk") unless FullDomain is set.
+//   - Domains are reported as HMAC-SHA256(Salt, domain) unless Plaintext is set.
+//   - Single-label names, local network suffixes (.local, .lan, .internal, …) and
+//     Exclude suffixes are never reported.
+//   - Each batch holds at most MaxSamples distinct domains; nothing is persisted and
+//     failed deliveries are dropped.
+type TelemetryConfig struct {
+	SampleRate float64  `json:"sample_rate,omitempty"` // Fraction (0..1] of fallback hits sampled (0 = 0.01)
+	Interval   Duration `json:"interval,omitempty"`    // Batch period (0 = 1h)
+	MaxSamples int      `json:"max_samples,omitempty"` // Distinct domains per batch; further domains are dropped (0 = 100)
+	FullDomain bool     `json:"full_domain,omitempty"` // Report full domains instead of registrable domains
+	Plaintext  bool     `json:"plaintext,omitempty"`   // Report domains unhashed
+
+	// Salt keys the domain hash. Use one salt for all installations of a deployment so
+	// maintainers can match reported hashes against candidate domains.
+	Salt string `json:"salt,omitempty"`
+
+	// Exclude lists domain suffixes that are never reported, e.g. company domains
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Endpoint receives each batch as a JSON POST ("" = none)
+	Endpoint     string      `json:"endpoint,omitempty"`
+	EndpointAuth *SourceAuth `json:"endpoint_auth,omitempty"`
+
+	// OnBatch is called with each batch (nil = none). Not serialized.
+	OnBatch func(TelemetryBatch) `json:"-"`
+}
+
+// TelemetryBatch is the sampled unmatched traffic of one period
+type TelemetryBatch struct {
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Hashed  bool              `json:"hashed"`            // Domains are hex HMAC-SHA256 digests
+	Samples []TelemetrySample `json:"samples,omitempty"` // Most samples first
+	Dropped uint64            `json:"dropped,omitempty"` // Samples dropped by MaxSamples
+}
+
+// TelemetrySample is a reported domain and the number of times it was sampled
+type TelemetrySample struct {
+	Domain string `json:"domain"`
+	Count  uint64 `json:"count"`
+}
+
+// validate checks the telemetry configuration
+func (c *TelemetryConfig) validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("Telemetry SampleRate must be between 0 and 1")
+	}
+	if c.Interval < 0 || c.MaxSamples < 0 {
+		return fmt.Errorf("Telemetry Interval and MaxSamples cannot be negative")
+	}
+	if c.Endpoint == "" && c.OnBatch == nil {
+		return fmt.Errorf("Telemetry requires Endpoint or OnBatch")
+	}
+	return nil
+}
+
+// globalTelemetry is the active telemetry collector (nil = disabled)
+var globalTelemetry atomic.Pointer[telemetryCollector]
+
+// telemetryCollector samples fallback hits for the current batch
+type telemetryCollector struct {
+	config   TelemetryConfig
+	client   *http.Client
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	mu      sync.Mutex
+	start   time.Time
+	counts  map[string]uint64
+	dropped uint64
+}
+
+// newTelemetryCollector creates a collector with defaults applied
+func newTelemetryCollector(config TelemetryConfig) *telemetryCollector {
+	if config.SampleRate <= 0 {
+		config.SampleRate = 0.01
+	}
+	if config.Interval <= 0 {
+		config.Interval = Duration(time.Hour)
+	}
+	if config.MaxSamples <= 0 {
+		config.MaxSamples = 100
+	}
+	excludes := make([]string, 0, len(config.Exclude))
+	for _, suffix := range config.Exclude {
+		if suffix = normalizeOverlayDomain(suffix); suffix != "" {
+			excludes = append(excludes, suffix)
+		}
+	}
+	config.Exclude = excludes
+	return &telemetryCollector{
+		config: config,
+		client: &http.Client{Timeout: telemetryPostTimeout},
+		stopCh: make(chan struct{}),
+		start:  time.Now(),
+		counts: make(map[string]uint64),
+	}
+}
+
+// startTelemetry replaces the active collector (config nil = disable)
+func startTelemetry(config *TelemetryConfig) {
+	var c *telemetryCollector
+	if config != nil {
+		c = newTelemetryCollector(*config)
+	}
+	if old := globalTelemetry.Swap(c); old != nil {
+		old.stop()
+	}
+	if c != nil {
+		c.startDelivery()
+	}
+}
+
+// FlushTelemetry ends the current telemetry batch now and delivers it (OnBatch,
+// Endpoint) as a periodic delivery would (ok = false when telemetry is disabled).
+func FlushTelemetry() (batch TelemetryBatch, ok bool) {
+	c := globalTelemetry.Load()
+	if c == nil {
+		return TelemetryBatch{}, false
+	}
+	return c.deliver(), true
+}
+
+// record samples a Match decision
+func (c *telemetryCollector) record(input string, result MatchResult) {
+	if !result.Fallback || result.Unknown || rand.Float64() >= c.config.SampleRate {
+		return
+	}
+	domain, ok := c.reportable(input)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, seen := c.counts[domain]; !seen && len(c.counts) >= c.config.MaxSamples {
+		c.dropped++
+		return
+	}
+	c.counts[domain]++
+}
+
+// reportable returns the reported form of a domain input, or false if it must not be reported
+func (c *telemetryCollector) reportable(input string) (string, bool) {
+	domain := normalizeOverlayDomain(input)
+	if !strings.Contains(domain, ".") || net.ParseIP(domain) != nil || !isValidDomain(domain) {
+		return "", false
+	}
+	for _, suffix := range privateSuffixes {
+		if hasDomainSuffix(domain, suffix) {
+			return "", false
+		}
+	}
+	for _, suffix := range c.config.Exclude {
+		if hasDomainSuffix(domain, suffix) {
+			return "", false
+		}
+	}
+	if !c.config.FullDomain {
+		domain = parentDomain(domain)
+	}
+	if !c.config.Plaintext {
+		mac := hmac.New(sha256.New, []byte(c.config.Salt))
+		mac.Write([]byte(domain))
+		domain = hex.EncodeToString(mac.Sum(nil))
+	}
+	return domain, true
+}
+
+// snapshot builds the current batch and starts a new one
+func (c *telemetryCollector) snapshot(now time.Time) TelemetryBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	batch := TelemetryBatch{Start: c.start, End: now, Hashed: !c.config.Plaintext, Dropped: c.dropped}
+	for _, hc := range topHits(c.counts, len(c.counts), 0) {
+		batch.Samples = append(batch.Samples, TelemetrySample{Domain: hc.Key, Count: hc.Count})
+	}
+	c.start = now
+	c.counts = make(map[string]uint64)
+	c.dropped = 0
+	return batch
+}
+
+// deliver ends the current batch and delivers it
+func (c *telemetryCollector) deliver() TelemetryBatch {
+	batch := c.snapshot(time.Now())
+	if c.config.OnBatch != nil {
+		safeCall("telemetry", func() error {
+			c.config.OnBatch(batch)
+			return nil
+		})
+	}
+	if c.config.Endpoint != "" && len(batch.Samples) > 0 {
+		if err := c.post(batch); err != nil {
+			slog.Warn("failed to deliver telemetry", "error", err)
+		}
+	}
+	return batch
+}
+
+// post sends batch as JSON to the endpoint
+func (c *telemetryCollector) post(batch TelemetryBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry batch: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	if c.config.EndpointAuth != nil {
+		if err := c.config.EndpointAuth.apply(req); err != nil {
+			return err
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("telemetry request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// startDelivery delivers a batch every Interval until stopped
+func (c *telemetryCollector) startDelivery() {
+	sched := newScheduler("telemetry", every(time.Duration(c.config.Interval)), 0, nil, func(bool) error {
+		c.deliver()
+		return nil
+	})
+	safeGo("telemetry", func() { sched.run(c.stopCh) })
+}
+
+// stop ends periodic deliveries
+func (c *telemetryCollector) stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+// hasDomainSuffix reports whether domain is suffix or a subdomain of it
+func hasDomainSuffix(domain, suffix string) bool {
+	return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+}
@@ -0,0 +1,116 @@
+package k2rule
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTelemetry_SamplesFallbackDomains(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	cacheDir := t.TempDir()
+	rulePath := filepath.Join(cacheDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, buildTestPornK2R(t, []string{"blocked.com"}))
+	manager := NewRemoteRuleManager("", cacheDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{}
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	var batches []TelemetryBatch
+	startTelemetry(&TelemetryConfig{
+		SampleRate: 1,
+		MaxSamples: 2,
+		Plaintext:  true,
+		Exclude:    []string{"corp.example"},
+		OnBatch:    func(b TelemetryBatch) { batches = append(batches, b) },
+	})
+
+	Match("a.unknown.com")
+	MatchVerbose("b.unknown.com") // same registrable domain
+	Match("ads.blocked.com")      // rule match: not reported
+	Match("8.8.8.8")              // IP: not reported
+	Match("printer.local")        // local network: not reported
+	Match("intranet")             // single label: not reported
+	Match("git.corp.example")     // excluded
+	Match("x.com:443")            // unknown input: not reported
+	Match("other.org")
+	Match("third.net") // beyond MaxSamples
+
+	batch, ok := FlushTelemetry()
+	if !ok {
+		t.Fatal("FlushTelemetry() disabled")
+	}
+	want := []TelemetrySample{{"unknown.com", 2}, {"other.org", 1}}
+	if fmt.Sprint(batch.Samples) != fmt.Sprint(want) {
+		t.Errorf("Samples = %v, want %v", batch.Samples, want)
+	}
+	if batch.Hashed || batch.Dropped != 1 {
+		t.Errorf("Hashed = %v, Dropped = %d, want false, 1", batch.Hashed, batch.Dropped)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("OnBatch called %d times, want 1", len(batches))
+	}
+
+	// The next batch starts empty
+	if batch, _ := FlushTelemetry(); len(batch.Samples) != 0 || batch.Dropped != 0 {
+		t.Errorf("second batch = %+v, want empty", batch)
+	}
+}
+
+func TestTelemetry_HashedEndpoint(t *testing.T) {
+	var received []TelemetryBatch
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("X-Api-Key")
+		var b TelemetryBatch
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		received = append(received, b)
+	}))
+	defer server.Close()
+
+	c := newTelemetryCollector(TelemetryConfig{
+		SampleRate:   1,
+		FullDomain:   true,
+		Salt:         "deployment-salt",
+		Endpoint:     server.URL,
+		EndpointAuth: &SourceAuth{Headers: map[string]string{"X-Api-Key": "secret"}},
+	})
+	defer c.stop()
+	c.record("cdn.Example.com.", MatchResult{Target: TargetProxy, Fallback: true})
+
+	mac := hmac.New(sha256.New, []byte("deployment-salt"))
+	mac.Write([]byte("cdn.example.com"))
+	wantDomain := hex.EncodeToString(mac.Sum(nil))
+
+	c.deliver()
+	if len(received) != 1 {
+		t.Fatalf("endpoint received %d batches, want 1", len(received))
+	}
+	if got := received[0]; !got.Hashed || len(got.Samples) != 1 || got.Samples[0].Domain != wantDomain {
+		t.Errorf("batch = %+v, want hashed %s", got, wantDomain)
+	}
+	if auth != "secret" {
+		t.Errorf("X-Api-Key = %q, want secret", auth)
+	}
+
+	// Empty batches are not posted
+	c.deliver()
+	if len(received) != 1 {
+		t.Errorf("endpoint received %d batches, want 1", len(received))
+	}
+}
@@ -0,0 +1,134 @@
+package k2rule
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kaitu-io/k2rule/internal/cache"
+)
+
+// tmpRuleShards is the number of shards of the TmpRule store
+const tmpRuleShards = 16
+
+// tmpRuleStore holds the TmpRules (input → Target), sharded by input so concurrent
+// Match calls and SetTmpRule storms do not contend on one lock. TmpRules never expire;
+// Config.Caches[CacheTmpRules] can bound the store, in which case all TmpRules are held
+// in the first shard so the policy evicts only when the total limit is reached. The
+// zero value is an unbounded store.
+type tmpRuleStore struct {
+	shards  [tmpRuleShards]cache.Cache[string, Target]
+	bounded atomic.Bool // All TmpRules are in shards[0]
+	mu      sync.Mutex  // Serializes configure
+}
+
+// TmpRuleCount returns the number of TmpRules set
+func TmpRuleCount() int {
+	return defaultEngine.TmpRuleCount()
+}
+
+// shard returns the shard of input
+func (s *tmpRuleStore) shard(input string) *cache.Cache[string, Target] {
+	if s.bounded.Load() {
+		return &s.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(input))
+	return &s.shards[h.Sum32()%tmpRuleShards]
+}
+
+// load returns the TmpRule of input, counting it as a use
+func (s *tmpRuleStore) load(input string) (Target, bool) {
+	return s.shard(input).Get(input, 0)
+}
+
+// store sets the TmpRule of input
+func (s *tmpRuleStore) store(input string, target Target) {
+	s.shard(input).Put(input, target, cache.NoExpiry)
+}
+
+// delete removes the TmpRule of input
+func (s *tmpRuleStore) delete(input string) {
+	s.shard(input).Delete(input)
+}
+
+// clear removes all TmpRules
+func (s *tmpRuleStore) clear() {
+	for i := range s.shards {
+		s.shards[i].Clear()
+	}
+}
+
+// count returns the number of TmpRules
+func (s *tmpRuleStore) count() int {
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Len()
+	}
+	return n
+}
+
+// snapshot returns a copy of the TmpRules (nil if there are none)
+func (s *tmpRuleStore) snapshot() map[string]Target {
+	var rules map[string]Target
+	for i := range s.shards {
+		s.shards[i].Range(func(input string, target Target) bool {
+			if rules == nil {
+				rules = make(map[string]Target)
+			}
+			rules[input] = target
+			return true
+		})
+	}
+	return rules
+}
+
+// configure sets the eviction policy and the total entry limit (0 = unbounded)
+func (s *tmpRuleStore) configure(policy cache.Policy, maxEntries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bounded := maxEntries > 0
+	for i := range s.shards {
+		if i == 0 && bounded {
+			s.shards[i].Configure(policy, maxEntries)
+		} else {
+			s.shards[i].Configure(policy, 0)
+		}
+	}
+	if s.bounded.Swap(bounded) == bounded {
+		return
+	}
+
+	// Move the TmpRules to their shard in the new layout
+	for i := range s.shards {
+		src := &s.shards[i]
+		var inputs []string
+		src.Range(func(input string, _ Target) bool {
+			inputs = append(inputs, input)
+			return true
+		})
+		for _, input := range inputs {
+			if dst := s.shard(input); dst != src {
+				if target, ok := src.Get(input, 0); ok {
+					dst.Put(input, target, cache.NoExpiry)
+				}
+				src.Delete(input)
+			}
+		}
+	}
+}
+
+// stats returns the counters of all shards combined
+func (s *tmpRuleStore) stats() cache.Stats {
+	var total cache.Stats
+	for i := range s.shards {
+		stats := s.shards[i].Stats()
+		total.Entries += stats.Entries
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+		total.Evictions += stats.Evictions
+		total.Expirations += stats.Expirations
+	}
+	return total
+}
@@ -1,19 +1,44 @@
 package k2rule
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
 // resetGlobalState resets all global state for isolated testing.
 func resetGlobalState() {
-	globalMutex.Lock()
-	globalConfig = nil
-	globalManager = nil
-	globalGeoIPMgr = nil
-	globalPornManager = nil
-	globalMatcher = nil
-	globalMutex.Unlock()
+	defaultEngine.mu.Lock()
+	retireManagers(defaultEngine.installedManagers(), managerSet{})
+	defaultEngine.config = nil
+	defaultEngine.manager = nil
+	defaultEngine.geoIPMgr = nil
+	defaultEngine.pornManager = nil
+	defaultEngine.bundleMgr = nil
+	defaultEngine.manifestMgr = nil
+	defaultEngine.subscriptionMgr = nil
+	defaultEngine.matcher = nil
+	defaultEngine.sources = nil
+	configureCaches(nil)
+	globalRegistrable.enabled.Store(false)
+	globalRegistrable.entries.Clear()
+	configureHookLimits(nil)
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 	ClearTmpRules()
+	startHitStats(nil, "")
+	startTelemetry(nil)
+	startRejectNotifier(nil, 0)
+	ClearMiddleware()
+	ClearExperiments()
+	ClearDomainIPs()
+	globalRDNS.clear()
+	globalNetwork.setProfiles(nil)
+	globalKeywords.Store(nil)
+	globalHosts.loadFile("")
+	ClearHosts()
+	SetNetworkContext(NetworkContext{})
+	ResumeUpdates()
 }
 
 func TestSetTmpRule_Domain(t *testing.T) {
@@ -58,20 +83,20 @@ func TestSetTmpRule_StorageOptimization(t *testing.T) {
 	// Setting TmpRule to TargetDirect should NOT store (same as static result).
 	SetTmpRule("example.com", TargetDirect)
 
-	// Verify it was not stored by checking globalTmpRules directly
-	if _, ok := globalTmpRules.Load("example.com"); ok {
+	// Verify it was not stored by checking defaultEngine.tmpRules directly
+	if _, ok := defaultEngine.tmpRules.load("example.com"); ok {
 		t.Error("SetTmpRule should not store when target matches static rules result")
 	}
 
 	// Setting to a different target should store
 	SetTmpRule("example.com", TargetProxy)
-	if _, ok := globalTmpRules.Load("example.com"); !ok {
+	if _, ok := defaultEngine.tmpRules.load("example.com"); !ok {
 		t.Error("SetTmpRule should store when target differs from static rules result")
 	}
 
 	// Setting back to TargetDirect should delete the stored override
 	SetTmpRule("example.com", TargetDirect)
-	if _, ok := globalTmpRules.Load("example.com"); ok {
+	if _, ok := defaultEngine.tmpRules.load("example.com"); ok {
 		t.Error("SetTmpRule should delete existing override when target matches static rules result")
 	}
 }
@@ -117,12 +142,13 @@ func TestTmpRule_PriorityOverGlobal(t *testing.T) {
 	resetGlobalState()
 
 	// Set up global mode
-	globalMutex.Lock()
-	globalConfig = &Config{
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{
 		IsGlobal:     true,
 		GlobalTarget: TargetProxy,
 	}
-	globalMutex.Unlock()
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
 
 	// Without TmpRule, should return GlobalTarget
 	if target := Match("example.com"); target != TargetProxy {
@@ -166,3 +192,107 @@ func TestTmpRule_LANBypassHigherPriority(t *testing.T) {
 		t.Errorf("Match(::1) with TmpRule = %v, want TargetDirect (LAN bypass)", target)
 	}
 }
+
+func TestImportTmpRules(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	list := `# hotfix list
+Blocked.Example.  # trailing dot and case are normalized
+
+203.0.113.7
+2001:0db8::0001
+`
+	n, err := ImportTmpRules(strings.NewReader(list), TargetReject)
+	if err != nil {
+		t.Fatalf("ImportTmpRules failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ImportTmpRules() = %d, want 3", n)
+	}
+	for _, input := range []string{"blocked.example", "203.0.113.7", "2001:db8::1"} {
+		if got := Match(input); got != TargetReject {
+			t.Errorf("Match(%s) = %v, want REJECT", input, got)
+		}
+	}
+}
+
+func TestImportTmpRules_InvalidEntry(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	_, err := ImportTmpRules(strings.NewReader("ok.example\nnot a domain\n"), TargetReject)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("ImportTmpRules() error = %v, want an error for line 2", err)
+	}
+	if _, ok := defaultEngine.tmpRules.load("ok.example"); ok {
+		t.Error("valid entries were imported despite the invalid one")
+	}
+}
+
+func TestTmpRuleCount(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	SetTmpRule("google.com", TargetProxy)
+	SetTmpRule("8.8.8.8", TargetReject)
+	SetTmpRule("google.com", TargetReject) // replaces, not added
+	if n := TmpRuleCount(); n != 2 {
+		t.Errorf("TmpRuleCount() = %d, want 2", n)
+	}
+	ClearTmpRules()
+	if n := TmpRuleCount(); n != 0 {
+		t.Errorf("TmpRuleCount() after ClearTmpRules = %d, want 0", n)
+	}
+}
+
+func TestTmpRules_Bounded(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	defaultEngine.mu.Lock()
+	configureCaches(map[string]CacheConfig{CacheTmpRules: {Policy: CachePolicyLRU, MaxEntries: 32}})
+	defaultEngine.mu.Unlock()
+
+	SetTmpRule("keep.example", TargetReject)
+	for i := 0; i < 500; i++ {
+		SetTmpRule(fmt.Sprintf("host%d.example", i), TargetProxy)
+		Match("keep.example") // recently used rules are not evicted
+	}
+	if n := TmpRuleCount(); n > 32 {
+		t.Errorf("TmpRuleCount() = %d, want at most 32", n)
+	}
+	if got := Match("keep.example"); got != TargetReject {
+		t.Errorf("Match(keep.example) = %v, want the recently used TmpRule kept", got)
+	}
+
+	var info CacheInfo
+	for _, c := range CacheStats() {
+		if c.Name == CacheTmpRules {
+			info = c
+		}
+	}
+	if info.Policy != CachePolicyLRU || info.MaxEntries != 32 || info.Evictions == 0 || info.Hits == 0 {
+		t.Errorf("tmp_rules CacheInfo = %+v, want LRU with evictions and hits", info)
+	}
+}
+
+func TestTmpRules_BoundedKeepsRulesUnderLimit(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	SetTmpRule("before.example", TargetReject)
+	defaultEngine.mu.Lock()
+	configureCaches(map[string]CacheConfig{CacheTmpRules: {Policy: CachePolicyLRU, MaxEntries: 16}})
+	defaultEngine.mu.Unlock()
+
+	for i := 0; i < 15; i++ {
+		SetTmpRule(fmt.Sprintf("host%d.example", i), TargetProxy)
+	}
+	if n := TmpRuleCount(); n != 16 {
+		t.Errorf("TmpRuleCount() = %d, want all 16 TmpRules kept", n)
+	}
+	if got := Match("before.example"); got != TargetReject {
+		t.Errorf("Match(before.example) = %v, want the TmpRule set before bounding", got)
+	}
+}
@@ -0,0 +1,395 @@
+package k2rule
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kaitu-io/k2rule/internal/statefile"
+	"github.com/kaitu-io/k2rule/keyword"
+)
+
+// CategoryPorn is the category name used by IsPorn for user overlay lookups
+const CategoryPorn = "porn"
+
+// overlayFileName is the file (inside CacheDir) persisting user overlay entries
+const overlayFileName = "overlay.json"
+
+// overlaySchema versions overlay.json: version 1 wraps the map of version 0
+// (category → OverlayEntries) unchanged
+var overlaySchema = statefile.Schema{
+	Name:       "overlay",
+	Migrations: []statefile.Migration{statefile.Identity},
+}
+
+// globalOverlay holds user add/remove entries per category ("block this now" / "unblock this").
+// Consulted before heuristics and databases, so it takes effect without waiting for upstream.
+var globalOverlay = newOverlayStore()
+
+// OverlayEntries are the user overlay entries of one category (persisted form)
+type OverlayEntries struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Keywords []string `json:"keywords,omitempty"` // Set by SetCategoryKeywords
+	Target   *Target  `json:"target,omitempty"`   // Category policy set by SetCategoryTarget
+}
+
+// overlayStore is a persisted, per-category set of user overlay entries.
+// Writes are serialized by mu; reads use an immutable snapshot (lock-free).
+type overlayStore struct {
+	mu       sync.Mutex
+	path     string                     // "" = in-memory only
+	entries  map[string]map[string]bool // category → domain → added (true) / removed (false)
+	keywords map[string][]string        // category → keywords of member domains
+	targets  map[string]Target          // category → target applied by Match
+	snapshot atomic.Pointer[map[string]*domainOverlay]
+	matchers atomic.Pointer[map[string]*keyword.Matcher] // Compiled keywords
+	policies atomic.Pointer[[]categoryPolicy]            // Sorted by category
+}
+
+// categoryPolicy is a category with the target Match applies to its domains
+type categoryPolicy struct {
+	category string
+	target   Target
+}
+
+func newOverlayStore() *overlayStore {
+	s := &overlayStore{entries: make(map[string]map[string]bool), keywords: make(map[string][]string), targets: make(map[string]Target)}
+	s.rebuild()
+	return s
+}
+
+// AddDomain marks domain (and its subdomains) as belonging to category, effective immediately.
+// For CategoryPorn, IsPorn returns true for the domain regardless of heuristics and databases.
+// Entries are persisted in CacheDir (when initialized) and survive restarts.
+func AddDomain(category, domain string) error {
+	return globalOverlay.set(category, domain, true)
+}
+
+// RemoveDomain marks domain (and its subdomains) as NOT belonging to category, overriding
+// heuristics and databases (e.g. to unblock a false positive).
+func RemoveDomain(category, domain string) error {
+	return globalOverlay.set(category, domain, false)
+}
+
+// ResetDomain deletes any user overlay entry for domain in category,
+// restoring the heuristic/database decision.
+func ResetDomain(category, domain string) error {
+	return globalOverlay.reset(category, domain)
+}
+
+// OverlayDomains returns the user overlay entries of a category (sorted).
+func OverlayDomains(category string) (added, removed []string) {
+	globalOverlay.mu.Lock()
+	defer globalOverlay.mu.Unlock()
+
+	for domain, isAdded := range globalOverlay.entries[category] {
+		if isAdded {
+			added = append(added, domain)
+		} else {
+			removed = append(removed, domain)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// SetCategoryTarget makes Match return target for every domain in category, e.g.
+// SetCategoryTarget("gambling", TargetReject). Category membership comes from
+// AddDomain/RemoveDomain; CategoryPorn also uses IsPorn's heuristics and databases.
+// The policy is checked after TmpRules and before global mode and static rules, and
+// is persisted with the overlay entries. If several categories match a domain, the
+// first in name order wins.
+func SetCategoryTarget(category string, target Target) error {
+	return globalOverlay.setTarget(category, target)
+}
+
+// ClearCategoryTarget removes the policy of category (its overlay entries are kept).
+func ClearCategoryTarget(category string) error {
+	return globalOverlay.clearTarget(category)
+}
+
+// CategoryTargets returns the policy of every category with one.
+func CategoryTargets() map[string]Target {
+	globalOverlay.mu.Lock()
+	defer globalOverlay.mu.Unlock()
+
+	out := make(map[string]Target, len(globalOverlay.targets))
+	for category, target := range globalOverlay.targets {
+		out[category] = target
+	}
+	return out
+}
+
+// SetCategoryKeywords makes every domain containing one of keywords (ASCII
+// case-insensitive, e.g. "casino") a member of category, in addition to its
+// AddDomain entries; RemoveDomain entries still exclude domains. For CategoryPorn,
+// IsPorn reports keyword matches before heuristics and databases. The keywords are
+// matched in one pass (keyword.Matcher) and persisted with the overlay entries;
+// nil removes them.
+func SetCategoryKeywords(category string, keywords []string) error {
+	return globalOverlay.setKeywords(category, keywords)
+}
+
+// CategoryKeywords returns the keywords of category (see SetCategoryKeywords)
+func CategoryKeywords(category string) []string {
+	globalOverlay.mu.Lock()
+	defer globalOverlay.mu.Unlock()
+	return append([]string(nil), globalOverlay.keywords[category]...)
+}
+
+// matchCategory returns the policy target of the first category domain belongs to
+// (CategoryPorn membership is decided by isPorn)
+func (s *overlayStore) matchCategory(domain string, isPorn func(string) bool) (string, Target, bool) {
+	for _, p := range *s.policies.Load() {
+		var member bool
+		if p.category == CategoryPorn {
+			member = isPorn(domain)
+		} else {
+			member, _ = s.member(p.category, domain)
+		}
+		if member {
+			return p.category, p.target, true
+		}
+	}
+	return "", 0, false
+}
+
+// export returns all categories with their entries (sorted), in persisted form
+func (s *overlayStore) export() map[string]OverlayEntries {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.persistedLocked()
+}
+
+// persistedLocked converts entries to their persisted form (caller holds mu)
+func (s *overlayStore) persistedLocked() map[string]OverlayEntries {
+	out := make(map[string]OverlayEntries, len(s.entries)+len(s.targets))
+	for category, target := range s.targets {
+		target := target
+		out[category] = OverlayEntries{Target: &target}
+	}
+	for category, keywords := range s.keywords {
+		e := out[category]
+		e.Keywords = append([]string(nil), keywords...)
+		out[category] = e
+	}
+	for category, m := range s.entries {
+		e := out[category]
+		for domain, isAdded := range m {
+			if isAdded {
+				e.Added = append(e.Added, domain)
+			} else {
+				e.Removed = append(e.Removed, domain)
+			}
+		}
+		sort.Strings(e.Added)
+		sort.Strings(e.Removed)
+		out[category] = e
+	}
+	return out
+}
+
+// lookup checks domain against the category overlay (lock-free)
+func (s *overlayStore) lookup(category, domain string) (added bool, found bool) {
+	return (*s.snapshot.Load())[category].lookup(domain)
+}
+
+// member checks domain against the category overlay, then its keywords (lock-free)
+func (s *overlayStore) member(category, domain string) (member bool, found bool) {
+	if added, found := s.lookup(category, domain); found {
+		return added, true
+	}
+	if m := (*s.matchers.Load())[category]; m != nil && m.Contains(domain) {
+		return true, true
+	}
+	return false, false
+}
+
+func (s *overlayStore) set(category, domain string, added bool) error {
+	domain = normalizeOverlayDomain(domain)
+	if category == "" || domain == "" {
+		return fmt.Errorf("category and domain are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries[category] == nil {
+		s.entries[category] = make(map[string]bool)
+	}
+	s.entries[category][domain] = added
+	s.rebuild()
+	return s.save()
+}
+
+func (s *overlayStore) setTarget(category string, target Target) error {
+	if category == "" {
+		return fmt.Errorf("category is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.targets[category] = target
+	s.rebuild()
+	return s.save()
+}
+
+func (s *overlayStore) setKeywords(category string, keywords []string) error {
+	if category == "" {
+		return fmt.Errorf("category is required")
+	}
+	if _, err := keyword.New(keywords); err != nil {
+		return fmt.Errorf("category %s: %w", category, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(keywords) == 0 {
+		delete(s.keywords, category)
+	} else {
+		s.keywords[category] = append([]string(nil), keywords...)
+	}
+	s.rebuild()
+	return s.save()
+}
+
+func (s *overlayStore) clearTarget(category string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.targets[category]; !ok {
+		return nil
+	}
+	delete(s.targets, category)
+	s.rebuild()
+	return s.save()
+}
+
+func (s *overlayStore) reset(category, domain string) error {
+	domain = normalizeOverlayDomain(domain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[category][domain]; !ok {
+		return nil
+	}
+	delete(s.entries[category], domain)
+	if len(s.entries[category]) == 0 {
+		delete(s.entries, category)
+	}
+	s.rebuild()
+	return s.save()
+}
+
+// load replaces the store contents with the overlay file in cacheDir (missing file = empty).
+// Subsequent changes are persisted to the same file, unless readOnly (kept in memory only).
+// A file written by a newer version is loaded best-effort and never overwritten.
+func (s *overlayStore) load(cacheDir string, readOnly bool) error {
+	path := filepath.Join(cacheDir, overlayFileName)
+
+	persisted := make(map[string]OverlayEntries)
+	_, err := overlaySchema.Load(path, &persisted)
+	newer := errors.Is(err, statefile.ErrNewerVersion)
+	if err != nil && !newer {
+		return fmt.Errorf("failed to load overlay: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	if readOnly || newer {
+		s.path = ""
+	}
+	s.entries, s.keywords, s.targets = entriesFromPersisted(persisted)
+	s.rebuild()
+	return nil
+}
+
+// replace swaps all entries for the given ones and persists them
+func (s *overlayStore) replace(entries map[string]OverlayEntries) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries, s.keywords, s.targets = entriesFromPersisted(entries)
+	s.rebuild()
+	return s.save()
+}
+
+// rebuild publishes a new immutable snapshot (caller holds mu)
+func (s *overlayStore) rebuild() {
+	snapshot := make(map[string]*domainOverlay, len(s.entries))
+	for category, m := range s.entries {
+		var added, removed []string
+		for domain, isAdded := range m {
+			if isAdded {
+				added = append(added, domain)
+			} else {
+				removed = append(removed, domain)
+			}
+		}
+		snapshot[category] = newDomainOverlay(added, removed)
+	}
+	s.snapshot.Store(&snapshot)
+
+	matchers := make(map[string]*keyword.Matcher, len(s.keywords))
+	for category, keywords := range s.keywords {
+		if m, err := keyword.New(keywords); err == nil {
+			matchers[category] = m
+		}
+	}
+	s.matchers.Store(&matchers)
+
+	policies := make([]categoryPolicy, 0, len(s.targets))
+	for category, target := range s.targets {
+		policies = append(policies, categoryPolicy{category: category, target: target})
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].category < policies[j].category })
+	s.policies.Store(&policies)
+}
+
+// save writes the overlay file atomically (caller holds mu)
+func (s *overlayStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := overlaySchema.Save(s.path, s.persistedLocked()); err != nil {
+		return fmt.Errorf("failed to save overlay: %w", err)
+	}
+	return nil
+}
+
+// entriesFromPersisted converts persisted entries to the in-memory form (removed wins)
+func entriesFromPersisted(persisted map[string]OverlayEntries) (map[string]map[string]bool, map[string][]string, map[string]Target) {
+	entries := make(map[string]map[string]bool, len(persisted))
+	keywords := make(map[string][]string)
+	targets := make(map[string]Target)
+	for category, e := range persisted {
+		if e.Target != nil {
+			targets[category] = *e.Target
+		}
+		if len(e.Keywords) > 0 {
+			keywords[category] = e.Keywords
+		}
+		if len(e.Added) == 0 && len(e.Removed) == 0 {
+			continue
+		}
+		m := make(map[string]bool, len(e.Added)+len(e.Removed))
+		for _, d := range e.Added {
+			m[normalizeOverlayDomain(d)] = true
+		}
+		for _, d := range e.Removed {
+			m[normalizeOverlayDomain(d)] = false
+		}
+		entries[category] = m
+	}
+	return entries, keywords, targets
+}
@@ -0,0 +1,327 @@
+package k2rule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserOverlay_IsPorn(t *testing.T) {
+	resetGlobalState()
+	defer func() {
+		ResetDomain(CategoryPorn, "pornhub.com")
+		ResetDomain(CategoryPorn, "blocked-now.com")
+	}()
+
+	if IsPorn("blocked-now.com") {
+		t.Fatal("IsPorn(blocked-now.com) = true before AddDomain")
+	}
+
+	if err := AddDomain(CategoryPorn, "blocked-now.com"); err != nil {
+		t.Fatalf("AddDomain failed: %v", err)
+	}
+	if !IsPorn("www.blocked-now.com") {
+		t.Error("IsPorn(www.blocked-now.com) = false after AddDomain")
+	}
+
+	// RemoveDomain overrides the heuristic
+	if err := RemoveDomain(CategoryPorn, "pornhub.com"); err != nil {
+		t.Fatalf("RemoveDomain failed: %v", err)
+	}
+	if IsPorn("pornhub.com") {
+		t.Error("IsPorn(pornhub.com) = true after RemoveDomain")
+	}
+
+	// ResetDomain restores the heuristic decision
+	if err := ResetDomain(CategoryPorn, "pornhub.com"); err != nil {
+		t.Fatalf("ResetDomain failed: %v", err)
+	}
+	if !IsPorn("pornhub.com") {
+		t.Error("IsPorn(pornhub.com) = false after ResetDomain")
+	}
+}
+
+func TestUserOverlay_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newOverlayStore()
+	if err := s.load(dir, false); err != nil {
+		t.Fatalf("load (empty) failed: %v", err)
+	}
+	if err := s.set("gambling", "Casino.example", true); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := s.set("gambling", "lottery.example", false); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	// A fresh store loading the same CacheDir sees the entries
+	reloaded := newOverlayStore()
+	if err := reloaded.load(dir, false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if added, found := reloaded.lookup("gambling", "www.casino.example"); !found || !added {
+		t.Errorf("lookup(casino) = (%v, %v), want (true, true)", added, found)
+	}
+	if added, found := reloaded.lookup("gambling", "lottery.example"); !found || added {
+		t.Errorf("lookup(lottery) = (%v, %v), want (false, true)", added, found)
+	}
+	if _, found := reloaded.lookup(CategoryPorn, "casino.example"); found {
+		t.Error("entries leaked into another category")
+	}
+}
+
+func TestUserOverlay_Validation(t *testing.T) {
+	if err := AddDomain("", "example.com"); err == nil {
+		t.Error("AddDomain with empty category succeeded")
+	}
+	if err := AddDomain(CategoryPorn, " . "); err == nil {
+		t.Error("AddDomain with empty domain succeeded")
+	}
+}
+
+func TestCategoryTarget_Match(t *testing.T) {
+	resetGlobalState()
+	defer globalOverlay.replace(nil)
+
+	if err := AddDomain("gambling", "casino.example"); err != nil {
+		t.Fatalf("AddDomain failed: %v", err)
+	}
+	if got := Match("www.casino.example"); got != TargetDirect {
+		t.Errorf("Match before SetCategoryTarget = %v, want DIRECT", got)
+	}
+
+	if err := SetCategoryTarget("gambling", TargetReject); err != nil {
+		t.Fatalf("SetCategoryTarget failed: %v", err)
+	}
+	result := MatchVerbose("www.casino.example")
+	if result.Target != TargetReject || result.Category != "gambling" || result.Fallback {
+		t.Errorf("MatchVerbose = %+v, want REJECT by gambling", result)
+	}
+	if got := Match("other.example"); got != TargetDirect {
+		t.Errorf("Match(other.example) = %v, want DIRECT", got)
+	}
+
+	// TmpRule takes precedence over category policy
+	SetTmpRule("www.casino.example", TargetProxy)
+	if got := Match("www.casino.example"); got != TargetProxy {
+		t.Errorf("Match with TmpRule = %v, want PROXY", got)
+	}
+	ClearTmpRules()
+
+	// Category policy takes precedence over global mode
+	defaultEngine.mu.Lock()
+	defaultEngine.config = &Config{IsGlobal: true, GlobalTarget: TargetProxy}
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+	if got := Match("casino.example"); got != TargetReject {
+		t.Errorf("Match in global mode = %v, want REJECT", got)
+	}
+	resetGlobalState()
+
+	if err := ClearCategoryTarget("gambling"); err != nil {
+		t.Fatalf("ClearCategoryTarget failed: %v", err)
+	}
+	if got := Match("casino.example"); got != TargetDirect {
+		t.Errorf("Match after ClearCategoryTarget = %v, want DIRECT", got)
+	}
+}
+
+func TestCategoryTarget_Porn(t *testing.T) {
+	resetGlobalState()
+	defer globalOverlay.replace(nil)
+
+	if err := SetCategoryTarget(CategoryPorn, TargetReject); err != nil {
+		t.Fatalf("SetCategoryTarget failed: %v", err)
+	}
+	// Membership uses IsPorn (heuristic here)
+	if got := Match("pornhub.com"); got != TargetReject {
+		t.Errorf("Match(pornhub.com) = %v, want REJECT", got)
+	}
+	if err := RemoveDomain(CategoryPorn, "pornhub.com"); err != nil {
+		t.Fatalf("RemoveDomain failed: %v", err)
+	}
+	if got := Match("pornhub.com"); got != TargetDirect {
+		t.Errorf("Match(pornhub.com) after RemoveDomain = %v, want DIRECT", got)
+	}
+}
+
+func TestCategoryTarget_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newOverlayStore()
+	if err := s.load(dir, false); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if err := s.setTarget("ads", TargetReject); err != nil {
+		t.Fatalf("setTarget failed: %v", err)
+	}
+	if err := s.setTarget("gambling", TargetProxy); err != nil {
+		t.Fatalf("setTarget failed: %v", err)
+	}
+	if err := s.set("gambling", "casino.example", true); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	reloaded := newOverlayStore()
+	if err := reloaded.load(dir, false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := reloaded.targets; len(got) != 2 || got["ads"] != TargetReject || got["gambling"] != TargetProxy {
+		t.Errorf("reloaded targets = %v", got)
+	}
+	if category, target, ok := reloaded.matchCategory("casino.example", defaultEngine.isPorn); !ok || category != "gambling" || target != TargetProxy {
+		t.Errorf("matchCategory = (%q, %v, %v), want (gambling, PROXY, true)", category, target, ok)
+	}
+
+	if err := reloaded.clearTarget("ads"); err != nil {
+		t.Fatalf("clearTarget failed: %v", err)
+	}
+	if _, ok := reloaded.export()["ads"]; ok {
+		t.Error("export still contains ads after clearTarget")
+	}
+}
+
+func TestUserOverlay_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newOverlayStore()
+	if err := s.load(dir, true); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if err := s.set("gambling", "casino.example", true); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if added, found := s.lookup("gambling", "casino.example"); !found || !added {
+		t.Error("read-only overlay entry not applied in memory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, overlayFileName)); !os.IsNotExist(err) {
+		t.Errorf("overlay file written to read-only cache (stat err = %v)", err)
+	}
+}
+
+func TestUserOverlay_LegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, overlayFileName)
+	legacy := []byte(`{"porn": {"added": ["legacy.example"]}}`)
+	if err := os.WriteFile(path, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newOverlayStore()
+	if err := s.load(dir, false); err != nil {
+		t.Fatalf("load (legacy) failed: %v", err)
+	}
+	if added, found := s.lookup(CategoryPorn, "legacy.example"); !found || !added {
+		t.Error("legacy overlay entry not loaded")
+	}
+	if err := s.set(CategoryPorn, "new.example", true); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if bak, err := os.ReadFile(path + ".v0.bak"); err != nil || string(bak) != string(legacy) {
+		t.Errorf("legacy backup = %s, %v", bak, err)
+	}
+
+	reloaded := newOverlayStore()
+	if err := reloaded.load(dir, false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	for _, domain := range []string{"legacy.example", "new.example"} {
+		if added, found := reloaded.lookup(CategoryPorn, domain); !found || !added {
+			t.Errorf("%s missing after migration", domain)
+		}
+	}
+}
+
+func TestUserOverlay_NewerFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, overlayFileName)
+	newer := []byte(`{"schema": "overlay", "version": 99, "data": {"porn": {"added": ["future.example"], "schedule": "22:00"}}}`)
+	if err := os.WriteFile(path, newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newOverlayStore()
+	if err := s.load(dir, false); err != nil {
+		t.Fatalf("load (newer) failed: %v", err)
+	}
+	if added, found := s.lookup(CategoryPorn, "future.example"); !found || !added {
+		t.Error("newer overlay entry not loaded best-effort")
+	}
+	if err := s.set(CategoryPorn, "other.example", true); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != string(newer) {
+		t.Errorf("overlay of a newer version was overwritten: %s", raw)
+	}
+}
+
+func TestCategoryKeywords(t *testing.T) {
+	resetGlobalState()
+	defer globalOverlay.replace(nil)
+
+	if err := SetCategoryKeywords("gambling", []string{"casino", "lottery"}); err != nil {
+		t.Fatalf("SetCategoryKeywords failed: %v", err)
+	}
+	if err := SetCategoryTarget("gambling", TargetReject); err != nil {
+		t.Fatalf("SetCategoryTarget failed: %v", err)
+	}
+	if result := MatchVerbose("Big-Casino.example"); result.Target != TargetReject || result.Category != "gambling" {
+		t.Errorf("MatchVerbose(Big-Casino.example) = %+v, want REJECT by gambling", result)
+	}
+
+	// RemoveDomain excludes a keyword member
+	if err := RemoveDomain("gambling", "casinoroyale-movie.example"); err != nil {
+		t.Fatalf("RemoveDomain failed: %v", err)
+	}
+	if got := Match("casinoroyale-movie.example"); got != TargetDirect {
+		t.Errorf("Match after RemoveDomain = %s, want DIRECT", got)
+	}
+
+	// CategoryPorn keywords extend IsPorn
+	if err := SetCategoryKeywords(CategoryPorn, []string{"onlyfriends"}); err != nil {
+		t.Fatalf("SetCategoryKeywords(porn) failed: %v", err)
+	}
+	if !IsPorn("onlyfriends.example") {
+		t.Error("IsPorn(onlyfriends.example) = false with a porn keyword")
+	}
+
+	if got := CategoryKeywords("gambling"); len(got) != 2 {
+		t.Errorf("CategoryKeywords(gambling) = %v", got)
+	}
+	if err := SetCategoryKeywords("gambling", []string{""}); err == nil {
+		t.Error("SetCategoryKeywords accepted an empty keyword")
+	}
+	if err := SetCategoryKeywords("gambling", nil); err != nil {
+		t.Fatalf("SetCategoryKeywords(nil) failed: %v", err)
+	}
+	if got := Match("big-casino.example"); got != TargetDirect {
+		t.Errorf("Match after clearing keywords = %s, want DIRECT", got)
+	}
+}
+
+func TestCategoryKeywords_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newOverlayStore()
+	if err := s.load(dir, false); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if err := s.setKeywords("gambling", []string{"casino"}); err != nil {
+		t.Fatalf("setKeywords failed: %v", err)
+	}
+	if err := s.setTarget("gambling", TargetProxy); err != nil {
+		t.Fatalf("setTarget failed: %v", err)
+	}
+
+	reloaded := newOverlayStore()
+	if err := reloaded.load(dir, false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if category, target, ok := reloaded.matchCategory("www.casino.example", defaultEngine.isPorn); !ok || category != "gambling" || target != TargetProxy {
+		t.Errorf("matchCategory = (%q, %v, %v), want (gambling, PROXY, true)", category, target, ok)
+	}
+	if got := reloaded.export()["gambling"].Keywords; len(got) != 1 || got[0] != "casino" {
+		t.Errorf("exported keywords = %v, want [casino]", got)
+	}
+}
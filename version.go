@@ -0,0 +1,109 @@
+package k2rule
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+// LibraryVersion is the version of this library, sent in the download User-Agent
+const LibraryVersion = "1.0.0"
+
+// modulePath is the import path of this module, used to find it in build info
+const modulePath = "github.com/kaitu-io/k2rule"
+
+// DefaultUserAgent returns the User-Agent sent with downloads when Config.UserAgent
+// is empty, e.g. "k2rule/1.0.0 (linux/amd64)"
+func DefaultUserAgent() string {
+	return "k2rule/" + LibraryVersion + " (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+}
+
+// Version returns the library version (LibraryVersion), e.g. "1.0.0"
+func Version() string {
+	return LibraryVersion
+}
+
+// FormatVersionSupported reports whether this library reads K2RULEV3 files whose
+// header declares the given format version
+func FormatVersionSupported(version uint32) bool {
+	return version <= slice.FormatVersion
+}
+
+// CapabilityInfo describes the library build and the rule data format features it
+// supports, plus those of the currently loaded rule file
+type CapabilityInfo struct {
+	LibraryVersion string `json:"library_version"`          // LibraryVersion
+	ModuleVersion  string `json:"module_version,omitempty"` // Go module version from build info, e.g. "v1.2.3" ("" if unavailable)
+	GoVersion      string `json:"go_version"`               // Go toolchain version, e.g. "go1.22.1"
+	Platform       string `json:"platform"`                 // GOOS/GOARCH
+
+	Format           string   `json:"format"`             // Rule file magic ("K2RULEV3")
+	MaxFormatVersion uint32   `json:"max_format_version"` // Highest header format version readable
+	RequiredFeatures []string `json:"required_features"`  // Required header features readable
+	OptionalFeatures []string `json:"optional_features"`  // Optional header features understood
+	SliceTypes       []string `json:"slice_types"`        // Slice types understood
+	ExportFormats    []string `json:"export_formats"`     // ExportDomains formats and "mmdb" (ExportMMDB)
+
+	LoadedFormatVersion uint32   `json:"loaded_format_version,omitempty"` // Format version of the loaded rule file (0 if none)
+	LoadedFeatures      []string `json:"loaded_features,omitempty"`       // Features declared by the loaded rule file
+}
+
+// Capabilities reports the library version and data format capabilities, so hosting
+// applications can log them at startup and gate behavior on what's active.
+//
+// Example:
+//
+//	caps := k2rule.Capabilities()
+//	log.Printf("k2rule %s, format v%d, features %v", caps.LibraryVersion, caps.MaxFormatVersion, caps.LoadedFeatures)
+func Capabilities() CapabilityInfo {
+	info := CapabilityInfo{
+		LibraryVersion:   LibraryVersion,
+		ModuleVersion:    moduleVersion(),
+		GoVersion:        runtime.Version(),
+		Platform:         runtime.GOOS + "/" + runtime.GOARCH,
+		Format:           slice.Magic,
+		MaxFormatVersion: slice.FormatVersion,
+		RequiredFeatures: slice.SupportedFeatures.Names(),
+		OptionalFeatures: (slice.KnownFeatures &^ slice.SupportedFeatures).Names(),
+		ExportFormats:    []string{string(FormatText), string(FormatClashDomain), string(FormatClashClassical), "mmdb"},
+	}
+	for t := slice.SliceType(1); t.Known(); t++ {
+		info.SliceTypes = append(info.SliceTypes, t.String())
+	}
+
+	defaultEngine.mu.RLock()
+	manager := defaultEngine.manager
+	defaultEngine.mu.RUnlock()
+	if manager != nil {
+		if reader := manager.reader.Get(); reader != nil {
+			info.LoadedFormatVersion = reader.Version()
+			info.LoadedFeatures = reader.Features().Names()
+		}
+	}
+	return info
+}
+
+// moduleVersion returns the version of this module recorded in the binary's build
+// info, or "" (e.g. in tests and development builds)
+func moduleVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if bi.Main.Path == modulePath {
+		if bi.Main.Version == "(devel)" {
+			return ""
+		}
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package k2rule
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kaitu-io/k2rule/internal/slice"
+)
+
+func TestVersionAndCapabilities(t *testing.T) {
+	resetGlobalState()
+	defer resetGlobalState()
+
+	if Version() != LibraryVersion {
+		t.Errorf("Version() = %q, want %q", Version(), LibraryVersion)
+	}
+	for v, want := range map[uint32]bool{1: true, slice.FormatVersion: true, slice.FormatVersion + 1: false} {
+		if got := FormatVersionSupported(v); got != want {
+			t.Errorf("FormatVersionSupported(%d) = %v, want %v", v, got, want)
+		}
+	}
+
+	caps := Capabilities()
+	if caps.Format != "K2RULEV3" || caps.MaxFormatVersion != slice.FormatVersion || caps.LoadedFormatVersion != 0 {
+		t.Errorf("Capabilities() = %+v", caps)
+	}
+	if len(caps.RequiredFeatures) != 1 || caps.RequiredFeatures[0] != "CompressedSlices" {
+		t.Errorf("RequiredFeatures = %v, want [CompressedSlices]", caps.RequiredFeatures)
+	}
+	if n := len(caps.SliceTypes); n == 0 || caps.SliceTypes[n-1] != slice.SliceTypeDNSHints.String() {
+		t.Errorf("SliceTypes = %v, want all known types", caps.SliceTypes)
+	}
+
+	w := slice.NewSliceWriter(uint8(TargetDirect))
+	w.AddDomainSlice([]string{"example.com"}, uint8(TargetProxy))
+	w.SetFeatures(0, slice.FeatureTargetNames)
+	data, err := w.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	tmpDir := t.TempDir()
+	rulePath := filepath.Join(tmpDir, "rules.k2r.gz")
+	writeTestK2RGzipFile(t, rulePath, data)
+	manager := NewRemoteRuleManager("", tmpDir, TargetDirect)
+	if err := manager.reader.Load(rulePath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaultEngine.mu.Lock()
+	defaultEngine.manager = manager
+	defaultEngine.publishState()
+	defaultEngine.mu.Unlock()
+
+	caps = Capabilities()
+	if caps.LoadedFormatVersion != 2 || len(caps.LoadedFeatures) != 1 || caps.LoadedFeatures[0] != "TargetNames" {
+		t.Errorf("loaded format = v%d %v, want v2 [TargetNames]", caps.LoadedFormatVersion, caps.LoadedFeatures)
+	}
+}